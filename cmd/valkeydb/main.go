@@ -1,20 +1,72 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/william1nguyen/valkeydb/internal/backup"
+	"github.com/william1nguyen/valkeydb/internal/bigkeys"
+	"github.com/william1nguyen/valkeydb/internal/command"
 	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/jsonl"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/sentinel"
 	"github.com/william1nguyen/valkeydb/internal/server"
 )
 
 var (
-	configFile = "config.yaml"
+	configFile   = flag.String("config", "config.yaml", "path to the configuration file")
+	addr         = flag.String("addr", "", "listen address, overriding server.addr")
+	replicaOf    = flag.String("replicaof", "", "follow the master at \"host port\", overriding replication.replicaof")
+	sentinelMode = flag.Bool("sentinel", false, "run as a sentinel monitoring the masters in the sentinel section")
+	bigKeys      = flag.Bool("bigkeys", false, "report the longest key of each type on the server at --addr and exit")
+	memKeys      = flag.Bool("memkeys", false, "report the key of each type using the most memory on the server at --addr and exit")
+	memSamples   = flag.Int("memkeys-samples", 5, "elements MEMORY USAGE measures per key with --memkeys, 0 for all")
+	interval     = flag.Duration("interval", 0, "pause between batches of 100 keys with --bigkeys and --memkeys")
 )
 
 func main() {
-	if err := config.Load(configFile); err != nil {
+	flag.Parse()
+
+	if flag.Arg(0) == "check-aof" {
+		os.Exit(runCheckAOF(flag.Args()[1:]))
+	}
+
+	if err := config.Load(*configFile); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if *addr != "" {
+		config.Global.Server.Addr = *addr
+	}
+	if *replicaOf != "" {
+		config.Global.Replication.ReplicaOf = *replicaOf
+	}
+
+	switch flag.Arg(0) {
+	case "restore":
+		os.Exit(runRestore(flag.Args()[1:]))
+	case "export":
+		os.Exit(runExport(flag.Args()[1:]))
+	case "import":
+		os.Exit(runImport(flag.Args()[1:]))
+	}
+
+	if *bigKeys || *memKeys {
+		runBigKeys()
+		return
+	}
+
+	if *sentinelMode {
+		runSentinel()
+		return
+	}
 
 	s := server.New(config.Global.Server.Addr)
 	log.Printf("Starting ValkeyDB on %s", config.Global.Server.Addr)
@@ -22,3 +74,278 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func runSentinel() {
+	cfg := sentinel.Config{
+		Addr:  config.Global.Server.Addr,
+		Peers: config.Global.Sentinel.Peers,
+	}
+	for _, m := range config.Global.Sentinel.Masters {
+		cfg.Masters = append(cfg.Masters, sentinel.MasterConfig{
+			Name:            m.Name,
+			Addr:            m.Addr,
+			Quorum:          m.Quorum,
+			DownAfter:       time.Duration(m.DownAfterMs) * time.Millisecond,
+			FailoverTimeout: time.Duration(m.FailoverTimeoutMs) * time.Millisecond,
+			Auth:            m.Auth,
+		})
+	}
+	if len(cfg.Masters) == 0 {
+		log.Fatal("sentinel mode needs at least one entry in sentinel.masters")
+	}
+
+	log.Printf("Starting ValkeyDB sentinel on %s", cfg.Addr)
+	if err := sentinel.New(cfg).ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runBigKeys() {
+	report, err := bigkeys.Scan(config.Global.Server.Addr, bigkeys.Options{
+		Memory:   *memKeys,
+		Samples:  *memSamples,
+		Interval: *interval,
+		Auth:     config.Global.GetAuth(),
+	})
+	if err != nil {
+		log.Fatalf("Failed to scan keys: %v", err)
+	}
+	if _, err := report.WriteTo(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runCheckAOF(args []string) int {
+	fs := flag.NewFlagSet("check-aof", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "truncate the file at its first bad record")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: valkeydb check-aof [--fix] <file.aof|file.manifest>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	checks, err := persistence.CheckAOF(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, c := range checks {
+		if strings.HasSuffix(c.File, ".rdb") {
+			if c.Err != nil {
+				fmt.Printf("%s: %v\n", c.File, c.Err)
+				status = 1
+			} else {
+				fmt.Printf("%s: OK, RDB base of %d bytes\n", c.File, c.Size)
+			}
+			continue
+		}
+		if c.Err == nil {
+			fmt.Printf("%s: OK, %d commands in %d bytes\n", c.File, c.Commands, c.Size)
+			continue
+		}
+		what := "bad record"
+		if c.Truncated {
+			what = "truncated command"
+		}
+		fmt.Printf("%s: %s at offset %d: %v\n", c.File, what, c.Valid, c.Err)
+		fmt.Printf("%s: %d commands in the first %d of %d bytes are good\n", c.File, c.Commands, c.Valid, c.Size)
+		if !*fix {
+			status = 1
+			continue
+		}
+		if err := persistence.FixAOF(c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: truncated to %d bytes, dropping %d\n", c.File, c.Valid, c.Size-c.Valid)
+	}
+	return status
+}
+
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	at := fs.String("at", "", "time to restore to, RFC 3339 or Unix seconds; the latest state when empty")
+	dir := fs.String("dir", config.Global.GetBackupDir(), "backup directory")
+	out := fs.String("out", config.Global.Persistence.RDB.Filename, "RDB file to write; it must not exist")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: valkeydb restore [--at <timestamp>] [--dir <dir>] [--out <file.rdb>]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *out == "" {
+		fs.Usage()
+		return 2
+	}
+	when, err := parseRestoreTime(*at)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if _, err := os.Stat(*out); err == nil {
+		fmt.Fprintf(os.Stderr, "%s already exists; move it away or pick another --out\n", *out)
+		return 1
+	}
+
+	aof, _ := persistence.OpenMultiPartAOF("", "", false, false)
+	db := &command.DB{
+		Dict:   datastructure.CreateDict(),
+		Set:    datastructure.CreateSet(),
+		List:   datastructure.CreateList(),
+		Hash:   datastructure.CreateHashMap(),
+		ZSet:   datastructure.CreateZSet(),
+		JSON:   datastructure.CreateJSONStore(),
+		TS:     datastructure.CreateTimeSeries(),
+		Sketch: datastructure.CreateSketches(),
+		Pubsub: datastructure.CreatePubsub(),
+		AOF:    aof,
+	}
+	command.Init(db)
+	used, replayed, err := backup.Restore(*dir, when, db.Restore, command.Replay)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = persistence.SaveRDB(*out, func(emit func(persistence.Snapshot) error) error {
+		return emit(db.Snapshot())
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("restored %s as of %s from %s and %d commands after it\n", *out, when.UTC().Format(time.RFC3339), used.Name, replayed)
+	noteRDBLoad(*out)
+	return 0
+}
+
+func noteRDBLoad(path string) {
+	if config.Global.Persistence.AOF.Enabled {
+		fmt.Printf("the server only loads %s without an AOF; move %s away before starting it\n", path, config.Global.GetAOFDir())
+	}
+}
+
+func parseRestoreTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --at %q: want RFC 3339 or Unix seconds", s)
+	}
+	return t, nil
+}
+
+const importBatch = 1000
+
+func jsonlFilter(match, types string) (jsonl.Filter, error) {
+	filter := jsonl.Filter{Match: match}
+	if types == "" {
+		return filter, nil
+	}
+	for _, t := range strings.Split(types, ",") {
+		if !jsonl.ValidType(t) {
+			return filter, fmt.Errorf("unknown type %q", t)
+		}
+		filter.Types = append(filter.Types, t)
+	}
+	return filter, nil
+}
+
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	rdb := fs.String("rdb", config.Global.Persistence.RDB.Filename, "RDB file to export")
+	out := fs.String("out", "", "file to write; standard output when empty")
+	match := fs.String("match", "", "export only keys matching this glob")
+	types := fs.String("type", "", "export only these comma-separated types: string, set, list, hash, zset, json, timeseries, sketch")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: valkeydb export [--rdb <file.rdb>] [--match <pattern>] [--type <types>] [--out <file.jsonl>]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	filter, err := jsonlFilter(*match, *types)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = bufio.NewWriter(f)
+	}
+	writer := jsonl.NewWriter(w, filter, time.Now())
+	if err := persistence.ScanRDB(*rdb, writer.WritePart); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d keys from %s\n", writer.Count(), *rdb)
+	return 0
+}
+
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	out := fs.String("out", config.Global.Persistence.RDB.Filename, "RDB file to write; it must not exist")
+	match := fs.String("match", "", "import only keys matching this glob")
+	types := fs.String("type", "", "import only these comma-separated types")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: valkeydb import [--match <pattern>] [--type <types>] [--out <file.rdb>] [file.jsonl]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 || *out == "" {
+		fs.Usage()
+		return 2
+	}
+	filter, err := jsonlFilter(*match, *types)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if _, err := os.Stat(*out); err == nil {
+		fmt.Fprintf(os.Stderr, "%s already exists; move it away or pick another --out\n", *out)
+		return 1
+	}
+
+	in := os.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		if in, err = os.Open(name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer in.Close()
+	}
+	var count int
+	err = persistence.SaveRDB(*out, func(emit func(persistence.Snapshot) error) error {
+		var err error
+		count, err = jsonl.Import(bufio.NewReader(in), filter, time.Now(), importBatch, emit)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("imported %d keys into %s\n", count, *out)
+	noteRDBLoad(*out)
+	return 0
+}
@@ -0,0 +1,567 @@
+// Package acl keeps the users of the server and what each may run, in the
+// form of Redis 6 access control lists.
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUser is the user one-argument AUTH and new connections use.
+const DefaultUser = "default"
+
+// Categories are the names usable in +@category and -@category rules.
+var Categories = []string{
+	"all", "read", "write", "keyspace", "string", "bitmap", "list", "set", "sortedset", "hash",
+	"geo", "json", "timeseries", "bloom", "cuckoo", "cms", "topk",
+	"pubsub", "connection", "admin", "dangerous",
+}
+
+const logMaxLen = 128
+
+const logGroupWindow = time.Minute
+
+// User is one ACL user. Keys and Channels are glob patterns; the last
+// Commands rule matching a command decides.
+type User struct {
+	Name    string
+	Enabled bool
+	NoPass  bool
+	// Passwords are SHA-256 hex digests.
+	Passwords []string
+	Commands  []string
+	Keys      []string
+	Channels  []string
+}
+
+// Request is what a command needs to be allowed.
+type Request struct {
+	// Command and Subcommand are lower case; Subcommand is the first
+	// argument, for command|subcommand rules.
+	Command    string
+	Subcommand string
+	Categories []string
+	Keys       []string
+	Channels   []string
+}
+
+// Denied says why a user may not run a command. Reason is "command", "key",
+// "channel" or "auth", as in ACL LOG.
+type Denied struct {
+	Reason string
+	Object string
+	User   string
+}
+
+func (d *Denied) Error() string {
+	switch d.Reason {
+	case "key":
+		return "NOPERM No permissions to access a key"
+	case "channel":
+		return "NOPERM No permissions to access a channel"
+	}
+	return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", d.User, d.Object)
+}
+
+// LogEntry is one group of denials in ACL LOG.
+type LogEntry struct {
+	ID       int64
+	Count    int
+	Reason   string
+	Object   string
+	Username string
+	Client   string
+	Created  time.Time
+	Updated  time.Time
+}
+
+// Users holds every user and the log of denied attempts.
+type Users struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	// password is the default user's when no ACL file defines it.
+	password string
+	// known reports whether a command exists, for rules naming one.
+	known  func(cmd string) bool
+	log    []*LogEntry
+	nextID int64
+}
+
+// New returns the default user alone, with password or none when it is empty.
+func New(password string, known func(cmd string) bool) *Users {
+	u := &Users{password: password, known: known}
+	u.users = map[string]*User{DefaultUser: u.defaultUser()}
+	return u
+}
+
+func (u *Users) defaultUser() *User {
+	user := &User{
+		Name:     DefaultUser,
+		Enabled:  true,
+		NoPass:   u.password == "",
+		Commands: []string{"+@all"},
+		Keys:     []string{"*"},
+		Channels: []string{"*"},
+	}
+	if u.password != "" {
+		user.Passwords = []string{HashPassword(u.password)}
+	}
+	return user
+}
+
+func newUser(name string) *User {
+	return &User{Name: name, Commands: []string{"-@all"}}
+}
+
+// HashPassword returns the SHA-256 hex digest ACLs store for password.
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (user *User) clone() *User {
+	c := *user
+	c.Passwords = slices.Clone(user.Passwords)
+	c.Commands = slices.Clone(user.Commands)
+	c.Keys = slices.Clone(user.Keys)
+	c.Channels = slices.Clone(user.Channels)
+	return &c
+}
+
+// Authenticate reports whether name may log in with password. A failure is
+// logged with client.
+func (u *Users) Authenticate(name, password, client string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.users[name]
+	if ok && user.Enabled && (user.NoPass || slices.Contains(user.Passwords, HashPassword(password))) {
+		return true
+	}
+	u.record(&Denied{Reason: "auth", Object: "AUTH", User: name}, client)
+	return false
+}
+
+// NoPass reports whether name is enabled and needs no password, so new
+// connections start logged in as the default user when it does.
+func (u *Users) NoPass(name string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user, ok := u.users[name]
+	return ok && user.Enabled && user.NoPass
+}
+
+// Exists reports whether name is a user.
+func (u *Users) Exists(name string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	_, ok := u.users[name]
+	return ok
+}
+
+// Get returns a copy of name.
+func (u *Users) Get(name string) (*User, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	user, ok := u.users[name]
+	if !ok {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+// Names returns every user name, sorted.
+func (u *Users) Names() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	names := make([]string, 0, len(u.users))
+	for name := range u.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns every user as an ACL file line, sorted by name.
+func (u *Users) List() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	lines := make([]string, 0, len(u.users))
+	for _, user := range u.users {
+		lines = append(lines, user.String())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// SetUser creates name if needed and applies rules to it in order. Either
+// every rule applies or, on an error, none.
+func (u *Users) SetUser(name string, rules ...string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid user name '%s'", name)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user := newUser(name)
+	if old, ok := u.users[name]; ok {
+		user = old.clone()
+	}
+	for _, rule := range rules {
+		if err := user.apply(rule, u.known); err != nil {
+			return err
+		}
+	}
+	u.users[name] = user
+	return nil
+}
+
+// DelUser deletes names and returns how many existed. The default user
+// cannot be deleted.
+func (u *Users) DelUser(names ...string) (int, error) {
+	if slices.Contains(names, DefaultUser) {
+		return 0, errors.New("The 'default' user cannot be removed")
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := 0
+	for _, name := range names {
+		if _, ok := u.users[name]; ok {
+			delete(u.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Check returns why name may not run r, or nil when it may. Denials are
+// logged with client.
+func (u *Users) Check(name, client string, r Request) *Denied {
+	u.mu.RLock()
+	user, ok := u.users[name]
+	var denied *Denied
+	if !ok {
+		denied = &Denied{Reason: "command", Object: r.Command, User: name}
+	} else {
+		denied = user.check(r)
+	}
+	u.mu.RUnlock()
+	if denied != nil {
+		u.mu.Lock()
+		u.record(denied, client)
+		u.mu.Unlock()
+	}
+	return denied
+}
+
+func (user *User) check(r Request) *Denied {
+	if !user.allows(r) {
+		object := r.Command
+		if r.Subcommand != "" && user.hasSubcommandRule(r.Command) {
+			object += "|" + r.Subcommand
+		}
+		return &Denied{Reason: "command", Object: object, User: user.Name}
+	}
+	for _, key := range r.Keys {
+		if !matchAny(user.Keys, key) {
+			return &Denied{Reason: "key", Object: key, User: user.Name}
+		}
+	}
+	for _, channel := range r.Channels {
+		if !matchAny(user.Channels, channel) {
+			return &Denied{Reason: "channel", Object: channel, User: user.Name}
+		}
+	}
+	return nil
+}
+
+func (user *User) allows(r Request) bool {
+	allowed := false
+	for _, rule := range user.Commands {
+		name := rule[1:]
+		var match bool
+		switch {
+		case strings.HasPrefix(name, "@"):
+			match = name == "@all" || slices.Contains(r.Categories, name[1:])
+		case strings.Contains(name, "|"):
+			match = name == r.Command+"|"+r.Subcommand
+		default:
+			match = name == r.Command
+		}
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+func (user *User) hasSubcommandRule(cmd string) bool {
+	for _, rule := range user.Commands {
+		if strings.HasPrefix(rule[1:], cmd+"|") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (user *User) apply(rule string, known func(string) bool) error {
+	fail := func(reason string) error {
+		return fmt.Errorf("modifier '%s': %s", rule, reason)
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		user.Enabled = true
+	case "off":
+		user.Enabled = false
+	case "nopass":
+		user.NoPass, user.Passwords = true, nil
+	case "resetpass":
+		user.NoPass, user.Passwords = false, nil
+	case "allkeys":
+		user.Keys = []string{"*"}
+	case "resetkeys":
+		user.Keys = nil
+	case "allchannels":
+		user.Channels = []string{"*"}
+	case "resetchannels":
+		user.Channels = nil
+	case "allcommands":
+		user.Commands = []string{"+@all"}
+	case "nocommands":
+		user.Commands = []string{"-@all"}
+	case "reset":
+		*user = *newUser(user.Name)
+	default:
+		if rule == "" {
+			return fail("Syntax error")
+		}
+		switch arg := rule[1:]; rule[0] {
+		case '>':
+			user.addPassword(HashPassword(arg))
+		case '<':
+			if !user.removePassword(HashPassword(arg)) {
+				return fail("no such password")
+			}
+		case '#':
+			if len(arg) != sha256.Size*2 || strings.Trim(arg, "0123456789abcdef") != "" {
+				return fail("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			user.addPassword(arg)
+		case '!':
+			if !user.removePassword(arg) {
+				return fail("no such password")
+			}
+		case '~':
+			if slices.Contains(user.Keys, "*") {
+				return fail("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+			}
+			user.Keys = appendPattern(user.Keys, arg)
+		case '&':
+			if slices.Contains(user.Channels, "*") {
+				return fail("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+			}
+			user.Channels = appendPattern(user.Channels, arg)
+		case '+', '-':
+			return user.addCommandRule(rule[:1], strings.ToLower(arg), known, fail)
+		default:
+			return fail("Syntax error")
+		}
+	}
+	return nil
+}
+
+func (user *User) addPassword(hash string) {
+	user.NoPass = false
+	if !slices.Contains(user.Passwords, hash) {
+		user.Passwords = append(user.Passwords, hash)
+	}
+}
+
+func (user *User) removePassword(hash string) bool {
+	i := slices.Index(user.Passwords, hash)
+	if i < 0 {
+		return false
+	}
+	user.Passwords = slices.Delete(user.Passwords, i, i+1)
+	return true
+}
+
+func appendPattern(patterns []string, p string) []string {
+	if p == "*" {
+		return []string{"*"}
+	}
+	if slices.Contains(patterns, p) {
+		return patterns
+	}
+	return append(patterns, p)
+}
+
+func (user *User) addCommandRule(sign, name string, known func(string) bool, fail func(string) error) error {
+	switch {
+	case strings.HasPrefix(name, "@"):
+		if !slices.Contains(Categories, name[1:]) {
+			return fail("Unknown command or category name in ACL")
+		}
+	case strings.Count(name, "|") > 1 || strings.HasPrefix(name, "|") || strings.HasSuffix(name, "|"):
+		return fail("Unknown command or category name in ACL")
+	default:
+		cmd, _, _ := strings.Cut(name, "|")
+		if known != nil && !known(cmd) {
+			return fail("Unknown command or category name in ACL")
+		}
+	}
+	if name == "@all" {
+		user.Commands = []string{sign + name}
+		return nil
+	}
+	user.Commands = slices.DeleteFunc(user.Commands, func(rule string) bool {
+		return rule[1:] == name || strings.HasPrefix(rule[1:], name+"|")
+	})
+	user.Commands = append(user.Commands, sign+name)
+	return nil
+}
+
+// String is user as an ACL file line, which ACL SETUSER takes back.
+func (user *User) String() string {
+	parts := []string{"user", user.Name, "off"}
+	if user.Enabled {
+		parts[2] = "on"
+	}
+	if user.NoPass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range user.Passwords {
+		parts = append(parts, "#"+hash)
+	}
+	if len(user.Keys) == 0 {
+		parts = append(parts, "resetkeys")
+	}
+	for _, p := range user.Keys {
+		parts = append(parts, "~"+p)
+	}
+	if len(user.Channels) == 0 {
+		parts = append(parts, "resetchannels")
+	}
+	for _, p := range user.Channels {
+		parts = append(parts, "&"+p)
+	}
+	parts = append(parts, user.Commands...)
+	return strings.Join(parts, " ")
+}
+
+// Callers hold u.mu.
+func (u *Users) record(d *Denied, client string) {
+	now := time.Now()
+	for i, e := range u.log {
+		if e.Reason == d.Reason && e.Object == d.Object && e.Username == d.User && now.Sub(e.Updated) < logGroupWindow {
+			e.Count++
+			e.Updated = now
+			e.Client = client
+			copy(u.log[1:i+1], u.log[:i])
+			u.log[0] = e
+			return
+		}
+	}
+	u.nextID++
+	e := &LogEntry{ID: u.nextID - 1, Count: 1, Reason: d.Reason, Object: d.Object, Username: d.User, Client: client, Created: now, Updated: now}
+	u.log = append([]*LogEntry{e}, u.log...)
+	if len(u.log) > logMaxLen {
+		u.log = u.log[:logMaxLen]
+	}
+}
+
+// Log returns up to n of the latest entries, newest first; all of them
+// when n is negative.
+func (u *Users) Log(n int) []LogEntry {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if n < 0 || n > len(u.log) {
+		n = len(u.log)
+	}
+	entries := make([]LogEntry, n)
+	for i := range entries {
+		entries[i] = *u.log[i]
+	}
+	return entries
+}
+
+// ResetLog empties the log.
+func (u *Users) ResetLog() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.log = nil
+}
+
+// Load replaces every user with those in the ACL file at path. On any error
+// nothing changes.
+func (u *Users) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := map[string]*User{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user <name>", path, line)
+		}
+		name := fields[1]
+		if _, dup := users[name]; dup {
+			return fmt.Errorf("%s:%d: duplicate user '%s'", path, line, name)
+		}
+		user := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := user.apply(rule, u.known); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+		}
+		users[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = u.defaultUser()
+	}
+	u.users = users
+	return nil
+}
+
+// Save writes every user to the ACL file at path, replacing it atomically.
+func (u *Users) Save(path string) error {
+	lines := u.List()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
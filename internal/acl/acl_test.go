@@ -0,0 +1,180 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultUser(t *testing.T) {
+	users := New("secret", nil)
+	if users.NoPass(DefaultUser) {
+		t.Error("Expected the default user to need the configured password")
+	}
+	if !users.Authenticate(DefaultUser, "secret", "c") || users.Authenticate(DefaultUser, "wrong", "c") {
+		t.Error("Expected only the configured password to log in")
+	}
+	if !New("", nil).NoPass(DefaultUser) {
+		t.Error("Expected no password to leave the default user open")
+	}
+	if d := users.Check(DefaultUser, "c", Request{Command: "bgsave", Categories: []string{"admin"}, Keys: []string{"k"}}); d != nil {
+		t.Errorf("Expected the default user allowed everything, got %v", d)
+	}
+	if _, err := users.DelUser(DefaultUser); err == nil {
+		t.Error("Expected the default user not to be deletable")
+	}
+}
+
+func TestRules(t *testing.T) {
+	users := New("", func(cmd string) bool { return cmd != "nosuch" })
+	if err := users.SetUser("alice", "on", ">pw", "~cache:*", "&news", "+@read", "-keys", "+acl|whoami"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	if !users.Authenticate("alice", "pw", "c") || users.Authenticate("bob", "pw", "c") {
+		t.Error("Expected alice to log in with pw and unknown users not to")
+	}
+
+	read := Request{Command: "get", Categories: []string{"read", "string"}, Keys: []string{"cache:1"}}
+	tests := []struct {
+		name   string
+		r      Request
+		reason string
+	}{
+		{"allowed", read, ""},
+		{"category", Request{Command: "set", Categories: []string{"write", "string"}}, "command"},
+		{"command", Request{Command: "keys", Categories: []string{"keyspace", "read"}}, "command"},
+		{"subcommand", Request{Command: "acl", Subcommand: "whoami", Categories: []string{"admin"}}, ""},
+		{"other subcommand", Request{Command: "acl", Subcommand: "list", Categories: []string{"admin"}}, "command"},
+		{"key", Request{Command: "get", Categories: []string{"read"}, Keys: []string{"cache:1", "secret"}}, "key"},
+		{"channel", Request{Command: "subscribe", Categories: []string{"read"}, Channels: []string{"sports"}}, "channel"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if d := users.Check("alice", "c", tt.r); d != nil {
+			got = d.Reason
+		}
+		if got != tt.reason {
+			t.Errorf("%s: expected denial %q, got %q", tt.name, tt.reason, got)
+		}
+	}
+	if d := users.Check("alice", "c", Request{Command: "acl", Subcommand: "list"}); d == nil || d.Object != "acl|list" {
+		t.Errorf("Expected the subcommand named in the denial, got %v", d)
+	}
+
+	users.SetUser("alice", "off")
+	if users.Authenticate("alice", "pw", "c") {
+		t.Error("Expected a disabled user not to log in")
+	}
+	users.SetUser("alice", "+@all", "-@dangerous")
+	user, _ := users.Get("alice")
+	if strings.Join(user.Commands, " ") != "+@all -@dangerous" {
+		t.Errorf("Expected +@all to drop the earlier rules, got %v", user.Commands)
+	}
+
+	users.SetUser("alice", "allkeys")
+	for _, rule := range []string{"+nosuch", "+@nosuch", "#abc", "<missing", "~x", "bogus"} {
+		if err := users.SetUser("alice", rule); err == nil {
+			t.Errorf("Expected %q to be refused", rule)
+		}
+	}
+	if err := users.SetUser("carol", "on", "+get", "bogus"); err == nil || users.Exists("carol") {
+		t.Error("Expected a failing SETUSER to create nothing")
+	}
+}
+
+func TestLog(t *testing.T) {
+	users := New("secret", nil)
+	users.SetUser("bob", "on", "nopass", "+get", "~*")
+	for range 3 {
+		users.Check("bob", "127.0.0.1:1", Request{Command: "set"})
+	}
+	users.Authenticate(DefaultUser, "wrong", "127.0.0.1:2")
+
+	entries := users.Log(-1)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	if e := entries[0]; e.Reason != "auth" || e.Username != DefaultUser || e.Client != "127.0.0.1:2" {
+		t.Errorf("Expected the failed AUTH first, got %+v", e)
+	}
+	if e := entries[1]; e.Reason != "command" || e.Object != "set" || e.Count != 3 {
+		t.Errorf("Expected the denied SETs grouped, got %+v", e)
+	}
+	if len(users.Log(1)) != 1 {
+		t.Error("Expected the count to limit the entries")
+	}
+	users.ResetLog()
+	if len(users.Log(-1)) != 0 {
+		t.Error("Expected an empty log after reset")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	users := New("secret", nil)
+	users.SetUser("alice", "on", ">pw", "~a:*", "~b:*", "&*", "-@all", "+get", "+set")
+	users.SetUser("bob", "nopass", "allkeys", "+@read")
+	if err := users.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "pw ") || !strings.Contains(string(data), "#"+HashPassword("pw")) {
+		t.Errorf("Expected only password hashes saved, got:\n%s", data)
+	}
+
+	loaded := New("other", nil)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if strings.Join(loaded.List(), "\n") != strings.Join(users.List(), "\n") {
+		t.Errorf("Expected the users back, got:\n%s\nwant:\n%s", strings.Join(loaded.List(), "\n"), strings.Join(users.List(), "\n"))
+	}
+
+	os.WriteFile(path, []byte("user carol on +get\nuser dave bogus\n"), 0600)
+	if err := loaded.Load(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+	if !loaded.Exists("alice") || loaded.Exists("carol") {
+		t.Error("Expected a failed load to change nothing")
+	}
+
+	os.WriteFile(path, []byte("# no default user\nuser carol on nopass +get ~*\n"), 0600)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Exists("alice") || !loaded.Authenticate(DefaultUser, "other", "c") {
+		t.Error("Expected the file to replace the users and keep the configured default user")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	users := New("", nil)
+	r := Request{Command: "set", Keys: []string{"user/1", "a:b/c"}, Channels: []string{"news/eu"}}
+	if d := users.Check(DefaultUser, "c", r); d != nil {
+		t.Errorf("Expected the default user allowed keys and channels with / and :, got %v", d)
+	}
+
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"user/*", "user/1/2", true},
+		{"*:*", "a:b", true},
+		{"h?llo", "h/llo", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a*b*c", "abxbc", true},
+		{"a*b", "abc", false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Package backup keeps point-in-time backups of the dataset. Each backup is
+// a directory holding an RDB snapshot and the segment of write commands
+// appended after it, up to the next backup, with a timestamp before each
+// second's commands. A restore loads the newest snapshot at or before the
+// chosen time and replays the segments after it up to that time.
+package backup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	prefix      = "backup-"
+	timeLayout  = "20060102T150405.000Z"
+	snapshotRDB = "dump.rdb"
+	segmentAOF  = "appendonly.aof"
+	// tsPrefix starts the line giving the Unix time of the commands after
+	// it, like Redis's AOF timestamp annotations.
+	tsPrefix = "#TS:"
+)
+
+type Config struct {
+	Dir string
+	// Keep is how many of the newest backups to keep; 0 keeps all.
+	Keep int
+	// MaxAge drops backups older than it, except the newest; 0 keeps all.
+	MaxAge time.Duration
+}
+
+// Backup is one backup directory. It is complete once its snapshot is
+// written; the segment of an incomplete one is still part of the chain.
+type Backup struct {
+	Name     string
+	Time     time.Time
+	Path     string
+	RDBSize  int64
+	AOFSize  int64
+	Complete bool
+}
+
+// List returns the backups in dir, oldest first.
+func List(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []Backup
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		at, err := time.Parse(timeLayout, strings.TrimPrefix(e.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		b := Backup{Name: e.Name(), Time: at, Path: filepath.Join(dir, e.Name())}
+		if stat, err := os.Stat(filepath.Join(b.Path, snapshotRDB)); err == nil {
+			b.RDBSize, b.Complete = stat.Size(), true
+		}
+		if stat, err := os.Stat(filepath.Join(b.Path, segmentAOF)); err == nil {
+			b.AOFSize = stat.Size()
+		}
+		backups = append(backups, b)
+	}
+	slices.SortFunc(backups, func(a, b Backup) int { return a.Time.Compare(b.Time) })
+	return backups, nil
+}
+
+// Manager writes backups and appends every write command to the segment of
+// the newest one.
+type Manager struct {
+	cfg Config
+
+	mu      sync.Mutex
+	segment *os.File
+	// lastTS is the second last written to the segment.
+	lastTS int64
+}
+
+// New opens the backup directory, continuing the segment of its newest
+// backup.
+func New(cfg Config) (*Manager, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	m := &Manager{cfg: cfg}
+	backups, err := List(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) > 0 {
+		newest := backups[len(backups)-1]
+		if m.segment, err = openSegment(newest.Path); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func openSegment(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, segmentAOF), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+// Dir is where the backups are kept.
+func (m *Manager) Dir() string {
+	return m.cfg.Dir
+}
+
+// Append adds a write command to the current segment. It is a no-op before
+// the first backup.
+func (m *Manager) Append(v resp.Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.segment == nil {
+		return
+	}
+	var b strings.Builder
+	if now := time.Now().Unix(); now != m.lastTS {
+		b.WriteString(tsPrefix + strconv.FormatInt(now, 10) + "\r\n")
+		m.lastTS = now
+	}
+	b.WriteString(resp.Encode(v))
+	if _, err := m.segment.WriteString(b.String()); err != nil {
+		log.Printf("backup segment write error: %v", err)
+	}
+}
+
+// Pending is a backup begun by Begin.
+type Pending struct {
+	m      *Manager
+	backup Backup
+}
+
+// Begin starts a backup as of now: write commands from here on go to its
+// segment.
+func (m *Manager) Begin(now time.Time) (*Pending, error) {
+	name := prefix + now.UTC().Format(timeLayout)
+	path := filepath.Join(m.cfg.Dir, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	segment, err := openSegment(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old := m.segment; old != nil {
+		old.Sync()
+		old.Close()
+	}
+	m.segment, m.lastTS = segment, 0
+	return &Pending{m: m, backup: Backup{Name: name, Time: now.UTC(), Path: path}}, nil
+}
+
+// Finish writes the snapshot of the backup, then prunes old backups.
+func (p *Pending) Finish(write func(emit func(persistence.Snapshot) error) error) error {
+	if err := persistence.SaveRDB(filepath.Join(p.backup.Path, snapshotRDB), write); err != nil {
+		return err
+	}
+	return p.m.prune(time.Now())
+}
+
+func (m *Manager) prune(now time.Time) error {
+	backups, err := List(m.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	var complete []Backup
+	for _, b := range backups {
+		if b.Complete {
+			complete = append(complete, b)
+		}
+	}
+	if len(complete) == 0 {
+		return nil
+	}
+	first := 0
+	if m.cfg.Keep > 0 && len(complete) > m.cfg.Keep {
+		first = len(complete) - m.cfg.Keep
+	}
+	if m.cfg.MaxAge > 0 {
+		for first < len(complete)-1 && now.Sub(complete[first].Time) > m.cfg.MaxAge {
+			first++
+		}
+	}
+	oldest := complete[first].Time
+	for _, b := range backups {
+		if b.Time.Before(oldest) {
+			if err := os.RemoveAll(b.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close syncs and closes the current segment.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.segment == nil {
+		return nil
+	}
+	m.segment.Sync()
+	err := m.segment.Close()
+	m.segment = nil
+	return err
+}
+
+// Restore rebuilds the dataset as of at from the backups in dir and returns
+// the backup used and how many commands were replayed.
+func Restore(dir string, at time.Time, restore func(*persistence.Snapshot), dispatch func(cmd string, args []resp.Value)) (Backup, int, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return Backup{}, 0, err
+	}
+	start := -1
+	for i, b := range backups {
+		if b.Complete && !b.Time.After(at) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return Backup{}, 0, fmt.Errorf("no backup in %s at or before %s", dir, at.UTC().Format(time.RFC3339))
+	}
+
+	base := backups[start]
+	snapshot, err := persistence.ReadRDB(filepath.Join(base.Path, snapshotRDB))
+	if err != nil {
+		return base, 0, err
+	}
+	if snapshot != nil {
+		restore(snapshot)
+	}
+	replayed := 0
+	for _, b := range backups[start:] {
+		if b.Time.After(at) {
+			break
+		}
+		n, done, err := replaySegment(filepath.Join(b.Path, segmentAOF), at, dispatch)
+		replayed += n
+		if err != nil {
+			return base, replayed, err
+		}
+		if done {
+			break
+		}
+	}
+	return base, replayed, nil
+}
+
+func replaySegment(path string, at time.Time, dispatch func(cmd string, args []resp.Value)) (int, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	n := 0
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return n, false, nil
+		} else if err != nil {
+			return n, false, err
+		}
+		if b[0] == '#' {
+			line, err := r.ReadString('\n')
+			if err == io.EOF {
+				return n, false, nil
+			} else if err != nil {
+				return n, false, err
+			}
+			ts, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, tsPrefix)), 10, 64)
+			if err != nil {
+				return n, false, fmt.Errorf("%s: bad annotation %q", path, strings.TrimSpace(line))
+			}
+			if time.Unix(ts, 0).After(at) {
+				return n, true, nil
+			}
+			continue
+		}
+		v, err := resp.Decode(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return n, false, nil
+		} else if err != nil {
+			return n, false, fmt.Errorf("%s: %w", path, err)
+		}
+		if v.Type != resp.Array || len(v.Items) == 0 {
+			return n, false, fmt.Errorf("%s: record is not a command", path)
+		}
+		dispatch(v.Items[0].Text, v.Items[1:])
+		n++
+	}
+}
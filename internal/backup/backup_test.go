@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func command(parts ...string) resp.Value {
+	items := make([]resp.Value, 0, len(parts))
+	for _, p := range parts {
+		items = append(items, resp.Value{Type: resp.BulkString, Text: p})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func snapshotOf(keys ...string) func(emit func(persistence.Snapshot) error) error {
+	return func(emit func(persistence.Snapshot) error) error {
+		data := map[string]datastructure.Item{}
+		for _, k := range keys {
+			data[k] = datastructure.Item{Value: "v"}
+		}
+		return emit(persistence.Snapshot{DictData: data})
+	}
+}
+
+func stamp(at time.Time) string {
+	return tsPrefix + strconv.FormatInt(at.Unix(), 10) + "\r\n"
+}
+
+// restoreAt returns the keys the snapshot held and the keys the replayed
+// commands set.
+func restoreAt(t *testing.T, dir string, at time.Time) (Backup, []string, []string, error) {
+	t.Helper()
+	var restored, replayed []string
+	used, n, err := Restore(dir, at, func(s *persistence.Snapshot) {
+		for k := range s.DictData {
+			restored = append(restored, k)
+		}
+	}, func(cmd string, args []resp.Value) {
+		replayed = append(replayed, args[0].Text)
+	})
+	if err == nil && n != len(replayed) {
+		t.Errorf("Expected %d commands reported, got %d", len(replayed), n)
+	}
+	slices.Sort(restored)
+	return used, restored, replayed, err
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	m.Append(command("SET", "early", "1"))
+
+	base := time.Unix(1700000000, 0)
+	first, err := m.Begin(base)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := first.Finish(snapshotOf("a")); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	second, err := m.Begin(base.Add(30 * time.Second))
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := second.Finish(snapshotOf("a", "b", "c")); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	m.Close()
+
+	segment := stamp(base.Add(10*time.Second)) + resp.Encode(command("SET", "b", "2")) +
+		stamp(base.Add(20*time.Second)) + resp.Encode(command("SET", "c", "3"))
+	os.WriteFile(filepath.Join(first.backup.Path, segmentAOF), []byte(segment), 0644)
+	// The second segment ends in a command cut short by a crash.
+	segment = stamp(base.Add(40*time.Second)) + resp.Encode(command("SET", "d", "4")) + "*3\r\n$3\r\nSET"
+	os.WriteFile(filepath.Join(second.backup.Path, segmentAOF), []byte(segment), 0644)
+
+	backups, _ := List(dir)
+	if len(backups) != 2 || !backups[0].Complete || backups[1].AOFSize != int64(len(segment)) {
+		t.Fatalf("Expected two complete backups, got %+v", backups)
+	}
+
+	if _, _, _, err := restoreAt(t, dir, base.Add(-time.Second)); err == nil {
+		t.Error("Expected no backup before the first one")
+	}
+	used, restored, replayed, err := restoreAt(t, dir, base.Add(15*time.Second))
+	if err != nil || used.Name != first.backup.Name || !slices.Equal(restored, []string{"a"}) || !slices.Equal(replayed, []string{"b"}) {
+		t.Errorf("Expected a from the first snapshot and b replayed, got %s %v %v %v", used.Name, restored, replayed, err)
+	}
+	used, restored, replayed, err = restoreAt(t, dir, base.Add(35*time.Second))
+	if err != nil || used.Name != second.backup.Name || len(restored) != 3 || len(replayed) != 0 {
+		t.Errorf("Expected the second snapshot alone, got %s %v %v %v", used.Name, restored, replayed, err)
+	}
+	_, _, replayed, err = restoreAt(t, dir, base.Add(time.Hour))
+	if err != nil || !slices.Equal(replayed, []string{"d"}) {
+		t.Errorf("Expected d replayed and the cut command dropped, got %v %v", replayed, err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p, err := m.Begin(time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if err := p.Finish(snapshotOf("a")); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	m.Append(command("SET", "b", "2"))
+	m.Append(command("SET", "c", "3"))
+	m.Close()
+
+	data, _ := os.ReadFile(filepath.Join(p.backup.Path, segmentAOF))
+	if !strings.HasPrefix(string(data), tsPrefix) || strings.Count(string(data), tsPrefix) > 2 {
+		t.Errorf("Expected the commands stamped once a second, got %q", data)
+	}
+
+	// A reopened manager continues the newest segment.
+	m, _ = New(Config{Dir: dir})
+	m.Append(command("SET", "d", "4"))
+	m.Close()
+	_, _, replayed, err := restoreAt(t, dir, time.Now().Add(time.Second))
+	if err != nil || !slices.Equal(replayed, []string{"b", "c", "d"}) {
+		t.Errorf("Expected every appended command replayed, got %v %v", replayed, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	m, _ := New(Config{Dir: dir, Keep: 2})
+	for _, age := range []time.Duration{4 * time.Hour, 3 * time.Hour, 2 * time.Hour} {
+		p, err := m.Begin(now.Add(-age))
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		if err := p.Finish(snapshotOf("a")); err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+	}
+	m.Close()
+	backups, _ := List(dir)
+	if len(backups) != 2 || now.Sub(backups[0].Time) != 3*time.Hour {
+		t.Fatalf("Expected the two newest backups kept, got %+v", backups)
+	}
+
+	m, _ = New(Config{Dir: dir, MaxAge: 150 * time.Minute})
+	// A backup whose snapshot was never written still counts for the age
+	// of the chain, not the retention count.
+	if _, err := m.Begin(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	p, _ := m.Begin(now)
+	if err := p.Finish(snapshotOf("a")); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	m.Close()
+	backups, _ = List(dir)
+	if len(backups) != 3 || now.Sub(backups[0].Time) != 2*time.Hour || backups[1].Complete {
+		t.Errorf("Expected only the backup past max age dropped, got %+v", backups)
+	}
+
+	m, _ = New(Config{Dir: dir, MaxAge: time.Nanosecond})
+	if err := m.prune(now.Add(time.Hour)); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	m.Close()
+	backups, _ = List(dir)
+	if len(backups) != 1 || !backups[0].Time.Equal(now) {
+		t.Errorf("Expected the newest backup always kept, got %+v", backups)
+	}
+}
@@ -0,0 +1,236 @@
+// Package bigkeys reports the largest keys of a running server per type, like
+// redis-cli --bigkeys and --memkeys. It works as a client issuing one command
+// per key in small batches, so the server keeps serving others meanwhile.
+package bigkeys
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const batchSize = 100
+
+type Options struct {
+	// Memory ranks keys by MEMORY USAGE instead of their length.
+	Memory bool
+	// Samples is passed to MEMORY USAGE; 0 measures every element.
+	Samples int
+	// Interval is slept between batches to throttle the scan.
+	Interval time.Duration
+	Auth     string
+}
+
+var lengthCommands = map[string]struct{ cmd, unit string }{
+	"string": {"STRLEN", "bytes"},
+	"list":   {"LLEN", "items"},
+	"set":    {"SCARD", "members"},
+	"hash":   {"HLEN", "fields"},
+	"zset":   {"ZCARD", "members"},
+}
+
+type typeStats struct {
+	name    string
+	unit    string
+	count   int
+	total   int64
+	biggest string
+	size    int64
+}
+
+type Report struct {
+	Keys      int
+	KeyBytes  int64
+	types     map[string]*typeStats
+	memory    bool
+	progress  []string
+	startedAt time.Time
+	elapsed   time.Duration
+}
+
+// Scan connects to addr and sizes every key.
+func Scan(addr string, opts Options) (*Report, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return ScanConn(conn, opts)
+}
+
+// ScanConn sizes every key over an established connection.
+func ScanConn(conn io.ReadWriter, opts Options) (*Report, error) {
+	c := &client{w: conn, r: bufio.NewReader(conn)}
+	if opts.Auth != "" {
+		if _, err := c.call([]string{"AUTH", opts.Auth}); err != nil {
+			return nil, err
+		}
+	}
+	r := &Report{types: map[string]*typeStats{}, memory: opts.Memory, startedAt: time.Now()}
+	cursor := "0"
+	for first := true; first || cursor != "0"; first = false {
+		if !first && opts.Interval > 0 {
+			time.Sleep(opts.Interval)
+		}
+		v, err := c.call([]string{"SCAN", cursor, "COUNT", fmt.Sprint(batchSize)})
+		if err != nil {
+			return nil, err
+		}
+		if v.Type != resp.Array || len(v.Items) != 2 {
+			return nil, errors.New("unexpected SCAN reply")
+		}
+		cursor = v.Items[0].Text
+		keys := make([]string, len(v.Items[1].Items))
+		for i, item := range v.Items[1].Items {
+			keys[i] = item.Text
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := r.scanBatch(c, keys, opts); err != nil {
+			return nil, err
+		}
+	}
+	r.elapsed = time.Since(r.startedAt)
+	return r, nil
+}
+
+func (r *Report) scanBatch(c *client, keys []string, opts Options) error {
+	cmds := make([][]string, len(keys))
+	for i, key := range keys {
+		cmds[i] = []string{"TYPE", key}
+	}
+	types, err := c.pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	units := make([]string, len(keys))
+	for i, key := range keys {
+		length, ok := lengthCommands[types[i].Text]
+		switch {
+		case ok && !opts.Memory:
+			cmds[i], units[i] = []string{length.cmd, key}, length.unit
+		default:
+			cmds[i], units[i] = []string{"MEMORY", "USAGE", key, "SAMPLES", fmt.Sprint(opts.Samples)}, "bytes"
+		}
+	}
+	sizes, err := c.pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		typ := types[i].Text
+		// The key was deleted since SCAN listed it.
+		if typ == "none" || sizes[i].Type != resp.Integer {
+			continue
+		}
+		r.add(key, typ, units[i], sizes[i].Number)
+	}
+	return nil
+}
+
+func (r *Report) add(key, typ, unit string, size int64) {
+	r.Keys++
+	r.KeyBytes += int64(len(key))
+	s := r.types[typ]
+	if s == nil {
+		s = &typeStats{name: typ, unit: unit}
+		r.types[typ] = s
+	}
+	s.count++
+	s.total += size
+	if s.biggest == "" || size > s.size {
+		s.biggest, s.size = key, size
+		r.progress = append(r.progress, fmt.Sprintf("Biggest %-9s found so far %q with %d %s", typ, key, size, unit))
+	}
+}
+
+// Biggest returns the largest key of a type and its size.
+func (r *Report) Biggest(typ string) (string, int64, bool) {
+	s := r.types[typ]
+	if s == nil {
+		return "", 0, false
+	}
+	return s.biggest, s.size, true
+}
+
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	by := "biggest keys"
+	if r.memory {
+		by = "biggest keys by memory"
+	}
+	fmt.Fprintf(&b, "# Scanning the entire keyspace to find the %s\n\n", by)
+	for _, line := range r.progress {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n-------- summary -------\n\n")
+	fmt.Fprintf(&b, "Sampled %d keys in the keyspace in %s!\n", r.Keys, r.elapsed.Round(time.Millisecond))
+	avg := 0.0
+	if r.Keys > 0 {
+		avg = float64(r.KeyBytes) / float64(r.Keys)
+	}
+	fmt.Fprintf(&b, "Total key length in bytes is %d (avg len %.2f)\n\n", r.KeyBytes, avg)
+
+	stats := make([]*typeStats, 0, len(r.types))
+	for _, s := range r.types {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].name < stats[j].name })
+	for _, s := range stats {
+		fmt.Fprintf(&b, "Biggest %-9s found %q has %d %s\n", s.name, s.biggest, s.size, s.unit)
+	}
+	b.WriteString("\n")
+	for _, s := range stats {
+		fmt.Fprintf(&b, "%d %ss with %d %s (%.2f%% of keys, avg size %.2f)\n",
+			s.count, s.name, s.total, s.unit, float64(s.count)*100/float64(r.Keys), float64(s.total)/float64(s.count))
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+type client struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *client) call(cmd []string) (resp.Value, error) {
+	replies, err := c.pipeline([][]string{cmd})
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if replies[0].Type == resp.Error {
+		return resp.Value{}, errors.New(replies[0].Text)
+	}
+	return replies[0], nil
+}
+
+func (c *client) pipeline(cmds [][]string) ([]resp.Value, error) {
+	var b strings.Builder
+	for _, cmd := range cmds {
+		items := make([]resp.Value, len(cmd))
+		for i, part := range cmd {
+			items[i] = resp.Value{Type: resp.BulkString, Text: part}
+		}
+		b.WriteString(resp.Encode(resp.Value{Type: resp.Array, Items: items}))
+	}
+	if _, err := io.WriteString(c.w, b.String()); err != nil {
+		return nil, err
+	}
+	replies := make([]resp.Value, len(cmds))
+	for i := range replies {
+		v, err := resp.Decode(c.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, nil
+}
@@ -0,0 +1,127 @@
+package bigkeys
+
+import (
+	"bufio"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type fakeKey struct {
+	typ    string
+	length int64
+	memory int64
+}
+
+// serveFake answers the commands a scan sends from a fixed keyspace.
+func serveFake(t *testing.T, keys map[string]fakeKey) net.Conn {
+	t.Helper()
+	// A real socket buffers a pipelined batch, unlike net.Pipe.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	// A key deleted after being listed.
+	names := append(slices.Sorted(maps.Keys(keys)), "gone")
+	go func() {
+		server, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		for {
+			req, err := resp.Decode(reader)
+			if err != nil {
+				return
+			}
+			args := make([]string, len(req.Items))
+			for i, item := range req.Items {
+				args[i] = item.Text
+			}
+			var reply resp.Value
+			switch strings.ToUpper(args[0]) {
+			case "AUTH":
+				reply = resp.Value{Type: resp.SimpleString, Text: "OK"}
+			case "SCAN":
+				// One key per call, to exercise the cursor.
+				cursor, _ := strconv.Atoi(args[1])
+				next := strconv.Itoa(cursor + 1)
+				if cursor+1 == len(names) {
+					next = "0"
+				}
+				reply = resp.Value{Type: resp.Array, Items: []resp.Value{
+					{Type: resp.BulkString, Text: next},
+					{Type: resp.Array, Items: []resp.Value{{Type: resp.BulkString, Text: names[cursor]}}},
+				}}
+			case "TYPE":
+				reply = resp.Value{Type: resp.SimpleString, Text: "none"}
+				if k, ok := keys[args[1]]; ok {
+					reply.Text = k.typ
+				}
+			case "MEMORY":
+				reply = resp.Value{Type: resp.Integer, Number: keys[args[2]].memory}
+			default:
+				reply = resp.Value{Type: resp.Integer, Number: keys[args[1]].length}
+			}
+			if _, err := server.Write([]byte(resp.Encode(reply))); err != nil {
+				return
+			}
+		}
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestScan(t *testing.T) {
+	keys := map[string]fakeKey{
+		"short": {"string", 3, 500},
+		"long":  {"string", 300, 100},
+		"l1":    {"list", 10, 50},
+		"l2":    {"list", 2, 5000},
+		"doc":   {"ReJSON-RL", 0, 800},
+	}
+	for i := range 250 {
+		keys[strings.Repeat("h", i+1)] = fakeKey{"hash", int64(i), int64(i)}
+	}
+
+	report, err := ScanConn(serveFake(t, keys), Options{Auth: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Keys != len(keys) {
+		t.Errorf("Expected %d keys, got %d", len(keys), report.Keys)
+	}
+	for typ, want := range map[string]string{"string": "long", "list": "l1", "ReJSON-RL": "doc", "hash": strings.Repeat("h", 250)} {
+		if key, _, _ := report.Biggest(typ); key != want {
+			t.Errorf("Expected biggest %s to be %s, got %s", typ, want, key)
+		}
+	}
+
+	report, err = ScanConn(serveFake(t, keys), Options{Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for typ, want := range map[string]string{"string": "short", "list": "l2"} {
+		if key, _, _ := report.Biggest(typ); key != want {
+			t.Errorf("Expected %s using the most memory to be %s, got %s", typ, want, key)
+		}
+	}
+	var out strings.Builder
+	if _, err := report.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `Biggest list      found "l2" has 5000 bytes`) {
+		t.Errorf("Expected the summary to name l2, got:\n%s", out.String())
+	}
+}
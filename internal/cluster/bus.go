@@ -0,0 +1,447 @@
+package cluster
+
+import (
+	"encoding/gob"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	msgMeet = "meet"
+	msgPing = "ping"
+	msgPong = "pong"
+)
+
+type message struct {
+	Type         string
+	CurrentEpoch int64
+	ID           string
+	Host         string
+	Port         int
+	BusPort      int
+	ConfigEpoch  int64
+	Slots        slotSet
+	Gossip       []gossip
+}
+
+type gossip struct {
+	ID      string
+	Host    string
+	Port    int
+	BusPort int
+	PFail   bool
+	Fail    bool
+}
+
+type link struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+func (l *link) close() { _ = l.conn.Close() }
+
+// Start listens on the bus port and begins gossiping with known nodes.
+func (c *Cluster) Start() error {
+	ln := c.opts.Listener
+	if ln == nil {
+		host, _, _ := net.SplitHostPort(c.opts.Addr)
+		var err error
+		if ln, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(c.opts.BusPort))); err != nil {
+			return err
+		}
+	}
+	c.listener = ln
+	c.wg.Add(2)
+	go c.acceptLoop()
+	go c.cron()
+	return nil
+}
+
+func (c *Cluster) closed() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Cluster) Close() {
+	if c.closed() {
+		return
+	}
+	close(c.stop)
+	if c.listener != nil {
+		_ = c.listener.Close()
+	}
+	c.mu.Lock()
+	for _, n := range c.nodes {
+		if n.link != nil {
+			n.link.close()
+			n.link = nil
+		}
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+func (c *Cluster) pingPeriod() time.Duration {
+	return min(max(c.opts.NodeTimeout/10, 100*time.Millisecond), time.Second)
+}
+
+func (c *Cluster) acceptLoop() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.serveBus(conn)
+		}()
+	}
+}
+
+func (c *Cluster) serveBus(conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.stop:
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	remoteHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	localHost, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * c.opts.NodeTimeout))
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		reply := c.process(&msg, remoteHost, localHost, nil)
+		if reply == nil {
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(c.opts.NodeTimeout))
+		if err := enc.Encode(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (c *Cluster) cron() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.pingPeriod())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+		c.tick()
+	}
+}
+
+func (c *Cluster) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, until := range c.forgotten {
+		if now.After(until) {
+			delete(c.forgotten, id)
+		}
+	}
+
+	changed := false
+	for _, n := range c.nodes {
+		if n == c.myself {
+			continue
+		}
+		if n.handshake && now.Sub(n.ctime) > c.opts.NodeTimeout {
+			log.Printf("cluster: handshake with %s timed out", n.addr())
+			c.removeNode(n)
+			continue
+		}
+		if !n.pinging {
+			n.pinging = true
+			typ := msgPing
+			if n.handshake {
+				typ = msgMeet
+			}
+			go c.ping(n, c.buildMessage(typ))
+		}
+		if n.handshake {
+			continue
+		}
+		if !n.pfail && !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.opts.NodeTimeout {
+			n.pfail = true
+			log.Printf("cluster: node %s (%s) is possibly failing", n.id, n.addr())
+		}
+		if n.pfail && !n.fail && c.failureAgreed(n, now) {
+			n.fail = true
+			changed = true
+			log.Printf("cluster: marking node %s (%s) as failing (quorum reached)", n.id, n.addr())
+		}
+	}
+	c.updateState()
+	if changed {
+		if err := c.saveConfig(); err != nil {
+			log.Printf("cluster: save config error: %v", err)
+		}
+	}
+}
+
+// Callers hold c.mu.
+func (c *Cluster) failureAgreed(n *Node, now time.Time) bool {
+	size, votes := 0, 0
+	for _, other := range c.nodes {
+		if other.numSlots > 0 && !other.handshake {
+			size++
+		}
+	}
+	if c.myself.numSlots > 0 {
+		votes++
+	}
+	for reporter, at := range n.failReports {
+		r, ok := c.nodes[reporter]
+		if !ok || now.Sub(at) > 2*c.opts.NodeTimeout {
+			delete(n.failReports, reporter)
+			continue
+		}
+		if r.numSlots > 0 {
+			votes++
+		}
+	}
+	return size > 0 && votes >= size/2+1
+}
+
+// Callers hold c.mu.
+func (c *Cluster) buildMessage(typ string) *message {
+	me := c.myself
+	msg := &message{
+		Type:         typ,
+		CurrentEpoch: c.currentEpoch,
+		ID:           me.id,
+		Host:         me.host,
+		Port:         me.port,
+		BusPort:      me.busPort,
+		ConfigEpoch:  me.configEpoch,
+		Slots:        me.slots,
+	}
+	for _, n := range c.nodes {
+		if n == me || n.handshake {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, gossip{
+			ID: n.id, Host: n.host, Port: n.port, BusPort: n.busPort,
+			PFail: n.pfail, Fail: n.fail,
+		})
+	}
+	return msg
+}
+
+func (c *Cluster) ping(n *Node, msg *message) {
+	c.mu.Lock()
+	l, host, addr := n.link, n.host, n.busAddr()
+	if n.pingSent.IsZero() {
+		n.pingSent = time.Now()
+	}
+	c.sent++
+	c.mu.Unlock()
+
+	timeout := c.opts.NodeTimeout / 2
+	reply, err := func() (*message, error) {
+		if l == nil {
+			conn, err := net.DialTimeout("tcp", addr, timeout)
+			if err != nil {
+				return nil, err
+			}
+			l = &link{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}
+			c.mu.Lock()
+			current := c.nodes[n.id] == n && !c.closed()
+			if current {
+				n.link = l
+			}
+			c.mu.Unlock()
+			if !current {
+				return nil, net.ErrClosed
+			}
+		}
+		_ = l.conn.SetDeadline(time.Now().Add(timeout))
+		if err := l.enc.Encode(msg); err != nil {
+			return nil, err
+		}
+		var reply message
+		if err := l.dec.Decode(&reply); err != nil {
+			return nil, err
+		}
+		return &reply, nil
+	}()
+
+	if err != nil {
+		c.mu.Lock()
+		if l != nil {
+			l.close()
+			if n.link == l {
+				n.link = nil
+			}
+		}
+		n.pinging = false
+		c.mu.Unlock()
+		return
+	}
+	c.process(reply, host, "", n)
+	c.mu.Lock()
+	n.pinging = false
+	c.mu.Unlock()
+}
+
+func (c *Cluster) process(msg *message, remoteHost, localHost string, pinged *Node) *message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed() {
+		return nil
+	}
+	c.received++
+	now := time.Now()
+	c.currentEpoch = max(c.currentEpoch, msg.CurrentEpoch)
+	if c.myself.host == "" && localHost != "" && msg.Type == msgMeet {
+		c.myself.host = localHost
+		log.Printf("cluster: learned own address %s", c.myself.addr())
+	}
+	_, banned := c.forgotten[msg.ID]
+	sender := c.nodes[msg.ID]
+
+	if pinged != nil {
+		if c.nodes[pinged.id] != pinged || msg.Type != msgPong {
+			return nil
+		}
+		if pinged.handshake {
+			l := pinged.link
+			pinged.link = nil
+			c.removeNode(pinged)
+			if banned || msg.ID == c.myself.id || sender != nil {
+				if l != nil {
+					l.close()
+				}
+			}
+			if banned || msg.ID == c.myself.id {
+				return nil
+			}
+			if sender == nil {
+				pinged.id, pinged.handshake, pinged.link = msg.ID, false, l
+				c.nodes[pinged.id] = pinged
+				sender = pinged
+				log.Printf("cluster: handshake with %s completed, node %s", pinged.addr(), pinged.id)
+			}
+		} else if pinged.id != msg.ID {
+			return nil
+		}
+		sender.pingSent, sender.pongRecv = time.Time{}, now
+		if sender.pfail || sender.fail {
+			log.Printf("cluster: node %s (%s) is reachable again", sender.id, sender.addr())
+		}
+		sender.pfail, sender.fail = false, false
+	}
+
+	var reply *message
+	if msg.Type != msgPong {
+		reply = c.buildMessage(msgPong)
+	}
+	if sender == nil {
+		if msg.Type != msgMeet || banned || msg.ID == c.myself.id {
+			return reply
+		}
+		sender = &Node{id: msg.ID, ctime: now}
+		c.nodes[sender.id] = sender
+		log.Printf("cluster: met node %s", sender.id)
+	}
+
+	changed := c.updateSender(sender, msg, remoteHost)
+	for _, g := range msg.Gossip {
+		if g.ID == c.myself.id {
+			continue
+		}
+		n := c.nodes[g.ID]
+		if n == nil {
+			if _, gone := c.forgotten[g.ID]; !gone && g.Host != "" {
+				c.startHandshake(g.Host, g.Port, g.BusPort)
+			}
+			continue
+		}
+		if sender.numSlots > 0 {
+			if g.PFail || g.Fail {
+				if n.failReports == nil {
+					n.failReports = make(map[string]time.Time)
+				}
+				n.failReports[sender.id] = now
+			} else {
+				delete(n.failReports, sender.id)
+			}
+		}
+		if g.Fail && !n.fail && n.pongRecv.Before(now.Add(-c.opts.NodeTimeout)) {
+			n.fail = true
+			changed = true
+			log.Printf("cluster: node %s (%s) marked as failing by %s", n.id, n.addr(), sender.id)
+		}
+	}
+
+	c.updateState()
+	if changed {
+		if err := c.saveConfig(); err != nil {
+			log.Printf("cluster: save config error: %v", err)
+		}
+	}
+	return reply
+}
+
+// Callers hold c.mu.
+func (c *Cluster) updateSender(sender *Node, msg *message, remoteHost string) bool {
+	host := msg.Host
+	if host == "" {
+		host = remoteHost
+	}
+	changed := sender.host != host || sender.port != msg.Port || sender.configEpoch != msg.ConfigEpoch
+	sender.host, sender.port, sender.busPort = host, msg.Port, msg.BusPort
+	sender.configEpoch = msg.ConfigEpoch
+
+	for slot := 0; slot < Slots; slot++ {
+		if !msg.Slots.has(slot) {
+			continue
+		}
+		owner := c.slots[slot]
+		if owner == sender || c.importing[slot] != nil {
+			continue
+		}
+		if owner != nil && owner.configEpoch >= sender.configEpoch {
+			continue
+		}
+		if owner == c.myself {
+			log.Printf("cluster: slot %d moved to %s (config epoch %d)", slot, sender.id, sender.configEpoch)
+		}
+		c.assign(slot, sender)
+		changed = true
+	}
+
+	// Two masters with the same config epoch: the one with the greater ID
+	// takes a new epoch so slot conflicts always have a winner.
+	if sender.configEpoch == c.myself.configEpoch && c.myself.id > sender.id {
+		c.currentEpoch++
+		c.myself.configEpoch = c.currentEpoch
+		log.Printf("cluster: config epoch collision with %s, moved to epoch %d", sender.id, c.myself.configEpoch)
+		changed = true
+	}
+	return changed
+}
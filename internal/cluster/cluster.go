@@ -0,0 +1,456 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	stateOK   = "ok"
+	stateFail = "fail"
+
+	// forgetBan keeps a forgotten node from being re-added by gossip.
+	forgetBan = time.Minute
+)
+
+var (
+	ErrCrossSlot   = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	ErrDown        = errors.New("CLUSTERDOWN The cluster is down")
+	ErrUnbound     = errors.New("CLUSTERDOWN Hash slot not served")
+	ErrTryAgain    = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+	ErrUnknownNode = errors.New("ERR Unknown node")
+	ErrForgetSelf  = errors.New("ERR I tried hard but I can't forget myself...")
+)
+
+// Redirect tells a client to retry a command on another node: MOVED when the
+// slot now lives there, ASK for a single retry during a migration.
+type Redirect struct {
+	Ask  bool
+	Slot int
+	Addr string
+}
+
+func (r *Redirect) Error() string {
+	kind := "MOVED"
+	if r.Ask {
+		kind = "ASK"
+	}
+	return fmt.Sprintf("%s %d %s", kind, r.Slot, r.Addr)
+}
+
+type Options struct {
+	// Addr is the host:port clients reach this node at. An empty or
+	// unspecified host is learned from the first peer that meets us.
+	Addr string
+	// BusPort defaults to the client port plus 10000.
+	BusPort     int
+	ConfigFile  string
+	NodeTimeout time.Duration
+	// Listener, when set, serves the bus instead of listening on BusPort.
+	Listener net.Listener
+}
+
+type slotSet [Slots / 8]byte
+
+func (s *slotSet) has(slot int) bool { return s[slot/8]&(1<<(slot%8)) != 0 }
+func (s *slotSet) set(slot int)      { s[slot/8] |= 1 << (slot % 8) }
+func (s *slotSet) clear(slot int)    { s[slot/8] &^= 1 << (slot % 8) }
+
+// Node is a member of the cluster as seen from this node. Its fields are
+// guarded by the Cluster's mutex.
+type Node struct {
+	id          string
+	host        string
+	port        int
+	busPort     int
+	configEpoch int64
+	slots       slotSet
+	numSlots    int
+
+	handshake   bool
+	ctime       time.Time
+	pfail       bool
+	fail        bool
+	failReports map[string]time.Time
+	pingSent    time.Time
+	pongRecv    time.Time
+	pinging     bool
+	link        *link
+}
+
+func (n *Node) addr() string    { return net.JoinHostPort(n.host, strconv.Itoa(n.port)) }
+func (n *Node) busAddr() string { return net.JoinHostPort(n.host, strconv.Itoa(n.busPort)) }
+
+// Cluster is this node's view of the cluster: its members, which of them
+// serves each slot, and the slots being migrated in or out.
+type Cluster struct {
+	opts Options
+
+	mu           sync.RWMutex
+	myself       *Node
+	nodes        map[string]*Node
+	slots        [Slots]*Node
+	migrating    map[int]*Node
+	importing    map[int]*Node
+	currentEpoch int64
+	state        string
+	forgotten    map[string]time.Time
+	sent         int64
+	received     int64
+
+	listener net.Listener
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newNodeID() string {
+	id := make([]byte, 20)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// New creates this node's cluster state, restoring it from the config file
+// when one exists.
+func New(opts Options) (*Cluster, error) {
+	host, portText, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	if opts.BusPort == 0 {
+		opts.BusPort = port + 10000
+	}
+	if opts.NodeTimeout <= 0 {
+		opts.NodeTimeout = 15 * time.Second
+	}
+
+	c := &Cluster{
+		opts:      opts,
+		nodes:     make(map[string]*Node),
+		migrating: make(map[int]*Node),
+		importing: make(map[int]*Node),
+		forgotten: make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+	loaded, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !loaded {
+		c.myself = &Node{id: newNodeID()}
+		c.nodes[c.myself.id] = c.myself
+	}
+	// Our own address comes from the current options, not the saved file.
+	if host != "" {
+		c.myself.host = host
+	}
+	c.myself.port, c.myself.busPort = port, opts.BusPort
+	c.updateState()
+	if err := c.saveConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cluster) MyID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.myself.id
+}
+
+// Route decides whether a command on keys runs here, returning a Redirect
+// when another node serves the keys.
+func (c *Cluster) Route(keys []string, asking bool, exists func(string) bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return ErrCrossSlot
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	owner := c.slots[slot]
+	importing := asking && c.importing[slot] != nil
+	if c.state != stateOK && !importing {
+		if owner == nil {
+			return ErrUnbound
+		}
+		return ErrDown
+	}
+	missing := func() int {
+		n := 0
+		for _, key := range keys {
+			if !exists(key) {
+				n++
+			}
+		}
+		return n
+	}
+
+	switch {
+	case owner == c.myself:
+		target := c.migrating[slot]
+		if target == nil {
+			return nil
+		}
+		switch missing() {
+		case 0:
+			return nil
+		case len(keys):
+			return &Redirect{Ask: true, Slot: slot, Addr: target.addr()}
+		default:
+			return ErrTryAgain
+		}
+	case importing:
+		if len(keys) > 1 && missing() > 0 {
+			return ErrTryAgain
+		}
+		return nil
+	default:
+		return &Redirect{Slot: slot, Addr: owner.addr()}
+	}
+}
+
+// Meet starts a handshake with the node at host:port, which joins it and
+// this node's cluster together.
+func (c *Cluster) Meet(host string, port, busPort int) {
+	if busPort == 0 {
+		busPort = port + 10000
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startHandshake(host, port, busPort)
+}
+
+// Callers hold c.mu.
+func (c *Cluster) startHandshake(host string, port, busPort int) {
+	for _, n := range c.nodes {
+		if n.host == host && n.port == port && (n.handshake || n == c.myself) {
+			return
+		}
+	}
+	n := &Node{id: newNodeID(), host: host, port: port, busPort: busPort, handshake: true, ctime: time.Now()}
+	c.nodes[n.id] = n
+}
+
+func (c *Cluster) Forget(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == c.myself.id {
+		return ErrForgetSelf
+	}
+	n, ok := c.nodes[id]
+	if !ok || n.handshake {
+		return ErrUnknownNode
+	}
+	c.removeNode(n)
+	c.forgotten[id] = time.Now().Add(forgetBan)
+	c.updateState()
+	return c.saveConfig()
+}
+
+// Callers hold c.mu.
+func (c *Cluster) removeNode(n *Node) {
+	for slot, owner := range c.slots {
+		if owner == n {
+			c.slots[slot] = nil
+		}
+	}
+	for slot, target := range c.migrating {
+		if target == n {
+			delete(c.migrating, slot)
+		}
+	}
+	for slot, source := range c.importing {
+		if source == n {
+			delete(c.importing, slot)
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n.id)
+	}
+	if n.link != nil {
+		n.link.close()
+	}
+	delete(c.nodes, n.id)
+}
+
+func (c *Cluster) AddSlots(slots []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return fmt.Errorf("ERR Slot %d is already busy", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.assign(slot, c.myself)
+		delete(c.importing, slot)
+	}
+	c.updateState()
+	return c.saveConfig()
+}
+
+func (c *Cluster) DelSlots(slots []int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return fmt.Errorf("ERR Slot %d is already unassigned", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.assign(slot, nil)
+	}
+	c.updateState()
+	return c.saveConfig()
+}
+
+// Callers hold c.mu.
+func (c *Cluster) assign(slot int, n *Node) {
+	if old := c.slots[slot]; old != nil {
+		old.slots.clear(slot)
+		old.numSlots--
+	}
+	c.slots[slot] = n
+	if n != nil {
+		n.slots.set(slot)
+		n.numSlots++
+	}
+	if n != c.myself {
+		delete(c.migrating, slot)
+	}
+}
+
+// SetSlot implements CLUSTER SETSLOT. keysInSlot reports whether this node
+// still stores keys of slot.
+func (c *Cluster) SetSlot(slot int, action, nodeID string, keysInSlot func(int) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n *Node
+	if action != "STABLE" {
+		var ok bool
+		if n, ok = c.nodes[nodeID]; !ok || n.handshake {
+			return fmt.Errorf("ERR I don't know about node %s", nodeID)
+		}
+	}
+	switch action {
+	case "MIGRATING":
+		if c.slots[slot] != c.myself {
+			return fmt.Errorf("ERR I'm not the owner of hash slot %d", slot)
+		}
+		if n == c.myself {
+			return errors.New("ERR I can't migrate a slot to myself")
+		}
+		c.migrating[slot] = n
+	case "IMPORTING":
+		if c.slots[slot] == c.myself {
+			return fmt.Errorf("ERR I'm already the owner of hash slot %d", slot)
+		}
+		if n == c.myself {
+			return errors.New("ERR I can't import a slot from myself")
+		}
+		c.importing[slot] = n
+	case "STABLE":
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+	case "NODE":
+		if c.slots[slot] == c.myself && n != c.myself && keysInSlot(slot) {
+			return fmt.Errorf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+		c.assign(slot, n)
+		delete(c.migrating, slot)
+		if n == c.myself && c.importing[slot] != nil {
+			delete(c.importing, slot)
+			// The new owner needs the highest config epoch so its claim wins
+			// over the old owner's once it spreads.
+			c.bumpEpoch()
+		}
+	default:
+		return errors.New("ERR Invalid CLUSTER SETSLOT action or number of arguments")
+	}
+	c.updateState()
+	return c.saveConfig()
+}
+
+// Callers hold c.mu.
+func (c *Cluster) bumpEpoch() bool {
+	maxEpoch := int64(0)
+	for _, n := range c.nodes {
+		if n != c.myself {
+			maxEpoch = max(maxEpoch, n.configEpoch)
+		}
+	}
+	if c.myself.configEpoch != 0 && c.myself.configEpoch > maxEpoch {
+		return false
+	}
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+	log.Printf("cluster: config epoch bumped to %d", c.myself.configEpoch)
+	return true
+}
+
+// BumpEpoch implements CLUSTER BUMPEPOCH.
+func (c *Cluster) BumpEpoch() (bool, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bumped := c.bumpEpoch()
+	return bumped, c.myself.configEpoch, c.saveConfig()
+}
+
+func (c *Cluster) SetConfigEpoch(epoch int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case epoch < 0:
+		return errors.New("ERR Invalid config epoch specified")
+	case len(c.nodes) > 1:
+		return errors.New("ERR The user can assign a config epoch only when the node does not know any other node.")
+	case c.myself.configEpoch != 0:
+		return errors.New("ERR Node config epoch is already non-zero")
+	}
+	c.myself.configEpoch = epoch
+	c.currentEpoch = max(c.currentEpoch, epoch)
+	return c.saveConfig()
+}
+
+// Callers hold c.mu.
+func (c *Cluster) updateState() {
+	state := stateOK
+	for _, owner := range c.slots {
+		if owner == nil || owner.fail {
+			state = stateFail
+			break
+		}
+	}
+	if state != c.state && c.state != "" {
+		log.Printf("cluster: state changed to %s", state)
+	}
+	c.state = state
+}
+
+// SaveConfig implements CLUSTER SAVECONFIG.
+func (c *Cluster) SaveConfig() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveConfig()
+}
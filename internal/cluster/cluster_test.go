@@ -0,0 +1,287 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"{user1000}.following", KeySlot("user1000")},
+		{"foo{}{bar}", int(crc16("foo{}{bar}") & (Slots - 1))},
+		{"foo{{bar}}zap", KeySlot("{bar")},
+		{"foo{bar}{zap}", KeySlot("bar")},
+	}
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Error("keys with the same hashtag should share a slot")
+	}
+}
+
+func newTestCluster(t *testing.T, port int, configFile string) *Cluster {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	c, err := New(Options{
+		Addr:        "127.0.0.1:" + strconv.Itoa(port),
+		BusPort:     ln.Addr().(*net.TCPAddr).Port,
+		ConfigFile:  configFile,
+		NodeTimeout: 500 * time.Millisecond,
+		Listener:    ln,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// addPeer records a node without talking to it.
+func addPeer(c *Cluster, port int) *Node {
+	n := &Node{id: newNodeID(), host: "127.0.0.1", port: port, busPort: port + 10000, failReports: map[string]time.Time{}}
+	c.mu.Lock()
+	c.nodes[n.id] = n
+	c.mu.Unlock()
+	return n
+}
+
+func slotRange(start, end int) []int {
+	var slots []int
+	for slot := start; slot <= end; slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+func TestRoute(t *testing.T) {
+	c := newTestCluster(t, 7001, "")
+	other := addPeer(c, 7002)
+
+	noKeys := func(string) bool { return false }
+	if err := c.Route([]string{"foo"}, false, noKeys); !errors.Is(err, ErrUnbound) {
+		t.Fatalf("unassigned slot: got %v", err)
+	}
+
+	if err := c.AddSlots(slotRange(0, 8191)); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	for slot := 8192; slot < Slots; slot++ {
+		c.assign(slot, other)
+	}
+	c.updateState()
+	c.mu.Unlock()
+
+	local, remote := "bar", "foo" // slots 5061 and 12182
+	if err := c.Route([]string{local}, false, noKeys); err != nil {
+		t.Fatalf("local key: %v", err)
+	}
+	var redirect *Redirect
+	if err := c.Route([]string{remote}, false, noKeys); !errors.As(err, &redirect) || redirect.Ask || err.Error() != "MOVED 12182 127.0.0.1:7002" {
+		t.Fatalf("remote key: got %v", err)
+	}
+	if err := c.Route([]string{"a", "b"}, false, noKeys); !errors.Is(err, ErrCrossSlot) {
+		t.Fatalf("cross slot: got %v", err)
+	}
+
+	// Migrating slot 5061 out: missing keys are asked for on the target.
+	if err := c.SetSlot(5061, "MIGRATING", other.id, nil); err != nil {
+		t.Fatal(err)
+	}
+	has := func(key string) bool { return key == "{bar}1" }
+	if err := c.Route([]string{"{bar}1"}, false, has); err != nil {
+		t.Fatalf("key still here: %v", err)
+	}
+	if err := c.Route([]string{"{bar}2"}, false, has); err == nil || err.Error() != "ASK 5061 127.0.0.1:7002" {
+		t.Fatalf("moved key: got %v", err)
+	}
+	if err := c.Route([]string{"{bar}1", "{bar}2"}, false, has); !errors.Is(err, ErrTryAgain) {
+		t.Fatalf("split keys: got %v", err)
+	}
+
+	// Importing slot 12182: only ASKING clients are served.
+	if err := c.SetSlot(12182, "IMPORTING", other.id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Route([]string{remote}, false, noKeys); !errors.As(err, &redirect) {
+		t.Fatalf("importing without asking: got %v", err)
+	}
+	if err := c.Route([]string{remote}, true, noKeys); err != nil {
+		t.Fatalf("importing with asking: %v", err)
+	}
+}
+
+func TestSetSlotErrors(t *testing.T) {
+	c := newTestCluster(t, 7001, "")
+	other := addPeer(c, 7002)
+	if err := c.AddSlots([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddSlots([]int{1}); err == nil || err.Error() != "ERR Slot 1 is already busy" {
+		t.Fatalf("busy slot: got %v", err)
+	}
+	if err := c.SetSlot(2, "MIGRATING", other.id, nil); err == nil {
+		t.Fatal("migrating a slot we don't own should fail")
+	}
+	if err := c.SetSlot(1, "IMPORTING", other.id, nil); err == nil {
+		t.Fatal("importing a slot we own should fail")
+	}
+	if err := c.SetSlot(1, "NODE", "nosuchnode", nil); err == nil {
+		t.Fatal("unknown node should fail")
+	}
+	hasKeys := func(int) bool { return true }
+	if err := c.SetSlot(1, "NODE", other.id, hasKeys); err == nil {
+		t.Fatal("giving away a slot with keys should fail")
+	}
+	if err := c.Forget(c.MyID()); !errors.Is(err, ErrForgetSelf) {
+		t.Fatalf("forget self: got %v", err)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nodes.conf")
+	c := newTestCluster(t, 7001, file)
+	other := addPeer(c, 7002)
+	if err := c.AddSlots(slotRange(0, 100)); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.assign(200, other)
+	other.configEpoch = 3
+	c.currentEpoch = 3
+	c.mu.Unlock()
+	if err := c.SetSlot(50, "MIGRATING", other.id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetSlot(200, "IMPORTING", other.id, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := c.Nodes()
+
+	loaded, err := New(c.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.MyID() != c.MyID() {
+		t.Fatalf("node ID changed: %s != %s", loaded.MyID(), c.MyID())
+	}
+	if got := loaded.Nodes(); got != want {
+		t.Fatalf("nodes after reload:\n%s\nwant:\n%s", got, want)
+	}
+	if loaded.currentEpoch != 3 {
+		t.Fatalf("current epoch = %d, want 3", loaded.currentEpoch)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// owner returns the ID of the node c believes serves slot.
+func owner(c *Cluster, slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if n := c.slots[slot]; n != nil {
+		return n.id
+	}
+	return ""
+}
+
+func knownNodes(c *Cluster) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	known := 0
+	for _, n := range c.nodes {
+		if !n.handshake {
+			known++
+		}
+	}
+	return known
+}
+
+func stateOf(c *Cluster) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+func TestGossip(t *testing.T) {
+	var nodes []*Cluster
+	for i := range 3 {
+		c := newTestCluster(t, 7001+i, "")
+		if err := c.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		nodes = append(nodes, c)
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// Meeting one node is enough; gossip introduces the third.
+	a.Meet("127.0.0.1", b.myself.port, b.opts.BusPort)
+	b.Meet("127.0.0.1", c.myself.port, c.opts.BusPort)
+	for _, n := range nodes {
+		waitFor(t, "every node to know the others", func() bool { return knownNodes(n) == 3 })
+	}
+
+	if err := a.AddSlots(slotRange(0, 5460)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddSlots(slotRange(5461, 10922)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddSlots(slotRange(10923, Slots-1)); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		waitFor(t, "the cluster to be ok", func() bool { return stateOf(n) == stateOK })
+	}
+	if got := owner(c, 0); got != a.MyID() {
+		t.Fatalf("slot 0 owner seen by c = %s, want %s", got, a.MyID())
+	}
+
+	// Move slot 100 from a to b the way a resharding tool does.
+	if err := b.SetSlot(100, "IMPORTING", a.MyID(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetSlot(100, "MIGRATING", b.MyID(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetSlot(100, "NODE", b.MyID(), nil); err != nil {
+		t.Fatal(err)
+	}
+	noKeys := func(int) bool { return false }
+	if err := a.SetSlot(100, "NODE", b.MyID(), noKeys); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "c to learn the new owner of slot 100", func() bool { return owner(c, 100) == b.MyID() })
+	if b.myself.configEpoch <= a.myself.configEpoch {
+		t.Fatalf("b's config epoch %d should exceed a's %d", b.myself.configEpoch, a.myself.configEpoch)
+	}
+
+	// Once c stops answering, a and b agree it failed.
+	c.Close()
+	waitFor(t, "the cluster to go down", func() bool { return stateOf(a) == stateFail && stateOf(b) == stateFail })
+}
@@ -0,0 +1,372 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NodeInfo describes a node for CLUSTER SLOTS and CLUSTER SHARDS.
+type NodeInfo struct {
+	ID      string
+	Host    string
+	Port    int
+	Healthy bool
+}
+
+// SlotRange is a run of consecutive slots served by one node.
+type SlotRange struct {
+	Start, End int
+	Node       NodeInfo
+}
+
+// Shard is a node with every slot range it serves.
+type Shard struct {
+	Ranges []SlotRange
+	Node   NodeInfo
+}
+
+func (n *Node) info() NodeInfo {
+	return NodeInfo{ID: n.id, Host: n.host, Port: n.port, Healthy: !n.fail && !n.pfail}
+}
+
+func (n *Node) ranges() [][2]int {
+	var out [][2]int
+	for slot := 0; slot < Slots; slot++ {
+		if !n.slots.has(slot) {
+			continue
+		}
+		start := slot
+		for slot+1 < Slots && n.slots.has(slot+1) {
+			slot++
+		}
+		out = append(out, [2]int{start, slot})
+	}
+	return out
+}
+
+// Callers hold c.mu.
+func (c *Cluster) sortedNodes() []*Node {
+	nodes := make([]*Node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+func (c *Cluster) SlotRanges() []SlotRange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []SlotRange
+	for slot := 0; slot < Slots; slot++ {
+		owner := c.slots[slot]
+		if owner == nil {
+			continue
+		}
+		start := slot
+		for slot+1 < Slots && c.slots[slot+1] == owner {
+			slot++
+		}
+		out = append(out, SlotRange{Start: start, End: slot, Node: owner.info()})
+	}
+	return out
+}
+
+func (c *Cluster) Shards() []Shard {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []Shard
+	for _, n := range c.sortedNodes() {
+		if n.handshake {
+			continue
+		}
+		shard := Shard{Node: n.info()}
+		for _, r := range n.ranges() {
+			shard.Ranges = append(shard.Ranges, SlotRange{Start: r[0], End: r[1], Node: shard.Node})
+		}
+		out = append(out, shard)
+	}
+	return out
+}
+
+// Info returns the CLUSTER INFO fields.
+func (c *Cluster) Info() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	assigned, pfail, failed, size, known := 0, 0, 0, 0, 0
+	for _, owner := range c.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.fail {
+			failed++
+		} else if owner.pfail {
+			pfail++
+		}
+	}
+	for _, n := range c.nodes {
+		if n.handshake {
+			continue
+		}
+		known++
+		if n.numSlots > 0 {
+			size++
+		}
+	}
+	return []string{
+		"cluster_enabled:1",
+		"cluster_state:" + c.state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned-pfail-failed),
+		"cluster_slots_pfail:" + strconv.Itoa(pfail),
+		"cluster_slots_fail:" + strconv.Itoa(failed),
+		"cluster_known_nodes:" + strconv.Itoa(known),
+		"cluster_size:" + strconv.Itoa(size),
+		"cluster_current_epoch:" + strconv.FormatInt(c.currentEpoch, 10),
+		"cluster_my_epoch:" + strconv.FormatInt(c.myself.configEpoch, 10),
+		"cluster_stats_messages_sent:" + strconv.FormatInt(c.sent, 10),
+		"cluster_stats_messages_received:" + strconv.FormatInt(c.received, 10),
+	}
+}
+
+// Nodes returns the CLUSTER NODES listing, one line per node.
+func (c *Cluster) Nodes() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodesText()
+}
+
+// Callers hold c.mu.
+func (c *Cluster) nodesText() string {
+	var b strings.Builder
+	for _, n := range c.sortedNodes() {
+		b.WriteString(c.nodeLine(n))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func (c *Cluster) nodeLine(n *Node) string {
+	var flags []string
+	if n == c.myself {
+		flags = append(flags, "myself")
+	}
+	flags = append(flags, "master")
+	if n.fail {
+		flags = append(flags, "fail")
+	} else if n.pfail {
+		flags = append(flags, "fail?")
+	}
+	if n.handshake {
+		flags = append(flags, "handshake")
+	}
+	if n.host == "" {
+		flags = append(flags, "noaddr")
+	}
+	linkState := "disconnected"
+	if n == c.myself || (n.link != nil && !n.pfail && !n.fail) {
+		linkState = "connected"
+	}
+
+	fields := []string{
+		n.id,
+		fmt.Sprintf("%s@%d", n.addr(), n.busPort),
+		strings.Join(flags, ","),
+		"-",
+		strconv.FormatInt(unixMilli(n.pingSent), 10),
+		strconv.FormatInt(unixMilli(n.pongRecv), 10),
+		strconv.FormatInt(n.configEpoch, 10),
+		linkState,
+	}
+	for _, r := range n.ranges() {
+		if r[0] == r[1] {
+			fields = append(fields, strconv.Itoa(r[0]))
+		} else {
+			fields = append(fields, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	if n == c.myself {
+		for _, slot := range sortedSlots(c.migrating) {
+			fields = append(fields, fmt.Sprintf("[%d->-%s]", slot, c.migrating[slot].id))
+		}
+		for _, slot := range sortedSlots(c.importing) {
+			fields = append(fields, fmt.Sprintf("[%d-<-%s]", slot, c.importing[slot].id))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func sortedSlots(m map[int]*Node) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// Callers hold c.mu.
+func (c *Cluster) saveConfig() error {
+	if c.opts.ConfigFile == "" {
+		return nil
+	}
+	var b strings.Builder
+	for _, n := range c.sortedNodes() {
+		if n.handshake {
+			continue
+		}
+		b.WriteString(c.nodeLine(n))
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+
+	tmp := c.opts.ConfigFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.opts.ConfigFile)
+}
+
+func (c *Cluster) loadConfig() (bool, error) {
+	if c.opts.ConfigFile == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(c.opts.ConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	type pending struct {
+		node   *Node
+		fields []string
+	}
+	var lines []pending
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return false, fmt.Errorf("cluster config %s: bad line %q", c.opts.ConfigFile, line)
+		}
+		n, err := parseNode(fields)
+		if err != nil {
+			return false, fmt.Errorf("cluster config %s: %w", c.opts.ConfigFile, err)
+		}
+		c.nodes[n.id] = n
+		if strings.Contains(fields[2], "myself") {
+			c.myself = n
+		} else {
+			n.failReports = map[string]time.Time{}
+		}
+		lines = append(lines, pending{n, fields[8:]})
+	}
+	if c.myself == nil {
+		return false, fmt.Errorf("cluster config %s: no myself entry", c.opts.ConfigFile)
+	}
+
+	// Slot ranges may point at nodes listed further down, so they are read
+	// once every node is known.
+	for _, p := range lines {
+		for _, f := range p.fields {
+			if err := c.parseSlotField(p.node, f); err != nil {
+				return false, fmt.Errorf("cluster config %s: %w", c.opts.ConfigFile, err)
+			}
+		}
+	}
+	return true, nil
+}
+
+func parseNode(fields []string) (*Node, error) {
+	addr, bus, ok := strings.Cut(fields[1], "@")
+	if !ok {
+		return nil, fmt.Errorf("bad address %q", fields[1])
+	}
+	bus, _, _ = strings.Cut(bus, ",")
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("bad address %q", fields[1])
+	}
+	port, err1 := strconv.Atoi(addr[i+1:])
+	busPort, err2 := strconv.Atoi(bus)
+	epoch, err3 := strconv.ParseInt(fields[6], 10, 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, err
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr[:i], "["), "]")
+	return &Node{id: fields[0], host: host, port: port, busPort: busPort, configEpoch: epoch}, nil
+}
+
+func (c *Cluster) parseSlotField(n *Node, f string) error {
+	if strings.HasPrefix(f, "[") {
+		body := strings.Trim(f, "[]")
+		if slotText, id, ok := strings.Cut(body, "->-"); ok {
+			slot, err := strconv.Atoi(slotText)
+			if other := c.nodes[id]; err == nil && other != nil {
+				c.migrating[slot] = other
+			}
+			return nil
+		}
+		if slotText, id, ok := strings.Cut(body, "-<-"); ok {
+			slot, err := strconv.Atoi(slotText)
+			if other := c.nodes[id]; err == nil && other != nil {
+				c.importing[slot] = other
+			}
+		}
+		return nil
+	}
+	startText, endText, isRange := strings.Cut(f, "-")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return fmt.Errorf("bad slot %q", f)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(endText); err != nil {
+			return fmt.Errorf("bad slot range %q", f)
+		}
+	}
+	if start < 0 || end >= Slots || start > end {
+		return fmt.Errorf("bad slot range %q", f)
+	}
+	for slot := start; slot <= end; slot++ {
+		c.assign(slot, n)
+	}
+	return nil
+}
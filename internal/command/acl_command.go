@@ -0,0 +1,298 @@
+package command
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/acl"
+	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type ACLContext struct {
+	Users *acl.Users
+}
+
+var aclCtx *ACLContext
+
+func SetACLContext(c *ACLContext) { aclCtx = c }
+
+func InitACLCommands() {
+	Register("ACL", cmdACL)
+}
+
+var categoryCommands = map[string][]string{
+	"keyspace":   {"DEL", "EXPIRE", "PEXPIREAT", "TTL", "TYPE", "KEYS", "SCAN", "OBJECT", "MEMORY", "DUMP", "RESTORE", "RESTORE-ASKING", "MIGRATE"},
+	"string":     {"SET", "GET", "STRLEN", "INCR", "DECR", "INCRBY", "DECRBY"},
+	"bitmap":     {"SETBIT", "GETBIT", "BITCOUNT", "BITPOS", "BITOP", "BITFIELD", "BITFIELD_RO"},
+	"list":       {"LPUSH", "RPUSH", "LPOP", "RPOP", "LLEN", "LRANGE", "SORT"},
+	"set":        {"SADD", "SREM", "SMEMBERS", "SISMEMBER", "SCARD", "SEXPIRE", "STTL"},
+	"sortedset":  {"ZADD", "ZREM", "ZSCORE", "ZCARD", "ZRANK", "ZREVRANK", "ZRANGE", "ZRANGEBYSCORE"},
+	"geo":        {"GEOADD", "GEOPOS", "GEODIST", "GEOHASH", "GEOSEARCH", "GEOSEARCHSTORE"},
+	"hash":       {"HSET", "HGET", "HDEL", "HGETALL", "HEXISTS", "HLEN"},
+	"pubsub":     {"SUBSCRIBE", "UNSUBSCRIBE", "PUBLISH"},
+	"connection": {"AUTH", "PING", "ASKING", "WAIT", "WAITAOF"},
+	"admin": {"BGSAVE", "MONITOR", "REPLICAOF", "SLAVEOF", "REPLCONF", "PSYNC", "SYNC",
+		"CLUSTER", "RAFT", "CRDT", "CRDT.SYNC", "BACKUP", "ACL"},
+	"dangerous": {"KEYS", "INFO", "ROLE", "MIGRATE", "RESTORE", "RESTORE-ASKING"},
+}
+
+var prefixCategories = map[string]string{
+	"JSON.": "json", "TS.": "timeseries", "BF.": "bloom", "CF.": "cuckoo", "CMS.": "cms", "TOPK.": "topk",
+}
+
+var dataCategories = []string{
+	"keyspace", "string", "bitmap", "list", "set", "sortedset", "geo", "hash",
+	"json", "timeseries", "bloom", "cuckoo", "cms", "topk",
+}
+
+var serverCommands = []string{"PSYNC", "SYNC", "CRDT.SYNC"}
+
+var commandCategories = func() map[string][]string {
+	m := map[string][]string{}
+	for category, names := range categoryCommands {
+		for _, name := range names {
+			m[name] = append(m[name], category)
+		}
+	}
+	return m
+}()
+
+// CommandCategories returns the ACL categories of a command, other than
+// @all.
+func CommandCategories(name string) []string {
+	name = strings.ToUpper(name)
+	cats := slices.Clone(commandCategories[name])
+	for prefix, category := range prefixCategories {
+		if strings.HasPrefix(name, prefix) {
+			cats = append(cats, category)
+		}
+	}
+	if slices.Contains(cats, "admin") {
+		cats = append(cats, "dangerous")
+	}
+	switch {
+	case writeCommands[name]:
+		cats = append(cats, "write")
+	case slices.ContainsFunc(cats, func(c string) bool { return slices.Contains(dataCategories, c) }):
+		cats = append(cats, "read")
+	}
+	sort.Strings(cats)
+	return slices.Compact(cats)
+}
+
+// KnownCommand reports whether ACL rules may name a command.
+func KnownCommand(name string) bool {
+	_, ok := Lookup(name)
+	return ok || slices.Contains(serverCommands, strings.ToUpper(name))
+}
+
+// Auth checks AUTH [username] password and returns the user it logs in, or
+// "" when it fails. One argument logs in the default user.
+func Auth(args []resp.Value, client string) (string, resp.Value) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", wrongArity("auth")
+	}
+	if aclCtx == nil || aclCtx.Users == nil {
+		return "", resp.Value{Type: resp.Error, Text: "ERR auth is not available"}
+	}
+	name, password := acl.DefaultUser, args[0].Text
+	if len(args) == 2 {
+		name, password = args[0].Text, args[1].Text
+	} else if aclCtx.Users.NoPass(acl.DefaultUser) {
+		return "", resp.Value{Type: resp.Error, Text: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
+	}
+	if !aclCtx.Users.Authenticate(name, password, client) {
+		return "", resp.Value{Type: resp.Error, Text: "WRONGPASS invalid username-password pair or user is disabled."}
+	}
+	return name, resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+// CheckACL returns the NOPERM error when user may not run the command, and
+// nil when it may. AUTH and ACL WHOAMI are always allowed.
+func CheckACL(user, client, name string, args []resp.Value) *resp.Value {
+	name = strings.ToUpper(name)
+	if aclCtx == nil || aclCtx.Users == nil || name == "AUTH" {
+		return nil
+	}
+	if name == "ACL" && len(args) == 1 && strings.EqualFold(args[0].Text, "WHOAMI") {
+		return nil
+	}
+	r := acl.Request{
+		Command:    strings.ToLower(name),
+		Categories: CommandCategories(name),
+		Keys:       CommandKeys(name, args),
+	}
+	if len(args) > 0 {
+		r.Subcommand = strings.ToLower(args[0].Text)
+	}
+	switch name {
+	case "MIGRATE":
+		if opts, errReply := parseMigrate(args); errReply == nil {
+			r.Keys = opts.keys
+		}
+	case "PUBLISH":
+		r.Channels = textArgs(args[:min(1, len(args))])
+	case "SUBSCRIBE":
+		r.Channels = textArgs(args)
+	}
+	if denied := aclCtx.Users.Check(user, client, r); denied != nil {
+		return &resp.Value{Type: resp.Error, Text: denied.Error()}
+	}
+	return nil
+}
+
+var errNoACLFile = resp.Value{Type: resp.Error, Text: "ERR This instance is not configured to use an ACL file. Set server.aclfile to store users in one"}
+
+func cmdACL(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return wrongArity("acl")
+	}
+	if aclCtx == nil || aclCtx.Users == nil {
+		return resp.Value{Type: resp.Error, Text: "ERR ACL is not available"}
+	}
+	users := aclCtx.Users
+	sub := strings.ToUpper(args[0].Text)
+	rest := args[1:]
+	switch sub {
+	case "SETUSER":
+		if len(rest) < 1 {
+			return wrongArity("acl|setuser")
+		}
+		if err := users.SetUser(rest[0].Text, textArgs(rest[1:])...); err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR Error in ACL SETUSER " + err.Error()}
+		}
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	case "GETUSER":
+		if len(rest) != 1 {
+			return wrongArity("acl|getuser")
+		}
+		user, ok := users.Get(rest[0].Text)
+		if !ok {
+			return resp.Value{Type: resp.BulkString, IsNil: true}
+		}
+		return aclUserReply(user)
+	case "DELUSER":
+		if len(rest) < 1 {
+			return wrongArity("acl|deluser")
+		}
+		n, err := users.DelUser(textArgs(rest)...)
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR " + err.Error()}
+		}
+		return resp.Value{Type: resp.Integer, Number: int64(n)}
+	case "USERS":
+		return bulkArray(users.Names())
+	case "LIST":
+		return bulkArray(users.List())
+	case "WHOAMI":
+		// Connections answer WHOAMI themselves; commands run without one,
+		// as when the AOF is replayed, run as the default user.
+		return resp.Value{Type: resp.BulkString, Text: acl.DefaultUser}
+	case "CAT":
+		return aclCat(rest)
+	case "LOG":
+		return aclLog(rest)
+	case "SAVE", "LOAD":
+		file := config.Global.GetACLFile()
+		if file == "" {
+			return errNoACLFile
+		}
+		var err error
+		if sub == "SAVE" {
+			err = users.Save(file)
+		} else {
+			err = users.Load(file)
+		}
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR " + err.Error()}
+		}
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + args[0].Text + "'. Try ACL SETUSER, GETUSER, DELUSER, USERS, LIST, WHOAMI, CAT, LOG, SAVE or LOAD."}
+}
+
+func aclUserReply(user *acl.User) resp.Value {
+	flags := []string{"off"}
+	if user.Enabled {
+		flags[0] = "on"
+	}
+	if user.NoPass {
+		flags = append(flags, "nopass")
+	}
+	prefixed := func(prefix string, patterns []string) string {
+		parts := make([]string, len(patterns))
+		for i, p := range patterns {
+			parts[i] = prefix + p
+		}
+		return strings.Join(parts, " ")
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: "flags"}, bulkArray(flags),
+		{Type: resp.BulkString, Text: "passwords"}, bulkArray(user.Passwords),
+		{Type: resp.BulkString, Text: "commands"}, {Type: resp.BulkString, Text: strings.Join(user.Commands, " ")},
+		{Type: resp.BulkString, Text: "keys"}, {Type: resp.BulkString, Text: prefixed("~", user.Keys)},
+		{Type: resp.BulkString, Text: "channels"}, {Type: resp.BulkString, Text: prefixed("&", user.Channels)},
+	}}
+}
+
+func aclCat(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return bulkArray(acl.Categories)
+	}
+	if len(args) > 1 {
+		return wrongArity("acl|cat")
+	}
+	category := strings.ToLower(args[0].Text)
+	if !slices.Contains(acl.Categories, category) {
+		return resp.Value{Type: resp.Error, Text: "ERR Unknown category '" + args[0].Text + "'"}
+	}
+	var names []string
+	for _, name := range append(slices.Collect(maps.Keys(registry)), serverCommands...) {
+		if category == "all" || slices.Contains(CommandCategories(name), category) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	return bulkArray(names)
+}
+
+func aclLog(args []resp.Value) resp.Value {
+	n := 10
+	if len(args) > 1 {
+		return wrongArity("acl|log")
+	}
+	if len(args) == 1 {
+		if strings.EqualFold(args[0].Text, "RESET") {
+			aclCtx.Users.ResetLog()
+			return resp.Value{Type: resp.SimpleString, Text: "OK"}
+		}
+		v, err := strconv.Atoi(args[0].Text)
+		if err != nil || v < 0 {
+			return resp.Value{Type: resp.Error, Text: "ERR value is out of range, must be positive"}
+		}
+		n = v
+	}
+	now := time.Now()
+	items := []resp.Value{}
+	for _, e := range aclCtx.Users.Log(n) {
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: "count"}, {Type: resp.Integer, Number: int64(e.Count)},
+			{Type: resp.BulkString, Text: "reason"}, {Type: resp.BulkString, Text: e.Reason},
+			{Type: resp.BulkString, Text: "context"}, {Type: resp.BulkString, Text: "toplevel"},
+			{Type: resp.BulkString, Text: "object"}, {Type: resp.BulkString, Text: e.Object},
+			{Type: resp.BulkString, Text: "username"}, {Type: resp.BulkString, Text: e.Username},
+			{Type: resp.BulkString, Text: "age-seconds"}, {Type: resp.BulkString, Text: fmt.Sprintf("%.3f", now.Sub(e.Created).Seconds())},
+			{Type: resp.BulkString, Text: "client-info"}, {Type: resp.BulkString, Text: "addr=" + e.Client},
+			{Type: resp.BulkString, Text: "entry-id"}, {Type: resp.Integer, Number: e.ID},
+			{Type: resp.BulkString, Text: "timestamp-created"}, {Type: resp.Integer, Number: e.Created.UnixMilli()},
+			{Type: resp.BulkString, Text: "timestamp-last-updated"}, {Type: resp.Integer, Number: e.Updated.UnixMilli()},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
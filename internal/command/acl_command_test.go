@@ -0,0 +1,144 @@
+package command
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/acl"
+	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func TestCommandCategories(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"get", []string{"read", "string"}},
+		{"SET", []string{"string", "write"}},
+		{"BGSAVE", []string{"admin", "dangerous"}},
+		{"KEYS", []string{"dangerous", "keyspace", "read"}},
+		{"JSON.GET", []string{"json", "read"}},
+		{"TS.MRANGE", []string{"read", "timeseries"}},
+		{"PUBLISH", []string{"pubsub"}},
+		{"BITFIELD_RO", []string{"bitmap", "read"}},
+	}
+	for _, tt := range tests {
+		if got := CommandCategories(tt.cmd); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.cmd, tt.want, got)
+		}
+	}
+
+	Init(&DB{})
+	for name := range registry {
+		if len(CommandCategories(name)) == 0 {
+			t.Errorf("Expected %s in an ACL category", name)
+		}
+	}
+}
+
+func TestAuthAndCheckACL(t *testing.T) {
+	SetACLContext(&ACLContext{Users: acl.New("secret", nil)})
+	defer SetACLContext(nil)
+
+	if res := cmdACL(bulkArgs("SETUSER", "app", "on", ">pw", "~app:*", "&events", "+@read", "+@string", "-set")); res.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", res)
+	}
+	if user, res := Auth(bulkArgs("secret"), "c"); user != acl.DefaultUser || res.Text != "OK" {
+		t.Errorf("Expected one-argument AUTH to log in default, got %q %v", user, res)
+	}
+	if user, res := Auth(bulkArgs("app", "wrong"), "c"); user != "" || !strings.HasPrefix(res.Text, "WRONGPASS") {
+		t.Errorf("Expected WRONGPASS, got %q %v", user, res)
+	}
+	if user, _ := Auth(bulkArgs("app", "pw"), "c"); user != "app" {
+		t.Fatalf("Expected app logged in, got %q", user)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "app:1"}, ""},
+		{[]string{"INCR", "app:1"}, ""},
+		{[]string{"SET", "app:1", "v"}, "NOPERM User app has no permissions to run the 'set' command"},
+		{[]string{"GET", "other"}, "NOPERM No permissions to access a key"},
+		{[]string{"BGSAVE"}, "NOPERM User app has no permissions to run the 'bgsave' command"},
+		{[]string{"PUBLISH", "other", "m"}, "NOPERM User app has no permissions to run the 'publish' command"},
+		{[]string{"AUTH", "app", "pw"}, ""},
+		{[]string{"ACL", "WHOAMI"}, ""},
+		{[]string{"ACL", "LIST"}, "NOPERM User app has no permissions to run the 'acl' command"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if v := CheckACL("app", "c", tt.args[0], bulkArgs(tt.args[1:]...)); v != nil {
+			got = v.Text
+		}
+		if got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.args, tt.want, got)
+		}
+	}
+	cmdACL(bulkArgs("SETUSER", "app", "+publish"))
+	if v := CheckACL("app", "c", "PUBLISH", bulkArgs("other", "m")); v == nil || !strings.Contains(v.Text, "channel") {
+		t.Errorf("Expected a channel denial, got %v", v)
+	}
+	if v := CheckACL("app", "c", "PUBLISH", bulkArgs("events", "m")); v != nil {
+		t.Errorf("Expected events allowed, got %v", v)
+	}
+
+	log := cmdACL(bulkArgs("LOG"))
+	if len(log.Items) == 0 || log.Items[0].Items[1].Number != 1 {
+		t.Fatalf("Expected denials logged, got %v", log)
+	}
+	if res := cmdACL(bulkArgs("LOG", "RESET")); res.Text != "OK" || len(cmdACL(bulkArgs("LOG")).Items) != 0 {
+		t.Errorf("Expected the log reset, got %v", res)
+	}
+}
+
+func TestCmdACL(t *testing.T) {
+	SetACLContext(&ACLContext{Users: acl.New("", nil)})
+	defer SetACLContext(nil)
+	saved := config.Global
+	config.Global = &config.Config{}
+	defer func() { config.Global = saved }()
+
+	if res := cmdACL(bulkArgs("SETUSER", "bob", "on", "bogus")); res.Type != resp.Error {
+		t.Errorf("Expected an error for a bad rule, got %v", res)
+	}
+	cmdACL(bulkArgs("SETUSER", "bob", "on", "nopass", "allkeys", "+@all", "-@dangerous"))
+	res := cmdACL(bulkArgs("GETUSER", "bob"))
+	if len(res.Items) != 10 || res.Items[5].Text != "+@all -@dangerous" || res.Items[7].Text != "~*" {
+		t.Errorf("Expected bob's rules, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("GETUSER", "nobody")); !res.IsNil {
+		t.Errorf("Expected nil for a missing user, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("USERS")); len(res.Items) != 2 || res.Items[0].Text != "bob" {
+		t.Errorf("Expected bob and default, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("LIST")); len(res.Items) != 2 || !strings.HasPrefix(res.Items[0].Text, "user bob on nopass ~* resetchannels +@all") {
+		t.Errorf("Expected the users as ACL lines, got %v", res)
+	}
+	InitGeoCommands()
+	if res := cmdACL(bulkArgs("CAT", "geo")); len(res.Items) != 6 || res.Items[0].Text != "geoadd" {
+		t.Errorf("Expected the geo commands, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("DELUSER", "default")); res.Type != resp.Error {
+		t.Errorf("Expected default not to be deletable, got %v", res)
+	}
+
+	if res := cmdACL(bulkArgs("SAVE")); res.Type != resp.Error {
+		t.Errorf("Expected SAVE without an ACL file to fail, got %v", res)
+	}
+	config.Global.Server.ACLFile = filepath.Join(t.TempDir(), "users.acl")
+	if res := cmdACL(bulkArgs("SAVE")); res.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("DELUSER", "bob", "nobody")); res.Number != 1 {
+		t.Errorf("Expected one user deleted, got %v", res)
+	}
+	if res := cmdACL(bulkArgs("LOAD")); res.Text != "OK" || !aclCtx.Users.Exists("bob") {
+		t.Errorf("Expected bob loaded back, got %v", res)
+	}
+}
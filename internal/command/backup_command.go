@@ -0,0 +1,111 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/backup"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type BackupContext struct {
+	Backups *backup.Manager
+	DB      *DB
+}
+
+var backupCtx *BackupContext
+
+func SetBackupContext(c *BackupContext) { backupCtx = c }
+
+func InitBackupCommands() {
+	Register("BACKUP", cmdBackup)
+}
+
+// BackupEnabled reports whether backups are written.
+func BackupEnabled() bool {
+	return backupCtx != nil && backupCtx.Backups != nil
+}
+
+var errBackupDisabled = resp.Value{Type: resp.Error, Text: "ERR This instance has backups disabled"}
+
+func cmdBackup(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return wrongArity("backup")
+	}
+	if !BackupEnabled() {
+		return errBackupDisabled
+	}
+	switch strings.ToUpper(args[0].Text) {
+	case "LIST":
+		if len(args) != 1 {
+			return wrongArity("backup|list")
+		}
+		return backupList()
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + args[0].Text + "'. Try BACKUP LIST."}
+}
+
+func backupList() resp.Value {
+	backups, err := backup.List(backupCtx.Backups.Dir())
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "ERR " + err.Error()}
+	}
+	items := []resp.Value{}
+	for _, b := range backups {
+		if !b.Complete {
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: "name"},
+			{Type: resp.BulkString, Text: b.Name},
+			{Type: resp.BulkString, Text: "time"},
+			{Type: resp.Integer, Number: b.Time.Unix()},
+			{Type: resp.BulkString, Text: "rdb_bytes"},
+			{Type: resp.Integer, Number: b.RDBSize},
+			{Type: resp.BulkString, Text: "aof_bytes"},
+			{Type: resp.Integer, Number: b.AOFSize},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+// RunBackup writes a backup of the dataset as it is now. pause must run its
+// function while no write command is.
+func RunBackup(pause func(fn func())) error {
+	if !BackupEnabled() {
+		return nil
+	}
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	var snapshot *BackgroundSnapshot
+	var pending *backup.Pending
+	var err error
+	pause(func() {
+		if pending, err = backupCtx.Backups.Begin(time.Now()); err == nil {
+			snapshot = backupCtx.DB.BeginSnapshot()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	err = pending.Finish(snapshot.Write)
+	snapshot.Close()
+	return err
+}
+
+func backupInfo(m *backup.Manager) []string {
+	lines := []string{"backup_dir:" + m.Dir()}
+	backups, err := backup.List(m.Dir())
+	if err != nil {
+		return lines
+	}
+	count, last := 0, int64(0)
+	for _, b := range backups {
+		if b.Complete {
+			count, last = count+1, b.Time.Unix()
+		}
+	}
+	return append(lines, "backup_count:"+strconv.Itoa(count), "backup_last_time:"+strconv.FormatInt(last, 10))
+}
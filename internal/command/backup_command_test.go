@@ -0,0 +1,48 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/backup"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func TestCmdBackupList(t *testing.T) {
+	SetBackupContext(&BackupContext{})
+	if res := cmdBackup([]resp.Value{{Type: resp.BulkString, Text: "LIST"}}); res.Type != resp.Error {
+		t.Errorf("Expected an error with backups disabled, got %v", res)
+	}
+
+	manager, err := backup.New(backup.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("backup.New failed: %v", err)
+	}
+	defer manager.Close()
+	dict := datastructure.CreateDict()
+	dict.Set("key1", "value1", 0)
+	db := &DB{
+		Dict: dict,
+		Set:  datastructure.CreateSet(),
+		List: datastructure.CreateList(),
+		Hash: datastructure.CreateHashMap(),
+	}
+	SetBackupContext(&BackupContext{Backups: manager, DB: db})
+	defer SetBackupContext(nil)
+
+	if err := RunBackup(func(fn func()) { fn() }); err != nil {
+		t.Fatalf("RunBackup failed: %v", err)
+	}
+	res := cmdBackup([]resp.Value{{Type: resp.BulkString, Text: "list"}})
+	if res.Type != resp.Array || len(res.Items) != 1 {
+		t.Fatalf("Expected one backup listed, got %v", res)
+	}
+	fields := res.Items[0].Items
+	if len(fields) != 8 || fields[0].Text != "name" || fields[5].Number <= 0 {
+		t.Errorf("Expected name, time and sizes, got %v", fields)
+	}
+
+	if res := cmdBackup([]resp.Value{{Type: resp.BulkString, Text: "LIST"}, {Type: resp.BulkString, Text: "x"}}); res.Type != resp.Error {
+		t.Errorf("Expected an arity error, got %v", res)
+	}
+}
@@ -0,0 +1,329 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func InitBitmapCommands() {
+	Register("SETBIT", cmdSetbit)
+	Register("GETBIT", cmdGetbit)
+	Register("BITCOUNT", cmdBitcount)
+	Register("BITPOS", cmdBitpos)
+	Register("BITOP", cmdBitop)
+	Register("BITFIELD", cmdBitfield)
+	Register("BITFIELD_RO", cmdBitfieldRo)
+}
+
+func parseBitOffset(s string) (uint64, bool) {
+	offset, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || offset > datastructure.MaxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func parseBit(s string) (byte, bool) {
+	switch s {
+	case "0":
+		return 0, true
+	case "1":
+		return 1, true
+	}
+	return 0, false
+}
+
+func parseBitRange(args []resp.Value, allowStartOnly bool) (*datastructure.BitRange, bool) {
+	if len(args) == 0 {
+		return nil, true
+	}
+	if len(args) == 1 && !allowStartOnly {
+		return nil, false
+	}
+	if len(args) > 3 {
+		return nil, false
+	}
+
+	r := &datastructure.BitRange{}
+	start, err := strconv.ParseInt(args[0].Text, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	r.Start = start
+	if len(args) > 1 {
+		end, err := strconv.ParseInt(args[1].Text, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		r.End = end
+		r.HasEnd = true
+	}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2].Text) {
+		case "BYTE":
+		case "BIT":
+			r.Bit = true
+		default:
+			return nil, false
+		}
+	}
+	return r, true
+}
+
+func cmdSetbit(args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'setbit'"}
+	}
+	key := args[0].Text
+	offset, ok := parseBitOffset(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR bit offset is not an integer or out of range"}
+	}
+	bit, ok := parseBit(args[2].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR bit is not an integer or out of range"}
+	}
+	old := dictCtx.Dict.SetBit(key, offset, bit)
+	if dictCtx.AOF != nil {
+		_ = dictCtx.AOF.Append(resp.Value{
+			Type: resp.Array,
+			Items: []resp.Value{
+				{Type: resp.BulkString, Text: "SETBIT"},
+				{Type: resp.BulkString, Text: key},
+				{Type: resp.BulkString, Text: args[1].Text},
+				{Type: resp.BulkString, Text: args[2].Text},
+			},
+		})
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(old)}
+}
+
+func cmdGetbit(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'getbit'"}
+	}
+	offset, ok := parseBitOffset(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR bit offset is not an integer or out of range"}
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(dictCtx.Dict.GetBit(args[0].Text, offset))}
+}
+
+func cmdBitcount(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'bitcount'"}
+	}
+	r, ok := parseBitRange(args[1:], false)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+	return resp.Value{Type: resp.Integer, Number: dictCtx.Dict.BitCount(args[0].Text, r)}
+}
+
+func cmdBitpos(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'bitpos'"}
+	}
+	bit, ok := parseBit(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR The bit argument must be 1 or 0."}
+	}
+	r, ok := parseBitRange(args[2:], true)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+	return resp.Value{Type: resp.Integer, Number: dictCtx.Dict.BitPos(args[0].Text, bit, r)}
+}
+
+func cmdBitop(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'bitop'"}
+	}
+	op := strings.ToUpper(args[0].Text)
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return resp.Value{Type: resp.Error, Text: "ERR BITOP NOT must be called with a single source key."}
+		}
+	default:
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+	dest := args[1].Text
+	keys := make([]string, 0, len(args)-2)
+	for _, a := range args[2:] {
+		keys = append(keys, a.Text)
+	}
+	n := dictCtx.Dict.BitOp(op, dest, keys...)
+	if dictCtx.AOF != nil {
+		arr := []resp.Value{{Type: resp.BulkString, Text: "BITOP"}, {Type: resp.BulkString, Text: op}, {Type: resp.BulkString, Text: dest}}
+		for _, k := range keys {
+			arr = append(arr, resp.Value{Type: resp.BulkString, Text: k})
+		}
+		_ = dictCtx.AOF.Append(resp.Value{Type: resp.Array, Items: arr})
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func parseBitfieldType(s string) (bool, uint, bool) {
+	if len(s) < 2 {
+		return false, 0, false
+	}
+	signed := false
+	switch s[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, false
+	}
+	width, err := strconv.ParseUint(s[1:], 10, 8)
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, false
+	}
+	return signed, uint(width), true
+}
+
+func parseBitfieldOffset(s string, width uint) (uint64, bool) {
+	multiply := strings.HasPrefix(s, "#")
+	if multiply {
+		s = s[1:]
+	}
+	offset, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if multiply {
+		if offset > datastructure.MaxBitOffset/uint64(width) {
+			return 0, false
+		}
+		offset *= uint64(width)
+	}
+	if offset+uint64(width)-1 > datastructure.MaxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func parseBitfieldOps(args []resp.Value, readOnly bool) ([]datastructure.BitFieldOp, *resp.Value) {
+	ops := []datastructure.BitFieldOp{}
+	overflow := datastructure.OverflowWrap
+	for i := 0; i < len(args); {
+		sub := strings.ToUpper(args[i].Text)
+		if sub == "OVERFLOW" {
+			if readOnly {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR BITFIELD_RO only supports the GET subcommand"}
+			}
+			if i+1 >= len(args) {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+			}
+			switch strings.ToUpper(args[i+1].Text) {
+			case "WRAP":
+				overflow = datastructure.OverflowWrap
+			case "SAT":
+				overflow = datastructure.OverflowSat
+			case "FAIL":
+				overflow = datastructure.OverflowFail
+			default:
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR Invalid OVERFLOW type specified"}
+			}
+			i += 2
+			continue
+		}
+
+		op := datastructure.BitFieldOp{Overflow: overflow}
+		argc := 3
+		switch sub {
+		case "GET":
+			op.Kind = datastructure.BitFieldGet
+		case "SET":
+			op.Kind = datastructure.BitFieldSet
+			argc = 4
+		case "INCRBY":
+			op.Kind = datastructure.BitFieldIncr
+			argc = 4
+		default:
+			return nil, &resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+		if readOnly && op.Kind != datastructure.BitFieldGet {
+			return nil, &resp.Value{Type: resp.Error, Text: "ERR BITFIELD_RO only supports the GET subcommand"}
+		}
+		if i+argc > len(args) {
+			return nil, &resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+
+		signed, width, ok := parseBitfieldType(args[i+1].Text)
+		if !ok {
+			return nil, &resp.Value{Type: resp.Error, Text: "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."}
+		}
+		offset, ok := parseBitfieldOffset(args[i+2].Text, width)
+		if !ok {
+			return nil, &resp.Value{Type: resp.Error, Text: "ERR bit offset is not an integer or out of range"}
+		}
+		op.Signed, op.Bits, op.Offset = signed, width, offset
+		if argc == 4 {
+			value, err := strconv.ParseInt(args[i+3].Text, 10, 64)
+			if err != nil {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+			}
+			op.Value = value
+		}
+		ops = append(ops, op)
+		i += argc
+	}
+	return ops, nil
+}
+
+func bitfieldResults(results []datastructure.BitFieldResult) resp.Value {
+	items := make([]resp.Value, 0, len(results))
+	for _, r := range results {
+		if r.Nil {
+			items = append(items, resp.Value{Type: resp.BulkString, IsNil: true})
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.Integer, Number: r.Value})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdBitfield(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'bitfield'"}
+	}
+	key := args[0].Text
+	ops, errVal := parseBitfieldOps(args[1:], false)
+	if errVal != nil {
+		return *errVal
+	}
+	results := dictCtx.Dict.BitField(key, ops)
+
+	written := false
+	for i, op := range ops {
+		if op.Kind != datastructure.BitFieldGet && !results[i].Nil {
+			written = true
+			break
+		}
+	}
+	if dictCtx.AOF != nil && written {
+		arr := []resp.Value{{Type: resp.BulkString, Text: "BITFIELD"}}
+		for _, a := range args {
+			arr = append(arr, resp.Value{Type: resp.BulkString, Text: a.Text})
+		}
+		_ = dictCtx.AOF.Append(resp.Value{Type: resp.Array, Items: arr})
+	}
+	return bitfieldResults(results)
+}
+
+func cmdBitfieldRo(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'bitfield_ro'"}
+	}
+	ops, errVal := parseBitfieldOps(args[1:], true)
+	if errVal != nil {
+		return *errVal
+	}
+	return bitfieldResults(dictCtx.Dict.BitField(args[0].Text, ops))
+}
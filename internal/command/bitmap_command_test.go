@@ -0,0 +1,159 @@
+package command
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func bulkArgs(texts ...string) []resp.Value {
+	args := make([]resp.Value, len(texts))
+	for i, t := range texts {
+		args[i] = resp.Value{Type: resp.BulkString, Text: t}
+	}
+	return args
+}
+
+// openTestAOF opens a multi-part AOF, with its base in command form, in a
+// temporary directory.
+func openTestAOF(t *testing.T) *persistence.AOF {
+	t.Helper()
+	dir := t.TempDir()
+	aof, err := persistence.OpenMultiPartAOF(dir, filepath.Join(dir, "appendonly.aof"), false, true)
+	if err != nil {
+		t.Fatalf("OpenMultiPartAOF failed: %v", err)
+	}
+	t.Cleanup(func() { aof.Close() })
+	return aof
+}
+
+// rewriteAOF replaces everything aof holds with snapshot.
+func rewriteAOF(t *testing.T, aof *persistence.AOF, snapshot persistence.Snapshot) {
+	t.Helper()
+	rewrite, err := aof.BeginRewrite()
+	if err != nil {
+		t.Fatalf("BeginRewrite failed: %v", err)
+	}
+	err = rewrite.Finish(func(emit func(persistence.Snapshot) error) error {
+		return emit(snapshot)
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+}
+
+func TestCmdSetbitGetbit(t *testing.T) {
+	setupDictTest()
+
+	result := cmdSetbit(bulkArgs("bm", "7", "1"))
+	if result.Type != resp.Integer || result.Number != 0 {
+		t.Errorf("Expected 0, got %v", result)
+	}
+	result = cmdGetbit(bulkArgs("bm", "7"))
+	if result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+
+	result = cmdSetbit(bulkArgs("bm", "7", "2"))
+	if result.Type != resp.Error {
+		t.Error("Expected error for invalid bit")
+	}
+	result = cmdSetbit(bulkArgs("bm", "4294967296", "1"))
+	if result.Type != resp.Error {
+		t.Error("Expected error for out of range offset")
+	}
+}
+
+func TestCmdBitcountBitpos(t *testing.T) {
+	dict := setupDictTest()
+	dict.Set("k", "foobar", 0)
+
+	if result := cmdBitcount(bulkArgs("k")); result.Number != 26 {
+		t.Errorf("Expected 26, got %d", result.Number)
+	}
+	if result := cmdBitcount(bulkArgs("k", "5", "30", "BIT")); result.Number != 17 {
+		t.Errorf("Expected 17, got %d", result.Number)
+	}
+	if result := cmdBitcount(bulkArgs("k", "1")); result.Type != resp.Error {
+		t.Error("Expected syntax error for start without end")
+	}
+
+	dict.Set("p", "\x00\xff", 0)
+	if result := cmdBitpos(bulkArgs("p", "1")); result.Number != 8 {
+		t.Errorf("Expected 8, got %d", result.Number)
+	}
+	if result := cmdBitpos(bulkArgs("p", "1", "0", "0")); result.Number != -1 {
+		t.Errorf("Expected -1, got %d", result.Number)
+	}
+}
+
+func TestCmdBitop(t *testing.T) {
+	dict := setupDictTest()
+	dict.Set("a", "\x0f", 0)
+	dict.Set("b", "\xf1", 0)
+
+	if result := cmdBitop(bulkArgs("XOR", "dest", "a", "b")); result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+	if val, _ := dict.Get("dest"); val != "\xfe" {
+		t.Errorf("Expected \\xfe, got %q", val)
+	}
+	if result := cmdBitop(bulkArgs("NOT", "dest", "a", "b")); result.Type != resp.Error {
+		t.Error("Expected error for NOT with multiple sources")
+	}
+	if result := cmdBitop(bulkArgs("NAND", "dest", "a")); result.Type != resp.Error {
+		t.Error("Expected error for unknown op")
+	}
+}
+
+func TestCmdBitfield(t *testing.T) {
+	setupDictTest()
+
+	result := cmdBitfield(bulkArgs("bf", "SET", "u8", "#1", "200", "GET", "u8", "8", "OVERFLOW", "FAIL", "INCRBY", "u8", "#1", "100"))
+	if result.Type != resp.Array || len(result.Items) != 3 {
+		t.Fatalf("Expected 3 results, got %v", result)
+	}
+	if result.Items[0].Number != 0 || result.Items[1].Number != 200 || !result.Items[2].IsNil {
+		t.Errorf("Unexpected results %v", result.Items)
+	}
+
+	if result := cmdBitfield(bulkArgs("bf", "GET", "u64", "0")); result.Type != resp.Error {
+		t.Error("Expected error for u64")
+	}
+	if result := cmdBitfieldRo(bulkArgs("bf", "SET", "u8", "0", "1")); result.Type != resp.Error {
+		t.Error("Expected error for SET in BITFIELD_RO")
+	}
+	if result := cmdBitfieldRo(bulkArgs("bf", "GET", "i8", "8")); result.Items[0].Number != -56 {
+		t.Errorf("Expected -56, got %d", result.Items[0].Number)
+	}
+}
+
+func TestBitmapAOFReplay(t *testing.T) {
+	aof := openTestAOF(t)
+
+	dict := datastructure.CreateDict()
+	SetDictContext(&DictContext{Dict: dict, AOF: aof})
+	cmdSetbit(bulkArgs("bm", "3", "1"))
+	cmdBitfield(bulkArgs("bm", "INCRBY", "u8", "8", "5"))
+	cmdBitfield(bulkArgs("bm", "GET", "u8", "8"))
+	cmdBitop(bulkArgs("NOT", "inv", "bm"))
+	want, _ := dict.Get("inv")
+
+	replayed := datastructure.CreateDict()
+	Init(&DB{Dict: replayed, Set: datastructure.CreateSet(), List: datastructure.CreateList(), Hash: datastructure.CreateHashMap(), AOF: aof})
+	count := 0
+	aof.LoadAll(nil, func(cmd string, args []resp.Value) {
+		count++
+		Replay(cmd, args)
+	})
+
+	if count != 3 {
+		t.Errorf("Expected 3 logged commands, got %d", count)
+	}
+	if got, _ := replayed.Get("inv"); got != want {
+		t.Errorf("Expected %q after replay, got %q", want, got)
+	}
+}
@@ -0,0 +1,279 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/cluster"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type ClusterContext struct {
+	Cluster *cluster.Cluster
+	DB      *DB
+}
+
+var clusterCtx *ClusterContext
+
+func SetClusterContext(c *ClusterContext) { clusterCtx = c }
+
+func InitClusterCommands() {
+	Register("CLUSTER", cmdCluster)
+	Register("ASKING", cmdAsking)
+	Register("MIGRATE", cmdMigrate)
+	Register("RESTORE-ASKING", cmdRestoreAsking)
+	Register("DUMP", cmdDump)
+	Register("RESTORE", cmdRestore)
+}
+
+// ClusterEnabled reports whether the server runs in cluster mode.
+func ClusterEnabled() bool {
+	return clusterCtx != nil && clusterCtx.Cluster != nil
+}
+
+func cmdAsking(args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return wrongArity("asking")
+	}
+	if !ClusterEnabled() {
+		return errClusterDisabled
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+var errClusterDisabled = resp.Value{Type: resp.Error, Text: "ERR This instance has cluster support disabled"}
+
+func cmdCluster(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return wrongArity("cluster")
+	}
+	if !ClusterEnabled() {
+		return errClusterDisabled
+	}
+	c := clusterCtx.Cluster
+	name := args[0].Text
+	sub := strings.ToUpper(name)
+	args = args[1:]
+
+	switch sub {
+	case "INFO":
+		return resp.Value{Type: resp.BulkString, Text: strings.Join(c.Info(), "\r\n") + "\r\n"}
+	case "MYID":
+		return resp.Value{Type: resp.BulkString, Text: c.MyID()}
+	case "NODES":
+		return resp.Value{Type: resp.BulkString, Text: c.Nodes()}
+	case "SLOTS":
+		return clusterSlots(c.SlotRanges())
+	case "SHARDS":
+		return clusterShards(c.Shards())
+	case "KEYSLOT":
+		if len(args) != 1 {
+			return wrongArity("cluster|keyslot")
+		}
+		return resp.Value{Type: resp.Integer, Number: int64(cluster.KeySlot(args[0].Text))}
+	case "COUNTKEYSINSLOT":
+		if len(args) != 1 {
+			return wrongArity("cluster|countkeysinslot")
+		}
+		slot, ok := parseSlot(args[0].Text)
+		if !ok {
+			return errInvalidSlot
+		}
+		return resp.Value{Type: resp.Integer, Number: int64(len(keysInSlot(slot, -1)))}
+	case "GETKEYSINSLOT":
+		if len(args) != 2 {
+			return wrongArity("cluster|getkeysinslot")
+		}
+		slot, ok := parseSlot(args[0].Text)
+		if !ok {
+			return errInvalidSlot
+		}
+		count, err := strconv.Atoi(args[1].Text)
+		if err != nil || count < 0 {
+			return resp.Value{Type: resp.Error, Text: "ERR Invalid number of keys"}
+		}
+		keys := keysInSlot(slot, count)
+		items := make([]resp.Value, len(keys))
+		for i, key := range keys {
+			items[i] = resp.Value{Type: resp.BulkString, Text: key}
+		}
+		return resp.Value{Type: resp.Array, Items: items}
+	case "ADDSLOTS", "DELSLOTS":
+		if len(args) == 0 {
+			return wrongArity("cluster|" + strings.ToLower(sub))
+		}
+		slots := make([]int, 0, len(args))
+		for _, a := range args {
+			slot, ok := parseSlot(a.Text)
+			if !ok {
+				return errInvalidSlot
+			}
+			slots = append(slots, slot)
+		}
+		if sub == "ADDSLOTS" {
+			return okOrError(c.AddSlots(slots))
+		}
+		return okOrError(c.DelSlots(slots))
+	case "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return wrongArity("cluster|" + strings.ToLower(sub))
+		}
+		var slots []int
+		for i := 0; i < len(args); i += 2 {
+			start, ok1 := parseSlot(args[i].Text)
+			end, ok2 := parseSlot(args[i+1].Text)
+			if !ok1 || !ok2 {
+				return errInvalidSlot
+			}
+			if start > end {
+				return resp.Value{Type: resp.Error, Text: "ERR start slot number " + args[i].Text + " is greater than end slot number " + args[i+1].Text}
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+		if sub == "ADDSLOTSRANGE" {
+			return okOrError(c.AddSlots(slots))
+		}
+		return okOrError(c.DelSlots(slots))
+	case "SETSLOT":
+		if len(args) < 2 {
+			return wrongArity("cluster|setslot")
+		}
+		slot, ok := parseSlot(args[0].Text)
+		if !ok {
+			return errInvalidSlot
+		}
+		action := strings.ToUpper(args[1].Text)
+		nodeID := ""
+		if action != "STABLE" {
+			if len(args) != 3 {
+				return resp.Value{Type: resp.Error, Text: "ERR Invalid CLUSTER SETSLOT action or number of arguments"}
+			}
+			nodeID = args[2].Text
+		}
+		hasKeys := func(slot int) bool { return len(keysInSlot(slot, 1)) > 0 }
+		return okOrError(c.SetSlot(slot, action, nodeID, hasKeys))
+	case "MEET":
+		if len(args) != 2 && len(args) != 3 {
+			return wrongArity("cluster|meet")
+		}
+		port, err := strconv.Atoi(args[1].Text)
+		if err != nil || port <= 0 || port > 65535 {
+			return resp.Value{Type: resp.Error, Text: "ERR Invalid node address specified: " + args[0].Text + ":" + args[1].Text}
+		}
+		busPort := 0
+		if len(args) == 3 {
+			if busPort, err = strconv.Atoi(args[2].Text); err != nil || busPort <= 0 || busPort > 65535 {
+				return resp.Value{Type: resp.Error, Text: "ERR Invalid bus port specified: " + args[2].Text}
+			}
+		}
+		c.Meet(args[0].Text, port, busPort)
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	case "FORGET":
+		if len(args) != 1 {
+			return wrongArity("cluster|forget")
+		}
+		return okOrError(c.Forget(args[0].Text))
+	case "BUMPEPOCH":
+		bumped, epoch, err := c.BumpEpoch()
+		if err != nil {
+			return errorReply(err)
+		}
+		status := "STILL"
+		if bumped {
+			status = "BUMPED"
+		}
+		return resp.Value{Type: resp.SimpleString, Text: status + " " + strconv.FormatInt(epoch, 10)}
+	case "SET-CONFIG-EPOCH":
+		if len(args) != 1 {
+			return wrongArity("cluster|set-config-epoch")
+		}
+		epoch, err := strconv.ParseInt(args[0].Text, 10, 64)
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+		}
+		return okOrError(c.SetConfigEpoch(epoch))
+	case "SAVECONFIG":
+		return okOrError(c.SaveConfig())
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + name + "'. Try CLUSTER HELP."}
+}
+
+var errInvalidSlot = resp.Value{Type: resp.Error, Text: "ERR Invalid or out of range slot"}
+
+func parseSlot(s string) (int, bool) {
+	slot, err := strconv.Atoi(s)
+	return slot, err == nil && slot >= 0 && slot < cluster.Slots
+}
+
+func okOrError(err error) resp.Value {
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func keysInSlot(slot, limit int) []string {
+	var keys []string
+	for _, key := range clusterCtx.DB.Keys() {
+		if limit >= 0 && len(keys) >= limit {
+			break
+		}
+		if cluster.KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func nodeReply(n cluster.NodeInfo) resp.Value {
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: n.Host},
+		{Type: resp.Integer, Number: int64(n.Port)},
+		{Type: resp.BulkString, Text: n.ID},
+	}}
+}
+
+func clusterSlots(ranges []cluster.SlotRange) resp.Value {
+	items := make([]resp.Value, 0, len(ranges))
+	for _, r := range ranges {
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.Integer, Number: int64(r.Start)},
+			{Type: resp.Integer, Number: int64(r.End)},
+			nodeReply(r.Node),
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func clusterShards(shards []cluster.Shard) resp.Value {
+	bulk := func(s string) resp.Value { return resp.Value{Type: resp.BulkString, Text: s} }
+	integer := func(n int) resp.Value { return resp.Value{Type: resp.Integer, Number: int64(n)} }
+
+	items := make([]resp.Value, 0, len(shards))
+	for _, s := range shards {
+		slots := make([]resp.Value, 0, 2*len(s.Ranges))
+		for _, r := range s.Ranges {
+			slots = append(slots, integer(r.Start), integer(r.End))
+		}
+		health := "online"
+		if !s.Node.Healthy {
+			health = "fail"
+		}
+		node := resp.Value{Type: resp.Array, Items: []resp.Value{
+			bulk("id"), bulk(s.Node.ID),
+			bulk("port"), integer(s.Node.Port),
+			bulk("ip"), bulk(s.Node.Host),
+			bulk("endpoint"), bulk(s.Node.Host),
+			bulk("role"), bulk("master"),
+			bulk("replication-offset"), integer(0),
+			bulk("health"), bulk(health),
+		}}
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			bulk("slots"), {Type: resp.Array, Items: slots},
+			bulk("nodes"), {Type: resp.Array, Items: []resp.Value{node}},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
@@ -0,0 +1,218 @@
+package command
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/cluster"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupClusterTest(t *testing.T, c *cluster.Cluster) *DB {
+	t.Helper()
+	db := &DB{
+		Dict:   datastructure.CreateDict(),
+		Set:    datastructure.CreateSet(),
+		List:   datastructure.CreateList(),
+		Hash:   datastructure.CreateHashMap(),
+		ZSet:   datastructure.CreateZSet(),
+		JSON:   datastructure.CreateJSONStore(),
+		TS:     datastructure.CreateTimeSeries(),
+		Sketch: datastructure.CreateSketches(),
+	}
+	SetClusterContext(&ClusterContext{Cluster: c, DB: db})
+	return db
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		args []string
+		want []string
+	}{
+		{"get", []string{"k"}, []string{"k"}},
+		{"DEL", []string{"a", "b"}, []string{"a", "b"}},
+		{"BITOP", []string{"AND", "dest", "x", "y"}, []string{"dest", "x", "y"}},
+		{"GEOSEARCHSTORE", []string{"dst", "src", "FROMMEMBER", "m"}, []string{"dst", "src"}},
+		{"TS.MADD", []string{"a", "1", "2", "b", "1", "3"}, []string{"a", "b"}},
+		{"RESTORE-ASKING", []string{"a", "0", "p", "b", "0", "p", "REPLACE"}, []string{"a", "b"}},
+		{"TS.MRANGE", []string{"-", "+", "FILTER", "a=b"}, nil},
+		{"PING", nil, nil},
+		{"CLUSTER", []string{"INFO"}, nil},
+		{"MEMORY", []string{"USAGE", "k", "SAMPLES", "0"}, []string{"k"}},
+		{"MEMORY", []string{"STATS"}, nil},
+		{"OBJECT", []string{"ENCODING", "k"}, []string{"k"}},
+		{"SCAN", []string{"0", "MATCH", "k*"}, nil},
+	}
+	for _, tt := range tests {
+		if got := CommandKeys(tt.cmd, bulkArgs(tt.args...)); !slices.Equal(got, tt.want) {
+			t.Errorf("CommandKeys(%s %v) = %v, want %v", tt.cmd, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCmdClusterDisabled(t *testing.T) {
+	setupClusterTest(t, nil)
+	if result := cmdCluster(bulkArgs("INFO")); result.Type != resp.Error || !strings.Contains(result.Text, "cluster support disabled") {
+		t.Errorf("Expected cluster disabled error, got %v", result)
+	}
+}
+
+func TestCmdCluster(t *testing.T) {
+	c, err := cluster.New(cluster.Options{Addr: "127.0.0.1:7001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := setupClusterTest(t, c)
+	db.Dict.Set("{user}1", "a", 0)
+	db.Hash.Hset("{user}2", "f", "v")
+	slot := cluster.KeySlot("user")
+
+	result := cmdCluster(bulkArgs("KEYSLOT", "123456789"))
+	if result.Type != resp.Integer || result.Number != 12739 {
+		t.Errorf("Expected slot 12739, got %v", result)
+	}
+	result = cmdCluster(bulkArgs("COUNTKEYSINSLOT", "5474"))
+	if slot != 5474 || result.Number != 2 {
+		t.Errorf("Expected 2 keys in slot %d, got %v", slot, result)
+	}
+	result = cmdCluster(bulkArgs("GETKEYSINSLOT", "5474", "1"))
+	if len(result.Items) != 1 {
+		t.Errorf("Expected 1 key, got %v", result)
+	}
+
+	result = cmdCluster(bulkArgs("ADDSLOTSRANGE", "0", "8191"))
+	if result.Text != "OK" {
+		t.Fatalf("ADDSLOTSRANGE failed: %v", result)
+	}
+	result = cmdCluster(bulkArgs("ADDSLOTS", "100"))
+	if result.Type != resp.Error || result.Text != "ERR Slot 100 is already busy" {
+		t.Errorf("Expected busy slot error, got %v", result)
+	}
+	result = cmdCluster(bulkArgs("ADDSLOTS", "16384"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected out of range error, got %v", result)
+	}
+
+	result = cmdCluster(bulkArgs("SLOTS"))
+	if len(result.Items) != 1 || result.Items[0].Items[0].Number != 0 || result.Items[0].Items[1].Number != 8191 {
+		t.Errorf("Unexpected CLUSTER SLOTS reply: %v", result)
+	}
+	result = cmdCluster(bulkArgs("INFO"))
+	if !strings.Contains(result.Text, "cluster_state:fail") || !strings.Contains(result.Text, "cluster_slots_assigned:8192") {
+		t.Errorf("Unexpected CLUSTER INFO reply: %q", result.Text)
+	}
+	result = cmdCluster(bulkArgs("NODES"))
+	if !strings.HasPrefix(result.Text, c.MyID()+" 127.0.0.1:7001@17001 myself,master") {
+		t.Errorf("Unexpected CLUSTER NODES reply: %q", result.Text)
+	}
+	result = cmdCluster(bulkArgs("BUMPEPOCH"))
+	if result.Text != "BUMPED 1" {
+		t.Errorf("Expected BUMPED 1, got %v", result)
+	}
+	result = cmdCluster(bulkArgs("NOSUCH"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected unknown subcommand error, got %v", result)
+	}
+}
+
+// fakeTarget accepts one connection and answers every command with OK,
+// recording what it received.
+func fakeTarget(t *testing.T) (addr string, received chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received = make(chan []string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			v, err := resp.Decode(reader)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- textArgs(v.Items)
+			_, _ = conn.Write([]byte(resp.Encode(resp.Value{Type: resp.SimpleString, Text: "OK"})))
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestCmdMigrate(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	db.Dict.Set("k1", "v1", 0)
+	db.Set.Sadd("k2", "m")
+
+	result := cmdMigrate(bulkArgs("127.0.0.1", "1", "missing", "0", "100"))
+	if result.Text != "NOKEY" {
+		t.Fatalf("Expected NOKEY, got %v", result)
+	}
+
+	addr, received := fakeTarget(t)
+	host, port, _ := net.SplitHostPort(addr)
+	result = cmdMigrate(bulkArgs(host, port, "", "0", "1000", "REPLACE", "KEYS", "k1", "k2", "missing"))
+	if result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if db.Exists("k1") || db.Exists("k2") {
+		t.Error("Migrated keys should be deleted locally")
+	}
+
+	cmd := <-received
+	if len(cmd) != 8 || cmd[0] != "RESTORE-ASKING" || cmd[1] != "k1" || cmd[2] != "0" || cmd[4] != "k2" || cmd[7] != "REPLACE" {
+		t.Fatalf("Expected both keys in one RESTORE-ASKING, got %d args", len(cmd))
+	}
+	target := setupClusterTest(t, nil)
+	if result := cmdRestoreAsking(bulkArgs(cmd[1:]...)); result.Text != "OK" {
+		t.Fatalf("RESTORE-ASKING failed: %v", result)
+	}
+	if v, ok := target.Dict.Get("k1"); !ok || v != "v1" {
+		t.Errorf("Expected k1=v1 on target, got %q", v)
+	}
+	if !target.Set.Sismember("k2", "m") {
+		t.Error("Expected k2 to hold m on target")
+	}
+}
+
+func TestCmdRestoreAsking(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	db.Dict.Set("k", "old", 0)
+
+	source := datastructure.CreateDict()
+	source.Set("k", "new", 0)
+	payload, err := persistence.EncodeDump(persistence.Snapshot{DictData: source.Dump()}, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := cmdRestoreAsking(bulkArgs("fresh", "0", string(payload), "k", "0", string(payload)))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Text, "BUSYKEY") {
+		t.Errorf("Expected BUSYKEY, got %v", result)
+	}
+	if db.Exists("fresh") {
+		t.Error("Expected no key restored when one of them exists")
+	}
+	result = cmdRestoreAsking(bulkArgs("k", "0", string(payload), "REPLACE"))
+	if result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if v, _ := db.Dict.Get("k"); v != "new" {
+		t.Errorf("Expected new, got %q", v)
+	}
+	result = cmdRestoreAsking(bulkArgs("k", "0", "garbage", "REPLACE"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected payload error, got %v", result)
+	}
+}
@@ -0,0 +1,188 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/crdt"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type ActiveActiveContext struct {
+	Node *crdt.Node
+}
+
+var activeActiveCtx *ActiveActiveContext
+
+func SetActiveActiveContext(c *ActiveActiveContext) { activeActiveCtx = c }
+
+func InitActiveActiveCommands() {
+	Register("CRDT", cmdCRDT)
+}
+
+// ActiveActiveEnabled reports whether this node merges writes with peers.
+func ActiveActiveEnabled() bool {
+	return activeActiveCtx != nil && activeActiveCtx.Node != nil
+}
+
+var activeActiveWrites = map[string]Handler{
+	"SET":    aaSet,
+	"DEL":    aaDel,
+	"INCR":   aaIncr,
+	"DECR":   aaDecr,
+	"INCRBY": aaIncrBy,
+	"DECRBY": aaDecrBy,
+	"SADD":   aaSadd,
+	"SREM":   aaSrem,
+	"HSET":   aaHset,
+	"HDEL":   aaHdel,
+}
+
+// ActiveActiveHandler returns the handler for a write command in
+// active-active mode.
+func ActiveActiveHandler(name string) (Handler, bool) {
+	h, ok := activeActiveWrites[strings.ToUpper(name)]
+	return h, ok
+}
+
+func aaSet(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("set")
+	}
+	if len(args) > 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR SET options are not supported in active-active mode"}
+	}
+	activeActiveCtx.Node.Set(args[0].Text, args[1].Text)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func aaDel(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return wrongArity("del")
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(activeActiveCtx.Node.Delete(textArgs(args)...))}
+}
+
+func aaIncr(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("incr")
+	}
+	return aaIncrByDelta(args[0].Text, 1)
+}
+
+func aaDecr(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("decr")
+	}
+	return aaIncrByDelta(args[0].Text, -1)
+}
+
+func aaIncrBy(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("incrby")
+	}
+	delta, err := strconv.ParseInt(args[1].Text, 10, 64)
+	if err != nil {
+		return errorReply(crdt.ErrNotInteger)
+	}
+	return aaIncrByDelta(args[0].Text, delta)
+}
+
+func aaDecrBy(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("decrby")
+	}
+	delta, err := strconv.ParseInt(args[1].Text, 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return errorReply(crdt.ErrNotInteger)
+	}
+	return aaIncrByDelta(args[0].Text, -delta)
+}
+
+func aaIncrByDelta(key string, delta int64) resp.Value {
+	n, err := activeActiveCtx.Node.IncrBy(key, delta)
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Value{Type: resp.Integer, Number: n}
+}
+
+func aaSadd(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("sadd")
+	}
+	n := activeActiveCtx.Node.SAdd(args[0].Text, textArgs(args[1:])...)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func aaSrem(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("srem")
+	}
+	n := activeActiveCtx.Node.SRem(args[0].Text, textArgs(args[1:])...)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func aaHset(args []resp.Value) resp.Value {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return wrongArity("hset")
+	}
+	n := activeActiveCtx.Node.HSet(args[0].Text, textArgs(args[1:])...)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func aaHdel(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("hdel")
+	}
+	n := activeActiveCtx.Node.HDel(args[0].Text, textArgs(args[1:])...)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func activeActiveInfo(n *crdt.Node) []string {
+	st := n.Status()
+	lines := []string{
+		"crdt_node_id:" + st.ID,
+		"crdt_run_id:" + st.RunID,
+		fmt.Sprintf("crdt_local_writes:%d", st.Seq),
+		fmt.Sprintf("crdt_state_entries:%d", st.Size),
+		fmt.Sprintf("crdt_connected_peers:%d", st.Peers),
+	}
+	for i, l := range st.Links {
+		lastIO := int64(-1)
+		if !l.LastIO.IsZero() {
+			lastIO = int64(time.Since(l.LastIO).Seconds())
+		}
+		lines = append(lines, fmt.Sprintf("link%d:addr=%s,state=%s,seq=%d,last_io_seconds_ago=%d", i, l.Addr, l.State, l.Seq, lastIO))
+	}
+	return lines
+}
+
+func cmdCRDT(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return wrongArity("crdt")
+	}
+	if !ActiveActiveEnabled() {
+		return resp.Value{Type: resp.Error, Text: "ERR This instance has active-active replication disabled"}
+	}
+	if strings.EqualFold(args[0].Text, "INFO") {
+		return resp.Value{Type: resp.BulkString, Text: strings.Join(activeActiveInfo(activeActiveCtx.Node), "\r\n") + "\r\n"}
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + args[0].Text + "'. Try CRDT HELP."}
+}
+
+type crdtView struct {
+	db *DB
+}
+
+func NewCRDTView(db *DB) crdt.View { return crdtView{db: db} }
+
+func (v crdtView) SetString(key, value string)       { v.db.Dict.Set(key, value, 0) }
+func (v crdtView) DeleteString(key string)           { v.db.Dict.Delete(key) }
+func (v crdtView) AddMember(key, member string)      { v.db.Set.Sadd(key, member) }
+func (v crdtView) RemoveMember(key, member string)   { v.db.Set.Srem(key, member) }
+func (v crdtView) SetField(key, field, value string) { v.db.Hash.Hset(key, field, value) }
+func (v crdtView) DeleteField(key, field string)     { v.db.Hash.Hdel(key, field) }
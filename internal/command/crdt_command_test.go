@@ -0,0 +1,93 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/crdt"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func TestCmdCRDTDisabled(t *testing.T) {
+	SetActiveActiveContext(&ActiveActiveContext{})
+	if result := cmdCRDT(bulkArgs("INFO")); result.Type != resp.Error || !strings.Contains(result.Text, "active-active replication disabled") {
+		t.Errorf("Expected active-active disabled error, got %v", result)
+	}
+}
+
+func TestActiveActiveHandlers(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	n, err := crdt.New(crdt.Config{ID: "a"}, NewCRDTView(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	SetActiveActiveContext(&ActiveActiveContext{Node: n})
+	defer SetActiveActiveContext(&ActiveActiveContext{})
+
+	run := func(args ...string) resp.Value {
+		h, ok := ActiveActiveHandler(args[0])
+		if !ok {
+			t.Fatalf("no active-active handler for %s", args[0])
+		}
+		return h(bulkArgs(args[1:]...))
+	}
+
+	if result := run("SET", "k", "v"); result.Text != "OK" {
+		t.Fatalf("SET failed: %v", result)
+	}
+	if v, _ := db.Dict.Get("k"); v != "v" {
+		t.Errorf("Expected k=v in the dict, got %q", v)
+	}
+	if result := run("SET", "k", "v", "EX", "10"); result.Type != resp.Error {
+		t.Errorf("Expected SET options to be refused, got %v", result)
+	}
+	if result := run("INCR", "k"); result.Type != resp.Error {
+		t.Errorf("Expected INCR on a non-integer to fail, got %v", result)
+	}
+
+	run("SET", "n", "10")
+	if result := run("INCRBY", "n", "5"); result.Number != 15 {
+		t.Errorf("Expected 15, got %v", result)
+	}
+	if result := run("DECR", "n"); result.Number != 14 {
+		t.Errorf("Expected 14, got %v", result)
+	}
+	if v, _ := db.Dict.Get("n"); v != "14" {
+		t.Errorf("Expected n=14 in the dict, got %q", v)
+	}
+
+	if result := run("SADD", "s", "x", "y", "x"); result.Number != 2 {
+		t.Errorf("Expected 2 members added, got %v", result)
+	}
+	if result := run("SREM", "s", "x"); result.Number != 1 {
+		t.Errorf("Expected 1 member removed, got %v", result)
+	}
+	if db.Set.Sismember("s", "x") || !db.Set.Sismember("s", "y") {
+		t.Error("Expected set s to hold only y")
+	}
+
+	if result := run("HSET", "h", "f", "1", "g", "2"); result.Number != 2 {
+		t.Errorf("Expected 2 fields added, got %v", result)
+	}
+	if result := run("HDEL", "h", "f", "missing"); result.Number != 1 {
+		t.Errorf("Expected 1 field deleted, got %v", result)
+	}
+	if v, ok := db.Hash.Hget("h", "g"); !ok || v != "2" {
+		t.Errorf("Expected h.g=2, got %q", v)
+	}
+
+	if result := run("DEL", "k", "n", "s", "h", "missing"); result.Number != 4 {
+		t.Errorf("Expected 4 keys deleted, got %v", result)
+	}
+	if _, ok := db.Dict.Get("n"); ok {
+		t.Error("Expected n to be deleted")
+	}
+
+	if _, ok := ActiveActiveHandler("LPUSH"); ok {
+		t.Error("Expected LPUSH to have no active-active handler")
+	}
+	if result := cmdCRDT(bulkArgs("INFO")); !strings.Contains(result.Text, "crdt_node_id:a") {
+		t.Errorf("Expected node id in CRDT INFO, got %q", result.Text)
+	}
+}
@@ -1,7 +1,9 @@
 package command
 
 import (
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
@@ -13,15 +15,24 @@ type DictStore interface {
 	Set(key, value string, ttl time.Duration)
 	Get(key string) (string, bool)
 	Delete(keys ...string) int
+	IncrBy(key string, delta int64) (int64, error)
 	Expire(key string, ttl time.Duration) bool
 	ExpireAt(key string, at time.Time) bool
 	TTL(key string) int64
-	Dump() map[string]datastructure.Item
+	Dump(keys ...string) map[string]datastructure.Item
+	SetBit(key string, offset uint64, bit byte) byte
+	GetBit(key string, offset uint64) byte
+	BitCount(key string, r *datastructure.BitRange) int64
+	BitPos(key string, bit byte, r *datastructure.BitRange) int64
+	BitOp(op string, dest string, keys ...string) int
+	BitField(key string, ops []datastructure.BitFieldOp) []datastructure.BitFieldResult
 }
 
 type DictContext struct {
 	Dict DictStore
 	AOF  *persistence.AOF
+	// DB lets DEL remove keys of every type; without it only strings go.
+	DB *DB
 }
 
 var dictCtx *DictContext
@@ -31,7 +42,12 @@ func SetDictContext(c *DictContext) { dictCtx = c }
 func InitDictCommands() {
 	Register("SET", cmdSet)
 	Register("GET", cmdGet)
+	Register("STRLEN", cmdStrlen)
 	Register("DEL", cmdDel)
+	Register("INCR", cmdIncr)
+	Register("DECR", cmdDecr)
+	Register("INCRBY", cmdIncrBy)
+	Register("DECRBY", cmdDecrBy)
 	Register("EXPIRE", cmdExpire)
 	Register("TTL", cmdTTL)
 	Register("PING", cmdPing)
@@ -45,12 +61,23 @@ func cmdSet(args []resp.Value) resp.Value {
 	key := args[0].Text
 	val := args[1].Text
 	var ttl time.Duration
-	if len(args) > 2 {
+	var at time.Time
+	if len(args) > 3 && strings.EqualFold(args[2].Text, "PXAT") {
+		ms, err := strconv.ParseInt(args[3].Text, 10, 64)
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+		}
+		at = time.UnixMilli(ms)
+	} else if len(args) > 2 {
 		if seconds, err := strconv.Atoi(args[2].Text); err == nil && seconds > 0 {
 			ttl = time.Duration(seconds) * time.Second
+			at = time.Now().Add(ttl)
 		}
 	}
 	dictCtx.Dict.Set(key, val, ttl)
+	if ttl == 0 && !at.IsZero() {
+		dictCtx.Dict.ExpireAt(key, at)
+	}
 	if dictCtx.AOF != nil {
 		_ = dictCtx.AOF.Append(resp.Value{
 			Type: resp.Array,
@@ -60,8 +87,7 @@ func cmdSet(args []resp.Value) resp.Value {
 				{Type: resp.BulkString, Text: val},
 			},
 		})
-		if ttl > 0 {
-			at := time.Now().Add(ttl)
+		if !at.IsZero() {
 			_ = dictCtx.AOF.Append(resp.Value{
 				Type: resp.Array,
 				Items: []resp.Value{
@@ -87,6 +113,14 @@ func cmdGet(args []resp.Value) resp.Value {
 	return resp.Value{Type: resp.BulkString, Text: val}
 }
 
+func cmdStrlen(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'strlen'"}
+	}
+	val, _ := dictCtx.Dict.Get(args[0].Text)
+	return resp.Value{Type: resp.Integer, Number: int64(len(val))}
+}
+
 func cmdDel(args []resp.Value) resp.Value {
 	if len(args) < 1 {
 		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'del'"}
@@ -95,7 +129,12 @@ func cmdDel(args []resp.Value) resp.Value {
 	for i, a := range args {
 		keys[i] = a.Text
 	}
-	n := dictCtx.Dict.Delete(keys...)
+	var n int
+	if dictCtx.DB != nil {
+		n = dictCtx.DB.Delete(keys...)
+	} else {
+		n = dictCtx.Dict.Delete(keys...)
+	}
 	if dictCtx.AOF != nil {
 		arr := []resp.Value{{Type: resp.BulkString, Text: "DEL"}}
 		for _, k := range keys {
@@ -106,6 +145,60 @@ func cmdDel(args []resp.Value) resp.Value {
 	return resp.Value{Type: resp.Integer, Number: int64(n)}
 }
 
+func cmdIncr(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("incr")
+	}
+	return incrBy(args[0].Text, 1)
+}
+
+func cmdDecr(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("decr")
+	}
+	return incrBy(args[0].Text, -1)
+}
+
+func cmdIncrBy(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("incrby")
+	}
+	delta, err := strconv.ParseInt(args[1].Text, 10, 64)
+	if err != nil {
+		return errorReply(datastructure.ErrNotInteger)
+	}
+	return incrBy(args[0].Text, delta)
+}
+
+func cmdDecrBy(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("decrby")
+	}
+	delta, err := strconv.ParseInt(args[1].Text, 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return errorReply(datastructure.ErrNotInteger)
+	}
+	return incrBy(args[0].Text, -delta)
+}
+
+func incrBy(key string, delta int64) resp.Value {
+	n, err := dictCtx.Dict.IncrBy(key, delta)
+	if err != nil {
+		return errorReply(err)
+	}
+	if dictCtx.AOF != nil {
+		_ = dictCtx.AOF.Append(resp.Value{
+			Type: resp.Array,
+			Items: []resp.Value{
+				{Type: resp.BulkString, Text: "INCRBY"},
+				{Type: resp.BulkString, Text: key},
+				{Type: resp.BulkString, Text: strconv.FormatInt(delta, 10)},
+			},
+		})
+	}
+	return resp.Value{Type: resp.Integer, Number: n}
+}
+
 func cmdExpire(args []resp.Value) resp.Value {
 	if len(args) != 2 {
 		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'expire'"}
@@ -144,6 +237,9 @@ func cmdPExpireAt(args []resp.Value) resp.Value {
 	}
 	at := time.UnixMilli(ms)
 	ok := dictCtx.Dict.ExpireAt(key, at)
+	if !ok && setCtx != nil {
+		ok = setCtx.Set.ExpireAt(key, at)
+	}
 	if !ok {
 		return resp.Value{Type: resp.Integer, Number: 0}
 	}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -47,6 +48,18 @@ func TestCmdGet(t *testing.T) {
 	}
 }
 
+func TestCmdStrlen(t *testing.T) {
+	dict := setupDictTest()
+	dict.Set("key1", "value1", 0)
+
+	if result := cmdStrlen(bulkArgs("key1")); result.Type != resp.Integer || result.Number != 6 {
+		t.Errorf("Expected 6, got %v", result)
+	}
+	if result := cmdStrlen(bulkArgs("missing")); result.Type != resp.Integer || result.Number != 0 {
+		t.Errorf("Expected 0 for a missing key, got %v", result)
+	}
+}
+
 func TestCmdDel(t *testing.T) {
 	dict := setupDictTest()
 	dict.Set("key1", "value1", 0)
@@ -102,6 +115,27 @@ func TestCmdExpire(t *testing.T) {
 	}
 }
 
+func TestCmdPExpireAt(t *testing.T) {
+	dict := setupDictTest()
+	set := datastructure.CreateSet()
+	SetSetContext(&SetContext{Set: set})
+	set.Sadd("s", "m")
+	at := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+
+	if result := cmdSet(bulkArgs("k", "v", "PXAT", at)); result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if ttl := dict.TTL("k"); ttl < 59 || ttl > 60 {
+		t.Errorf("Expected a TTL of about a minute, got %d", ttl)
+	}
+	if result := cmdPExpireAt(bulkArgs("s", at)); result.Number != 1 || set.TTL("s") < 59 {
+		t.Errorf("Expected the set to expire, got %v", result)
+	}
+	if result := cmdPExpireAt(bulkArgs("missing", at)); result.Number != 0 {
+		t.Errorf("Expected 0 for a missing key, got %v", result)
+	}
+}
+
 func TestCmdPing(t *testing.T) {
 	result := cmdPing([]resp.Value{})
 	if result.Type != resp.SimpleString || result.Text != "PONG" {
@@ -113,3 +147,32 @@ func TestCmdPing(t *testing.T) {
 		t.Errorf("Expected hello, got %s", result.Text)
 	}
 }
+
+func TestCmdIncrBy(t *testing.T) {
+	dict := setupDictTest()
+
+	if result := cmdIncr(bulkArgs("counter")); result.Number != 1 {
+		t.Errorf("Expected 1, got %v", result)
+	}
+	if result := cmdIncrBy(bulkArgs("counter", "10")); result.Number != 11 {
+		t.Errorf("Expected 11, got %v", result)
+	}
+	if result := cmdDecrBy(bulkArgs("counter", "20")); result.Number != -9 {
+		t.Errorf("Expected -9, got %v", result)
+	}
+	if result := cmdDecr(bulkArgs("counter")); result.Number != -10 {
+		t.Errorf("Expected -10, got %v", result)
+	}
+
+	dict.Set("text", "abc", 0)
+	if result := cmdIncr(bulkArgs("text")); result.Type != resp.Error {
+		t.Errorf("Expected not an integer error, got %v", result)
+	}
+	dict.Set("max", "9223372036854775807", 0)
+	if result := cmdIncr(bulkArgs("max")); result.Type != resp.Error {
+		t.Errorf("Expected overflow error, got %v", result)
+	}
+	if result := cmdIncrBy(bulkArgs("counter", "x")); result.Type != resp.Error {
+		t.Errorf("Expected not an integer error, got %v", result)
+	}
+}
@@ -0,0 +1,179 @@
+package command
+
+import (
+	"errors"
+	"math/rand/v2"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var evictionPolicies = map[string]bool{
+	"allkeys-lru": true, "volatile-lru": true,
+	"allkeys-lfu": true, "volatile-lfu": true,
+	"allkeys-random": true, "volatile-random": true,
+	"volatile-ttl": true, "noeviction": true,
+}
+
+// ValidEvictionPolicy reports whether name is a maxmemory_policy.
+func ValidEvictionPolicy(name string) bool {
+	return evictionPolicies[strings.ToLower(name)]
+}
+
+var freeingCommands = map[string]bool{
+	"DEL": true, "SREM": true, "LPOP": true, "RPOP": true, "HDEL": true, "ZREM": true,
+	"JSON.DEL": true, "JSON.FORGET": true, "JSON.ARRPOP": true,
+	"TS.DELETERULE": true, "CF.DEL": true,
+}
+
+var evictedKeys atomic.Uint64
+
+func EvictedKeys() uint64 { return evictedKeys.Load() }
+
+type volatileSampler interface {
+	SampleVolatile(n int) map[string]time.Time
+}
+
+var heapState struct {
+	sync.Mutex
+	cycles uint64
+	freed  uint64
+}
+
+var heapSamples = []metrics.Sample{
+	{Name: "/gc/heap/live:bytes"},
+	{Name: "/gc/cycles/total:gc-cycles"},
+}
+
+// UsedMemory is the heap found live by the last garbage collection, less
+// what eviction freed since.
+func UsedMemory() uint64 {
+	heapState.Lock()
+	defer heapState.Unlock()
+	samples := make([]metrics.Sample, len(heapSamples))
+	copy(samples, heapSamples)
+	metrics.Read(samples)
+	used, cycles := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+	if cycles != heapState.cycles {
+		heapState.cycles, heapState.freed = cycles, 0
+	}
+	if heapState.freed >= used {
+		return 0
+	}
+	return used - heapState.freed
+}
+
+func noteFreed(n uint64) {
+	heapState.Lock()
+	defer heapState.Unlock()
+	heapState.freed += n
+}
+
+// CheckMemory evicts keys by maxmemory_policy when the heap is over
+// maxmemory, and fails with ErrOOM if nothing can be evicted.
+func (db *DB) CheckMemory(cmd string) error {
+	if config.Global == nil {
+		return nil
+	}
+	return db.freeMemory(cmd, config.Global.GetMaxMemory(), config.Global.GetMaxMemoryPolicy(), config.Global.GetMaxMemorySamples())
+}
+
+// tracksAccess reports whether key use is recorded, which only LRU and LFU
+// eviction under a maxmemory limit need.
+func (db *DB) tracksAccess() bool {
+	if db.Access == nil || config.Global == nil || config.Global.GetMaxMemory() == 0 {
+		return false
+	}
+	policy := config.Global.GetMaxMemoryPolicy()
+	return strings.HasSuffix(policy, "-lru") || strings.HasSuffix(policy, "-lfu")
+}
+
+func (db *DB) freeMemory(cmd string, limit uint64, policy string, samples int) error {
+	cmd = strings.ToUpper(cmd)
+	if limit == 0 || !IsWrite(cmd) || freeingCommands[cmd] {
+		return nil
+	}
+	// Replicas leave eviction to their master, whose deletes they apply.
+	if db.Repl != nil && db.Repl.IsReplica() {
+		return nil
+	}
+	// A key evicted on one node of a Raft group or active-active set would
+	// still exist on the others, so there only writes are refused.
+	if db.Raft != nil || db.CRDT != nil {
+		policy = "noeviction"
+	}
+	for UsedMemory() > limit {
+		if policy == "noeviction" {
+			return ErrOOM
+		}
+		key, ok := db.evictionCandidate(policy, samples)
+		if !ok {
+			return ErrOOM
+		}
+		db.evict(key)
+	}
+	return nil
+}
+
+func (db *DB) evict(key string) {
+	db.RunWrite(func() {
+		size, _ := db.MemoryUsage(key, datastructure.DefaultMemorySamples)
+		if db.Delete(key) == 0 {
+			return
+		}
+		appendToAOF(db.AOF, "DEL", []resp.Value{{Type: resp.BulkString, Text: key}})
+		evictedKeys.Add(1)
+		noteFreed(uint64(size))
+	})
+}
+
+func (db *DB) evictionCandidate(policy string, n int) (string, bool) {
+	volatile := strings.HasPrefix(policy, "volatile-")
+	expiry := map[string]time.Time{}
+	var keys []string
+	for _, space := range db.keyspaces() {
+		if !volatile {
+			keys = append(keys, space.Sample(n)...)
+			continue
+		}
+		vs, ok := space.keyspace.(volatileSampler)
+		if !ok {
+			continue
+		}
+		for key, at := range vs.SampleVolatile(n) {
+			keys = append(keys, key)
+			expiry[key] = at
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	if strings.HasSuffix(policy, "-random") {
+		return keys[rand.IntN(len(keys))], true
+	}
+
+	best, bestScore := "", -1.0
+	for _, key := range keys {
+		var score float64
+		switch {
+		case strings.HasSuffix(policy, "-lru"):
+			score = float64(db.idle(key))
+		case strings.HasSuffix(policy, "-lfu"):
+			score = float64(255 - db.freq(key))
+		case policy == "volatile-ttl":
+			score = float64(-time.Until(expiry[key]))
+		}
+		if best == "" || score > bestScore {
+			best, bestScore = key, score
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+)
+
+func TestCheckMemoryNoEviction(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	db.Dict.Set("k", "v", 0)
+	// The live heap is measured by the collector.
+	runtime.GC()
+
+	if err := db.freeMemory("SET", 1, "noeviction", 5); !errors.Is(err, ErrOOM) {
+		t.Errorf("Expected OOM for SET, got %v", err)
+	}
+	if err := db.freeMemory("DEL", 1, "noeviction", 5); err != nil {
+		t.Errorf("Expected DEL to be allowed, got %v", err)
+	}
+	if err := db.freeMemory("GET", 1, "noeviction", 5); err != nil {
+		t.Errorf("Expected GET to be allowed, got %v", err)
+	}
+	if !db.Dict.Exists("k") {
+		t.Error("Expected noeviction to keep every key")
+	}
+	if err := db.freeMemory("SET", 0, "noeviction", 5); err != nil {
+		t.Errorf("Expected no limit with maxmemory 0, got %v", err)
+	}
+}
+
+func TestCheckMemoryEvictsEverything(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	db.Dict.Set("a", "1", 0)
+	db.Set.Sadd("b", "x")
+	db.Hash.Hset("c", "f", "v")
+	runtime.GC()
+
+	before := EvictedKeys()
+	if err := db.freeMemory("SET", 1, "allkeys-random", 5); !errors.Is(err, ErrOOM) {
+		t.Errorf("Expected OOM once nothing is left to evict, got %v", err)
+	}
+	if keys := db.Keys(); len(keys) != 0 {
+		t.Errorf("Expected every key to be evicted, got %v", keys)
+	}
+	if n := EvictedKeys() - before; n != 3 {
+		t.Errorf("Expected 3 evicted keys, got %d", n)
+	}
+}
+
+// trackAccess has db record key use as LRU eviction needs.
+func trackAccess(t *testing.T, db *DB) {
+	saved := config.Global
+	config.Global = &config.Config{}
+	config.Global.Datastructure.Expiration.CheckInterval = 1
+	config.Global.Memory.MaxMemory = "1g"
+	config.Global.Memory.MaxMemoryPolicy = "allkeys-lru"
+	t.Cleanup(func() { config.Global = saved })
+	db.Access = datastructure.CreateAccessTable()
+}
+
+func TestTouch(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	trackAccess(t, db)
+	db.Dict.Set("k", "v", 0)
+	db.Hash.Hset("h", "f", "v")
+
+	config.Global.Memory.MaxMemoryPolicy = "noeviction"
+	for range 100 {
+		db.Touch("k")
+	}
+	if freq := db.freq("k"); freq != datastructure.LFUInitVal {
+		t.Errorf("Expected no tracking without an LRU or LFU policy, got freq %d", freq)
+	}
+
+	config.Global.Memory.MaxMemoryPolicy = "allkeys-lfu"
+	for range 100 {
+		db.Touch("k", "h", "missing")
+	}
+	if db.freq("k") <= datastructure.LFUInitVal || db.freq("h") <= datastructure.LFUInitVal {
+		t.Errorf("Expected k and h tracked, got freq %d and %d", db.freq("k"), db.freq("h"))
+	}
+	db.Delete("h")
+	db.Hash.Hset("h", "f", "v")
+	if freq := db.freq("h"); freq != datastructure.LFUInitVal {
+		t.Errorf("Expected a recreated key to start afresh, got freq %d", freq)
+	}
+}
+
+func TestEvictionCandidate(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	trackAccess(t, db)
+	for _, key := range []string{"old", "new", "hot"} {
+		db.Dict.Set(key, "v", 0)
+	}
+	db.Set.Sadd("soon", "x")
+	db.Set.Expire("soon", time.Minute)
+	db.Dict.Set("later", "v", time.Hour)
+
+	db.Touch("old")
+	time.Sleep(5 * time.Millisecond)
+	db.Touch("soon")
+	time.Sleep(5 * time.Millisecond)
+	db.Touch("new", "hot", "later")
+	for range 1000 {
+		db.Touch("hot")
+	}
+
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"allkeys-lru", "old"},
+		{"volatile-ttl", "soon"},
+		{"volatile-lru", "soon"},
+	}
+	for _, tt := range tests {
+		if key, ok := db.evictionCandidate(tt.policy, 100); !ok || key != tt.want {
+			t.Errorf("%s: expected %s, got %q", tt.policy, tt.want, key)
+		}
+	}
+	if key, _ := db.evictionCandidate("allkeys-lfu", 100); key == "hot" {
+		t.Error("allkeys-lfu: expected a key other than the most used one")
+	}
+	db.Delete("soon", "later")
+	if _, ok := db.evictionCandidate("volatile-random", 100); ok {
+		t.Error("volatile-random: expected no candidate without TTL keys")
+	}
+}
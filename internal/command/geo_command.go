@@ -0,0 +1,353 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func InitGeoCommands() {
+	Register("GEOADD", cmdGeoadd)
+	Register("GEOPOS", cmdGeopos)
+	Register("GEODIST", cmdGeodist)
+	Register("GEOHASH", cmdGeohash)
+	Register("GEOSEARCH", cmdGeosearch)
+	Register("GEOSEARCHSTORE", cmdGeosearchstore)
+}
+
+func geoUnitFactor(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'g', 17, 64)
+}
+
+func formatGeoDist(meters, factor float64) string {
+	return fmt.Sprintf("%.4f", meters/factor)
+}
+
+func cmdGeoadd(args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geoadd'"}
+	}
+	key := args[0].Text
+	var flags datastructure.ZAddFlags
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "NX":
+			flags.NX = true
+			continue
+		case "XX":
+			flags.XX = true
+			continue
+		case "CH":
+			flags.CH = true
+			continue
+		}
+		break
+	}
+	if flags.NX && flags.XX {
+		return resp.Value{Type: resp.Error, Text: "ERR XX and NX options at the same time are not compatible"}
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+
+	entries := make([]datastructure.ScoreMember, 0, len(rest)/3)
+	for j := 0; j < len(rest); j += 3 {
+		lon, err1 := strconv.ParseFloat(rest[j].Text, 64)
+		lat, err2 := strconv.ParseFloat(rest[j+1].Text, 64)
+		if err1 != nil || err2 != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR value is not a valid float"}
+		}
+		if !datastructure.ValidGeoCoord(lon, lat) {
+			return resp.Value{Type: resp.Error, Text: fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat)}
+		}
+		entries = append(entries, datastructure.ScoreMember{Member: rest[j+2].Text, Score: float64(datastructure.GeohashEncode(lon, lat))})
+	}
+
+	n := zsetCtx.ZSet.Zadd(key, flags, entries...)
+	appendToAOF(zsetCtx.AOF, "GEOADD", args)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func cmdGeopos(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geopos'"}
+	}
+	key := args[0].Text
+	items := make([]resp.Value, 0, len(args)-1)
+	for _, a := range args[1:] {
+		lon, lat, ok := zsetCtx.ZSet.Geopos(key, a.Text)
+		if !ok {
+			items = append(items, resp.Value{Type: resp.Array, IsNil: true})
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: formatCoord(lon)},
+			{Type: resp.BulkString, Text: formatCoord(lat)},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdGeodist(args []resp.Value) resp.Value {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geodist'"}
+	}
+	factor := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if factor, ok = geoUnitFactor(args[3].Text); !ok {
+			return resp.Value{Type: resp.Error, Text: "ERR unsupported unit provided. please use M, KM, FT, MI"}
+		}
+	}
+	dist, ok := zsetCtx.ZSet.Geodist(args[0].Text, args[1].Text, args[2].Text)
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.BulkString, Text: formatGeoDist(dist, factor)}
+}
+
+func cmdGeohash(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geohash'"}
+	}
+	key := args[0].Text
+	items := make([]resp.Value, 0, len(args)-1)
+	for _, a := range args[1:] {
+		score, ok := zsetCtx.ZSet.Zscore(key, a.Text)
+		if !ok {
+			items = append(items, resp.Value{Type: resp.BulkString, IsNil: true})
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.BulkString, Text: datastructure.GeohashString(uint64(score))})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+type geoSearchOptions struct {
+	query     datastructure.GeoQuery
+	factor    float64
+	sort      int
+	count     int
+	any       bool
+	withDist  bool
+	withHash  bool
+	withCoord bool
+	storeDist bool
+}
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+func parseGeoSearch(key string, args []resp.Value, store bool) (*geoSearchOptions, *resp.Value) {
+	syntaxErr := &resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	opts := &geoSearchOptions{factor: 1}
+	hasFrom, hasBy := false, false
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].Text)
+		switch {
+		case arg == "FROMMEMBER" && i+1 < len(args):
+			if hasFrom {
+				return nil, syntaxErr
+			}
+			// A missing key searches nothing, so only a missing member of an
+			// existing key is an error.
+			lon, lat, ok := zsetCtx.ZSet.Geopos(key, args[i+1].Text)
+			if !ok && zsetCtx.ZSet.Zcard(key) > 0 {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR could not decode requested zset member"}
+			}
+			opts.query.Lon, opts.query.Lat = lon, lat
+			hasFrom = true
+			i++
+		case arg == "FROMLONLAT" && i+2 < len(args):
+			if hasFrom {
+				return nil, syntaxErr
+			}
+			lon, err1 := strconv.ParseFloat(args[i+1].Text, 64)
+			lat, err2 := strconv.ParseFloat(args[i+2].Text, 64)
+			if err1 != nil || err2 != nil {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR value is not a valid float"}
+			}
+			if !datastructure.ValidGeoCoord(lon, lat) {
+				return nil, &resp.Value{Type: resp.Error, Text: fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat)}
+			}
+			opts.query.Lon, opts.query.Lat = lon, lat
+			hasFrom = true
+			i += 2
+		case arg == "BYRADIUS" && i+2 < len(args):
+			if hasBy {
+				return nil, syntaxErr
+			}
+			radius, err := strconv.ParseFloat(args[i+1].Text, 64)
+			if err != nil || radius < 0 {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR radius cannot be negative"}
+			}
+			factor, ok := geoUnitFactor(args[i+2].Text)
+			if !ok {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR unsupported unit provided. please use M, KM, FT, MI"}
+			}
+			opts.query.Shape = datastructure.GeoByRadius
+			opts.query.Radius = radius * factor
+			opts.factor = factor
+			hasBy = true
+			i += 2
+		case arg == "BYBOX" && i+3 < len(args):
+			if hasBy {
+				return nil, syntaxErr
+			}
+			width, err1 := strconv.ParseFloat(args[i+1].Text, 64)
+			height, err2 := strconv.ParseFloat(args[i+2].Text, 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR height or width cannot be negative"}
+			}
+			factor, ok := geoUnitFactor(args[i+3].Text)
+			if !ok {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR unsupported unit provided. please use M, KM, FT, MI"}
+			}
+			opts.query.Shape = datastructure.GeoByBox
+			opts.query.Width = width * factor
+			opts.query.Height = height * factor
+			opts.factor = factor
+			hasBy = true
+			i += 3
+		case arg == "ASC":
+			opts.sort = geoSortAsc
+		case arg == "DESC":
+			opts.sort = geoSortDesc
+		case arg == "COUNT" && i+1 < len(args):
+			count, err := strconv.Atoi(args[i+1].Text)
+			if err != nil || count <= 0 {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR COUNT must be > 0"}
+			}
+			opts.count = count
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1].Text) == "ANY" {
+				opts.any = true
+				i++
+			}
+		case arg == "WITHDIST" && !store:
+			opts.withDist = true
+		case arg == "WITHHASH" && !store:
+			opts.withHash = true
+		case arg == "WITHCOORD" && !store:
+			opts.withCoord = true
+		case arg == "STOREDIST" && store:
+			opts.storeDist = true
+		default:
+			return nil, syntaxErr
+		}
+	}
+
+	if !hasFrom {
+		return nil, &resp.Value{Type: resp.Error, Text: "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified"}
+	}
+	if !hasBy {
+		return nil, &resp.Value{Type: resp.Error, Text: "ERR exactly one of BYRADIUS and BYBOX can be specified"}
+	}
+	return opts, nil
+}
+
+func runGeoSearch(key string, opts *geoSearchOptions) []datastructure.GeoResult {
+	results := zsetCtx.ZSet.GeoSearch(key, opts.query)
+
+	if opts.any && opts.count > 0 && len(results) > opts.count {
+		results = results[:opts.count]
+	}
+	if opts.sort == geoSortNone && opts.count > 0 && !opts.any {
+		opts.sort = geoSortAsc
+	}
+	switch opts.sort {
+	case geoSortAsc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist < results[j].Dist })
+	case geoSortDesc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist > results[j].Dist })
+	}
+	if opts.count > 0 && len(results) > opts.count {
+		results = results[:opts.count]
+	}
+	return results
+}
+
+func cmdGeosearch(args []resp.Value) resp.Value {
+	if len(args) < 5 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geosearch'"}
+	}
+	key := args[0].Text
+	opts, errVal := parseGeoSearch(key, args[1:], false)
+	if errVal != nil {
+		return *errVal
+	}
+
+	results := runGeoSearch(key, opts)
+	items := make([]resp.Value, 0, len(results))
+	plain := !opts.withDist && !opts.withHash && !opts.withCoord
+	for _, r := range results {
+		if plain {
+			items = append(items, resp.Value{Type: resp.BulkString, Text: r.Member})
+			continue
+		}
+		entry := []resp.Value{{Type: resp.BulkString, Text: r.Member}}
+		if opts.withDist {
+			entry = append(entry, resp.Value{Type: resp.BulkString, Text: formatGeoDist(r.Dist, opts.factor)})
+		}
+		if opts.withHash {
+			entry = append(entry, resp.Value{Type: resp.Integer, Number: int64(r.Hash)})
+		}
+		if opts.withCoord {
+			entry = append(entry, resp.Value{Type: resp.Array, Items: []resp.Value{
+				{Type: resp.BulkString, Text: formatCoord(r.Lon)},
+				{Type: resp.BulkString, Text: formatCoord(r.Lat)},
+			}})
+		}
+		items = append(items, resp.Value{Type: resp.Array, Items: entry})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdGeosearchstore(args []resp.Value) resp.Value {
+	if len(args) < 6 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'geosearchstore'"}
+	}
+	dest := args[0].Text
+	src := args[1].Text
+	opts, errVal := parseGeoSearch(src, args[2:], true)
+	if errVal != nil {
+		return *errVal
+	}
+
+	results := runGeoSearch(src, opts)
+	entries := make([]datastructure.ScoreMember, 0, len(results))
+	for _, r := range results {
+		score := float64(r.Hash)
+		if opts.storeDist {
+			score = r.Dist / opts.factor
+		}
+		entries = append(entries, datastructure.ScoreMember{Member: r.Member, Score: score})
+	}
+	n := zsetCtx.ZSet.Zstore(dest, entries)
+	appendToAOF(zsetCtx.AOF, "GEOSEARCHSTORE", args)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
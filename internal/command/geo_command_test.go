@@ -0,0 +1,124 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupGeoTest() *datastructure.ZSet {
+	zset := datastructure.CreateZSet()
+	SetZSetContext(&ZSetContext{ZSet: zset, AOF: nil})
+	cmdGeoadd(bulkArgs("Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"))
+	return zset
+}
+
+func TestCmdGeoadd(t *testing.T) {
+	setupGeoTest()
+
+	result := cmdGeoadd(bulkArgs("Sicily", "CH", "13.361389", "38.115556", "Palermo", "12.758489", "38.788135", "edge1"))
+	if result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+	result = cmdGeoadd(bulkArgs("Sicily", "0", "86", "pole"))
+	if result.Type != resp.Error {
+		t.Error("Expected error for invalid latitude")
+	}
+}
+
+func TestCmdGeoposGeodistGeohash(t *testing.T) {
+	setupGeoTest()
+
+	result := cmdGeopos(bulkArgs("Sicily", "Palermo", "Nowhere"))
+	if len(result.Items) != 2 || !result.Items[1].IsNil {
+		t.Fatalf("Unexpected GEOPOS result %v", result)
+	}
+	if result.Items[0].Items[0].Text != "13.361389338970184" || result.Items[0].Items[1].Text != "38.115556395496299" {
+		t.Errorf("Unexpected coordinates %v", result.Items[0].Items)
+	}
+
+	if result := cmdGeodist(bulkArgs("Sicily", "Palermo", "Catania", "km")); result.Text != "166.2742" {
+		t.Errorf("Expected 166.2742, got %s", result.Text)
+	}
+	if result := cmdGeodist(bulkArgs("Sicily", "Palermo", "Nowhere")); !result.IsNil {
+		t.Error("Expected nil for missing member")
+	}
+
+	result = cmdGeohash(bulkArgs("Sicily", "Palermo", "Catania"))
+	if result.Items[0].Text != "sqc8b49rny0" || result.Items[1].Text != "sqdtr74hyu0" {
+		t.Errorf("Unexpected hashes %v", result.Items)
+	}
+}
+
+func TestCmdGeosearch(t *testing.T) {
+	setupGeoTest()
+
+	result := cmdGeosearch(bulkArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST"))
+	if len(result.Items) != 2 {
+		t.Fatalf("Expected 2 results, got %v", result)
+	}
+	first, second := result.Items[0].Items, result.Items[1].Items
+	if first[0].Text != "Catania" || first[1].Text != "56.4413" {
+		t.Errorf("Unexpected first result %v", first)
+	}
+	if second[0].Text != "Palermo" || second[1].Text != "190.4424" {
+		t.Errorf("Unexpected second result %v", second)
+	}
+
+	result = cmdGeosearch(bulkArgs("Sicily", "FROMMEMBER", "Palermo", "BYBOX", "400", "400", "km", "DESC", "COUNT", "1"))
+	if len(result.Items) != 1 || result.Items[0].Text != "Catania" {
+		t.Errorf("Expected Catania, got %v", result.Items)
+	}
+
+	result = cmdGeosearch(bulkArgs("Sicily", "FROMMEMBER", "Nowhere", "BYRADIUS", "1", "km"))
+	if result.Type != resp.Error {
+		t.Error("Expected error for missing member")
+	}
+	result = cmdGeosearch(bulkArgs("Nowhere", "FROMMEMBER", "Palermo", "BYRADIUS", "1", "km"))
+	if result.Type != resp.Array || len(result.Items) != 0 {
+		t.Errorf("Expected an empty array for a missing key, got %v", result)
+	}
+	result = cmdGeosearch(bulkArgs("Sicily", "FROMLONLAT", "15", "37", "ASC"))
+	if result.Type != resp.Error {
+		t.Error("Expected error without BYRADIUS or BYBOX")
+	}
+}
+
+func TestCmdGeosearchstore(t *testing.T) {
+	zset := setupGeoTest()
+
+	result := cmdGeosearchstore(bulkArgs("near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST"))
+	if result.Number != 2 {
+		t.Errorf("Expected 2 stored, got %d", result.Number)
+	}
+	if score, ok := zset.Zscore("near", "Catania"); !ok || score < 56.44 || score > 56.45 {
+		t.Errorf("Expected distance score, got %v", score)
+	}
+
+	cmdGeosearchstore(bulkArgs("near", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"))
+	if zset.Zcard("near") != 0 {
+		t.Error("Empty result should delete destination")
+	}
+}
+
+func TestGeoAOFRewrite(t *testing.T) {
+	aof := openTestAOF(t)
+
+	zset := setupGeoTest()
+	rewriteAOF(t, aof, persistence.Snapshot{ZSetData: zset.Dump()})
+
+	replayed := datastructure.CreateZSet()
+	SetZSetContext(&ZSetContext{ZSet: replayed, AOF: aof})
+	aof.LoadAll(nil, func(cmd string, args []resp.Value) {
+		if cmd == "ZADD" {
+			cmdZadd(args)
+		}
+	})
+
+	want, _ := zset.Zscore("Sicily", "Palermo")
+	if got, ok := replayed.Zscore("Sicily", "Palermo"); !ok || got != want {
+		t.Errorf("Expected score %v after replay, got %v", want, got)
+	}
+}
@@ -13,7 +13,7 @@ type HashStore interface {
 	Hgetall(key string) (map[string]string, bool)
 	Hexists(key, field string) bool
 	Hlen(key string) int
-	Dump() map[string]map[string]string
+	Dump(keys ...string) map[string]map[string]string
 }
 
 type HashContext struct {
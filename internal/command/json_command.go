@@ -0,0 +1,248 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type JSONStore interface {
+	JSONSet(key, path, value string, nx, xx bool) (bool, error)
+	JSONGet(key string, paths ...string) (string, bool, error)
+	JSONDel(key, path string) (int, error)
+	JSONNumIncrBy(key, path, delta string) (string, error)
+	JSONArrInsert(key, path string, index int, appendMode bool, values ...string) ([]*int, bool, error)
+	JSONArrPop(key, path string, index int) ([]*string, bool, error)
+	JSONObjKeys(key, path string) ([][]string, bool, error)
+	Dump(keys ...string) map[string]string
+}
+
+type JSONContext struct {
+	JSON JSONStore
+	AOF  *persistence.AOF
+}
+
+var jsonCtx *JSONContext
+
+func SetJSONContext(c *JSONContext) { jsonCtx = c }
+
+func InitJSONCommands() {
+	Register("JSON.SET", cmdJSONSet)
+	Register("JSON.GET", cmdJSONGet)
+	Register("JSON.DEL", cmdJSONDel)
+	Register("JSON.FORGET", cmdJSONDel)
+	Register("JSON.NUMINCRBY", cmdJSONNumIncrBy)
+	Register("JSON.ARRAPPEND", cmdJSONArrAppend)
+	Register("JSON.ARRINSERT", cmdJSONArrInsert)
+	Register("JSON.ARRPOP", cmdJSONArrPop)
+	Register("JSON.OBJKEYS", cmdJSONObjKeys)
+}
+
+func errorReply(err error) resp.Value {
+	return resp.Value{Type: resp.Error, Text: err.Error()}
+}
+
+func lengthsReply(lengths []*int, legacy bool) resp.Value {
+	if legacy {
+		return resp.Value{Type: resp.Integer, Number: int64(*lengths[len(lengths)-1])}
+	}
+	items := make([]resp.Value, 0, len(lengths))
+	for _, n := range lengths {
+		if n == nil {
+			items = append(items, resp.Value{Type: resp.BulkString, IsNil: true})
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.Integer, Number: int64(*n)})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdJSONSet(args []resp.Value) resp.Value {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.set'"}
+	}
+	nx, xx := false, false
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3].Text) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+	}
+	ok, err := jsonCtx.JSON.JSONSet(args[0].Text, args[1].Text, args[2].Text, nx, xx)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	appendToAOF(jsonCtx.AOF, "JSON.SET", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdJSONGet(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.get'"}
+	}
+	paths := make([]string, 0, len(args)-1)
+	for _, a := range args[1:] {
+		paths = append(paths, a.Text)
+	}
+	doc, ok, err := jsonCtx.JSON.JSONGet(args[0].Text, paths...)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.BulkString, Text: doc}
+}
+
+func cmdJSONDel(args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.del'"}
+	}
+	path := "$"
+	if len(args) == 2 {
+		path = args[1].Text
+	}
+	n, err := jsonCtx.JSON.JSONDel(args[0].Text, path)
+	if err != nil {
+		return errorReply(err)
+	}
+	if n > 0 {
+		appendToAOF(jsonCtx.AOF, "JSON.DEL", []resp.Value{args[0], {Type: resp.BulkString, Text: path}})
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func cmdJSONNumIncrBy(args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.numincrby'"}
+	}
+	result, err := jsonCtx.JSON.JSONNumIncrBy(args[0].Text, args[1].Text, args[2].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(jsonCtx.AOF, "JSON.NUMINCRBY", args)
+	return resp.Value{Type: resp.BulkString, Text: result}
+}
+
+func cmdJSONArrAppend(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.arrappend'"}
+	}
+	values := make([]string, 0, len(args)-2)
+	for _, a := range args[2:] {
+		values = append(values, a.Text)
+	}
+	lengths, legacy, err := jsonCtx.JSON.JSONArrInsert(args[0].Text, args[1].Text, 0, true, values...)
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(jsonCtx.AOF, "JSON.ARRAPPEND", args)
+	return lengthsReply(lengths, legacy)
+}
+
+func cmdJSONArrInsert(args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.arrinsert'"}
+	}
+	index, err := strconv.Atoi(args[2].Text)
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+	}
+	values := make([]string, 0, len(args)-3)
+	for _, a := range args[3:] {
+		values = append(values, a.Text)
+	}
+	lengths, legacy, err := jsonCtx.JSON.JSONArrInsert(args[0].Text, args[1].Text, index, false, values...)
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(jsonCtx.AOF, "JSON.ARRINSERT", args)
+	return lengthsReply(lengths, legacy)
+}
+
+func cmdJSONArrPop(args []resp.Value) resp.Value {
+	if len(args) < 1 || len(args) > 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.arrpop'"}
+	}
+	path, index := "$", -1
+	if len(args) > 1 {
+		path = args[1].Text
+	}
+	if len(args) > 2 {
+		var err error
+		if index, err = strconv.Atoi(args[2].Text); err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+		}
+	}
+	popped, legacy, err := jsonCtx.JSON.JSONArrPop(args[0].Text, path, index)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	items := make([]resp.Value, 0, len(popped))
+	changed := false
+	for _, p := range popped {
+		if p == nil {
+			items = append(items, resp.Value{Type: resp.BulkString, IsNil: true})
+			continue
+		}
+		changed = true
+		items = append(items, resp.Value{Type: resp.BulkString, Text: *p})
+	}
+	if changed {
+		appendToAOF(jsonCtx.AOF, "JSON.ARRPOP", []resp.Value{
+			args[0],
+			{Type: resp.BulkString, Text: path},
+			{Type: resp.BulkString, Text: strconv.Itoa(index)},
+		})
+	}
+	if legacy {
+		return items[len(items)-1]
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdJSONObjKeys(args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'json.objkeys'"}
+	}
+	path := "."
+	if len(args) == 2 {
+		path = args[1].Text
+	}
+	keys, legacy, err := jsonCtx.JSON.JSONObjKeys(args[0].Text, path)
+	if err != nil {
+		return errorReply(err)
+	}
+	if keys == nil {
+		return resp.Value{Type: resp.Array, IsNil: true}
+	}
+
+	toArray := func(names []string) resp.Value {
+		if names == nil {
+			return resp.Value{Type: resp.Array, IsNil: true}
+		}
+		items := make([]resp.Value, 0, len(names))
+		for _, n := range names {
+			items = append(items, resp.Value{Type: resp.BulkString, Text: n})
+		}
+		return resp.Value{Type: resp.Array, Items: items}
+	}
+	if legacy {
+		return toArray(keys[len(keys)-1])
+	}
+	items := make([]resp.Value, 0, len(keys))
+	for _, names := range keys {
+		items = append(items, toArray(names))
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
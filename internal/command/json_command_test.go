@@ -0,0 +1,107 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupJSONTest() *datastructure.JSONStore {
+	store := datastructure.CreateJSONStore()
+	SetJSONContext(&JSONContext{JSON: store, AOF: nil})
+	return store
+}
+
+func TestCmdJSONSetGet(t *testing.T) {
+	setupJSONTest()
+
+	if result := cmdJSONSet(bulkArgs("doc", "$", `{"a":1,"b":[1,2]}`)); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := cmdJSONSet(bulkArgs("doc", "$", `{}`, "NX")); !result.IsNil {
+		t.Error("Expected nil for NX on existing key")
+	}
+	if result := cmdJSONSet(bulkArgs("doc", "$", `{}`, "BAD")); result.Type != resp.Error {
+		t.Error("Expected syntax error")
+	}
+	if result := cmdJSONGet(bulkArgs("doc", "$.b[0]")); result.Text != "[1]" {
+		t.Errorf("Expected [1], got %s", result.Text)
+	}
+	if result := cmdJSONGet(bulkArgs("doc", ".c")); result.Type != resp.Error {
+		t.Error("Expected error for missing legacy path")
+	}
+	if result := cmdJSONGet(bulkArgs("missing")); !result.IsNil {
+		t.Error("Expected nil for missing key")
+	}
+	if result := cmdJSONDel(bulkArgs("doc", "$.a")); result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+}
+
+func TestCmdJSONArrays(t *testing.T) {
+	setupJSONTest()
+	cmdJSONSet(bulkArgs("doc", "$", `{"a":[1],"b":{"c":[]}}`))
+
+	result := cmdJSONArrAppend(bulkArgs("doc", "$..*", "2"))
+	if len(result.Items) != 4 || result.Items[0].Number != 2 || !result.Items[1].IsNil || result.Items[3].Number != 1 {
+		t.Errorf("Unexpected append result %v", result.Items)
+	}
+	if result := cmdJSONArrInsert(bulkArgs("doc", ".a", "0", "0")); result.Type != resp.Integer || result.Number != 3 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+	if result := cmdJSONArrPop(bulkArgs("doc", ".a", "0")); result.Text != "0" {
+		t.Errorf("Expected 0, got %v", result)
+	}
+	if result := cmdJSONArrPop(bulkArgs("doc", "$.b.c")); result.Items[0].Text != "2" {
+		t.Errorf("Expected 2, got %v", result.Items)
+	}
+	if result := cmdJSONArrPop(bulkArgs("doc", "$.b.c")); !result.Items[0].IsNil {
+		t.Error("Expected nil when popping empty array")
+	}
+	if result := cmdJSONNumIncrBy(bulkArgs("doc", "$.a[0]", "5")); result.Text != "[6]" {
+		t.Errorf("Expected [6], got %s", result.Text)
+	}
+	result = cmdJSONObjKeys(bulkArgs("doc"))
+	if len(result.Items) != 2 || result.Items[0].Text != "a" {
+		t.Errorf("Expected the root keys as a flat array, got %v", result.Items)
+	}
+	result = cmdJSONObjKeys(bulkArgs("doc", "$"))
+	if len(result.Items) != 1 || len(result.Items[0].Items) != 2 {
+		t.Errorf("Unexpected keys %v", result.Items)
+	}
+}
+
+func TestJSONAOFReplay(t *testing.T) {
+	aof := openTestAOF(t)
+
+	store := datastructure.CreateJSONStore()
+	SetJSONContext(&JSONContext{JSON: store, AOF: aof})
+	cmdJSONSet(bulkArgs("doc", "$", `{"n":1,"arr":[]}`))
+	cmdJSONNumIncrBy(bulkArgs("doc", "$.n", "2"))
+	cmdJSONArrAppend(bulkArgs("doc", "$.arr", `"x"`, `"y"`))
+	cmdJSONArrPop(bulkArgs("doc", "$.arr"))
+	cmdJSONGet(bulkArgs("doc"))
+	want := store.Dump()["doc"]
+
+	replayed := datastructure.CreateJSONStore()
+	SetJSONContext(&JSONContext{JSON: replayed, AOF: aof})
+	count := 0
+	aof.LoadAll(nil, func(cmd string, args []resp.Value) {
+		count++
+		h, _ := map[string]Handler{
+			"JSON.SET":       cmdJSONSet,
+			"JSON.NUMINCRBY": cmdJSONNumIncrBy,
+			"JSON.ARRAPPEND": cmdJSONArrAppend,
+			"JSON.ARRPOP":    cmdJSONArrPop,
+		}[cmd]
+		h(args)
+	})
+
+	if count != 4 {
+		t.Errorf("Expected 4 logged commands, got %d", count)
+	}
+	if got := replayed.Dump()["doc"]; got != want {
+		t.Errorf("Expected %s after replay, got %s", want, got)
+	}
+}
@@ -0,0 +1,229 @@
+package command
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type MemoryContext struct {
+	DB *DB
+}
+
+var memoryCtx *MemoryContext
+
+func SetMemoryContext(c *MemoryContext) { memoryCtx = c }
+
+var (
+	startupAllocated atomic.Uint64
+	peakAllocated    atomic.Uint64
+)
+
+func InitMemoryCommands() {
+	Register("MEMORY", cmdMemory)
+	Register("OBJECT", cmdObject)
+	startupAllocated.Store(heapAllocated())
+	peakAllocated.Store(0)
+	NotePeakMemory()
+}
+
+var heapObjectsSample = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+
+func heapAllocated() uint64 {
+	samples := make([]metrics.Sample, len(heapObjectsSample))
+	copy(samples, heapObjectsSample)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
+
+// NotePeakMemory records the current heap if it is the largest seen. The
+// server calls it periodically, and every memory report calls it too.
+func NotePeakMemory() uint64 {
+	alloc := heapAllocated()
+	for {
+		peak := peakAllocated.Load()
+		if alloc <= peak || peakAllocated.CompareAndSwap(peak, alloc) {
+			return alloc
+		}
+	}
+}
+
+func cmdMemory(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return wrongArity("memory")
+	}
+	switch strings.ToUpper(args[0].Text) {
+	case "USAGE":
+		return memoryUsage(args[1:])
+	case "STATS":
+		if len(args) != 1 {
+			return wrongArity("memory|stats")
+		}
+		return memoryStats()
+	case "HELP":
+		return memoryHelp()
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + args[0].Text + "'. Try MEMORY HELP."}
+}
+
+func memoryUsage(args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 3 {
+		return wrongArity("memory|usage")
+	}
+	samples := datastructure.DefaultMemorySamples
+	if len(args) == 3 {
+		if !strings.EqualFold(args[1].Text, "SAMPLES") {
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+		n, err := strconv.Atoi(args[2].Text)
+		if err != nil || n < 0 {
+			return resp.Value{Type: resp.Error, Text: "ERR value is out of range, must be positive"}
+		}
+		samples = n
+	}
+	size, ok := memoryCtx.DB.MemoryUsage(args[0].Text, samples)
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.Integer, Number: size}
+}
+
+// DatasetStats estimates the memory of every key with the default sampling.
+func (db *DB) DatasetStats() (keys int, bytes int64) {
+	for _, space := range db.keyspaces() {
+		for _, key := range space.Keys() {
+			if size, ok := space.MemoryUsage(key, datastructure.DefaultMemorySamples); ok {
+				keys++
+				bytes += size
+			}
+		}
+	}
+	return keys, bytes
+}
+
+func memoryStats() resp.Value {
+	total := NotePeakMemory()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	keys, dataset := memoryCtx.DB.DatasetStats()
+	overhead := int64(total) - dataset
+	if overhead < 0 {
+		overhead = 0
+	}
+	var perKey, datasetPct float64
+	if keys > 0 {
+		perKey = float64(dataset) / float64(keys)
+	}
+	if total > 0 {
+		datasetPct = float64(dataset) * 100 / float64(total)
+	}
+	fragmentation := 1.0
+	if m.HeapAlloc > 0 {
+		fragmentation = float64(m.HeapInuse) / float64(m.HeapAlloc)
+	}
+
+	integer := func(n int64) resp.Value { return resp.Value{Type: resp.Integer, Number: n} }
+	float := func(f float64) resp.Value {
+		return resp.Value{Type: resp.BulkString, Text: strconv.FormatFloat(f, 'f', 2, 64)}
+	}
+	stats := []struct {
+		name  string
+		value resp.Value
+	}{
+		{"peak.allocated", integer(int64(peakAllocated.Load()))},
+		{"total.allocated", integer(int64(total))},
+		{"startup.allocated", integer(int64(startupAllocated.Load()))},
+		{"heap.inuse", integer(int64(m.HeapInuse))},
+		{"heap.sys", integer(int64(m.HeapSys))},
+		{"overhead.total", integer(overhead)},
+		{"keys.count", integer(int64(keys))},
+		{"keys.bytes-per-key", float(perKey)},
+		{"dataset.bytes", integer(dataset)},
+		{"dataset.percentage", float(datasetPct)},
+		{"fragmentation", float(fragmentation)},
+		{"gc.cycles", integer(int64(m.NumGC))},
+	}
+	items := make([]resp.Value, 0, 2*len(stats))
+	for _, s := range stats {
+		items = append(items, resp.Value{Type: resp.BulkString, Text: s.name}, s.value)
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func memoryHelp() resp.Value {
+	lines := []string{
+		"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"USAGE <key> [SAMPLES <count>]",
+		"    Estimate the bytes used by a key and its value. Collections are",
+		"    extrapolated from <count> elements (default 5, 0 for all).",
+		"STATS",
+		"    Report the heap, the dataset estimate and the overhead.",
+		"HELP",
+		"    Print this help.",
+	}
+	items := make([]resp.Value, len(lines))
+	for i, l := range lines {
+		items[i] = resp.Value{Type: resp.SimpleString, Text: l}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdObject(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return wrongArity("object")
+	}
+	sub := strings.ToUpper(args[0].Text)
+	if sub == "HELP" {
+		return objectHelp()
+	}
+	switch sub {
+	case "ENCODING", "IDLETIME", "FREQ", "REFCOUNT":
+	default:
+		return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + args[0].Text + "'. Try OBJECT HELP."}
+	}
+	if len(args) != 2 {
+		return wrongArity("object|" + strings.ToLower(sub))
+	}
+	db, key := memoryCtx.DB, args[1].Text
+	enc, ok := db.Encoding(key)
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	switch sub {
+	case "ENCODING":
+		return resp.Value{Type: resp.BulkString, Text: enc}
+	case "IDLETIME":
+		return resp.Value{Type: resp.Integer, Number: int64(db.idle(key).Seconds())}
+	case "FREQ":
+		return resp.Value{Type: resp.Integer, Number: int64(db.freq(key))}
+	}
+	// Values are never shared between keys.
+	return resp.Value{Type: resp.Integer, Number: 1}
+}
+
+func objectHelp() resp.Value {
+	lines := []string{
+		"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"ENCODING <key>",
+		"    Return the kind of internal representation used to store the value",
+		"    at <key>.",
+		"FREQ <key>",
+		"    Return the logarithmic access frequency counter of <key>.",
+		"IDLETIME <key>",
+		"    Return the idle time of <key>, in seconds.",
+		"REFCOUNT <key>",
+		"    Return the number of references of the value at <key>.",
+		"HELP",
+		"    Print this help.",
+	}
+	items := make([]resp.Value, len(lines))
+	for i, l := range lines {
+		items[i] = resp.Value{Type: resp.SimpleString, Text: l}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
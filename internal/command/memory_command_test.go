@@ -0,0 +1,187 @@
+package command
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupMemoryTest(t *testing.T) *DB {
+	t.Helper()
+	db := setupClusterTest(t, nil)
+	SetMemoryContext(&MemoryContext{DB: db})
+	SetSystemContext(&SystemContext{DB: db})
+	return db
+}
+
+func TestCmdType(t *testing.T) {
+	db := setupMemoryTest(t)
+	db.Dict.Set("s", "v", 0)
+	db.List.Rpush("l", "a")
+	db.ZSet.Zstore("z", nil)
+	if err := db.Sketch.CMSInit("cms", 10, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{"s": "string", "l": "list", "z": "none", "cms": "CMSk-TYPE", "missing": "none"}
+	for key, want := range tests {
+		if got := cmdType(bulkArgs(key)); got.Type != resp.SimpleString || got.Text != want {
+			t.Errorf("TYPE %s: expected %s, got %+v", key, want, got)
+		}
+	}
+}
+
+func TestCmdMemoryUsage(t *testing.T) {
+	db := setupMemoryTest(t)
+	db.Dict.Set("s", "value", 0)
+	for _, m := range []string{"a", "b", "c"} {
+		db.Set.Sadd("set", m)
+	}
+
+	got := cmdMemory(bulkArgs("USAGE", "s"))
+	if want, _ := db.Dict.MemoryUsage("s", 0); got.Type != resp.Integer || got.Number != want {
+		t.Errorf("Expected %d bytes for s, got %+v", want, got)
+	}
+	if got := cmdMemory(bulkArgs("usage", "set", "SAMPLES", "0")); got.Type != resp.Integer || got.Number <= 0 {
+		t.Errorf("Expected a size for set, got %+v", got)
+	}
+	if got := cmdMemory(bulkArgs("USAGE", "missing")); !got.IsNil {
+		t.Errorf("Expected nil for a missing key, got %+v", got)
+	}
+	for _, args := range [][]string{{"USAGE"}, {"USAGE", "s", "SAMPLES"}, {"USAGE", "s", "SAMPLES", "-1"}, {"USAGE", "s", "LIMIT", "1"}, {"NOPE"}} {
+		if got := cmdMemory(bulkArgs(args...)); got.Type != resp.Error {
+			t.Errorf("MEMORY %v: expected an error, got %+v", args, got)
+		}
+	}
+}
+
+func TestCmdMemoryStats(t *testing.T) {
+	db := setupMemoryTest(t)
+	db.Dict.Set("a", "1", 0)
+	db.Hash.Hset("b", "f", "v")
+
+	got := cmdMemory(bulkArgs("STATS"))
+	if got.Type != resp.Array || len(got.Items)%2 != 0 {
+		t.Fatalf("Expected name/value pairs, got %+v", got)
+	}
+	stats := map[string]resp.Value{}
+	for i := 0; i < len(got.Items); i += 2 {
+		stats[got.Items[i].Text] = got.Items[i+1]
+	}
+	if stats["keys.count"].Number != 2 {
+		t.Errorf("Expected 2 keys, got %+v", stats["keys.count"])
+	}
+	a, _ := db.MemoryUsage("a", 5)
+	b, _ := db.MemoryUsage("b", 5)
+	if stats["dataset.bytes"].Number != a+b {
+		t.Errorf("Expected dataset of %d bytes, got %+v", a+b, stats["dataset.bytes"])
+	}
+	if stats["peak.allocated"].Number < stats["total.allocated"].Number {
+		t.Errorf("Expected peak to be at least the current heap, got %+v", stats)
+	}
+}
+
+func TestCmdObject(t *testing.T) {
+	db := setupMemoryTest(t)
+	trackAccess(t, db)
+	db.Dict.Set("n", "42", 0)
+	db.Set.Sadd("ints", "1", "2")
+	db.Hash.Hset("h", "f", "v")
+	db.ZSet.Zadd("z", datastructure.ZAddFlags{}, datastructure.ScoreMember{Member: "m", Score: 1})
+	if _, err := db.JSON.JSONSet("j", "$", "{}", false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{"n": "int", "ints": "intset", "h": "listpack", "z": "skiplist", "j": "raw"}
+	for key, want := range tests {
+		if got := cmdObject(bulkArgs("ENCODING", key)); got.Text != want {
+			t.Errorf("OBJECT ENCODING %s: expected %s, got %+v", key, want, got)
+		}
+	}
+
+	for range 100 {
+		db.Touch("n")
+	}
+	freq := cmdObject(bulkArgs("freq", "n"))
+	if freq.Type != resp.Integer || freq.Number <= int64(datastructure.LFUInitVal) {
+		t.Errorf("Expected FREQ above the initial value after 100 hits, got %+v", freq)
+	}
+	if got := cmdObject(bulkArgs("IDLETIME", "n")); got.Type != resp.Integer || got.Number != 0 {
+		t.Errorf("Expected IDLETIME 0, got %+v", got)
+	}
+	if got := cmdObject(bulkArgs("REFCOUNT", "n")); got.Number != 1 {
+		t.Errorf("Expected REFCOUNT 1, got %+v", got)
+	}
+	if got := cmdObject(bulkArgs("ENCODING", "missing")); !got.IsNil {
+		t.Errorf("Expected nil for a missing key, got %+v", got)
+	}
+	for _, args := range [][]string{{}, {"ENCODING"}, {"ENCODING", "n", "x"}, {"NOPE", "n"}} {
+		if got := cmdObject(bulkArgs(args...)); got.Type != resp.Error {
+			t.Errorf("OBJECT %v: expected an error, got %+v", args, got)
+		}
+	}
+	if TouchesKeys("object") || TouchesKeys("MEMORY") || !TouchesKeys("GET") {
+		t.Error("Expected only introspection commands to leave access times alone")
+	}
+}
+
+func TestCmdScan(t *testing.T) {
+	db := setupMemoryTest(t)
+	want := map[string]bool{}
+	for i := range 50 {
+		key := "str:" + strconv.Itoa(i)
+		db.Dict.Set(key, "v", 0)
+		want[key] = true
+	}
+	db.Hash.Hset("hash:1", "f", "v")
+	db.List.Rpush("list:1", "a")
+	if err := db.Sketch.CMSInit("cms", 10, 2); err != nil {
+		t.Fatal(err)
+	}
+	want["hash:1"], want["list:1"], want["cms"] = true, true, true
+
+	scan := func(extra ...string) map[string]int {
+		seen := map[string]int{}
+		cursor := "0"
+		for calls := 0; ; calls++ {
+			if calls > 1000 {
+				t.Fatal("SCAN did not terminate")
+			}
+			got := cmdScan(bulkArgs(append([]string{cursor}, extra...)...))
+			if got.Type != resp.Array || len(got.Items) != 2 {
+				t.Fatalf("Unexpected reply %+v", got)
+			}
+			for _, key := range got.Items[1].Items {
+				seen[key.Text]++
+			}
+			cursor = got.Items[0].Text
+			if cursor == "0" {
+				return seen
+			}
+		}
+	}
+
+	seen := scan("COUNT", "3")
+	if len(seen) != len(want) {
+		t.Errorf("Expected %d keys, got %d", len(want), len(seen))
+	}
+	for key, n := range seen {
+		if !want[key] || n != 1 {
+			t.Errorf("Key %s returned %d times", key, n)
+		}
+	}
+	if seen := scan("MATCH", "str:1*"); len(seen) != 11 {
+		t.Errorf("Expected 11 matches, got %d", len(seen))
+	}
+	if seen := scan("TYPE", "CMSk-TYPE", "COUNT", "100"); len(seen) != 1 || seen["cms"] != 1 {
+		t.Errorf("Expected only cms, got %v", seen)
+	}
+
+	for _, args := range [][]string{{"x"}, {"0", "COUNT", "0"}, {"0", "FOO", "1"}, {"0", "MATCH"}} {
+		if got := cmdScan(bulkArgs(args...)); got.Type != resp.Error {
+			t.Errorf("SCAN %v: expected an error, got %+v", args, got)
+		}
+	}
+}
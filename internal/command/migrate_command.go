@@ -0,0 +1,371 @@
+package command
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+var (
+	errBusyKey     = resp.Value{Type: resp.Error, Text: "BUSYKEY Target key name already exists."}
+	errBadPayload  = resp.Value{Type: resp.Error, Text: "ERR " + persistence.ErrDumpPayload.Error()}
+	errInvalidTTL  = resp.Value{Type: resp.Error, Text: "ERR Invalid TTL value, must be >= 0"}
+	errTTLNotKept  = resp.Value{Type: resp.Error, Text: "ERR only string and set keys can have a TTL"}
+	errRestoreDown = resp.Value{Type: resp.Error, Text: "ERR restore is not available"}
+)
+
+func (db *DB) dumpKey(key string) ([]byte, time.Time, error) {
+	if !db.Exists(key) {
+		return nil, time.Time{}, nil
+	}
+	snapshot := db.SnapshotKeys(key)
+	var expiredAt time.Time
+	if item, ok := snapshot.DictData[key]; ok {
+		expiredAt, item.ExpiredAt = item.ExpiredAt, time.Time{}
+		snapshot.DictData[key] = item
+	}
+	if item, ok := snapshot.SetData[key]; ok {
+		expiredAt, item.ExpiredAt = item.ExpiredAt, time.Time{}
+		snapshot.SetData[key] = item
+	}
+	payload, err := persistence.EncodeDump(snapshot, key)
+	return payload, expiredAt, err
+}
+
+type restoreEntry struct {
+	key      string
+	payload  string
+	snapshot *persistence.Snapshot
+	// expireAt is zero for a key without a TTL.
+	expireAt time.Time
+}
+
+func newRestoreEntry(key, ttlArg, payload string, abs bool, now time.Time) (restoreEntry, *resp.Value) {
+	ttl, err := strconv.ParseInt(ttlArg, 10, 64)
+	if err != nil || ttl < 0 {
+		return restoreEntry{}, &errInvalidTTL
+	}
+	snapshot, err := persistence.DecodeDump([]byte(payload), key)
+	if err != nil {
+		return restoreEntry{}, &errBadPayload
+	}
+	e := restoreEntry{key: key, payload: payload, snapshot: snapshot}
+	if ttl == 0 {
+		return e, nil
+	}
+	if len(snapshot.DictData) == 0 && len(snapshot.SetData) == 0 {
+		return restoreEntry{}, &errTTLNotKept
+	}
+	if abs {
+		e.expireAt = time.UnixMilli(ttl)
+	} else {
+		e.expireAt = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+	for k, item := range snapshot.DictData {
+		item.ExpiredAt = e.expireAt
+		snapshot.DictData[k] = item
+	}
+	for k, item := range snapshot.SetData {
+		item.ExpiredAt = e.expireAt
+		snapshot.SetData[k] = item
+	}
+	return e, nil
+}
+
+func (db *DB) restoreKeys(entries []restoreEntry, replace bool, now time.Time) *resp.Value {
+	if !replace {
+		for _, e := range entries {
+			if db.Exists(e.key) {
+				return &errBusyKey
+			}
+		}
+	}
+	for _, e := range entries {
+		deleted := db.Delete(e.key) > 0
+		if !e.expireAt.IsZero() && !e.expireAt.After(now) {
+			if deleted {
+				appendToAOF(db.AOF, "DEL", []resp.Value{{Type: resp.BulkString, Text: e.key}})
+			}
+			continue
+		}
+		db.Restore(e.snapshot)
+		ttl := "0"
+		if !e.expireAt.IsZero() {
+			ttl = strconv.FormatInt(e.expireAt.UnixMilli(), 10)
+		}
+		appendToAOF(db.AOF, "RESTORE", []resp.Value{
+			{Type: resp.BulkString, Text: e.key},
+			{Type: resp.BulkString, Text: ttl},
+			{Type: resp.BulkString, Text: e.payload},
+			{Type: resp.BulkString, Text: "REPLACE"},
+			{Type: resp.BulkString, Text: "ABSTTL"},
+		})
+	}
+	return nil
+}
+
+func cmdDump(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("dump")
+	}
+	if clusterCtx == nil || clusterCtx.DB == nil {
+		return errRestoreDown
+	}
+	payload, _, err := clusterCtx.DB.dumpKey(args[0].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	if payload == nil {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.BulkString, Text: string(payload)}
+}
+
+func cmdRestore(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return wrongArity("restore")
+	}
+	syntaxErr := resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	replace, abs := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			abs = true
+		case "IDLETIME":
+			if i+1 >= len(args) || freq != -1 {
+				return syntaxErr
+			}
+			n, err := strconv.ParseInt(args[i+1].Text, 10, 64)
+			if err != nil || n < 0 {
+				return resp.Value{Type: resp.Error, Text: "ERR Invalid IDLETIME value, must be >= 0"}
+			}
+			idle = n
+			i++
+		case "FREQ":
+			if i+1 >= len(args) || idle != -1 {
+				return syntaxErr
+			}
+			n, err := strconv.ParseInt(args[i+1].Text, 10, 64)
+			if err != nil || n < 0 || n > 255 {
+				return resp.Value{Type: resp.Error, Text: "ERR Invalid FREQ value, must be >= 0 and <= 255"}
+			}
+			freq = n
+			i++
+		default:
+			return syntaxErr
+		}
+	}
+	if clusterCtx == nil || clusterCtx.DB == nil {
+		return errRestoreDown
+	}
+	db := clusterCtx.DB
+
+	now := time.Now()
+	key := args[0].Text
+	e, errReply := newRestoreEntry(key, args[1].Text, args[2].Text, abs, now)
+	if errReply != nil {
+		return *errReply
+	}
+	if errReply := db.restoreKeys([]restoreEntry{e}, replace, now); errReply != nil {
+		return *errReply
+	}
+	if store := db.tracked(key); db.Access != nil && store != nil {
+		if idle >= 0 {
+			db.Access.SetIdle(store, key, time.Duration(idle)*time.Second)
+		}
+		if freq >= 0 {
+			db.Access.SetFreq(store, key, uint8(freq))
+		}
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+type migrateOptions struct {
+	host, port string
+	keys       []string
+	timeout    time.Duration
+	copy       bool
+	replace    bool
+	auth       []string
+}
+
+func parseMigrate(args []resp.Value) (*migrateOptions, *resp.Value) {
+	if len(args) < 5 {
+		v := wrongArity("migrate")
+		return nil, &v
+	}
+	syntaxErr := &resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	opts := &migrateOptions{host: args[0].Text, port: args[1].Text}
+	if db, err := strconv.Atoi(args[3].Text); err != nil || db != 0 {
+		return nil, &resp.Value{Type: resp.Error, Text: "ERR DB index is out of range"}
+	}
+	ms, err := strconv.ParseInt(args[4].Text, 10, 64)
+	if err != nil || ms < 0 {
+		return nil, &resp.Value{Type: resp.Error, Text: "ERR timeout is not an integer or out of range"}
+	}
+	if ms == 0 {
+		ms = 1000
+	}
+	opts.timeout = time.Duration(ms) * time.Millisecond
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "COPY":
+			opts.copy = true
+		case "REPLACE":
+			opts.replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return nil, syntaxErr
+			}
+			opts.auth = []string{args[i+1].Text}
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return nil, syntaxErr
+			}
+			opts.auth = []string{args[i+1].Text, args[i+2].Text}
+			i += 2
+		case "KEYS":
+			if args[2].Text != "" {
+				return nil, &resp.Value{Type: resp.Error, Text: "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"}
+			}
+			opts.keys = textArgs(args[i+1:])
+			i = len(args)
+		default:
+			return nil, syntaxErr
+		}
+	}
+	if opts.keys == nil {
+		opts.keys = []string{args[2].Text}
+	}
+	return opts, nil
+}
+
+func cmdMigrate(args []resp.Value) resp.Value {
+	opts, errReply := parseMigrate(args)
+	if errReply != nil {
+		return *errReply
+	}
+	if clusterCtx == nil || clusterCtx.DB == nil {
+		return resp.Value{Type: resp.Error, Text: "ERR migration is not available"}
+	}
+	db := clusterCtx.DB
+
+	now := time.Now()
+	restore := []string{"RESTORE-ASKING"}
+	var keys []string
+	for _, key := range opts.keys {
+		payload, expiredAt, err := db.dumpKey(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if payload == nil {
+			continue
+		}
+		ttl := int64(0)
+		if !expiredAt.IsZero() {
+			ttl = max(expiredAt.Sub(now).Milliseconds(), 1)
+		}
+		restore = append(restore, key, strconv.FormatInt(ttl, 10), string(payload))
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return resp.Value{Type: resp.SimpleString, Text: "NOKEY"}
+	}
+	if opts.replace {
+		restore = append(restore, "REPLACE")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(opts.host, opts.port), opts.timeout)
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "IOERR error or timeout connecting to the client"}
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	call := func(parts ...string) (resp.Value, error) {
+		items := make([]resp.Value, len(parts))
+		for i, p := range parts {
+			items[i] = resp.Value{Type: resp.BulkString, Text: p}
+		}
+		_ = conn.SetDeadline(time.Now().Add(opts.timeout))
+		if _, err := conn.Write([]byte(resp.Encode(resp.Value{Type: resp.Array, Items: items}))); err != nil {
+			return resp.Value{}, err
+		}
+		return resp.Decode(reader)
+	}
+	targetErr := func(v resp.Value) resp.Value {
+		return resp.Value{Type: resp.Error, Text: "ERR Target instance replied with error: " + v.Text}
+	}
+	ioErr := resp.Value{Type: resp.Error, Text: "IOERR error or timeout reading to target instance"}
+
+	if opts.auth != nil {
+		reply, err := call(append([]string{"AUTH"}, opts.auth...)...)
+		if err != nil {
+			return ioErr
+		}
+		if reply.Type == resp.Error {
+			return targetErr(reply)
+		}
+	}
+	reply, err := call(restore...)
+	if err != nil {
+		return ioErr
+	}
+	if reply.Type == resp.Error {
+		return targetErr(reply)
+	}
+
+	if !opts.copy {
+		db.Delete(keys...)
+		delArgs := make([]resp.Value, len(keys))
+		for i, key := range keys {
+			delArgs[i] = resp.Value{Type: resp.BulkString, Text: key}
+		}
+		appendToAOF(db.AOF, "DEL", delArgs)
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdRestoreAsking(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return wrongArity("restore-asking")
+	}
+	triples := len(args) / 3
+	replace, abs := false, false
+	for _, opt := range args[triples*3:] {
+		switch strings.ToUpper(opt.Text) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			abs = true
+		default:
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+	}
+	if clusterCtx == nil || clusterCtx.DB == nil {
+		return errRestoreDown
+	}
+
+	now := time.Now()
+	entries := make([]restoreEntry, 0, triples)
+	for i := 0; i < triples*3; i += 3 {
+		e, errReply := newRestoreEntry(args[i].Text, args[i+1].Text, args[i+2].Text, abs, now)
+		if errReply != nil {
+			return *errReply
+		}
+		entries = append(entries, e)
+	}
+	if errReply := clusterCtx.DB.restoreKeys(entries, replace, now); errReply != nil {
+		return *errReply
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func TestCmdDumpRestore(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	db.Access = datastructure.CreateAccessTable()
+	db.Dict.Set("s", "v", time.Hour)
+	db.Hash.Hset("h", "f", "v")
+
+	if result := cmdDump(bulkArgs("missing")); !result.IsNil {
+		t.Errorf("Expected nil for a missing key, got %v", result)
+	}
+	dumped := cmdDump(bulkArgs("s"))
+	if dumped.Type != resp.BulkString || dumped.Text == "" {
+		t.Fatalf("Expected a payload, got %v", dumped)
+	}
+	payload := dumped.Text
+
+	if result := cmdRestore(bulkArgs("s", "0", payload)); !strings.HasPrefix(result.Text, "BUSYKEY") {
+		t.Errorf("Expected BUSYKEY, got %v", result)
+	}
+	if result := cmdRestore(bulkArgs("copy", "5000", payload)); result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if v, _ := db.Dict.Get("copy"); v != "v" {
+		t.Errorf("Expected copy=v, got %q", v)
+	}
+	if ttl := db.Dict.TTL("copy"); ttl < 3 || ttl > 5 {
+		t.Errorf("Expected a TTL of about 5 seconds, got %d", ttl)
+	}
+
+	abs := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+	if result := cmdRestore(bulkArgs("copy", abs, payload, "REPLACE", "ABSTTL")); result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if ttl := db.Dict.TTL("copy"); ttl < 50 || ttl > 60 {
+		t.Errorf("Expected an absolute TTL about a minute away, got %d", ttl)
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	if result := cmdRestore(bulkArgs("copy", past, payload, "REPLACE", "ABSTTL")); result.Text != "OK" || db.Exists("copy") {
+		t.Errorf("Expected a key restored already expired to be gone, got %v", result)
+	}
+
+	hash := cmdDump(bulkArgs("h")).Text
+	if result := cmdRestore(bulkArgs("h2", "0", hash, "IDLETIME", "100")); result.Text != "OK" {
+		t.Fatalf("Expected OK, got %v", result)
+	}
+	if idle := db.idle("h2"); idle < 100*time.Second {
+		t.Errorf("Expected h2 idle for 100s, got %v", idle)
+	}
+	if result := cmdRestore(bulkArgs("h3", "0", hash, "FREQ", "200")); result.Text != "OK" || db.freq("h3") != 200 {
+		t.Errorf("Expected h3 with a counter of 200, got %v", result)
+	}
+	if v, _ := db.Hash.Hget("h3", "f"); v != "v" {
+		t.Errorf("Expected h3.f=v, got %q", v)
+	}
+
+	corrupt := []byte(payload)
+	corrupt[len(corrupt)/2] ^= 1
+	for _, args := range [][]string{
+		{"x", "0", string(corrupt)},
+		{"x", "-1", payload},
+		{"x", "1000", hash},
+		{"x", "0", payload, "IDLETIME", "1", "FREQ", "1"},
+		{"x", "0", payload, "FREQ", "256"},
+		{"x", "0", payload, "NOPE"},
+	} {
+		if result := cmdRestore(bulkArgs(args...)); result.Type != resp.Error {
+			t.Errorf("Expected an error for %v, got %v", args[1:], result)
+		}
+	}
+	if db.Exists("x") {
+		t.Error("Expected nothing restored on error")
+	}
+}
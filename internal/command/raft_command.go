@@ -0,0 +1,149 @@
+package command
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/raft"
+)
+
+type RaftContext struct {
+	Raft *raft.Raft
+}
+
+var raftCtx *RaftContext
+
+func SetRaftContext(c *RaftContext) { raftCtx = c }
+
+func InitRaftCommands() {
+	Register("RAFT", cmdRaft)
+}
+
+// RaftEnabled reports whether writes go through a Raft log.
+func RaftEnabled() bool {
+	return raftCtx != nil && raftCtx.Raft != nil
+}
+
+// RaftRequest returns a write in the form its handler logs to the AOF, with
+// relative expiries and "*" timestamps made absolute.
+func RaftRequest(cmd string, req resp.Value) resp.Value {
+	args := req.Items[1:]
+	now := time.Now()
+	at := func(seconds int) string {
+		return strconv.FormatInt(now.Add(time.Duration(seconds)*time.Second).UnixMilli(), 10)
+	}
+	switch cmd {
+	case "SET":
+		if len(args) > 2 {
+			if n, err := strconv.Atoi(args[2].Text); err == nil && n > 0 {
+				return bulkArray([]string{"SET", args[0].Text, args[1].Text, "PXAT", at(n)})
+			}
+		}
+	case "EXPIRE", "SEXPIRE":
+		if len(args) == 2 {
+			if n, err := strconv.Atoi(args[1].Text); err == nil {
+				return bulkArray([]string{"PEXPIREAT", args[0].Text, at(n)})
+			}
+		}
+	case "TS.ADD", "TS.MADD":
+		items := slices.Clone(req.Items)
+		for i := 2; i < len(items); i += 3 {
+			if items[i].Text == "*" {
+				items[i] = resp.Value{Type: resp.BulkString, Text: strconv.FormatInt(now.UnixMilli(), 10)}
+			}
+			if cmd == "TS.ADD" {
+				break
+			}
+		}
+		return resp.Value{Type: resp.Array, Items: items}
+	case "RESTORE":
+		if len(args) < 3 || slices.ContainsFunc(args[3:], func(a resp.Value) bool { return strings.EqualFold(a.Text, "ABSTTL") }) {
+			break
+		}
+		if ttl, err := strconv.ParseInt(args[1].Text, 10, 64); err == nil && ttl > 0 {
+			items := append(slices.Clone(req.Items), resp.Value{Type: resp.BulkString, Text: "ABSTTL"})
+			items[2] = resp.Value{Type: resp.BulkString, Text: strconv.FormatInt(now.UnixMilli()+ttl, 10)}
+			return resp.Value{Type: resp.Array, Items: items}
+		}
+	}
+	return req
+}
+
+func bulkArray(texts []string) resp.Value {
+	items := make([]resp.Value, len(texts))
+	for i, t := range texts {
+		items[i] = resp.Value{Type: resp.BulkString, Text: t}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+var errRaftDisabled = resp.Value{Type: resp.Error, Text: "ERR This instance has raft replication disabled"}
+
+func raftInfo(r *raft.Raft) []string {
+	st := r.Status()
+	lines := []string{
+		"raft_node_id:" + st.ID,
+		"raft_role:" + st.Role,
+		fmt.Sprintf("raft_term:%d", st.Term),
+		"raft_leader:" + st.Leader,
+		fmt.Sprintf("raft_commit_index:%d", st.CommitIndex),
+		fmt.Sprintf("raft_last_applied:%d", st.LastApplied),
+		fmt.Sprintf("raft_last_index:%d", st.LastIndex),
+		fmt.Sprintf("raft_snapshot_index:%d", st.SnapshotAt),
+		fmt.Sprintf("raft_members:%d", len(st.Members)),
+	}
+	for i, m := range st.Members {
+		lines = append(lines, fmt.Sprintf("member%d:id=%s,addr=%s,client_addr=%s", i, m.ID, m.Addr, m.ClientAddr))
+	}
+	return lines
+}
+
+func cmdRaft(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return wrongArity("raft")
+	}
+	if !RaftEnabled() {
+		return errRaftDisabled
+	}
+	r := raftCtx.Raft
+	name := args[0].Text
+	args = args[1:]
+
+	switch strings.ToUpper(name) {
+	case "INFO":
+		return resp.Value{Type: resp.BulkString, Text: strings.Join(raftInfo(r), "\r\n") + "\r\n"}
+	case "LEADER":
+		st := r.Status()
+		for _, m := range st.Members {
+			if m.ID == st.Leader {
+				return resp.Value{Type: resp.Array, Items: []resp.Value{
+					{Type: resp.BulkString, Text: m.ID},
+					{Type: resp.BulkString, Text: m.ClientAddr},
+				}}
+			}
+		}
+		return resp.Value{Type: resp.Array, IsNil: true}
+	case "ADDNODE":
+		if len(args) != 2 && len(args) != 3 {
+			return wrongArity("raft|addnode")
+		}
+		m := raft.Member{ID: args[0].Text, Addr: args[1].Text}
+		if len(args) == 3 {
+			m.ClientAddr = args[2].Text
+		}
+		return okOrError(r.AddMember(m))
+	case "REMOVENODE":
+		if len(args) != 1 {
+			return wrongArity("raft|removenode")
+		}
+		return okOrError(r.RemoveMember(args[0].Text))
+	case "SNAPSHOT":
+		r.Snapshot()
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown subcommand '" + name + "'. Try RAFT HELP."}
+}
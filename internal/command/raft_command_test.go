@@ -0,0 +1,121 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/raft"
+)
+
+func TestCmdRaftDisabled(t *testing.T) {
+	SetRaftContext(&RaftContext{})
+	if result := cmdRaft(bulkArgs("INFO")); result.Type != resp.Error || !strings.Contains(result.Text, "raft replication disabled") {
+		t.Errorf("Expected raft disabled error, got %v", result)
+	}
+}
+
+func TestCmdRaft(t *testing.T) {
+	db := setupClusterTest(t, nil)
+	r, err := raft.New(raft.Config{
+		ID:              "n1",
+		Addr:            "127.0.0.1:0",
+		ClientAddr:      "127.0.0.1:6379",
+		Peers:           []raft.Member{{ID: "n1", Addr: "127.0.0.1:0", ClientAddr: "127.0.0.1:6379"}},
+		ElectionTimeout: 50 * time.Millisecond,
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDictContext(&DictContext{Dict: db.Dict, DB: db})
+	InitDictCommands()
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	SetRaftContext(&RaftContext{Raft: r})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("single node never became leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := r.Propose(resp.Value{Type: resp.Array, Items: bulkArgs("SET", "k", "v")})
+	if err != nil || result.Text != "OK" {
+		t.Fatalf("Propose SET: %v %v", result, err)
+	}
+	if v, _ := db.Dict.Get("k"); v != "v" {
+		t.Errorf("Expected k=v after commit, got %q", v)
+	}
+
+	result = cmdRaft(bulkArgs("LEADER"))
+	if len(result.Items) != 2 || result.Items[0].Text != "n1" || result.Items[1].Text != "127.0.0.1:6379" {
+		t.Errorf("Unexpected RAFT LEADER reply: %v", result)
+	}
+	result = cmdRaft(bulkArgs("INFO"))
+	if !strings.Contains(result.Text, "raft_role:leader") || !strings.Contains(result.Text, "raft_members:1") {
+		t.Errorf("Unexpected RAFT INFO reply: %q", result.Text)
+	}
+	if result = cmdRaft(bulkArgs("SNAPSHOT")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if st := r.Status(); st.SnapshotAt == 0 {
+		t.Error("RAFT SNAPSHOT should compact the log")
+	}
+	result = cmdRaft(bulkArgs("REMOVENODE", "nosuch"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected error removing unknown node, got %v", result)
+	}
+	result = cmdRaft(bulkArgs("NOSUCH"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected unknown subcommand error, got %v", result)
+	}
+}
+
+func TestRaftRequest(t *testing.T) {
+	soon := time.Now().Add(100 * time.Second).UnixMilli()
+	request := func(args ...string) resp.Value {
+		return RaftRequest(strings.ToUpper(args[0]), resp.Value{Type: resp.Array, Items: bulkArgs(args...)})
+	}
+	// near checks that s is a millisecond timestamp within a second of want.
+	near := func(s string, want int64) bool {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		return err == nil && ms >= want && ms < want+1000
+	}
+
+	if r := request("SET", "k", "v", "100"); len(r.Items) != 5 || r.Items[3].Text != "PXAT" || !near(r.Items[4].Text, soon) {
+		t.Errorf("Expected SET with an absolute expiry, got %v", r.Items)
+	}
+	if r := request("EXPIRE", "k", "100"); r.Items[0].Text != "PEXPIREAT" || !near(r.Items[2].Text, soon) {
+		t.Errorf("Expected PEXPIREAT, got %v", r.Items)
+	}
+	if r := request("SEXPIRE", "s", "100"); r.Items[0].Text != "PEXPIREAT" || !near(r.Items[2].Text, soon) {
+		t.Errorf("Expected PEXPIREAT, got %v", r.Items)
+	}
+	if r := request("TS.ADD", "ts", "*", "1"); !near(r.Items[2].Text, soon-100000) {
+		t.Errorf("Expected an explicit timestamp, got %v", r.Items)
+	}
+	if r := request("TS.MADD", "a", "5", "1", "b", "*", "2"); r.Items[2].Text != "5" || !near(r.Items[5].Text, soon-100000) {
+		t.Errorf("Expected only * replaced, got %v", r.Items)
+	}
+	if r := request("RESTORE", "k", "100000", "payload"); len(r.Items) != 5 || r.Items[4].Text != "ABSTTL" || !near(r.Items[2].Text, soon) {
+		t.Errorf("Expected RESTORE with an absolute TTL, got %v", r.Items)
+	}
+	for _, args := range [][]string{
+		{"SET", "k", "v"},
+		{"EXPIRE", "k", "x"},
+		{"RESTORE", "k", "0", "payload"},
+		{"RESTORE", "k", "5", "payload", "ABSTTL"},
+		{"INCR", "k"},
+	} {
+		want := resp.Value{Type: resp.Array, Items: bulkArgs(args...)}
+		if r := request(args...); resp.Encode(r) != resp.Encode(want) {
+			t.Errorf("Expected %v unchanged, got %v", args, r.Items)
+		}
+	}
+}
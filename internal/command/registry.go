@@ -1,11 +1,21 @@
 package command
 
 import (
+	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/william1nguyen/valkeydb/internal/acl"
+	"github.com/william1nguyen/valkeydb/internal/backup"
+	"github.com/william1nguyen/valkeydb/internal/cluster"
+	"github.com/william1nguyen/valkeydb/internal/crdt"
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
 	"github.com/william1nguyen/valkeydb/internal/persistence"
 	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/raft"
+	"github.com/william1nguyen/valkeydb/internal/replication"
 )
 
 type Handler func(args []resp.Value) resp.Value
@@ -15,20 +25,118 @@ type DB struct {
 	Set    *datastructure.Set
 	List   *datastructure.List
 	Hash   *datastructure.HashMap
+	ZSet   *datastructure.ZSet
+	JSON   *datastructure.JSONStore
+	TS     *datastructure.TimeSeries
+	Sketch *datastructure.Sketches
 	AOF    *persistence.AOF
 	RDB    *persistence.RDB
 	Pubsub *datastructure.Pubsub
+	// Access records key use for LRU and LFU eviction; nil disables
+	// tracking.
+	Access *datastructure.AccessTable
+	Repl   *replication.Manager
+	// Cluster is nil unless the server runs in cluster mode.
+	Cluster *cluster.Cluster
+	// Raft is nil unless writes are replicated through a Raft log.
+	Raft *raft.Raft
+	// CRDT is nil unless writes are merged with active-active peers.
+	CRDT *crdt.Node
+	// Backups is nil unless point-in-time backups are written.
+	Backups *backup.Manager
+	// ACL holds the users and what each may run.
+	ACL *acl.Users
+
+	// writeMu is held shared by writes and exclusively while a replication
+	// snapshot is taken.
+	writeMu sync.RWMutex
 }
 
 var (
 	registry = map[string]Handler{}
 )
 
+var writeCommands = map[string]bool{
+	"SET": true, "DEL": true, "INCR": true, "DECR": true, "INCRBY": true, "DECRBY": true, "EXPIRE": true, "PEXPIREAT": true,
+	"SETBIT": true, "BITOP": true, "BITFIELD": true,
+	"SADD": true, "SREM": true, "SEXPIRE": true,
+	"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true,
+	"HSET": true, "HDEL": true,
+	"ZADD": true, "ZREM": true, "GEOADD": true, "GEOSEARCHSTORE": true,
+	"JSON.SET": true, "JSON.DEL": true, "JSON.FORGET": true, "JSON.NUMINCRBY": true,
+	"JSON.ARRAPPEND": true, "JSON.ARRINSERT": true, "JSON.ARRPOP": true,
+	"TS.CREATE": true, "TS.ADD": true, "TS.MADD": true, "TS.CREATERULE": true, "TS.DELETERULE": true,
+	"BF.RESERVE": true, "BF.ADD": true, "BF.MADD": true, "BF.LOADCHUNK": true,
+	"CF.RESERVE": true, "CF.ADD": true, "CF.ADDNX": true, "CF.DEL": true, "CF.LOADCHUNK": true,
+	"CMS.INITBYDIM": true, "CMS.INITBYPROB": true, "CMS.INCRBY": true, "CMS.LOADCHUNK": true,
+	"TOPK.RESERVE": true, "TOPK.ADD": true, "TOPK.LOADCHUNK": true,
+	"MIGRATE": true, "RESTORE-ASKING": true, "RESTORE": true,
+}
+
+var keylessCommands = map[string]bool{
+	"PING": true, "AUTH": true, "INFO": true, "BGSAVE": true, "KEYS": true, "SCAN": true, "MONITOR": true,
+	"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PUBLISH": true,
+	"REPLICAOF": true, "SLAVEOF": true, "REPLCONF": true, "ROLE": true, "WAIT": true, "WAITAOF": true,
+	"TS.MRANGE": true, "TS.MREVRANGE": true,
+	"CLUSTER": true, "ASKING": true, "MIGRATE": true, "RAFT": true, "CRDT": true,
+	"BACKUP": true, "ACL": true,
+}
+
+var noTouchCommands = map[string]bool{"OBJECT": true, "MEMORY": true, "TYPE": true}
+
+// TouchesKeys reports whether running the command counts as using its keys.
+func TouchesKeys(name string) bool {
+	return !noTouchCommands[strings.ToUpper(name)]
+}
+
+// IsWrite reports whether the command may modify the dataset.
+func IsWrite(name string) bool {
+	return writeCommands[strings.ToUpper(name)]
+}
+
+// CommandKeys returns the keys a command reads or writes. Most commands take
+// a single key as their first argument.
+func CommandKeys(name string, args []resp.Value) []string {
+	name = strings.ToUpper(name)
+	if keylessCommands[name] || len(args) == 0 {
+		return nil
+	}
+	var keys []string
+	switch name {
+	case "DEL":
+		keys = textArgs(args)
+	case "BITOP":
+		keys = textArgs(args[1:])
+	case "MEMORY":
+		if strings.EqualFold(args[0].Text, "USAGE") && len(args) > 1 {
+			keys = []string{args[1].Text}
+		}
+	case "OBJECT":
+		if len(args) > 1 {
+			keys = []string{args[1].Text}
+		}
+	case "GEOSEARCHSTORE", "TS.CREATERULE", "TS.DELETERULE":
+		keys = textArgs(args[:min(2, len(args))])
+	case "TS.MADD":
+		for i := 0; i < len(args); i += 3 {
+			keys = append(keys, args[i].Text)
+		}
+	case "RESTORE-ASKING":
+		for i := 0; i+2 < len(args); i += 3 {
+			keys = append(keys, args[i].Text)
+		}
+	default:
+		keys = []string{args[0].Text}
+	}
+	return keys
+}
+
 func Init(db *DB) {
 	registry = map[string]Handler{}
 
-	SetDictContext(&DictContext{Dict: db.Dict, AOF: db.AOF})
+	SetDictContext(&DictContext{Dict: db.Dict, AOF: db.AOF, DB: db})
 	InitDictCommands()
+	InitBitmapCommands()
 
 	SetSetContext(&SetContext{Set: db.Set, AOF: db.AOF})
 	InitSetCommands()
@@ -39,11 +147,447 @@ func Init(db *DB) {
 	SetSystemContext(&SystemContext{DB: db})
 	InitSystemCommands()
 
+	SetMemoryContext(&MemoryContext{DB: db})
+	InitMemoryCommands()
+
 	SetPubsubContext(&PubsubContext{Pubsub: db.Pubsub})
 	InitPubsubCommands()
 
 	SetHashContext(&HashContext{Hash: db.Hash, AOF: db.AOF})
 	InitHashCommands()
+
+	SetZSetContext(&ZSetContext{ZSet: db.ZSet, AOF: db.AOF})
+	InitZSetCommands()
+	InitGeoCommands()
+
+	SetJSONContext(&JSONContext{JSON: db.JSON, AOF: db.AOF})
+	InitJSONCommands()
+
+	SetTimeSeriesContext(&TimeSeriesContext{TimeSeries: db.TS, AOF: db.AOF})
+	InitTimeSeriesCommands()
+
+	SetSketchContext(&SketchContext{Sketches: db.Sketch, AOF: db.AOF})
+	InitSketchCommands()
+
+	SetReplicationContext(&ReplicationContext{Repl: db.Repl, AOF: db.AOF})
+	InitReplicationCommands()
+
+	SetClusterContext(&ClusterContext{Cluster: db.Cluster, DB: db})
+	InitClusterCommands()
+
+	SetRaftContext(&RaftContext{Raft: db.Raft})
+	InitRaftCommands()
+
+	SetActiveActiveContext(&ActiveActiveContext{Node: db.CRDT})
+	InitActiveActiveCommands()
+
+	SetBackupContext(&BackupContext{Backups: db.Backups, DB: db})
+	InitBackupCommands()
+
+	SetACLContext(&ACLContext{Users: db.ACL})
+	InitACLCommands()
+}
+
+func (db *DB) Snapshot() persistence.Snapshot {
+	return db.SnapshotKeys()
+}
+
+// SnapshotKeys copies the given keys of every type, or the whole dataset
+// when none are given.
+func (db *DB) SnapshotKeys(keys ...string) persistence.Snapshot {
+	snapshot := persistence.Snapshot{
+		DictData: db.Dict.Dump(keys...),
+		SetData:  db.Set.Dump(keys...),
+		ListData: db.List.Dump(keys...),
+		HashData: db.Hash.Dump(keys...),
+	}
+	if db.ZSet != nil {
+		snapshot.ZSetData = db.ZSet.Dump(keys...)
+	}
+	if db.JSON != nil {
+		snapshot.JSONData = db.JSON.Dump(keys...)
+	}
+	if db.TS != nil {
+		snapshot.TSData = db.TS.Dump(keys...)
+	}
+	if db.Sketch != nil {
+		snapshot.SketchData = db.Sketch.Dump(keys...)
+	}
+	return snapshot
+}
+
+// BackgroundSnapshot is a point-in-time view of the dataset that is written
+// out one store segment at a time rather than copied first.
+type BackgroundSnapshot struct {
+	db   *DB
+	view *datastructure.SnapshotView
+}
+
+// BeginSnapshot fixes the dataset as it is now. Only one may be open at a
+// time, and Close must be called once it is written.
+func (db *DB) BeginSnapshot() *BackgroundSnapshot {
+	stores := []datastructure.SnapshotStore{db.Dict, db.Set, db.List, db.Hash}
+	if db.ZSet != nil {
+		stores = append(stores, db.ZSet)
+	}
+	if db.JSON != nil {
+		stores = append(stores, db.JSON)
+	}
+	if db.TS != nil {
+		stores = append(stores, db.TS)
+	}
+	if db.Sketch != nil {
+		stores = append(stores, db.Sketch)
+	}
+	return &BackgroundSnapshot{db: db, view: datastructure.BeginSnapshot(stores...)}
+}
+
+// Write passes the snapshot to emit in parts of one segment each.
+func (b *BackgroundSnapshot) Write(emit func(persistence.Snapshot) error) error {
+	type part struct {
+		segments int
+		dump     func(i int) persistence.Snapshot
+	}
+	db := b.db
+	parts := []part{
+		{db.Dict.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{DictData: db.Dict.DumpSegment(i)} }},
+		{db.Set.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{SetData: db.Set.DumpSegment(i)} }},
+		{db.List.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{ListData: db.List.DumpSegment(i)} }},
+		{db.Hash.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{HashData: db.Hash.DumpSegment(i)} }},
+	}
+	if db.ZSet != nil {
+		parts = append(parts, part{db.ZSet.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{ZSetData: db.ZSet.DumpSegment(i)} }})
+	}
+	if db.JSON != nil {
+		parts = append(parts, part{db.JSON.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{JSONData: db.JSON.DumpSegment(i)} }})
+	}
+	if db.TS != nil {
+		parts = append(parts, part{db.TS.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{TSData: db.TS.DumpSegment(i)} }})
+	}
+	if db.Sketch != nil {
+		parts = append(parts, part{db.Sketch.Segments(), func(i int) persistence.Snapshot { return persistence.Snapshot{SketchData: db.Sketch.DumpSegment(i)} }})
+	}
+	for _, p := range parts {
+		for i := range p.segments {
+			if err := emit(p.dump(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close ends the snapshot and returns how many bytes of old values it kept
+// for keys written while it was open.
+func (b *BackgroundSnapshot) Close() int64 {
+	return b.view.Close()
+}
+
+type keyspace interface {
+	Keys() []string
+	Exists(key string) bool
+	Delete(keys ...string) int
+	Sample(n int) []string
+	MemoryUsage(key string, samples int) (int64, bool)
+	// Segments and SegmentKeys expose the independently locked parts of a
+	// store so SCAN can walk it one part at a time.
+	Segments() int
+	SegmentKeys(i int) []string
+}
+
+type namedKeyspace struct {
+	keyspace
+	name string
+}
+
+func (db *DB) keyspaces() []namedKeyspace {
+	spaces := []namedKeyspace{{db.Dict, "string"}, {db.Set, "set"}, {db.List, "list"}, {db.Hash, "hash"}}
+	if db.ZSet != nil {
+		spaces = append(spaces, namedKeyspace{db.ZSet, "zset"})
+	}
+	if db.JSON != nil {
+		spaces = append(spaces, namedKeyspace{db.JSON, "ReJSON-RL"})
+	}
+	if db.TS != nil {
+		spaces = append(spaces, namedKeyspace{db.TS, "TSDB-TYPE"})
+	}
+	if db.Sketch != nil {
+		// Sketch keys are named by their kind, see typeOf.
+		spaces = append(spaces, namedKeyspace{db.Sketch, ""})
+	}
+	return spaces
+}
+
+// Keys lists every key of every type.
+func (db *DB) Keys() []string {
+	var keys []string
+	for _, space := range db.keyspaces() {
+		keys = append(keys, space.Keys()...)
+	}
+	return keys
+}
+
+// Scan returns the keys of whole segments from cursor until at least count
+// are found, with the cursor to continue from; 0 when every segment was seen.
+func (db *DB) Scan(cursor uint64, count int, typ string) ([]string, uint64) {
+	var keys []string
+	var base uint64
+	for _, space := range db.keyspaces() {
+		n := uint64(space.Segments())
+		for ; cursor < base+n; cursor++ {
+			if len(keys) >= count {
+				return keys, cursor
+			}
+			for _, key := range space.SegmentKeys(int(cursor - base)) {
+				if typ == "" || strings.EqualFold(space.typeOf(key), typ) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		base += n
+	}
+	return keys, 0
+}
+
+func (space namedKeyspace) typeOf(key string) string {
+	if sk, ok := space.keyspace.(*datastructure.Sketches); ok {
+		return sk.TypeName(key)
+	}
+	return space.name
+}
+
+func (db *DB) Exists(key string) bool {
+	for _, space := range db.keyspaces() {
+		if space.Exists(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Type names the type of key's value, or "none" when it does not exist.
+func (db *DB) Type(key string) string {
+	for _, space := range db.keyspaces() {
+		if space.Exists(key) {
+			return space.typeOf(key)
+		}
+	}
+	return "none"
+}
+
+// Encoding names how key's value is stored, as OBJECT ENCODING reports it.
+func (db *DB) Encoding(key string) (string, bool) {
+	switch db.Type(key) {
+	case "none":
+		return "", false
+	case "string":
+		value, ok := db.Dict.Get(key)
+		return datastructure.StringEncoding(value), ok
+	case "set":
+		return db.Set.Encoding(key)
+	case "list":
+		return db.List.Encoding(key)
+	case "hash":
+		return db.Hash.Encoding(key)
+	case "zset":
+		return datastructure.EncodingSkiplist, true
+	}
+	// Module types are opaque to OBJECT ENCODING in Redis.
+	return datastructure.EncodingRaw, true
+}
+
+// MemoryUsage estimates the bytes key and its value take, measuring up to
+// samples elements of a collection (all of them when samples is 0).
+func (db *DB) MemoryUsage(key string, samples int) (int64, bool) {
+	for _, space := range db.keyspaces() {
+		if size, ok := space.MemoryUsage(key, samples); ok {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// Delete removes keys whatever their type and returns how many existed.
+func (db *DB) Delete(keys ...string) int {
+	n := 0
+	for _, space := range db.keyspaces() {
+		n += space.Delete(keys...)
+	}
+	return n
+}
+
+// Touch records that keys were used, when the eviction policy needs to know.
+// Keys that do not exist are left out.
+func (db *DB) Touch(keys ...string) {
+	if !db.tracksAccess() {
+		return
+	}
+	spaces := db.keyspaces()
+	for _, key := range keys {
+		for _, space := range spaces {
+			if store, ok := space.keyspace.(datastructure.Tracked); ok && db.Access.Touch(store, key) {
+				break
+			}
+		}
+	}
+}
+
+// tracked returns the store holding key, or nil when key does not exist.
+func (db *DB) tracked(key string) datastructure.Tracked {
+	for _, space := range db.keyspaces() {
+		if store, ok := space.keyspace.(datastructure.Tracked); ok && space.Exists(key) {
+			return store
+		}
+	}
+	return nil
+}
+
+func (db *DB) idle(key string) time.Duration {
+	store := db.tracked(key)
+	if db.Access == nil || store == nil {
+		return 0
+	}
+	return db.Access.Idle(store, key)
+}
+
+func (db *DB) freq(key string) uint8 {
+	store := db.tracked(key)
+	if db.Access == nil || store == nil {
+		return datastructure.LFUInitVal
+	}
+	return db.Access.Freq(store, key)
+}
+
+// Flush empties every store.
+func (db *DB) Flush() {
+	db.Dict.Flush()
+	db.Set.Flush()
+	db.List.Flush()
+	db.Hash.Flush()
+	if db.ZSet != nil {
+		db.ZSet.Flush()
+	}
+	if db.JSON != nil {
+		db.JSON.Flush()
+	}
+	if db.TS != nil {
+		db.TS.Flush()
+	}
+	if db.Sketch != nil {
+		db.Sketch.Flush()
+	}
+}
+
+// Restore loads snapshot into the stores on top of their current contents.
+func (db *DB) Restore(snapshot *persistence.Snapshot) {
+	for key, item := range snapshot.DictData {
+		db.Dict.Set(key, item.Value, 0)
+		if !item.ExpiredAt.IsZero() {
+			db.Dict.ExpireAt(key, item.ExpiredAt)
+		}
+	}
+
+	for key, item := range snapshot.SetData {
+		if len(item.Members) > 0 {
+			members := make([]string, 0, len(item.Members))
+			for m := range item.Members {
+				members = append(members, m)
+			}
+			db.Set.Sadd(key, members...)
+			if !item.ExpiredAt.IsZero() {
+				db.Set.ExpireAt(key, item.ExpiredAt)
+			}
+		}
+	}
+
+	for key, items := range snapshot.ListData {
+		if len(items) > 0 {
+			values := make([]string, 0, len(items))
+			for _, item := range items {
+				values = append(values, item.Value)
+			}
+			db.List.Rpush(key, values...)
+		}
+	}
+
+	for key, hash := range snapshot.HashData {
+		for field, value := range hash {
+			db.Hash.Hset(key, field, value)
+		}
+	}
+
+	if db.ZSet != nil {
+		for key, scores := range snapshot.ZSetData {
+			entries := make([]datastructure.ScoreMember, 0, len(scores))
+			for member, score := range scores {
+				entries = append(entries, datastructure.ScoreMember{Member: member, Score: score})
+			}
+			db.ZSet.Zstore(key, entries)
+		}
+	}
+
+	if db.JSON != nil {
+		for key, doc := range snapshot.JSONData {
+			if _, err := db.JSON.JSONSet(key, "$", doc, false, false); err != nil {
+				log.Printf("json restore error for %s: %v", key, err)
+			}
+		}
+	}
+
+	if db.TS != nil {
+		for key, dump := range snapshot.TSData {
+			db.TS.TSRestore(key, dump)
+		}
+	}
+
+	if db.Sketch != nil {
+		for key, dump := range snapshot.SketchData {
+			if err := db.Sketch.Restore(key, dump); err != nil {
+				log.Printf("sketch restore error for %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// Replace swaps the whole dataset for snapshot, as a replica does on full
+// sync.
+func (db *DB) Replace(snapshot *persistence.Snapshot) {
+	db.Flush()
+	db.Restore(snapshot)
+}
+
+// Replay runs a command by name, as when applying a master's write stream.
+func (db *DB) Replay(cmd string, args []resp.Value) {
+	Replay(cmd, args)
+}
+
+// Apply runs a committed Raft log entry and returns the reply for the client
+// that proposed it.
+func (db *DB) Apply(cmd string, args []resp.Value) resp.Value {
+	h, ok := Lookup(cmd)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR unknown command"}
+	}
+	var result resp.Value
+	db.RunWrite(func() {
+		result = h(args)
+	})
+	return result
+}
+
+// RunWrite runs fn as a write command.
+func (db *DB) RunWrite(fn func()) {
+	db.writeMu.RLock()
+	defer db.writeMu.RUnlock()
+	fn()
+	atomic.AddInt64(&dirty, 1)
+}
+
+// PauseWrites runs fn while no write command is executing.
+func (db *DB) PauseWrites(fn func()) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	fn()
 }
 
 func Register(name string, h Handler) {
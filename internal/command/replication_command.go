@@ -0,0 +1,257 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/replication"
+)
+
+type ReplicationContext struct {
+	Repl *replication.Manager
+	AOF  *persistence.AOF
+}
+
+var replCtx *ReplicationContext
+
+func SetReplicationContext(c *ReplicationContext) { replCtx = c }
+
+func InitReplicationCommands() {
+	Register("REPLICAOF", cmdReplicaOf)
+	Register("SLAVEOF", cmdReplicaOf)
+	Register("REPLCONF", cmdReplconf)
+	Register("ROLE", cmdRole)
+	Register("WAIT", cmdWait)
+	Register("WAITAOF", cmdWaitAOF)
+}
+
+// WriteOffsets marks where a client's last write ended in the replication
+// stream and in the AOF. WAIT and WAITAOF block until they are covered.
+type WriteOffsets struct {
+	Repl int64
+	AOF  int64
+}
+
+// CurrentOffsets returns the offsets after the latest write on the server.
+// Connections record it after each write they run.
+func CurrentOffsets() WriteOffsets {
+	var off WriteOffsets
+	if replCtx == nil {
+		return off
+	}
+	if replCtx.Repl != nil {
+		off.Repl = replCtx.Repl.Offset()
+	}
+	if replCtx.AOF != nil {
+		off.AOF = replCtx.AOF.Offset()
+	}
+	return off
+}
+
+func cmdWait(args []resp.Value) resp.Value {
+	return Wait(CurrentOffsets(), args)
+}
+
+func cmdWaitAOF(args []resp.Value) resp.Value {
+	return WaitAOF(CurrentOffsets(), args)
+}
+
+func parseWaitTimeout(v resp.Value) (time.Duration, *resp.Value) {
+	ms, err := strconv.ParseInt(v.Text, 10, 64)
+	if err != nil {
+		return 0, &resp.Value{Type: resp.Error, Text: "ERR timeout is not an integer or out of range"}
+	}
+	if ms < 0 {
+		return 0, &resp.Value{Type: resp.Error, Text: "ERR timeout is negative"}
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Wait implements WAIT numreplicas timeout for a client whose last write
+// ended at last.
+func Wait(last WriteOffsets, args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("wait")
+	}
+	n, err := strconv.Atoi(args[0].Text)
+	if err != nil || n < 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+	}
+	timeout, errReply := parseWaitTimeout(args[1])
+	if errReply != nil {
+		return *errReply
+	}
+	if replCtx == nil || replCtx.Repl == nil {
+		return resp.Value{Type: resp.Integer, Number: 0}
+	}
+	if replCtx.Repl.IsReplica() {
+		return resp.Value{Type: resp.Error, Text: "ERR WAIT cannot be used with replica instances"}
+	}
+	acked := replCtx.Repl.WaitReplicas(last.Repl, n, false, timeout)
+	return resp.Value{Type: resp.Integer, Number: int64(acked)}
+}
+
+// WaitAOF implements WAITAOF numlocal numreplicas timeout.
+func WaitAOF(last WriteOffsets, args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return wrongArity("waitaof")
+	}
+	numLocal, err1 := strconv.Atoi(args[0].Text)
+	numReplicas, err2 := strconv.Atoi(args[1].Text)
+	if err1 != nil || err2 != nil || numLocal < 0 || numReplicas < 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+	}
+	timeout, errReply := parseWaitTimeout(args[2])
+	if errReply != nil {
+		return *errReply
+	}
+	if replCtx == nil {
+		return resp.Value{Type: resp.Error, Text: "ERR replication is not available"}
+	}
+	aof := replCtx.AOF
+	if numLocal > 0 && (aof == nil || !aof.Enabled()) {
+		return resp.Value{Type: resp.Error, Text: "ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled."}
+	}
+	if replCtx.Repl != nil && replCtx.Repl.IsReplica() {
+		return resp.Value{Type: resp.Error, Text: "ERR WAITAOF cannot be used with replica instances"}
+	}
+
+	deadline := time.Now().Add(timeout)
+	remaining := func() time.Duration {
+		if timeout == 0 {
+			return 0
+		}
+		// A spent budget still has to be non-zero, or it would mean forever.
+		return max(time.Until(deadline), time.Nanosecond)
+	}
+
+	local := int64(0)
+	if aof != nil && aof.Enabled() {
+		if numLocal > 0 {
+			if aof.WaitFsync(last.AOF, remaining()) {
+				local = 1
+			}
+		} else if aof.FsyncedOffset() >= last.AOF {
+			local = 1
+		}
+	}
+	replicas := 0
+	if replCtx.Repl != nil {
+		replicas = replCtx.Repl.WaitReplicas(last.Repl, numReplicas, true, remaining())
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.Integer, Number: local},
+		{Type: resp.Integer, Number: int64(replicas)},
+	}}
+}
+
+// ReadOnlyReplica reports whether this node is a replica rejecting writes.
+func ReadOnlyReplica() bool {
+	return replCtx != nil && replCtx.Repl != nil && replCtx.Repl.ReadOnly()
+}
+
+func cmdReplicaOf(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("replicaof")
+	}
+	if replCtx == nil || replCtx.Repl == nil {
+		return resp.Value{Type: resp.Error, Text: "ERR replication is not available"}
+	}
+	if strings.EqualFold(args[0].Text, "NO") && strings.EqualFold(args[1].Text, "ONE") {
+		replCtx.Repl.PromoteToMaster()
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	}
+	if port, err := strconv.Atoi(args[1].Text); err != nil || port <= 0 || port > 65535 {
+		return resp.Value{Type: resp.Error, Text: "ERR Invalid master port"}
+	}
+	replCtx.Repl.ReplicaOf(args[0].Text, args[1].Text)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdReplconf(args []resp.Value) resp.Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongArity("replconf")
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdRole(args []resp.Value) resp.Value {
+	if replCtx == nil || replCtx.Repl == nil {
+		return resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: "master"},
+			{Type: resp.Integer, Number: 0},
+			{Type: resp.Array},
+		}}
+	}
+	st := replCtx.Repl.Status()
+	if st.Role == "slave" {
+		state := "connect"
+		if st.SyncInProgress {
+			state = "sync"
+		} else if st.LinkUp {
+			state = "connected"
+		}
+		port, _ := strconv.ParseInt(st.MasterPort, 10, 64)
+		return resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: "slave"},
+			{Type: resp.BulkString, Text: st.MasterHost},
+			{Type: resp.Integer, Number: port},
+			{Type: resp.BulkString, Text: state},
+			{Type: resp.Integer, Number: st.Offset},
+		}}
+	}
+	replicas := make([]resp.Value, 0, len(st.Replicas))
+	for _, r := range st.Replicas {
+		replicas = append(replicas, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: r.IP},
+			{Type: resp.BulkString, Text: r.Port},
+			{Type: resp.BulkString, Text: strconv.FormatInt(r.Offset, 10)},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: "master"},
+		{Type: resp.Integer, Number: st.Offset},
+		{Type: resp.Array, Items: replicas},
+	}}
+}
+
+func replicationInfo() []string {
+	if replCtx == nil || replCtx.Repl == nil {
+		return []string{"role:master", "connected_slaves:0"}
+	}
+	st := replCtx.Repl.Status()
+	lines := []string{"role:" + st.Role}
+	if st.Role == "slave" {
+		linkStatus := "down"
+		if st.LinkUp {
+			linkStatus = "up"
+		}
+		lines = append(lines,
+			"master_host:"+st.MasterHost,
+			"master_port:"+st.MasterPort,
+			"master_link_status:"+linkStatus,
+			"master_last_io_seconds_ago:"+strconv.Itoa(int(st.LastIO.Seconds())),
+			"master_sync_in_progress:"+boolToInt(st.SyncInProgress),
+			"slave_repl_offset:"+strconv.FormatInt(st.Offset, 10),
+			"slave_read_only:"+boolToInt(st.ReadOnly),
+		)
+	}
+	lines = append(lines, "connected_slaves:"+strconv.Itoa(len(st.Replicas)))
+	for i, r := range st.Replicas {
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d,lag=%d", i, r.IP, r.Port, r.Offset, int(r.Lag.Seconds())))
+	}
+	return append(lines,
+		"master_replid:"+st.ReplID,
+		"master_replid2:"+st.ReplID2,
+		"master_repl_offset:"+strconv.FormatInt(st.Offset, 10),
+		"second_repl_offset:"+strconv.FormatInt(st.SecondOffset, 10),
+		"repl_backlog_active:1",
+		"repl_backlog_size:"+strconv.Itoa(st.BacklogSize),
+		"repl_backlog_first_byte_offset:"+strconv.FormatInt(st.BacklogFirst, 10),
+		"repl_backlog_histlen:"+strconv.FormatInt(st.BacklogLen, 10),
+	)
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/replication"
+)
+
+func TestCmdReplication(t *testing.T) {
+	db := &DB{Dict: datastructure.CreateDict(), Set: datastructure.CreateSet(), List: datastructure.CreateList(), Hash: datastructure.CreateHashMap()}
+	repl := replication.NewManager(db, replication.Options{ReadOnly: true})
+	defer repl.Close()
+	SetReplicationContext(&ReplicationContext{Repl: repl})
+
+	if result := cmdRole(nil); result.Items[0].Text != "master" {
+		t.Errorf("Expected master role, got %v", result)
+	}
+	if result := cmdReplicaOf(bulkArgs("localhost")); result.Type != resp.Error {
+		t.Error("Expected arity error")
+	}
+	if result := cmdReplicaOf(bulkArgs("localhost", "notaport")); result.Type != resp.Error {
+		t.Error("Expected invalid port error")
+	}
+
+	// Port 1 refuses connections, so the link stays down.
+	if result := cmdReplicaOf(bulkArgs("127.0.0.1", "1")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if !ReadOnlyReplica() {
+		t.Error("Expected replica to be read-only")
+	}
+	info := strings.Join(replicationInfo(), "\n")
+	if !strings.Contains(info, "role:slave") || !strings.Contains(info, "master_link_status:down") {
+		t.Errorf("Unexpected INFO replication:\n%s", info)
+	}
+	if result := cmdRole(nil); result.Items[0].Text != "slave" || result.Items[2].Number != 1 {
+		t.Errorf("Unexpected ROLE reply %v", result)
+	}
+
+	if result := cmdReplicaOf(bulkArgs("NO", "ONE")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if ReadOnlyReplica() {
+		t.Error("Expected writes to be accepted after promotion")
+	}
+}
+
+func TestDBReplace(t *testing.T) {
+	db := &DB{Dict: datastructure.CreateDict(), Set: datastructure.CreateSet(), List: datastructure.CreateList(), Hash: datastructure.CreateHashMap(), ZSet: datastructure.CreateZSet()}
+	db.Dict.Set("stale", "1", 0)
+	db.ZSet.Zadd("z", datastructure.ZAddFlags{}, datastructure.ScoreMember{Member: "m", Score: 1})
+
+	db.Replace(&persistence.Snapshot{
+		DictData: map[string]datastructure.Item{"fresh": {Value: "2"}},
+		HashData: map[string]map[string]string{"h": {"f": "v"}},
+	})
+
+	if _, ok := db.Dict.Get("stale"); ok {
+		t.Error("Expected stale key to be flushed")
+	}
+	if v, ok := db.Dict.Get("fresh"); !ok || v != "2" {
+		t.Errorf("Expected fresh=2, got %q", v)
+	}
+	if len(db.ZSet.Dump()) != 0 || len(db.Hash.Dump()) != 1 {
+		t.Error("Expected stores to hold only the snapshot")
+	}
+	if !IsWrite("set") || IsWrite("GET") {
+		t.Error("Unexpected write classification")
+	}
+}
+
+func TestCmdWait(t *testing.T) {
+	aof := openTestAOF(t)
+
+	db := &DB{Dict: datastructure.CreateDict(), Set: datastructure.CreateSet(), List: datastructure.CreateList(), Hash: datastructure.CreateHashMap()}
+	repl := replication.NewManager(db, replication.Options{})
+	defer repl.Close()
+	aof.SetFeed(repl.Feed)
+	SetDictContext(&DictContext{Dict: db.Dict, AOF: aof})
+	SetReplicationContext(&ReplicationContext{Repl: repl, AOF: aof})
+
+	cmdSet(bulkArgs("k", "v"))
+	last := CurrentOffsets()
+	if last.Repl == 0 || last.AOF == 0 {
+		t.Fatalf("Expected write to advance offsets, got %+v", last)
+	}
+
+	if result := Wait(last, bulkArgs("0", "0")); result.Number != 0 {
+		t.Errorf("Expected 0 replicas, got %v", result)
+	}
+	if result := Wait(last, bulkArgs("1", "10")); result.Type != resp.Integer || result.Number != 0 {
+		t.Errorf("Expected timeout with 0 replicas, got %v", result)
+	}
+	if result := Wait(last, bulkArgs("1", "-1")); result.Type != resp.Error {
+		t.Error("Expected negative timeout error")
+	}
+	if result := Wait(last, bulkArgs("1")); result.Type != resp.Error {
+		t.Error("Expected arity error")
+	}
+
+	result := WaitAOF(last, bulkArgs("1", "0", "100"))
+	if len(result.Items) != 2 || result.Items[0].Number != 1 || result.Items[1].Number != 0 {
+		t.Errorf("Expected [1 0], got %v", result)
+	}
+
+	SetReplicationContext(&ReplicationContext{Repl: repl, AOF: &persistence.AOF{}})
+	if result := WaitAOF(last, bulkArgs("1", "0", "0")); result.Type != resp.Error {
+		t.Error("Expected error when AOF is disabled")
+	}
+}
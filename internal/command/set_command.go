@@ -15,6 +15,7 @@ type SetStore interface {
 	Sismember(key, member string) bool
 	Scard(key string) int
 	Expire(key string, ttl time.Duration) bool
+	ExpireAt(key string, at time.Time) bool
 	TTL(key string) int64
 }
 
@@ -0,0 +1,486 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type SketchStore interface {
+	BFReserve(key string, errorRate float64, capacity int64, expansion int, nonScaling bool) error
+	BFAdd(key string, items ...string) ([]bool, error)
+	BFExists(key string, items ...string) ([]bool, error)
+	BFInfo(key string) (datastructure.BloomInfo, error)
+	CFReserve(key string, capacity int64, bucketSize, maxIterations, expansion int) error
+	CFAdd(key, item string, nx bool) (bool, error)
+	CFDel(key, item string) (bool, error)
+	CFExists(key string, items ...string) ([]bool, error)
+	CFCount(key, item string) (int, error)
+	CMSInit(key string, width, depth uint64) error
+	CMSIncrBy(key string, items []string, incrs []uint64) ([]uint64, error)
+	CMSQuery(key string, items ...string) ([]uint64, error)
+	TopKReserve(key string, k int, width, depth uint64, decay float64) error
+	TopKAdd(key string, items ...string) ([]*string, error)
+	TopKList(key string) ([]datastructure.TopKItem, error)
+	TopKQuery(key string, items ...string) ([]bool, error)
+	Restore(key string, dump datastructure.SketchDump) error
+	Dump(keys ...string) map[string]datastructure.SketchDump
+}
+
+type SketchContext struct {
+	Sketches SketchStore
+	AOF      *persistence.AOF
+}
+
+var sketchCtx *SketchContext
+
+func SetSketchContext(c *SketchContext) { sketchCtx = c }
+
+func InitSketchCommands() {
+	Register("BF.RESERVE", cmdBFReserve)
+	Register("BF.ADD", cmdBFAdd)
+	Register("BF.MADD", cmdBFMadd)
+	Register("BF.EXISTS", cmdBFExists)
+	Register("BF.MEXISTS", cmdBFMexists)
+	Register("BF.INFO", cmdBFInfo)
+	Register("BF.LOADCHUNK", loadChunkHandler(datastructure.SketchBloom))
+
+	Register("CF.RESERVE", cmdCFReserve)
+	Register("CF.ADD", cmdCFAdd)
+	Register("CF.ADDNX", cmdCFAddnx)
+	Register("CF.DEL", cmdCFDel)
+	Register("CF.EXISTS", cmdCFExists)
+	Register("CF.MEXISTS", cmdCFMexists)
+	Register("CF.COUNT", cmdCFCount)
+	Register("CF.LOADCHUNK", loadChunkHandler(datastructure.SketchCuckoo))
+
+	Register("CMS.INITBYDIM", cmdCMSInitByDim)
+	Register("CMS.INITBYPROB", cmdCMSInitByProb)
+	Register("CMS.INCRBY", cmdCMSIncrBy)
+	Register("CMS.QUERY", cmdCMSQuery)
+	Register("CMS.LOADCHUNK", loadChunkHandler(datastructure.SketchCMS))
+
+	Register("TOPK.RESERVE", cmdTopKReserve)
+	Register("TOPK.ADD", cmdTopKAdd)
+	Register("TOPK.LIST", cmdTopKList)
+	Register("TOPK.QUERY", cmdTopKQuery)
+	Register("TOPK.LOADCHUNK", loadChunkHandler(datastructure.SketchTopK))
+}
+
+func wrongArity(name string) resp.Value {
+	return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for '" + name + "'"}
+}
+
+func parsePositiveInt(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n > 0
+}
+
+func parseRate(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil && f > 0 && f < 1
+}
+
+func boolsReply(values []bool) resp.Value {
+	items := make([]resp.Value, 0, len(values))
+	for _, v := range values {
+		n := int64(0)
+		if v {
+			n = 1
+		}
+		items = append(items, resp.Value{Type: resp.Integer, Number: n})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func textArgs(args []resp.Value) []string {
+	out := make([]string, 0, len(args))
+	for _, a := range args {
+		out = append(out, a.Text)
+	}
+	return out
+}
+
+func cmdBFReserve(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return wrongArity("bf.reserve")
+	}
+	errorRate, ok := parseRate(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR (0 < error rate range < 1)"}
+	}
+	capacity, ok := parsePositiveInt(args[2].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR (capacity should be larger than 0)"}
+	}
+	expansion, nonScaling := int64(datastructure.DefaultExpansion), false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+			}
+			if expansion, ok = parsePositiveInt(args[i+1].Text); !ok {
+				return resp.Value{Type: resp.Error, Text: "ERR bad expansion"}
+			}
+			i++
+		case "NONSCALING":
+			nonScaling = true
+		default:
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+	}
+	if err := sketchCtx.Sketches.BFReserve(args[0].Text, errorRate, capacity, int(expansion), nonScaling); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "BF.RESERVE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func bfAdd(args []resp.Value, name string) ([]resp.Value, resp.Value, bool) {
+	items := textArgs(args[1:])
+	added, err := sketchCtx.Sketches.BFAdd(args[0].Text, items...)
+	if err == datastructure.ErrWrongType {
+		return nil, errorReply(err), false
+	}
+	if len(added) > 0 {
+		appendToAOF(sketchCtx.AOF, name, args[:1+len(added)])
+	}
+	out := boolsReply(added).Items
+	for len(out) < len(items) {
+		out = append(out, errorReply(err))
+	}
+	return out, resp.Value{}, true
+}
+
+func cmdBFAdd(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("bf.add")
+	}
+	out, errVal, ok := bfAdd(args, "BF.ADD")
+	if !ok {
+		return errVal
+	}
+	return out[0]
+}
+
+func cmdBFMadd(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("bf.madd")
+	}
+	out, errVal, ok := bfAdd(args, "BF.MADD")
+	if !ok {
+		return errVal
+	}
+	return resp.Value{Type: resp.Array, Items: out}
+}
+
+func cmdBFExists(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("bf.exists")
+	}
+	found, err := sketchCtx.Sketches.BFExists(args[0].Text, args[1].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolsReply(found).Items[0]
+}
+
+func cmdBFMexists(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("bf.mexists")
+	}
+	found, err := sketchCtx.Sketches.BFExists(args[0].Text, textArgs(args[1:])...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolsReply(found)
+}
+
+func cmdBFInfo(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("bf.info")
+	}
+	info, err := sketchCtx.Sketches.BFInfo(args[0].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.SimpleString, Text: "Capacity"}, {Type: resp.Integer, Number: info.Capacity},
+		{Type: resp.SimpleString, Text: "Size"}, {Type: resp.Integer, Number: int64(info.Size)},
+		{Type: resp.SimpleString, Text: "Number of filters"}, {Type: resp.Integer, Number: int64(info.Filters)},
+		{Type: resp.SimpleString, Text: "Number of items inserted"}, {Type: resp.Integer, Number: info.Items},
+		{Type: resp.SimpleString, Text: "Expansion rate"}, {Type: resp.Integer, Number: int64(info.Expansion)},
+	}}
+}
+
+func cmdCFReserve(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("cf.reserve")
+	}
+	capacity, ok := parsePositiveInt(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR Bad capacity"}
+	}
+	opts := map[string]int64{
+		"BUCKETSIZE":    datastructure.DefaultCuckooBucketSize,
+		"MAXITERATIONS": datastructure.DefaultCuckooMaxIter,
+		"EXPANSION":     datastructure.DefaultExpansion,
+	}
+	for i := 2; i < len(args); i += 2 {
+		name := strings.ToUpper(args[i].Text)
+		if _, known := opts[name]; !known || i+1 >= len(args) {
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+		n, err := strconv.ParseInt(args[i+1].Text, 10, 64)
+		if err != nil || n < 0 || (n == 0 && name != "EXPANSION") || (name == "BUCKETSIZE" && n > 255) {
+			return resp.Value{Type: resp.Error, Text: "ERR bad " + strings.ToLower(name)}
+		}
+		opts[name] = n
+	}
+	err := sketchCtx.Sketches.CFReserve(args[0].Text, capacity, int(opts["BUCKETSIZE"]), int(opts["MAXITERATIONS"]), int(opts["EXPANSION"]))
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "CF.RESERVE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cfAdd(args []resp.Value, nx bool, name string) resp.Value {
+	if len(args) != 2 {
+		return wrongArity(strings.ToLower(name))
+	}
+	added, err := sketchCtx.Sketches.CFAdd(args[0].Text, args[1].Text, nx)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !added {
+		return resp.Value{Type: resp.Integer, Number: 0}
+	}
+	appendToAOF(sketchCtx.AOF, name, args)
+	return resp.Value{Type: resp.Integer, Number: 1}
+}
+
+func cmdCFAdd(args []resp.Value) resp.Value {
+	return cfAdd(args, false, "CF.ADD")
+}
+
+func cmdCFAddnx(args []resp.Value) resp.Value {
+	return cfAdd(args, true, "CF.ADDNX")
+}
+
+func cmdCFDel(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("cf.del")
+	}
+	deleted, err := sketchCtx.Sketches.CFDel(args[0].Text, args[1].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !deleted {
+		return resp.Value{Type: resp.Integer, Number: 0}
+	}
+	appendToAOF(sketchCtx.AOF, "CF.DEL", args)
+	return resp.Value{Type: resp.Integer, Number: 1}
+}
+
+func cmdCFExists(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("cf.exists")
+	}
+	found, err := sketchCtx.Sketches.CFExists(args[0].Text, args[1].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolsReply(found).Items[0]
+}
+
+func cmdCFMexists(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("cf.mexists")
+	}
+	found, err := sketchCtx.Sketches.CFExists(args[0].Text, textArgs(args[1:])...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolsReply(found)
+}
+
+func cmdCFCount(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return wrongArity("cf.count")
+	}
+	n, err := sketchCtx.Sketches.CFCount(args[0].Text, args[1].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func cmdCMSInitByDim(args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return wrongArity("cms.initbydim")
+	}
+	width, ok := parsePositiveInt(args[1].Text)
+	depth, ok2 := parsePositiveInt(args[2].Text)
+	if !ok || !ok2 {
+		return resp.Value{Type: resp.Error, Text: "ERR CMS: invalid width/depth value"}
+	}
+	if err := sketchCtx.Sketches.CMSInit(args[0].Text, uint64(width), uint64(depth)); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "CMS.INITBYDIM", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdCMSInitByProb(args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return wrongArity("cms.initbyprob")
+	}
+	errorRate, ok := parseRate(args[1].Text)
+	probability, ok2 := parseRate(args[2].Text)
+	if !ok || !ok2 {
+		return resp.Value{Type: resp.Error, Text: "ERR CMS: invalid prob value"}
+	}
+	width, depth := datastructure.CMSDimensions(errorRate, probability)
+	if err := sketchCtx.Sketches.CMSInit(args[0].Text, width, depth); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "CMS.INITBYPROB", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func countsReply(counts []uint64) resp.Value {
+	items := make([]resp.Value, 0, len(counts))
+	for _, n := range counts {
+		items = append(items, resp.Value{Type: resp.Integer, Number: int64(n)})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdCMSIncrBy(args []resp.Value) resp.Value {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return wrongArity("cms.incrby")
+	}
+	items := make([]string, 0, len(args)/2)
+	incrs := make([]uint64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		n, err := strconv.ParseUint(args[i+1].Text, 10, 64)
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: "ERR CMS: Cannot parse number"}
+		}
+		items = append(items, args[i].Text)
+		incrs = append(incrs, n)
+	}
+	counts, err := sketchCtx.Sketches.CMSIncrBy(args[0].Text, items, incrs)
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "CMS.INCRBY", args)
+	return countsReply(counts)
+}
+
+func cmdCMSQuery(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("cms.query")
+	}
+	counts, err := sketchCtx.Sketches.CMSQuery(args[0].Text, textArgs(args[1:])...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return countsReply(counts)
+}
+
+func cmdTopKReserve(args []resp.Value) resp.Value {
+	if len(args) != 2 && len(args) != 5 {
+		return wrongArity("topk.reserve")
+	}
+	k, ok := parsePositiveInt(args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR TopK: invalid k"}
+	}
+	width, depth, decay := int64(datastructure.DefaultTopKWidth), int64(datastructure.DefaultTopKDepth), datastructure.DefaultTopKDecay
+	if len(args) == 5 {
+		var ok2, ok3 bool
+		width, ok = parsePositiveInt(args[2].Text)
+		depth, ok2 = parsePositiveInt(args[3].Text)
+		decay, ok3 = parseRate(args[4].Text)
+		if !ok || !ok2 || !ok3 {
+			return resp.Value{Type: resp.Error, Text: "ERR TopK: invalid width, depth or decay"}
+		}
+	}
+	if err := sketchCtx.Sketches.TopKReserve(args[0].Text, int(k), uint64(width), uint64(depth), decay); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "TOPK.RESERVE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdTopKAdd(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("topk.add")
+	}
+	dropped, err := sketchCtx.Sketches.TopKAdd(args[0].Text, textArgs(args[1:])...)
+	if err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(sketchCtx.AOF, "TOPK.ADD", args)
+	items := make([]resp.Value, 0, len(dropped))
+	for _, d := range dropped {
+		if d == nil {
+			items = append(items, resp.Value{Type: resp.BulkString, IsNil: true})
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.BulkString, Text: *d})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdTopKList(args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongArity("topk.list")
+	}
+	withCount := len(args) == 2
+	if withCount && !strings.EqualFold(args[1].Text, "WITHCOUNT") {
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+	list, err := sketchCtx.Sketches.TopKList(args[0].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	items := make([]resp.Value, 0, len(list)*2)
+	for _, e := range list {
+		items = append(items, resp.Value{Type: resp.BulkString, Text: e.Item})
+		if withCount {
+			items = append(items, resp.Value{Type: resp.Integer, Number: int64(e.Count)})
+		}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdTopKQuery(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return wrongArity("topk.query")
+	}
+	found, err := sketchCtx.Sketches.TopKQuery(args[0].Text, textArgs(args[1:])...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolsReply(found)
+}
+
+func loadChunkHandler(kind string) Handler {
+	return func(args []resp.Value) resp.Value {
+		if len(args) != 3 {
+			return wrongArity(strings.ToLower(kind) + ".loadchunk")
+		}
+		if err := sketchCtx.Sketches.Restore(args[0].Text, datastructure.SketchDump{Kind: kind, Data: []byte(args[2].Text)}); err != nil {
+			return errorReply(err)
+		}
+		appendToAOF(sketchCtx.AOF, kind+".LOADCHUNK", args)
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	}
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupSketchTest() *datastructure.Sketches {
+	sketches := datastructure.CreateSketches()
+	SetSketchContext(&SketchContext{Sketches: sketches, AOF: nil})
+	return sketches
+}
+
+func TestCmdBloom(t *testing.T) {
+	setupSketchTest()
+
+	if result := cmdBFReserve(bulkArgs("bf", "0.01", "2", "NONSCALING")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := cmdBFReserve(bulkArgs("bad", "1.5", "10")); result.Type != resp.Error {
+		t.Error("Expected error for invalid error rate")
+	}
+	if result := cmdBFAdd(bulkArgs("bf", "a")); result.Number != 1 {
+		t.Errorf("Expected 1, got %v", result)
+	}
+	result := cmdBFMadd(bulkArgs("bf", "a", "b", "c"))
+	if len(result.Items) != 3 || result.Items[0].Number != 0 || result.Items[1].Number != 1 || result.Items[2].Type != resp.Error {
+		t.Errorf("Unexpected MADD result %v", result.Items)
+	}
+	result = cmdBFMexists(bulkArgs("bf", "a", "b", "zzz"))
+	if result.Items[0].Number != 1 || result.Items[1].Number != 1 {
+		t.Errorf("Unexpected MEXISTS result %v", result.Items)
+	}
+	if result := cmdBFExists(bulkArgs("missing", "a")); result.Number != 0 {
+		t.Error("Expected 0 for missing filter")
+	}
+	if result := cmdBFInfo(bulkArgs("bf")); result.Items[7].Number != 2 {
+		t.Errorf("Expected 2 items inserted, got %v", result.Items)
+	}
+}
+
+func TestCmdCuckoo(t *testing.T) {
+	setupSketchTest()
+
+	if result := cmdCFReserve(bulkArgs("cf", "1000", "BUCKETSIZE", "4", "EXPANSION", "1")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := cmdCFReserve(bulkArgs("bad", "10", "BUCKETSIZE", "0")); result.Type != resp.Error {
+		t.Error("Expected error for zero bucket size")
+	}
+	cmdCFAdd(bulkArgs("cf", "x"))
+	if result := cmdCFAddnx(bulkArgs("cf", "x")); result.Number != 0 {
+		t.Error("Expected ADDNX to skip existing item")
+	}
+	if result := cmdCFCount(bulkArgs("cf", "x")); result.Number != 1 {
+		t.Errorf("Expected count 1, got %d", result.Number)
+	}
+	if result := cmdCFDel(bulkArgs("cf", "x")); result.Number != 1 {
+		t.Error("Expected delete to succeed")
+	}
+	if result := cmdCFExists(bulkArgs("cf", "x")); result.Number != 0 {
+		t.Error("Expected item to be gone")
+	}
+	if result := cmdCFDel(bulkArgs("nope", "x")); result.Type != resp.Error {
+		t.Error("Expected error deleting from missing filter")
+	}
+	if result := cmdBFAdd(bulkArgs("cf", "x")); result.Type != resp.Error {
+		t.Error("Expected wrong type error")
+	}
+}
+
+func TestCmdCMSAndTopK(t *testing.T) {
+	setupSketchTest()
+
+	cmdCMSInitByProb(bulkArgs("cms", "0.01", "0.01"))
+	result := cmdCMSIncrBy(bulkArgs("cms", "a", "5", "b", "2", "a", "1"))
+	if len(result.Items) != 3 || result.Items[2].Number != 6 {
+		t.Errorf("Unexpected INCRBY result %v", result.Items)
+	}
+	if result := cmdCMSQuery(bulkArgs("cms", "b", "c")); result.Items[0].Number != 2 || result.Items[1].Number != 0 {
+		t.Errorf("Unexpected QUERY result %v", result.Items)
+	}
+	if result := cmdCMSIncrBy(bulkArgs("cms", "a", "-1")); result.Type != resp.Error {
+		t.Error("Expected error for negative increment")
+	}
+
+	cmdTopKReserve(bulkArgs("tk", "2"))
+	cmdTopKAdd(bulkArgs("tk", "a", "a", "a", "b", "b", "c"))
+	result = cmdTopKList(bulkArgs("tk", "WITHCOUNT"))
+	if len(result.Items) != 4 || result.Items[0].Text != "a" || result.Items[1].Number != 3 || result.Items[2].Text != "b" {
+		t.Errorf("Unexpected LIST result %v", result.Items)
+	}
+	if result := cmdTopKQuery(bulkArgs("tk", "a", "c")); result.Items[0].Number != 1 || result.Items[1].Number != 0 {
+		t.Errorf("Unexpected QUERY result %v", result.Items)
+	}
+}
+
+func TestSketchAOFRewrite(t *testing.T) {
+	aof := openTestAOF(t)
+
+	sketches := setupSketchTest()
+	cmdBFMadd(bulkArgs("bf", "a", "b"))
+	cmdCFAdd(bulkArgs("cf", "a"))
+	cmdCMSInitByDim(bulkArgs("cms", "100", "4"))
+	cmdCMSIncrBy(bulkArgs("cms", "a", "9"))
+	cmdTopKReserve(bulkArgs("tk", "1"))
+	cmdTopKAdd(bulkArgs("tk", "z", "z"))
+	rewriteAOF(t, aof, persistence.Snapshot{SketchData: sketches.Dump()})
+
+	replayed := datastructure.CreateSketches()
+	SetSketchContext(&SketchContext{Sketches: replayed, AOF: nil})
+	handlers := map[string]Handler{
+		"BF.LOADCHUNK":   loadChunkHandler(datastructure.SketchBloom),
+		"CF.LOADCHUNK":   loadChunkHandler(datastructure.SketchCuckoo),
+		"CMS.LOADCHUNK":  loadChunkHandler(datastructure.SketchCMS),
+		"TOPK.LOADCHUNK": loadChunkHandler(datastructure.SketchTopK),
+	}
+	aof.LoadAll(nil, func(cmd string, args []resp.Value) {
+		if result := handlers[cmd](args); result.Type == resp.Error {
+			t.Errorf("%s failed: %s", cmd, result.Text)
+		}
+	})
+
+	if result := cmdBFExists(bulkArgs("bf", "b")); result.Number != 1 {
+		t.Error("Expected bloom item after replay")
+	}
+	if result := cmdCFExists(bulkArgs("cf", "a")); result.Number != 1 {
+		t.Error("Expected cuckoo item after replay")
+	}
+	if result := cmdCMSQuery(bulkArgs("cms", "a")); result.Items[0].Number != 9 {
+		t.Errorf("Expected 9, got %v", result.Items)
+	}
+	if result := cmdTopKList(bulkArgs("tk")); len(result.Items) != 1 || result.Items[0].Text != "z" {
+		t.Errorf("Unexpected top-k after replay %v", result.Items)
+	}
+}
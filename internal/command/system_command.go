@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"log"
 	"path/filepath"
 	"fmt"
@@ -29,29 +30,17 @@ func InitSystemCommands() {
 	Register("INFO", cmdInfo)
 	Register("BGSAVE", cmdBgsave)
 	Register("KEYS", cmdKeys)
+	Register("SCAN", cmdScan)
+	Register("TYPE", cmdType)
 	Register("MONITOR", cmdMonitor)
 	startedAt = time.Now()
+	atomic.StoreInt64(&dirty, 0)
+	atomic.StoreInt64(&lastSave, startedAt.Unix())
 }
 
 func cmdAuth(args []resp.Value) resp.Value {
-	if len(args) > 1 {
-		return resp.Value{
-			Type: resp.Error,
-			Text: "ERR wrong number of arguments for 'auth'",
-		}
-	}
-
-	auth := args[0].Text
-	if auth != config.Global.GetAuth() {
-		return resp.Value{
-			Type: resp.Error,
-			Text: "ERR auth is not correct",
-		}
-	}
-	return resp.Value{
-		Type: resp.SimpleString,
-		Text: "OK",
-	}
+	_, v := Auth(args, "")
+	return v
 }
 
 func cmdInfo(args []resp.Value) resp.Value {
@@ -63,10 +52,26 @@ func cmdInfo(args []resp.Value) resp.Value {
 	uptime := int(time.Since(startedAt).Seconds())
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	dictCount := len(sysCtx.DB.Dict.Dump())
-	setCount := len(sysCtx.DB.Set.Dump())
-	listCount := len(sysCtx.DB.List.Dump())
-	hashCount := len(sysCtx.DB.Hash.Dump())
+	dictCount := sysCtx.DB.Dict.Len()
+	setCount := sysCtx.DB.Set.Len()
+	listCount := sysCtx.DB.List.Len()
+	hashCount := sysCtx.DB.Hash.Len()
+	zsetCount := 0
+	if sysCtx.DB.ZSet != nil {
+		zsetCount = sysCtx.DB.ZSet.Len()
+	}
+	jsonCount := 0
+	if sysCtx.DB.JSON != nil {
+		jsonCount = sysCtx.DB.JSON.Len()
+	}
+	tsCount := 0
+	if sysCtx.DB.TS != nil {
+		tsCount = sysCtx.DB.TS.Len()
+	}
+	sketchCount := 0
+	if sysCtx.DB.Sketch != nil {
+		sketchCount = sysCtx.DB.Sketch.Len()
+	}
 	appendSection := func(name string, kv []string) {
 		if section == "all" || section == name {
 			for _, line := range kv {
@@ -77,6 +82,7 @@ func cmdInfo(args []resp.Value) resp.Value {
 	}
 	appendSection("server", []string{
 		"uptime_in_seconds:" + strconv.Itoa(uptime),
+		"execution:" + config.Global.GetExecution(),
 	})
 	appendSection("clients", []string{
 		"connected_clients:" + strconv.Itoa(int(getCurrentConnections())),
@@ -84,44 +90,159 @@ func cmdInfo(args []resp.Value) resp.Value {
 	})
 	appendSection("memory", []string{
 		"used_memory:" + strconv.FormatUint(m.Alloc, 10),
+		"used_memory_peak:" + strconv.FormatUint(max(NotePeakMemory(), m.Alloc), 10),
+		"maxmemory:" + strconv.FormatUint(config.Global.GetMaxMemory(), 10),
+		"maxmemory_policy:" + config.Global.GetMaxMemoryPolicy(),
 	})
 	appendSection("persistence", []string{
 		"aof_enabled:" + boolToInt(config.Global.Persistence.AOF.Enabled),
+		"aof_rewrite_in_progress:" + strconv.Itoa(int(atomic.LoadInt32(&aofRewriteInProg))),
 		"rdb_enabled:" + boolToInt(config.Global.Persistence.RDB.Enabled),
 		"bgsave_in_progress:" + strconv.Itoa(int(atomic.LoadInt32(&bgsaveInProg))),
+		"rdb_changes_since_last_save:" + strconv.FormatInt(atomic.LoadInt64(&dirty), 10),
+		"rdb_last_save_time:" + strconv.FormatInt(atomic.LoadInt64(&lastSave), 10),
+		"rdb_last_bgsave_status:" + bgsaveStatus(),
+		"rdb_last_cow_size:" + strconv.FormatInt(atomic.LoadInt64(&lastCowSize), 10),
 	})
+	appendSection("replication", replicationInfo())
 	appendSection("stats", []string{
 		"total_commands_processed:" + strconv.FormatUint(getTotalCommands(), 10),
+		"evicted_keys:" + strconv.FormatUint(EvictedKeys(), 10),
+	})
+	appendSection("cluster", []string{
+		"cluster_enabled:" + boolToInt(ClusterEnabled()),
 	})
+	raftLines := []string{"raft_enabled:" + boolToInt(RaftEnabled())}
+	if RaftEnabled() {
+		raftLines = append(raftLines, raftInfo(raftCtx.Raft)...)
+	}
+	appendSection("raft", raftLines)
+	aaLines := []string{"active_active_enabled:" + boolToInt(ActiveActiveEnabled())}
+	if ActiveActiveEnabled() {
+		aaLines = append(aaLines, activeActiveInfo(activeActiveCtx.Node)...)
+	}
+	appendSection("active_active", aaLines)
+	backupLines := []string{"backup_enabled:" + boolToInt(BackupEnabled())}
+	if BackupEnabled() {
+		backupLines = append(backupLines, backupInfo(backupCtx.Backups)...)
+	}
+	appendSection("backup", backupLines)
 	appendSection("keyspace", []string{
-		fmt.Sprintf("db0:dict=%d,set=%d,list=%d,hash=%d", dictCount, setCount, listCount, hashCount),
+		fmt.Sprintf("db0:dict=%d,set=%d,list=%d,hash=%d,zset=%d,json=%d,timeseries=%d,sketch=%d", dictCount, setCount, listCount, hashCount, zsetCount, jsonCount, tsCount, sketchCount),
 	})
 	return resp.Value{Type: resp.BulkString, Text: b.String()}
 }
 
 func cmdBgsave(args []resp.Value) resp.Value {
-	go func() {
-		filename := "dump.rdb"
-		if len(args) > 0 && (args[0].Type == resp.BulkString || args[0].Type == resp.SimpleString) {
-			filename = args[0].Text
-		}
+	filename := ""
+	if len(args) > 0 && (args[0].Type == resp.BulkString || args[0].Type == resp.SimpleString) {
+		filename = args[0].Text
+	}
+	if err := BackgroundSave(filename); err != nil {
+		return resp.Value{Type: resp.Error, Text: err.Error()}
+	}
+	return resp.Value{Type: resp.SimpleString, Text: "Background saving started"}
+}
 
-		atomic.StoreInt32(&bgsaveInProg, 1)
-		snapshot := persistence.Snapshot{
-			DictData: sysCtx.DB.Dict.Dump(),
-			SetData:  sysCtx.DB.Set.Dump(),
-			ListData: sysCtx.DB.List.Dump(),
-			HashData: sysCtx.DB.Hash.Dump(),
-		}
+const bgsaveRetryDelay = 5 * time.Second
 
-		if err := sysCtx.DB.RDB.Save(snapshot, filename); err != nil {
+var (
+	errBgsaveInProgress     = errors.New("ERR Background save already in progress")
+	errAOFRewriteInProgress = errors.New("ERR Background append only file rewriting in progress")
+)
+
+// BackgroundSave starts writing the dataset to filename, or to the
+// configured RDB file when it is empty.
+func BackgroundSave(filename string) error {
+	if !atomic.CompareAndSwapInt32(&bgsaveInProg, 0, 1) {
+		return errBgsaveInProgress
+	}
+	if !snapshotMu.TryLock() {
+		atomic.StoreInt32(&bgsaveInProg, 0)
+		return errAOFRewriteInProgress
+	}
+	if filename == "" {
+		filename = rdbFilename()
+	}
+	primary := filename == rdbFilename()
+	changes := atomic.LoadInt64(&dirty)
+	atomic.StoreInt64(&lastBgsaveTry, time.Now().Unix())
+	// The view is fixed here, so the save holds the dataset as of this
+	// call whatever runs while it is written.
+	snapshot := sysCtx.DB.BeginSnapshot()
+	go func() {
+		err := sysCtx.DB.RDB.SaveStream(filename, snapshot.Write)
+		atomic.StoreInt64(&lastCowSize, snapshot.Close())
+		if err != nil {
 			log.Printf("BGSAVE error: %v", err)
+			atomic.StoreInt32(&lastBgsaveFailed, 1)
 		} else {
 			log.Printf("BGSAVE success -> %s", filename)
+			atomic.StoreInt32(&lastBgsaveFailed, 0)
+			if primary {
+				atomic.AddInt64(&dirty, -changes)
+				atomic.StoreInt64(&lastSave, time.Now().Unix())
+			}
 		}
+		snapshotMu.Unlock()
 		atomic.StoreInt32(&bgsaveInProg, 0)
 	}()
-	return resp.Value{Type: resp.SimpleString, Text: "Background saving started"}
+	return nil
+}
+
+// RewriteAOF replaces the AOF's base with the dataset as it is now. pause
+// must run its function while no write command is.
+func RewriteAOF(pause func(fn func())) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	atomic.StoreInt32(&aofRewriteInProg, 1)
+	defer atomic.StoreInt32(&aofRewriteInProg, 0)
+
+	var snapshot *BackgroundSnapshot
+	var rewrite *persistence.AOFRewrite
+	var err error
+	pause(func() {
+		if rewrite, err = sysCtx.DB.AOF.BeginRewrite(); err == nil {
+			snapshot = sysCtx.DB.BeginSnapshot()
+		}
+	})
+	if err != nil || rewrite == nil {
+		return err
+	}
+	err = rewrite.Finish(snapshot.Write)
+	snapshot.Close()
+	return err
+}
+
+// SaveIfDue starts a background save when one of rules is met and reports
+// whether it did.
+func SaveIfDue(rules []config.SaveRule, now time.Time) bool {
+	if atomic.LoadInt32(&lastBgsaveFailed) == 1 && now.Sub(time.Unix(atomic.LoadInt64(&lastBgsaveTry), 0)) < bgsaveRetryDelay {
+		return false
+	}
+	changes := atomic.LoadInt64(&dirty)
+	elapsed := now.Sub(time.Unix(atomic.LoadInt64(&lastSave), 0))
+	for _, rule := range rules {
+		if changes >= rule.Changes && elapsed >= rule.After {
+			log.Printf("%d changes in %d seconds. Saving...", rule.Changes, int(rule.After.Seconds()))
+			return BackgroundSave("") == nil
+		}
+	}
+	return false
+}
+
+func rdbFilename() string {
+	if config.Global == nil || config.Global.Persistence.RDB.Filename == "" {
+		return "dump.rdb"
+	}
+	return config.Global.Persistence.RDB.Filename
+}
+
+func bgsaveStatus() string {
+	if atomic.LoadInt32(&lastBgsaveFailed) == 1 {
+		return "err"
+	}
+	return "ok"
 }
 
 func cmdKeys(args []resp.Value) resp.Value {
@@ -130,42 +251,59 @@ func cmdKeys(args []resp.Value) resp.Value {
 	}
 
 	pattern := args[0].Text
-	var matchedKeys []string
-
-	dictSnapshot := sysCtx.DB.Dict.Dump()
-	for key := range dictSnapshot {
+	items := []resp.Value{}
+	for _, key := range sysCtx.DB.Keys() {
 		if matched, _ := filepath.Match(pattern, key); matched {
-			matchedKeys = append(matchedKeys, key)
+			items = append(items, resp.Value{Type: resp.BulkString, Text: key})
 		}
 	}
 
-	setSnapshot := sysCtx.DB.Set.Dump()
-	for key := range setSnapshot {
-		if matched, _ := filepath.Match(pattern, key); matched {
-			matchedKeys = append(matchedKeys, key)
-		}
-	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
 
-	listSnapshot := sysCtx.DB.List.Dump()
-	for key := range listSnapshot {
-		if matched, _ := filepath.Match(pattern, key); matched {
-			matchedKeys = append(matchedKeys, key)
+func cmdScan(args []resp.Value) resp.Value {
+	if len(args) < 1 || len(args)%2 != 1 {
+		return wrongArity("scan")
+	}
+	cursor, err := strconv.ParseUint(args[0].Text, 10, 64)
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "ERR invalid cursor"}
+	}
+	pattern, count, typ := "*", 10, ""
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i].Text) {
+		case "MATCH":
+			pattern = args[i+1].Text
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1].Text)
+			if err != nil || count < 1 {
+				return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+			}
+		case "TYPE":
+			typ = args[i+1].Text
+		default:
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
 		}
 	}
 
-	hashSnapshot := sysCtx.DB.Hash.Dump()
-	for key := range hashSnapshot {
+	keys, next := sysCtx.DB.Scan(cursor, count, typ)
+	items := []resp.Value{}
+	for _, key := range keys {
 		if matched, _ := filepath.Match(pattern, key); matched {
-			matchedKeys = append(matchedKeys, key)
+			items = append(items, resp.Value{Type: resp.BulkString, Text: key})
 		}
 	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: strconv.FormatUint(next, 10)},
+		{Type: resp.Array, Items: items},
+	}}
+}
 
-	items := make([]resp.Value, len(matchedKeys))
-	for i, key := range matchedKeys {
-		items[i] = resp.Value{Type: resp.BulkString, Text: key}
+func cmdType(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return wrongArity("type")
 	}
-
-	return resp.Value{Type: resp.Array, Items: items}
+	return resp.Value{Type: resp.SimpleString, Text: sysCtx.DB.Type(args[0].Text)}
 }
 
 func cmdMonitor(args []resp.Value) resp.Value {
@@ -175,6 +313,9 @@ func cmdMonitor(args []resp.Value) resp.Value {
 var (
 	startedAt     time.Time
 	bgsaveInProg  int32
+	// lastCowSize is how many bytes the last BGSAVE copied for keys
+	// written while it ran.
+	lastCowSize   int64
 	statMu        sync.Mutex
 	totalCmds     uint64
 	totalConns    uint64
@@ -183,6 +324,18 @@ var (
 	monSubs       = map[chan resp.Value]struct{}{}
 )
 
+var (
+	// dirty counts writes since the last save of the RDB file.
+	dirty            int64
+	lastSave         int64
+	lastBgsaveTry    int64
+	lastBgsaveFailed int32
+	aofRewriteInProg int32
+	// snapshotMu is held while BGSAVE or an AOF rewrite has a snapshot
+	// view open, since only one may be open at a time.
+	snapshotMu sync.Mutex
+)
+
 func boolToInt(b bool) string {
 	if b {
 		return "1"
@@ -201,6 +354,10 @@ func IncCommands() { incTotalCommands() }
 func MonitorSubscribe() chan resp.Value { ch := make(chan resp.Value, 128); monMu.Lock(); monSubs[ch] = struct{}{}; monMu.Unlock(); return ch }
 func MonitorUnsubscribe(ch chan resp.Value) { monMu.Lock(); delete(monSubs, ch); close(ch); monMu.Unlock() }
 func MonitorPublish(cmd string, args []resp.Value) {
+	// As in Redis, commands carrying passwords are not shown.
+	if cmd == "AUTH" || cmd == "ACL" {
+		return
+	}
 	monMu.RLock()
 	if len(monSubs) == 0 {
 		monMu.RUnlock()
@@ -2,8 +2,12 @@ package command
 
 import (
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/william1nguyen/valkeydb/internal/config"
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
 	"github.com/william1nguyen/valkeydb/internal/persistence"
 	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
@@ -80,9 +84,11 @@ func TestCmdBgsave(t *testing.T) {
 	hash := datastructure.CreateHashMap()
 	hash.Hset("myhash", "field", "value")	
 
-	tmpFile := "test_bgsave.rdb"
-	rdb, _ := persistence.OpenRDB(tmpFile, true)
-	defer os.Remove(tmpFile)
+	dir := t.TempDir()
+	rdb, _ := persistence.OpenRDB(filepath.Join(dir, "test_bgsave.rdb"), true)
+	defer rdb.Close()
+	// BGSAVE writes dump.rdb to the working directory.
+	t.Chdir(dir)
 
 	SetSystemContext(&SystemContext{
 		DB: &DB{
@@ -101,4 +107,74 @@ func TestCmdBgsave(t *testing.T) {
 	if result.Text != "Background saving started" {
 		t.Errorf("Expected 'Background saving started', got %s", result.Text)
 	}
+	// The save runs in the background; wait until it wrote the file.
+	for {
+		_, err := os.Stat("dump.rdb")
+		if err == nil && atomic.LoadInt32(&bgsaveInProg) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSaveIfDue(t *testing.T) {
+	for atomic.LoadInt32(&bgsaveInProg) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	dict := datastructure.CreateDict()
+	dict.Set("key1", "value1", 0)
+
+	db := &DB{
+		Dict: dict,
+		Set:  datastructure.CreateSet(),
+		List: datastructure.CreateList(),
+		Hash: datastructure.CreateHashMap(),
+	}
+
+	tmpFile := filepath.Join(t.TempDir(), "dump.rdb")
+	saved := config.Global
+	config.Global = &config.Config{}
+	config.Global.Persistence.RDB.Filename = tmpFile
+	defer func() { config.Global = saved }()
+	rdb, _ := persistence.OpenRDB(tmpFile, true)
+	defer rdb.Close()
+	db.RDB = rdb
+	SetSystemContext(&SystemContext{DB: db})
+	InitSystemCommands()
+	for range 3 {
+		db.RunWrite(func() {})
+	}
+
+	start := time.Unix(atomic.LoadInt64(&lastSave), 0)
+	rules := []config.SaveRule{{After: time.Minute, Changes: 3}, {After: time.Hour, Changes: 1}}
+	if SaveIfDue(rules, start.Add(30*time.Second)) {
+		t.Error("Saved before any rule was met")
+	}
+	if SaveIfDue([]config.SaveRule{{After: time.Minute, Changes: 4}}, start.Add(2*time.Minute)) {
+		t.Error("Saved with too few changes")
+	}
+	if !SaveIfDue(rules, start.Add(time.Minute)) {
+		t.Fatal("Expected a save once a rule was met")
+	}
+	for atomic.LoadInt32(&bgsaveInProg) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&dirty); n != 0 {
+		t.Errorf("Expected no changes since the save, got %d", n)
+	}
+	snapshot, err := rdb.Load(tmpFile)
+	if err != nil || snapshot.DictData["key1"].Value != "value1" {
+		t.Errorf("Expected key1 saved, got %v %v", snapshot, err)
+	}
+
+	// A failed save waits before the rules try again.
+	now := start.Add(2 * time.Hour)
+	atomic.StoreInt64(&dirty, 10)
+	atomic.StoreInt64(&lastSave, start.Unix())
+	atomic.StoreInt32(&lastBgsaveFailed, 1)
+	atomic.StoreInt64(&lastBgsaveTry, now.Add(-time.Second).Unix())
+	if SaveIfDue(rules, now) {
+		t.Error("Retried a failed save too soon")
+	}
+	atomic.StoreInt32(&lastBgsaveFailed, 0)
 }
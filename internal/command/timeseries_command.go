@@ -0,0 +1,472 @@
+package command
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type TimeSeriesStore interface {
+	TSCreate(key string, opts datastructure.TSOptions) error
+	TSAdd(key string, ts int64, value float64, opts *datastructure.TSOptions, onDuplicate datastructure.DuplicatePolicy) (int64, error)
+	TSGet(key string) (datastructure.Sample, bool, error)
+	TSRange(key string, from, to int64, reverse bool, agg *datastructure.Aggregation, count int) ([]datastructure.Sample, error)
+	TSMRange(from, to int64, reverse bool, agg *datastructure.Aggregation, count int, filters []datastructure.LabelFilter) []datastructure.SeriesRange
+	TSCreateRule(src, dest string, agg datastructure.Aggregation) error
+	TSDeleteRule(src, dest string) error
+	TSInfo(key string) (datastructure.TSInfo, error)
+	Dump(keys ...string) map[string]datastructure.TSDump
+}
+
+type TimeSeriesContext struct {
+	TimeSeries TimeSeriesStore
+	AOF        *persistence.AOF
+}
+
+var tsCtx *TimeSeriesContext
+
+func SetTimeSeriesContext(c *TimeSeriesContext) { tsCtx = c }
+
+func InitTimeSeriesCommands() {
+	Register("TS.CREATE", cmdTSCreate)
+	Register("TS.ADD", cmdTSAdd)
+	Register("TS.MADD", cmdTSMadd)
+	Register("TS.GET", cmdTSGet)
+	Register("TS.RANGE", cmdTSRange)
+	Register("TS.REVRANGE", cmdTSRevrange)
+	Register("TS.MRANGE", cmdTSMrange)
+	Register("TS.MREVRANGE", cmdTSMrevrange)
+	Register("TS.CREATERULE", cmdTSCreateRule)
+	Register("TS.DELETERULE", cmdTSDeleteRule)
+	Register("TS.INFO", cmdTSInfo)
+}
+
+var (
+	errTSTimestamp = resp.Value{Type: resp.Error, Text: "ERR TSDB: invalid timestamp"}
+	errTSValue     = resp.Value{Type: resp.Error, Text: "ERR TSDB: invalid value"}
+	errTSSyntax    = resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+)
+
+func parseTimestamp(s string) (int64, bool) {
+	if s == "*" {
+		return time.Now().UnixMilli(), true
+	}
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, false
+	}
+	return ts, true
+}
+
+func parseSampleValue(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+func parseTSOptions(args []resp.Value) (datastructure.TSOptions, datastructure.DuplicatePolicy, bool) {
+	opts := datastructure.TSOptions{}
+	var onDuplicate datastructure.DuplicatePolicy
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "RETENTION":
+			if i+1 >= len(args) {
+				return opts, "", false
+			}
+			r, err := strconv.ParseInt(args[i+1].Text, 10, 64)
+			if err != nil || r < 0 {
+				return opts, "", false
+			}
+			opts.Retention = r
+			i++
+		case "DUPLICATE_POLICY", "ON_DUPLICATE":
+			if i+1 >= len(args) {
+				return opts, "", false
+			}
+			p, ok := datastructure.ParseDuplicatePolicy(args[i+1].Text)
+			if !ok {
+				return opts, "", false
+			}
+			if strings.EqualFold(args[i].Text, "ON_DUPLICATE") {
+				onDuplicate = p
+			} else {
+				opts.Duplicate = p
+			}
+			i++
+		case "LABELS":
+			rest := args[i+1:]
+			if len(rest)%2 != 0 {
+				return opts, "", false
+			}
+			opts.Labels = make(map[string]string, len(rest)/2)
+			for j := 0; j < len(rest); j += 2 {
+				opts.Labels[rest[j].Text] = rest[j+1].Text
+			}
+			return opts, onDuplicate, true
+		default:
+			return opts, "", false
+		}
+	}
+	return opts, onDuplicate, true
+}
+
+func parseAggregation(args []resp.Value) (*datastructure.Aggregation, bool) {
+	if len(args) < 2 {
+		return nil, false
+	}
+	agg, ok := datastructure.ParseAggregator(args[0].Text)
+	if !ok {
+		return nil, false
+	}
+	bucket, err := strconv.ParseInt(args[1].Text, 10, 64)
+	if err != nil || bucket <= 0 {
+		return nil, false
+	}
+	return &datastructure.Aggregation{Type: agg, Bucket: bucket}, true
+}
+
+func sampleReply(s datastructure.Sample) resp.Value {
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.Integer, Number: s.Timestamp},
+		{Type: resp.SimpleString, Text: formatScore(s.Value)},
+	}}
+}
+
+func samplesReply(samples []datastructure.Sample) resp.Value {
+	items := make([]resp.Value, 0, len(samples))
+	for _, s := range samples {
+		items = append(items, sampleReply(s))
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func labelsReply(labels map[string]string) resp.Value {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	items := make([]resp.Value, 0, len(names))
+	for _, k := range names {
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: k},
+			{Type: resp.BulkString, Text: labels[k]},
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdTSCreate(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.create'"}
+	}
+	opts, onDuplicate, ok := parseTSOptions(args[1:])
+	if !ok || onDuplicate != "" {
+		return errTSSyntax
+	}
+	if err := tsCtx.TimeSeries.TSCreate(args[0].Text, opts); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(tsCtx.AOF, "TS.CREATE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdTSAdd(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.add'"}
+	}
+	ts, ok := parseTimestamp(args[1].Text)
+	if !ok {
+		return errTSTimestamp
+	}
+	value, ok := parseSampleValue(args[2].Text)
+	if !ok {
+		return errTSValue
+	}
+	opts, onDuplicate, ok := parseTSOptions(args[3:])
+	if !ok {
+		return errTSSyntax
+	}
+	added, err := tsCtx.TimeSeries.TSAdd(args[0].Text, ts, value, &opts, onDuplicate)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	logged := append([]resp.Value{}, args...)
+	logged[1] = resp.Value{Type: resp.BulkString, Text: strconv.FormatInt(added, 10)}
+	appendToAOF(tsCtx.AOF, "TS.ADD", logged)
+	return resp.Value{Type: resp.Integer, Number: added}
+}
+
+func cmdTSMadd(args []resp.Value) resp.Value {
+	if len(args) < 3 || len(args)%3 != 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.madd'"}
+	}
+	items := make([]resp.Value, 0, len(args)/3)
+	logged := []resp.Value{}
+	for i := 0; i < len(args); i += 3 {
+		ts, ok := parseTimestamp(args[i+1].Text)
+		if !ok {
+			items = append(items, errTSTimestamp)
+			continue
+		}
+		value, ok := parseSampleValue(args[i+2].Text)
+		if !ok {
+			items = append(items, errTSValue)
+			continue
+		}
+		added, err := tsCtx.TimeSeries.TSAdd(args[i].Text, ts, value, nil, "")
+		if err != nil {
+			items = append(items, errorReply(err))
+			continue
+		}
+		items = append(items, resp.Value{Type: resp.Integer, Number: added})
+		logged = append(logged, args[i], resp.Value{Type: resp.BulkString, Text: strconv.FormatInt(added, 10)}, args[i+2])
+	}
+	if len(logged) > 0 {
+		appendToAOF(tsCtx.AOF, "TS.MADD", logged)
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdTSGet(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.get'"}
+	}
+	sample, ok, err := tsCtx.TimeSeries.TSGet(args[0].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.Value{Type: resp.Array, Items: []resp.Value{}}
+	}
+	return sampleReply(sample)
+}
+
+type tsRangeQuery struct {
+	from       int64
+	to         int64
+	count      int
+	agg        *datastructure.Aggregation
+	withLabels bool
+	filters    []datastructure.LabelFilter
+}
+
+func parseRangeBound(s string, open int64) (int64, bool) {
+	if s == "-" || s == "+" {
+		return open, true
+	}
+	ts, err := strconv.ParseInt(s, 10, 64)
+	return ts, err == nil
+}
+
+func parseTSRange(args []resp.Value, multi bool) (tsRangeQuery, resp.Value, bool) {
+	q := tsRangeQuery{}
+	var ok bool
+	if q.from, ok = parseRangeBound(args[0].Text, math.MinInt64); !ok {
+		return q, errTSTimestamp, false
+	}
+	if q.to, ok = parseRangeBound(args[1].Text, math.MaxInt64); !ok {
+		return q, errTSTimestamp, false
+	}
+
+	rest := args[2:]
+	for i := 0; i < len(rest); i++ {
+		switch strings.ToUpper(rest[i].Text) {
+		case "COUNT":
+			if i+1 >= len(rest) {
+				return q, errTSSyntax, false
+			}
+			n, err := strconv.Atoi(rest[i+1].Text)
+			if err != nil || n <= 0 {
+				return q, resp.Value{Type: resp.Error, Text: "ERR TSDB: invalid COUNT value"}, false
+			}
+			q.count = n
+			i++
+		case "AGGREGATION":
+			if q.agg, ok = parseAggregation(rest[i+1:]); !ok {
+				return q, resp.Value{Type: resp.Error, Text: "ERR TSDB: invalid aggregation"}, false
+			}
+			i += 2
+		case "WITHLABELS":
+			if !multi {
+				return q, errTSSyntax, false
+			}
+			q.withLabels = true
+		case "FILTER":
+			if !multi {
+				return q, errTSSyntax, false
+			}
+			filters, ok := parseLabelFilters(rest[i+1:])
+			if !ok {
+				return q, resp.Value{Type: resp.Error, Text: "ERR TSDB: please provide at least one matcher"}, false
+			}
+			q.filters = filters
+			return q, resp.Value{}, true
+		default:
+			return q, errTSSyntax, false
+		}
+	}
+	if multi {
+		return q, resp.Value{Type: resp.Error, Text: "ERR TSDB: missing FILTER argument"}, false
+	}
+	return q, resp.Value{}, true
+}
+
+func parseLabelFilters(args []resp.Value) ([]datastructure.LabelFilter, bool) {
+	filters := make([]datastructure.LabelFilter, 0, len(args))
+	positive := false
+	for _, a := range args {
+		expr := a.Text
+		eq := strings.IndexByte(expr, '=')
+		if eq <= 0 {
+			return nil, false
+		}
+		f := datastructure.LabelFilter{Label: expr[:eq]}
+		if strings.HasSuffix(f.Label, "!") {
+			f.Label = f.Label[:len(f.Label)-1]
+			f.Negate = true
+		}
+		value := expr[eq+1:]
+		if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+			f.Values = strings.Split(value[1:len(value)-1], ",")
+		} else {
+			f.Values = []string{value}
+		}
+		if f.Label == "" {
+			return nil, false
+		}
+		if !f.Negate && value != "" {
+			positive = true
+		}
+		filters = append(filters, f)
+	}
+	return filters, positive
+}
+
+func tsRange(args []resp.Value, reverse bool, name string) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for '" + name + "'"}
+	}
+	q, errVal, ok := parseTSRange(args[1:], false)
+	if !ok {
+		return errVal
+	}
+	samples, err := tsCtx.TimeSeries.TSRange(args[0].Text, q.from, q.to, reverse, q.agg, q.count)
+	if err != nil {
+		return errorReply(err)
+	}
+	return samplesReply(samples)
+}
+
+func cmdTSRange(args []resp.Value) resp.Value {
+	return tsRange(args, false, "ts.range")
+}
+
+func cmdTSRevrange(args []resp.Value) resp.Value {
+	return tsRange(args, true, "ts.revrange")
+}
+
+func tsMrange(args []resp.Value, reverse bool, name string) resp.Value {
+	if len(args) < 4 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for '" + name + "'"}
+	}
+	q, errVal, ok := parseTSRange(args, true)
+	if !ok {
+		return errVal
+	}
+	series := tsCtx.TimeSeries.TSMRange(q.from, q.to, reverse, q.agg, q.count, q.filters)
+	items := make([]resp.Value, 0, len(series))
+	for _, s := range series {
+		labels := resp.Value{Type: resp.Array, Items: []resp.Value{}}
+		if q.withLabels {
+			labels = labelsReply(s.Labels)
+		}
+		items = append(items, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: s.Key},
+			labels,
+			samplesReply(s.Samples),
+		}})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdTSMrange(args []resp.Value) resp.Value {
+	return tsMrange(args, false, "ts.mrange")
+}
+
+func cmdTSMrevrange(args []resp.Value) resp.Value {
+	return tsMrange(args, true, "ts.mrevrange")
+}
+
+func cmdTSCreateRule(args []resp.Value) resp.Value {
+	if len(args) != 5 || !strings.EqualFold(args[2].Text, "AGGREGATION") {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.createrule'"}
+	}
+	agg, ok := parseAggregation(args[3:])
+	if !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR TSDB: invalid aggregation"}
+	}
+	if err := tsCtx.TimeSeries.TSCreateRule(args[0].Text, args[1].Text, *agg); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(tsCtx.AOF, "TS.CREATERULE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdTSDeleteRule(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.deleterule'"}
+	}
+	if err := tsCtx.TimeSeries.TSDeleteRule(args[0].Text, args[1].Text); err != nil {
+		return errorReply(err)
+	}
+	appendToAOF(tsCtx.AOF, "TS.DELETERULE", args)
+	return resp.Value{Type: resp.SimpleString, Text: "OK"}
+}
+
+func cmdTSInfo(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'ts.info'"}
+	}
+	info, err := tsCtx.TimeSeries.TSInfo(args[0].Text)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	sourceKey := resp.Value{Type: resp.BulkString, IsNil: true}
+	if info.SourceKey != "" {
+		sourceKey = resp.Value{Type: resp.BulkString, Text: info.SourceKey}
+	}
+	rules := make([]resp.Value, 0, len(info.Rules))
+	for _, rule := range info.Rules {
+		rules = append(rules, resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: rule.Dest},
+			{Type: resp.Integer, Number: rule.Aggregation.Bucket},
+			{Type: resp.SimpleString, Text: string(rule.Aggregation.Type)},
+		}})
+	}
+	field := func(name string) resp.Value {
+		return resp.Value{Type: resp.SimpleString, Text: name}
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		field("totalSamples"), {Type: resp.Integer, Number: int64(info.TotalSamples)},
+		field("memoryUsage"), {Type: resp.Integer, Number: int64(info.MemoryUsage)},
+		field("firstTimestamp"), {Type: resp.Integer, Number: info.First},
+		field("lastTimestamp"), {Type: resp.Integer, Number: info.Last},
+		field("retentionTime"), {Type: resp.Integer, Number: info.Options.Retention},
+		field("chunkCount"), {Type: resp.Integer, Number: int64(info.ChunkCount)},
+		field("duplicatePolicy"), {Type: resp.SimpleString, Text: strings.ToLower(string(info.Options.Duplicate))},
+		field("labels"), labelsReply(info.Options.Labels),
+		field("sourceKey"), sourceKey,
+		field("rules"), {Type: resp.Array, Items: rules},
+	}}
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupTimeSeriesTest() *datastructure.TimeSeries {
+	ts := datastructure.CreateTimeSeries()
+	SetTimeSeriesContext(&TimeSeriesContext{TimeSeries: ts, AOF: nil})
+	return ts
+}
+
+func TestCmdTSAddRange(t *testing.T) {
+	setupTimeSeriesTest()
+
+	if result := cmdTSCreate(bulkArgs("temp", "RETENTION", "0", "DUPLICATE_POLICY", "last", "LABELS", "room", "a")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	if result := cmdTSCreate(bulkArgs("bad", "DUPLICATE_POLICY", "nope")); result.Type != resp.Error {
+		t.Error("Expected error for unknown policy")
+	}
+	if result := cmdTSAdd(bulkArgs("temp", "abc", "1")); result.Type != resp.Error {
+		t.Error("Expected error for invalid timestamp")
+	}
+	if result := cmdTSAdd(bulkArgs("temp", "*", "21.5")); result.Type != resp.Integer || result.Number == 0 {
+		t.Errorf("Expected server timestamp, got %v", result)
+	}
+
+	result := cmdTSMadd(bulkArgs("temp", "10", "1", "temp", "20", "3", "missing", "30", "1"))
+	if len(result.Items) != 3 || result.Items[1].Number != 20 || result.Items[2].Type != resp.Error {
+		t.Errorf("Unexpected MADD result %v", result.Items)
+	}
+
+	result = cmdTSRange(bulkArgs("temp", "-", "100"))
+	if len(result.Items) != 2 || result.Items[0].Items[0].Number != 10 || result.Items[1].Items[1].Text != "3" {
+		t.Errorf("Unexpected range %v", result.Items)
+	}
+	result = cmdTSRange(bulkArgs("temp", "0", "100", "AGGREGATION", "avg", "100"))
+	if len(result.Items) != 1 || result.Items[0].Items[1].Text != "2" {
+		t.Errorf("Unexpected aggregation %v", result.Items)
+	}
+	result = cmdTSRevrange(bulkArgs("temp", "-", "+", "COUNT", "1"))
+	if len(result.Items) != 1 || result.Items[0].Items[1].Text != "21.5" {
+		t.Errorf("Unexpected reverse range %v", result.Items)
+	}
+	if result := cmdTSRange(bulkArgs("temp", "0", "100", "AGGREGATION", "median", "10")); result.Type != resp.Error {
+		t.Error("Expected error for unknown aggregation")
+	}
+	if result := cmdTSGet(bulkArgs("temp")); result.Items[1].Text != "21.5" {
+		t.Errorf("Expected last value 21.5, got %v", result.Items)
+	}
+}
+
+func TestCmdTSMrange(t *testing.T) {
+	setupTimeSeriesTest()
+	cmdTSAdd(bulkArgs("cpu:1", "1", "10", "LABELS", "metric", "cpu", "host", "a"))
+	cmdTSAdd(bulkArgs("cpu:2", "1", "20", "LABELS", "metric", "cpu", "host", "b"))
+	cmdTSAdd(bulkArgs("mem:1", "1", "30", "LABELS", "metric", "mem", "host", "a"))
+
+	result := cmdTSMrange(bulkArgs("-", "+", "WITHLABELS", "FILTER", "metric=cpu", "host!=b"))
+	if len(result.Items) != 1 || result.Items[0].Items[0].Text != "cpu:1" {
+		t.Fatalf("Unexpected series %v", result.Items)
+	}
+	if labels := result.Items[0].Items[1].Items; len(labels) != 2 || labels[0].Items[0].Text != "host" {
+		t.Errorf("Expected sorted labels, got %v", labels)
+	}
+	result = cmdTSMrevrange(bulkArgs("-", "+", "FILTER", "metric=(cpu,mem)", "host=a"))
+	if len(result.Items) != 2 || len(result.Items[0].Items[1].Items) != 0 {
+		t.Errorf("Unexpected series %v", result.Items)
+	}
+	if result := cmdTSMrange(bulkArgs("-", "+", "FILTER", "host!=a")); result.Type != resp.Error {
+		t.Error("Expected error without a positive matcher")
+	}
+	if result := cmdTSMrange(bulkArgs("-", "+", "COUNT", "1")); result.Type != resp.Error {
+		t.Error("Expected error without FILTER")
+	}
+}
+
+func TestCmdTSRulesInfo(t *testing.T) {
+	setupTimeSeriesTest()
+	cmdTSCreate(bulkArgs("raw"))
+	cmdTSCreate(bulkArgs("hourly"))
+
+	if result := cmdTSCreateRule(bulkArgs("raw", "hourly", "AGGREGATION", "max", "10")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+	cmdTSMadd(bulkArgs("raw", "1", "5", "raw", "2", "9", "raw", "11", "1"))
+
+	result := cmdTSRange(bulkArgs("hourly", "-", "+"))
+	if len(result.Items) != 1 || result.Items[0].Items[1].Text != "9" {
+		t.Errorf("Unexpected compaction %v", result.Items)
+	}
+
+	info := cmdTSInfo(bulkArgs("raw"))
+	fields := map[string]resp.Value{}
+	for i := 0; i+1 < len(info.Items); i += 2 {
+		fields[info.Items[i].Text] = info.Items[i+1]
+	}
+	if fields["totalSamples"].Number != 3 || fields["duplicatePolicy"].Text != "block" || len(fields["rules"].Items) != 1 {
+		t.Errorf("Unexpected info %v", info.Items)
+	}
+	if info := cmdTSInfo(bulkArgs("hourly")); info.Items[17].Text != "raw" {
+		t.Errorf("Expected source key raw, got %v", info.Items[17])
+	}
+	if result := cmdTSDeleteRule(bulkArgs("raw", "hourly")); result.Text != "OK" {
+		t.Errorf("Expected OK, got %v", result)
+	}
+}
+
+func TestTimeSeriesAOFRewrite(t *testing.T) {
+	aof := openTestAOF(t)
+
+	ts := setupTimeSeriesTest()
+	cmdTSCreate(bulkArgs("raw", "LABELS", "k", "v"))
+	cmdTSCreate(bulkArgs("sum", "DUPLICATE_POLICY", "SUM"))
+	cmdTSCreateRule(bulkArgs("raw", "sum", "AGGREGATION", "sum", "100"))
+	for i := 0; i < 1500; i++ {
+		cmdTSAdd(bulkArgs("raw", formatScore(float64(i*10)), "0.5"))
+	}
+	rewriteAOF(t, aof, persistence.Snapshot{TSData: ts.Dump()})
+
+	replayed := setupTimeSeriesTest()
+	Init(&DB{Dict: datastructure.CreateDict(), Set: datastructure.CreateSet(), List: datastructure.CreateList(), Hash: datastructure.CreateHashMap(), TS: replayed, AOF: aof})
+	aof.LoadAll(nil, func(cmd string, args []resp.Value) {
+		Replay(cmd, args)
+	})
+
+	cmdTSAdd(bulkArgs("raw", "20000", "1"))
+	ts.TSAdd("raw", 20000, 1, nil, "")
+	want, _ := ts.TSRange("sum", 0, 1<<40, false, nil, 0)
+	got, _ := replayed.TSRange("sum", 0, 1<<40, false, nil, 0)
+	if len(got) != len(want) || got[len(got)-1] != want[len(want)-1] || got[0].Value != 5 {
+		t.Errorf("Expected %d compacted samples ending %v, got %d ending %v", len(want), want[len(want)-1], len(got), got[len(got)-1])
+	}
+}
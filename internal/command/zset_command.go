@@ -0,0 +1,250 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type ZSetStore interface {
+	Zadd(key string, flags datastructure.ZAddFlags, entries ...datastructure.ScoreMember) int
+	Zrem(key string, members ...string) int
+	Zscore(key, member string) (float64, bool)
+	Zcard(key string) int
+	Zrank(key, member string, reverse bool) (int, bool)
+	Zrange(key string, start, stop int, reverse bool) []datastructure.ScoreMember
+	ZrangeByScore(key string, r datastructure.ScoreRange) []datastructure.ScoreMember
+	Zstore(key string, entries []datastructure.ScoreMember) int
+	Geoadd(key string, flags datastructure.ZAddFlags, lon, lat float64, member string) int
+	Geopos(key, member string) (float64, float64, bool)
+	Geodist(key, member1, member2 string) (float64, bool)
+	GeoSearch(key string, q datastructure.GeoQuery) []datastructure.GeoResult
+	Dump(keys ...string) map[string]map[string]float64
+}
+
+type ZSetContext struct {
+	ZSet ZSetStore
+	AOF  *persistence.AOF
+}
+
+var zsetCtx *ZSetContext
+
+func SetZSetContext(c *ZSetContext) { zsetCtx = c }
+
+func InitZSetCommands() {
+	Register("ZADD", cmdZadd)
+	Register("ZREM", cmdZrem)
+	Register("ZSCORE", cmdZscore)
+	Register("ZCARD", cmdZcard)
+	Register("ZRANK", cmdZrank)
+	Register("ZREVRANK", cmdZrevrank)
+	Register("ZRANGE", cmdZrange)
+	Register("ZRANGEBYSCORE", cmdZrangeByScore)
+}
+
+func formatScore(score float64) string {
+	if math.IsInf(score, 0) {
+		if score > 0 {
+			return "inf"
+		}
+		return "-inf"
+	}
+	e := strconv.FormatFloat(score, 'e', -1, 64)
+	if exp, _ := strconv.Atoi(e[strings.IndexByte(e, 'e')+1:]); exp < -4 || exp >= 17 {
+		return e
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func parseScore(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "+inf", "inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	f, ok := parseScore(s)
+	return f, exclusive, ok
+}
+
+func appendToAOF(aof *persistence.AOF, name string, args []resp.Value) {
+	if aof == nil {
+		return
+	}
+	arr := make([]resp.Value, 0, len(args)+1)
+	arr = append(arr, resp.Value{Type: resp.BulkString, Text: name})
+	for _, a := range args {
+		arr = append(arr, resp.Value{Type: resp.BulkString, Text: a.Text})
+	}
+	_ = aof.Append(resp.Value{Type: resp.Array, Items: arr})
+}
+
+func scoreMembersReply(entries []datastructure.ScoreMember, withScores bool) resp.Value {
+	items := make([]resp.Value, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, resp.Value{Type: resp.BulkString, Text: e.Member})
+		if withScores {
+			items = append(items, resp.Value{Type: resp.BulkString, Text: formatScore(e.Score)})
+		}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func cmdZadd(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zadd'"}
+	}
+	key := args[0].Text
+	var flags datastructure.ZAddFlags
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Text) {
+		case "NX":
+			flags.NX = true
+			continue
+		case "XX":
+			flags.XX = true
+			continue
+		case "CH":
+			flags.CH = true
+			continue
+		}
+		break
+	}
+	if flags.NX && flags.XX {
+		return resp.Value{Type: resp.Error, Text: "ERR XX and NX options at the same time are not compatible"}
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+	}
+
+	entries := make([]datastructure.ScoreMember, 0, len(rest)/2)
+	for j := 0; j < len(rest); j += 2 {
+		score, ok := parseScore(rest[j].Text)
+		if !ok {
+			return resp.Value{Type: resp.Error, Text: "ERR value is not a valid float"}
+		}
+		entries = append(entries, datastructure.ScoreMember{Member: rest[j+1].Text, Score: score})
+	}
+
+	n := zsetCtx.ZSet.Zadd(key, flags, entries...)
+	appendToAOF(zsetCtx.AOF, "ZADD", args)
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func cmdZrem(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zrem'"}
+	}
+	members := make([]string, 0, len(args)-1)
+	for _, a := range args[1:] {
+		members = append(members, a.Text)
+	}
+	n := zsetCtx.ZSet.Zrem(args[0].Text, members...)
+	if n > 0 {
+		appendToAOF(zsetCtx.AOF, "ZREM", args)
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(n)}
+}
+
+func cmdZscore(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zscore'"}
+	}
+	score, ok := zsetCtx.ZSet.Zscore(args[0].Text, args[1].Text)
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.BulkString, Text: formatScore(score)}
+}
+
+func cmdZcard(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zcard'"}
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(zsetCtx.ZSet.Zcard(args[0].Text))}
+}
+
+func zrankGeneric(args []resp.Value, name string, reverse bool) resp.Value {
+	if len(args) != 2 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for '" + name + "'"}
+	}
+	rank, ok := zsetCtx.ZSet.Zrank(args[0].Text, args[1].Text, reverse)
+	if !ok {
+		return resp.Value{Type: resp.BulkString, IsNil: true}
+	}
+	return resp.Value{Type: resp.Integer, Number: int64(rank)}
+}
+
+func cmdZrank(args []resp.Value) resp.Value {
+	return zrankGeneric(args, "zrank", false)
+}
+
+func cmdZrevrank(args []resp.Value) resp.Value {
+	return zrankGeneric(args, "zrevrank", true)
+}
+
+func cmdZrange(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zrange'"}
+	}
+	start, err := strconv.Atoi(args[1].Text)
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+	}
+	stop, err := strconv.Atoi(args[2].Text)
+	if err != nil {
+		return resp.Value{Type: resp.Error, Text: "ERR value is not an integer or out of range"}
+	}
+	withScores, reverse := false, false
+	for _, a := range args[3:] {
+		switch strings.ToUpper(a.Text) {
+		case "WITHSCORES":
+			withScores = true
+		case "REV":
+			reverse = true
+		default:
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+	}
+	return scoreMembersReply(zsetCtx.ZSet.Zrange(args[0].Text, start, stop, reverse), withScores)
+}
+
+func cmdZrangeByScore(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Value{Type: resp.Error, Text: "ERR wrong number of arguments for 'zrangebyscore'"}
+	}
+	var r datastructure.ScoreRange
+	var ok bool
+	if r.Min, r.MinEx, ok = parseScoreBound(args[1].Text); !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR min or max is not a float"}
+	}
+	if r.Max, r.MaxEx, ok = parseScoreBound(args[2].Text); !ok {
+		return resp.Value{Type: resp.Error, Text: "ERR min or max is not a float"}
+	}
+	withScores := false
+	for _, a := range args[3:] {
+		if strings.ToUpper(a.Text) != "WITHSCORES" {
+			return resp.Value{Type: resp.Error, Text: "ERR syntax error"}
+		}
+		withScores = true
+	}
+	return scoreMembersReply(zsetCtx.ZSet.ZrangeByScore(args[0].Text, r), withScores)
+}
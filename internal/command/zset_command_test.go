@@ -0,0 +1,91 @@
+package command
+
+import (
+	"math"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func setupZSetTest() *datastructure.ZSet {
+	zset := datastructure.CreateZSet()
+	SetZSetContext(&ZSetContext{ZSet: zset, AOF: nil})
+	return zset
+}
+
+func TestCmdZadd(t *testing.T) {
+	setupZSetTest()
+
+	result := cmdZadd(bulkArgs("z", "1", "a", "2.5", "b"))
+	if result.Number != 2 {
+		t.Errorf("Expected 2, got %d", result.Number)
+	}
+	result = cmdZadd(bulkArgs("z", "XX", "CH", "3", "a", "1", "c"))
+	if result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+	if result := cmdZadd(bulkArgs("z", "abc", "a")); result.Type != resp.Error {
+		t.Error("Expected error for invalid score")
+	}
+	if result := cmdZadd(bulkArgs("z", "NX", "XX", "1", "a")); result.Type != resp.Error {
+		t.Error("Expected error for NX with XX")
+	}
+	if result := cmdZscore(bulkArgs("z", "b")); result.Text != "2.5" {
+		t.Errorf("Expected 2.5, got %s", result.Text)
+	}
+}
+
+func TestCmdZrange(t *testing.T) {
+	setupZSetTest()
+	cmdZadd(bulkArgs("z", "1", "a", "2", "b", "3", "c"))
+
+	result := cmdZrange(bulkArgs("z", "0", "-1", "WITHSCORES"))
+	if len(result.Items) != 6 || result.Items[0].Text != "a" || result.Items[5].Text != "3" {
+		t.Errorf("Unexpected range %v", result.Items)
+	}
+	result = cmdZrangeByScore(bulkArgs("z", "(1", "+inf"))
+	if len(result.Items) != 2 || result.Items[0].Text != "b" {
+		t.Errorf("Unexpected score range %v", result.Items)
+	}
+	if result := cmdZrank(bulkArgs("z", "c")); result.Number != 2 {
+		t.Errorf("Expected rank 2, got %d", result.Number)
+	}
+	if result := cmdZrevrank(bulkArgs("z", "missing")); !result.IsNil {
+		t.Error("Expected nil rank for missing member")
+	}
+}
+
+func TestCmdZrem(t *testing.T) {
+	setupZSetTest()
+	cmdZadd(bulkArgs("z", "1", "a", "2", "b"))
+
+	if result := cmdZrem(bulkArgs("z", "a", "x")); result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+	if result := cmdZcard(bulkArgs("z")); result.Number != 1 {
+		t.Errorf("Expected 1, got %d", result.Number)
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{3479447370796909, "3479447370796909"},
+		{1000000, "1000000"},
+		{2.5, "2.5"},
+		{-0.1, "-0.1"},
+		{0, "0"},
+		{1e17, "1e+17"},
+		{0.00001, "1e-05"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+	}
+	for _, tt := range tests {
+		if got := formatScore(tt.score); got != tt.want {
+			t.Errorf("formatScore(%v) = %q, want %q", tt.score, got, tt.want)
+		}
+	}
+}
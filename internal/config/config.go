@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -11,7 +14,13 @@ type Config struct {
 	Server        ServerConfig        `yaml:"server"`
 	Persistence   PersistenceConfig   `yaml:"persistence"`
 	Datastructure DatastructureConfig `yaml:"datastructure"`
+	Memory        MemoryConfig        `yaml:"memory"`
 	Logging       LoggingConfig       `yaml:"logging"`
+	Replication   ReplicationConfig   `yaml:"replication"`
+	Sentinel      SentinelConfig      `yaml:"sentinel"`
+	Cluster       ClusterConfig       `yaml:"cluster"`
+	Raft          RaftConfig          `yaml:"raft"`
+	ActiveActive  ActiveActiveConfig  `yaml:"active_active"`
 }
 
 type ServerConfig struct {
@@ -19,32 +28,99 @@ type ServerConfig struct {
 	ReadTimeout  int    `yaml:"read_timeout"`
 	WriteTimeout int    `yaml:"write_timeout"`
 	Auth         string `yaml:"auth"`
+	// ACLFile holds the users, one "user <name> <rules...>" line each. It is
+	// loaded at startup and by ACL LOAD, and written by ACL SAVE.
+	ACLFile string `yaml:"aclfile"`
+	// Execution is "concurrent", where every connection runs its own
+	// commands, or "single", where one goroutine runs them all in order.
+	Execution string `yaml:"execution"`
 }
 
 type PersistenceConfig struct {
-	AOF AOFConfig `yaml:"aof"`
-	RDB RDBConfig `yaml:"rdb"`
+	AOF    AOFConfig    `yaml:"aof"`
+	RDB    RDBConfig    `yaml:"rdb"`
+	Backup BackupConfig `yaml:"backup"`
+}
+
+// BackupConfig schedules point-in-time backups: an RDB snapshot every
+// Interval seconds, and the write commands in between.
+type BackupConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Dir      string `yaml:"dir"`
+	Interval int    `yaml:"interval"`
+	// Keep is how many backups to keep, MaxAge how many seconds; 0 means
+	// no limit. The newest backup is always kept.
+	Keep   int `yaml:"keep"`
+	MaxAge int `yaml:"max_age"`
 }
 
 type AOFConfig struct {
 	Enabled         bool   `yaml:"enabled"`
 	Filename        string `yaml:"filename"`
 	RewriteInterval int    `yaml:"rewrite_interval"`
+	// Dir holds the base and incremental files of the AOF and their
+	// manifest, named after Filename.
+	Dir string `yaml:"dir"`
+	// RDBPreamble writes the base file in RDB form, which loads faster
+	// than replaying commands.
+	RDBPreamble *bool `yaml:"rdb_preamble"`
+	// LoadTruncated lets startup cut off a command left half-written by a
+	// crash instead of refusing to start, like aof-load-truncated.
+	LoadTruncated *bool `yaml:"load_truncated"`
 }
 
 type RDBConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	Filename string `yaml:"filename"`
+	// Save lists "<seconds> <changes>" rules, like Redis's save directive.
+	Save []string `yaml:"save"`
+}
+
+// SaveRule is one parsed entry of RDBConfig.Save.
+type SaveRule struct {
+	After   time.Duration
+	Changes int64
 }
 
 type DatastructureConfig struct {
+	// Segments is how many independently locked parts each keyspace is
+	// split into, rounded up to a power of two.
+	Segments   int              `yaml:"segments"`
 	Expiration ExpirationConfig `yaml:"expiration"`
+	Encoding   EncodingConfig   `yaml:"encoding"`
+}
+
+// EncodingConfig is Redis's *-max-listpack-* settings. Unset uses the Redis
+// default and a negative value disables the compact encoding.
+type EncodingConfig struct {
+	SetMaxIntsetEntries    int `yaml:"set_max_intset_entries"`
+	SetMaxListpackEntries  int `yaml:"set_max_listpack_entries"`
+	SetMaxListpackValue    int `yaml:"set_max_listpack_value"`
+	HashMaxListpackEntries int `yaml:"hash_max_listpack_entries"`
+	HashMaxListpackValue   int `yaml:"hash_max_listpack_value"`
+	ListMaxListpackEntries int `yaml:"list_max_listpack_entries"`
+	ListMaxListpackValue   int `yaml:"list_max_listpack_value"`
 }
 
 type ExpirationConfig struct {
-	MaxSampleSize   int `yaml:"max_sample_size"`
-	MaxSampleRounds int `yaml:"max_sample_rounds"`
-	CheckInterval   int `yaml:"check_interval"`
+	CheckInterval int `yaml:"check_interval"`
+	// MaxCycleTimeMs caps each active expiry cycle, so a burst of expiring
+	// keys is spread over several cycles.
+	MaxCycleTimeMs int `yaml:"max_cycle_time_ms"`
+}
+
+// MemoryConfig caps the heap and chooses which keys are evicted to stay under
+// the cap.
+type MemoryConfig struct {
+	// MaxMemory is a byte count with an optional unit: k/m/g are powers of
+	// 1000 and kb/mb/gb powers of 1024. 0 disables the limit.
+	MaxMemory        string `yaml:"maxmemory"`
+	MaxMemoryPolicy  string `yaml:"maxmemory_policy"`
+	MaxMemorySamples int    `yaml:"maxmemory_samples"`
+	LFULogFactor     int    `yaml:"lfu_log_factor"`
+	// LFUDecayTime is how many idle minutes take one off a key's access
+	// counter. 0 never decays it.
+	LFUDecayTime int `yaml:"lfu_decay_time"`
 }
 
 type LoggingConfig struct {
@@ -52,6 +128,80 @@ type LoggingConfig struct {
 	VerbosePersistence bool   `yaml:"verbose_persistence"`
 }
 
+type ReplicationConfig struct {
+	// ReplicaOf is "host port" of the master to follow at startup.
+	ReplicaOf       string `yaml:"replicaof"`
+	MasterAuth      string `yaml:"masterauth"`
+	ReplicaReadOnly *bool  `yaml:"replica_read_only"`
+	BacklogSize     int    `yaml:"backlog_size"`
+	Timeout         int    `yaml:"timeout"`
+}
+
+// SentinelConfig is used when running with --sentinel.
+type SentinelConfig struct {
+	// Peers lists the addresses of every sentinel, this one included.
+	Peers   []string               `yaml:"peers"`
+	Masters []SentinelMasterConfig `yaml:"masters"`
+}
+
+type SentinelMasterConfig struct {
+	Name              string `yaml:"name"`
+	Addr              string `yaml:"addr"`
+	Quorum            int    `yaml:"quorum"`
+	DownAfterMs       int    `yaml:"down_after_ms"`
+	FailoverTimeoutMs int    `yaml:"failover_timeout_ms"`
+	Auth              string `yaml:"auth"`
+}
+
+type ClusterConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	ConfigFile string `yaml:"config_file"`
+	// NodeTimeout is in milliseconds.
+	NodeTimeout int `yaml:"node_timeout"`
+	// BusPort defaults to the client port plus 10000.
+	BusPort int `yaml:"bus_port"`
+}
+
+// RaftConfig replaces AOF, RDB and replication with a Raft log when enabled.
+type RaftConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	ID      string `yaml:"id"`
+	// Addr is where raft RPCs are served.
+	Addr string `yaml:"addr"`
+	// ClientAddr is sent to clients in NOTLEADER replies and defaults to
+	// server.addr.
+	ClientAddr string `yaml:"client_addr"`
+	// Peers bootstraps a new group and must list this node too. Leave it
+	// empty on a node that will be added with RAFT ADDNODE.
+	Peers             []RaftPeerConfig `yaml:"peers"`
+	Dir               string           `yaml:"dir"`
+	ElectionTimeoutMs int              `yaml:"election_timeout_ms"`
+	SnapshotEntries   int              `yaml:"snapshot_entries"`
+	// ReadIndex confirms leadership before every read so followers never
+	// serve stale data and a deposed leader cannot either.
+	ReadIndex bool `yaml:"read_index"`
+}
+
+type RaftPeerConfig struct {
+	ID         string `yaml:"id"`
+	Addr       string `yaml:"addr"`
+	ClientAddr string `yaml:"client_addr"`
+}
+
+// ActiveActiveConfig lets every node accept writes and merges them as CRDTs.
+type ActiveActiveConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	ID      string `yaml:"id"`
+	// Peers are the client addresses of every other node.
+	Peers []string `yaml:"peers"`
+	// Auth is sent to peers and defaults to server.auth.
+	Auth        string `yaml:"auth"`
+	Dir         string `yaml:"dir"`
+	BacklogSize int    `yaml:"backlog_size"`
+	// Timeout is in seconds.
+	Timeout int `yaml:"timeout"`
+}
+
 var Global *Config
 
 func Load(path string) error {
@@ -81,6 +231,40 @@ func (c *Config) GetAOFRewriteInterval() time.Duration {
 	return time.Duration(c.Persistence.AOF.RewriteInterval) * time.Second
 }
 
+// GetAOFDir defaults to appendonlydir, as in Redis.
+func (c *Config) GetAOFDir() string {
+	if c.Persistence.AOF.Dir == "" {
+		return "appendonlydir"
+	}
+	return c.Persistence.AOF.Dir
+}
+
+// GetAOFRDBPreamble defaults to true.
+func (c *Config) GetAOFRDBPreamble() bool {
+	return c.Persistence.AOF.RDBPreamble == nil || *c.Persistence.AOF.RDBPreamble
+}
+
+// GetAOFLoadTruncated defaults to true, as in Redis.
+func (c *Config) GetAOFLoadTruncated() bool {
+	return c.Persistence.AOF.LoadTruncated == nil || *c.Persistence.AOF.LoadTruncated
+}
+
+// GetBackupDir defaults to backups.
+func (c *Config) GetBackupDir() string {
+	if c.Persistence.Backup.Dir == "" {
+		return "backups"
+	}
+	return c.Persistence.Backup.Dir
+}
+
+// GetBackupInterval defaults to an hour.
+func (c *Config) GetBackupInterval() time.Duration {
+	if c.Persistence.Backup.Interval <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.Persistence.Backup.Interval) * time.Second
+}
+
 func (c *Config) GetExpirationCheckInterval() time.Duration {
 	return time.Duration(c.Datastructure.Expiration.CheckInterval) * time.Second
 }
@@ -88,3 +272,159 @@ func (c *Config) GetExpirationCheckInterval() time.Duration {
 func (c *Config) GetAuth() string {
 	return c.Server.Auth
 }
+
+func (c *Config) GetACLFile() string {
+	return c.Server.ACLFile
+}
+
+// GetExecution defaults to concurrent execution.
+func (c *Config) GetExecution() string {
+	if c.Server.Execution == "" {
+		return "concurrent"
+	}
+	return strings.ToLower(c.Server.Execution)
+}
+
+// GetReplicaReadOnly defaults to true so replicas reject client writes.
+func (c *Config) GetReplicaReadOnly() bool {
+	return c.Replication.ReplicaReadOnly == nil || *c.Replication.ReplicaReadOnly
+}
+
+func (c *Config) GetReplicationTimeout() time.Duration {
+	return time.Duration(c.Replication.Timeout) * time.Second
+}
+
+func (c *Config) GetClusterNodeTimeout() time.Duration {
+	return time.Duration(c.Cluster.NodeTimeout) * time.Millisecond
+}
+
+func (c *Config) GetRaftElectionTimeout() time.Duration {
+	return time.Duration(c.Raft.ElectionTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) GetActiveActiveAuth() string {
+	if c.ActiveActive.Auth != "" {
+		return c.ActiveActive.Auth
+	}
+	return c.Server.Auth
+}
+
+func (c *Config) GetActiveActiveTimeout() time.Duration {
+	return time.Duration(c.ActiveActive.Timeout) * time.Second
+}
+
+// ParseMemory reads a size such as "100mb" or "1g" as a byte count.
+func ParseMemory(s string) (uint64, error) {
+	size := strings.ToLower(strings.TrimSpace(s))
+	if size == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	mult := uint64(1)
+	for _, u := range units {
+		if strings.HasSuffix(size, u.suffix) {
+			size, mult = strings.TrimSuffix(size, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * mult, nil
+}
+
+// ParseSaveRules reads save rules such as "900 1".
+func ParseSaveRules(rules []string) ([]SaveRule, error) {
+	parsed := make([]SaveRule, 0, len(rules))
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid save rule %q, expected \"<seconds> <changes>\"", rule)
+		}
+		seconds, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid save rule %q", rule)
+		}
+		changes, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || changes < 1 {
+			return nil, fmt.Errorf("invalid save rule %q", rule)
+		}
+		parsed = append(parsed, SaveRule{After: time.Duration(seconds) * time.Second, Changes: changes})
+	}
+	return parsed, nil
+}
+
+// GetSaveRules is the parsed RDB save rules, none when invalid.
+func (c *Config) GetSaveRules() []SaveRule {
+	rules, _ := ParseSaveRules(c.Persistence.RDB.Save)
+	return rules
+}
+
+func encodingLimit(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return max(v, 0)
+}
+
+// GetSetMaxIntsetEntries defaults to 512.
+func (c *Config) GetSetMaxIntsetEntries() int {
+	return encodingLimit(c.Datastructure.Encoding.SetMaxIntsetEntries, 512)
+}
+
+// GetSetMaxListpackEntries defaults to 128.
+func (c *Config) GetSetMaxListpackEntries() int {
+	return encodingLimit(c.Datastructure.Encoding.SetMaxListpackEntries, 128)
+}
+
+// GetSetMaxListpackValue defaults to 64.
+func (c *Config) GetSetMaxListpackValue() int {
+	return encodingLimit(c.Datastructure.Encoding.SetMaxListpackValue, 64)
+}
+
+// GetHashMaxListpackEntries defaults to 128.
+func (c *Config) GetHashMaxListpackEntries() int {
+	return encodingLimit(c.Datastructure.Encoding.HashMaxListpackEntries, 128)
+}
+
+// GetHashMaxListpackValue defaults to 64.
+func (c *Config) GetHashMaxListpackValue() int {
+	return encodingLimit(c.Datastructure.Encoding.HashMaxListpackValue, 64)
+}
+
+// GetListMaxListpackEntries defaults to 128.
+func (c *Config) GetListMaxListpackEntries() int {
+	return encodingLimit(c.Datastructure.Encoding.ListMaxListpackEntries, 128)
+}
+
+// GetListMaxListpackValue defaults to 64.
+func (c *Config) GetListMaxListpackValue() int {
+	return encodingLimit(c.Datastructure.Encoding.ListMaxListpackValue, 64)
+}
+
+// GetMaxMemory is the memory limit in bytes, 0 when unlimited or invalid.
+func (c *Config) GetMaxMemory() uint64 {
+	n, _ := ParseMemory(c.Memory.MaxMemory)
+	return n
+}
+
+func (c *Config) GetMaxMemoryPolicy() string {
+	if c.Memory.MaxMemoryPolicy == "" {
+		return "noeviction"
+	}
+	return strings.ToLower(c.Memory.MaxMemoryPolicy)
+}
+
+func (c *Config) GetMaxMemorySamples() int {
+	if c.Memory.MaxMemorySamples <= 0 {
+		return 5
+	}
+	return c.Memory.MaxMemorySamples
+}
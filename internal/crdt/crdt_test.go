@@ -0,0 +1,392 @@
+package crdt
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// memView is a View over plain maps.
+type memView struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+}
+
+func newMemView() *memView {
+	return &memView{strings: map[string]string{}, sets: map[string]map[string]bool{}, hashes: map[string]map[string]string{}}
+}
+
+func (v *memView) SetString(key, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.strings[key] = value
+}
+
+func (v *memView) DeleteString(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.strings, key)
+}
+
+func (v *memView) AddMember(key, member string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.sets[key] == nil {
+		v.sets[key] = map[string]bool{}
+	}
+	v.sets[key][member] = true
+}
+
+func (v *memView) RemoveMember(key, member string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.sets[key], member)
+	if len(v.sets[key]) == 0 {
+		delete(v.sets, key)
+	}
+}
+
+func (v *memView) SetField(key, field, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.hashes[key] == nil {
+		v.hashes[key] = map[string]string{}
+	}
+	v.hashes[key][field] = value
+}
+
+func (v *memView) DeleteField(key, field string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.hashes[key], field)
+	if len(v.hashes[key]) == 0 {
+		delete(v.hashes, key)
+	}
+}
+
+// dump renders the view canonically so two views can be compared.
+func (v *memView) dump() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var b strings.Builder
+	for _, k := range sortedKeys(v.strings) {
+		fmt.Fprintf(&b, "%s=%s\n", k, v.strings[k])
+	}
+	for _, k := range sortedKeys(v.sets) {
+		fmt.Fprintf(&b, "%s=%v\n", k, sortedKeys(v.sets[k]))
+	}
+	for _, k := range sortedKeys(v.hashes) {
+		fmt.Fprintf(&b, "%s=%v\n", k, v.hashes[k])
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func newTestNode(t *testing.T, id string, cfg Config) (*Node, *memView) {
+	t.Helper()
+	cfg.ID = id
+	view := newMemView()
+	n, err := New(cfg, view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n, view
+}
+
+// deliver merges every local write of from into to, as a peer link would.
+func deliver(from, to *Node) {
+	from.mu.Lock()
+	entries := append([]entry(nil), from.backlog...)
+	from.mu.Unlock()
+	for _, e := range entries {
+		deltas, _ := decodeDeltas(e.payload)
+		to.mu.Lock()
+		to.merge(e.payload, deltas)
+		to.mu.Unlock()
+	}
+}
+
+func TestClock(t *testing.T) {
+	now := int64(100)
+	c := NewClock("a")
+	c.now = func() int64 { return now }
+
+	t1 := c.Now()
+	t2 := c.Now()
+	if !t1.Less(t2) {
+		t.Fatalf("%v should be before %v", t1, t2)
+	}
+	// A peer far ahead pulls the clock forward.
+	c.Observe(Timestamp{Wall: 500, Logical: 7, Node: "b"})
+	if t3 := c.Now(); t3.Wall != 500 || t3.Logical != 8 {
+		t.Fatalf("after observing 500.7, got %v", t3)
+	}
+	// Physical time going backwards never reorders events.
+	now = 50
+	if t4 := c.Now(); t4.Wall != 500 || t4.Logical != 9 {
+		t.Fatalf("with the wall clock behind, got %v", t4)
+	}
+	if !(Timestamp{Wall: 1, Node: "a"}).Less(Timestamp{Wall: 1, Node: "b"}) {
+		t.Fatal("equal clocks should be ordered by node")
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	a, va := newTestNode(t, "a", Config{})
+	b, vb := newTestNode(t, "b", Config{})
+
+	// Strings: the later write wins everywhere.
+	a.Set("s", "from-a")
+	time.Sleep(time.Millisecond)
+	b.Set("s", "from-b")
+
+	// Counters: concurrent increments add up.
+	a.IncrBy("c", 5)
+	b.IncrBy("c", -2)
+	b.IncrBy("c", 10)
+
+	// Sets: an add beats a concurrent remove, which only covers the tags
+	// the removing node had seen.
+	a.SAdd("set", "x", "y")
+	deliver(a, b)
+	b.SRem("set", "x")
+	a.SRem("set", "x")
+	a.SAdd("set", "x")
+	a.SRem("set", "y")
+
+	// Hashes: last writer wins per field.
+	a.HSet("h", "f1", "a1", "f2", "a2")
+	time.Sleep(time.Millisecond)
+	b.HSet("h", "f2", "b2", "f3", "b3")
+
+	// Deleting a key keeps what the deleting node had not seen.
+	a.SAdd("d", "old")
+	deliver(a, b)
+	b.Delete("d")
+	a.SAdd("d", "new")
+
+	deliver(a, b)
+	deliver(b, a)
+	if va.dump() != vb.dump() {
+		t.Fatalf("nodes diverged:\n%s\nvs\n%s", va.dump(), vb.dump())
+	}
+	want := map[string]string{"s": "from-b", "c": "13"}
+	for k, v := range want {
+		if va.strings[k] != v {
+			t.Errorf("%s = %q, want %q", k, va.strings[k], v)
+		}
+	}
+	if !va.sets["set"]["x"] || va.sets["set"]["y"] {
+		t.Errorf("set = %v, want only x", va.sets["set"])
+	}
+	if h := va.hashes["h"]; h["f1"] != "a1" || h["f2"] != "b2" || h["f3"] != "b3" {
+		t.Errorf("hash = %v", h)
+	}
+	if d := va.sets["d"]; len(d) != 1 || !d["new"] {
+		t.Errorf("d = %v, want only new", d)
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	a, va := newTestNode(t, "a", Config{})
+	b, vb := newTestNode(t, "b", Config{})
+	a.IncrBy("c", 3)
+	deliver(a, b)
+	// b deletes what it saw while a keeps counting.
+	b.Delete("c")
+	a.IncrBy("c", 4)
+	deliver(a, b)
+	deliver(b, a)
+	if va.strings["c"] != "4" || vb.strings["c"] != "4" {
+		t.Fatalf("c = %q / %q, want 4", va.strings["c"], vb.strings["c"])
+	}
+
+	// A string holding an integer turns into a counter.
+	a.Set("n", "10")
+	if v, err := a.IncrBy("n", 1); err != nil || v != 11 {
+		t.Fatalf("INCR on a numeric string: %d %v", v, err)
+	}
+	a.Set("text", "abc")
+	if _, err := a.IncrBy("text", 1); err != ErrNotInteger {
+		t.Fatalf("INCR on text: %v", err)
+	}
+}
+
+// TestConvergence runs random writes on several nodes and delivers them in
+// random orders, with duplicates, checking every node ends up the same.
+func TestConvergence(t *testing.T) {
+	for seed := range uint64(20) {
+		rng := rand.New(rand.NewPCG(seed, seed))
+		var nodes []*Node
+		var views []*memView
+		for i := range 3 {
+			n, v := newTestNode(t, fmt.Sprintf("n%d", i), Config{BacklogSize: 1 << 20})
+			nodes = append(nodes, n)
+			views = append(views, v)
+		}
+		keys := []string{"k1", "k2", "k3"}
+		members := []string{"a", "b", "c"}
+		for range 300 {
+			n := nodes[rng.IntN(len(nodes))]
+			key := keys[rng.IntN(len(keys))]
+			m := members[rng.IntN(len(members))]
+			switch rng.IntN(8) {
+			case 0:
+				n.Set(key, m)
+			case 1:
+				n.IncrBy(key, int64(rng.IntN(10)-5))
+			case 2:
+				n.SAdd("set:"+key, m)
+			case 3:
+				n.SRem("set:"+key, m)
+			case 4:
+				n.HSet("hash:"+key, m, fmt.Sprint(rng.IntN(100)))
+			case 5:
+				n.HDel("hash:"+key, m)
+			case 6:
+				n.Delete(key, "set:"+key, "hash:"+key)
+			case 7:
+				// Partial, out of order exchange between two nodes.
+				deliverRandom(rng, n, nodes[rng.IntN(len(nodes))])
+			}
+		}
+		for _, from := range nodes {
+			for _, to := range nodes {
+				if from != to {
+					deliverRandom(rng, from, to)
+					deliver(from, to)
+				}
+			}
+		}
+		for i := 1; i < len(views); i++ {
+			if got, want := views[i].dump(), views[0].dump(); got != want {
+				t.Fatalf("seed %d: node %d diverged:\n%s\nvs\n%s", seed, i, got, want)
+			}
+		}
+	}
+}
+
+// deliverRandom merges a random subset of from's writes into to in a random
+// order.
+func deliverRandom(rng *rand.Rand, from, to *Node) {
+	if from == to {
+		return
+	}
+	from.mu.Lock()
+	entries := append([]entry(nil), from.backlog...)
+	from.mu.Unlock()
+	rng.Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+	for _, e := range entries[:rng.IntN(len(entries)+1)] {
+		deltas, _ := decodeDeltas(e.payload)
+		to.mu.Lock()
+		to.merge(e.payload, deltas)
+		to.mu.Unlock()
+	}
+}
+
+func TestLogReload(t *testing.T) {
+	dir := t.TempDir()
+	n, view := newTestNode(t, "a", Config{Dir: dir})
+	n.Set("s", "v")
+	n.IncrBy("c", 7)
+	n.SAdd("set", "x", "y")
+	n.SRem("set", "y")
+	n.HSet("h", "f", "v")
+	want := view.dump()
+	n.Close()
+
+	reloaded, view2 := newTestNode(t, "a", Config{Dir: dir})
+	if got := view2.dump(); got != want {
+		t.Fatalf("after reload:\n%s\nwant:\n%s", got, want)
+	}
+	// Writes after the reload still win over the recovered ones.
+	reloaded.Set("s", "new")
+	if view2.strings["s"] != "new" {
+		t.Fatalf("s = %q, want new", view2.strings["s"])
+	}
+}
+
+// serve answers CRDT.SYNC on a loopback listener the way the server does.
+func serve(t *testing.T, n *Node) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				v, err := resp.Decode(reader)
+				if err != nil || len(v.Items) != 4 || v.Items[0].Text != "CRDT.SYNC" {
+					return
+				}
+				n.ServePeer(conn, reader, []string{v.Items[1].Text, v.Items[2].Text, v.Items[3].Text})
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSync(t *testing.T) {
+	a, va := newTestNode(t, "a", Config{Timeout: time.Second})
+	b, vb := newTestNode(t, "b", Config{Timeout: time.Second, BacklogSize: 2})
+	a.cfg.Peers = []string{serve(t, b)}
+	b.cfg.Peers = []string{serve(t, a)}
+
+	a.Set("k", "a")
+	b.IncrBy("c", 2)
+	a.Start()
+	b.Start()
+	waitFor(t, "the nodes to converge", func() bool { return va.dump() == vb.dump() && va.dump() != "" })
+
+	a.SAdd("s", "x")
+	b.HSet("h", "f", "v")
+	waitFor(t, "streamed writes", func() bool { return va.dump() == vb.dump() && strings.Contains(va.dump(), "h=") })
+
+	// b writes more than its backlog holds while a is disconnected, so a
+	// falls back to a full resync.
+	a.mu.Lock()
+	a.links[0].conn.Close()
+	a.mu.Unlock()
+	for i := range 5 {
+		b.IncrBy("c", int64(i))
+	}
+	waitFor(t, "a to catch up", func() bool { return va.dump() == vb.dump() && strings.Contains(va.dump(), "c=12") })
+	waitFor(t, "the link to resume", func() bool {
+		st := a.Status()
+		return st.Links[0].State == linkConnected && st.Links[0].Seq == b.Status().Seq
+	})
+}
@@ -0,0 +1,66 @@
+package crdt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading. Node breaks ties, so
+// timestamps from different nodes never compare equal.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+	Node    string
+}
+
+func (t Timestamp) Less(o Timestamp) bool {
+	if t.Wall != o.Wall {
+		return t.Wall < o.Wall
+	}
+	if t.Logical != o.Logical {
+		return t.Logical < o.Logical
+	}
+	return t.Node < o.Node
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d.%s", t.Wall, t.Logical, t.Node)
+}
+
+// Clock issues timestamps that never go backwards and stay ahead of every
+// timestamp it has observed from peers, even when their clocks run fast.
+type Clock struct {
+	mu      sync.Mutex
+	node    string
+	wall    int64
+	logical uint32
+	now     func() int64
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{node: node, now: func() int64 { return time.Now().UnixNano() }}
+}
+
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.now(); pt > c.wall {
+		c.wall, c.logical = pt, 0
+	} else {
+		c.logical++
+	}
+	return Timestamp{Wall: c.wall, Logical: c.logical, Node: c.node}
+}
+
+// Observe moves the clock past a timestamp received from a peer.
+func (c *Clock) Observe(ts Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case ts.Wall > c.wall:
+		c.wall, c.logical = ts.Wall, ts.Logical
+	case ts.Wall == c.wall && ts.Logical > c.logical:
+		c.logical = ts.Logical
+	}
+}
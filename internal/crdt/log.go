@@ -0,0 +1,172 @@
+package crdt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	logFile = "crdt.aof"
+	// compactMin is the fewest records the log holds before it may be
+	// rewritten as the current state.
+	compactMin = 1000
+)
+
+func encodeDeltas(deltas []Delta) (string, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(deltas); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func decodeDeltas(payload string) ([]Delta, error) {
+	var deltas []Delta
+	if err := gob.NewDecoder(bytes.NewReader([]byte(payload))).Decode(&deltas); err != nil {
+		return nil, fmt.Errorf("crdt: bad delta payload: %w", err)
+	}
+	return deltas, nil
+}
+
+func encodeEntry(e entry) []byte {
+	return []byte(resp.Encode(resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: "DELTA"},
+		{Type: resp.BulkString, Text: strconv.FormatUint(e.seq, 10)},
+		{Type: resp.BulkString, Text: e.payload},
+	}}))
+}
+
+func logRecord(payload string) string {
+	return resp.Encode(resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: "MERGE"},
+		{Type: resp.BulkString, Text: payload},
+	}})
+}
+
+type deltaLog struct {
+	dir     string
+	file    *os.File
+	records int
+}
+
+func openDeltaLog(dir string, apply func([]Delta)) (*deltaLog, error) {
+	l := &deltaLog{dir: dir}
+	if dir == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, logFile)
+	if err := l.load(path, apply); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *deltaLog) load(path string, apply func([]Delta)) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	src := bytes.NewReader(data)
+	reader := bufio.NewReader(src)
+	for {
+		consumed := len(data) - src.Len() - reader.Buffered()
+		v, err := resp.Decode(reader)
+		if err != nil {
+			if consumed < len(data) {
+				log.Printf("crdt: dropping %d bytes of truncated log", len(data)-consumed)
+				return os.Truncate(path, int64(consumed))
+			}
+			return nil
+		}
+		if v.Type != resp.Array || len(v.Items) != 2 || v.Items[0].Text != "MERGE" {
+			return fmt.Errorf("crdt log %s: malformed record", path)
+		}
+		deltas, err := decodeDeltas(v.Items[1].Text)
+		if err != nil {
+			return err
+		}
+		apply(deltas)
+		l.records++
+	}
+}
+
+func (l *deltaLog) append(payload string, state *State) {
+	if l.file == nil {
+		return
+	}
+	if _, err := l.file.WriteString(logRecord(payload)); err != nil {
+		log.Printf("crdt: writing log: %v", err)
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		log.Printf("crdt: syncing log: %v", err)
+	}
+	l.records++
+	if l.records >= compactMin && l.records > 2*state.size() {
+		if err := l.rewrite(state); err != nil {
+			log.Printf("crdt: rewriting log: %v", err)
+		}
+	}
+}
+
+func (l *deltaLog) rewrite(state *State) error {
+	payload, err := encodeDeltas(state.deltas())
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, logFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(logRecord(payload)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	_ = l.file.Close()
+	if l.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return err
+	}
+	l.records = 1
+	return nil
+}
+
+func (l *deltaLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,388 @@
+// Package crdt implements active-active replication: every node accepts
+// writes, and conflicting writes are resolved by merging CRDTs, so all
+// nodes reach the same state once they have seen the same writes.
+package crdt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBacklogSize = 10000
+	DefaultTimeout     = 10 * time.Second
+)
+
+var (
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+)
+
+type Config struct {
+	ID string
+	// Peers are the client addresses of the other nodes. Each node only
+	// sends the writes it accepted itself, so every node lists every other.
+	Peers []string
+	// Auth is the password sent to peers.
+	Auth string
+	// Dir holds the delta log. Empty keeps the state in memory only.
+	Dir string
+	// BacklogSize is how many local writes are kept for peers to resume
+	// from after a disconnect; older ones need a full resync.
+	BacklogSize int
+	Timeout     time.Duration
+}
+
+type entry struct {
+	seq     uint64
+	payload string
+}
+
+type Node struct {
+	cfg   Config
+	clock *Clock
+	view  View
+
+	mu    sync.Mutex
+	state *State
+	// runID names this run of the node. seq numbers restart with it, so
+	// peers resume only against the same runID.
+	runID   string
+	seq     uint64
+	backlog []entry
+	subs    map[*subscriber]struct{}
+	links   []*link
+	log     *deltaLog
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New loads the delta log from cfg.Dir and shows the recovered state
+// through view.
+func New(cfg Config, view View) (*Node, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("crdt: node id is required")
+	}
+	if cfg.BacklogSize <= 0 {
+		cfg.BacklogSize = DefaultBacklogSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	n := &Node{
+		cfg:   cfg,
+		clock: NewClock(cfg.ID),
+		view:  view,
+		state: newState(),
+		runID: hex.EncodeToString(b),
+		subs:  map[*subscriber]struct{}{},
+		stop:  make(chan struct{}),
+	}
+	l, err := openDeltaLog(cfg.Dir, func(deltas []Delta) {
+		for _, d := range deltas {
+			n.clock.Observe(d.TS)
+			n.state.merge(d, view)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.log = l
+	return n, nil
+}
+
+func (n *Node) ID() string { return n.cfg.ID }
+
+// Start connects to every peer and keeps pulling their writes.
+func (n *Node) Start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, addr := range n.cfg.Peers {
+		l := &link{addr: addr, state: linkConnecting}
+		n.links = append(n.links, l)
+		n.wg.Add(1)
+		go n.runLink(l)
+	}
+	n.wg.Add(1)
+	go n.pingLoop()
+}
+
+func (n *Node) closed() bool {
+	select {
+	case <-n.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *Node) Close() {
+	n.mu.Lock()
+	if n.closed() {
+		n.mu.Unlock()
+		return
+	}
+	close(n.stop)
+	for _, l := range n.links {
+		l.close()
+	}
+	for s := range n.subs {
+		s.close()
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_ = n.log.close()
+}
+
+// Callers hold n.mu.
+func (n *Node) commit(deltas []Delta) {
+	if len(deltas) == 0 {
+		return
+	}
+	for _, d := range deltas {
+		n.state.merge(d, n.view)
+	}
+	payload, err := encodeDeltas(deltas)
+	if err != nil {
+		return
+	}
+	n.log.append(payload, n.state)
+	n.seq++
+	e := entry{seq: n.seq, payload: payload}
+	n.backlog = append(n.backlog, e)
+	if over := len(n.backlog) - n.cfg.BacklogSize; over > 0 {
+		n.backlog = append([]entry(nil), n.backlog[over:]...)
+	}
+	msg := encodeEntry(e)
+	for s := range n.subs {
+		s.send(msg)
+	}
+}
+
+// Callers hold n.mu.
+func (n *Node) merge(payload string, deltas []Delta) {
+	for _, d := range deltas {
+		n.clock.Observe(d.TS)
+		n.state.merge(d, n.view)
+	}
+	n.log.append(payload, n.state)
+}
+
+// Set writes a string register. A live counter under the same key is reset,
+// so the new value shows unless another node counts concurrently.
+func (n *Node) Set(key, value string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	deltas := []Delta{{Kind: KindRegister, Key: key, Value: value, TS: n.clock.Now()}}
+	if c := n.state.counters[key]; c != nil && c.visible() {
+		deltas = append(deltas, n.resetCounter(key, c))
+	}
+	n.commit(deltas)
+}
+
+func (n *Node) resetCounter(key string, c *counter) Delta {
+	base := make(map[string]PN, len(c.counts))
+	for node, pn := range c.counts {
+		base[node] = pn
+	}
+	return Delta{Kind: KindCounter, Key: key, Base: base}
+}
+
+// Delete removes keys of every type. Only what this node has seen is
+// removed: an element another node adds concurrently survives.
+func (n *Node) Delete(keys ...string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ts := n.clock.Now()
+	var deltas []Delta
+	deleted := 0
+	for _, key := range keys {
+		found := false
+		if r := n.state.registers[key]; r != nil && !r.Deleted {
+			deltas = append(deltas, Delta{Kind: KindRegister, Key: key, TS: ts, Deleted: true})
+			found = true
+		}
+		if c := n.state.counters[key]; c != nil && c.visible() {
+			deltas = append(deltas, n.resetCounter(key, c))
+			found = true
+		}
+		for member := range n.state.sets[key] {
+			if d, ok := n.removeMember(key, member); ok {
+				deltas = append(deltas, d)
+				found = true
+			}
+		}
+		for field, r := range n.state.hashes[key] {
+			if !r.Deleted {
+				deltas = append(deltas, Delta{Kind: KindHash, Key: key, Field: field, TS: ts, Deleted: true})
+				found = true
+			}
+		}
+		if found {
+			deleted++
+		}
+	}
+	n.commit(deltas)
+	return deleted
+}
+
+// IncrBy adds delta to a PN-counter and returns the new value. A string
+// holding an integer becomes a counter starting from that integer.
+func (n *Node) IncrBy(key string, delta int64) (int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := n.state.counters[key]
+	var cur, counted int64
+	var deltas []Delta
+	if c != nil && c.visible() {
+		cur = c.value()
+		counted = cur
+	} else if r := n.state.registers[key]; r != nil && !r.Deleted {
+		v, err := strconv.ParseInt(r.Value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		cur = v
+		deltas = append(deltas, Delta{Kind: KindRegister, Key: key, TS: n.clock.Now(), Deleted: true})
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	target := cur + delta
+
+	var own PN
+	if c != nil {
+		own = c.counts[n.cfg.ID]
+	}
+	if add := target - counted; add >= 0 {
+		own.P += add
+	} else {
+		own.N -= add
+	}
+	deltas = append(deltas, Delta{Kind: KindCounter, Key: key, Counts: map[string]PN{n.cfg.ID: own}})
+	n.commit(deltas)
+	return target, nil
+}
+
+// SAdd adds members to an observed-remove set under fresh tags.
+func (n *Node) SAdd(key string, members ...string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tag := n.clock.Now().String()
+	var deltas []Delta
+	seen := map[string]bool{}
+	for _, m := range members {
+		if seen[m] || n.state.memberPresent(key, m) {
+			continue
+		}
+		seen[m] = true
+		deltas = append(deltas, Delta{Kind: KindSet, Key: key, Field: m, Adds: []string{tag}})
+	}
+	n.commit(deltas)
+	return len(deltas)
+}
+
+// SRem removes the tags of members this node has observed.
+func (n *Node) SRem(key string, members ...string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var deltas []Delta
+	seen := map[string]bool{}
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		if d, ok := n.removeMember(key, m); ok {
+			deltas = append(deltas, d)
+		}
+	}
+	n.commit(deltas)
+	return len(deltas)
+}
+
+// Callers hold n.mu.
+func (n *Node) removeMember(key, member string) (Delta, bool) {
+	t := n.state.sets[key][member]
+	if t == nil || !t.present() {
+		return Delta{}, false
+	}
+	removes := make([]string, 0, len(t.adds))
+	for tag := range t.adds {
+		removes = append(removes, tag)
+	}
+	return Delta{Kind: KindSet, Key: key, Field: member, Removes: removes}, true
+}
+
+// HSet writes hash fields, each a last-writer-wins register.
+func (n *Node) HSet(key string, fieldValues ...string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var deltas []Delta
+	added := map[string]bool{}
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		field := fieldValues[i]
+		if !n.state.fieldPresent(key, field) {
+			added[field] = true
+		}
+		// A fresh timestamp per field lets a repeated field keep its last
+		// value.
+		deltas = append(deltas, Delta{Kind: KindHash, Key: key, Field: field, Value: fieldValues[i+1], TS: n.clock.Now()})
+	}
+	n.commit(deltas)
+	return len(added)
+}
+
+func (n *Node) HDel(key string, fields ...string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ts := n.clock.Now()
+	var deltas []Delta
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if seen[field] || !n.state.fieldPresent(key, field) {
+			continue
+		}
+		seen[field] = true
+		deltas = append(deltas, Delta{Kind: KindHash, Key: key, Field: field, TS: ts, Deleted: true})
+	}
+	n.commit(deltas)
+	return len(deltas)
+}
+
+type LinkStatus struct {
+	Addr   string
+	State  string
+	RunID  string
+	Seq    uint64
+	LastIO time.Time
+}
+
+type Status struct {
+	ID    string
+	RunID string
+	Seq   uint64
+	Size  int
+	Peers int
+	Links []LinkStatus
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := Status{ID: n.cfg.ID, RunID: n.runID, Seq: n.seq, Size: n.state.size(), Peers: len(n.subs)}
+	for _, l := range n.links {
+		st.Links = append(st.Links, LinkStatus{Addr: l.addr, State: l.state, RunID: l.runID, Seq: l.seq, LastIO: l.lastIO})
+	}
+	return st
+}
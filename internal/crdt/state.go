@@ -0,0 +1,250 @@
+package crdt
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+const (
+	KindRegister = "register"
+	KindCounter  = "counter"
+	KindSet      = "set"
+	KindHash     = "hash"
+)
+
+// PN holds the increments and decrements one node made to a counter.
+type PN struct {
+	P, N int64
+}
+
+// Delta is a fragment of CRDT state. Merging one is idempotent and
+// commutative.
+type Delta struct {
+	Kind string
+	Key  string
+	// Field is the hash field or the set member.
+	Field string
+	// Value, TS and Deleted describe a string register or a hash field.
+	Value   string
+	TS      Timestamp
+	Deleted bool
+	// Counts are per-node totals of a counter. Base is what a delete had
+	// observed; only counts above it make up the value.
+	Counts map[string]PN
+	Base   map[string]PN
+	// Adds and Removes are the tags of a set member. The member is present
+	// while some added tag has not been removed.
+	Adds    []string
+	Removes []string
+}
+
+// View receives the visible result of every merge, so the regular stores
+// can serve reads.
+type View interface {
+	SetString(key, value string)
+	DeleteString(key string)
+	AddMember(key, member string)
+	RemoveMember(key, member string)
+	SetField(key, field, value string)
+	DeleteField(key, field string)
+}
+
+type register struct {
+	Value   string
+	TS      Timestamp
+	Deleted bool
+}
+
+func (r *register) merge(value string, ts Timestamp, deleted bool) {
+	if r.TS.Less(ts) {
+		r.Value, r.TS, r.Deleted = value, ts, deleted
+	}
+}
+
+type counter struct {
+	counts map[string]PN
+	base   map[string]PN
+}
+
+func (c *counter) value() int64 {
+	var v int64
+	for node, pn := range c.counts {
+		b := c.base[node]
+		v += (pn.P - b.P) - (pn.N - b.N)
+	}
+	return v
+}
+
+func (c *counter) visible() bool {
+	for node, pn := range c.counts {
+		if pn != c.base[node] {
+			return true
+		}
+	}
+	return false
+}
+
+type tags struct {
+	adds    map[string]struct{}
+	removes map[string]struct{}
+}
+
+func (t *tags) present() bool {
+	for tag := range t.adds {
+		if _, ok := t.removes[tag]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+type State struct {
+	registers map[string]*register
+	counters  map[string]*counter
+	sets      map[string]map[string]*tags
+	hashes    map[string]map[string]*register
+}
+
+func newState() *State {
+	return &State{
+		registers: map[string]*register{},
+		counters:  map[string]*counter{},
+		sets:      map[string]map[string]*tags{},
+		hashes:    map[string]map[string]*register{},
+	}
+}
+
+func maxPN(dst map[string]PN, src map[string]PN) {
+	for node, pn := range src {
+		cur := dst[node]
+		dst[node] = PN{P: max(cur.P, pn.P), N: max(cur.N, pn.N)}
+	}
+}
+
+func (s *State) merge(d Delta, view View) {
+	switch d.Kind {
+	case KindRegister:
+		r := s.registers[d.Key]
+		if r == nil {
+			r = &register{Deleted: true}
+			s.registers[d.Key] = r
+		}
+		r.merge(d.Value, d.TS, d.Deleted)
+		s.showString(d.Key, view)
+	case KindCounter:
+		c := s.counters[d.Key]
+		if c == nil {
+			c = &counter{counts: map[string]PN{}, base: map[string]PN{}}
+			s.counters[d.Key] = c
+		}
+		maxPN(c.counts, d.Counts)
+		maxPN(c.base, d.Base)
+		s.showString(d.Key, view)
+	case KindSet:
+		members := s.sets[d.Key]
+		if members == nil {
+			members = map[string]*tags{}
+			s.sets[d.Key] = members
+		}
+		t := members[d.Field]
+		if t == nil {
+			t = &tags{adds: map[string]struct{}{}, removes: map[string]struct{}{}}
+			members[d.Field] = t
+		}
+		for _, tag := range d.Adds {
+			t.adds[tag] = struct{}{}
+		}
+		for _, tag := range d.Removes {
+			t.removes[tag] = struct{}{}
+		}
+		if t.present() {
+			view.AddMember(d.Key, d.Field)
+		} else {
+			view.RemoveMember(d.Key, d.Field)
+		}
+	case KindHash:
+		fields := s.hashes[d.Key]
+		if fields == nil {
+			fields = map[string]*register{}
+			s.hashes[d.Key] = fields
+		}
+		r := fields[d.Field]
+		if r == nil {
+			r = &register{Deleted: true}
+			fields[d.Field] = r
+		}
+		r.merge(d.Value, d.TS, d.Deleted)
+		if r.Deleted {
+			view.DeleteField(d.Key, d.Field)
+		} else {
+			view.SetField(d.Key, d.Field, r.Value)
+		}
+	}
+}
+
+func (s *State) stringValue(key string) (string, bool) {
+	if c := s.counters[key]; c != nil && c.visible() {
+		return strconv.FormatInt(c.value(), 10), true
+	}
+	if r := s.registers[key]; r != nil && !r.Deleted {
+		return r.Value, true
+	}
+	return "", false
+}
+
+func (s *State) showString(key string, view View) {
+	if v, ok := s.stringValue(key); ok {
+		view.SetString(key, v)
+	} else {
+		view.DeleteString(key)
+	}
+}
+
+func (s *State) memberPresent(key, member string) bool {
+	t := s.sets[key][member]
+	return t != nil && t.present()
+}
+
+func (s *State) fieldPresent(key, field string) bool {
+	r := s.hashes[key][field]
+	return r != nil && !r.Deleted
+}
+
+func (s *State) deltas() []Delta {
+	var out []Delta
+	for key, r := range s.registers {
+		out = append(out, Delta{Kind: KindRegister, Key: key, Value: r.Value, TS: r.TS, Deleted: r.Deleted})
+	}
+	for key, c := range s.counters {
+		out = append(out, Delta{Kind: KindCounter, Key: key, Counts: maps.Clone(c.counts), Base: maps.Clone(c.base)})
+	}
+	for key, members := range s.sets {
+		for member, t := range members {
+			out = append(out, Delta{
+				Kind:    KindSet,
+				Key:     key,
+				Field:   member,
+				Adds:    slices.Sorted(maps.Keys(t.adds)),
+				Removes: slices.Sorted(maps.Keys(t.removes)),
+			})
+		}
+	}
+	for key, fields := range s.hashes {
+		for field, r := range fields {
+			out = append(out, Delta{Kind: KindHash, Key: key, Field: field, Value: r.Value, TS: r.TS, Deleted: r.Deleted})
+		}
+	}
+	return out
+}
+
+func (s *State) size() int {
+	n := len(s.registers) + len(s.counters)
+	for _, members := range s.sets {
+		n += len(members)
+	}
+	for _, fields := range s.hashes {
+		n += len(fields)
+	}
+	return n
+}
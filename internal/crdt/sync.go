@@ -0,0 +1,299 @@
+package crdt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	linkConnecting = "connect"
+	linkConnected  = "connected"
+
+	reconnectDelay   = time.Second
+	subscriberBuffer = 4096
+)
+
+var pingMessage = []byte(resp.Encode(resp.Value{Type: resp.Array, Items: []resp.Value{{Type: resp.BulkString, Text: "PING"}}}))
+
+type subscriber struct {
+	conn      net.Conn
+	ch        chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *subscriber) send(data []byte) {
+	select {
+	case s.ch <- data:
+	default:
+		// A peer that cannot keep up is dropped; it reconnects and
+		// resumes from the backlog.
+		s.close()
+	}
+}
+
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
+
+func (s *subscriber) writeLoop() {
+	for {
+		select {
+		case data := <-s.ch:
+			if _, err := s.conn.Write(data); err != nil {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ServePeer answers CRDT.SYNC <node-id> <run-id> <seq> on conn and streams
+// new writes until the peer disconnects.
+func (n *Node) ServePeer(conn net.Conn, reader *bufio.Reader, args []string) {
+	_ = conn.SetDeadline(time.Time{})
+	s := &subscriber{conn: conn, ch: make(chan []byte, subscriberBuffer), done: make(chan struct{})}
+	header, err := n.attach(s, args)
+	if err != nil {
+		_, _ = conn.Write([]byte(resp.Encode(resp.Value{Type: resp.Error, Text: err.Error()})))
+		return
+	}
+	defer n.detach(s)
+	if _, err := conn.Write(header); err != nil {
+		return
+	}
+	go s.writeLoop()
+	for {
+		if _, err := resp.Decode(reader); err != nil {
+			return
+		}
+	}
+}
+
+func (n *Node) attach(s *subscriber, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("ERR wrong number of arguments for 'crdt.sync' command")
+	}
+	runID := args[1]
+	seq, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR invalid sequence number")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return nil, errors.New("ERR node is shutting down")
+	}
+	first := n.seq - uint64(len(n.backlog)) + 1
+	var header []byte
+	if runID == n.runID && seq <= n.seq && seq+1 >= first {
+		header = []byte("+CONTINUE\r\n")
+		for _, e := range n.backlog[seq+1-first:] {
+			header = append(header, encodeEntry(e)...)
+		}
+	} else {
+		payload, err := encodeDeltas(n.state.deltas())
+		if err != nil {
+			return nil, err
+		}
+		header = fmt.Appendf(nil, "+FULLSYNC %s %d\r\n", n.runID, n.seq)
+		header = append(header, resp.Encode(resp.Value{Type: resp.BulkString, Text: payload})...)
+		log.Printf("crdt: full sync for peer %s", args[0])
+	}
+	n.subs[s] = struct{}{}
+	return header, nil
+}
+
+func (n *Node) detach(s *subscriber) {
+	s.close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.subs, s)
+}
+
+func (n *Node) pingLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.Timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		for s := range n.subs {
+			s.send(pingMessage)
+		}
+		n.mu.Unlock()
+	}
+}
+
+type link struct {
+	addr    string
+	state   string
+	runID   string
+	seq     uint64
+	lastIO  time.Time
+	conn    net.Conn
+	stopped bool
+}
+
+func (l *link) close() {
+	l.stopped = true
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
+
+func (n *Node) runLink(l *link) {
+	defer n.wg.Done()
+	for {
+		err := n.pull(l)
+		n.mu.Lock()
+		stopped := l.stopped
+		l.state = linkConnecting
+		l.conn = nil
+		n.mu.Unlock()
+		if stopped {
+			return
+		}
+		log.Printf("crdt: link to %s lost: %v", l.addr, err)
+		select {
+		case <-n.stop:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (n *Node) pull(l *link) error {
+	conn, err := net.DialTimeout("tcp", l.addr, n.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	n.mu.Lock()
+	if l.stopped {
+		n.mu.Unlock()
+		return nil
+	}
+	l.conn = conn
+	runID, seq := l.runID, l.seq
+	n.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	read := func() (resp.Value, error) {
+		_ = conn.SetReadDeadline(time.Now().Add(n.cfg.Timeout))
+		v, err := resp.Decode(reader)
+		if err == nil && v.Type == resp.Error {
+			err = errors.New(v.Text)
+		}
+		return v, err
+	}
+	if n.cfg.Auth != "" {
+		if err := writeCommand(conn, "AUTH", n.cfg.Auth); err != nil {
+			return err
+		}
+		if _, err := read(); err != nil {
+			return err
+		}
+	}
+	if err := writeCommand(conn, "CRDT.SYNC", n.cfg.ID, runID, strconv.FormatUint(seq, 10)); err != nil {
+		return err
+	}
+	v, err := read()
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(v.Text)
+	switch {
+	case len(fields) == 1 && fields[0] == "CONTINUE":
+	case len(fields) == 3 && fields[0] == "FULLSYNC":
+		seq, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLSYNC reply %q", v.Text)
+		}
+		payload, err := read()
+		if err != nil {
+			return err
+		}
+		deltas, err := decodeDeltas(payload.Text)
+		if err != nil {
+			return err
+		}
+		n.mu.Lock()
+		n.merge(payload.Text, deltas)
+		l.runID, l.seq = fields[1], seq
+		n.mu.Unlock()
+	default:
+		return fmt.Errorf("unexpected CRDT.SYNC reply %q", v.Text)
+	}
+
+	n.mu.Lock()
+	l.state = linkConnected
+	l.lastIO = time.Now()
+	n.mu.Unlock()
+
+	for {
+		v, err := read()
+		if err != nil {
+			return err
+		}
+		if v.Type != resp.Array || len(v.Items) == 0 {
+			return errors.New("unexpected message from peer")
+		}
+		switch v.Items[0].Text {
+		case "PING":
+			n.mu.Lock()
+			l.lastIO = time.Now()
+			n.mu.Unlock()
+		case "DELTA":
+			if len(v.Items) != 3 {
+				return errors.New("malformed DELTA")
+			}
+			seq, err := strconv.ParseUint(v.Items[1].Text, 10, 64)
+			if err != nil {
+				return err
+			}
+			deltas, err := decodeDeltas(v.Items[2].Text)
+			if err != nil {
+				return err
+			}
+			n.mu.Lock()
+			if seq != l.seq+1 {
+				n.mu.Unlock()
+				return fmt.Errorf("expected write %d from peer, got %d", l.seq+1, seq)
+			}
+			n.merge(v.Items[2].Text, deltas)
+			l.seq = seq
+			l.lastIO = time.Now()
+			n.mu.Unlock()
+		}
+	}
+}
+
+func writeCommand(conn net.Conn, parts ...string) error {
+	items := make([]resp.Value, len(parts))
+	for i, p := range parts {
+		items[i] = resp.Value{Type: resp.BulkString, Text: p}
+	}
+	_, err := conn.Write([]byte(resp.Encode(resp.Value{Type: resp.Array, Items: items})))
+	return err
+}
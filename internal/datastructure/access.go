@@ -0,0 +1,169 @@
+package datastructure
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// LFUInitVal is the access counter of a key never used since it was loaded,
+// so new keys are not evicted before they had a chance to be used again.
+const LFUInitVal = 5
+
+type accessInfo struct {
+	// at is the unix time in milliseconds of the last access.
+	at int64
+	// freq is a logarithmic access counter, as in Redis LFU.
+	freq uint8
+}
+
+// AccessTable tracks when each key was last used and how often, for LRU and
+// LFU eviction. The records are kept beside the keys in their store, so they
+// share its locks and go away with the keys.
+type AccessTable struct {
+	created int64
+	now     func() time.Time
+}
+
+func CreateAccessTable() *AccessTable {
+	return &AccessTable{
+		created: time.Now().UnixMilli(),
+		now:     time.Now,
+	}
+}
+
+// Tracked is a store whose keys an AccessTable can track.
+type Tracked interface {
+	// accessOf returns the records of key with the store's read lock held,
+	// and the function that releases it; nil when key does not exist.
+	accessOf(key string) (*accessMap, func())
+}
+
+// accessMap holds the access records of the keys beside it. Readers update
+// it under mu while holding the read lock of the keys; writers holding the
+// write lock need not take mu.
+type accessMap struct {
+	mu    sync.Mutex
+	infos map[string]accessInfo
+}
+
+func (m *accessMap) update(key string, init accessInfo, fn func(info *accessInfo)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.infos == nil {
+		m.infos = make(map[string]accessInfo)
+	}
+	info, ok := m.infos[key]
+	if !ok {
+		info = init
+	}
+	fn(&info)
+	m.infos[key] = info
+}
+
+func (m *accessMap) get(key string) (accessInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, ok := m.infos[key]
+	return info, ok
+}
+
+func (m *accessMap) forget(key string) {
+	delete(m.infos, key)
+}
+
+func (m *accessMap) reset() {
+	m.infos = nil
+}
+
+// Touch records an access to key in store, and reports whether store holds
+// key. Missing keys are not recorded.
+func (t *AccessTable) Touch(store Tracked, key string) bool {
+	now := t.now().UnixMilli()
+	return t.update(store, key, t.unused(), func(info *accessInfo) {
+		info.freq = logIncr(decay(*info, now))
+		info.at = now
+	})
+}
+
+// Idle is how long ago key was last used. Keys not used since the table was
+// created count from then.
+func (t *AccessTable) Idle(store Tracked, key string) time.Duration {
+	info := t.info(store, key)
+	return time.Duration(t.now().UnixMilli()-info.at) * time.Millisecond
+}
+
+// Freq is the access counter of key after decay.
+func (t *AccessTable) Freq(store Tracked, key string) uint8 {
+	return decay(t.info(store, key), t.now().UnixMilli())
+}
+
+// SetIdle records key as last used idle ago, as RESTORE IDLETIME does.
+func (t *AccessTable) SetIdle(store Tracked, key string, idle time.Duration) {
+	at := t.now().Add(-idle).UnixMilli()
+	t.update(store, key, t.unused(), func(info *accessInfo) { info.at = at })
+}
+
+// SetFreq sets the access counter of key, as RESTORE FREQ does.
+func (t *AccessTable) SetFreq(store Tracked, key string, freq uint8) {
+	init := accessInfo{at: t.now().UnixMilli(), freq: LFUInitVal}
+	t.update(store, key, init, func(info *accessInfo) { info.freq = freq })
+}
+
+func (t *AccessTable) update(store Tracked, key string, init accessInfo, fn func(info *accessInfo)) bool {
+	m, unlock := store.accessOf(key)
+	if m == nil {
+		return false
+	}
+	defer unlock()
+	m.update(key, init, fn)
+	return true
+}
+
+func (t *AccessTable) info(store Tracked, key string) accessInfo {
+	if m, unlock := store.accessOf(key); m != nil {
+		defer unlock()
+		if info, ok := m.get(key); ok {
+			return info
+		}
+	}
+	return t.unused()
+}
+
+// unused is what is known of a key never used since the table was created.
+func (t *AccessTable) unused() accessInfo {
+	return accessInfo{at: t.created, freq: LFUInitVal}
+}
+
+func (segs segments[V]) accessOf(key string) (*accessMap, func()) {
+	seg := segs.of(key)
+	seg.rlock()
+	if _, ok := seg.items[key]; !ok {
+		seg.runlock()
+		return nil, nil
+	}
+	return &seg.access, seg.runlock
+}
+
+func logIncr(freq uint8) uint8 {
+	if freq == 255 {
+		return freq
+	}
+	base := max(int(freq)-LFUInitVal, 0)
+	if rand.Float64() < 1/float64(base*GetLFULogFactor()+1) {
+		freq++
+	}
+	return freq
+}
+
+func decay(info accessInfo, now int64) uint8 {
+	period := GetLFUDecayTime()
+	if period <= 0 {
+		return info.freq
+	}
+	periods := (now - info.at) / period.Milliseconds()
+	if periods >= int64(info.freq) {
+		return 0
+	}
+	return info.freq - uint8(periods)
+}
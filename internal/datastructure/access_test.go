@@ -0,0 +1,92 @@
+package datastructure
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessTable(t *testing.T) {
+	now := time.Unix(1000, 0)
+	table := CreateAccessTable()
+	table.created = now.UnixMilli()
+	table.now = func() time.Time { return now }
+	d := CreateDict()
+	for _, key := range []string{"a", "b", "hot", "restored", "counted"} {
+		d.Set(key, "v", 0)
+	}
+
+	if freq := table.Freq(d, "a"); freq != LFUInitVal {
+		t.Errorf("Expected unused key to have freq %d, got %d", LFUInitVal, freq)
+	}
+	table.Touch(d, "a")
+	now = now.Add(10 * time.Second)
+	table.Touch(d, "b")
+	if idle := table.Idle(d, "a"); idle != 10*time.Second {
+		t.Errorf("Expected a idle for 10s, got %v", idle)
+	}
+	if idle := table.Idle(d, "b"); idle != 0 {
+		t.Errorf("Expected b idle for 0s, got %v", idle)
+	}
+	if idle := table.Idle(d, "hot"); idle != 10*time.Second {
+		t.Errorf("Expected unused key idle since creation, got %v", idle)
+	}
+	if table.Touch(d, "missing") {
+		t.Error("Expected touching a missing key to report it missing")
+	}
+
+	for range 1000 {
+		table.Touch(d, "hot")
+	}
+	hot := table.Freq(d, "hot")
+	if hot <= LFUInitVal+5 || hot == 255 {
+		t.Errorf("Expected logarithmic growth after 1000 hits, got %d", hot)
+	}
+
+	now = now.Add(3 * time.Minute)
+	if freq := table.Freq(d, "hot"); freq != hot-3 {
+		t.Errorf("Expected freq to decay by one per idle minute to %d, got %d", hot-3, freq)
+	}
+	now = now.Add(24 * time.Hour)
+	if freq := table.Freq(d, "hot"); freq != 0 {
+		t.Errorf("Expected freq to decay to 0, got %d", freq)
+	}
+
+	d.Delete("a")
+	d.Expire("b", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	d.Get("b")
+	d.Set("a", "v", 0)
+	d.Set("b", "v", 0)
+	if idle, freq := table.Idle(d, "a"), table.Freq(d, "b"); idle != now.Sub(time.Unix(1000, 0)) || freq != 0 {
+		t.Errorf("Expected deleted and expired keys forgotten, got idle %v and freq %d", idle, freq)
+	}
+
+	table.SetIdle(d, "restored", time.Minute)
+	if idle, freq := table.Idle(d, "restored"), table.Freq(d, "restored"); idle != time.Minute || freq != LFUInitVal-1 {
+		t.Errorf("Expected restored idle for a minute at freq %d, got %v and %d", LFUInitVal-1, idle, freq)
+	}
+	table.SetFreq(d, "counted", 42)
+	if idle, freq := table.Idle(d, "counted"), table.Freq(d, "counted"); idle != 0 || freq != 42 {
+		t.Errorf("Expected counted just used at freq 42, got %v and %d", idle, freq)
+	}
+}
+
+func TestSample(t *testing.T) {
+	d := CreateDict()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		d.Set(key, "v", 0)
+	}
+	d.Set("v1", "v", time.Hour)
+	d.Set("v2", "v", time.Minute)
+
+	if keys := d.Sample(3); len(keys) != 3 {
+		t.Errorf("Expected 3 sampled keys, got %v", keys)
+	}
+	if keys := d.Sample(100); len(keys) != 6 {
+		t.Errorf("Expected every key when sampling more than exist, got %v", keys)
+	}
+	volatile := d.SampleVolatile(10)
+	if len(volatile) != 2 || volatile["v1"].IsZero() || volatile["v2"].IsZero() {
+		t.Errorf("Expected only keys with a TTL, got %v", volatile)
+	}
+}
@@ -0,0 +1,370 @@
+package datastructure
+
+import (
+	"math"
+	"math/bits"
+)
+
+const MaxBitOffset = 1<<32 - 1
+
+type BitRange struct {
+	Start  int64
+	End    int64
+	HasEnd bool
+	Bit    bool
+}
+
+type BitFieldKind int
+
+const (
+	BitFieldGet BitFieldKind = iota
+	BitFieldSet
+	BitFieldIncr
+)
+
+type BitFieldOverflow int
+
+const (
+	OverflowWrap BitFieldOverflow = iota
+	OverflowSat
+	OverflowFail
+)
+
+type BitFieldOp struct {
+	Kind     BitFieldKind
+	Signed   bool
+	Bits     uint
+	Offset   uint64
+	Value    int64
+	Overflow BitFieldOverflow
+}
+
+type BitFieldResult struct {
+	Value int64
+	Nil   bool
+}
+
+func (d *Dict) liveBytes(key string) ([]byte, Item, bool) {
+	item, exist := d.segs.get(key)
+	if !exist || d.isExpired(item) {
+		return nil, Item{}, false
+	}
+	return []byte(item.Value), item, true
+}
+
+func growBytes(buf []byte, size uint64) []byte {
+	if uint64(len(buf)) >= size {
+		return buf
+	}
+	grown := make([]byte, size)
+	copy(grown, buf)
+	return grown
+}
+
+func (d *Dict) SetBit(key string, offset uint64, bit byte) byte {
+	defer d.segs.lock(key)()
+
+	buf, item, _ := d.liveBytes(key)
+	buf = growBytes(buf, offset>>3+1)
+
+	idx := offset >> 3
+	mask := byte(1 << (7 - offset&7))
+	old := byte(0)
+	if buf[idx]&mask != 0 {
+		old = 1
+	}
+	if bit == 1 {
+		buf[idx] |= mask
+	} else {
+		buf[idx] &^= mask
+	}
+
+	item.Value = string(buf)
+	d.put(key, item)
+	return old
+}
+
+func (d *Dict) GetBit(key string, offset uint64) byte {
+	val, ok := d.Get(key)
+	if !ok || offset>>3 >= uint64(len(val)) {
+		return 0
+	}
+	if val[offset>>3]&byte(1<<(7-offset&7)) != 0 {
+		return 1
+	}
+	return 0
+}
+
+func normalizeBitRange(r *BitRange, length int64) (int64, int64, bool) {
+	total := length
+	if r.Bit {
+		total = length * 8
+	}
+	start, end := r.Start, total-1
+	if r.HasEnd {
+		end = r.End
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if !r.Bit {
+		return start * 8, end*8 + 7, true
+	}
+	return start, end, true
+}
+
+func (d *Dict) BitCount(key string, r *BitRange) int64 {
+	val, ok := d.Get(key)
+	if !ok || len(val) == 0 {
+		return 0
+	}
+
+	first, last := int64(0), int64(len(val))*8-1
+	if r != nil {
+		var valid bool
+		if first, last, valid = normalizeBitRange(r, int64(len(val))); !valid {
+			return 0
+		}
+	}
+
+	var count int64
+	for pos := first; pos <= last; {
+		if pos&7 == 0 && pos+7 <= last {
+			count += int64(bits.OnesCount8(val[pos>>3]))
+			pos += 8
+			continue
+		}
+		if val[pos>>3]&byte(1<<(7-pos&7)) != 0 {
+			count++
+		}
+		pos++
+	}
+	return count
+}
+
+func (d *Dict) BitPos(key string, bit byte, r *BitRange) int64 {
+	val, ok := d.Get(key)
+	if !ok || len(val) == 0 {
+		if bit == 1 {
+			return -1
+		}
+		return 0
+	}
+
+	first, last := int64(0), int64(len(val))*8-1
+	if r != nil {
+		var valid bool
+		if first, last, valid = normalizeBitRange(r, int64(len(val))); !valid {
+			return -1
+		}
+	}
+
+	skip := byte(0xff)
+	if bit == 1 {
+		skip = 0
+	}
+	for pos := first; pos <= last; {
+		if pos&7 == 0 && pos+7 <= last && val[pos>>3] == skip {
+			pos += 8
+			continue
+		}
+		set := val[pos>>3]&byte(1<<(7-pos&7)) != 0
+		if set == (bit == 1) {
+			return pos
+		}
+		pos++
+	}
+
+	if bit == 0 && (r == nil || !r.HasEnd) {
+		return last + 1
+	}
+	return -1
+}
+
+func (d *Dict) BitOp(op string, dest string, keys ...string) int {
+	defer d.segs.lock(append([]string{dest}, keys...)...)()
+
+	srcs := make([][]byte, len(keys))
+	maxLen := 0
+	for i, key := range keys {
+		buf, _, _ := d.liveBytes(key)
+		srcs[i] = buf
+		if len(buf) > maxLen {
+			maxLen = len(buf)
+		}
+	}
+
+	if maxLen == 0 {
+		d.remove(dest)
+		return 0
+	}
+
+	out := make([]byte, maxLen)
+	for i := range out {
+		var b byte
+		for j, src := range srcs {
+			var s byte
+			if i < len(src) {
+				s = src[i]
+			}
+			if j == 0 {
+				b = s
+				continue
+			}
+			switch op {
+			case "AND":
+				b &= s
+			case "OR":
+				b |= s
+			case "XOR":
+				b ^= s
+			}
+		}
+		if op == "NOT" {
+			b = ^b
+		}
+		out[i] = b
+	}
+
+	d.put(dest, Item{Value: string(out)})
+	return maxLen
+}
+
+func (d *Dict) BitField(key string, ops []BitFieldOp) []BitFieldResult {
+	defer d.segs.lock(key)()
+
+	buf, item, _ := d.liveBytes(key)
+
+	results := make([]BitFieldResult, 0, len(ops))
+	dirty := false
+	for _, op := range ops {
+		old := readBitField(buf, op.Offset, op.Bits, op.Signed)
+		if op.Kind == BitFieldGet {
+			results = append(results, BitFieldResult{Value: old})
+			continue
+		}
+
+		var next int64
+		var overflow bool
+		if op.Kind == BitFieldSet {
+			next, overflow = bitFieldClamp(op.Value, 0, op.Bits, op.Signed, op.Overflow)
+		} else {
+			next, overflow = bitFieldClamp(old, op.Value, op.Bits, op.Signed, op.Overflow)
+		}
+		if overflow && op.Overflow == OverflowFail {
+			results = append(results, BitFieldResult{Nil: true})
+			continue
+		}
+
+		buf = growBytes(buf, (op.Offset+uint64(op.Bits)+7)>>3)
+		writeBitField(buf, op.Offset, op.Bits, uint64(next))
+		dirty = true
+
+		if op.Kind == BitFieldSet {
+			results = append(results, BitFieldResult{Value: old})
+		} else {
+			results = append(results, BitFieldResult{Value: next})
+		}
+	}
+
+	if dirty {
+		item.Value = string(buf)
+		d.put(key, item)
+	}
+	return results
+}
+
+func readBitField(buf []byte, offset uint64, width uint, signed bool) int64 {
+	var v uint64
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		v <<= 1
+		if pos>>3 < uint64(len(buf)) && buf[pos>>3]&byte(1<<(7-pos&7)) != 0 {
+			v |= 1
+		}
+	}
+	if signed && width < 64 && v&(1<<(width-1)) != 0 {
+		v |= math.MaxUint64 << width
+	}
+	return int64(v)
+}
+
+func writeBitField(buf []byte, offset uint64, width uint, v uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		mask := byte(1 << (7 - pos&7))
+		if v>>(uint64(width)-1-i)&1 == 1 {
+			buf[pos>>3] |= mask
+		} else {
+			buf[pos>>3] &^= mask
+		}
+	}
+}
+
+func bitFieldClamp(value, incr int64, width uint, signed bool, mode BitFieldOverflow) (int64, bool) {
+	if signed {
+		max := int64(math.MaxInt64)
+		if width < 64 {
+			max = 1<<(width-1) - 1
+		}
+		min := -max - 1
+		sum := value + incr
+		over := value > max || (incr > 0 && (sum < value || sum > max))
+		under := value < min || (incr < 0 && (sum > value || sum < min))
+		if !over && !under {
+			return sum, false
+		}
+		switch mode {
+		case OverflowSat:
+			if over {
+				return max, true
+			}
+			return min, true
+		case OverflowWrap:
+			c := uint64(value) + uint64(incr)
+			if width < 64 {
+				mask := uint64(math.MaxUint64) << width
+				if c&(1<<(width-1)) != 0 {
+					c |= mask
+				} else {
+					c &^= mask
+				}
+			}
+			return int64(c), true
+		}
+		return 0, true
+	}
+
+	max := uint64(1)<<width - 1
+	uvalue := uint64(value)
+	over := uvalue > max || (incr > 0 && uint64(incr) > max-uvalue)
+	under := incr < 0 && uint64(-incr) > uvalue
+	if !over && !under {
+		return int64(uvalue + uint64(incr)), false
+	}
+	switch mode {
+	case OverflowSat:
+		if over {
+			return int64(max), true
+		}
+		return 0, true
+	case OverflowWrap:
+		return int64((uvalue + uint64(incr)) & max), true
+	}
+	return 0, true
+}
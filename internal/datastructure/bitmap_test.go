@@ -0,0 +1,168 @@
+package datastructure
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDictSetBitGrowsString(t *testing.T) {
+	d := CreateDict()
+
+	if old := d.SetBit("bm", 7, 1); old != 0 {
+		t.Errorf("Expected old bit 0, got %d", old)
+	}
+	val, _ := d.Get("bm")
+	if val != "\x01" {
+		t.Errorf("Expected \\x01, got %q", val)
+	}
+
+	d.SetBit("bm", 100, 1)
+	val, _ = d.Get("bm")
+	if len(val) != 13 {
+		t.Errorf("Expected length 13 after growth, got %d", len(val))
+	}
+	if d.GetBit("bm", 100) != 1 || d.GetBit("bm", 7) != 1 {
+		t.Error("Bits should survive growth")
+	}
+	if d.GetBit("bm", 99) != 0 || d.GetBit("bm", 10000) != 0 {
+		t.Error("Unset bits should read 0")
+	}
+
+	if old := d.SetBit("bm", 7, 0); old != 1 {
+		t.Errorf("Expected old bit 1, got %d", old)
+	}
+}
+
+func TestDictSetBitKeepsTTL(t *testing.T) {
+	d := CreateDict()
+	d.Set("bm", "a", 10*time.Second)
+	d.SetBit("bm", 1, 1)
+
+	if ttl := d.TTL("bm"); ttl < 9 {
+		t.Errorf("Expected TTL to be preserved, got %d", ttl)
+	}
+}
+
+func TestDictBitCount(t *testing.T) {
+	d := CreateDict()
+	d.Set("k", "foobar", 0)
+
+	tests := []struct {
+		r    *BitRange
+		want int64
+	}{
+		{nil, 26},
+		{&BitRange{Start: 0, End: 0, HasEnd: true}, 4},
+		{&BitRange{Start: 1, End: 1, HasEnd: true}, 6},
+		{&BitRange{Start: -2, End: -1, HasEnd: true}, 7},
+		{&BitRange{Start: 5, End: 30, HasEnd: true, Bit: true}, 17},
+		{&BitRange{Start: 3, End: 1, HasEnd: true}, 0},
+	}
+	for _, tt := range tests {
+		if got := d.BitCount("k", tt.r); got != tt.want {
+			t.Errorf("BitCount(%+v): expected %d, got %d", tt.r, tt.want, got)
+		}
+	}
+
+	if got := d.BitCount("missing", nil); got != 0 {
+		t.Errorf("Expected 0 for missing key, got %d", got)
+	}
+}
+
+func TestDictBitPos(t *testing.T) {
+	d := CreateDict()
+	d.Set("k", "\xff\xf0\x00", 0)
+
+	if got := d.BitPos("k", 0, nil); got != 12 {
+		t.Errorf("Expected 12, got %d", got)
+	}
+	if got := d.BitPos("k", 1, &BitRange{Start: 2}); got != -1 {
+		t.Errorf("Expected -1, got %d", got)
+	}
+	if got := d.BitPos("k", 1, &BitRange{Start: 7, End: 15, HasEnd: true, Bit: true}); got != 7 {
+		t.Errorf("Expected 7, got %d", got)
+	}
+
+	d.Set("ones", "\xff\xff", 0)
+	if got := d.BitPos("ones", 0, nil); got != 16 {
+		t.Errorf("Expected 16 when no clear bit and no end, got %d", got)
+	}
+	if got := d.BitPos("ones", 0, &BitRange{Start: 0, End: -1, HasEnd: true}); got != -1 {
+		t.Errorf("Expected -1 with explicit end, got %d", got)
+	}
+
+	if d.BitPos("missing", 0, nil) != 0 || d.BitPos("missing", 1, nil) != -1 {
+		t.Error("Missing key should report 0 for clear bits and -1 for set bits")
+	}
+}
+
+func TestDictBitOp(t *testing.T) {
+	d := CreateDict()
+	d.Set("a", "foobar", 0)
+	d.Set("b", "abcdef", 0)
+
+	if n := d.BitOp("AND", "dest", "a", "b"); n != 6 {
+		t.Errorf("Expected length 6, got %d", n)
+	}
+	if val, _ := d.Get("dest"); val != "`bc`ab" {
+		t.Errorf("Expected `bc`ab, got %q", val)
+	}
+
+	d.Set("short", "\x0f", 0)
+	d.BitOp("OR", "dest", "short", "a")
+	if val, _ := d.Get("dest"); val != "ooobar" {
+		t.Errorf("Expected ooobar, got %q", val)
+	}
+
+	d.BitOp("NOT", "dest", "short")
+	if val, _ := d.Get("dest"); val != "\xf0" {
+		t.Errorf("Expected \\xf0, got %q", val)
+	}
+
+	if n := d.BitOp("XOR", "dest", "missing1", "missing2"); n != 0 {
+		t.Errorf("Expected 0, got %d", n)
+	}
+	if _, ok := d.Get("dest"); ok {
+		t.Error("Empty result should delete destination")
+	}
+}
+
+func TestDictBitField(t *testing.T) {
+	d := CreateDict()
+
+	results := d.BitField("bf", []BitFieldOp{
+		{Kind: BitFieldSet, Signed: false, Bits: 8, Offset: 0, Value: 255},
+		{Kind: BitFieldGet, Signed: true, Bits: 8, Offset: 0},
+		{Kind: BitFieldIncr, Signed: false, Bits: 4, Offset: 8, Value: 20},
+	})
+	if results[0].Value != 0 || results[1].Value != -1 || results[2].Value != 4 {
+		t.Errorf("Unexpected results %+v", results)
+	}
+
+	results = d.BitField("bf", []BitFieldOp{
+		{Kind: BitFieldIncr, Signed: true, Bits: 8, Offset: 16, Value: 200, Overflow: OverflowSat},
+		{Kind: BitFieldIncr, Signed: true, Bits: 8, Offset: 16, Value: -300, Overflow: OverflowSat},
+		{Kind: BitFieldIncr, Signed: false, Bits: 2, Offset: 24, Value: 5, Overflow: OverflowFail},
+		{Kind: BitFieldIncr, Signed: true, Bits: 8, Offset: 32, Value: 130, Overflow: OverflowWrap},
+		{Kind: BitFieldIncr, Signed: true, Bits: 64, Offset: 40, Value: 1},
+	})
+	if results[0].Value != 127 {
+		t.Errorf("Expected saturation at 127, got %d", results[0].Value)
+	}
+	if results[1].Value != -128 {
+		t.Errorf("Expected saturation at -128, got %d", results[1].Value)
+	}
+	if !results[2].Nil {
+		t.Error("Expected FAIL overflow to return nil")
+	}
+	if results[3].Value != -126 {
+		t.Errorf("Expected wrap to -126, got %d", results[3].Value)
+	}
+	if results[4].Value != 1 {
+		t.Errorf("Expected i64 increment to 1, got %d", results[4].Value)
+	}
+
+	if got := d.BitField("bf", []BitFieldOp{{Kind: BitFieldGet, Bits: 2, Offset: 24}}); got[0].Value != 0 {
+		t.Errorf("Failed op must not write, got %d", got[0].Value)
+	}
+}
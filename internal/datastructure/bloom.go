@@ -0,0 +1,135 @@
+package datastructure
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	DefaultBloomErrorRate = 0.01
+	DefaultBloomCapacity  = 100
+	DefaultExpansion      = 2
+	// bloomTightening shrinks the error rate of each new sub-filter so the
+	// compound rate stays bounded as the filter grows.
+	bloomTightening = 0.5
+)
+
+func sketchHash(item string) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(item))
+	sum := h.Sum(nil)
+	return mix64(binary.BigEndian.Uint64(sum[:8])), mix64(binary.BigEndian.Uint64(sum[8:])) | 1
+}
+
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+type bloomLayer struct {
+	Bits     []uint64
+	NumBits  uint64
+	Hashes   int
+	Capacity int64
+	Count    int64
+}
+
+func newBloomLayer(capacity int64, errorRate float64) *bloomLayer {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	numBits := uint64(math.Ceil(float64(capacity) * bitsPerItem))
+	if numBits < 64 {
+		numBits = 64
+	}
+	return &bloomLayer{
+		Bits:     make([]uint64, (numBits+63)/64),
+		NumBits:  numBits,
+		Hashes:   int(math.Ceil(math.Ln2 * bitsPerItem)),
+		Capacity: capacity,
+	}
+}
+
+func (l *bloomLayer) test(h1, h2 uint64) bool {
+	for i := 0; i < l.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % l.NumBits
+		if l.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) set(h1, h2 uint64) {
+	for i := 0; i < l.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % l.NumBits
+		l.Bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// BloomFilter is a scalable Bloom filter: when the newest layer reaches its
+// capacity a larger layer with a tighter error rate is stacked on top.
+type BloomFilter struct {
+	Layers     []*bloomLayer
+	ErrorRate  float64
+	Expansion  int
+	NonScaling bool
+}
+
+func NewBloomFilter(errorRate float64, capacity int64, expansion int, nonScaling bool) *BloomFilter {
+	return &BloomFilter{
+		Layers:     []*bloomLayer{newBloomLayer(capacity, errorRate)},
+		ErrorRate:  errorRate,
+		Expansion:  expansion,
+		NonScaling: nonScaling,
+	}
+}
+
+func (b *BloomFilter) exists(h1, h2 uint64) bool {
+	for _, l := range b.Layers {
+		if l.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BloomFilter) add(item string) (bool, error) {
+	h1, h2 := sketchHash(item)
+	if b.exists(h1, h2) {
+		return false, nil
+	}
+	top := b.Layers[len(b.Layers)-1]
+	if top.Count >= top.Capacity {
+		if b.NonScaling {
+			return false, ErrFilterFull
+		}
+		rate := b.ErrorRate * math.Pow(bloomTightening, float64(len(b.Layers)))
+		top = newBloomLayer(top.Capacity*int64(b.Expansion), rate)
+		b.Layers = append(b.Layers, top)
+	}
+	top.set(h1, h2)
+	top.Count++
+	return true, nil
+}
+
+type BloomInfo struct {
+	Capacity  int64
+	Size      int
+	Filters   int
+	Items     int64
+	Expansion int
+}
+
+func (b *BloomFilter) info() BloomInfo {
+	info := BloomInfo{Filters: len(b.Layers), Expansion: b.Expansion}
+	for _, l := range b.Layers {
+		info.Capacity += l.Capacity
+		info.Items += l.Count
+		info.Size += len(l.Bits) * 8
+	}
+	return info
+}
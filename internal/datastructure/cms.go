@@ -0,0 +1,49 @@
+package datastructure
+
+import "math"
+
+// CountMinSketch estimates item frequencies with Depth rows of Width
+// counters.
+type CountMinSketch struct {
+	Width    uint64
+	Depth    uint64
+	Counters []uint64
+	Count    uint64
+}
+
+func NewCountMinSketch(width, depth uint64) *CountMinSketch {
+	return &CountMinSketch{
+		Width:    width,
+		Depth:    depth,
+		Counters: make([]uint64, width*depth),
+	}
+}
+
+// CMSDimensions converts an error rate and the probability of exceeding it
+// into sketch dimensions.
+func CMSDimensions(errorRate, probability float64) (uint64, uint64) {
+	width := uint64(math.Ceil(2 / errorRate))
+	depth := uint64(math.Ceil(math.Log(probability) / math.Log(0.5)))
+	return width, max(depth, 1)
+}
+
+func (c *CountMinSketch) incrBy(item string, incr uint64) uint64 {
+	h1, h2 := sketchHash(item)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < c.Depth; row++ {
+		i := row*c.Width + (h1+row*h2)%c.Width
+		c.Counters[i] += incr
+		estimate = min(estimate, c.Counters[i])
+	}
+	c.Count += incr
+	return estimate
+}
+
+func (c *CountMinSketch) query(item string) uint64 {
+	h1, h2 := sketchHash(item)
+	estimate := uint64(math.MaxUint64)
+	for row := uint64(0); row < c.Depth; row++ {
+		estimate = min(estimate, c.Counters[row*c.Width+(h1+row*h2)%c.Width])
+	}
+	return estimate
+}
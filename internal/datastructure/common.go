@@ -1,6 +1,7 @@
 package datastructure
 
 import (
+	"math/bits"
 	"time"
 
 	"github.com/william1nguyen/valkeydb/internal/config"
@@ -12,23 +13,77 @@ type Item struct {
 	ExpiredAt time.Time
 }
 
-func GetMaxSampleSize() int {
+// GetSegmentCount is how many segments a keyspace is split into, always a
+// power of two.
+func GetSegmentCount() int {
+	n := 16
+	if config.Global != nil && config.Global.Datastructure.Segments > 0 {
+		n = config.Global.Datastructure.Segments
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+func GetExpirationCheckInterval() time.Duration {
 	if config.Global != nil {
-		return config.Global.Datastructure.Expiration.MaxSampleSize
+		return config.Global.GetExpirationCheckInterval()
 	}
-	return 20
+	return time.Second
 }
 
-func GetMaxSampleRounds() int {
+// GetExpireCycleTime caps how long one active expiry cycle may run.
+func GetExpireCycleTime() time.Duration {
+	if config.Global != nil && config.Global.Datastructure.Expiration.MaxCycleTimeMs > 0 {
+		return time.Duration(config.Global.Datastructure.Expiration.MaxCycleTimeMs) * time.Millisecond
+	}
+	return 25 * time.Millisecond
+}
+
+func GetLFULogFactor() int {
 	if config.Global != nil {
-		return config.Global.Datastructure.Expiration.MaxSampleRounds
+		return config.Global.Memory.LFULogFactor
 	}
-	return 3
+	return 10
 }
 
-func GetExpirationCheckInterval() time.Duration {
+func GetLFUDecayTime() time.Duration {
 	if config.Global != nil {
-		return config.Global.GetExpirationCheckInterval()
+		return time.Duration(config.Global.Memory.LFUDecayTime) * time.Minute
 	}
-	return time.Second
+	return time.Minute
+}
+
+// EncodingLimits bounds the compact encodings of small collections.
+type EncodingLimits struct {
+	SetIntsetEntries    int
+	SetListpackEntries  int
+	SetListpackValue    int
+	HashListpackEntries int
+	HashListpackValue   int
+	ListListpackEntries int
+	ListListpackValue   int
+}
+
+var defaultEncodingLimits = EncodingLimits{
+	SetIntsetEntries:    512,
+	SetListpackEntries:  128,
+	SetListpackValue:    64,
+	HashListpackEntries: 128,
+	HashListpackValue:   64,
+	ListListpackEntries: 128,
+	ListListpackValue:   64,
+}
+
+func GetEncodingLimits() EncodingLimits {
+	if c := config.Global; c != nil {
+		return EncodingLimits{
+			SetIntsetEntries:    c.GetSetMaxIntsetEntries(),
+			SetListpackEntries:  c.GetSetMaxListpackEntries(),
+			SetListpackValue:    c.GetSetMaxListpackValue(),
+			HashListpackEntries: c.GetHashMaxListpackEntries(),
+			HashListpackValue:   c.GetHashMaxListpackValue(),
+			ListListpackEntries: c.GetListMaxListpackEntries(),
+			ListListpackValue:   c.GetListMaxListpackValue(),
+		}
+	}
+	return defaultEncodingLimits
 }
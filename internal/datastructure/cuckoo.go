@@ -0,0 +1,187 @@
+package datastructure
+
+import "math/bits"
+
+const (
+	DefaultCuckooCapacity   = 1024
+	DefaultCuckooBucketSize = 2
+	DefaultCuckooMaxIter    = 20
+)
+
+type cuckooLayer struct {
+	Slots      []uint8
+	NumBuckets uint64
+}
+
+func newCuckooLayer(capacity int64, bucketSize int) *cuckooLayer {
+	buckets := uint64(capacity) / uint64(bucketSize)
+	if buckets < 1 {
+		buckets = 1
+	}
+	// A power of two keeps the alternate index an involution under XOR.
+	buckets = 1 << bits.Len64(buckets-1)
+	return &cuckooLayer{
+		Slots:      make([]uint8, buckets*uint64(bucketSize)),
+		NumBuckets: buckets,
+	}
+}
+
+func (l *cuckooLayer) altIndex(index uint64, fp uint8) uint64 {
+	return (index ^ (uint64(fp) * 0x5bd1e995)) & (l.NumBuckets - 1)
+}
+
+func (l *cuckooLayer) bucket(index uint64, bucketSize int) []uint8 {
+	start := index * uint64(bucketSize)
+	return l.Slots[start : start+uint64(bucketSize)]
+}
+
+// CuckooFilter stores 8-bit fingerprints in buckets of BucketSize slots.
+type CuckooFilter struct {
+	Layers        []*cuckooLayer
+	Capacity      int64
+	BucketSize    int
+	MaxIterations int
+	Expansion     int
+	Items         int64
+	Deletes       int64
+	Rand          uint64
+}
+
+func NewCuckooFilter(capacity int64, bucketSize, maxIterations, expansion int) *CuckooFilter {
+	return &CuckooFilter{
+		Layers:        []*cuckooLayer{newCuckooLayer(capacity, bucketSize)},
+		Capacity:      capacity,
+		BucketSize:    bucketSize,
+		MaxIterations: maxIterations,
+		Expansion:     expansion,
+		Rand:          0x9e3779b97f4a7c15,
+	}
+}
+
+func cuckooFingerprint(item string) (uint64, uint8) {
+	h, _ := sketchHash(item)
+	fp := uint8(h >> 56)
+	if fp == 0 {
+		fp = 1
+	}
+	return h, fp
+}
+
+func (c *CuckooFilter) next() uint64 {
+	c.Rand ^= c.Rand << 13
+	c.Rand ^= c.Rand >> 7
+	c.Rand ^= c.Rand << 17
+	return c.Rand
+}
+
+func (c *CuckooFilter) count(item string) int {
+	h, fp := cuckooFingerprint(item)
+	n := 0
+	for _, l := range c.Layers {
+		i1 := h & (l.NumBuckets - 1)
+		i2 := l.altIndex(i1, fp)
+		for _, slot := range l.bucket(i1, c.BucketSize) {
+			if slot == fp {
+				n++
+			}
+		}
+		if i2 != i1 {
+			for _, slot := range l.bucket(i2, c.BucketSize) {
+				if slot == fp {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+func (c *CuckooFilter) add(item string) error {
+	h, fp := cuckooFingerprint(item)
+	for i := len(c.Layers) - 1; i >= 0; i-- {
+		if c.insertInto(c.Layers[i], h, fp) {
+			c.Items++
+			return nil
+		}
+	}
+	if c.Expansion == 0 {
+		return ErrFilterFull
+	}
+	capacity := c.Capacity
+	for range c.Layers {
+		capacity *= int64(c.Expansion)
+	}
+	l := newCuckooLayer(capacity, c.BucketSize)
+	c.Layers = append(c.Layers, l)
+	if !c.insertInto(l, h, fp) {
+		return ErrFilterFull
+	}
+	c.Items++
+	return nil
+}
+
+func (c *CuckooFilter) insertInto(l *cuckooLayer, h uint64, fp uint8) bool {
+	i1 := h & (l.NumBuckets - 1)
+	i2 := l.altIndex(i1, fp)
+	for _, index := range []uint64{i1, i2} {
+		b := l.bucket(index, c.BucketSize)
+		for s := range b {
+			if b[s] == 0 {
+				b[s] = fp
+				return true
+			}
+		}
+	}
+
+	// Relocate existing fingerprints; undo every swap if no room turns up so
+	// the layer is left exactly as it was.
+	type move struct {
+		index uint64
+		slot  int
+	}
+	path := make([]move, 0, c.MaxIterations)
+	index := i1
+	if c.next()&1 == 1 {
+		index = i2
+	}
+	for n := 0; n < c.MaxIterations; n++ {
+		slot := int(c.next() % uint64(c.BucketSize))
+		b := l.bucket(index, c.BucketSize)
+		fp, b[slot] = b[slot], fp
+		path = append(path, move{index, slot})
+
+		index = l.altIndex(index, fp)
+		b = l.bucket(index, c.BucketSize)
+		for s := range b {
+			if b[s] == 0 {
+				b[s] = fp
+				return true
+			}
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		b := l.bucket(path[i].index, c.BucketSize)
+		fp, b[path[i].slot] = b[path[i].slot], fp
+	}
+	return false
+}
+
+func (c *CuckooFilter) remove(item string) bool {
+	h, fp := cuckooFingerprint(item)
+	for i := len(c.Layers) - 1; i >= 0; i-- {
+		l := c.Layers[i]
+		i1 := h & (l.NumBuckets - 1)
+		for _, index := range []uint64{i1, l.altIndex(i1, fp)} {
+			b := l.bucket(index, c.BucketSize)
+			for s := range b {
+				if b[s] == fp {
+					b[s] = 0
+					c.Items--
+					c.Deletes++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
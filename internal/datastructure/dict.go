@@ -1,49 +1,63 @@
 package datastructure
 
 import (
-	"math/rand/v2"
-	"sync"
+	"errors"
+	"math"
+	"strconv"
 	"time"
 )
 
+var (
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrOverflow   = errors.New("ERR increment or decrement would overflow")
+)
+
 type Dict struct {
-	mu    sync.RWMutex
-	items map[string]Item
+	segs segments[Item]
 }
 
 func CreateDict() *Dict {
 	d := &Dict{
-		items: make(map[string]Item),
+		segs: newSegments[Item](GetSegmentCount()),
 	}
-	go d.expireLoop()
+	tick(GetExpirationCheckInterval(), func() { d.activeExpire() })
 	return d
 }
 
 func (d *Dict) getItem(key string) (Item, bool) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-	item, exist := d.items[key]
-	return item, exist
+	defer d.segs.rlock(key)()
+	return d.segs.get(key)
 }
 
 func (d *Dict) isExpired(item Item) bool {
 	return !item.ExpiredAt.IsZero() && time.Now().After(item.ExpiredAt)
 }
 
+// put and remove need the write lock of key's segment.
+func (d *Dict) put(key string, item Item) {
+	seg := d.segs.of(key)
+	seg.items[key] = item
+	seg.expires.set(key, item.ExpiredAt)
+}
+
+func (d *Dict) remove(key string) {
+	d.segs.del(key)
+}
+
 func (d *Dict) passiveExpire(key string) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	delete(d.items, key)
+	defer d.segs.lock(key)()
+	if item, ok := d.segs.get(key); ok && d.isExpired(item) {
+		d.remove(key)
+	}
 }
 
 func (d *Dict) Set(key, value string, ttl time.Duration) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+	defer d.segs.lock(key)()
 	item := Item{Value: value}
 	if ttl > 0 {
 		item.ExpiredAt = time.Now().Add(ttl)
 	}
-	d.items[key] = item
+	d.put(key, item)
 }
 
 func (d *Dict) Get(key string) (string, bool) {
@@ -58,56 +72,53 @@ func (d *Dict) Get(key string) (string, bool) {
 	return item.Value, true
 }
 
-func (d *Dict) Delete(keys ...string) int {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	count := 0
-	for _, key := range keys {
-		if _, exist := d.items[key]; exist {
-			delete(d.items, key)
-			count++
+// IncrBy adds delta to the integer stored at key, treating a missing key as
+// 0. The key keeps its TTL.
+func (d *Dict) IncrBy(key string, delta int64) (int64, error) {
+	defer d.segs.lock(key)()
+	item, ok := d.segs.get(key)
+	if ok && d.isExpired(item) {
+		item, ok = Item{}, false
+	}
+	var n int64
+	if ok {
+		var err error
+		if n, err = strconv.ParseInt(item.Value, 10, 64); err != nil {
+			return 0, ErrNotInteger
 		}
 	}
-	return count
-}
-
-func (d *Dict) Expire(key string, ttl time.Duration) bool {
-	item, exist := d.getItem(key)
-	if !exist {
-		return false
-	}
-
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	if ttl <= 0 {
-		delete(d.items, key)
-		return true
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
 	}
+	n += delta
+	item.Value = strconv.FormatInt(n, 10)
+	d.put(key, item)
+	return n, nil
+}
 
-	item.ExpiredAt = time.Now().Add(ttl)
-	d.items[key] = item
+func (d *Dict) Delete(keys ...string) int {
+	return d.segs.deleteKeys(keys)
+}
 
-	return true
+func (d *Dict) Expire(key string, ttl time.Duration) bool {
+	return d.ExpireAt(key, time.Now().Add(ttl))
 }
 
 func (d *Dict) ExpireAt(key string, at time.Time) bool {
-	item, exist := d.getItem(key)
-	if !exist {
+	defer d.segs.lock(key)()
+
+	item, exist := d.segs.get(key)
+	if !exist || d.isExpired(item) {
 		return false
 	}
 
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
-	if at.Before(time.Now()) {
-		delete(d.items, key)
+	if !at.After(time.Now()) {
+		d.remove(key)
 		return true
 	}
 
 	item.ExpiredAt = at
-	d.items[key] = item
+	d.put(key, item)
 
 	return true
 }
@@ -131,66 +142,74 @@ func (d *Dict) TTL(key string) int64 {
 	return int64(seconds)
 }
 
-func (d *Dict) expireLoop() {
-	ticker := time.NewTicker(GetExpirationCheckInterval())
-	defer ticker.Stop()
+func (d *Dict) activeExpire() int {
+	return d.segs.expireCycle(GetExpireCycleTime())
+}
 
-	for range ticker.C {
-		d.activeExpire()
-	}
+func (d *Dict) live(item Item) bool {
+	return !d.isExpired(item)
 }
 
-func (d *Dict) activeExpire() {
-	if len(d.items) == 0 {
-		return
-	}
+func (d *Dict) dumpItem(key string, item Item) (Item, bool) {
+	return item, !d.isExpired(item)
+}
 
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Dict) snapshot() cowSegments[Item, Item] {
+	return cowSegments[Item, Item]{segs: d.segs, dump: d.dumpItem, usage: itemUsage}
+}
 
-	for i := 0; i < GetMaxSampleRounds(); i++ {
-		checked := 0
-		expired := 0
+func (d *Dict) cow() cowStore {
+	return d.snapshot()
+}
 
-		keys := make([]string, 0, len(d.items))
-		for key := range d.items {
-			keys = append(keys, key)
-		}
+// Dump copies the given keys, or every key when none are given.
+func (d *Dict) Dump(keys ...string) map[string]Item {
+	return d.snapshot().dumpKeys(keys)
+}
 
-		limit := GetMaxSampleSize()
-		if len(keys) < limit {
-			limit = len(keys)
-		}
+// DumpSegment returns segment i as an open SnapshotView sees it.
+func (d *Dict) DumpSegment(i int) map[string]Item {
+	return d.snapshot().dumpSegment(i)
+}
 
-		for j := 0; j < limit; j++ {
-			idx := rand.IntN(len(keys))
-			key := keys[idx]
-			if item, exist := d.items[key]; exist {
-				checked++
-				if d.isExpired(item) {
-					delete(d.items, key)
-					expired++
-				}
-			}
-		}
+func (d *Dict) Keys() []string {
+	return d.segs.keys(d.live)
+}
 
-		if checked == 0 || expired*4 < checked {
-			return
-		}
-	}
+// SegmentKeys lists the keys of segment i, for SCAN.
+func (d *Dict) SegmentKeys(i int) []string {
+	return d.segs.segmentKeys(i, d.live)
 }
 
-func (d *Dict) Dump() map[string]Item {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Dict) Segments() int {
+	return len(d.segs)
+}
 
-	snapshot := make(map[string]Item, len(d.items))
+// Len is the number of keys, including expired ones not yet removed.
+func (d *Dict) Len() int {
+	return d.segs.count()
+}
 
-	for key, item := range d.items {
-		if !d.isExpired(item) {
-			snapshot[key] = item
-		}
-	}
+// Sample returns up to n keys picked at random, for eviction.
+func (d *Dict) Sample(n int) []string {
+	return d.segs.sample(n)
+}
+
+// SampleVolatile returns up to n keys that have a TTL, with their expiry.
+func (d *Dict) SampleVolatile(n int) map[string]time.Time {
+	return d.segs.sampleVolatile(n)
+}
+
+func (d *Dict) Exists(key string) bool {
+	_, ok := d.Get(key)
+	return ok
+}
+
+func (d *Dict) accessOf(key string) (*accessMap, func()) {
+	return d.segs.accessOf(key)
+}
 
-	return snapshot
+// Flush removes every key.
+func (d *Dict) Flush() {
+	d.segs.flush()
 }
@@ -56,6 +56,9 @@ func TestDictDelete(t *testing.T) {
 	if !ok || val != "value2" {
 		t.Error("key2 should still exist")
 	}
+	if n := d.Len(); n != 1 {
+		t.Errorf("Expected 1 key left, got %d", n)
+	}
 }
 
 func TestDictTTL(t *testing.T) {
@@ -98,3 +101,23 @@ func TestDictDump(t *testing.T) {
 		t.Error("Expired key should not be in snapshot")
 	}
 }
+
+func TestDictIncrBy(t *testing.T) {
+	d := CreateDict()
+	d.Set("n", "5", time.Hour)
+
+	n, err := d.IncrBy("n", 3)
+	if err != nil || n != 8 {
+		t.Fatalf("Expected 8, got %d %v", n, err)
+	}
+	if ttl := d.TTL("n"); ttl <= 0 {
+		t.Errorf("IncrBy should keep the TTL, got %d", ttl)
+	}
+	if n, _ := d.IncrBy("missing", -2); n != -2 {
+		t.Errorf("Expected -2, got %d", n)
+	}
+	d.Set("s", "x", 0)
+	if _, err := d.IncrBy("s", 1); err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package datastructure
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"time"
+)
+
+const expireBatch = 64
+
+type expiryEntry struct {
+	key string
+	at  time.Time
+}
+
+type expiryIndex struct {
+	entries []expiryEntry
+	pos     map[string]int
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{pos: make(map[string]int)}
+}
+
+func (x *expiryIndex) Len() int           { return len(x.entries) }
+func (x *expiryIndex) Less(i, j int) bool { return x.entries[i].at.Before(x.entries[j].at) }
+
+func (x *expiryIndex) Swap(i, j int) {
+	x.entries[i], x.entries[j] = x.entries[j], x.entries[i]
+	x.pos[x.entries[i].key] = i
+	x.pos[x.entries[j].key] = j
+}
+
+func (x *expiryIndex) Push(e any) {
+	entry := e.(expiryEntry)
+	x.pos[entry.key] = len(x.entries)
+	x.entries = append(x.entries, entry)
+}
+
+func (x *expiryIndex) Pop() any {
+	last := x.entries[len(x.entries)-1]
+	x.entries = x.entries[:len(x.entries)-1]
+	delete(x.pos, last.key)
+	return last
+}
+
+func (x *expiryIndex) set(key string, at time.Time) {
+	i, ok := x.pos[key]
+	switch {
+	case at.IsZero():
+		if ok {
+			heap.Remove(x, i)
+		}
+	case ok:
+		x.entries[i].at = at
+		heap.Fix(x, i)
+	default:
+		heap.Push(x, expiryEntry{key: key, at: at})
+	}
+}
+
+func (x *expiryIndex) remove(key string) {
+	x.set(key, time.Time{})
+}
+
+func (x *expiryIndex) due(now time.Time) (string, bool) {
+	if len(x.entries) == 0 || x.entries[0].at.After(now) {
+		return "", false
+	}
+	return x.entries[0].key, true
+}
+
+func (x *expiryIndex) sample(n int) map[string]time.Time {
+	n = min(n, len(x.entries))
+	keys := make(map[string]time.Time, n)
+	if n == 0 {
+		return keys
+	}
+	start := rand.IntN(len(x.entries))
+	for i := range n {
+		e := x.entries[(start+i)%len(x.entries)]
+		keys[e.key] = e.at
+	}
+	return keys
+}
+
+// The segment's write lock must be held.
+func (seg *segment[V]) expireDue(now time.Time, limit int) int {
+	n := 0
+	for n < limit {
+		key, ok := seg.expires.due(now)
+		if !ok {
+			break
+		}
+		seg.preserve(key)
+		delete(seg.items, key)
+		seg.access.forget(key)
+		seg.expires.remove(key)
+		n++
+	}
+	return n
+}
+
+func (segs segments[V]) expireCycle(budget time.Duration) int {
+	deadline := time.Now().Add(budget)
+	start := rand.IntN(len(segs))
+	total := 0
+	for i := range segs {
+		seg := segs[(start+i)%len(segs)]
+		for {
+			seg.lock()
+			n := seg.expireDue(time.Now(), expireBatch)
+			seg.unlock()
+			total += n
+			if time.Now().After(deadline) {
+				return total
+			}
+			if n < expireBatch {
+				break
+			}
+		}
+	}
+	return total
+}
+
+func (segs segments[V]) sampleVolatile(n int) map[string]time.Time {
+	keys := make(map[string]time.Time, n)
+	start := rand.IntN(len(segs))
+	for i := range segs {
+		if len(keys) >= n {
+			break
+		}
+		seg := segs[(start+i)%len(segs)]
+		seg.rlock()
+		for key, at := range seg.expires.sample(n - len(keys)) {
+			keys[key] = at
+		}
+		seg.runlock()
+	}
+	return keys
+}
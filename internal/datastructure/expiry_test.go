@@ -0,0 +1,190 @@
+package datastructure
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpiryIndex(t *testing.T) {
+	x := newExpiryIndex()
+	now := time.Now()
+	x.set("c", now.Add(3*time.Second))
+	x.set("a", now.Add(time.Second))
+	x.set("b", now.Add(2*time.Second))
+
+	if _, ok := x.due(now); ok {
+		t.Error("Nothing should be due yet")
+	}
+	if key, ok := x.due(now.Add(time.Second)); !ok || key != "a" {
+		t.Errorf("Expected a due, got %q %v", key, ok)
+	}
+
+	x.set("a", now.Add(5*time.Second))
+	if key, _ := x.due(now.Add(4 * time.Second)); key != "b" {
+		t.Errorf("Expected b after moving a, got %q", key)
+	}
+
+	x.remove("b")
+	x.set("c", time.Time{})
+	if x.Len() != 1 {
+		t.Fatalf("Expected 1 entry, got %d", x.Len())
+	}
+	if key, _ := x.due(now.Add(10 * time.Second)); key != "a" {
+		t.Errorf("Expected a, got %q", key)
+	}
+	for key, i := range x.pos {
+		if x.entries[i].key != key {
+			t.Errorf("Position of %q is stale", key)
+		}
+	}
+
+	sample := x.sample(10)
+	if len(sample) != 1 || !sample["a"].Equal(now.Add(5*time.Second)) {
+		t.Errorf("Unexpected sample %v", sample)
+	}
+}
+
+// counts returns how many keys and how many keys with a TTL segs holds.
+func counts[V any](segs segments[V]) (keys, volatile int) {
+	for _, seg := range segs {
+		seg.mu.RLock()
+		keys += len(seg.items)
+		volatile += seg.expires.Len()
+		seg.mu.RUnlock()
+	}
+	return keys, volatile
+}
+
+func indexed[V any](segs segments[V], key string) bool {
+	defer segs.rlock(key)()
+	_, ok := segs.of(key).expires.pos[key]
+	return ok
+}
+
+func TestDictActiveExpire(t *testing.T) {
+	d := CreateDict()
+	for i := range 200 {
+		d.Set("tmp"+strconv.Itoa(i), "v", 20*time.Millisecond)
+	}
+	d.Set("keep", "v", 0)
+	d.Set("later", "v", time.Hour)
+	d.Set("persisted", "v", 20*time.Millisecond)
+	d.Set("persisted", "v", 0)
+
+	time.Sleep(30 * time.Millisecond)
+	d.activeExpire()
+
+	n, volatile := counts(d.segs)
+	if n != 3 {
+		t.Errorf("Expected 3 keys left, got %d", n)
+	}
+	if volatile != 1 {
+		t.Errorf("Expected 1 volatile key, got %d", volatile)
+	}
+	if _, ok := d.Get("persisted"); !ok {
+		t.Error("Overwritten key should have lost its TTL")
+	}
+}
+
+func TestDictExpireIndexFollowsWrites(t *testing.T) {
+	d := CreateDict()
+	d.Set("k", "v", time.Hour)
+	d.Delete("k")
+	d.Set("bits", "", time.Hour)
+	d.SetBit("bits", 3, 1)
+	d.BitOp("AND", "dest", "missing")
+
+	if indexed(d.segs, "k") {
+		t.Error("Deleted key is still indexed")
+	}
+	if !indexed(d.segs, "bits") {
+		t.Error("SETBIT should keep the TTL")
+	}
+}
+
+func TestSetActiveExpire(t *testing.T) {
+	s := CreateSet()
+	for i := range 200 {
+		key := "tmp" + strconv.Itoa(i)
+		s.Sadd(key, "a")
+		s.Expire(key, 20*time.Millisecond)
+	}
+	s.Sadd("keep", "a")
+	s.Sadd("gone", "a")
+	s.Expire("gone", time.Hour)
+	s.Srem("gone", "a")
+
+	time.Sleep(30 * time.Millisecond)
+	s.activeExpire()
+
+	n, volatile := counts(s.segs)
+	if n != 1 {
+		t.Errorf("Expected 1 key left, got %d", n)
+	}
+	if volatile != 0 {
+		t.Errorf("Expected no volatile keys, got %d", volatile)
+	}
+}
+
+// fillDict adds n keys without a TTL, the bulk a cycle used to walk.
+func fillDict(d *Dict, n int) {
+	for i := range n {
+		d.Set("key:"+strconv.Itoa(i), "v", 0)
+	}
+}
+
+func BenchmarkDictActiveExpireIdle(b *testing.B) {
+	d := CreateDict()
+	fillDict(d, 1_000_000)
+	for i := range 10_000 {
+		d.Set("tmp:"+strconv.Itoa(i), "v", time.Hour)
+	}
+	b.ResetTimer()
+	for range b.N {
+		d.activeExpire()
+	}
+}
+
+func BenchmarkDictActiveExpireDue(b *testing.B) {
+	d := CreateDict()
+	fillDict(d, 1_000_000)
+	past := time.Now().Add(-time.Second)
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		for i := range expireBatch {
+			key := "tmp:" + strconv.Itoa(i)
+			unlock := d.segs.lock(key)
+			d.put(key, Item{Value: "v", ExpiredAt: past})
+			unlock()
+		}
+		b.StartTimer()
+		d.activeExpire()
+	}
+}
+
+func BenchmarkDictSetWithTTL(b *testing.B) {
+	d := CreateDict()
+	fillDict(d, 1_000_000)
+	b.ResetTimer()
+	for i := range b.N {
+		d.Set("tmp:"+strconv.Itoa(i%100_000), "v", time.Hour)
+	}
+}
+
+func BenchmarkSetActiveExpireIdle(b *testing.B) {
+	s := CreateSet()
+	for i := range 1_000_000 {
+		s.Sadd("key:"+strconv.Itoa(i), "a")
+	}
+	for i := range 10_000 {
+		key := "tmp:" + strconv.Itoa(i)
+		s.Sadd(key, "a")
+		s.Expire(key, time.Hour)
+	}
+	b.ResetTimer()
+	for range b.N {
+		s.activeExpire()
+	}
+}
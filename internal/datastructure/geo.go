@@ -0,0 +1,233 @@
+package datastructure
+
+import "math"
+
+const (
+	GeoLatMin = -85.05112878
+	GeoLatMax = 85.05112878
+	GeoLonMin = -180.0
+	GeoLonMax = 180.0
+
+	geoStepMax        = 26
+	earthRadiusMeters = 6372797.560856
+	geoAlphabet       = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+type GeoShape int
+
+const (
+	GeoByRadius GeoShape = iota
+	GeoByBox
+)
+
+type GeoQuery struct {
+	Lon    float64
+	Lat    float64
+	Shape  GeoShape
+	Radius float64
+	Width  float64
+	Height float64
+}
+
+type GeoResult struct {
+	Member string
+	Dist   float64
+	Hash   uint64
+	Lon    float64
+	Lat    float64
+}
+
+func interleave64(xlo, ylo uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+	x, y := uint64(xlo), uint64(ylo)
+	for i := 4; i >= 0; i-- {
+		x = (x | x<<s[i]) & b[i]
+		y = (y | y<<s[i]) & b[i]
+	}
+	return x | y<<1
+}
+
+func deinterleave64(interleaved uint64) (uint32, uint32) {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := 0; i < len(b); i++ {
+		x = (x | x>>s[i]) & b[i]
+		y = (y | y>>s[i]) & b[i]
+	}
+	return uint32(x), uint32(y)
+}
+
+func ValidGeoCoord(lon, lat float64) bool {
+	return lon >= GeoLonMin && lon <= GeoLonMax && lat >= GeoLatMin && lat <= GeoLatMax
+}
+
+func geohashEncodeRange(lon, lat, lonMin, lonMax, latMin, latMax float64, step uint) uint64 {
+	scale := float64(uint64(1) << step)
+	limit := uint32(uint64(1)<<step - 1)
+	latBits := uint32(math.Min((lat-latMin)/(latMax-latMin)*scale, float64(limit)))
+	lonBits := uint32(math.Min((lon-lonMin)/(lonMax-lonMin)*scale, float64(limit)))
+	return interleave64(latBits, lonBits)
+}
+
+// GeohashEncode maps a coordinate to the 52-bit score used by geo sorted sets.
+func GeohashEncode(lon, lat float64) uint64 {
+	return geohashEncodeRange(lon, lat, GeoLonMin, GeoLonMax, GeoLatMin, GeoLatMax, geoStepMax)
+}
+
+// GeohashDecode returns the center of the cell a 52-bit score refers to.
+func GeohashDecode(hash uint64) (float64, float64) {
+	latBits, lonBits := deinterleave64(hash)
+	scale := float64(uint64(1) << geoStepMax)
+
+	latMin := GeoLatMin + float64(latBits)/scale*(GeoLatMax-GeoLatMin)
+	latMax := GeoLatMin + float64(latBits+1)/scale*(GeoLatMax-GeoLatMin)
+	lonMin := GeoLonMin + float64(lonBits)/scale*(GeoLonMax-GeoLonMin)
+	lonMax := GeoLonMin + float64(lonBits+1)/scale*(GeoLonMax-GeoLonMin)
+
+	lon := math.Max(GeoLonMin, math.Min(GeoLonMax, (lonMin+lonMax)/2))
+	lat := math.Max(GeoLatMin, math.Min(GeoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+// GeohashString renders a score as the standard 11 character geohash, which
+// uses the full [-90, 90] latitude range rather than the Mercator limits.
+func GeohashString(hash uint64) string {
+	lon, lat := GeohashDecode(hash)
+	bits := geohashEncodeRange(lon, lat, -180, 180, -90, 90, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := uint64(0)
+		if i < 10 {
+			idx = bits >> (52 - uint(i+1)*5) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(deg float64) float64 { return deg * math.Pi / 180 }
+func radDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := degRad(lat1), degRad(lon1)
+	lat2r, lon2r := degRad(lat2), degRad(lon2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func (q GeoQuery) match(lon, lat float64) (float64, bool) {
+	if q.Shape == GeoByBox {
+		latDist := earthRadiusMeters * math.Abs(degRad(lat)-degRad(q.Lat))
+		if latDist > q.Height/2 {
+			return 0, false
+		}
+		if GeoDistance(lon, lat, q.Lon, lat) > q.Width/2 {
+			return 0, false
+		}
+		return GeoDistance(q.Lon, q.Lat, lon, lat), true
+	}
+	dist := GeoDistance(q.Lon, q.Lat, lon, lat)
+	return dist, dist <= q.Radius
+}
+
+func (q GeoQuery) scoreRanges() []ScoreRange {
+	halfWidth, halfHeight := q.Radius, q.Radius
+	if q.Shape == GeoByBox {
+		halfWidth, halfHeight = q.Width/2, q.Height/2
+	}
+
+	latSpan := radDeg(halfHeight / earthRadiusMeters)
+	edgeLat := math.Min(90, math.Abs(q.Lat)+latSpan)
+	lonSpan := 360.0
+	if cos := math.Cos(degRad(edgeLat)); cos > 1e-12 {
+		lonSpan = radDeg(halfWidth / earthRadiusMeters / cos)
+	}
+
+	step := uint(geoStepMax)
+	for step > 0 {
+		cells := float64(uint64(1) << step)
+		if (GeoLonMax-GeoLonMin)/cells >= lonSpan && (GeoLatMax-GeoLatMin)/cells >= latSpan {
+			break
+		}
+		step--
+	}
+	if step == 0 {
+		return []ScoreRange{{Min: 0, Max: float64(uint64(1) << (2 * geoStepMax)), MaxEx: true}}
+	}
+
+	center := geohashEncodeRange(q.Lon, q.Lat, GeoLonMin, GeoLonMax, GeoLatMin, GeoLatMax, step)
+	latBits, lonBits := deinterleave64(center)
+	cells := int64(1) << step
+	shift := 2 * (geoStepMax - step)
+
+	seen := map[uint64]struct{}{}
+	ranges := make([]ScoreRange, 0, 9)
+	for dlat := int64(-1); dlat <= 1; dlat++ {
+		lat := int64(latBits) + dlat
+		if lat < 0 || lat >= cells {
+			continue
+		}
+		for dlon := int64(-1); dlon <= 1; dlon++ {
+			lon := (int64(lonBits) + dlon + cells) % cells
+			cell := interleave64(uint32(lat), uint32(lon))
+			if _, dup := seen[cell]; dup {
+				continue
+			}
+			seen[cell] = struct{}{}
+			ranges = append(ranges, ScoreRange{
+				Min:   float64(cell << shift),
+				Max:   float64((cell + 1) << shift),
+				MaxEx: true,
+			})
+		}
+	}
+	return ranges
+}
+
+func (z *ZSet) Geoadd(key string, flags ZAddFlags, lon, lat float64, member string) int {
+	return z.Zadd(key, flags, ScoreMember{Member: member, Score: float64(GeohashEncode(lon, lat))})
+}
+
+func (z *ZSet) Geopos(key, member string) (float64, float64, bool) {
+	score, ok := z.Zscore(key, member)
+	if !ok {
+		return 0, 0, false
+	}
+	lon, lat := GeohashDecode(uint64(score))
+	return lon, lat, true
+}
+
+func (z *ZSet) Geodist(key, member1, member2 string) (float64, bool) {
+	lon1, lat1, ok1 := z.Geopos(key, member1)
+	lon2, lat2, ok2 := z.Geopos(key, member2)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return GeoDistance(lon1, lat1, lon2, lat2), true
+}
+
+// GeoSearch returns every member inside the query shape, unsorted.
+func (z *ZSet) GeoSearch(key string, q GeoQuery) []GeoResult {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return []GeoResult{}
+	}
+
+	results := []GeoResult{}
+	for _, r := range q.scoreRanges() {
+		for _, e := range ss.rangeByScore(r) {
+			lon, lat := GeohashDecode(uint64(e.Score))
+			dist, ok := q.match(lon, lat)
+			if !ok {
+				continue
+			}
+			results = append(results, GeoResult{Member: e.Member, Dist: dist, Hash: uint64(e.Score), Lon: lon, Lat: lat})
+		}
+	}
+	return results
+}
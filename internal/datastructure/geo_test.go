@@ -0,0 +1,113 @@
+package datastructure
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+func setupSicily() *ZSet {
+	z := CreateZSet()
+	z.Geoadd("Sicily", ZAddFlags{}, 13.361389, 38.115556, "Palermo")
+	z.Geoadd("Sicily", ZAddFlags{}, 15.087269, 37.502669, "Catania")
+	return z
+}
+
+func TestGeohashRoundTrip(t *testing.T) {
+	lon, lat := GeohashDecode(GeohashEncode(13.361389, 38.115556))
+	if math.Abs(lon-13.36138933897018433) > 1e-9 || math.Abs(lat-38.11555639549629859) > 1e-9 {
+		t.Errorf("Unexpected decoded position %v,%v", lon, lat)
+	}
+}
+
+func TestGeohashString(t *testing.T) {
+	z := setupSicily()
+
+	score, _ := z.Zscore("Sicily", "Palermo")
+	if got := GeohashString(uint64(score)); got != "sqc8b49rny0" {
+		t.Errorf("Expected sqc8b49rny0, got %s", got)
+	}
+	score, _ = z.Zscore("Sicily", "Catania")
+	if got := GeohashString(uint64(score)); got != "sqdtr74hyu0" {
+		t.Errorf("Expected sqdtr74hyu0, got %s", got)
+	}
+}
+
+func TestGeodist(t *testing.T) {
+	z := setupSicily()
+
+	dist, ok := z.Geodist("Sicily", "Palermo", "Catania")
+	if !ok || math.Abs(dist-166274.1516) > 0.001 {
+		t.Errorf("Expected 166274.1516, got %f", dist)
+	}
+	if _, ok := z.Geodist("Sicily", "Palermo", "Rome"); ok {
+		t.Error("Expected missing member to fail")
+	}
+}
+
+func TestGeoSearchRadius(t *testing.T) {
+	z := setupSicily()
+
+	results := z.GeoSearch("Sicily", GeoQuery{Lon: 15, Lat: 37, Shape: GeoByRadius, Radius: 200000})
+	sort.Slice(results, func(i, j int) bool { return results[i].Dist < results[j].Dist })
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].Member != "Catania" || math.Abs(results[0].Dist/1000-56.4413) > 0.0001 {
+		t.Errorf("Unexpected first result %+v", results[0])
+	}
+	if results[1].Member != "Palermo" || math.Abs(results[1].Dist/1000-190.4424) > 0.0001 {
+		t.Errorf("Unexpected second result %+v", results[1])
+	}
+
+	results = z.GeoSearch("Sicily", GeoQuery{Lon: 15, Lat: 37, Shape: GeoByRadius, Radius: 100000})
+	if len(results) != 1 || results[0].Member != "Catania" {
+		t.Errorf("Expected only Catania, got %+v", results)
+	}
+}
+
+func TestGeoSearchBox(t *testing.T) {
+	z := setupSicily()
+	z.Geoadd("Sicily", ZAddFlags{}, 12.758489, 38.788135, "edge1")
+	z.Geoadd("Sicily", ZAddFlags{}, 17.241510, 38.788135, "edge2")
+
+	results := z.GeoSearch("Sicily", GeoQuery{Lon: 15, Lat: 37, Shape: GeoByBox, Width: 400000, Height: 400000})
+	if len(results) != 4 {
+		t.Errorf("Expected 4 results, got %d", len(results))
+	}
+
+	results = z.GeoSearch("Sicily", GeoQuery{Lon: 15, Lat: 37, Shape: GeoByBox, Width: 200000, Height: 200000})
+	if len(results) != 1 || results[0].Member != "Catania" {
+		t.Errorf("Expected only Catania, got %+v", results)
+	}
+}
+
+func TestGeoSearchMatchesFullScan(t *testing.T) {
+	z := CreateZSet()
+	points := map[string][2]float64{}
+	for i := 0; i < 2000; i++ {
+		lon := -180 + math.Mod(float64(i)*37.77, 360)
+		lat := -85 + math.Mod(float64(i)*13.13, 170)
+		name := "p" + string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676))
+		z.Geoadd("pts", ZAddFlags{}, lon, lat, name)
+		points[name] = [2]float64{lon, lat}
+	}
+
+	queries := []GeoQuery{
+		{Lon: 179.9, Lat: 10, Shape: GeoByRadius, Radius: 800000},
+		{Lon: 0, Lat: 80, Shape: GeoByRadius, Radius: 1500000},
+		{Lon: -45, Lat: -30, Shape: GeoByBox, Width: 3000000, Height: 1000000},
+	}
+	for _, q := range queries {
+		want := 0
+		for name := range points {
+			lon, lat, _ := z.Geopos("pts", name)
+			if _, ok := q.match(lon, lat); ok {
+				want++
+			}
+		}
+		if got := len(z.GeoSearch("pts", q)); got != want {
+			t.Errorf("Query %+v: expected %d results, got %d", q, want, got)
+		}
+	}
+}
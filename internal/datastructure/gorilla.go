@@ -0,0 +1,197 @@
+package datastructure
+
+import (
+	"math"
+	"math/bits"
+)
+
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.nbits%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (7 - w.nbits%8)
+	}
+	w.nbits++
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v>>(i-1)&1 == 1)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos uint
+}
+
+func (r *bitReader) readBit() bool {
+	bit := r.buf[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+	return bit
+}
+
+func (r *bitReader) readBits(n uint) uint64 {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+type gorillaChunk struct {
+	w        bitWriter
+	count    int
+	first    int64
+	last     int64
+	lastVal  float64
+	delta    int64
+	leading  uint8
+	trailing uint8
+}
+
+func (c *gorillaChunk) append(ts int64, value float64) {
+	switch c.count {
+	case 0:
+		c.w.writeBits(uint64(ts), 64)
+		c.w.writeBits(math.Float64bits(value), 64)
+		c.first = ts
+	case 1:
+		c.delta = ts - c.last
+		c.w.writeBits(uint64(c.delta), 64)
+		c.writeValue(value)
+	default:
+		delta := ts - c.last
+		c.writeDoD(delta - c.delta)
+		c.delta = delta
+		c.writeValue(value)
+	}
+	c.last = ts
+	c.lastVal = value
+	c.count++
+}
+
+func (c *gorillaChunk) writeDoD(dod int64) {
+	switch {
+	case dod == 0:
+		c.w.writeBit(false)
+	case dod >= -64 && dod <= 63:
+		c.w.writeBits(0b10, 2)
+		c.w.writeBits(uint64(dod), 7)
+	case dod >= -256 && dod <= 255:
+		c.w.writeBits(0b110, 3)
+		c.w.writeBits(uint64(dod), 9)
+	case dod >= -2048 && dod <= 2047:
+		c.w.writeBits(0b1110, 4)
+		c.w.writeBits(uint64(dod), 12)
+	default:
+		c.w.writeBits(0b1111, 4)
+		c.w.writeBits(uint64(dod), 64)
+	}
+}
+
+func (c *gorillaChunk) writeValue(value float64) {
+	xor := math.Float64bits(value) ^ math.Float64bits(c.lastVal)
+	if xor == 0 {
+		c.w.writeBit(false)
+		return
+	}
+	c.w.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+	if leading > 31 {
+		leading = 31
+	}
+	if c.count > 1 && leading >= c.leading && trailing >= c.trailing {
+		c.w.writeBit(false)
+		c.w.writeBits(xor>>c.trailing, uint(64-c.leading-c.trailing))
+		return
+	}
+	c.leading, c.trailing = leading, trailing
+	sigbits := 64 - leading - trailing
+	c.w.writeBit(true)
+	c.w.writeBits(uint64(leading), 5)
+	// 64 significant bits do not fit in 6 bits and are stored as 0.
+	c.w.writeBits(uint64(sigbits&63), 6)
+	c.w.writeBits(xor>>trailing, uint(sigbits))
+}
+
+func (c *gorillaChunk) size() int {
+	return len(c.w.buf)
+}
+
+func (c *gorillaChunk) samples() []Sample {
+	out := make([]Sample, 0, c.count)
+	if c.count == 0 {
+		return out
+	}
+	r := bitReader{buf: c.w.buf}
+	ts := int64(r.readBits(64))
+	bitsVal := r.readBits(64)
+	out = append(out, Sample{Timestamp: ts, Value: math.Float64frombits(bitsVal)})
+
+	var delta int64
+	var leading, trailing uint8
+	for i := 1; i < c.count; i++ {
+		if i == 1 {
+			delta = int64(r.readBits(64))
+		} else {
+			delta += readDoD(&r)
+		}
+		ts += delta
+
+		if r.readBit() {
+			if r.readBit() {
+				leading = uint8(r.readBits(5))
+				sigbits := uint8(r.readBits(6))
+				if sigbits == 0 {
+					sigbits = 64
+				}
+				trailing = 64 - leading - sigbits
+			}
+			xor := r.readBits(uint(64-leading-trailing)) << trailing
+			bitsVal ^= xor
+		}
+		out = append(out, Sample{Timestamp: ts, Value: math.Float64frombits(bitsVal)})
+	}
+	return out
+}
+
+func readDoD(r *bitReader) int64 {
+	var n uint
+	switch {
+	case !r.readBit():
+		return 0
+	case !r.readBit():
+		n = 7
+	case !r.readBit():
+		n = 9
+	case !r.readBit():
+		n = 12
+	default:
+		n = 64
+	}
+	v := r.readBits(n)
+	if n < 64 && v&(1<<(n-1)) != 0 {
+		v |= ^uint64(0) << n
+	}
+	return int64(v)
+}
+
+func encodeChunk(samples []Sample) *gorillaChunk {
+	c := &gorillaChunk{}
+	for _, s := range samples {
+		c.append(s.Timestamp, s.Value)
+	}
+	return c
+}
@@ -1,128 +1,265 @@
 package datastructure
 
-import "sync"
+type hashValue struct {
+	enc    string
+	pack   listpack
+	fields map[string]string
+}
+
+func newHashValue() *hashValue {
+	return &hashValue{enc: EncodingListpack}
+}
+
+func (v *hashValue) len() int {
+	if v.enc == EncodingListpack {
+		return v.pack.len() / 2
+	}
+	return len(v.fields)
+}
+
+func (v *hashValue) get(field string) (string, bool) {
+	if v.enc == EncodingListpack {
+		i := v.pack.index(field, 2)
+		if i < 0 {
+			return "", false
+		}
+		return v.pack.get(i + 1), true
+	}
+	val, ok := v.fields[field]
+	return val, ok
+}
+
+func (v *hashValue) each(fn func(field, value string) bool) {
+	if v.enc == EncodingListpack {
+		var field string
+		i := 0
+		v.pack.each(func(s string) bool {
+			i++
+			if i%2 == 1 {
+				field = s
+				return true
+			}
+			return fn(field, s)
+		})
+		return
+	}
+	for f, val := range v.fields {
+		if !fn(f, val) {
+			return
+		}
+	}
+}
+
+func (v *hashValue) set(field, value string, lim EncodingLimits) bool {
+	if v.enc == EncodingListpack {
+		i := v.pack.index(field, 2)
+		fits := len(field) <= lim.HashListpackValue && len(value) <= lim.HashListpackValue
+		if i >= 0 && fits {
+			v.pack.set(i+1, value)
+			return false
+		}
+		if i < 0 && fits && v.len() < lim.HashListpackEntries {
+			v.pack.pushBack(field)
+			v.pack.pushBack(value)
+			return true
+		}
+		v.grow()
+	}
+	_, exists := v.fields[field]
+	v.fields[field] = value
+	return !exists
+}
+
+func (v *hashValue) grow() {
+	fields := make(map[string]string, v.len()+1)
+	v.each(func(f, val string) bool {
+		fields[f] = val
+		return true
+	})
+	v.enc, v.pack, v.fields = EncodingHashtable, listpack{}, fields
+}
+
+func (v *hashValue) remove(field string) bool {
+	if v.enc == EncodingListpack {
+		i := v.pack.index(field, 2)
+		if i < 0 {
+			return false
+		}
+		v.pack.remove(i)
+		v.pack.remove(i)
+		return true
+	}
+	if _, ok := v.fields[field]; !ok {
+		return false
+	}
+	delete(v.fields, field)
+	return true
+}
 
 type HashMap struct {
-	mu    sync.RWMutex
-	items map[string]map[string]string
+	segs segments[*hashValue]
 }
 
 func CreateHashMap() *HashMap {
 	return &HashMap{
-		items: make(map[string]map[string]string),
+		segs: newSegments[*hashValue](GetSegmentCount()),
 	}
 }
 
 func (h *HashMap) Hset(key string, fieldValues ...string) int {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer h.segs.lock(key)()
 
 	if len(fieldValues)%2 != 0 {
 		return 0
 	}
 
-	if h.items[key] == nil {
-		h.items[key] = make(map[string]string)
+	hash, ok := h.segs.get(key)
+	if !ok {
+		hash = newHashValue()
+		h.segs.put(key, hash)
 	}
 
+	lim := GetEncodingLimits()
 	added := 0
 	for i := 0; i < len(fieldValues); i += 2 {
-		field := fieldValues[i]
-		value := fieldValues[i+1]
-		_, exists := h.items[key][field]
-		h.items[key][field] = value
-		if !exists {
+		if hash.set(fieldValues[i], fieldValues[i+1], lim) {
 			added++
 		}
 	}
+	if hash.len() == 0 {
+		h.segs.del(key)
+	}
 	return added
 }
 
 func (h *HashMap) Hget(key, field string) (string, bool) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	defer h.segs.rlock(key)()
 
-	hash, ok := h.items[key]
+	hash, ok := h.segs.get(key)
 	if !ok {
 		return "", false
 	}
-	val, ok := hash[field]
-	return val, ok
+	return hash.get(field)
 }
 
 func (h *HashMap) Hdel(key string, fields ...string) int {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	defer h.segs.lock(key)()
 
-	hash, ok := h.items[key]
+	hash, ok := h.segs.get(key)
 	if !ok {
 		return 0
 	}
 
 	count := 0
 	for _, field := range fields {
-		if _, exists := hash[field]; exists {
-			delete(hash, field)
+		if hash.remove(field) {
 			count++
 		}
 	}
 
-	if len(hash) == 0 {
-		delete(h.items, key)
+	if hash.len() == 0 {
+		h.segs.del(key)
 	}
 	return count
 }
 
 func (h *HashMap) Hgetall(key string) (map[string]string, bool) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	defer h.segs.rlock(key)()
 
-	hash, ok := h.items[key]
+	hash, ok := h.segs.get(key)
 	if !ok {
 		return nil, false
 	}
+	return hash.copy(), true
+}
 
-	result := make(map[string]string, len(hash))
-	for k, v := range hash {
-		result[k] = v
-	}
-	return result, true
+func (v *hashValue) copy() map[string]string {
+	result := make(map[string]string, v.len())
+	v.each(func(f, val string) bool {
+		result[f] = val
+		return true
+	})
+	return result
 }
 
 func (h *HashMap) Hexists(key, field string) bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	hash, ok := h.items[key]
-	if !ok {
-		return false
-	}
-	_, exists := hash[field]
-	return exists
+	_, ok := h.Hget(key, field)
+	return ok
 }
 
 func (h *HashMap) Hlen(key string) int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	defer h.segs.rlock(key)()
 
-	hash, ok := h.items[key]
+	hash, ok := h.segs.get(key)
 	if !ok {
 		return 0
 	}
-	return len(hash)
+	return hash.len()
 }
 
-func (h *HashMap) Dump() map[string]map[string]string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// Encoding returns the OBJECT ENCODING of the hash at key.
+func (h *HashMap) Encoding(key string) (string, bool) {
+	defer h.segs.rlock(key)()
 
-	snapshot := make(map[string]map[string]string, len(h.items))
-	for key, hash := range h.items {
-		hashCopy := make(map[string]string, len(hash))
-		for field, value := range hash {
-			hashCopy[field] = value
-		}
-		snapshot[key] = hashCopy
+	hash, ok := h.segs.get(key)
+	if !ok {
+		return "", false
 	}
-	return snapshot
+	return hash.enc, true
+}
+
+func dumpHash(key string, hash *hashValue) (map[string]string, bool) {
+	return hash.copy(), true
+}
+
+func (h *HashMap) snapshot() cowSegments[*hashValue, map[string]string] {
+	return cowSegments[*hashValue, map[string]string]{segs: h.segs, dump: dumpHash, usage: hashUsage}
+}
+
+func (h *HashMap) cow() cowStore {
+	return h.snapshot()
+}
+
+func (h *HashMap) Dump(keys ...string) map[string]map[string]string {
+	return h.snapshot().dumpKeys(keys)
+}
+
+func (h *HashMap) DumpSegment(i int) map[string]map[string]string {
+	return h.snapshot().dumpSegment(i)
+}
+
+func (h *HashMap) Keys() []string {
+	return h.segs.keys(nil)
+}
+
+func (h *HashMap) SegmentKeys(i int) []string {
+	return h.segs.segmentKeys(i, nil)
+}
+
+func (h *HashMap) Segments() int {
+	return len(h.segs)
+}
+
+func (h *HashMap) Len() int {
+	return h.segs.count()
+}
+
+func (h *HashMap) Sample(n int) []string {
+	return h.segs.sample(n)
+}
+
+func (h *HashMap) Exists(key string) bool {
+	return h.Hlen(key) > 0
+}
+
+func (h *HashMap) accessOf(key string) (*accessMap, func()) {
+	return h.segs.accessOf(key)
+}
+
+func (h *HashMap) Delete(keys ...string) int {
+	return h.segs.deleteKeys(keys)
+}
+
+func (h *HashMap) Flush() {
+	h.segs.flush()
 }
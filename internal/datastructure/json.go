@@ -0,0 +1,635 @@
+package datastructure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type JSONKind int
+
+const (
+	JSONNull JSONKind = iota
+	JSONBool
+	JSONNumber
+	JSONString
+	JSONArray
+	JSONObject
+)
+
+var (
+	ErrJSONSyntax       = errors.New("ERR expected value")
+	ErrJSONRootCreate   = errors.New("ERR new objects must be created at the root")
+	ErrJSONNoKey        = errors.New("ERR could not perform this operation on a key that doesn't exist")
+	ErrJSONNotNumber    = errors.New("ERR value is not a number")
+	ErrJSONNotArray     = errors.New("ERR value is not an array")
+	ErrJSONNotObject    = errors.New("ERR value is not an object")
+	ErrJSONIndexRange   = errors.New("ERR index out of bounds")
+	ErrJSONNumOverflow  = errors.New("ERR result is not a finite number")
+	ErrJSONPathNotFound = errors.New("ERR Path does not exist")
+)
+
+type jsonNode struct {
+	kind   JSONKind
+	b      bool
+	num    string
+	str    string
+	keys   []string
+	fields map[string]*jsonNode
+	elems  []*jsonNode
+}
+
+func parseJSON(text string) (*jsonNode, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	node, err := decodeJSONNode(dec)
+	if err != nil {
+		return nil, ErrJSONSyntax
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrJSONSyntax
+	}
+	return node, nil
+}
+
+func decodeJSONNode(dec *json.Decoder) (*jsonNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := tok.(type) {
+	case nil:
+		return &jsonNode{kind: JSONNull}, nil
+	case bool:
+		return &jsonNode{kind: JSONBool, b: v}, nil
+	case json.Number:
+		return &jsonNode{kind: JSONNumber, num: v.String()}, nil
+	case string:
+		return &jsonNode{kind: JSONString, str: v}, nil
+	case json.Delim:
+		switch v {
+		case '[':
+			node := &jsonNode{kind: JSONArray, elems: []*jsonNode{}}
+			for dec.More() {
+				elem, err := decodeJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.elems = append(node.elems, elem)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case '{':
+			node := &jsonNode{kind: JSONObject, fields: map[string]*jsonNode{}}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyTok.(string)
+				child, err := decodeJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.setField(key, child)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+	return nil, ErrJSONSyntax
+}
+
+func (n *jsonNode) setField(key string, child *jsonNode) {
+	if _, exists := n.fields[key]; !exists {
+		n.keys = append(n.keys, key)
+	}
+	n.fields[key] = child
+}
+
+func (n *jsonNode) deleteField(key string) bool {
+	if _, exists := n.fields[key]; !exists {
+		return false
+	}
+	delete(n.fields, key)
+	for i, k := range n.keys {
+		if k == key {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (n *jsonNode) String() string {
+	var buf bytes.Buffer
+	n.write(&buf)
+	return buf.String()
+}
+
+func (n *jsonNode) write(buf *bytes.Buffer) {
+	switch n.kind {
+	case JSONNull:
+		buf.WriteString("null")
+	case JSONBool:
+		buf.WriteString(strconv.FormatBool(n.b))
+	case JSONNumber:
+		buf.WriteString(n.num)
+	case JSONString:
+		writeJSONString(buf, n.str)
+	case JSONArray:
+		buf.WriteByte('[')
+		for i, elem := range n.elems {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			elem.write(buf)
+		}
+		buf.WriteByte(']')
+	case JSONObject:
+		buf.WriteByte('{')
+		for i, key := range n.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			n.fields[key].write(buf)
+		}
+		buf.WriteByte('}')
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	buf.Truncate(buf.Len() - 1)
+}
+
+func (n *jsonNode) clone() *jsonNode {
+	c := *n
+	if n.kind == JSONArray {
+		c.elems = make([]*jsonNode, len(n.elems))
+		for i, elem := range n.elems {
+			c.elems[i] = elem.clone()
+		}
+	}
+	if n.kind == JSONObject {
+		c.keys = append([]string(nil), n.keys...)
+		c.fields = make(map[string]*jsonNode, len(n.fields))
+		for k, v := range n.fields {
+			c.fields[k] = v.clone()
+		}
+	}
+	return &c
+}
+
+func addJSONNumbers(a, b string) (string, error) {
+	ai, errA := strconv.ParseInt(a, 10, 64)
+	bi, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		sum := ai + bi
+		if (bi > 0 && sum > ai) || (bi <= 0 && sum <= ai) {
+			return strconv.FormatInt(sum, 10), nil
+		}
+	}
+	af, errA := strconv.ParseFloat(a, 64)
+	bf, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil {
+		return "", ErrJSONNotNumber
+	}
+	sum := af + bf
+	if math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return "", ErrJSONNumOverflow
+	}
+	out := strconv.FormatFloat(sum, 'e', -1, 64)
+	if exp, _ := strconv.Atoi(out[strings.IndexByte(out, 'e')+1:]); sum == 0 || exp >= -5 && exp < 16 {
+		out = strconv.FormatFloat(sum, 'f', -1, 64)
+	}
+	out = strings.Replace(out, "e+", "e", 1)
+	if !strings.ContainsAny(out, ".eE") {
+		out += ".0"
+	}
+	return out, nil
+}
+
+type JSONStore struct {
+	segs segments[*jsonNode]
+}
+
+func CreateJSONStore() *JSONStore {
+	return &JSONStore{
+		segs: newSegments[*jsonNode](GetSegmentCount()),
+	}
+}
+
+// JSONSet writes value at path and reports whether anything was written; the
+// NX and XX conditions apply to the matched locations.
+func (j *JSONStore) JSONSet(key, path, value string, nx, xx bool) (bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return false, err
+	}
+	node, err := parseJSON(value)
+	if err != nil {
+		return false, err
+	}
+
+	defer j.segs.lock(key)()
+
+	root, exists := j.segs.get(key)
+	if len(p.segments) == 0 {
+		if (nx && exists) || (xx && !exists) {
+			return false, nil
+		}
+		j.segs.put(key, node)
+		return true, nil
+	}
+	if !exists {
+		return false, ErrJSONRootCreate
+	}
+
+	matches := p.eval(root)
+	if len(matches) > 0 {
+		if nx {
+			return false, nil
+		}
+		for _, m := range matches {
+			m.replace(node.clone())
+		}
+		return true, nil
+	}
+	if xx {
+		return false, nil
+	}
+
+	last := p.segments[len(p.segments)-1]
+	if last.kind != segField {
+		if p.legacy {
+			return false, ErrJSONPathNotFound
+		}
+		return false, nil
+	}
+	parentPath := jsonPath{segments: p.segments[:len(p.segments)-1], legacy: p.legacy}
+	written := false
+	for _, parent := range parentPath.eval(root) {
+		if parent.node.kind == JSONObject {
+			parent.node.setField(last.name, node.clone())
+			written = true
+		}
+	}
+	if !written && p.legacy {
+		return false, ErrJSONPathNotFound
+	}
+	return written, nil
+}
+
+// JSONGet serializes the values at the given paths.
+func (j *JSONStore) JSONGet(key string, paths ...string) (string, bool, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	parsed := make([]jsonPath, len(paths))
+	anyJSONPath := false
+	for i, path := range paths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return "", false, err
+		}
+		parsed[i] = p
+		anyJSONPath = anyJSONPath || !p.legacy
+	}
+
+	defer j.segs.rlock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return "", false, nil
+	}
+
+	render := func(p jsonPath, asArray bool) (*jsonNode, error) {
+		matches := p.eval(root)
+		if asArray {
+			arr := &jsonNode{kind: JSONArray, elems: make([]*jsonNode, 0, len(matches))}
+			for _, m := range matches {
+				arr.elems = append(arr.elems, m.node)
+			}
+			return arr, nil
+		}
+		if len(matches) == 0 {
+			return nil, ErrJSONPathNotFound
+		}
+		return matches[0].node, nil
+	}
+
+	if len(parsed) == 1 {
+		node, err := render(parsed[0], !parsed[0].legacy)
+		if err != nil {
+			return "", true, err
+		}
+		return node.String(), true, nil
+	}
+
+	out := &jsonNode{kind: JSONObject, fields: map[string]*jsonNode{}}
+	for i, p := range parsed {
+		node, err := render(p, anyJSONPath)
+		if err != nil {
+			return "", true, err
+		}
+		out.setField(paths[i], node)
+	}
+	return out.String(), true, nil
+}
+
+func (j *JSONStore) JSONDel(key, path string) (int, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer j.segs.lock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return 0, nil
+	}
+	if len(p.segments) == 0 {
+		j.segs.del(key)
+		return 1, nil
+	}
+
+	matches := p.eval(root)
+	// Remove array elements from the highest index down so earlier removals
+	// do not shift the positions of later ones.
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].index > matches[b].index })
+	count := 0
+	for _, m := range matches {
+		if m.remove() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// JSONNumIncrBy adds delta to every number at path and returns the new values
+// as JSON, with null for matches that are not numbers.
+func (j *JSONStore) JSONNumIncrBy(key, path, delta string) (string, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	deltaNode, err := parseJSON(delta)
+	if err != nil || deltaNode.kind != JSONNumber {
+		return "", ErrJSONNotNumber
+	}
+
+	defer j.segs.lock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return "", ErrJSONNoKey
+	}
+
+	matches := p.eval(root)
+	if p.legacy && len(matches) == 0 {
+		return "", ErrJSONPathNotFound
+	}
+	results := &jsonNode{kind: JSONArray, elems: []*jsonNode{}}
+	for _, m := range matches {
+		if m.node.kind != JSONNumber {
+			if p.legacy {
+				return "", ErrJSONNotNumber
+			}
+			results.elems = append(results.elems, &jsonNode{kind: JSONNull})
+			continue
+		}
+		sum, err := addJSONNumbers(m.node.num, deltaNode.num)
+		if err != nil {
+			return "", err
+		}
+		results.elems = append(results.elems, &jsonNode{kind: JSONNumber, num: sum})
+	}
+	for i, m := range matches {
+		if m.node.kind == JSONNumber {
+			m.node.num = results.elems[i].num
+		}
+	}
+	if p.legacy {
+		return results.elems[len(results.elems)-1].String(), nil
+	}
+	return results.String(), nil
+}
+
+// JSONArrInsert inserts values before index in every array at path.
+func (j *JSONStore) JSONArrInsert(key, path string, index int, appendMode bool, values ...string) ([]*int, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	nodes := make([]*jsonNode, len(values))
+	for i, v := range values {
+		if nodes[i], err = parseJSON(v); err != nil {
+			return nil, false, err
+		}
+	}
+
+	defer j.segs.lock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return nil, p.legacy, ErrJSONNoKey
+	}
+
+	matches := p.eval(root)
+	if p.legacy && len(matches) == 0 {
+		return nil, true, ErrJSONPathNotFound
+	}
+	positions := make([]int, len(matches))
+	for i, m := range matches {
+		if m.node.kind != JSONArray {
+			if p.legacy {
+				return nil, true, ErrJSONNotArray
+			}
+			continue
+		}
+		pos := index
+		if appendMode {
+			pos = len(m.node.elems)
+		} else if pos < 0 {
+			pos += len(m.node.elems)
+		}
+		if pos < 0 || pos > len(m.node.elems) {
+			return nil, p.legacy, ErrJSONIndexRange
+		}
+		positions[i] = pos
+	}
+	lengths := make([]*int, 0, len(matches))
+	for i, m := range matches {
+		if m.node.kind != JSONArray {
+			lengths = append(lengths, nil)
+			continue
+		}
+		pos := positions[i]
+		inserted := make([]*jsonNode, 0, len(m.node.elems)+len(nodes))
+		inserted = append(inserted, m.node.elems[:pos]...)
+		for _, n := range nodes {
+			inserted = append(inserted, n.clone())
+		}
+		inserted = append(inserted, m.node.elems[pos:]...)
+		m.node.elems = inserted
+		n := len(inserted)
+		lengths = append(lengths, &n)
+	}
+	return lengths, p.legacy, nil
+}
+
+// JSONArrPop removes and returns the element at index from every array at
+// path, with nil for matches that are not arrays or are empty.
+func (j *JSONStore) JSONArrPop(key, path string, index int) ([]*string, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer j.segs.lock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return nil, p.legacy, ErrJSONNoKey
+	}
+
+	matches := p.eval(root)
+	if p.legacy && len(matches) == 0 {
+		return nil, true, ErrJSONPathNotFound
+	}
+	popped := make([]*string, 0, len(matches))
+	for _, m := range matches {
+		if m.node.kind != JSONArray {
+			if p.legacy {
+				return nil, true, ErrJSONNotArray
+			}
+			popped = append(popped, nil)
+			continue
+		}
+		size := len(m.node.elems)
+		if size == 0 {
+			popped = append(popped, nil)
+			continue
+		}
+		pos := index
+		if pos < 0 {
+			pos += size
+		}
+		if pos < 0 {
+			pos = 0
+		}
+		if pos >= size {
+			pos = size - 1
+		}
+		text := m.node.elems[pos].String()
+		m.node.elems = append(m.node.elems[:pos], m.node.elems[pos+1:]...)
+		popped = append(popped, &text)
+	}
+	return popped, p.legacy, nil
+}
+
+// JSONObjKeys lists the keys of every object at path, with nil for matches
+// that are not objects.
+func (j *JSONStore) JSONObjKeys(key, path string) ([][]string, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer j.segs.rlock(key)()
+
+	root, exists := j.segs.get(key)
+	if !exists {
+		return nil, p.legacy, nil
+	}
+
+	matches := p.eval(root)
+	if p.legacy && len(matches) == 0 {
+		return nil, true, ErrJSONPathNotFound
+	}
+	keys := make([][]string, 0, len(matches))
+	for _, m := range matches {
+		if m.node.kind != JSONObject {
+			if p.legacy {
+				return nil, true, ErrJSONNotObject
+			}
+			keys = append(keys, nil)
+			continue
+		}
+		keys = append(keys, append([]string{}, m.node.keys...))
+	}
+	return keys, p.legacy, nil
+}
+
+func dumpJSON(key string, node *jsonNode) (string, bool) {
+	return node.String(), true
+}
+
+func (j *JSONStore) snapshot() cowSegments[*jsonNode, string] {
+	return cowSegments[*jsonNode, string]{segs: j.segs, dump: dumpJSON, usage: jsonUsage}
+}
+
+func (j *JSONStore) cow() cowStore {
+	return j.snapshot()
+}
+
+func (j *JSONStore) Dump(keys ...string) map[string]string {
+	return j.snapshot().dumpKeys(keys)
+}
+
+func (j *JSONStore) DumpSegment(i int) map[string]string {
+	return j.snapshot().dumpSegment(i)
+}
+
+func (j *JSONStore) Keys() []string {
+	return j.segs.keys(nil)
+}
+
+func (j *JSONStore) SegmentKeys(i int) []string {
+	return j.segs.segmentKeys(i, nil)
+}
+
+func (j *JSONStore) Segments() int {
+	return len(j.segs)
+}
+
+func (j *JSONStore) Len() int {
+	return j.segs.count()
+}
+
+func (j *JSONStore) Sample(n int) []string {
+	return j.segs.sample(n)
+}
+
+func (j *JSONStore) Exists(key string) bool {
+	defer j.segs.rlock(key)()
+	_, ok := j.segs.get(key)
+	return ok
+}
+
+func (j *JSONStore) accessOf(key string) (*accessMap, func()) {
+	return j.segs.accessOf(key)
+}
+
+func (j *JSONStore) Delete(keys ...string) int {
+	return j.segs.deleteKeys(keys)
+}
+
+func (j *JSONStore) Flush() {
+	j.segs.flush()
+}
@@ -0,0 +1,165 @@
+package datastructure
+
+import (
+	"testing"
+)
+
+func TestJSONSetGet(t *testing.T) {
+	j := CreateJSONStore()
+
+	if ok, err := j.JSONSet("doc", "$", `{"name":"Leonard","age":29,"tags":["a","b"]}`, false, false); !ok || err != nil {
+		t.Fatalf("Expected set to succeed, got %v %v", ok, err)
+	}
+	if got, _, _ := j.JSONGet("doc"); got != `{"name":"Leonard","age":29,"tags":["a","b"]}` {
+		t.Errorf("Unexpected document %s", got)
+	}
+	if got, _, _ := j.JSONGet("doc", ".name"); got != `"Leonard"` {
+		t.Errorf("Expected legacy path to return bare value, got %s", got)
+	}
+	if got, _, _ := j.JSONGet("doc", "$.tags[*]"); got != `["a","b"]` {
+		t.Errorf("Expected wildcard matches, got %s", got)
+	}
+	if got, _, _ := j.JSONGet("doc", "$.name", "$.age"); got != `{"$.name":["Leonard"],"$.age":[29]}` {
+		t.Errorf("Unexpected multi-path result %s", got)
+	}
+	if _, ok, _ := j.JSONGet("missing"); ok {
+		t.Error("Expected missing key to report not found")
+	}
+
+	if _, err := j.JSONSet("new", "$.a", `1`, false, false); err != ErrJSONRootCreate {
+		t.Errorf("Expected root create error, got %v", err)
+	}
+	if ok, _ := j.JSONSet("doc", "$.name", `"x"`, true, false); ok {
+		t.Error("NX should not overwrite existing path")
+	}
+	if ok, _ := j.JSONSet("doc", "$.city", `"x"`, false, true); ok {
+		t.Error("XX should not create missing path")
+	}
+	j.JSONSet("doc", "$.city", `"Paris"`, false, false)
+	if got, _, _ := j.JSONGet("doc", "$.city"); got != `["Paris"]` {
+		t.Errorf("Expected new field, got %s", got)
+	}
+	if _, err := j.JSONSet("doc", "$", `{bad`, false, false); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+
+	j.JSONSet("html", "$", `{"<a>":"x < y && y > z"}`, false, false)
+	if got, _, _ := j.JSONGet("html"); got != `{"<a>":"x < y && y > z"}` {
+		t.Errorf("Expected <, > and & left unescaped, got %s", got)
+	}
+}
+
+func TestJSONRecursivePath(t *testing.T) {
+	j := CreateJSONStore()
+	j.JSONSet("doc", "$", `{"a":{"price":1},"b":[{"price":2},{"price":"x"}]}`, false, false)
+
+	if got, _, _ := j.JSONGet("doc", "$..price"); got != `[1,2,"x"]` {
+		t.Errorf("Unexpected recursive matches %s", got)
+	}
+	if got, _ := j.JSONNumIncrBy("doc", "$..price", "10"); got != `[11,12,null]` {
+		t.Errorf("Unexpected increment result %s", got)
+	}
+	if got, _ := j.JSONNumIncrBy("doc", ".a.price", "0.5"); got != "11.5" {
+		t.Errorf("Expected 11.5, got %s", got)
+	}
+	if _, err := j.JSONNumIncrBy("doc", ".b", "1"); err != ErrJSONNotNumber {
+		t.Errorf("Expected not a number error, got %v", err)
+	}
+
+	j.JSONSet("nums", "$", `{"a":{"v":1},"b":{"v":1.7976931348623157e308}}`, false, false)
+	if _, err := j.JSONNumIncrBy("nums", "$..v", "1.7976931348623157e308"); err != ErrJSONNumOverflow {
+		t.Errorf("Expected overflow error, got %v", err)
+	}
+	if got, _, _ := j.JSONGet("nums", "$..v"); got != `[1,1.7976931348623157e308]` {
+		t.Errorf("Expected a failed increment to change nothing, got %s", got)
+	}
+	if got, _ := j.JSONNumIncrBy("nums", ".b.v", "-1e292"); got != "1.7976931348623155e308" {
+		t.Errorf("Expected a large result in exponent form, got %s", got)
+	}
+
+	if n, _ := j.JSONDel("doc", "$..price"); n != 3 {
+		t.Errorf("Expected 3 deleted, got %d", n)
+	}
+	if got, _, _ := j.JSONGet("doc"); got != `{"a":{},"b":[{},{}]}` {
+		t.Errorf("Unexpected document after delete %s", got)
+	}
+	if n, _ := j.JSONDel("doc", "$"); n != 1 {
+		t.Errorf("Expected root delete, got %d", n)
+	}
+	if _, ok, _ := j.JSONGet("doc"); ok {
+		t.Error("Expected key to be removed")
+	}
+}
+
+func TestJSONArrays(t *testing.T) {
+	j := CreateJSONStore()
+	j.JSONSet("doc", "$", `{"a":[1,2],"b":"s"}`, false, false)
+
+	lengths, legacy, _ := j.JSONArrInsert("doc", "$.*", 0, true, `3`)
+	if legacy || len(lengths) != 2 || *lengths[0] != 3 || lengths[1] != nil {
+		t.Errorf("Unexpected append result %v", lengths)
+	}
+	lengths, _, _ = j.JSONArrInsert("doc", ".a", 1, false, `"x"`, `null`)
+	if *lengths[0] != 5 {
+		t.Errorf("Expected length 5, got %d", *lengths[0])
+	}
+	if got, _, _ := j.JSONGet("doc", ".a"); got != `[1,"x",null,2,3]` {
+		t.Errorf("Unexpected array %s", got)
+	}
+	if _, _, err := j.JSONArrInsert("doc", ".a", 9, false, `1`); err != ErrJSONIndexRange {
+		t.Errorf("Expected index error, got %v", err)
+	}
+
+	j.JSONSet("nested", "$", `{"a":[1,2,3,4,5,6],"b":{"a":[1]}}`, false, false)
+	if _, _, err := j.JSONArrInsert("nested", "$..a", 4, false, `9`); err != ErrJSONIndexRange {
+		t.Errorf("Expected index error, got %v", err)
+	}
+	if got, _, _ := j.JSONGet("nested"); got != `{"a":[1,2,3,4,5,6],"b":{"a":[1]}}` {
+		t.Errorf("Expected a failed insert to change nothing, got %s", got)
+	}
+
+	popped, _, _ := j.JSONArrPop("doc", "$.a", -1)
+	if *popped[0] != "3" {
+		t.Errorf("Expected 3, got %s", *popped[0])
+	}
+	popped, _, _ = j.JSONArrPop("doc", ".a", 100)
+	if *popped[0] != "2" {
+		t.Errorf("Expected out of range index to pop last, got %s", *popped[0])
+	}
+	popped, _, _ = j.JSONArrPop("doc", "$.b", -1)
+	if popped[0] != nil {
+		t.Error("Expected nil for non-array match")
+	}
+}
+
+func TestJSONObjKeys(t *testing.T) {
+	j := CreateJSONStore()
+	j.JSONSet("doc", "$", `{"z":1,"a":{"k":true},"m":null}`, false, false)
+
+	keys, legacy, _ := j.JSONObjKeys("doc", "$")
+	if legacy || len(keys) != 1 || len(keys[0]) != 3 || keys[0][0] != "z" || keys[0][2] != "m" {
+		t.Errorf("Expected insertion order keys, got %v", keys)
+	}
+	keys, _, _ = j.JSONObjKeys("doc", "$.*")
+	if keys[0] != nil || keys[1][0] != "k" || keys[2] != nil {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	if _, _, err := j.JSONObjKeys("doc", ".z"); err != ErrJSONNotObject {
+		t.Errorf("Expected not an object error, got %v", err)
+	}
+}
+
+func TestJSONPathParse(t *testing.T) {
+	valid := []string{"$", ".", "a", ".a.b", "$['a b'][0]", "$.a[-1]", "$..*", "$.*"}
+	for _, p := range valid {
+		if _, err := parseJSONPath(p); err != nil {
+			t.Errorf("Expected %q to parse, got %v", p, err)
+		}
+	}
+	invalid := []string{"$.", "$[x]", "$['a'", "$..", "$a"}
+	for _, p := range invalid {
+		if _, err := parseJSONPath(p); err == nil {
+			t.Errorf("Expected %q to fail", p)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package datastructure
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrJSONPath = errors.New("ERR invalid JSONPath")
+
+type segmentKind int
+
+const (
+	segField segmentKind = iota
+	segIndex
+	segWildcard
+	segRecursive
+	segRecursiveWildcard
+)
+
+type pathSegment struct {
+	kind  segmentKind
+	name  string
+	index int
+}
+
+type jsonPath struct {
+	segments []pathSegment
+	legacy   bool
+}
+
+type jsonMatch struct {
+	node   *jsonNode
+	parent *jsonNode
+	key    string
+	index  int
+}
+
+func parseJSONPath(path string) (jsonPath, error) {
+	p := jsonPath{}
+	rest := path
+	switch {
+	case strings.HasPrefix(path, "$"):
+		rest = path[1:]
+	case path == ".":
+		p.legacy = true
+		rest = ""
+	case strings.HasPrefix(path, ".") || strings.HasPrefix(path, "["):
+		p.legacy = true
+	default:
+		p.legacy = true
+		rest = "." + path
+	}
+
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "*") {
+				p.segments = append(p.segments, pathSegment{kind: segRecursiveWildcard})
+				rest = rest[1:]
+				continue
+			}
+			name, n := scanPathName(rest)
+			if n == 0 {
+				return jsonPath{}, ErrJSONPath
+			}
+			p.segments = append(p.segments, pathSegment{kind: segRecursive, name: name})
+			rest = rest[n:]
+		case strings.HasPrefix(rest, ".*"):
+			p.segments = append(p.segments, pathSegment{kind: segWildcard})
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			name, n := scanPathName(rest[1:])
+			if n == 0 {
+				return jsonPath{}, ErrJSONPath
+			}
+			p.segments = append(p.segments, pathSegment{kind: segField, name: name})
+			rest = rest[1+n:]
+		case strings.HasPrefix(rest, "["):
+			seg, n, err := scanPathBracket(rest)
+			if err != nil {
+				return jsonPath{}, err
+			}
+			p.segments = append(p.segments, seg)
+			rest = rest[n:]
+		default:
+			return jsonPath{}, ErrJSONPath
+		}
+	}
+	return p, nil
+}
+
+func scanPathName(s string) (string, int) {
+	n := 0
+	for n < len(s) && s[n] != '.' && s[n] != '[' {
+		n++
+	}
+	return s[:n], n
+}
+
+func scanPathBracket(s string) (pathSegment, int, error) {
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return pathSegment{}, 0, ErrJSONPath
+	}
+	inner := s[1:end]
+	if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') {
+		quote := inner[0]
+		closing := strings.IndexByte(s[2:], quote)
+		if closing < 0 {
+			return pathSegment{}, 0, ErrJSONPath
+		}
+		name := s[2 : 2+closing]
+		after := 2 + closing + 1
+		if after >= len(s) || s[after] != ']' {
+			return pathSegment{}, 0, ErrJSONPath
+		}
+		return pathSegment{kind: segField, name: name}, after + 1, nil
+	}
+	if inner == "*" {
+		return pathSegment{kind: segWildcard}, end + 1, nil
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(inner))
+	if err != nil {
+		return pathSegment{}, 0, ErrJSONPath
+	}
+	return pathSegment{kind: segIndex, index: index}, end + 1, nil
+}
+
+func (p jsonPath) eval(root *jsonNode) []jsonMatch {
+	current := []jsonMatch{{node: root, index: -1}}
+	for _, seg := range p.segments {
+		next := []jsonMatch{}
+		for _, m := range current {
+			next = seg.apply(m.node, next)
+		}
+		current = next
+	}
+	return current
+}
+
+func (seg pathSegment) apply(node *jsonNode, out []jsonMatch) []jsonMatch {
+	switch seg.kind {
+	case segField:
+		if node.kind == JSONObject {
+			if child, ok := node.fields[seg.name]; ok {
+				out = append(out, jsonMatch{node: child, parent: node, key: seg.name, index: -1})
+			}
+		}
+	case segIndex:
+		if node.kind == JSONArray {
+			idx := seg.index
+			if idx < 0 {
+				idx += len(node.elems)
+			}
+			if idx >= 0 && idx < len(node.elems) {
+				out = append(out, jsonMatch{node: node.elems[idx], parent: node, index: idx})
+			}
+		}
+	case segWildcard:
+		out = appendChildren(node, out)
+	case segRecursive:
+		field := pathSegment{kind: segField, name: seg.name}
+		out = field.apply(node, out)
+		for _, child := range appendChildren(node, nil) {
+			out = seg.apply(child.node, out)
+		}
+	case segRecursiveWildcard:
+		for _, child := range appendChildren(node, nil) {
+			out = append(out, child)
+			out = seg.apply(child.node, out)
+		}
+	}
+	return out
+}
+
+func appendChildren(node *jsonNode, out []jsonMatch) []jsonMatch {
+	switch node.kind {
+	case JSONObject:
+		for _, k := range node.keys {
+			out = append(out, jsonMatch{node: node.fields[k], parent: node, key: k, index: -1})
+		}
+	case JSONArray:
+		for i, elem := range node.elems {
+			out = append(out, jsonMatch{node: elem, parent: node, index: i})
+		}
+	}
+	return out
+}
+
+func (m jsonMatch) replace(value *jsonNode) {
+	*m.node = *value
+}
+
+func (m jsonMatch) remove() bool {
+	if m.parent == nil {
+		return false
+	}
+	if m.parent.kind == JSONObject {
+		return m.parent.deleteField(m.key)
+	}
+	if m.index < 0 || m.index >= len(m.parent.elems) || m.parent.elems[m.index] != m.node {
+		return false
+	}
+	m.parent.elems = append(m.parent.elems[:m.index], m.parent.elems[m.index+1:]...)
+	return true
+}
@@ -0,0 +1,278 @@
+package datastructure
+
+import (
+	"hash/maphash"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+var segmentSeed = maphash.MakeSeed()
+
+var executor func(fn func())
+
+// SetExecutor makes stores created afterwards single-threaded. Call it
+// before creating stores.
+func SetExecutor(run func(fn func())) {
+	executor = run
+}
+
+func tick(interval time.Duration, fn func()) {
+	run := executor
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if run != nil {
+				run(fn)
+			} else {
+				fn()
+			}
+		}
+	}()
+}
+
+type segment[V any] struct {
+	mu    sync.RWMutex
+	items map[string]V
+	// expires indexes the keys with a TTL, in stores that have TTLs.
+	expires *expiryIndex
+	// cow keeps old values for a snapshot that has yet to write this
+	// segment; it is nil otherwise.
+	cow *cowState[V]
+	// exec is set on single-threaded segments, which are only used from
+	// the executor and skip mu.
+	exec func(fn func())
+	// access records key use for eviction.
+	access accessMap
+}
+
+func newSegment[V any]() *segment[V] {
+	return &segment[V]{items: make(map[string]V), expires: newExpiryIndex(), exec: executor}
+}
+
+func (seg *segment[V]) lock() {
+	if seg.exec == nil {
+		seg.mu.Lock()
+	}
+}
+
+func (seg *segment[V]) unlock() {
+	if seg.exec == nil {
+		seg.mu.Unlock()
+	}
+}
+
+func (seg *segment[V]) rlock() {
+	if seg.exec == nil {
+		seg.mu.RLock()
+	}
+}
+
+func (seg *segment[V]) runlock() {
+	if seg.exec == nil {
+		seg.mu.RUnlock()
+	}
+}
+
+func (seg *segment[V]) exclusive(fn func()) {
+	if seg.exec != nil {
+		seg.exec(fn)
+		return
+	}
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	fn()
+}
+
+type segments[V any] []*segment[V]
+
+func newSegments[V any](n int) segments[V] {
+	segs := make(segments[V], n)
+	for i := range segs {
+		segs[i] = newSegment[V]()
+	}
+	return segs
+}
+
+func (segs segments[V]) index(key string) int {
+	return int(maphash.String(segmentSeed, key) & uint64(len(segs)-1))
+}
+
+func (segs segments[V]) of(key string) *segment[V] {
+	return segs[segs.index(key)]
+}
+
+// get, put and del need the lock of key's segment.
+func (segs segments[V]) get(key string) (V, bool) {
+	v, ok := segs.of(key).items[key]
+	return v, ok
+}
+
+func (segs segments[V]) put(key string, v V) {
+	segs.of(key).items[key] = v
+}
+
+func (segs segments[V]) del(key string) {
+	seg := segs.of(key)
+	delete(seg.items, key)
+	seg.access.forget(key)
+	seg.expires.remove(key)
+}
+
+// Multi-key operations lock segments in this order, so they cannot deadlock.
+func (segs segments[V]) indexes(keys []string) []int {
+	idx := make([]int, len(keys))
+	for i, key := range keys {
+		idx[i] = segs.index(key)
+	}
+	slices.Sort(idx)
+	return slices.Compact(idx)
+}
+
+func (segs segments[V]) lock(keys ...string) func() {
+	if segs[0].exec != nil {
+		segs.preserve(keys)
+		return func() {}
+	}
+	idx := segs.indexes(keys)
+	for _, i := range idx {
+		segs[i].lock()
+	}
+	segs.preserve(keys)
+	return func() {
+		for _, i := range slices.Backward(idx) {
+			segs[i].unlock()
+		}
+	}
+}
+
+func (segs segments[V]) rlock(keys ...string) func() {
+	if segs[0].exec != nil {
+		return func() {}
+	}
+	idx := segs.indexes(keys)
+	for _, i := range idx {
+		segs[i].rlock()
+	}
+	return func() {
+		for _, i := range slices.Backward(idx) {
+			segs[i].runlock()
+		}
+	}
+}
+
+func (segs segments[V]) each(fn func(key string, v V)) {
+	for _, seg := range segs {
+		seg.rlock()
+		for key, v := range seg.items {
+			fn(key, v)
+		}
+		seg.runlock()
+	}
+}
+
+func (segs segments[V]) count() int {
+	n := 0
+	for _, seg := range segs {
+		seg.rlock()
+		n += len(seg.items)
+		seg.runlock()
+	}
+	return n
+}
+
+func (segs segments[V]) forKeys(keys []string, fn func(key string, v V)) {
+	if len(keys) == 0 {
+		segs.each(fn)
+		return
+	}
+	for _, key := range keys {
+		seg := segs.of(key)
+		seg.rlock()
+		if v, ok := seg.items[key]; ok {
+			fn(key, v)
+		}
+		seg.runlock()
+	}
+}
+
+func (segs segments[V]) keys(live func(V) bool) []string {
+	var keys []string
+	segs.each(func(key string, v V) {
+		if live == nil || live(v) {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+func (segs segments[V]) segmentKeys(i int, live func(V) bool) []string {
+	if i < 0 || i >= len(segs) {
+		return nil
+	}
+	seg := segs[i]
+	seg.rlock()
+	defer seg.runlock()
+	keys := make([]string, 0, len(seg.items))
+	for key, v := range seg.items {
+		if live == nil || live(v) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (segs segments[V]) deleteKeys(keys []string) int {
+	defer segs.lock(keys...)()
+	count := 0
+	for _, key := range keys {
+		if _, ok := segs.get(key); ok {
+			segs.del(key)
+			count++
+		}
+	}
+	return count
+}
+
+func sampleKeys[V any](items map[string]V, n int) []string {
+	keys := make([]string, 0, min(n, len(items)))
+	for key := range items {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (segs segments[V]) sample(n int) []string {
+	keys := make([]string, 0, n)
+	start := rand.IntN(len(segs))
+	for i := range segs {
+		if len(keys) >= n {
+			break
+		}
+		seg := segs[(start+i)%len(segs)]
+		seg.rlock()
+		keys = append(keys, sampleKeys(seg.items, n-len(keys))...)
+		seg.runlock()
+	}
+	return keys
+}
+
+func (segs segments[V]) flush() {
+	for _, seg := range segs {
+		seg.lock()
+	}
+	for _, seg := range slices.Backward(segs) {
+		for key := range seg.items {
+			seg.preserve(key)
+		}
+		seg.items = make(map[string]V)
+		seg.access.reset()
+		seg.expires = newExpiryIndex()
+		seg.unlock()
+	}
+}
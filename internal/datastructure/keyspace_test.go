@@ -0,0 +1,179 @@
+package datastructure
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSegmentIndexes(t *testing.T) {
+	segs := newSegments[int](16)
+	keys := []string{"a", "b", "c", "a", "d", "e", "f", "g"}
+	idx := segs.indexes(keys)
+	if !slices.IsSorted(idx) || len(slices.Compact(slices.Clone(idx))) != len(idx) {
+		t.Errorf("Expected sorted distinct indexes, got %v", idx)
+	}
+	for _, key := range keys {
+		if _, found := slices.BinarySearch(idx, segs.index(key)); !found {
+			t.Errorf("Segment of %q is missing", key)
+		}
+	}
+	if GetSegmentCount() != 16 {
+		t.Errorf("Expected 16 segments by default, got %d", GetSegmentCount())
+	}
+}
+
+func TestSegmentKeys(t *testing.T) {
+	d := CreateDict()
+	want := make([]string, 100)
+	for i := range want {
+		want[i] = "key" + strconv.Itoa(i)
+		d.Set(want[i], "v", 0)
+	}
+	d.Set("gone", "v", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	var got []string
+	for i := range d.Segments() {
+		got = append(got, d.SegmentKeys(i)...)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("Segments hold %d live keys, want %d", len(got), len(want))
+	}
+	if d.SegmentKeys(d.Segments()) != nil {
+		t.Error("Out of range segment should be empty")
+	}
+}
+
+// TestMultiKeyLockOrder runs multi-key operations naming the same keys in
+// opposite orders; they would deadlock without a fixed lock order.
+func TestMultiKeyLockOrder(t *testing.T) {
+	d := CreateDict()
+	keys := make([]string, 32)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+		d.Set(keys[i], "\xff", 0)
+	}
+	reversed := slices.Clone(keys)
+	slices.Reverse(reversed)
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for w := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				order := keys
+				if w%2 == 1 {
+					order = reversed
+				}
+				for range 200 {
+					d.BitOp("OR", order[0], order[1:]...)
+					d.Delete(order[len(order)-1], order[0])
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Multi-key operations deadlocked")
+	}
+}
+
+func TestTimeSeriesLinkedLocking(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSCreate("src", TSOptions{})
+	ts.TSCreate("dest", TSOptions{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 500 {
+			ts.TSAdd("src", int64(i), 1, nil, DuplicateLast)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			ts.TSCreateRule("src", "dest", Aggregation{Type: AggSum, Bucket: 10})
+			ts.TSDeleteRule("src", "dest")
+		}
+	}()
+	wg.Wait()
+
+	ts.TSCreateRule("src", "dest", Aggregation{Type: AggSum, Bucket: 10})
+	ts.TSAdd("src", 1000, 1, nil, DuplicateLast)
+	ts.TSAdd("src", 1010, 1, nil, DuplicateLast)
+	if s, ok, _ := ts.TSGet("dest"); !ok || s.Timestamp != 1000 {
+		t.Errorf("Expected the 1000 bucket compacted, got %v %v", s, ok)
+	}
+	if ts.Delete("dest") != 1 {
+		t.Fatal("Expected dest deleted")
+	}
+	if info, _ := ts.TSInfo("src"); len(info.Rules) != 0 {
+		t.Errorf("Deleting dest should drop the rule, got %v", info.Rules)
+	}
+}
+
+func benchmarkParallel(b *testing.B, op func(d *Dict, key string)) {
+	for _, n := range []int{1, 16, 64} {
+		b.Run("segments="+strconv.Itoa(n), func(b *testing.B) {
+			d := &Dict{segs: newSegments[Item](n)}
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = "key:" + strconv.Itoa(i)
+				d.Set(keys[i], "v", 0)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					op(d, keys[i%len(keys)])
+					i += 7
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkDictSetParallel(b *testing.B) {
+	benchmarkParallel(b, func(d *Dict, key string) { d.Set(key, "value", 0) })
+}
+
+func BenchmarkDictIncrParallel(b *testing.B) {
+	benchmarkParallel(b, func(d *Dict, key string) { d.IncrBy(key+":n", 1) })
+}
+
+func BenchmarkDictMixedParallel(b *testing.B) {
+	benchmarkParallel(b, func(d *Dict, key string) {
+		if len(key)%4 == 0 {
+			d.Set(key, "value", 0)
+		} else {
+			d.Get(key)
+		}
+	})
+}
+
+func BenchmarkHashSetParallel(b *testing.B) {
+	for _, n := range []int{1, 16, 64} {
+		b.Run("segments="+strconv.Itoa(n), func(b *testing.B) {
+			h := &HashMap{segs: newSegments[*hashValue](n)}
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					h.Hset("hash:"+strconv.Itoa(i%1024), "f", "v")
+					i += 7
+				}
+			})
+		})
+	}
+}
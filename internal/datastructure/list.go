@@ -1,158 +1,242 @@
 package datastructure
 
 import (
+	"sort"
 	"strconv"
-	"sync"
 )
 
+type listValue struct {
+	enc  string
+	pack listpack
+	dq   *Deque[string]
+}
+
+func newListValue() *listValue {
+	return &listValue{enc: EncodingListpack}
+}
+
+func (v *listValue) len() int {
+	if v.enc == EncodingListpack {
+		return v.pack.len()
+	}
+	return v.dq.size
+}
+
+func (v *listValue) fits(value string, lim EncodingLimits) bool {
+	return v.pack.len() < lim.ListListpackEntries && len(value) <= lim.ListListpackValue
+}
+
+func (v *listValue) grow() {
+	dq := NewDeque[string]()
+	v.pack.each(func(s string) bool {
+		dq.PushBack(s)
+		return true
+	})
+	v.enc, v.pack, v.dq = EncodingQuicklist, listpack{}, dq
+}
+
+func (v *listValue) pushFront(value string, lim EncodingLimits) {
+	if v.enc == EncodingListpack && !v.fits(value, lim) {
+		v.grow()
+	}
+	if v.enc == EncodingListpack {
+		v.pack.pushFront(value)
+		return
+	}
+	v.dq.PushFront(value)
+}
+
+func (v *listValue) pushBack(value string, lim EncodingLimits) {
+	if v.enc == EncodingListpack && !v.fits(value, lim) {
+		v.grow()
+	}
+	if v.enc == EncodingListpack {
+		v.pack.pushBack(value)
+		return
+	}
+	v.dq.PushBack(value)
+}
+
+func (v *listValue) popFront() (string, bool) {
+	if v.enc == EncodingListpack {
+		if v.pack.len() == 0 {
+			return "", false
+		}
+		return v.pack.remove(0), true
+	}
+	return v.dq.PopFront()
+}
+
+func (v *listValue) popBack() (string, bool) {
+	if v.enc == EncodingListpack {
+		if v.pack.len() == 0 {
+			return "", false
+		}
+		return v.pack.remove(v.pack.len() - 1), true
+	}
+	return v.dq.PopBack()
+}
+
+func (v *listValue) items(start, stop int) []Item {
+	items := make([]Item, 0, stop-start+1)
+	if v.enc == EncodingListpack {
+		i := 0
+		v.pack.each(func(s string) bool {
+			if i >= start {
+				items = append(items, Item{Value: s})
+			}
+			i++
+			return i <= stop
+		})
+		return items
+	}
+	for i := start; i <= stop; i++ {
+		items = append(items, Item{Value: v.dq.items[(v.dq.head+i)%v.dq.capacity]})
+	}
+	return items
+}
+
+func (v *listValue) sort(less func(a, b string) bool) {
+	if v.enc != EncodingListpack {
+		v.dq.Sort(less)
+		return
+	}
+	values := v.pack.values()
+	sort.Slice(values, func(i, j int) bool {
+		return less(values[i], values[j])
+	})
+	v.pack = listpack{}
+	for _, s := range values {
+		v.pack.pushBack(s)
+	}
+}
+
 type List struct {
-	mu    sync.RWMutex
-	items map[string]*Deque[Item]
+	segs segments[*listValue]
 }
 
 func CreateList() *List {
 	return &List{
-		items: make(map[string]*Deque[Item]),
+		segs: newSegments[*listValue](GetSegmentCount()),
 	}
 }
 
 func (l *List) Lpush(key string, values ...string) int {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	defer l.segs.lock(key)()
 
-	if l.items[key] == nil {
-		l.items[key] = NewDeque[Item]()
+	list, ok := l.segs.get(key)
+	if !ok {
+		list = newListValue()
+		l.segs.put(key, list)
 	}
 
+	lim := GetEncodingLimits()
 	for _, value := range values {
-		item := Item{Value: value}
-		l.items[key].PushFront(item)
+		list.pushFront(value, lim)
 	}
-	return l.items[key].size
+	return list.len()
 }
 
 func (l *List) Rpush(key string, values ...string) int {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	defer l.segs.lock(key)()
 
-	if l.items[key] == nil {
-		l.items[key] = NewDeque[Item]()
+	list, ok := l.segs.get(key)
+	if !ok {
+		list = newListValue()
+		l.segs.put(key, list)
 	}
 
+	lim := GetEncodingLimits()
 	for _, value := range values {
-		item := Item{Value: value}
-		l.items[key].PushBack(item)
+		list.pushBack(value, lim)
 	}
-	return l.items[key].size
+	return list.len()
 }
 
 func (l *List) Lpop(key string, count int) []Item {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	deque := l.items[key]
-	if deque == nil {
-		return []Item{}
-	}
-
-	items := make([]Item, 0, count)
-	for i := 0; i < count; i++ {
-		item, ok := deque.PopFront()
-		if !ok {
-			break
-		}
-		items = append(items, item)
-	}
-	if deque.Empty() {
-		delete(l.items, key)
-	}
-	return items
+	return l.pop(key, count, (*listValue).popFront)
 }
 
 func (l *List) Rpop(key string, count int) []Item {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.pop(key, count, (*listValue).popBack)
+}
 
-	deque := l.items[key]
-	if deque == nil {
+func (l *List) pop(key string, count int, popOne func(*listValue) (string, bool)) []Item {
+	defer l.segs.lock(key)()
+
+	list, ok := l.segs.get(key)
+	if !ok {
 		return []Item{}
 	}
 
 	items := make([]Item, 0, count)
 	for i := 0; i < count; i++ {
-		item, ok := deque.PopBack()
+		value, ok := popOne(list)
 		if !ok {
 			break
 		}
-		items = append(items, item)
+		items = append(items, Item{Value: value})
 	}
-	if deque.Empty() {
-		delete(l.items, key)
+	if list.len() == 0 {
+		l.segs.del(key)
 	}
 	return items
 }
 
 func (l *List) Llen(key string) int {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	defer l.segs.rlock(key)()
 
-	if l.items[key] == nil {
+	list, ok := l.segs.get(key)
+	if !ok {
 		return 0
 	}
-
-	return l.items[key].size
+	return list.len()
 }
 
 func (l *List) Lrange(key string, start int, stop int) ([]Item, bool) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	defer l.segs.rlock(key)()
 
-	deque := l.items[key]
-	if deque == nil || deque.size == 0 {
+	list, ok := l.segs.get(key)
+	if !ok || list.len() == 0 {
 		return []Item{}, false
 	}
 
+	size := list.len()
 	if start < 0 {
-		start += deque.size
+		start += size
 	}
 	if stop < 0 {
-		stop += deque.size
+		stop += size
 	}
 	if start < 0 {
 		start = 0
 	}
-	if stop >= deque.size {
-		stop = deque.size - 1
+	if stop >= size {
+		stop = size - 1
 	}
 	if start > stop {
 		return []Item{}, true
 	}
-
-	items := make([]Item, 0, stop-start+1)
-	for i := start; i <= stop; i++ {
-		pos := (deque.head + i) % deque.capacity
-		items = append(items, deque.items[pos])
-	}
-	return items, true
+	return list.items(start, stop), true
 }
 
 func (l *List) Sort(key string, asc bool, alpha bool) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	defer l.segs.lock(key)()
 
-	if l.items[key] == nil {
+	list, ok := l.segs.get(key)
+	if !ok {
 		return
 	}
 
-	l.items[key].Sort(func(i, j Item) bool {
+	list.sort(func(i, j string) bool {
 		var less bool
 		if alpha {
-			less = i.Value < j.Value
+			less = i < j
 		} else {
-			a, erra := strconv.ParseFloat(i.Value, 64)
-			b, errb := strconv.ParseFloat(j.Value, 64)
+			a, erra := strconv.ParseFloat(i, 64)
+			b, errb := strconv.ParseFloat(j, 64)
 			if erra != nil || errb != nil {
-				less = i.Value < j.Value
+				less = i < j
 			} else {
 				less = a < b
 			}
@@ -164,21 +248,84 @@ func (l *List) Sort(key string, asc bool, alpha bool) {
 	})
 }
 
-func (l *List) Dump() map[string][]Item {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// Encoding returns the OBJECT ENCODING of the list at key.
+func (l *List) Encoding(key string) (string, bool) {
+	defer l.segs.rlock(key)()
 
-	snapshot := make(map[string][]Item, len(l.items))
-	for key, deque := range l.items {
-		if deque == nil || deque.size == 0 {
-			continue
-		}
-		items := make([]Item, 0, deque.size)
-		for i := 0; i < deque.size; i++ {
-			pos := (deque.head + i) % deque.capacity
-			items = append(items, deque.items[pos])
+	list, ok := l.segs.get(key)
+	if !ok {
+		return "", false
+	}
+	return list.enc, true
+}
+
+func dumpList(key string, list *listValue) ([]Item, bool) {
+	if list.len() == 0 {
+		return nil, false
+	}
+	return list.items(0, list.len()-1), true
+}
+
+func (l *List) snapshot() cowSegments[*listValue, []Item] {
+	return cowSegments[*listValue, []Item]{segs: l.segs, dump: dumpList, usage: listUsage}
+}
+
+func (l *List) cow() cowStore {
+	return l.snapshot()
+}
+
+func (l *List) Dump(keys ...string) map[string][]Item {
+	return l.snapshot().dumpKeys(keys)
+}
+
+func (l *List) DumpSegment(i int) map[string][]Item {
+	return l.snapshot().dumpSegment(i)
+}
+
+func nonEmpty(list *listValue) bool {
+	return list.len() > 0
+}
+
+func (l *List) Keys() []string {
+	return l.segs.keys(nonEmpty)
+}
+
+func (l *List) SegmentKeys(i int) []string {
+	return l.segs.segmentKeys(i, nonEmpty)
+}
+
+func (l *List) Segments() int {
+	return len(l.segs)
+}
+
+func (l *List) Len() int {
+	return l.segs.count()
+}
+
+func (l *List) Sample(n int) []string {
+	return l.segs.sample(n)
+}
+
+func (l *List) Exists(key string) bool {
+	return l.Llen(key) > 0
+}
+
+func (l *List) accessOf(key string) (*accessMap, func()) {
+	return l.segs.accessOf(key)
+}
+
+func (l *List) Delete(keys ...string) int {
+	defer l.segs.lock(keys...)()
+	count := 0
+	for _, key := range keys {
+		if list, ok := l.segs.get(key); ok && list.len() > 0 {
+			count++
 		}
-		snapshot[key] = items
+		l.segs.del(key)
 	}
-	return snapshot
+	return count
+}
+
+func (l *List) Flush() {
+	l.segs.flush()
 }
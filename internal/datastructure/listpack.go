@@ -0,0 +1,150 @@
+package datastructure
+
+import (
+	"encoding/binary"
+	"slices"
+	"strconv"
+)
+
+// Encoding names reported by OBJECT ENCODING, as in Redis.
+const (
+	EncodingInt       = "int"
+	EncodingEmbstr    = "embstr"
+	EncodingRaw       = "raw"
+	EncodingIntset    = "intset"
+	EncodingListpack  = "listpack"
+	EncodingHashtable = "hashtable"
+	EncodingQuicklist = "quicklist"
+	EncodingSkiplist  = "skiplist"
+)
+
+const embstrLimit = 44
+
+type listpack struct {
+	buf []byte
+	n   int
+}
+
+func (lp *listpack) len() int { return lp.n }
+
+func (lp *listpack) next(off int) (string, int) {
+	size, w := binary.Uvarint(lp.buf[off:])
+	start := off + w
+	end := start + int(size)
+	return string(lp.buf[start:end]), end
+}
+
+func (lp *listpack) each(fn func(s string) bool) {
+	for off := 0; off < len(lp.buf); {
+		var s string
+		s, off = lp.next(off)
+		if !fn(s) {
+			return
+		}
+	}
+}
+
+func (lp *listpack) offset(i int) int {
+	off := 0
+	for ; i > 0; i-- {
+		size, w := binary.Uvarint(lp.buf[off:])
+		off += w + int(size)
+	}
+	return off
+}
+
+func (lp *listpack) get(i int) string {
+	s, _ := lp.next(lp.offset(i))
+	return s
+}
+
+func encodeEntry(s string) []byte {
+	b := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(s)), uint64(len(s)))
+	return append(b, s...)
+}
+
+func (lp *listpack) insert(i int, s string) {
+	lp.buf = slices.Insert(lp.buf, lp.offset(i), encodeEntry(s)...)
+	lp.n++
+}
+
+func (lp *listpack) pushBack(s string) {
+	lp.buf = append(lp.buf, encodeEntry(s)...)
+	lp.n++
+}
+
+func (lp *listpack) pushFront(s string) { lp.insert(0, s) }
+
+func (lp *listpack) set(i int, s string) {
+	off := lp.offset(i)
+	_, end := lp.next(off)
+	lp.buf = slices.Replace(lp.buf, off, end, encodeEntry(s)...)
+}
+
+func (lp *listpack) remove(i int) string {
+	off := lp.offset(i)
+	s, end := lp.next(off)
+	lp.buf = slices.Delete(lp.buf, off, end)
+	lp.n--
+	return s
+}
+
+func (lp *listpack) index(s string, step int) int {
+	i, found := 0, -1
+	lp.each(func(e string) bool {
+		if i%step == 0 && e == s {
+			found = i
+			return false
+		}
+		i++
+		return true
+	})
+	return found
+}
+
+func (lp *listpack) values() []string {
+	out := make([]string, 0, lp.n)
+	lp.each(func(s string) bool {
+		out = append(out, s)
+		return true
+	})
+	return out
+}
+
+type intset []int64
+
+func parseIntMember(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == s
+}
+
+func (is intset) find(n int64) (int, bool) {
+	return slices.BinarySearch(is, n)
+}
+
+func (is *intset) add(n int64) bool {
+	i, found := is.find(n)
+	if !found {
+		*is = slices.Insert(*is, i, n)
+	}
+	return !found
+}
+
+func (is *intset) remove(n int64) bool {
+	i, found := is.find(n)
+	if found {
+		*is = slices.Delete(*is, i, i+1)
+	}
+	return found
+}
+
+// StringEncoding is the OBJECT ENCODING of a string value.
+func StringEncoding(value string) string {
+	if _, ok := parseIntMember(value); ok {
+		return EncodingInt
+	}
+	if len(value) <= embstrLimit {
+		return EncodingEmbstr
+	}
+	return EncodingRaw
+}
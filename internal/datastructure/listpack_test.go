@@ -0,0 +1,198 @@
+package datastructure
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/config"
+)
+
+func TestListpack(t *testing.T) {
+	var lp listpack
+	lp.pushBack("b")
+	lp.pushBack(strings.Repeat("x", 200))
+	lp.pushFront("a")
+	lp.insert(2, "")
+	if got := lp.values(); !slices.Equal(got, []string{"a", "b", "", strings.Repeat("x", 200)}) {
+		t.Fatalf("Unexpected entries %q", got)
+	}
+	lp.set(1, "bb")
+	if got := lp.get(1); got != "bb" {
+		t.Errorf("Expected bb after set, got %q", got)
+	}
+	if i := lp.index("bb", 1); i != 1 {
+		t.Errorf("Expected bb at 1, got %d", i)
+	}
+	if i := lp.index("bb", 2); i != -1 {
+		t.Errorf("Expected no bb at an even position, got %d", i)
+	}
+	if got := lp.remove(3); got != strings.Repeat("x", 200) || lp.len() != 3 {
+		t.Errorf("Expected the long entry removed, got %q with %d left", got, lp.len())
+	}
+}
+
+func TestIntset(t *testing.T) {
+	var is intset
+	for _, n := range []int64{5, -3, 100, 5} {
+		is.add(n)
+	}
+	if !slices.Equal(is, intset{-3, 5, 100}) {
+		t.Errorf("Expected sorted unique members, got %v", is)
+	}
+	if !is.remove(5) || is.remove(5) {
+		t.Error("Expected 5 to be removed once")
+	}
+	for _, s := range []string{"007", "+1", "1.0", " 1", "99999999999999999999"} {
+		if _, ok := parseIntMember(s); ok {
+			t.Errorf("Expected %q not to be stored as an integer", s)
+		}
+	}
+}
+
+func TestSetEncoding(t *testing.T) {
+	s := CreateSet()
+	s.Sadd("ints", "3", "1", "2")
+	if enc, _ := s.Encoding("ints"); enc != EncodingIntset {
+		t.Errorf("Expected intset, got %s", enc)
+	}
+	s.Sadd("ints", "a")
+	if enc, _ := s.Encoding("ints"); enc != EncodingListpack {
+		t.Errorf("Expected listpack after a string member, got %s", enc)
+	}
+	members, _ := s.Smembers("ints")
+	sort.Strings(members)
+	if !slices.Equal(members, []string{"1", "2", "3", "a"}) {
+		t.Errorf("Expected members to survive conversion, got %v", members)
+	}
+
+	s.Sadd("long", strings.Repeat("m", 65))
+	if enc, _ := s.Encoding("long"); enc != EncodingHashtable {
+		t.Errorf("Expected hashtable for a long member, got %s", enc)
+	}
+
+	for i := range 513 {
+		s.Sadd("many", strconv.Itoa(i))
+	}
+	if enc, _ := s.Encoding("many"); enc != EncodingHashtable || s.Scard("many") != 513 {
+		t.Errorf("Expected 513 integers in a hashtable, got %s with %d", enc, s.Scard("many"))
+	}
+	if s.Srem("many", "0", "512", "x") != 2 || s.Sismember("many", "0") || !s.Sismember("many", "1") {
+		t.Error("Expected removal to work after conversion")
+	}
+	if _, ok := s.Encoding("missing"); ok {
+		t.Error("Expected no encoding for a missing key")
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	h := CreateHashMap()
+	h.Hset("h", "a", "1", "b", "2")
+	h.Hset("h", "a", "10")
+	if enc, _ := h.Encoding("h"); enc != EncodingListpack {
+		t.Errorf("Expected listpack, got %s", enc)
+	}
+	if v, _ := h.Hget("h", "a"); v != "10" || h.Hlen("h") != 2 {
+		t.Errorf("Expected a=10 in 2 fields, got %s in %d", v, h.Hlen("h"))
+	}
+	// A value equal to a field name must not be found as a field.
+	h.Hset("h", "c", "b")
+	if h.Hdel("h", "b") != 1 || h.Hexists("h", "b") || !h.Hexists("h", "c") {
+		t.Error("Expected only field b to be deleted")
+	}
+
+	h.Hset("h", "big", strings.Repeat("v", 65))
+	if enc, _ := h.Encoding("h"); enc != EncodingHashtable {
+		t.Errorf("Expected hashtable after a long value, got %s", enc)
+	}
+	all, _ := h.Hgetall("h")
+	if len(all) != 3 || all["a"] != "10" || all["c"] != "b" {
+		t.Errorf("Expected fields to survive conversion, got %v", all)
+	}
+
+	for i := range 129 {
+		h.Hset("wide", strconv.Itoa(i), "v")
+	}
+	if enc, _ := h.Encoding("wide"); enc != EncodingHashtable {
+		t.Errorf("Expected hashtable past 128 fields, got %s", enc)
+	}
+}
+
+func TestListEncoding(t *testing.T) {
+	l := CreateList()
+	l.Rpush("l", "b", "c")
+	l.Lpush("l", "a")
+	if enc, _ := l.Encoding("l"); enc != EncodingListpack {
+		t.Errorf("Expected listpack, got %s", enc)
+	}
+	if got := values(l.Lpop("l", 1)); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("Expected a, got %v", got)
+	}
+	if got := values(l.Rpop("l", 1)); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("Expected c, got %v", got)
+	}
+
+	for i := range 128 {
+		l.Rpush("l", strconv.Itoa(i))
+	}
+	if enc, _ := l.Encoding("l"); enc != EncodingQuicklist {
+		t.Errorf("Expected quicklist past 128 entries, got %s", enc)
+	}
+	items, _ := l.Lrange("l", 0, 2)
+	if got := values(items); !slices.Equal(got, []string{"b", "0", "1"}) {
+		t.Errorf("Expected order to survive conversion, got %v", got)
+	}
+
+	l.Rpush("s", "3", "1", "2")
+	l.Sort("s", true, false)
+	items, _ = l.Lrange("s", 0, -1)
+	if got := values(items); !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Errorf("Expected a sorted listpack, got %v", got)
+	}
+	l.Rpush("s", strings.Repeat("z", 65))
+	if enc, _ := l.Encoding("s"); enc != EncodingQuicklist {
+		t.Errorf("Expected quicklist after a long value, got %s", enc)
+	}
+}
+
+func values(items []Item) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = item.Value
+	}
+	return out
+}
+
+func TestStringEncoding(t *testing.T) {
+	tests := map[string]string{"12345": EncodingInt, "hello": EncodingEmbstr, "012": EncodingEmbstr, strings.Repeat("x", 45): EncodingRaw}
+	for value, want := range tests {
+		if got := StringEncoding(value); got != want {
+			t.Errorf("%q: expected %s, got %s", value, want, got)
+		}
+	}
+}
+
+func TestEncodingLimitsDefaults(t *testing.T) {
+	saved := config.Global
+	defer func() { config.Global = saved }()
+	config.Global = &config.Config{}
+	if lim := GetEncodingLimits(); lim != defaultEncodingLimits {
+		t.Errorf("Expected the Redis defaults without an encoding section, got %+v", lim)
+	}
+
+	config.Global.Datastructure.Expiration.CheckInterval = 1
+	config.Global.Datastructure.Encoding.HashMaxListpackEntries = -1
+	config.Global.Datastructure.Encoding.SetMaxIntsetEntries = 16
+	s := CreateSet()
+	s.Sadd("s", "1")
+	h := CreateHashMap()
+	h.Hset("h", "f", "v")
+	if enc, _ := s.Encoding("s"); enc != EncodingIntset {
+		t.Errorf("Expected intset, got %s", enc)
+	}
+	if enc, _ := h.Encoding("h"); enc != EncodingHashtable {
+		t.Errorf("Expected a negative limit to disable listpack, got %s", enc)
+	}
+}
@@ -0,0 +1,274 @@
+package datastructure
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// Memory estimates follow the layout of the 64-bit Go runtime. They leave
+// out allocator size-class rounding, so they are approximate but comparable
+// between keys.
+const (
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	sliceHeaderSize  = int64(unsafe.Sizeof([]byte(nil)))
+	pointerSize      = int64(unsafe.Sizeof(uintptr(0)))
+	interfaceSize    = int64(unsafe.Sizeof(any(nil)))
+	itemSize         = int64(unsafe.Sizeof(Item{}))
+	// mapHeaderSize is the map header and the directory of its tables.
+	mapHeaderSize = 48 + 32
+	// mapGroupSlots entries share a group behind one control byte each, and
+	// a table is grown before it is 7/8 full.
+	mapGroupSlots = 8
+)
+
+// DefaultMemorySamples is how many elements of a collection MEMORY USAGE
+// measures by default; the rest are assumed to be of the same average size.
+const DefaultMemorySamples = 5
+
+func mapSize(n int, slotSize int64) int64 {
+	if n == 0 {
+		return mapHeaderSize
+	}
+	slots := int64(n) * 8 / 7
+	groups := (slots + mapGroupSlots - 1) / mapGroupSlots
+	groups = 1 << bits.Len64(uint64(groups-1))
+	return mapHeaderSize + groups*mapGroupSlots*(1+slotSize)
+}
+
+func keySize(key string, valueSize int64) int64 {
+	return int64(len(key)) + (1+stringHeaderSize+valueSize)*8/7
+}
+
+type sampler struct {
+	limit    int
+	measured int
+	total    int64
+}
+
+func newSampler(n, samples int) *sampler {
+	if samples <= 0 || samples > n {
+		samples = n
+	}
+	return &sampler{limit: samples}
+}
+
+func (s *sampler) add(size int64) bool {
+	s.total += size
+	s.measured++
+	return s.measured < s.limit
+}
+
+func (s *sampler) estimate(n int) int64 {
+	if s.measured == 0 {
+		return 0
+	}
+	return s.total * int64(n) / int64(s.measured)
+}
+
+func (d *Dict) MemoryUsage(key string, samples int) (int64, bool) {
+	item, ok := d.getItem(key)
+	if !ok || d.isExpired(item) {
+		return 0, false
+	}
+	return itemUsage(key, item, samples), true
+}
+
+func itemUsage(key string, item Item, samples int) int64 {
+	return keySize(key, itemSize) + int64(len(item.Value))
+}
+
+func (s *Set) MemoryUsage(key string, samples int) (int64, bool) {
+	defer s.segs.rlock(key)()
+	v, ok := s.live(key)
+	if !ok {
+		return 0, false
+	}
+	return setUsage(key, v, samples), true
+}
+
+func setUsage(key string, v *setValue, samples int) int64 {
+	size := keySize(key, pointerSize) + int64(unsafe.Sizeof(*v))
+	switch v.enc {
+	case EncodingIntset:
+		return size + int64(cap(v.ints))*8
+	case EncodingListpack:
+		return size + int64(cap(v.pack.buf))
+	}
+	n := len(v.members)
+	sm := newSampler(n, samples)
+	for m := range v.members {
+		if !sm.add(int64(len(m))) {
+			break
+		}
+	}
+	return size + mapSize(n, stringHeaderSize) + sm.estimate(n)
+}
+
+func (l *List) MemoryUsage(key string, samples int) (int64, bool) {
+	defer l.segs.rlock(key)()
+	list, ok := l.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return listUsage(key, list, samples), true
+}
+
+func listUsage(key string, list *listValue, samples int) int64 {
+	size := keySize(key, pointerSize) + int64(unsafe.Sizeof(*list))
+	if list.enc == EncodingListpack {
+		return size + int64(cap(list.pack.buf))
+	}
+	dq := list.dq
+	size += int64(unsafe.Sizeof(*dq)) + int64(cap(dq.items))*stringHeaderSize
+	sm := newSampler(dq.size, samples)
+	for i := 0; i < dq.size; i++ {
+		if !sm.add(int64(len(dq.items[(dq.head+i)%dq.capacity]))) {
+			break
+		}
+	}
+	return size + sm.estimate(dq.size)
+}
+
+func (h *HashMap) MemoryUsage(key string, samples int) (int64, bool) {
+	defer h.segs.rlock(key)()
+	hash, ok := h.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return hashUsage(key, hash, samples), true
+}
+
+func hashUsage(key string, hash *hashValue, samples int) int64 {
+	size := keySize(key, pointerSize) + int64(unsafe.Sizeof(*hash))
+	if hash.enc == EncodingListpack {
+		return size + int64(cap(hash.pack.buf))
+	}
+	n := len(hash.fields)
+	sm := newSampler(n, samples)
+	for f, v := range hash.fields {
+		if !sm.add(int64(len(f) + len(v))) {
+			break
+		}
+	}
+	return size + mapSize(n, 2*stringHeaderSize) + sm.estimate(n)
+}
+
+func (z *ZSet) MemoryUsage(key string, samples int) (int64, bool) {
+	defer z.segs.rlock(key)()
+	ss, ok := z.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return zsetUsage(key, ss, samples), true
+}
+
+func zsetUsage(key string, ss *sortedSet, samples int) int64 {
+	n := len(ss.scores)
+	nodeSize := int64(unsafe.Sizeof(skiplistNode{}))
+	levelSize := int64(unsafe.Sizeof(skiplistLevel{}))
+	size := keySize(key, pointerSize) + int64(unsafe.Sizeof(*ss)) + int64(unsafe.Sizeof(*ss.zsl))
+	size += nodeSize + skiplistMaxLevel*levelSize
+	size += mapSize(n, stringHeaderSize+8)
+	// A node has 1/(1-p) levels on average. Members share their bytes with
+	// the score map.
+	size += int64(n) * (nodeSize + int64(float64(levelSize)/(1-skiplistP)))
+	sm := newSampler(n, samples)
+	for m := range ss.scores {
+		if !sm.add(int64(len(m))) {
+			break
+		}
+	}
+	return size + sm.estimate(n)
+}
+
+func (j *JSONStore) MemoryUsage(key string, samples int) (int64, bool) {
+	defer j.segs.rlock(key)()
+	node, ok := j.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return jsonUsage(key, node, samples), true
+}
+
+func jsonUsage(key string, node *jsonNode, samples int) int64 {
+	return keySize(key, pointerSize) + node.memoryUsage()
+}
+
+func (n *jsonNode) memoryUsage() int64 {
+	size := int64(unsafe.Sizeof(*n)) + int64(len(n.num)+len(n.str))
+	size += int64(cap(n.keys)) * stringHeaderSize
+	for _, k := range n.keys {
+		size += int64(len(k))
+	}
+	if n.fields != nil {
+		size += mapSize(len(n.fields), stringHeaderSize+pointerSize)
+		for _, child := range n.fields {
+			size += child.memoryUsage()
+		}
+	}
+	size += int64(cap(n.elems)) * pointerSize
+	for _, child := range n.elems {
+		size += child.memoryUsage()
+	}
+	return size
+}
+
+func (t *TimeSeries) MemoryUsage(key string, samples int) (int64, bool) {
+	defer t.segs.rlock(key)()
+	s, ok := t.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return seriesUsage(key, s, samples), true
+}
+
+func seriesUsage(key string, s *timeSeries, samples int) int64 {
+	size := keySize(key, pointerSize) + int64(unsafe.Sizeof(*s)) + int64(len(s.sourceKey))
+	size += mapSize(len(s.opts.Labels), 2*stringHeaderSize)
+	for k, v := range s.opts.Labels {
+		size += int64(len(k) + len(v))
+	}
+	size += int64(cap(s.chunks)) * pointerSize
+	for _, c := range s.chunks {
+		size += int64(unsafe.Sizeof(*c)) + int64(cap(c.w.buf))
+	}
+	size += int64(cap(s.rules)) * pointerSize
+	for _, r := range s.rules {
+		size += int64(unsafe.Sizeof(*r)) + int64(len(r.Dest))
+	}
+	return size
+}
+
+func (s *Sketches) MemoryUsage(key string, samples int) (int64, bool) {
+	defer s.segs.rlock(key)()
+	v, ok := s.segs.get(key)
+	if !ok {
+		return 0, false
+	}
+	return sketchUsage(key, v, samples), true
+}
+
+func sketchUsage(key string, v any, samples int) int64 {
+	size := keySize(key, interfaceSize)
+	switch v := v.(type) {
+	case *BloomFilter:
+		size += int64(unsafe.Sizeof(*v)) + int64(cap(v.Layers))*pointerSize
+		for _, l := range v.Layers {
+			size += int64(unsafe.Sizeof(*l)) + int64(cap(l.Bits))*8
+		}
+	case *CuckooFilter:
+		size += int64(unsafe.Sizeof(*v)) + int64(cap(v.Layers))*pointerSize
+		for _, l := range v.Layers {
+			size += int64(unsafe.Sizeof(*l)) + int64(cap(l.Slots))
+		}
+	case *CountMinSketch:
+		size += int64(unsafe.Sizeof(*v)) + int64(cap(v.Counters))*8
+	case *TopK:
+		size += int64(unsafe.Sizeof(*v)) + int64(cap(v.Buckets))*int64(unsafe.Sizeof(topkBucket{}))
+		size += int64(cap(v.Heap)) * int64(unsafe.Sizeof(TopKItem{}))
+		for _, item := range v.Heap {
+			size += int64(len(item.Item))
+		}
+	}
+	return size
+}
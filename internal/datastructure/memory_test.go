@@ -0,0 +1,99 @@
+package datastructure
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMapSize(t *testing.T) {
+	if got := mapSize(0, 16); got != mapHeaderSize {
+		t.Errorf("Expected an empty map to cost its header, got %d", got)
+	}
+	prev := mapSize(1, 16)
+	for n := 2; n < 5000; n++ {
+		size := mapSize(n, 16)
+		if size < prev {
+			t.Fatalf("Expected map size to grow with entries, %d entries took %d after %d", n, size, prev)
+		}
+		if size < int64(n)*17 {
+			t.Fatalf("Expected %d entries to take at least %d bytes, got %d", n, n*17, size)
+		}
+		prev = size
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	d := CreateDict()
+	d.Set("short", "v", 0)
+	d.Set("long", strings.Repeat("v", 1000), 0)
+	d.Set("gone", "v", time.Millisecond)
+	short, _ := d.MemoryUsage("short", 0)
+	long, _ := d.MemoryUsage("long", 0)
+	if long-short != 999-1 {
+		t.Errorf("Expected the string length difference to show, got %d and %d", short, long)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := d.MemoryUsage("gone", 0); ok {
+		t.Error("Expected no usage for an expired key")
+	}
+	if _, ok := d.MemoryUsage("missing", 0); ok {
+		t.Error("Expected no usage for a missing key")
+	}
+
+	s := CreateSet()
+	for i := range 1000 {
+		s.Sadd("set", "member"+strconv.Itoa(1000+i))
+	}
+	sampled, _ := s.MemoryUsage("set", 5)
+	exact, _ := s.MemoryUsage("set", 0)
+	if sampled != exact {
+		t.Errorf("Expected sampling equal sized members to be exact, got %d and %d", sampled, exact)
+	}
+
+	l := CreateList()
+	l.Rpush("list", "a")
+	small, _ := l.MemoryUsage("list", 0)
+	for range 200 {
+		l.Rpush("list", "a")
+	}
+	grown, _ := l.MemoryUsage("list", 0)
+	l.Lpop("list", 200)
+	shrunk, _ := l.MemoryUsage("list", 0)
+	if grown <= small || shrunk <= small {
+		t.Errorf("Expected the deque capacity, not its length, to count, got %d, %d and %d", small, grown, shrunk)
+	}
+
+	z := CreateZSet()
+	z.Zadd("z", ZAddFlags{}, ScoreMember{Member: "a", Score: 1})
+	one, _ := z.MemoryUsage("z", 0)
+	for i := range 100 {
+		z.Zadd("z", ZAddFlags{}, ScoreMember{Member: "m" + strconv.Itoa(i), Score: float64(i)})
+	}
+	many, _ := z.MemoryUsage("z", 0)
+	if many < one+100*int64(len("m00")) {
+		t.Errorf("Expected members to add to a sorted set, got %d and %d", one, many)
+	}
+
+	j := CreateJSONStore()
+	if _, err := j.JSONSet("doc", "$", `{"a":1}`, false, false); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := j.MemoryUsage("doc", 0)
+	if _, err := j.JSONSet("doc", "$.b", `"`+strings.Repeat("x", 500)+`"`, false, false); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := j.MemoryUsage("doc", 0)
+	if after < before+500 {
+		t.Errorf("Expected a new field to add its bytes, got %d and %d", before, after)
+	}
+
+	sk := CreateSketches()
+	if err := sk.BFReserve("bf", 0.01, 100000, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := sk.MemoryUsage("bf", 0); size < 100000 {
+		t.Errorf("Expected a bloom filter for 100k items to take its bit array, got %d", size)
+	}
+}
@@ -1,163 +1,299 @@
 package datastructure
 
 import (
-	"math/rand/v2"
-	"sync"
+	"strconv"
 	"time"
 )
 
+type setValue struct {
+	enc       string
+	ints      intset
+	pack      listpack
+	members   map[string]struct{}
+	expiredAt time.Time
+}
+
+func newSetValue() *setValue {
+	return &setValue{enc: EncodingIntset}
+}
+
+func (v *setValue) len() int {
+	switch v.enc {
+	case EncodingIntset:
+		return len(v.ints)
+	case EncodingListpack:
+		return v.pack.len()
+	}
+	return len(v.members)
+}
+
+func (v *setValue) has(m string) bool {
+	switch v.enc {
+	case EncodingIntset:
+		n, ok := parseIntMember(m)
+		if !ok {
+			return false
+		}
+		_, found := v.ints.find(n)
+		return found
+	case EncodingListpack:
+		return v.pack.index(m, 1) >= 0
+	}
+	_, ok := v.members[m]
+	return ok
+}
+
+func (v *setValue) each(fn func(m string) bool) {
+	switch v.enc {
+	case EncodingIntset:
+		for _, n := range v.ints {
+			if !fn(strconv.FormatInt(n, 10)) {
+				return
+			}
+		}
+	case EncodingListpack:
+		v.pack.each(fn)
+	default:
+		for m := range v.members {
+			if !fn(m) {
+				return
+			}
+		}
+	}
+}
+
+func (v *setValue) values() []string {
+	out := make([]string, 0, v.len())
+	v.each(func(m string) bool {
+		out = append(out, m)
+		return true
+	})
+	return out
+}
+
+func (v *setValue) fits(m string, lim EncodingLimits) bool {
+	switch v.enc {
+	case EncodingIntset:
+		_, ok := parseIntMember(m)
+		return ok && len(v.ints) < lim.SetIntsetEntries
+	case EncodingListpack:
+		return v.pack.len() < lim.SetListpackEntries && len(m) <= lim.SetListpackValue
+	}
+	return true
+}
+
+func (v *setValue) grow(m string, lim EncodingLimits) {
+	members := v.values()
+	listpackOK := v.enc == EncodingIntset && len(members) < lim.SetListpackEntries && len(m) <= lim.SetListpackValue
+	for _, e := range members {
+		listpackOK = listpackOK && len(e) <= lim.SetListpackValue
+	}
+	v.ints, v.pack = nil, listpack{}
+	if listpackOK {
+		v.enc = EncodingListpack
+		for _, e := range members {
+			v.pack.pushBack(e)
+		}
+		return
+	}
+	v.enc = EncodingHashtable
+	v.members = make(map[string]struct{}, len(members)+1)
+	for _, e := range members {
+		v.members[e] = struct{}{}
+	}
+}
+
+func (v *setValue) add(m string, lim EncodingLimits) bool {
+	if v.has(m) {
+		return false
+	}
+	for !v.fits(m, lim) {
+		v.grow(m, lim)
+	}
+	switch v.enc {
+	case EncodingIntset:
+		n, _ := parseIntMember(m)
+		v.ints.add(n)
+	case EncodingListpack:
+		v.pack.pushBack(m)
+	default:
+		v.members[m] = struct{}{}
+	}
+	return true
+}
+
+func (v *setValue) remove(m string) bool {
+	switch v.enc {
+	case EncodingIntset:
+		n, ok := parseIntMember(m)
+		return ok && v.ints.remove(n)
+	case EncodingListpack:
+		i := v.pack.index(m, 1)
+		if i < 0 {
+			return false
+		}
+		v.pack.remove(i)
+		return true
+	}
+	if _, ok := v.members[m]; !ok {
+		return false
+	}
+	delete(v.members, m)
+	return true
+}
+
 type Set struct {
-	mu    sync.RWMutex
-	items map[string]Item
+	segs segments[*setValue]
 }
 
 func CreateSet() *Set {
-	s := &Set{items: make(map[string]Item)}
-	go s.expireLoop()
+	s := &Set{segs: newSegments[*setValue](GetSegmentCount())}
+	tick(GetExpirationCheckInterval(), func() { s.activeExpire() })
 	return s
 }
 
-func (s *Set) getItem(key string) (Item, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	item, ok := s.items[key]
-	return item, ok
+func (s *Set) isExpired(v *setValue) bool {
+	return !v.expiredAt.IsZero() && time.Now().After(v.expiredAt)
 }
 
-func (s *Set) isExpired(it Item) bool {
-	return !it.ExpiredAt.IsZero() && time.Now().After(it.ExpiredAt)
+// The lock of key's segment must be held.
+func (s *Set) live(key string) (*setValue, bool) {
+	v, ok := s.segs.get(key)
+	if !ok || s.isExpired(v) {
+		return nil, false
+	}
+	return v, true
 }
 
 func (s *Set) passiveExpire(key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.items, key)
+	defer s.segs.lock(key)()
+	if v, ok := s.segs.get(key); ok && s.isExpired(v) {
+		s.segs.del(key)
+	}
+}
+
+func (s *Set) read(key string, fn func(v *setValue)) bool {
+	unlock := s.segs.rlock(key)
+	v, ok := s.segs.get(key)
+	expired := ok && s.isExpired(v)
+	if ok && !expired {
+		fn(v)
+	}
+	unlock()
+	if expired {
+		s.passiveExpire(key)
+	}
+	return ok && !expired
 }
 
 func (s *Set) Sadd(key string, members ...string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.segs.lock(key)()
 
-	item, ok := s.items[key]
+	v, ok := s.live(key)
 	if !ok {
-		item = Item{Members: make(map[string]struct{})}
+		v = newSetValue()
+		// An expired key being replaced loses its TTL.
+		s.segs.of(key).expires.remove(key)
 	}
+	lim := GetEncodingLimits()
 	added := 0
 	for _, m := range members {
-		if _, exist := item.Members[m]; !exist {
-			item.Members[m] = struct{}{}
+		if v.add(m, lim) {
 			added++
 		}
 	}
-	s.items[key] = item
+	if v.len() > 0 {
+		s.segs.put(key, v)
+	}
 	return added
 }
 
 func (s *Set) Srem(key string, members ...string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.segs.lock(key)()
 
-	item, ok := s.items[key]
-	if !ok || len(item.Members) == 0 {
+	v, ok := s.live(key)
+	if !ok {
 		return 0
 	}
 	removed := 0
 	for _, m := range members {
-		if _, exist := item.Members[m]; exist {
-			delete(item.Members, m)
+		if v.remove(m) {
 			removed++
 		}
 	}
-	if len(item.Members) == 0 {
-		delete(s.items, key)
-	} else {
-		s.items[key] = item
+	if v.len() == 0 {
+		s.segs.del(key)
 	}
 	return removed
 }
 
 func (s *Set) Smembers(key string) ([]string, bool) {
-	item, ok := s.getItem(key)
-	if !ok {
-		return nil, false
-	}
-	if s.isExpired(item) {
-		s.passiveExpire(key)
-		return nil, false
-	}
-	res := make([]string, 0, len(item.Members))
-	for m := range item.Members {
-		res = append(res, m)
-	}
-	return res, true
+	var res []string
+	ok := s.read(key, func(v *setValue) {
+		res = v.values()
+	})
+	return res, ok
 }
 
 func (s *Set) Sismember(key, member string) bool {
-	item, ok := s.getItem(key)
-	if !ok {
-		return false
-	}
-	if s.isExpired(item) {
-		s.passiveExpire(key)
-		return false
-	}
-	_, exist := item.Members[member]
+	exist := false
+	s.read(key, func(v *setValue) {
+		exist = v.has(member)
+	})
 	return exist
 }
 
 func (s *Set) Scard(key string) int {
-	item, ok := s.getItem(key)
-	if !ok {
-		return 0
-	}
-	if s.isExpired(item) {
-		s.passiveExpire(key)
-		return 0
-	}
-	return len(item.Members)
+	n := 0
+	s.read(key, func(v *setValue) {
+		n = v.len()
+	})
+	return n
+}
+
+// Encoding returns the OBJECT ENCODING of the set at key.
+func (s *Set) Encoding(key string) (string, bool) {
+	enc := ""
+	ok := s.read(key, func(v *setValue) {
+		enc = v.enc
+	})
+	return enc, ok
 }
 
 func (s *Set) Expire(key string, ttl time.Duration) bool {
-	item, ok := s.getItem(key)
-	if !ok {
-		return false
-	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if ttl <= 0 {
-		delete(s.items, key)
-		return true
-	}
-	item.ExpiredAt = time.Now().Add(ttl)
-	s.items[key] = item
-	return true
+	return s.ExpireAt(key, time.Now().Add(ttl))
 }
 
 func (s *Set) ExpireAt(key string, at time.Time) bool {
-	item, ok := s.getItem(key)
+	defer s.segs.lock(key)()
+	v, ok := s.live(key)
 	if !ok {
 		return false
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if at.Before(time.Now()) {
-		delete(s.items, key)
+	if !at.After(time.Now()) {
+		s.segs.del(key)
 		return true
 	}
-	item.ExpiredAt = at
-	s.items[key] = item
+	v.expiredAt = at
+	s.segs.of(key).expires.set(key, at)
 	return true
 }
 
 func (s *Set) TTL(key string) int64 {
-	item, ok := s.getItem(key)
+	unlock := s.segs.rlock(key)
+	v, ok := s.segs.get(key)
+	var at time.Time
+	if ok {
+		at = v.expiredAt
+	}
+	unlock()
 	if !ok {
 		return -2
 	}
-	if item.ExpiredAt.IsZero() {
+	if at.IsZero() {
 		return -1
 	}
-	secs := time.Until(item.ExpiredAt).Seconds()
+	secs := time.Until(at).Seconds()
 	if secs < 0 {
 		s.passiveExpire(key)
 		return -2
@@ -165,63 +301,80 @@ func (s *Set) TTL(key string) int64 {
 	return int64(secs)
 }
 
-func (s *Set) Dump() map[string]Item {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	snapshot := make(map[string]Item, len(s.items))
-	for k, it := range s.items {
-		if s.isExpired(it) {
-			continue
-		}
-		members := make(map[string]struct{}, len(it.Members))
-		for m := range it.Members {
-			members[m] = struct{}{}
-		}
-		snapshot[k] = Item{Members: members, ExpiredAt: it.ExpiredAt}
+func (s *Set) dumpValue(key string, v *setValue) (Item, bool) {
+	if s.isExpired(v) {
+		return Item{}, false
 	}
-	return snapshot
+	members := make(map[string]struct{}, v.len())
+	v.each(func(m string) bool {
+		members[m] = struct{}{}
+		return true
+	})
+	return Item{Members: members, ExpiredAt: v.expiredAt}, true
 }
 
-func (s *Set) expireLoop() {
-	ticker := time.NewTicker(GetExpirationCheckInterval())
-	defer ticker.Stop()
-	for range ticker.C {
-		s.activeExpire()
-	}
+func (s *Set) snapshot() cowSegments[*setValue, Item] {
+	return cowSegments[*setValue, Item]{segs: s.segs, dump: s.dumpValue, usage: setUsage}
 }
 
-func (s *Set) activeExpire() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if len(s.items) == 0 {
-		return
-	}
-	for range GetMaxSampleRounds() {
-		checked, expired := 0, 0
-		keys := make([]string, 0, len(s.items))
-		for k := range s.items {
-			keys = append(keys, k)
-		}
-		if len(keys) == 0 {
-			return
-		}
-		limit := GetMaxSampleSize()
-		if len(keys) < limit {
-			limit = len(keys)
-		}
-		for i := 0; i < limit; i++ {
-			idx := rand.IntN(len(keys))
-			k := keys[idx]
-			if item, ok := s.items[k]; ok {
-				checked++
-				if s.isExpired(item) {
-					delete(s.items, k)
-					expired++
-				}
-			}
-		}
-		if checked == 0 || expired*4 < checked {
-			return
-		}
-	}
+func (s *Set) cow() cowStore {
+	return s.snapshot()
+}
+
+func (s *Set) Dump(keys ...string) map[string]Item {
+	return s.snapshot().dumpKeys(keys)
+}
+
+func (s *Set) DumpSegment(i int) map[string]Item {
+	return s.snapshot().dumpSegment(i)
+}
+
+func (s *Set) alive(v *setValue) bool {
+	return !s.isExpired(v)
+}
+
+func (s *Set) Keys() []string {
+	return s.segs.keys(s.alive)
+}
+
+func (s *Set) SegmentKeys(i int) []string {
+	return s.segs.segmentKeys(i, s.alive)
+}
+
+func (s *Set) Segments() int {
+	return len(s.segs)
+}
+
+func (s *Set) Len() int {
+	return s.segs.count()
+}
+
+func (s *Set) Sample(n int) []string {
+	return s.segs.sample(n)
+}
+
+func (s *Set) SampleVolatile(n int) map[string]time.Time {
+	return s.segs.sampleVolatile(n)
+}
+
+func (s *Set) Exists(key string) bool {
+	defer s.segs.rlock(key)()
+	_, ok := s.live(key)
+	return ok
+}
+
+func (s *Set) accessOf(key string) (*accessMap, func()) {
+	return s.segs.accessOf(key)
+}
+
+func (s *Set) Delete(keys ...string) int {
+	return s.segs.deleteKeys(keys)
+}
+
+func (s *Set) activeExpire() int {
+	return s.segs.expireCycle(GetExpireCycleTime())
+}
+
+func (s *Set) Flush() {
+	s.segs.flush()
 }
@@ -0,0 +1,403 @@
+package datastructure
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+var (
+	ErrWrongType     = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrFilterFull    = errors.New("ERR filter is full")
+	ErrSketchExists  = errors.New("ERR item exists")
+	ErrSketchNoKey   = errors.New("ERR not found")
+	ErrSketchCorrupt = errors.New("ERR received bad data")
+)
+
+const (
+	SketchBloom  = "BF"
+	SketchCuckoo = "CF"
+	SketchCMS    = "CMS"
+	SketchTopK   = "TOPK"
+)
+
+// SketchDump is the serialized form of one probabilistic structure, used by
+// RDB snapshots and AOF rewrites.
+type SketchDump struct {
+	Kind string
+	Data []byte
+}
+
+// Sketches holds Bloom filters, cuckoo filters, count-min sketches and top-k
+// lists under one keyspace so a key can only hold one of them.
+type Sketches struct {
+	segs segments[any]
+}
+
+func CreateSketches() *Sketches {
+	return &Sketches{
+		segs: newSegments[any](GetSegmentCount()),
+	}
+}
+
+func lookupSketch[T any](s *Sketches, key string) (T, bool, error) {
+	var zero T
+	v, ok := s.segs.get(key)
+	if !ok {
+		return zero, false, nil
+	}
+	typed, ok := v.(T)
+	if !ok {
+		return zero, true, ErrWrongType
+	}
+	return typed, true, nil
+}
+
+func (s *Sketches) reserve(key string, v any) error {
+	if _, exists := s.segs.get(key); exists {
+		return ErrSketchExists
+	}
+	s.segs.put(key, v)
+	return nil
+}
+
+func (s *Sketches) BFReserve(key string, errorRate float64, capacity int64, expansion int, nonScaling bool) error {
+	defer s.segs.lock(key)()
+	return s.reserve(key, NewBloomFilter(errorRate, capacity, expansion, nonScaling))
+}
+
+// BFAdd adds items to the filter, creating it with default settings when
+// missing. It stops at the first error and returns the results so far.
+func (s *Sketches) BFAdd(key string, items ...string) ([]bool, error) {
+	defer s.segs.lock(key)()
+
+	bf, ok, err := lookupSketch[*BloomFilter](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		bf = NewBloomFilter(DefaultBloomErrorRate, DefaultBloomCapacity, DefaultExpansion, false)
+		s.segs.put(key, bf)
+	}
+	added := make([]bool, 0, len(items))
+	for _, item := range items {
+		ok, err := bf.add(item)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, ok)
+	}
+	return added, nil
+}
+
+func (s *Sketches) BFExists(key string, items ...string) ([]bool, error) {
+	defer s.segs.rlock(key)()
+
+	bf, ok, err := lookupSketch[*BloomFilter](s, key)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(items))
+	if !ok {
+		return found, nil
+	}
+	for i, item := range items {
+		found[i] = bf.exists(sketchHash(item))
+	}
+	return found, nil
+}
+
+func (s *Sketches) BFInfo(key string) (BloomInfo, error) {
+	defer s.segs.rlock(key)()
+
+	bf, ok, err := lookupSketch[*BloomFilter](s, key)
+	if err != nil {
+		return BloomInfo{}, err
+	}
+	if !ok {
+		return BloomInfo{}, ErrSketchNoKey
+	}
+	return bf.info(), nil
+}
+
+func (s *Sketches) CFReserve(key string, capacity int64, bucketSize, maxIterations, expansion int) error {
+	defer s.segs.lock(key)()
+	return s.reserve(key, NewCuckooFilter(capacity, bucketSize, maxIterations, expansion))
+}
+
+// CFAdd inserts item, creating the filter when missing. With nx it skips
+// items that may already be present and reports whether it inserted.
+func (s *Sketches) CFAdd(key, item string, nx bool) (bool, error) {
+	defer s.segs.lock(key)()
+
+	cf, ok, err := lookupSketch[*CuckooFilter](s, key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		cf = NewCuckooFilter(DefaultCuckooCapacity, DefaultCuckooBucketSize, DefaultCuckooMaxIter, DefaultExpansion)
+		s.segs.put(key, cf)
+	}
+	if nx && cf.count(item) > 0 {
+		return false, nil
+	}
+	if err := cf.add(item); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Sketches) CFDel(key, item string) (bool, error) {
+	defer s.segs.lock(key)()
+
+	cf, ok, err := lookupSketch[*CuckooFilter](s, key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrSketchNoKey
+	}
+	return cf.remove(item), nil
+}
+
+func (s *Sketches) CFExists(key string, items ...string) ([]bool, error) {
+	defer s.segs.rlock(key)()
+
+	cf, ok, err := lookupSketch[*CuckooFilter](s, key)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(items))
+	if !ok {
+		return found, nil
+	}
+	for i, item := range items {
+		found[i] = cf.count(item) > 0
+	}
+	return found, nil
+}
+
+func (s *Sketches) CFCount(key, item string) (int, error) {
+	defer s.segs.rlock(key)()
+
+	cf, ok, err := lookupSketch[*CuckooFilter](s, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return cf.count(item), nil
+}
+
+func (s *Sketches) CMSInit(key string, width, depth uint64) error {
+	defer s.segs.lock(key)()
+	return s.reserve(key, NewCountMinSketch(width, depth))
+}
+
+// CMSIncrBy increments each item by the matching entry of incrs and returns
+// the new estimates.
+func (s *Sketches) CMSIncrBy(key string, items []string, incrs []uint64) ([]uint64, error) {
+	defer s.segs.lock(key)()
+
+	cms, ok, err := lookupSketch[*CountMinSketch](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSketchNoKey
+	}
+	estimates := make([]uint64, len(items))
+	for i, item := range items {
+		estimates[i] = cms.incrBy(item, incrs[i])
+	}
+	return estimates, nil
+}
+
+func (s *Sketches) CMSQuery(key string, items ...string) ([]uint64, error) {
+	defer s.segs.rlock(key)()
+
+	cms, ok, err := lookupSketch[*CountMinSketch](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSketchNoKey
+	}
+	estimates := make([]uint64, len(items))
+	for i, item := range items {
+		estimates[i] = cms.query(item)
+	}
+	return estimates, nil
+}
+
+func (s *Sketches) TopKReserve(key string, k int, width, depth uint64, decay float64) error {
+	defer s.segs.lock(key)()
+	return s.reserve(key, NewTopK(k, width, depth, decay))
+}
+
+// TopKAdd counts items and returns, for each, the item it expelled from the
+// list or nil.
+func (s *Sketches) TopKAdd(key string, items ...string) ([]*string, error) {
+	defer s.segs.lock(key)()
+
+	topk, ok, err := lookupSketch[*TopK](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSketchNoKey
+	}
+	dropped := make([]*string, len(items))
+	for i, item := range items {
+		if out, ok := topk.add(item); ok {
+			dropped[i] = &out
+		}
+	}
+	return dropped, nil
+}
+
+func (s *Sketches) TopKList(key string) ([]TopKItem, error) {
+	defer s.segs.rlock(key)()
+
+	topk, ok, err := lookupSketch[*TopK](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSketchNoKey
+	}
+	return append([]TopKItem{}, topk.Heap...), nil
+}
+
+func (s *Sketches) TopKQuery(key string, items ...string) ([]bool, error) {
+	defer s.segs.rlock(key)()
+
+	topk, ok, err := lookupSketch[*TopK](s, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSketchNoKey
+	}
+	found := make([]bool, len(items))
+	for i, item := range items {
+		found[i] = topk.query(item)
+	}
+	return found, nil
+}
+
+func sketchKind(v any) string {
+	switch v.(type) {
+	case *BloomFilter:
+		return SketchBloom
+	case *CuckooFilter:
+		return SketchCuckoo
+	case *CountMinSketch:
+		return SketchCMS
+	case *TopK:
+		return SketchTopK
+	}
+	return ""
+}
+
+// Restore replaces key with a structure serialized by Dump.
+func (s *Sketches) Restore(key string, dump SketchDump) error {
+	var v any
+	switch dump.Kind {
+	case SketchBloom:
+		v = &BloomFilter{}
+	case SketchCuckoo:
+		v = &CuckooFilter{}
+	case SketchCMS:
+		v = &CountMinSketch{}
+	case SketchTopK:
+		v = &TopK{}
+	default:
+		return ErrSketchCorrupt
+	}
+	if err := gob.NewDecoder(bytes.NewReader(dump.Data)).Decode(v); err != nil {
+		return ErrSketchCorrupt
+	}
+
+	defer s.segs.lock(key)()
+	s.segs.put(key, v)
+	return nil
+}
+
+func dumpSketch(key string, v any) (SketchDump, bool) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return SketchDump{}, false
+	}
+	return SketchDump{Kind: sketchKind(v), Data: buf.Bytes()}, true
+}
+
+func (s *Sketches) snapshot() cowSegments[any, SketchDump] {
+	return cowSegments[any, SketchDump]{segs: s.segs, dump: dumpSketch, usage: sketchUsage}
+}
+
+func (s *Sketches) cow() cowStore {
+	return s.snapshot()
+}
+
+func (s *Sketches) Dump(keys ...string) map[string]SketchDump {
+	return s.snapshot().dumpKeys(keys)
+}
+
+func (s *Sketches) DumpSegment(i int) map[string]SketchDump {
+	return s.snapshot().dumpSegment(i)
+}
+
+func (s *Sketches) Keys() []string {
+	return s.segs.keys(nil)
+}
+
+func (s *Sketches) SegmentKeys(i int) []string {
+	return s.segs.segmentKeys(i, nil)
+}
+
+func (s *Sketches) Segments() int {
+	return len(s.segs)
+}
+
+func (s *Sketches) Len() int {
+	return s.segs.count()
+}
+
+func (s *Sketches) Sample(n int) []string {
+	return s.segs.sample(n)
+}
+
+func (s *Sketches) Exists(key string) bool {
+	defer s.segs.rlock(key)()
+	_, ok := s.segs.get(key)
+	return ok
+}
+
+func (s *Sketches) accessOf(key string) (*accessMap, func()) {
+	return s.segs.accessOf(key)
+}
+
+// TypeName names the sketch at key the way the Redis modules do, or returns
+// "" when there is none.
+func (s *Sketches) TypeName(key string) string {
+	defer s.segs.rlock(key)()
+	v, _ := s.segs.get(key)
+	switch v.(type) {
+	case *BloomFilter:
+		return "MBbloom--"
+	case *CuckooFilter:
+		return "MBbloomCF"
+	case *CountMinSketch:
+		return "CMSk-TYPE"
+	case *TopK:
+		return "TopK-TYPE"
+	}
+	return ""
+}
+
+func (s *Sketches) Delete(keys ...string) int {
+	return s.segs.deleteKeys(keys)
+}
+
+func (s *Sketches) Flush() {
+	s.segs.flush()
+}
@@ -0,0 +1,194 @@
+package datastructure
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomScaling(t *testing.T) {
+	s := CreateSketches()
+	s.BFReserve("bf", 0.01, 1000, 2, false)
+	for i := 0; i < 10000; i++ {
+		s.BFAdd("bf", "item"+strconv.Itoa(i))
+	}
+
+	info, _ := s.BFInfo("bf")
+	if info.Filters < 4 || info.Capacity < 10000 {
+		t.Errorf("Expected filter to scale, got %+v", info)
+	}
+	for i := 0; i < 10000; i++ {
+		if found, _ := s.BFExists("bf", "item"+strconv.Itoa(i)); !found[0] {
+			t.Fatalf("False negative for item%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if found, _ := s.BFExists("bf", "other"+strconv.Itoa(i)); found[0] {
+			falsePositives++
+		}
+	}
+	// The compound rate of a scalable filter stays under twice the target.
+	if falsePositives > 200 {
+		t.Errorf("Expected fewer than 2%% false positives, got %d", falsePositives)
+	}
+
+	if added, _ := s.BFAdd("bf", "item1"); added[0] {
+		t.Error("Expected existing item not to be reported as added")
+	}
+}
+
+func TestBloomNonScaling(t *testing.T) {
+	s := CreateSketches()
+	s.BFReserve("bf", 0.001, 10, 2, true)
+	added, err := s.BFAdd("bf", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k")
+	if err != ErrFilterFull || len(added) != 10 {
+		t.Errorf("Expected full after 10 items, got %d %v", len(added), err)
+	}
+	if err := s.BFReserve("bf", 0.01, 10, 2, false); err != ErrSketchExists {
+		t.Errorf("Expected exists error, got %v", err)
+	}
+}
+
+func TestCuckooAddDelete(t *testing.T) {
+	s := CreateSketches()
+	s.CFReserve("cf", 100, 2, 20, 2)
+	for i := 0; i < 1000; i++ {
+		if _, err := s.CFAdd("cf", "item"+strconv.Itoa(i), false); err != nil {
+			t.Fatalf("Add failed at %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if found, _ := s.CFExists("cf", "item"+strconv.Itoa(i)); !found[0] {
+			t.Fatalf("False negative for item%d", i)
+		}
+	}
+
+	s.CFAdd("cf", "dup", false)
+	s.CFAdd("cf", "dup", false)
+	if added, _ := s.CFAdd("cf", "dup", true); added {
+		t.Error("Expected ADDNX to skip existing item")
+	}
+	if n, _ := s.CFCount("cf", "dup"); n < 2 {
+		t.Errorf("Expected count of at least 2, got %d", n)
+	}
+	s.CFDel("cf", "dup")
+	s.CFDel("cf", "dup")
+	if found, _ := s.CFExists("cf", "dup"); found[0] {
+		t.Error("Expected item to be gone after deleting both copies")
+	}
+	if _, err := s.CFDel("missing", "x"); err != ErrSketchNoKey {
+		t.Errorf("Expected not found, got %v", err)
+	}
+}
+
+func TestCuckooFullWithoutExpansion(t *testing.T) {
+	cf := NewCuckooFilter(8, 2, 10, 0)
+	var err error
+	inserted := 0
+	for i := 0; i < 100 && err == nil; i++ {
+		if err = cf.add("item" + strconv.Itoa(i)); err == nil {
+			inserted++
+		}
+	}
+	if err != ErrFilterFull {
+		t.Fatalf("Expected filter to fill, got %v", err)
+	}
+	// A failed insert must not lose fingerprints that were relocated.
+	for i := 0; i < inserted; i++ {
+		if cf.count("item"+strconv.Itoa(i)) == 0 {
+			t.Errorf("Lost item%d after failed insert", i)
+		}
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := CreateSketches()
+	width, depth := CMSDimensions(0.001, 0.01)
+	if width != 2000 || depth != 7 {
+		t.Errorf("Unexpected dimensions %d x %d", width, depth)
+	}
+	s.CMSInit("cms", width, depth)
+
+	if _, err := s.CMSQuery("missing", "a"); err != ErrSketchNoKey {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		s.CMSIncrBy("cms", []string{"k" + strconv.Itoa(i%100)}, []uint64{1})
+	}
+	estimates, _ := s.CMSIncrBy("cms", []string{"hot"}, []uint64{500})
+	if estimates[0] < 500 || estimates[0] > 502 {
+		t.Errorf("Expected estimate near 500, got %d", estimates[0])
+	}
+	counts, _ := s.CMSQuery("cms", "k1", "never")
+	if counts[0] < 10 || counts[1] > 2 {
+		t.Errorf("Unexpected estimates %v", counts)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	s := CreateSketches()
+	s.TopKReserve("tk", 3, 50, 4, 0.9)
+
+	expelled := 0
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 20; i++ {
+			item := "rare" + strconv.Itoa(round*20+i)
+			if i < 3 {
+				item = "hot" + strconv.Itoa(i)
+			}
+			dropped, _ := s.TopKAdd("tk", item)
+			if dropped[0] != nil {
+				expelled++
+			}
+		}
+	}
+
+	list, _ := s.TopKList("tk")
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 items, got %v", list)
+	}
+	for _, e := range list {
+		if e.Item[:3] != "hot" || e.Count < 40 {
+			t.Errorf("Unexpected top item %+v", e)
+		}
+	}
+	if found, _ := s.TopKQuery("tk", "hot0", "rare5"); !found[0] || found[1] {
+		t.Errorf("Unexpected query result %v", found)
+	}
+}
+
+func TestSketchDumpRestore(t *testing.T) {
+	s := CreateSketches()
+	s.BFAdd("bf", "a", "b")
+	s.CFAdd("cf", "a", false)
+	s.CMSInit("cms", 100, 5)
+	s.CMSIncrBy("cms", []string{"a"}, []uint64{7})
+	s.TopKReserve("tk", 2, 8, 7, 0.9)
+	s.TopKAdd("tk", "x", "x", "y")
+
+	restored := CreateSketches()
+	for key, dump := range s.Dump() {
+		if err := restored.Restore(key, dump); err != nil {
+			t.Fatalf("Restore %s failed: %v", key, err)
+		}
+	}
+	if found, _ := restored.BFExists("bf", "a"); !found[0] {
+		t.Error("Expected bloom item after restore")
+	}
+	if found, _ := restored.CFExists("cf", "a"); !found[0] {
+		t.Error("Expected cuckoo item after restore")
+	}
+	if counts, _ := restored.CMSQuery("cms", "a"); counts[0] != 7 {
+		t.Errorf("Expected 7, got %d", counts[0])
+	}
+	if list, _ := restored.TopKList("tk"); len(list) != 2 || list[0].Item != "x" {
+		t.Errorf("Unexpected top-k after restore %v", list)
+	}
+
+	if _, err := restored.CFAdd("bf", "a", false); err != ErrWrongType {
+		t.Errorf("Expected wrong type, got %v", err)
+	}
+	if err := restored.Restore("bad", SketchDump{Kind: SketchBloom, Data: []byte("junk")}); err != ErrSketchCorrupt {
+		t.Errorf("Expected corrupt data error, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package datastructure
+
+import "sync/atomic"
+
+// Snapshots are copy-on-write. BeginSnapshot locks every segment of the
+// stores at once and marks them, which fixes the point in time without
+// copying anything. Until the snapshot has written a segment, the first
+// write to each of its keys saves the key's old value in snapshot form, and
+// the snapshot reads that instead of the live value. Only keys written
+// during a save are ever copied, and a segment's old values are dropped as
+// soon as it is written.
+
+type cowState[V any] struct {
+	// save returns v in snapshot form, or nil if snapshots leave it out,
+	// and the bytes the copy takes.
+	save func(key string, v V) (any, int64)
+	// old maps each key written since the snapshot began to its old value;
+	// nil means the key was absent.
+	old   map[string]any
+	bytes *atomic.Int64
+}
+
+// preserve runs under the segment's write lock.
+func (seg *segment[V]) preserve(key string) {
+	c := seg.cow
+	if c == nil {
+		return
+	}
+	if _, ok := c.old[key]; ok {
+		return
+	}
+	var old any
+	if v, ok := seg.items[key]; ok {
+		var size int64
+		old, size = c.save(key, v)
+		c.bytes.Add(size)
+	}
+	c.old[key] = old
+}
+
+func (segs segments[V]) preserve(keys []string) {
+	for _, key := range keys {
+		if seg := segs.of(key); seg.cow != nil {
+			seg.preserve(key)
+		}
+	}
+}
+
+type cowStore interface {
+	lockAll()
+	unlockAll()
+	// begin marks every segment; all of them must be locked.
+	begin(bytes *atomic.Int64)
+	// end stops saving old values for segments not written yet.
+	end()
+}
+
+type cowSegments[V, D any] struct {
+	segs segments[V]
+	// dump converts v to snapshot form; false leaves it out, as for an
+	// expired key.
+	dump  func(key string, v V) (D, bool)
+	usage func(key string, v V, samples int) int64
+}
+
+func (c cowSegments[V, D]) lockAll() {
+	for _, seg := range c.segs {
+		seg.lock()
+	}
+}
+
+func (c cowSegments[V, D]) unlockAll() {
+	for _, seg := range c.segs {
+		seg.unlock()
+	}
+}
+
+func (c cowSegments[V, D]) begin(bytes *atomic.Int64) {
+	save := func(key string, v V) (any, int64) {
+		d, ok := c.dump(key, v)
+		if !ok {
+			return nil, 0
+		}
+		return d, c.usage(key, v, 0)
+	}
+	for _, seg := range c.segs {
+		seg.cow = &cowState[V]{save: save, old: make(map[string]any), bytes: bytes}
+	}
+}
+
+func (c cowSegments[V, D]) end() {
+	for _, seg := range c.segs {
+		seg.exclusive(func() { seg.cow = nil })
+	}
+}
+
+func (c cowSegments[V, D]) dumpKeys(keys []string) map[string]D {
+	snapshot := make(map[string]D)
+	c.segs.forKeys(keys, func(key string, v V) {
+		if d, ok := c.dump(key, v); ok {
+			snapshot[key] = d
+		}
+	})
+	return snapshot
+}
+
+func (c cowSegments[V, D]) dumpSegment(i int) map[string]D {
+	snapshot := make(map[string]D)
+	if i < 0 || i >= len(c.segs) {
+		return snapshot
+	}
+	seg := c.segs[i]
+	seg.exclusive(func() {
+		var old map[string]any
+		if seg.cow != nil {
+			old = seg.cow.old
+			seg.cow = nil
+		}
+		for key, v := range seg.items {
+			if _, changed := old[key]; changed {
+				continue
+			}
+			if d, ok := c.dump(key, v); ok {
+				snapshot[key] = d
+			}
+		}
+		for key, v := range old {
+			if v != nil {
+				snapshot[key] = v.(D)
+			}
+		}
+	})
+	return snapshot
+}
+
+// SnapshotStore is a store BeginSnapshot can take a view of.
+type SnapshotStore interface {
+	cow() cowStore
+}
+
+// SnapshotView is a point-in-time view of some stores, read one segment at a
+// time with each store's DumpSegment. Only one may be open at a time.
+type SnapshotView struct {
+	stores []cowStore
+	bytes  atomic.Int64
+}
+
+// BeginSnapshot opens a view of stores as they are now. Close must be
+// called once the view has been read.
+func BeginSnapshot(stores ...SnapshotStore) *SnapshotView {
+	v := &SnapshotView{}
+	for _, s := range stores {
+		v.stores = append(v.stores, s.cow())
+	}
+	for _, s := range v.stores {
+		s.lockAll()
+	}
+	for _, s := range v.stores {
+		s.begin(&v.bytes)
+	}
+	for _, s := range v.stores {
+		s.unlockAll()
+	}
+	return v
+}
+
+// Close ends the view and returns how many bytes of old values it kept for
+// keys written while it was open.
+func (v *SnapshotView) Close() int64 {
+	for _, s := range v.stores {
+		s.end()
+	}
+	return v.bytes.Load()
+}
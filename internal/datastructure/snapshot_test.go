@@ -0,0 +1,107 @@
+package datastructure
+
+import (
+	"maps"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func dumpAll[V, D any](c cowSegments[V, D]) map[string]D {
+	all := make(map[string]D)
+	for i := range c.segs {
+		maps.Copy(all, c.dumpSegment(i))
+	}
+	return all
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	d := CreateDict()
+	h := CreateHashMap()
+	for i := range 100 {
+		d.Set("k"+strconv.Itoa(i), "old", 0)
+	}
+	d.Set("ttl", "old", time.Hour)
+	h.Hset("h", "f", "old")
+
+	view := BeginSnapshot(d, h)
+	d.Set("k0", "new", 0)
+	d.Set("fresh", "new", 0)
+	d.Delete("k1")
+	d.IncrBy("k2:n", 1)
+	d.Expire("ttl", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	d.activeExpire()
+	h.Hset("h", "f", "new")
+	h.Hset("h", "g", "new")
+
+	dict := dumpAll(d.snapshot())
+	if len(dict) != 101 {
+		t.Errorf("Expected the 101 keys of when the snapshot began, got %d", len(dict))
+	}
+	if dict["k0"].Value != "old" || dict["k1"].Value != "old" || dict["ttl"].Value != "old" {
+		t.Errorf("Expected old values, got %v %v %v", dict["k0"], dict["k1"], dict["ttl"])
+	}
+	if _, ok := dict["fresh"]; ok {
+		t.Error("Key created during the snapshot should be left out")
+	}
+	hash := dumpAll(h.snapshot())
+	if len(hash["h"]) != 1 || hash["h"]["f"] != "old" {
+		t.Errorf("Expected the hash as it was, got %v", hash["h"])
+	}
+
+	if cow := view.Close(); cow <= 0 {
+		t.Errorf("Expected copied bytes to be counted, got %d", cow)
+	}
+	if v, _ := d.Get("k0"); v != "new" {
+		t.Errorf("Live value should be new, got %q", v)
+	}
+}
+
+func TestSnapshotCopiesOnlyUnwritten(t *testing.T) {
+	d := CreateDict()
+	d.Set("a", "old", 0)
+	view := BeginSnapshot(d)
+	c := d.snapshot()
+	i := c.segs.index("a")
+	if got := c.dumpSegment(i); got["a"].Value != "old" {
+		t.Fatalf("Expected a, got %v", got)
+	}
+	d.Set("a", "new", 0)
+	if cow := view.Close(); cow != 0 {
+		t.Errorf("A written segment should not copy, got %d bytes", cow)
+	}
+	for _, seg := range c.segs {
+		if seg.cow != nil {
+			t.Fatal("Close should end copy-on-write")
+		}
+	}
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	d := CreateDict()
+	for i := range 1000 {
+		d.Set("k"+strconv.Itoa(i), "0", 0)
+	}
+	view := BeginSnapshot(d)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 5 {
+			for i := range 1000 {
+				d.IncrBy("k"+strconv.Itoa(i), 1)
+			}
+		}
+	}()
+	dict := dumpAll(d.snapshot())
+	<-done
+	view.Close()
+	for key, item := range dict {
+		if item.Value != "0" {
+			t.Fatalf("Expected %s at 0, got %q", key, item.Value)
+		}
+	}
+	if len(dict) != 1000 {
+		t.Errorf("Expected 1000 keys, got %d", len(dict))
+	}
+}
@@ -0,0 +1,657 @@
+package datastructure
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const tsChunkSamples = 256
+
+var (
+	ErrTSNoKey          = errors.New("ERR TSDB: the key does not exist")
+	ErrTSExists         = errors.New("ERR TSDB: key already exists")
+	ErrTSOld            = errors.New("ERR TSDB: Timestamp is older than retention")
+	ErrTSDuplicate      = errors.New("ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	ErrTSSameKey        = errors.New("ERR TSDB: the source key and destination key should be different")
+	ErrTSRuleExists     = errors.New("ERR TSDB: the destination key already has a src rule")
+	ErrTSRuleNotFound   = errors.New("ERR TSDB: compaction rule does not exist")
+	ErrTSCompactionLoop = errors.New("ERR TSDB: the source key is itself a compaction destination")
+)
+
+type DuplicatePolicy string
+
+const (
+	DuplicateBlock DuplicatePolicy = "BLOCK"
+	DuplicateFirst DuplicatePolicy = "FIRST"
+	DuplicateLast  DuplicatePolicy = "LAST"
+	DuplicateMin   DuplicatePolicy = "MIN"
+	DuplicateMax   DuplicatePolicy = "MAX"
+	DuplicateSum   DuplicatePolicy = "SUM"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, bool) {
+	p := DuplicatePolicy(strings.ToUpper(s))
+	switch p {
+	case DuplicateBlock, DuplicateFirst, DuplicateLast, DuplicateMin, DuplicateMax, DuplicateSum:
+		return p, true
+	}
+	return "", false
+}
+
+type Aggregator string
+
+const (
+	AggAvg   Aggregator = "AVG"
+	AggSum   Aggregator = "SUM"
+	AggMin   Aggregator = "MIN"
+	AggMax   Aggregator = "MAX"
+	AggCount Aggregator = "COUNT"
+	AggFirst Aggregator = "FIRST"
+	AggLast  Aggregator = "LAST"
+	AggRange Aggregator = "RANGE"
+)
+
+func ParseAggregator(s string) (Aggregator, bool) {
+	a := Aggregator(strings.ToUpper(s))
+	switch a {
+	case AggAvg, AggSum, AggMin, AggMax, AggCount, AggFirst, AggLast, AggRange:
+		return a, true
+	}
+	return "", false
+}
+
+type Sample struct {
+	Timestamp int64
+	Value     float64
+}
+
+type TSOptions struct {
+	Retention int64
+	Duplicate DuplicatePolicy
+	Labels    map[string]string
+}
+
+type Aggregation struct {
+	Type   Aggregator
+	Bucket int64
+}
+
+type CompactionRule struct {
+	Dest        string
+	Aggregation Aggregation
+}
+
+// LabelFilter matches series whose label is one of Values; an empty value
+// stands for a missing label. Negate inverts the match.
+type LabelFilter struct {
+	Label  string
+	Values []string
+	Negate bool
+}
+
+type SeriesRange struct {
+	Key     string
+	Labels  map[string]string
+	Samples []Sample
+}
+
+type TSInfo struct {
+	TotalSamples int
+	MemoryUsage  int
+	First        int64
+	Last         int64
+	ChunkCount   int
+	Options      TSOptions
+	SourceKey    string
+	Rules        []CompactionRule
+}
+
+// TSDump is the persisted form of one series.
+type TSDump struct {
+	Options   TSOptions
+	Samples   []Sample
+	Rules     []CompactionRule
+	SourceKey string
+}
+
+type timeSeries struct {
+	opts      TSOptions
+	chunks    []*gorillaChunk
+	rules     []*compaction
+	sourceKey string
+}
+
+type compaction struct {
+	CompactionRule
+	open    int64
+	hasOpen bool
+}
+
+type TimeSeries struct {
+	segs segments[*timeSeries]
+}
+
+func CreateTimeSeries() *TimeSeries {
+	return &TimeSeries{
+		segs: newSegments[*timeSeries](GetSegmentCount()),
+	}
+}
+
+// The segments of keys must be locked.
+func (t *TimeSeries) linked(keys []string) []string {
+	out := slices.Clone(keys)
+	for _, key := range keys {
+		s, ok := t.segs.get(key)
+		if !ok {
+			continue
+		}
+		if s.sourceKey != "" {
+			out = append(out, s.sourceKey)
+		}
+		for _, rule := range s.rules {
+			out = append(out, rule.Dest)
+		}
+	}
+	return out
+}
+
+func (t *TimeSeries) lockLinked(keys ...string) func() {
+	unlock := t.segs.rlock(keys...)
+	want := t.linked(keys)
+	unlock()
+	for {
+		unlock := t.segs.lock(want...)
+		held := t.segs.indexes(want)
+		now := t.linked(keys)
+		if !slices.ContainsFunc(t.segs.indexes(now), func(i int) bool {
+			_, found := slices.BinarySearch(held, i)
+			return !found
+		}) {
+			return unlock
+		}
+		unlock()
+		want = now
+	}
+}
+
+func newTimeSeries(opts TSOptions) *timeSeries {
+	if opts.Duplicate == "" {
+		opts.Duplicate = DuplicateBlock
+	}
+	labels := make(map[string]string, len(opts.Labels))
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	opts.Labels = labels
+	return &timeSeries{opts: opts}
+}
+
+func (t *TimeSeries) TSCreate(key string, opts TSOptions) error {
+	defer t.segs.lock(key)()
+
+	if _, exists := t.segs.get(key); exists {
+		return ErrTSExists
+	}
+	t.segs.put(key, newTimeSeries(opts))
+	return nil
+}
+
+// TSAdd appends a sample, creating the series with opts when it is missing.
+// onDuplicate overrides the series policy when set.
+func (t *TimeSeries) TSAdd(key string, ts int64, value float64, opts *TSOptions, onDuplicate DuplicatePolicy) (int64, error) {
+	defer t.lockLinked(key)()
+
+	s, exists := t.segs.get(key)
+	if !exists {
+		if opts == nil {
+			return 0, ErrTSNoKey
+		}
+		s = newTimeSeries(*opts)
+		t.segs.put(key, s)
+	}
+	policy := s.opts.Duplicate
+	if onDuplicate != "" {
+		policy = onDuplicate
+	}
+	if err := s.upsert(ts, value, policy); err != nil {
+		return 0, err
+	}
+	t.compact(s, ts)
+	return ts, nil
+}
+
+func (s *timeSeries) lastTimestamp() (int64, bool) {
+	if len(s.chunks) == 0 {
+		return 0, false
+	}
+	return s.chunks[len(s.chunks)-1].last, true
+}
+
+func (s *timeSeries) upsert(ts int64, value float64, policy DuplicatePolicy) error {
+	last, ok := s.lastTimestamp()
+	if ok && s.opts.Retention > 0 && ts < last-s.opts.Retention {
+		return ErrTSOld
+	}
+	if !ok || ts > last {
+		tail := len(s.chunks) - 1
+		if tail < 0 || s.chunks[tail].count >= tsChunkSamples {
+			s.chunks = append(s.chunks, &gorillaChunk{})
+			tail++
+		}
+		s.chunks[tail].append(ts, value)
+		s.trim()
+		return nil
+	}
+
+	// Out of order samples rewrite the chunk that covers them.
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].last >= ts })
+	samples := s.chunks[i].samples()
+	j := sort.Search(len(samples), func(j int) bool { return samples[j].Timestamp >= ts })
+	if j < len(samples) && samples[j].Timestamp == ts {
+		old := samples[j].Value
+		switch policy {
+		case DuplicateBlock:
+			return ErrTSDuplicate
+		case DuplicateFirst:
+			value = old
+		case DuplicateMin:
+			value = math.Min(old, value)
+		case DuplicateMax:
+			value = math.Max(old, value)
+		case DuplicateSum:
+			value += old
+		}
+		samples[j].Value = value
+	} else {
+		samples = append(samples, Sample{})
+		copy(samples[j+1:], samples[j:])
+		samples[j] = Sample{Timestamp: ts, Value: value}
+	}
+	s.chunks[i] = encodeChunk(samples)
+	return nil
+}
+
+func (s *timeSeries) trim() {
+	if s.opts.Retention <= 0 {
+		return
+	}
+	last, _ := s.lastTimestamp()
+	oldest := last - s.opts.Retention
+	drop := 0
+	for drop < len(s.chunks)-1 && s.chunks[drop].last < oldest {
+		drop++
+	}
+	s.chunks = s.chunks[drop:]
+}
+
+func (s *timeSeries) rangeSamples(from, to int64) []Sample {
+	if last, ok := s.lastTimestamp(); ok && s.opts.Retention > 0 && from < last-s.opts.Retention {
+		from = last - s.opts.Retention
+	}
+	out := []Sample{}
+	for _, c := range s.chunks {
+		if c.count == 0 || c.last < from || c.first > to {
+			continue
+		}
+		for _, sample := range c.samples() {
+			if sample.Timestamp >= from && sample.Timestamp <= to {
+				out = append(out, sample)
+			}
+		}
+	}
+	return out
+}
+
+func (s *timeSeries) countSamples() int {
+	n := 0
+	for _, c := range s.chunks {
+		n += c.count
+	}
+	return n
+}
+
+func bucketStart(ts, bucket int64) int64 {
+	start := ts - ts%bucket
+	if ts < 0 && ts%bucket != 0 {
+		start -= bucket
+	}
+	return start
+}
+
+func (t *TimeSeries) compact(s *timeSeries, ts int64) {
+	for _, rule := range s.rules {
+		dest, ok := t.segs.get(rule.Dest)
+		if !ok {
+			continue
+		}
+		bucket := bucketStart(ts, rule.Aggregation.Bucket)
+		switch {
+		case !rule.hasOpen:
+			rule.open, rule.hasOpen = bucket, true
+		case bucket > rule.open:
+			t.flushBucket(s, dest, rule, rule.open)
+			rule.open = bucket
+		case bucket < rule.open:
+			t.flushBucket(s, dest, rule, bucket)
+		}
+	}
+}
+
+func (t *TimeSeries) flushBucket(src, dest *timeSeries, rule *compaction, start int64) {
+	samples := src.rangeSamples(start, start+rule.Aggregation.Bucket-1)
+	if len(samples) == 0 {
+		return
+	}
+	value := aggregate(rule.Aggregation.Type, samples)
+	_ = dest.upsert(start, value, DuplicateLast)
+}
+
+func aggregate(agg Aggregator, samples []Sample) float64 {
+	switch agg {
+	case AggCount:
+		return float64(len(samples))
+	case AggFirst:
+		return samples[0].Value
+	case AggLast:
+		return samples[len(samples)-1].Value
+	}
+	sum, lo, hi := 0.0, math.Inf(1), math.Inf(-1)
+	for _, s := range samples {
+		sum += s.Value
+		lo = math.Min(lo, s.Value)
+		hi = math.Max(hi, s.Value)
+	}
+	switch agg {
+	case AggSum:
+		return sum
+	case AggMin:
+		return lo
+	case AggMax:
+		return hi
+	case AggRange:
+		return hi - lo
+	}
+	return sum / float64(len(samples))
+}
+
+func aggregateSamples(samples []Sample, agg *Aggregation) []Sample {
+	if agg == nil || len(samples) == 0 {
+		return samples
+	}
+	out := []Sample{}
+	start := 0
+	for start < len(samples) {
+		bucket := bucketStart(samples[start].Timestamp, agg.Bucket)
+		end := start
+		for end < len(samples) && samples[end].Timestamp < bucket+agg.Bucket {
+			end++
+		}
+		out = append(out, Sample{Timestamp: bucket, Value: aggregate(agg.Type, samples[start:end])})
+		start = end
+	}
+	return out
+}
+
+func reverseSamples(samples []Sample) {
+	for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+		samples[i], samples[j] = samples[j], samples[i]
+	}
+}
+
+func queryRange(s *timeSeries, from, to int64, reverse bool, agg *Aggregation, count int) []Sample {
+	samples := aggregateSamples(s.rangeSamples(from, to), agg)
+	if reverse {
+		reverseSamples(samples)
+	}
+	if count > 0 && len(samples) > count {
+		samples = samples[:count]
+	}
+	return samples
+}
+
+// TSRange returns samples in [from, to], optionally aggregated into buckets
+// and limited to count results.
+func (t *TimeSeries) TSRange(key string, from, to int64, reverse bool, agg *Aggregation, count int) ([]Sample, error) {
+	defer t.segs.rlock(key)()
+
+	s, exists := t.segs.get(key)
+	if !exists {
+		return nil, ErrTSNoKey
+	}
+	return queryRange(s, from, to, reverse, agg, count), nil
+}
+
+func (t *TimeSeries) TSMRange(from, to int64, reverse bool, agg *Aggregation, count int, filters []LabelFilter) []SeriesRange {
+	out := []SeriesRange{}
+	t.segs.each(func(key string, s *timeSeries) {
+		if !matchLabels(s.opts.Labels, filters) {
+			return
+		}
+		labels := make(map[string]string, len(s.opts.Labels))
+		for k, v := range s.opts.Labels {
+			labels[k] = v
+		}
+		out = append(out, SeriesRange{Key: key, Labels: labels, Samples: queryRange(s, from, to, reverse, agg, count)})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func matchLabels(labels map[string]string, filters []LabelFilter) bool {
+	for _, f := range filters {
+		value := labels[f.Label]
+		matched := false
+		for _, v := range f.Values {
+			if v == value {
+				matched = true
+				break
+			}
+		}
+		if matched == f.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TimeSeries) TSGet(key string) (Sample, bool, error) {
+	defer t.segs.rlock(key)()
+
+	s, exists := t.segs.get(key)
+	if !exists {
+		return Sample{}, false, ErrTSNoKey
+	}
+	if len(s.chunks) == 0 {
+		return Sample{}, false, nil
+	}
+	c := s.chunks[len(s.chunks)-1]
+	return Sample{Timestamp: c.last, Value: c.lastVal}, true, nil
+}
+
+func (t *TimeSeries) TSCreateRule(src, dest string, agg Aggregation) error {
+	if src == dest {
+		return ErrTSSameKey
+	}
+
+	defer t.segs.lock(src, dest)()
+
+	s, ok := t.segs.get(src)
+	d, ok2 := t.segs.get(dest)
+	if !ok || !ok2 {
+		return ErrTSNoKey
+	}
+	if d.sourceKey != "" {
+		return ErrTSRuleExists
+	}
+	if s.sourceKey != "" || len(d.rules) > 0 {
+		return ErrTSCompactionLoop
+	}
+
+	rule := &compaction{CompactionRule: CompactionRule{Dest: dest, Aggregation: agg}}
+	if last, ok := s.lastTimestamp(); ok {
+		rule.open, rule.hasOpen = bucketStart(last, agg.Bucket), true
+	}
+	s.rules = append(s.rules, rule)
+	d.sourceKey = src
+	return nil
+}
+
+func (t *TimeSeries) TSDeleteRule(src, dest string) error {
+	defer t.segs.lock(src, dest)()
+
+	s, ok := t.segs.get(src)
+	if !ok {
+		return ErrTSNoKey
+	}
+	for i, rule := range s.rules {
+		if rule.Dest == dest {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			if d, ok := t.segs.get(dest); ok {
+				d.sourceKey = ""
+			}
+			return nil
+		}
+	}
+	return ErrTSRuleNotFound
+}
+
+func (t *TimeSeries) TSInfo(key string) (TSInfo, error) {
+	defer t.segs.rlock(key)()
+
+	s, exists := t.segs.get(key)
+	if !exists {
+		return TSInfo{}, ErrTSNoKey
+	}
+	info := TSInfo{
+		TotalSamples: s.countSamples(),
+		ChunkCount:   len(s.chunks),
+		Options:      s.opts,
+		SourceKey:    s.sourceKey,
+	}
+	for _, c := range s.chunks {
+		info.MemoryUsage += c.size()
+	}
+	if len(s.chunks) > 0 {
+		info.First = s.chunks[0].first
+		info.Last = s.chunks[len(s.chunks)-1].last
+	}
+	for _, rule := range s.rules {
+		info.Rules = append(info.Rules, rule.CompactionRule)
+	}
+	return info, nil
+}
+
+// TSRestore replaces key with a dumped series without running compactions.
+func (t *TimeSeries) TSRestore(key string, dump TSDump) {
+	defer t.segs.lock(key)()
+
+	s := newTimeSeries(dump.Options)
+	for start := 0; start < len(dump.Samples); start += tsChunkSamples {
+		end := min(start+tsChunkSamples, len(dump.Samples))
+		s.chunks = append(s.chunks, encodeChunk(dump.Samples[start:end]))
+	}
+	last, ok := s.lastTimestamp()
+	for _, rule := range dump.Rules {
+		c := &compaction{CompactionRule: rule}
+		if ok {
+			c.open, c.hasOpen = bucketStart(last, rule.Aggregation.Bucket), true
+		}
+		s.rules = append(s.rules, c)
+	}
+	s.sourceKey = dump.SourceKey
+	t.segs.put(key, s)
+}
+
+func dumpSeries(key string, s *timeSeries) (TSDump, bool) {
+	dump := TSDump{
+		Options:   TSOptions{Retention: s.opts.Retention, Duplicate: s.opts.Duplicate, Labels: map[string]string{}},
+		Samples:   make([]Sample, 0, s.countSamples()),
+		SourceKey: s.sourceKey,
+	}
+	for k, v := range s.opts.Labels {
+		dump.Options.Labels[k] = v
+	}
+	for _, c := range s.chunks {
+		dump.Samples = append(dump.Samples, c.samples()...)
+	}
+	for _, rule := range s.rules {
+		dump.Rules = append(dump.Rules, rule.CompactionRule)
+	}
+	return dump, true
+}
+
+func (t *TimeSeries) snapshot() cowSegments[*timeSeries, TSDump] {
+	return cowSegments[*timeSeries, TSDump]{segs: t.segs, dump: dumpSeries, usage: seriesUsage}
+}
+
+func (t *TimeSeries) cow() cowStore {
+	return t.snapshot()
+}
+
+func (t *TimeSeries) Dump(keys ...string) map[string]TSDump {
+	return t.snapshot().dumpKeys(keys)
+}
+
+func (t *TimeSeries) DumpSegment(i int) map[string]TSDump {
+	return t.snapshot().dumpSegment(i)
+}
+
+func (t *TimeSeries) Keys() []string {
+	return t.segs.keys(nil)
+}
+
+func (t *TimeSeries) SegmentKeys(i int) []string {
+	return t.segs.segmentKeys(i, nil)
+}
+
+func (t *TimeSeries) Segments() int {
+	return len(t.segs)
+}
+
+func (t *TimeSeries) Len() int {
+	return t.segs.count()
+}
+
+func (t *TimeSeries) Sample(n int) []string {
+	return t.segs.sample(n)
+}
+
+func (t *TimeSeries) Exists(key string) bool {
+	defer t.segs.rlock(key)()
+	_, ok := t.segs.get(key)
+	return ok
+}
+
+func (t *TimeSeries) accessOf(key string) (*accessMap, func()) {
+	return t.segs.accessOf(key)
+}
+
+// Delete removes series along with the compaction rules that feed them.
+func (t *TimeSeries) Delete(keys ...string) int {
+	defer t.lockLinked(keys...)()
+	count := 0
+	for _, key := range keys {
+		s, ok := t.segs.get(key)
+		if !ok {
+			continue
+		}
+		if src, ok := t.segs.get(s.sourceKey); ok {
+			src.rules = slices.DeleteFunc(src.rules, func(r *compaction) bool { return r.Dest == key })
+		}
+		for _, rule := range s.rules {
+			if dest, ok := t.segs.get(rule.Dest); ok {
+				dest.sourceKey = ""
+			}
+		}
+		t.segs.del(key)
+		count++
+	}
+	return count
+}
+
+func (t *TimeSeries) Flush() {
+	t.segs.flush()
+}
@@ -0,0 +1,221 @@
+package datastructure
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestGorillaRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	samples := []Sample{}
+	ts := int64(1700000000000)
+	value := 20.0
+	for i := 0; i < 1000; i++ {
+		ts += 1000 + int64(r.Intn(5000)-2500)
+		if i%7 == 0 {
+			ts += 1 << 20
+		}
+		if i%3 != 0 {
+			value += r.Float64() - 0.5
+		}
+		samples = append(samples, Sample{Timestamp: ts, Value: value})
+	}
+	samples = append(samples, Sample{Timestamp: ts + 1, Value: math.Inf(1)}, Sample{Timestamp: ts + 2, Value: -0.0})
+
+	c := encodeChunk(samples)
+	got := c.samples()
+	if len(got) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(got))
+	}
+	for i := range samples {
+		if got[i].Timestamp != samples[i].Timestamp || math.Float64bits(got[i].Value) != math.Float64bits(samples[i].Value) {
+			t.Fatalf("Sample %d: expected %v, got %v", i, samples[i], got[i])
+		}
+	}
+	if c.size() >= len(samples)*16 {
+		t.Errorf("Expected compression, got %d bytes for %d samples", c.size(), len(samples))
+	}
+}
+
+func TestGorillaRegularSeriesCompresses(t *testing.T) {
+	c := &gorillaChunk{}
+	for i := 0; i < tsChunkSamples; i++ {
+		c.append(int64(i)*1000, 42)
+	}
+	// Constant interval and value cost two bits per sample after the first delta.
+	if c.size() > 16+8+1+tsChunkSamples/4 {
+		t.Errorf("Expected about 2 bits per sample, got %d bytes", c.size())
+	}
+}
+
+func TestTSAddRange(t *testing.T) {
+	ts := CreateTimeSeries()
+	if _, err := ts.TSAdd("cpu", 10, 1, nil, ""); err != ErrTSNoKey {
+		t.Errorf("Expected missing key error, got %v", err)
+	}
+	ts.TSCreate("cpu", TSOptions{Labels: map[string]string{"host": "a"}})
+	if err := ts.TSCreate("cpu", TSOptions{}); err != ErrTSExists {
+		t.Errorf("Expected exists error, got %v", err)
+	}
+	for i := int64(0); i < 600; i++ {
+		ts.TSAdd("cpu", i*10, float64(i), nil, "")
+	}
+
+	samples, _ := ts.TSRange("cpu", 100, 150, false, nil, 0)
+	if len(samples) != 6 || samples[0].Timestamp != 100 || samples[5].Value != 15 {
+		t.Errorf("Unexpected range %v", samples)
+	}
+	samples, _ = ts.TSRange("cpu", 0, math.MaxInt64, true, nil, 2)
+	if len(samples) != 2 || samples[0].Timestamp != 5990 {
+		t.Errorf("Unexpected reverse range %v", samples)
+	}
+
+	samples, _ = ts.TSRange("cpu", 0, 99, false, &Aggregation{Type: AggAvg, Bucket: 50}, 0)
+	if len(samples) != 2 || samples[0].Value != 2 || samples[1].Timestamp != 50 || samples[1].Value != 7 {
+		t.Errorf("Unexpected avg buckets %v", samples)
+	}
+	for agg, want := range map[Aggregator]float64{AggSum: 10, AggMin: 0, AggMax: 4, AggCount: 5, AggFirst: 0, AggLast: 4, AggRange: 4} {
+		samples, _ = ts.TSRange("cpu", 0, 49, false, &Aggregation{Type: agg, Bucket: 50}, 0)
+		if samples[0].Value != want {
+			t.Errorf("%s: expected %v, got %v", agg, want, samples[0].Value)
+		}
+	}
+
+	if last, ok, _ := ts.TSGet("cpu"); !ok || last.Timestamp != 5990 {
+		t.Errorf("Unexpected last sample %v", last)
+	}
+	info, _ := ts.TSInfo("cpu")
+	if info.TotalSamples != 600 || info.ChunkCount != 3 || info.First != 0 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+}
+
+func TestTSDuplicatePolicy(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSCreate("s", TSOptions{})
+	ts.TSAdd("s", 10, 1, nil, "")
+	ts.TSAdd("s", 30, 3, nil, "")
+	if _, err := ts.TSAdd("s", 10, 5, nil, ""); err != ErrTSDuplicate {
+		t.Errorf("Expected block error, got %v", err)
+	}
+	ts.TSAdd("s", 10, 5, nil, DuplicateSum)
+	ts.TSAdd("s", 30, 1, nil, DuplicateMax)
+	ts.TSAdd("s", 20, 2, nil, "")
+
+	samples, _ := ts.TSRange("s", 0, 100, false, nil, 0)
+	want := []Sample{{10, 6}, {20, 2}, {30, 3}}
+	if len(samples) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, samples)
+	}
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], samples[i])
+		}
+	}
+}
+
+func TestTSRetention(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSAdd("s", 0, 1, &TSOptions{Retention: 100}, "")
+	for i := int64(1); i <= 1000; i++ {
+		ts.TSAdd("s", i, 1, nil, "")
+	}
+	if _, err := ts.TSAdd("s", 800, 1, nil, DuplicateLast); err != ErrTSOld {
+		t.Errorf("Expected retention error, got %v", err)
+	}
+	samples, _ := ts.TSRange("s", 0, 1000, false, nil, 0)
+	if len(samples) != 101 || samples[0].Timestamp != 900 {
+		t.Errorf("Expected samples 900..1000, got %d starting at %v", len(samples), samples[0])
+	}
+	if info, _ := ts.TSInfo("s"); info.ChunkCount > 2 {
+		t.Errorf("Expected old chunks to be dropped, got %d", info.ChunkCount)
+	}
+}
+
+func TestTSCompaction(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSCreate("raw", TSOptions{})
+	ts.TSCreate("avg", TSOptions{})
+	if err := ts.TSCreateRule("raw", "raw", Aggregation{AggAvg, 10}); err != ErrTSSameKey {
+		t.Errorf("Expected same key error, got %v", err)
+	}
+	ts.TSCreateRule("raw", "avg", Aggregation{AggAvg, 10})
+	if err := ts.TSCreateRule("raw", "avg", Aggregation{AggSum, 10}); err != ErrTSRuleExists {
+		t.Errorf("Expected rule exists error, got %v", err)
+	}
+
+	for i := int64(0); i < 35; i++ {
+		ts.TSAdd("raw", i, float64(i), nil, "")
+	}
+	samples, _ := ts.TSRange("avg", 0, 100, false, nil, 0)
+	if len(samples) != 3 || samples[0].Value != 4.5 || samples[2].Timestamp != 20 {
+		t.Errorf("Unexpected compacted samples %v", samples)
+	}
+
+	ts.TSAdd("raw", 5, 100, nil, DuplicateLast)
+	samples, _ = ts.TSRange("avg", 0, 0, false, nil, 0)
+	if samples[0].Value != 14 {
+		t.Errorf("Expected late sample to recompute bucket, got %v", samples[0].Value)
+	}
+
+	if err := ts.TSDeleteRule("raw", "avg"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := ts.TSDeleteRule("raw", "avg"); err != ErrTSRuleNotFound {
+		t.Errorf("Expected rule not found, got %v", err)
+	}
+}
+
+func TestTSMRange(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSCreate("a", TSOptions{Labels: map[string]string{"metric": "cpu", "host": "h1"}})
+	ts.TSCreate("b", TSOptions{Labels: map[string]string{"metric": "cpu", "host": "h2"}})
+	ts.TSCreate("c", TSOptions{Labels: map[string]string{"metric": "mem"}})
+	for _, key := range []string{"a", "b", "c"} {
+		ts.TSAdd(key, 1, 1, nil, "")
+	}
+
+	result := ts.TSMRange(0, 10, false, nil, 0, []LabelFilter{{Label: "metric", Values: []string{"cpu"}}})
+	if len(result) != 2 || result[0].Key != "a" || result[1].Key != "b" {
+		t.Errorf("Unexpected series %v", result)
+	}
+	result = ts.TSMRange(0, 10, false, nil, 0, []LabelFilter{{Label: "metric", Values: []string{"cpu", "mem"}}, {Label: "host", Values: []string{"h2"}, Negate: true}})
+	if len(result) != 2 || result[0].Key != "a" || result[1].Key != "c" {
+		t.Errorf("Unexpected series %v", result)
+	}
+	result = ts.TSMRange(0, 10, false, nil, 0, []LabelFilter{{Label: "host", Values: []string{""}}})
+	if len(result) != 1 || result[0].Key != "c" {
+		t.Errorf("Expected only series without host, got %v", result)
+	}
+}
+
+func TestTSDumpRestore(t *testing.T) {
+	ts := CreateTimeSeries()
+	ts.TSCreate("raw", TSOptions{Retention: 5000, Duplicate: DuplicateLast, Labels: map[string]string{"k": "v"}})
+	ts.TSCreate("sum", TSOptions{})
+	ts.TSCreateRule("raw", "sum", Aggregation{AggSum, 100})
+	for i := int64(0); i < 300; i++ {
+		ts.TSAdd("raw", i*10, 1, nil, "")
+	}
+
+	restored := CreateTimeSeries()
+	for key, dump := range ts.Dump() {
+		restored.TSRestore(key, dump)
+	}
+	restored.TSAdd("raw", 3000, 1, nil, "")
+	ts.TSAdd("raw", 3000, 1, nil, "")
+
+	want, _ := ts.TSRange("sum", 0, math.MaxInt64, false, nil, 0)
+	got, _ := restored.TSRange("sum", 0, math.MaxInt64, false, nil, 0)
+	if len(got) != len(want) || got[len(got)-1] != want[len(want)-1] {
+		t.Errorf("Expected %v after restore, got %v", want, got)
+	}
+	info, _ := restored.TSInfo("raw")
+	if info.Options.Retention != 5000 || info.Options.Duplicate != DuplicateLast || info.Options.Labels["k"] != "v" {
+		t.Errorf("Unexpected restored options %+v", info.Options)
+	}
+	if info, _ := restored.TSInfo("sum"); info.SourceKey != "raw" {
+		t.Errorf("Expected source key raw, got %q", info.SourceKey)
+	}
+}
@@ -0,0 +1,110 @@
+package datastructure
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	DefaultTopKWidth = 8
+	DefaultTopKDepth = 7
+	DefaultTopKDecay = 0.9
+)
+
+type topkBucket struct {
+	Fingerprint uint64
+	Count       uint64
+}
+
+type TopKItem struct {
+	Item  string
+	Count uint64
+}
+
+// TopK tracks the K heaviest items with the HeavyKeeper algorithm.
+type TopK struct {
+	K       int
+	Width   uint64
+	Depth   uint64
+	Decay   float64
+	Buckets []topkBucket
+	Heap    []TopKItem
+	Rand    uint64
+}
+
+func NewTopK(k int, width, depth uint64, decay float64) *TopK {
+	return &TopK{
+		K:       k,
+		Width:   width,
+		Depth:   depth,
+		Decay:   decay,
+		Buckets: make([]topkBucket, width*depth),
+		Rand:    0x2545f4914f6cdd1d,
+	}
+}
+
+func (t *TopK) random() float64 {
+	t.Rand ^= t.Rand << 13
+	t.Rand ^= t.Rand >> 7
+	t.Rand ^= t.Rand << 17
+	return float64(t.Rand>>11) / (1 << 53)
+}
+
+func (t *TopK) add(item string) (string, bool) {
+	fp, h2 := sketchHash(item)
+	var count uint64
+	for row := uint64(0); row < t.Depth; row++ {
+		b := &t.Buckets[row*t.Width+(fp+row*h2)%t.Width]
+		switch {
+		case b.Count == 0:
+			b.Fingerprint, b.Count = fp, 1
+		case b.Fingerprint == fp:
+			b.Count++
+		case t.random() < math.Pow(t.Decay, float64(b.Count)):
+			b.Count--
+			if b.Count == 0 {
+				b.Fingerprint, b.Count = fp, 1
+			}
+		}
+		if b.Fingerprint == fp {
+			count = max(count, b.Count)
+		}
+	}
+	return t.offer(item, count)
+}
+
+func (t *TopK) offer(item string, count uint64) (string, bool) {
+	for i := range t.Heap {
+		if t.Heap[i].Item == item {
+			t.Heap[i].Count = max(t.Heap[i].Count, count)
+			t.sortHeap()
+			return "", false
+		}
+	}
+	if len(t.Heap) < t.K {
+		t.Heap = append(t.Heap, TopKItem{Item: item, Count: count})
+		t.sortHeap()
+		return "", false
+	}
+	last := len(t.Heap) - 1
+	if count <= t.Heap[last].Count {
+		return "", false
+	}
+	dropped := t.Heap[last].Item
+	t.Heap[last] = TopKItem{Item: item, Count: count}
+	t.sortHeap()
+	return dropped, true
+}
+
+func (t *TopK) sortHeap() {
+	sort.SliceStable(t.Heap, func(i, j int) bool { return t.Heap[i].Count > t.Heap[j].Count })
+}
+
+func (t *TopK) query(item string) bool {
+	for _, e := range t.Heap {
+		if e.Item == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,301 @@
+package datastructure
+
+type ScoreMember struct {
+	Member string
+	Score  float64
+}
+
+type ScoreRange struct {
+	Min   float64
+	Max   float64
+	MinEx bool
+	MaxEx bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+type ZAddFlags struct {
+	NX bool
+	XX bool
+	CH bool
+}
+
+type sortedSet struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64), zsl: newSkiplist()}
+}
+
+func (ss *sortedSet) add(member string, score float64) (added bool, changed bool) {
+	old, exists := ss.scores[member]
+	if exists {
+		if old == score {
+			return false, false
+		}
+		ss.zsl.delete(old, member)
+		ss.zsl.insert(score, member)
+		ss.scores[member] = score
+		return false, true
+	}
+	ss.zsl.insert(score, member)
+	ss.scores[member] = score
+	return true, true
+}
+
+func (ss *sortedSet) remove(member string) bool {
+	score, exists := ss.scores[member]
+	if !exists {
+		return false
+	}
+	ss.zsl.delete(score, member)
+	delete(ss.scores, member)
+	return true
+}
+
+type ZSet struct {
+	segs segments[*sortedSet]
+}
+
+func CreateZSet() *ZSet {
+	return &ZSet{
+		segs: newSegments[*sortedSet](GetSegmentCount()),
+	}
+}
+
+func (z *ZSet) Zadd(key string, flags ZAddFlags, entries ...ScoreMember) int {
+	defer z.segs.lock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		if flags.XX {
+			return 0
+		}
+		ss = newSortedSet()
+		z.segs.put(key, ss)
+	}
+
+	count := 0
+	for _, e := range entries {
+		_, exists := ss.scores[e.Member]
+		if (flags.NX && exists) || (flags.XX && !exists) {
+			continue
+		}
+		added, changed := ss.add(e.Member, e.Score)
+		if added || (flags.CH && changed) {
+			count++
+		}
+	}
+	if len(ss.scores) == 0 {
+		z.segs.del(key)
+	}
+	return count
+}
+
+func (z *ZSet) Zrem(key string, members ...string) int {
+	defer z.segs.lock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return 0
+	}
+	removed := 0
+	for _, m := range members {
+		if ss.remove(m) {
+			removed++
+		}
+	}
+	if len(ss.scores) == 0 {
+		z.segs.del(key)
+	}
+	return removed
+}
+
+func (z *ZSet) Zscore(key, member string) (float64, bool) {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return 0, false
+	}
+	score, ok := ss.scores[member]
+	return score, ok
+}
+
+func (z *ZSet) Zcard(key string) int {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return 0
+	}
+	return len(ss.scores)
+}
+
+func (z *ZSet) Zrank(key, member string, reverse bool) (int, bool) {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return 0, false
+	}
+	score, ok := ss.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := ss.zsl.rank(score, member)
+	if reverse {
+		return ss.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+func (z *ZSet) Zrange(key string, start, stop int, reverse bool) []ScoreMember {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return []ScoreMember{}
+	}
+
+	size := ss.zsl.length
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return []ScoreMember{}
+	}
+
+	result := make([]ScoreMember, 0, stop-start+1)
+	if reverse {
+		x := ss.zsl.byRank(size - start)
+		for i := start; i <= stop && x != nil; i++ {
+			result = append(result, ScoreMember{Member: x.member, Score: x.score})
+			x = x.backward
+		}
+		return result
+	}
+	x := ss.zsl.byRank(start + 1)
+	for i := start; i <= stop && x != nil; i++ {
+		result = append(result, ScoreMember{Member: x.member, Score: x.score})
+		x = x.level[0].forward
+	}
+	return result
+}
+
+func (z *ZSet) ZrangeByScore(key string, r ScoreRange) []ScoreMember {
+	defer z.segs.rlock(key)()
+
+	ss, _ := z.segs.get(key)
+	if ss == nil {
+		return []ScoreMember{}
+	}
+	return ss.rangeByScore(r)
+}
+
+func (ss *sortedSet) rangeByScore(r ScoreRange) []ScoreMember {
+	result := []ScoreMember{}
+	for x := ss.zsl.firstInRange(r); x != nil && r.belowMax(x.score); x = x.level[0].forward {
+		result = append(result, ScoreMember{Member: x.member, Score: x.score})
+	}
+	return result
+}
+
+// Zstore replaces key with exactly the given entries, deleting it when empty.
+func (z *ZSet) Zstore(key string, entries []ScoreMember) int {
+	defer z.segs.lock(key)()
+
+	if len(entries) == 0 {
+		z.segs.del(key)
+		return 0
+	}
+	ss := newSortedSet()
+	for _, e := range entries {
+		ss.add(e.Member, e.Score)
+	}
+	z.segs.put(key, ss)
+	return len(ss.scores)
+}
+
+func dumpZSet(key string, ss *sortedSet) (map[string]float64, bool) {
+	scores := make(map[string]float64, len(ss.scores))
+	for member, score := range ss.scores {
+		scores[member] = score
+	}
+	return scores, true
+}
+
+func (z *ZSet) snapshot() cowSegments[*sortedSet, map[string]float64] {
+	return cowSegments[*sortedSet, map[string]float64]{segs: z.segs, dump: dumpZSet, usage: zsetUsage}
+}
+
+func (z *ZSet) cow() cowStore {
+	return z.snapshot()
+}
+
+func (z *ZSet) Dump(keys ...string) map[string]map[string]float64 {
+	return z.snapshot().dumpKeys(keys)
+}
+
+func (z *ZSet) DumpSegment(i int) map[string]map[string]float64 {
+	return z.snapshot().dumpSegment(i)
+}
+
+func (z *ZSet) Keys() []string {
+	return z.segs.keys(nil)
+}
+
+func (z *ZSet) SegmentKeys(i int) []string {
+	return z.segs.segmentKeys(i, nil)
+}
+
+func (z *ZSet) Segments() int {
+	return len(z.segs)
+}
+
+func (z *ZSet) Len() int {
+	return z.segs.count()
+}
+
+func (z *ZSet) Sample(n int) []string {
+	return z.segs.sample(n)
+}
+
+func (z *ZSet) Exists(key string) bool {
+	return z.Zcard(key) > 0
+}
+
+func (z *ZSet) accessOf(key string) (*accessMap, func()) {
+	return z.segs.accessOf(key)
+}
+
+func (z *ZSet) Delete(keys ...string) int {
+	return z.segs.deleteKeys(keys)
+}
+
+func (z *ZSet) Flush() {
+	z.segs.flush()
+}
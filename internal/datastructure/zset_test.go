@@ -0,0 +1,78 @@
+package datastructure
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestZSetAddScore(t *testing.T) {
+	z := CreateZSet()
+
+	n := z.Zadd("z", ZAddFlags{}, ScoreMember{"a", 1}, ScoreMember{"b", 2})
+	if n != 2 {
+		t.Errorf("Expected 2 added, got %d", n)
+	}
+	n = z.Zadd("z", ZAddFlags{CH: true}, ScoreMember{"a", 5}, ScoreMember{"b", 2})
+	if n != 1 {
+		t.Errorf("Expected 1 changed, got %d", n)
+	}
+	if score, ok := z.Zscore("z", "a"); !ok || score != 5 {
+		t.Errorf("Expected score 5, got %v", score)
+	}
+
+	z.Zadd("z", ZAddFlags{NX: true}, ScoreMember{"a", 9})
+	if score, _ := z.Zscore("z", "a"); score != 5 {
+		t.Error("NX should not update existing member")
+	}
+	z.Zadd("z", ZAddFlags{XX: true}, ScoreMember{"c", 9})
+	if _, ok := z.Zscore("z", "c"); ok {
+		t.Error("XX should not add new member")
+	}
+}
+
+func TestZSetRankRange(t *testing.T) {
+	z := CreateZSet()
+	for i := 0; i < 100; i++ {
+		z.Zadd("z", ZAddFlags{}, ScoreMember{Member: "m" + strconv.Itoa(i), Score: float64(100 - i)})
+	}
+
+	if rank, ok := z.Zrank("z", "m99", false); !ok || rank != 0 {
+		t.Errorf("Expected rank 0, got %d", rank)
+	}
+	if rank, ok := z.Zrank("z", "m99", true); !ok || rank != 99 {
+		t.Errorf("Expected reverse rank 99, got %d", rank)
+	}
+
+	got := z.Zrange("z", 0, 2, false)
+	if len(got) != 3 || got[0].Member != "m99" || got[2].Member != "m97" {
+		t.Errorf("Unexpected range %+v", got)
+	}
+	got = z.Zrange("z", -2, -1, true)
+	if len(got) != 2 || got[0].Member != "m98" || got[1].Member != "m99" {
+		t.Errorf("Unexpected reverse range %+v", got)
+	}
+
+	got = z.ZrangeByScore("z", ScoreRange{Min: 10, Max: 12, MinEx: true})
+	if len(got) != 2 || got[0].Score != 11 || got[1].Score != 12 {
+		t.Errorf("Unexpected score range %+v", got)
+	}
+}
+
+func TestZSetRemove(t *testing.T) {
+	z := CreateZSet()
+	z.Zadd("z", ZAddFlags{}, ScoreMember{"a", 1}, ScoreMember{"b", 1}, ScoreMember{"c", 1})
+
+	if n := z.Zrem("z", "b", "missing"); n != 1 {
+		t.Errorf("Expected 1 removed, got %d", n)
+	}
+	if rank, _ := z.Zrank("z", "c", false); rank != 1 {
+		t.Errorf("Expected c at rank 1, got %d", rank)
+	}
+	z.Zrem("z", "a", "c")
+	if z.Zcard("z") != 0 {
+		t.Error("Expected empty sorted set")
+	}
+	if _, exists := z.Dump()["z"]; exists {
+		t.Error("Empty sorted set should be deleted")
+	}
+}
@@ -0,0 +1,574 @@
+// Package jsonl converts between snapshots and JSON Lines, one object per
+// key:
+//
+//	{"key":"user:1","db":0,"type":"hash","value":{"name":"ada"}}
+//	{"key":"session","db":0,"type":"string","ttl":59000,"value":"abc"}
+//
+// A record holding a key or string that is not valid UTF-8 has "encoding"
+// set to "base64", and then its key and every string in its value are
+// base64-encoded, except the document of a json key, which is JSON already.
+package jsonl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+)
+
+// The record types, one for each store of a snapshot.
+const (
+	TypeString     = "string"
+	TypeSet        = "set"
+	TypeList       = "list"
+	TypeHash       = "hash"
+	TypeZSet       = "zset"
+	TypeJSON       = "json"
+	TypeTimeSeries = "timeseries"
+	TypeSketch     = "sketch"
+)
+
+var types = []string{TypeString, TypeSet, TypeList, TypeHash, TypeZSet, TypeJSON, TypeTimeSeries, TypeSketch}
+
+// ValidType reports whether t is a record type.
+func ValidType(t string) bool {
+	return slices.Contains(types, t)
+}
+
+const encodingBase64 = "base64"
+
+type Record struct {
+	Key  string `json:"key"`
+	DB   int    `json:"db"`
+	Type string `json:"type"`
+	// TTL is how many milliseconds the key had left when exported; only
+	// string and set keys have one.
+	TTL      int64           `json:"ttl,omitempty"`
+	Encoding string          `json:"encoding,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// Filter picks the keys to export or import.
+type Filter struct {
+	// Match is a glob keys must match, as for KEYS; empty matches all.
+	Match string
+	// Types limits the keys to these types; empty allows all.
+	Types []string
+}
+
+func (f Filter) keep(key, typ string) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, typ) {
+		return false
+	}
+	if f.Match == "" {
+		return true
+	}
+	matched, _ := filepath.Match(f.Match, key)
+	return matched
+}
+
+type score float64
+
+func (s score) MarshalJSON() ([]byte, error) {
+	f := float64(s)
+	switch {
+	case math.IsInf(f, 1):
+		return []byte(`"inf"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-inf"`), nil
+	case math.IsNaN(f):
+		return []byte(`"nan"`), nil
+	}
+	return json.Marshal(f)
+}
+
+func (s *score) UnmarshalJSON(b []byte) error {
+	var f float64
+	if len(b) > 0 && b[0] == '"' {
+		var text string
+		if err := json.Unmarshal(b, &text); err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		f = v
+	} else if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*s = score(f)
+	return nil
+}
+
+type sample struct {
+	Timestamp int64
+	Value     score
+}
+
+func (s sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{s.Timestamp, s.Value})
+}
+
+func (s *sample) UnmarshalJSON(b []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return errors.New("sample is not [timestamp, value]")
+	}
+	if err := json.Unmarshal(pair[0], &s.Timestamp); err != nil {
+		return err
+	}
+	return json.Unmarshal(pair[1], &s.Value)
+}
+
+type tsRule struct {
+	Dest        string `json:"dest"`
+	Aggregation string `json:"aggregation"`
+	Bucket      int64  `json:"bucket"`
+}
+
+type tsValue struct {
+	Retention       int64             `json:"retention"`
+	DuplicatePolicy string            `json:"duplicate_policy,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	SourceKey       string            `json:"source_key,omitempty"`
+	Rules           []tsRule          `json:"rules,omitempty"`
+	Samples         []sample          `json:"samples"`
+}
+
+type sketchValue struct {
+	Kind string `json:"kind"`
+	Data []byte `json:"data"`
+}
+
+type codec struct {
+	base64 bool
+	err    error
+}
+
+func (c *codec) encode(s string) string {
+	if !c.base64 {
+		return s
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func (c *codec) decode(s string) string {
+	if !c.base64 || c.err != nil {
+		return s
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		c.err = err
+	}
+	return string(b)
+}
+
+func allUTF8(strs ...string) bool {
+	for _, s := range strs {
+		if !utf8.ValidString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Writer writes the keys of snapshot parts as records.
+type Writer struct {
+	enc    *json.Encoder
+	filter Filter
+	now    time.Time
+	count  int
+}
+
+// NewWriter writes to w the keys filter keeps. Keys expired as of now are
+// left out, and TTLs are counted from it.
+func NewWriter(w io.Writer, filter Filter, now time.Time) *Writer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &Writer{enc: enc, filter: filter, now: now}
+}
+
+// Count is how many records have been written.
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// WritePart writes the keys of part, by type and then key.
+func (w *Writer) WritePart(part persistence.Snapshot) error {
+	for _, key := range sortedKeys(part.DictData) {
+		item := part.DictData[key]
+		err := w.write(key, TypeString, item.ExpiredAt, func(c *codec) any {
+			return c.encode(item.Value)
+		}, item.Value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.SetData) {
+		item := part.SetData[key]
+		members := sortedKeys(item.Members)
+		err := w.write(key, TypeSet, item.ExpiredAt, func(c *codec) any {
+			out := make([]string, len(members))
+			for i, m := range members {
+				out[i] = c.encode(m)
+			}
+			return out
+		}, members...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.ListData) {
+		values := make([]string, len(part.ListData[key]))
+		for i, item := range part.ListData[key] {
+			values[i] = item.Value
+		}
+		err := w.write(key, TypeList, time.Time{}, func(c *codec) any {
+			out := make([]string, len(values))
+			for i, v := range values {
+				out[i] = c.encode(v)
+			}
+			return out
+		}, values...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.HashData) {
+		hash := part.HashData[key]
+		var strs []string
+		for field, value := range hash {
+			strs = append(strs, field, value)
+		}
+		err := w.write(key, TypeHash, time.Time{}, func(c *codec) any {
+			out := make(map[string]string, len(hash))
+			for field, value := range hash {
+				out[c.encode(field)] = c.encode(value)
+			}
+			return out
+		}, strs...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.ZSetData) {
+		scores := part.ZSetData[key]
+		members := sortedKeys(scores)
+		err := w.write(key, TypeZSet, time.Time{}, func(c *codec) any {
+			out := make(map[string]score, len(scores))
+			for member, s := range scores {
+				out[c.encode(member)] = score(s)
+			}
+			return out
+		}, members...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.JSONData) {
+		doc := part.JSONData[key]
+		if !json.Valid([]byte(doc)) {
+			return fmt.Errorf("json key %q does not hold a JSON document", key)
+		}
+		err := w.write(key, TypeJSON, time.Time{}, func(*codec) any {
+			return json.RawMessage(doc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.TSData) {
+		dump := part.TSData[key]
+		strs := []string{dump.SourceKey}
+		for label, value := range dump.Options.Labels {
+			strs = append(strs, label, value)
+		}
+		for _, rule := range dump.Rules {
+			strs = append(strs, rule.Dest)
+		}
+		err := w.write(key, TypeTimeSeries, time.Time{}, func(c *codec) any {
+			return encodeTimeSeries(c, dump)
+		}, strs...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(part.SketchData) {
+		dump := part.SketchData[key]
+		err := w.write(key, TypeSketch, time.Time{}, func(*codec) any {
+			return sketchValue{Kind: dump.Kind, Data: dump.Data}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) write(key, typ string, expiredAt time.Time, value func(c *codec) any, strs ...string) error {
+	if !w.filter.keep(key, typ) {
+		return nil
+	}
+	rec := Record{Key: key, Type: typ}
+	if !expiredAt.IsZero() {
+		ttl := expiredAt.Sub(w.now).Milliseconds()
+		if ttl <= 0 {
+			return nil
+		}
+		rec.TTL = ttl
+	}
+	c := &codec{base64: !allUTF8(key) || !allUTF8(strs...)}
+	if c.base64 {
+		rec.Encoding = encodingBase64
+		rec.Key = c.encode(key)
+	}
+	data, err := json.Marshal(value(c))
+	if err != nil {
+		return fmt.Errorf("%s key %q: %w", typ, key, err)
+	}
+	rec.Value = data
+	if err := w.enc.Encode(rec); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+func encodeTimeSeries(c *codec, dump datastructure.TSDump) tsValue {
+	v := tsValue{
+		Retention:       dump.Options.Retention,
+		DuplicatePolicy: string(dump.Options.Duplicate),
+		SourceKey:       c.encode(dump.SourceKey),
+		Samples:         make([]sample, len(dump.Samples)),
+	}
+	if len(dump.Options.Labels) > 0 {
+		v.Labels = make(map[string]string, len(dump.Options.Labels))
+		for label, value := range dump.Options.Labels {
+			v.Labels[c.encode(label)] = c.encode(value)
+		}
+	}
+	for _, rule := range dump.Rules {
+		v.Rules = append(v.Rules, tsRule{
+			Dest:        c.encode(rule.Dest),
+			Aggregation: string(rule.Aggregation.Type),
+			Bucket:      rule.Aggregation.Bucket,
+		})
+	}
+	for i, s := range dump.Samples {
+		v.Samples[i] = sample{Timestamp: s.Timestamp, Value: score(s.Value)}
+	}
+	return v
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Import reads records from r and passes the keys filter keeps to emit, at
+// most batch keys at a time. It returns how many keys it passed on.
+func Import(r io.Reader, filter Filter, now time.Time, batch int, emit func(persistence.Snapshot) error) (int, error) {
+	dec := json.NewDecoder(r)
+	var part persistence.Snapshot
+	inPart, count := 0, 0
+	for n := 1; ; n++ {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, fmt.Errorf("record %d: %w", n, err)
+		}
+		added, err := addRecord(&part, rec, filter, now)
+		if err != nil {
+			return count, fmt.Errorf("record %d (key %q): %w", n, rec.Key, err)
+		}
+		if !added {
+			continue
+		}
+		count++
+		if inPart++; inPart >= batch {
+			if err := emit(part); err != nil {
+				return count, err
+			}
+			part, inPart = persistence.Snapshot{}, 0
+		}
+	}
+	if inPart > 0 {
+		if err := emit(part); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func addRecord(part *persistence.Snapshot, rec Record, filter Filter, now time.Time) (bool, error) {
+	if rec.DB != 0 {
+		return false, fmt.Errorf("db %d does not exist; only db 0 does", rec.DB)
+	}
+	c := &codec{}
+	switch rec.Encoding {
+	case "":
+	case encodingBase64:
+		c.base64 = true
+	default:
+		return false, fmt.Errorf("unknown encoding %q", rec.Encoding)
+	}
+	if !ValidType(rec.Type) {
+		return false, fmt.Errorf("unknown type %q", rec.Type)
+	}
+	key := c.decode(rec.Key)
+	if c.err != nil {
+		return false, fmt.Errorf("key: %w", c.err)
+	}
+	if !filter.keep(key, rec.Type) {
+		return false, nil
+	}
+	var expiredAt time.Time
+	if rec.TTL != 0 {
+		if rec.Type != TypeString && rec.Type != TypeSet {
+			return false, fmt.Errorf("%s keys have no TTL", rec.Type)
+		}
+		if rec.TTL < 0 {
+			return false, fmt.Errorf("invalid ttl %d", rec.TTL)
+		}
+		expiredAt = now.Add(time.Duration(rec.TTL) * time.Millisecond)
+	}
+	if len(rec.Value) == 0 {
+		return false, errors.New("missing value")
+	}
+
+	var err error
+	switch rec.Type {
+	case TypeString:
+		var value string
+		if err = json.Unmarshal(rec.Value, &value); err == nil {
+			part.DictData = initMap(part.DictData)
+			part.DictData[key] = datastructure.Item{Value: c.decode(value), ExpiredAt: expiredAt}
+		}
+	case TypeSet:
+		var members []string
+		if err = json.Unmarshal(rec.Value, &members); err == nil {
+			item := datastructure.Item{Members: make(map[string]struct{}, len(members)), ExpiredAt: expiredAt}
+			for _, m := range members {
+				item.Members[c.decode(m)] = struct{}{}
+			}
+			part.SetData = initMap(part.SetData)
+			part.SetData[key] = item
+		}
+	case TypeList:
+		var values []string
+		if err = json.Unmarshal(rec.Value, &values); err == nil {
+			items := make([]datastructure.Item, len(values))
+			for i, v := range values {
+				items[i] = datastructure.Item{Value: c.decode(v)}
+			}
+			part.ListData = initMap(part.ListData)
+			part.ListData[key] = items
+		}
+	case TypeHash:
+		var hash map[string]string
+		if err = json.Unmarshal(rec.Value, &hash); err == nil {
+			out := make(map[string]string, len(hash))
+			for field, value := range hash {
+				out[c.decode(field)] = c.decode(value)
+			}
+			part.HashData = initMap(part.HashData)
+			part.HashData[key] = out
+		}
+	case TypeZSet:
+		var scores map[string]score
+		if err = json.Unmarshal(rec.Value, &scores); err == nil {
+			out := make(map[string]float64, len(scores))
+			for member, s := range scores {
+				out[c.decode(member)] = float64(s)
+			}
+			part.ZSetData = initMap(part.ZSetData)
+			part.ZSetData[key] = out
+		}
+	case TypeJSON:
+		part.JSONData = initMap(part.JSONData)
+		part.JSONData[key] = string(rec.Value)
+	case TypeTimeSeries:
+		var v tsValue
+		if err = json.Unmarshal(rec.Value, &v); err == nil {
+			var dump datastructure.TSDump
+			if dump, err = decodeTimeSeries(c, v); err == nil {
+				part.TSData = initMap(part.TSData)
+				part.TSData[key] = dump
+			}
+		}
+	case TypeSketch:
+		var v sketchValue
+		if err = json.Unmarshal(rec.Value, &v); err == nil {
+			part.SketchData = initMap(part.SketchData)
+			part.SketchData[key] = datastructure.SketchDump{Kind: v.Kind, Data: v.Data}
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s value: %w", rec.Type, err)
+	}
+	if c.err != nil {
+		return false, fmt.Errorf("%s value: %w", rec.Type, c.err)
+	}
+	return true, nil
+}
+
+func decodeTimeSeries(c *codec, v tsValue) (datastructure.TSDump, error) {
+	dump := datastructure.TSDump{
+		Options:   datastructure.TSOptions{Retention: v.Retention},
+		SourceKey: c.decode(v.SourceKey),
+		Samples:   make([]datastructure.Sample, len(v.Samples)),
+	}
+	if v.DuplicatePolicy != "" {
+		policy, ok := datastructure.ParseDuplicatePolicy(v.DuplicatePolicy)
+		if !ok {
+			return dump, fmt.Errorf("unknown duplicate policy %q", v.DuplicatePolicy)
+		}
+		dump.Options.Duplicate = policy
+	}
+	if len(v.Labels) > 0 {
+		dump.Options.Labels = make(map[string]string, len(v.Labels))
+		for label, value := range v.Labels {
+			dump.Options.Labels[c.decode(label)] = c.decode(value)
+		}
+	}
+	for _, rule := range v.Rules {
+		agg, ok := datastructure.ParseAggregator(rule.Aggregation)
+		if !ok || rule.Bucket <= 0 {
+			return dump, fmt.Errorf("invalid rule %s %d", rule.Aggregation, rule.Bucket)
+		}
+		dump.Rules = append(dump.Rules, datastructure.CompactionRule{
+			Dest:        c.decode(rule.Dest),
+			Aggregation: datastructure.Aggregation{Type: agg, Bucket: rule.Bucket},
+		})
+	}
+	for i, s := range v.Samples {
+		dump.Samples[i] = datastructure.Sample{Timestamp: s.Timestamp, Value: float64(s.Value)}
+	}
+	return dump, nil
+}
+
+func initMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return make(map[string]V)
+	}
+	return m
+}
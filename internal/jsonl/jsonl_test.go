@@ -0,0 +1,149 @@
+package jsonl
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+)
+
+func testSnapshot(now time.Time) persistence.Snapshot {
+	return persistence.Snapshot{
+		DictData: map[string]datastructure.Item{
+			"str":     {Value: "hello"},
+			"session": {Value: "abc", ExpiredAt: now.Add(time.Minute)},
+			"bin":     {Value: "\xff\x00raw"},
+		},
+		SetData: map[string]datastructure.Item{
+			"tags": {Members: map[string]struct{}{"a": {}, "b": {}}},
+		},
+		ListData: map[string][]datastructure.Item{
+			"queue": {{Value: "x"}, {Value: "y"}},
+		},
+		HashData: map[string]map[string]string{
+			"user:1": {"name": "ada", "lang": "go"},
+		},
+		ZSetData: map[string]map[string]float64{
+			"scores": {"a": 1.5, "top": math.Inf(1), "bottom": math.Inf(-1)},
+		},
+		JSONData: map[string]string{
+			"doc": `{"a":[1,2]}`,
+		},
+		TSData: map[string]datastructure.TSDump{
+			"temp": {
+				Options: datastructure.TSOptions{Retention: 1000, Duplicate: datastructure.DuplicateLast, Labels: map[string]string{"room": "1"}},
+				Samples: []datastructure.Sample{{Timestamp: 1, Value: 20.5}, {Timestamp: 2, Value: 21}},
+				Rules:   []datastructure.CompactionRule{{Dest: "temp:avg", Aggregation: datastructure.Aggregation{Type: datastructure.AggAvg, Bucket: 60}}},
+			},
+		},
+		SketchData: map[string]datastructure.SketchDump{
+			"bf": {Kind: "bloom", Data: []byte{0, 1, 255}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Now()
+	snapshot := testSnapshot(now)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Filter{}, now)
+	if err := w.WritePart(snapshot); err != nil {
+		t.Fatalf("WritePart failed: %v", err)
+	}
+	if w.Count() != 10 || strings.Count(buf.String(), "\n") != 10 {
+		t.Fatalf("Expected 10 records, got %d:\n%s", w.Count(), buf.String())
+	}
+	for _, want := range []string{
+		`{"key":"str","db":0,"type":"string","value":"hello"}`,
+		`{"key":"session","db":0,"type":"string","ttl":60000,"value":"abc"}`,
+		`"value":{"a":1.5,"bottom":"-inf","top":"inf"}`,
+		`{"key":"doc","db":0,"type":"json","value":{"a":[1,2]}}`,
+		`"samples":[[1,20.5],[2,21]]`,
+		`"data":"AAH/"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected the export to contain %s, got:\n%s", want, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), `{"key":"Ymlu","db":0,"type":"string","encoding":"base64","value":"/wByYXc="}`) {
+		t.Errorf("Expected the binary value base64-encoded, got:\n%s", buf.String())
+	}
+
+	exported := buf.String()
+	parts := 0
+	n, err := Import(strings.NewReader(exported), Filter{}, now, 4, func(persistence.Snapshot) error {
+		parts++
+		return nil
+	})
+	if err != nil || n != 10 || parts != 3 {
+		t.Fatalf("Expected 10 keys in 3 parts, got %d in %d: %v", n, parts, err)
+	}
+	var merged persistence.Snapshot
+	Import(strings.NewReader(exported), Filter{}, now, 100, func(part persistence.Snapshot) error {
+		merged = part
+		return nil
+	})
+	// Expiry times survive to the millisecond.
+	session := merged.DictData["session"]
+	if d := session.ExpiredAt.Sub(snapshot.DictData["session"].ExpiredAt); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("Expected the TTL kept, got expiry off by %v", d)
+	}
+	session.ExpiredAt = snapshot.DictData["session"].ExpiredAt
+	merged.DictData["session"] = session
+	if !reflect.DeepEqual(merged, snapshot) {
+		t.Errorf("Expected the snapshot back, got %+v", merged)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	snapshot := testSnapshot(now)
+	snapshot.DictData["gone"] = datastructure.Item{Value: "x", ExpiredAt: now.Add(-time.Second)}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, Filter{Match: "s*", Types: []string{TypeString, TypeZSet}}, now)
+	if err := w.WritePart(snapshot); err != nil {
+		t.Fatalf("WritePart failed: %v", err)
+	}
+	if w.Count() != 3 {
+		t.Errorf("Expected str, session and scores exported, got:\n%s", buf.String())
+	}
+
+	var buf2 bytes.Buffer
+	NewWriter(&buf2, Filter{}, now).WritePart(snapshot)
+	if strings.Contains(buf2.String(), `"gone"`) {
+		t.Error("Expected an expired key left out")
+	}
+	n, err := Import(&buf2, Filter{Types: []string{TypeHash}}, now, 100, func(part persistence.Snapshot) error {
+		if len(part.HashData) != 1 || len(part.DictData) != 0 {
+			t.Errorf("Expected only the hash imported, got %+v", part)
+		}
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Errorf("Expected one key imported, got %d: %v", n, err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"key":"a","db":1,"type":"string","value":"x"}`,
+		`{"key":"a","db":0,"type":"stream","value":"x"}`,
+		`{"key":"a","db":0,"type":"hash","ttl":5,"value":{}}`,
+		`{"key":"a","db":0,"type":"set","value":"x"}`,
+		`{"key":"a!","db":0,"type":"string","encoding":"base64","value":"eA=="}`,
+		`{"key":"a","db":0,"type":"zset","value":{"m":"high"}}`,
+		`{"key":"a","db":0,"type":"string"}`,
+		`{"key":"a",`,
+	} {
+		_, err := Import(strings.NewReader(input), Filter{}, time.Now(), 10, func(persistence.Snapshot) error { return nil })
+		if err == nil {
+			t.Errorf("Expected an error importing %s", input)
+		}
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
-	"github.com/william1nguyen/valkeydb/internal/datastructure"
 	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
 )
 
@@ -15,176 +15,133 @@ type AOF struct {
 	file      *os.File
 	enabled   bool
 	replaying bool
+	feed      func(resp.Value)
+	// offset counts bytes ever appended; it is not reset by rewrites.
+	offset int64
+
+	syncMu  sync.Mutex
+	fsynced int64
+	synced  chan struct{}
+
+	// dir holds the files the manifest lists; file is the last incremental
+	// one.
+	dir      string
+	name     string
+	preamble bool
+	manifest manifest
+	created  bool
+	// loadTruncated lets loading cut off a truncated last command.
+	loadTruncated bool
 }
 
-func OpenAOF(path string, enabled bool) (*AOF, error) {
-	if !enabled {
-		return &AOF{
-			enabled: false,
-		}, nil
-	}
-
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, err
-	}
+// SetFeed registers fn to receive every command appended outside of a
+// replay, whether or not the file is enabled. Replication hooks in here.
+func (a *AOF) SetFeed(fn func(resp.Value)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.feed = fn
+}
 
-	return &AOF{
-		file:    f,
-		enabled: true,
-	}, nil
+// SetLoadTruncated sets whether loading cuts a truncated command off the
+// end of the last file, as a crash mid-append leaves, rather than failing.
+func (a *AOF) SetLoadTruncated(allow bool) {
+	a.loadTruncated = allow
 }
 
 func (a *AOF) Append(v resp.Value) error {
-	if !a.enabled || a.replaying {
+	if a.replaying {
 		return nil
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	_, err := a.file.WriteString(resp.Encode(v))
-	if err != nil {
-		return err
+	if a.feed != nil {
+		a.feed(v)
 	}
-
-	return a.file.Sync()
-}
-
-func (a *AOF) Load(path string, dispatch func(cmd string, args []resp.Value)) error {
 	if !a.enabled {
 		return nil
 	}
 
-	f, err := os.Open(path)
+	n, err := a.file.WriteString(resp.Encode(v))
+	a.offset += int64(n)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
-
-	reader := bufio.NewReader(f)
-
-	a.replaying = true
-	defer func() {
-		a.replaying = false
-	}()
-
-	for {
-		val, err := resp.Decode(reader)
-		if err != nil {
-			break
-		}
-
-		if val.Type != resp.Array || len(val.Items) == 0 {
-			continue
-		}
-
-		cmdVal := val.Items[0]
-		if cmdVal.Type != resp.BulkString && cmdVal.Type != resp.SimpleString {
-			continue
-		}
-
-		cmd := cmdVal.Text
-		args := val.Items[1:]
-		dispatch(cmd, args)
+	if err := a.file.Sync(); err != nil {
+		return err
 	}
-
+	a.markFsynced(a.offset)
 	return nil
 }
 
-func (a *AOF) Close() error {
-	if !a.enabled {
-		return nil
+func (a *AOF) markFsynced(offset int64) {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	if offset > a.fsynced {
+		a.fsynced = offset
+		close(a.synced)
+		a.synced = make(chan struct{})
 	}
-
-	return a.file.Close()
 }
 
-func (a *AOF) Rewrite(dump func() map[string]datastructure.Item, path string) error {
-	if !a.enabled {
-		return nil
-	}
+func (a *AOF) Enabled() bool {
+	return a.enabled
+}
 
+// Offset returns how many bytes have been appended since the AOF was opened.
+func (a *AOF) Offset() int64 {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	return a.offset
+}
 
-	tmpPath := path + ".tmp"
-	f, err := os.Create(tmpPath)
-	if err != nil {
-		return err
-	}
-
-	defer f.Close()
-
-	snapshot := dump()
+// FsyncedOffset returns the offset up to which appends are known to be on
+// disk. It never decreases.
+func (a *AOF) FsyncedOffset() int64 {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	return a.fsynced
+}
 
-	for key, item := range snapshot {
-		if len(item.Members) > 0 {
-			items := make([]resp.Value, 0, 2+len(item.Members))
-			items = append(items, resp.Value{Type: resp.BulkString, Text: "SADD"})
-			items = append(items, resp.Value{Type: resp.BulkString, Text: key})
-			for member := range item.Members {
-				items = append(items, resp.Value{Type: resp.BulkString, Text: member})
-			}
-			v := resp.Value{Type: resp.Array, Items: items}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
-				return err
-			}
-		} else if item.Value != "" {
-			v := resp.Value{
-				Type: resp.Array,
-				Items: []resp.Value{
-					{Type: resp.BulkString, Text: "SET"},
-					{Type: resp.BulkString, Text: key},
-					{Type: resp.BulkString, Text: item.Value},
-				},
-			}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
-				return err
-			}
+// WaitFsync blocks until offset is fsynced or timeout passes; a zero
+// timeout waits forever.
+func (a *AOF) WaitFsync(offset int64, timeout time.Duration) bool {
+	if !a.enabled {
+		return false
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		a.syncMu.Lock()
+		done, ch := a.fsynced >= offset, a.synced
+		a.syncMu.Unlock()
+		if done {
+			return true
 		}
-
-		if !item.ExpiredAt.IsZero() {
-			at := item.ExpiredAt
-			v := resp.Value{
-				Type: resp.Array,
-				Items: []resp.Value{
-					{Type: resp.BulkString, Text: "PEXPIREAT"},
-					{Type: resp.BulkString, Text: key},
-					{Type: resp.BulkString, Text: strconv.FormatInt(at.UnixMilli(), 10)},
-				},
-			}
-
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
-				return err
-			}
+		select {
+		case <-ch:
+		case <-expired:
+			return false
 		}
 	}
-
-	if err := f.Sync(); err != nil {
-		return err
-	}
-
-	return os.Rename(tmpPath, path)
 }
 
-func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump map[string]datastructure.Item, listDump map[string][]datastructure.Item, hashDump map[string]map[string]string, path string) error {
+func (a *AOF) Close() error {
 	if !a.enabled {
 		return nil
 	}
 
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	tmpPath := path + ".tmp"
-	f, err := os.Create(tmpPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return a.file.Close()
+}
 
-	for key, item := range dictDump {
+func writeCommands(w *bufio.Writer, snapshot Snapshot) error {
+	for key, item := range snapshot.DictData {
 		v := resp.Value{
 			Type: resp.Array,
 			Items: []resp.Value{
@@ -193,7 +150,7 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 				{Type: resp.BulkString, Text: item.Value},
 			},
 		}
-		if _, err := f.WriteString(resp.Encode(v)); err != nil {
+		if _, err := w.WriteString(resp.Encode(v)); err != nil {
 			return err
 		}
 		if !item.ExpiredAt.IsZero() {
@@ -205,13 +162,13 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 					{Type: resp.BulkString, Text: strconv.FormatInt(item.ExpiredAt.UnixMilli(), 10)},
 				},
 			}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
+			if _, err := w.WriteString(resp.Encode(v)); err != nil {
 				return err
 			}
 		}
 	}
 
-	for key, item := range setDump {
+	for key, item := range snapshot.SetData {
 		if len(item.Members) > 0 {
 			items := make([]resp.Value, 0, 2+len(item.Members))
 			items = append(items, resp.Value{Type: resp.BulkString, Text: "SADD"})
@@ -220,7 +177,7 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 				items = append(items, resp.Value{Type: resp.BulkString, Text: member})
 			}
 			v := resp.Value{Type: resp.Array, Items: items}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
+			if _, err := w.WriteString(resp.Encode(v)); err != nil {
 				return err
 			}
 			if !item.ExpiredAt.IsZero() {
@@ -232,14 +189,14 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 						{Type: resp.BulkString, Text: strconv.FormatInt(item.ExpiredAt.UnixMilli(), 10)},
 					},
 				}
-				if _, err := f.WriteString(resp.Encode(v)); err != nil {
+				if _, err := w.WriteString(resp.Encode(v)); err != nil {
 					return err
 				}
 			}
 		}
 	}
 
-	for key, items := range listDump {
+	for key, items := range snapshot.ListData {
 		if len(items) > 0 {
 			vals := make([]resp.Value, 0, 2+len(items))
 			vals = append(vals, resp.Value{Type: resp.BulkString, Text: "RPUSH"})
@@ -248,13 +205,13 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 				vals = append(vals, resp.Value{Type: resp.BulkString, Text: item.Value})
 			}
 			v := resp.Value{Type: resp.Array, Items: vals}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
+			if _, err := w.WriteString(resp.Encode(v)); err != nil {
 				return err
 			}
 		}
 	}
 
-	for key, hash := range hashDump {
+	for key, hash := range snapshot.HashData {
 		for field, value := range hash {
 			v := resp.Value{
 				Type: resp.Array,
@@ -265,15 +222,94 @@ func (a *AOF) RewriteWithLists(dictDump map[string]datastructure.Item, setDump m
 					{Type: resp.BulkString, Text: value},
 				},
 			}
-			if _, err := f.WriteString(resp.Encode(v)); err != nil {
+			if _, err := w.WriteString(resp.Encode(v)); err != nil {
 				return err
 			}
 		}
 	}
 
-	if err := f.Sync(); err != nil {
-		return err
+	for key, scores := range snapshot.ZSetData {
+		if len(scores) == 0 {
+			continue
+		}
+		vals := make([]resp.Value, 0, 2+2*len(scores))
+		vals = append(vals, resp.Value{Type: resp.BulkString, Text: "ZADD"})
+		vals = append(vals, resp.Value{Type: resp.BulkString, Text: key})
+		for member, score := range scores {
+			vals = append(vals, resp.Value{Type: resp.BulkString, Text: strconv.FormatFloat(score, 'g', -1, 64)})
+			vals = append(vals, resp.Value{Type: resp.BulkString, Text: member})
+		}
+		v := resp.Value{Type: resp.Array, Items: vals}
+		if _, err := w.WriteString(resp.Encode(v)); err != nil {
+			return err
+		}
+	}
+
+	for key, doc := range snapshot.JSONData {
+		v := resp.Value{
+			Type: resp.Array,
+			Items: []resp.Value{
+				{Type: resp.BulkString, Text: "JSON.SET"},
+				{Type: resp.BulkString, Text: key},
+				{Type: resp.BulkString, Text: "$"},
+				{Type: resp.BulkString, Text: doc},
+			},
+		}
+		if _, err := w.WriteString(resp.Encode(v)); err != nil {
+			return err
+		}
+	}
+
+	// Series are created and filled before any compaction rule is attached,
+	// otherwise replaying the raw samples would compact them a second time.
+	for key, dump := range snapshot.TSData {
+		args := []string{"TS.CREATE", key, "RETENTION", strconv.FormatInt(dump.Options.Retention, 10)}
+		if dump.Options.Duplicate != "" {
+			args = append(args, "DUPLICATE_POLICY", string(dump.Options.Duplicate))
+		}
+		if len(dump.Options.Labels) > 0 {
+			args = append(args, "LABELS")
+			for k, v := range dump.Options.Labels {
+				args = append(args, k, v)
+			}
+		}
+		if _, err := w.WriteString(resp.Encode(bulkCommand(args...))); err != nil {
+			return err
+		}
+		for start := 0; start < len(dump.Samples); start += 1000 {
+			end := min(start+1000, len(dump.Samples))
+			args := []string{"TS.MADD"}
+			for _, sample := range dump.Samples[start:end] {
+				args = append(args, key, strconv.FormatInt(sample.Timestamp, 10), strconv.FormatFloat(sample.Value, 'g', -1, 64))
+			}
+			if _, err := w.WriteString(resp.Encode(bulkCommand(args...))); err != nil {
+				return err
+			}
+		}
+	}
+	for key, dump := range snapshot.TSData {
+		for _, rule := range dump.Rules {
+			v := bulkCommand("TS.CREATERULE", key, rule.Dest, "AGGREGATION", string(rule.Aggregation.Type), strconv.FormatInt(rule.Aggregation.Bucket, 10))
+			if _, err := w.WriteString(resp.Encode(v)); err != nil {
+				return err
+			}
+		}
 	}
 
-	return os.Rename(tmpPath, path)
+	for key, dump := range snapshot.SketchData {
+		v := bulkCommand(dump.Kind+".LOADCHUNK", key, "1", string(dump.Data))
+		if _, err := w.WriteString(resp.Encode(v)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func bulkCommand(parts ...string) resp.Value {
+	items := make([]resp.Value, 0, len(parts))
+	for _, p := range parts {
+		items = append(items, resp.Value{Type: resp.BulkString, Text: p})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
 }
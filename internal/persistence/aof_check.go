@@ -0,0 +1,179 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// ErrAOFCorrupt is returned when loading an AOF file that holds a bad
+// record, or a truncated one where truncation is not allowed.
+var ErrAOFCorrupt = errors.New("AOF file is corrupt")
+
+// AOFCheck describes one file checked by CheckAOF.
+type AOFCheck struct {
+	File string
+	Size int64
+	// Valid is the length of the run of whole commands the file starts
+	// with, and Commands their number.
+	Valid    int64
+	Commands int
+	// Truncated is set when all that follows Valid is the start of one
+	// command, as a crash mid-append leaves.
+	Truncated bool
+	// Err describes the record at Valid; it is nil for a good file.
+	Err error
+	// Fixable is set when truncating the file to Valid repairs it: it is
+	// a single AOF or the last file of a multi-part one.
+	Fixable bool
+}
+
+func scanAOF(r io.Reader, size int64, dispatch func(cmd string, args []resp.Value)) AOFCheck {
+	counter := &countingReader{r: r}
+	reader := bufio.NewReader(counter)
+	check := AOFCheck{Size: size}
+	for {
+		val, err := resp.Decode(reader)
+		if err == io.EOF && check.Valid == size {
+			return check
+		}
+		if err != nil {
+			check.Err = err
+			check.Truncated = errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+			return check
+		}
+		if err := validCommand(val); err != nil {
+			check.Err = err
+			return check
+		}
+		dispatch(val.Items[0].Text, val.Items[1:])
+		check.Valid = counter.n - int64(reader.Buffered())
+		check.Commands++
+	}
+}
+
+func validCommand(v resp.Value) error {
+	if v.Type != resp.Array || len(v.Items) == 0 {
+		return errors.New("record is not a command")
+	}
+	for _, item := range v.Items {
+		if item.Type != resp.BulkString || item.IsNil {
+			return errors.New("command argument is not a bulk string")
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func replayFile(path string, dispatch func(cmd string, args []resp.Value), truncated bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	check := scanAOF(f, stat.Size(), dispatch)
+	switch {
+	case check.Err == nil:
+		return nil
+	case check.Truncated && truncated:
+		log.Printf("!!! AOF %s ends in a truncated command at offset %d; dropping its last %d bytes", path, check.Valid, check.Size-check.Valid)
+		return os.Truncate(path, check.Valid)
+	case check.Truncated:
+		return fmt.Errorf("%s: %w: truncated command at offset %d; set aof load_truncated or run valkeydb check-aof --fix", path, ErrAOFCorrupt, check.Valid)
+	}
+	return fmt.Errorf("%s: %w: bad record at offset %d: %v; valkeydb check-aof reports it", path, ErrAOFCorrupt, check.Valid, check.Err)
+}
+
+// CheckAOF checks the AOF file at path or, given a manifest, every file of
+// the multi-part AOF it lists.
+func CheckAOF(path string) ([]AOFCheck, error) {
+	if !strings.HasSuffix(path, ".manifest") {
+		check, err := checkAOFFile(path)
+		check.Fixable = true
+		return []AOFCheck{check}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseManifest(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	var checks []AOFCheck
+	if m.base.file != "" {
+		file := filepath.Join(dir, m.base.file)
+		if strings.HasSuffix(file, ".rdb") {
+			check := AOFCheck{File: file}
+			if stat, err := os.Stat(file); err == nil {
+				check.Size = stat.Size()
+			}
+			if _, check.Err = ReadRDB(file); check.Err == nil {
+				check.Valid = check.Size
+			}
+			checks = append(checks, check)
+		} else {
+			check, err := checkAOFFile(file)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, check)
+		}
+	}
+	for i, p := range m.incrs {
+		check, err := checkAOFFile(filepath.Join(dir, p.file))
+		if err != nil {
+			return nil, err
+		}
+		check.Fixable = i == len(m.incrs)-1
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkAOFFile(path string) (AOFCheck, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AOFCheck{File: path}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return AOFCheck{File: path}, err
+	}
+	check := scanAOF(f, stat.Size(), func(string, []resp.Value) {})
+	check.File = path
+	return check, nil
+}
+
+// FixAOF repairs a fixable file by truncating it to its valid commands.
+func FixAOF(check AOFCheck) error {
+	if !check.Fixable {
+		return fmt.Errorf("%s: only a single AOF or the last file of a multi-part one can be fixed", check.File)
+	}
+	return os.Truncate(check.File, check.Valid)
+}
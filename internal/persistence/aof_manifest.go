@@ -0,0 +1,342 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// A multi-part AOF keeps its files in a directory: one base file holding the
+// dataset as of the last rewrite, either in RDB form or as commands, and the
+// incremental files of commands appended since, oldest first. The manifest
+// lists them in the format Redis 7 uses:
+//
+//	file appendonly.aof.2.base.rdb seq 2 type b
+//	file appendonly.aof.5.incr.aof seq 5 type i
+//
+// A rewrite starts a new incremental file, writes a new base from a snapshot
+// taken at the same moment, then drops the old base and incremental files.
+
+type aofPart struct {
+	file string
+	seq  int64
+	// kind is 'b' for the base and 'i' for an incremental file.
+	kind byte
+}
+
+type manifest struct {
+	// base is empty when the AOF has never been rewritten.
+	base  aofPart
+	incrs []aofPart
+}
+
+func (m *manifest) encode() string {
+	var b strings.Builder
+	for _, p := range append([]aofPart{m.base}, m.incrs...) {
+		if p.file != "" {
+			fmt.Fprintf(&b, "file %s seq %d type %c\n", p.file, p.seq, p.kind)
+		}
+	}
+	return b.String()
+}
+
+func parseManifest(data string) (manifest, error) {
+	var m manifest
+	for line := range strings.Lines(data) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields)%2 != 0 {
+			return m, fmt.Errorf("invalid manifest line %q", strings.TrimSpace(line))
+		}
+		var p aofPart
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				p.file = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return m, fmt.Errorf("invalid manifest line %q", strings.TrimSpace(line))
+				}
+				p.seq = seq
+			case "type":
+				if len(fields[i+1]) == 1 {
+					p.kind = fields[i+1][0]
+				}
+			}
+		}
+		if p.file == "" || p.file != filepath.Base(p.file) {
+			return m, fmt.Errorf("invalid manifest line %q", strings.TrimSpace(line))
+		}
+		switch p.kind {
+		case 'b':
+			if m.base.file != "" {
+				return m, errors.New("manifest lists more than one base file")
+			}
+			m.base = p
+		case 'i':
+			m.incrs = append(m.incrs, p)
+		default:
+			return m, fmt.Errorf("invalid manifest line %q", strings.TrimSpace(line))
+		}
+	}
+	if len(m.incrs) == 0 {
+		return m, errors.New("manifest lists no incremental file")
+	}
+	return m, nil
+}
+
+// OpenMultiPartAOF opens the multi-part AOF in dir, taking over a
+// single-file AOF at path as the base.
+func OpenMultiPartAOF(dir, path string, preamble, enabled bool) (*AOF, error) {
+	if !enabled {
+		return &AOF{
+			enabled: false,
+		}, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	a := &AOF{
+		enabled:  true,
+		synced:   make(chan struct{}),
+		dir:      dir,
+		name:     filepath.Base(path),
+		preamble: preamble,
+	}
+
+	data, err := os.ReadFile(a.manifestPath())
+	switch {
+	case err == nil:
+		if a.manifest, err = parseManifest(string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.manifestPath(), err)
+		}
+	case os.IsNotExist(err):
+		a.created = true
+		if stat, err := os.Stat(path); err == nil && stat.Size() > 0 {
+			base := aofPart{file: a.partName(1, "base.aof"), seq: 1, kind: 'b'}
+			if err := os.Rename(path, filepath.Join(dir, base.file)); err != nil {
+				return nil, err
+			}
+			a.manifest.base = base
+			a.created = false
+		}
+		a.manifest.incrs = []aofPart{{file: a.partName(1, "incr.aof"), seq: 1, kind: 'i'}}
+		if err := a.writeManifest(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	last := a.manifest.incrs[len(a.manifest.incrs)-1]
+	if a.file, err = os.OpenFile(a.partPath(last), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AOF) manifestPath() string {
+	return filepath.Join(a.dir, a.name+".manifest")
+}
+
+func (a *AOF) partName(seq int64, suffix string) string {
+	return a.name + "." + strconv.FormatInt(seq, 10) + "." + suffix
+}
+
+func (a *AOF) partPath(p aofPart) string {
+	return filepath.Join(a.dir, p.file)
+}
+
+func (a *AOF) writeManifest() error {
+	tmpPath := a.manifestPath() + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(a.manifest.encode()); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, a.manifestPath()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(a.dir)
+}
+
+// Created reports whether opening the multi-part AOF started it afresh,
+// with nothing to load.
+func (a *AOF) Created() bool {
+	return a.created
+}
+
+// LoadAll loads a multi-part AOF.
+func (a *AOF) LoadAll(restore func(*Snapshot), dispatch func(cmd string, args []resp.Value)) error {
+	if !a.enabled {
+		return nil
+	}
+
+	a.replaying = true
+	defer func() {
+		a.replaying = false
+	}()
+
+	if base := a.manifest.base; base.file != "" {
+		if strings.HasSuffix(base.file, ".rdb") {
+			snapshot, err := ReadRDB(a.partPath(base))
+			if err != nil {
+				return err
+			}
+			if snapshot != nil {
+				restore(snapshot)
+			}
+		} else if err := replayFile(a.partPath(base), dispatch, false); err != nil {
+			return err
+		}
+	}
+	for i, p := range a.manifest.incrs {
+		last := i == len(a.manifest.incrs)-1
+		if err := replayFile(a.partPath(p), dispatch, last && a.loadTruncated); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AOFRewrite is a rewrite begun by BeginRewrite.
+type AOFRewrite struct {
+	aof *AOF
+	// incr is the incremental file begun with the rewrite; the new base
+	// replaces everything before it.
+	incr aofPart
+}
+
+// BeginRewrite starts a new incremental file for the commands appended from
+// now on. No write may run between taking the snapshot and calling it.
+func (a *AOF) BeginRewrite() (*AOFRewrite, error) {
+	if a.dir == "" {
+		return nil, errors.New("AOF is not multi-part")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	last := a.manifest.incrs[len(a.manifest.incrs)-1]
+	incr := aofPart{file: a.partName(last.seq+1, "incr.aof"), seq: last.seq + 1, kind: 'i'}
+	f, err := os.OpenFile(a.partPath(incr), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.manifest.incrs = append(a.manifest.incrs, incr)
+	if err := a.writeManifest(); err != nil {
+		a.manifest.incrs = a.manifest.incrs[:len(a.manifest.incrs)-1]
+		f.Close()
+		os.Remove(a.partPath(incr))
+		return nil, err
+	}
+	old := a.file
+	a.file = f
+	if err := old.Sync(); err != nil {
+		old.Close()
+		return nil, err
+	}
+	return &AOFRewrite{aof: a, incr: incr}, old.Close()
+}
+
+// Finish writes the new base and drops the files it replaces. On error the
+// AOF keeps its old files.
+func (r *AOFRewrite) Finish(write func(emit func(Snapshot) error) error) error {
+	a := r.aof
+	a.mu.Lock()
+	base := aofPart{seq: a.manifest.base.seq + 1, kind: 'b'}
+	a.mu.Unlock()
+	if a.preamble {
+		base.file = a.partName(base.seq, "base.rdb")
+	} else {
+		base.file = a.partName(base.seq, "base.aof")
+	}
+
+	tmpPath := a.partPath(base) + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if a.preamble {
+		err = writeRDB(f, write)
+	} else {
+		err = writeBaseCommands(f, write)
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, a.partPath(base))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var stale []aofPart
+	if a.manifest.base.file != "" {
+		stale = append(stale, a.manifest.base)
+	}
+	keep := a.manifest.incrs[:0:0]
+	for _, p := range a.manifest.incrs {
+		if p.seq < r.incr.seq {
+			stale = append(stale, p)
+		} else {
+			keep = append(keep, p)
+		}
+	}
+	prev := a.manifest
+	a.manifest = manifest{base: base, incrs: keep}
+	if err := a.writeManifest(); err != nil {
+		a.manifest = prev
+		os.Remove(a.partPath(base))
+		return err
+	}
+	for _, p := range stale {
+		os.Remove(a.partPath(p))
+	}
+	return nil
+}
+
+func writeBaseCommands(f *os.File, write func(emit func(Snapshot) error) error) error {
+	w := bufio.NewWriter(f)
+	err := write(func(part Snapshot) error {
+		return writeCommands(w, part)
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
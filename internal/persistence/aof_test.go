@@ -1,22 +1,30 @@
 package persistence
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
 	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
 )
 
-func TestAOFAppendLoad(t *testing.T) {
-	tmpFile := "test_append.aof"
-	defer os.Remove(tmpFile)
-
-	aof, err := OpenAOF(tmpFile, true)
+// openAOF opens a new multi-part AOF in a temporary directory and returns
+// it with the directory and the path of its first incremental file.
+func openAOF(t *testing.T) (*AOF, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	aof, err := OpenMultiPartAOF(dir, filepath.Join(dir, "appendonly.aof"), false, true)
 	if err != nil {
-		t.Fatalf("OpenAOF failed: %v", err)
+		t.Fatalf("OpenMultiPartAOF failed: %v", err)
 	}
-	defer aof.Close()
+	return aof, dir, filepath.Join(dir, "appendonly.aof.1.incr.aof")
+}
+
+func TestAOFAppendLoad(t *testing.T) {
+	aof, dir, _ := openAOF(t)
 
 	cmd1 := resp.Value{
 		Type: resp.Array,
@@ -41,61 +49,254 @@ func TestAOFAppendLoad(t *testing.T) {
 	if err := aof.Append(cmd2); err != nil {
 		t.Fatalf("Append failed: %v", err)
 	}
+	aof.Close()
 
-	commands := []string{}
-	aof.Load(tmpFile, func(cmd string, args []resp.Value) {
-		commands = append(commands, cmd)
-	})
+	_, commands := loadAll(t, dir, filepath.Join(dir, "appendonly.aof"))
 
 	if len(commands) != 2 {
 		t.Errorf("Expected 2 commands, got %d", len(commands))
 	}
-	if commands[0] != "SET" || commands[1] != "SADD" {
+	if commands[0] != "SET key1" || commands[1] != "SADD set1" {
 		t.Errorf("Commands mismatch: %v", commands)
 	}
 }
 
-func TestAOFRewrite(t *testing.T) {
-	tmpFile := "test_rewrite.aof"
-	defer os.Remove(tmpFile)
-	defer os.Remove(tmpFile + ".tmp")
+func TestAOFDisabled(t *testing.T) {
+	aof, _ := OpenMultiPartAOF("", "", false, false)
+
+	cmd := resp.Value{Type: resp.Array, Items: []resp.Value{{Type: resp.BulkString, Text: "PING"}}}
+	if err := aof.Append(cmd); err != nil {
+		t.Error("Append with disabled AOF should not error")
+	}
+
+	err := aof.LoadAll(func(*Snapshot) {}, func(cmd string, args []resp.Value) {})
+	if err != nil {
+		t.Error("Load with disabled AOF should not error")
+	}
+}
 
-	aof, _ := OpenAOF(tmpFile, true)
+func TestAOFFsyncedOffset(t *testing.T) {
+	aof, _, _ := openAOF(t)
 	defer aof.Close()
 
-	snapshot := map[string]datastructure.Item{
-		"key1": {Value: "value1"},
-		"set1": {Members: map[string]struct{}{"m1": {}, "m2": {}}},
+	cmd := bulkCommand("SET", "k", "v")
+	aof.Append(cmd)
+	aof.Append(cmd)
+	want := int64(2 * len(resp.Encode(cmd)))
+	if aof.Offset() != want || aof.FsyncedOffset() != want {
+		t.Errorf("Expected offsets %d, got %d/%d", want, aof.Offset(), aof.FsyncedOffset())
+	}
+	if !aof.WaitFsync(want, time.Second) {
+		t.Error("Expected appended offset to be fsynced")
+	}
+	if aof.WaitFsync(want+1, 20*time.Millisecond) {
+		t.Error("Expected future offset to time out")
+	}
+
+	done := make(chan bool)
+	go func() { done <- aof.WaitFsync(want+1, 0) }()
+	aof.Append(cmd)
+	if !<-done {
+		t.Error("Expected waiter to be woken by the next fsync")
 	}
 
-	err := aof.Rewrite(func() map[string]datastructure.Item {
-		return snapshot
-	}, tmpFile)
+	disabled, _ := OpenMultiPartAOF("", "", false, false)
+	if disabled.WaitFsync(0, time.Millisecond) {
+		t.Error("Expected disabled AOF never to report fsync")
+	}
+}
 
+// loadAll reopens the multi-part AOF in dir and returns the snapshot and
+// commands it loads.
+func loadAll(t *testing.T, dir, path string) (*Snapshot, []string) {
+	t.Helper()
+	aof, err := OpenMultiPartAOF(dir, path, true, true)
 	if err != nil {
-		t.Fatalf("Rewrite failed: %v", err)
+		t.Fatalf("OpenMultiPartAOF failed: %v", err)
 	}
+	defer aof.Close()
+	var restored *Snapshot
+	var commands []string
+	err = aof.LoadAll(func(s *Snapshot) { restored = s }, func(cmd string, args []resp.Value) {
+		commands = append(commands, cmd+" "+args[0].Text)
+	})
+	if err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+	return restored, commands
+}
+
+func TestMultiPartAOFRewrite(t *testing.T) {
+	for _, preamble := range []bool{true, false} {
+		root := t.TempDir()
+		dir := filepath.Join(root, "appendonlydir")
+		path := filepath.Join(root, "appendonly.aof")
+		aof, err := OpenMultiPartAOF(dir, path, preamble, true)
+		if err != nil {
+			t.Fatalf("OpenMultiPartAOF failed: %v", err)
+		}
+		if !aof.Created() {
+			t.Error("Expected a new AOF to report Created")
+		}
+		aof.Append(bulkCommand("SET", "a", "1"))
+
+		rewrite, err := aof.BeginRewrite()
+		if err != nil {
+			t.Fatalf("BeginRewrite failed: %v", err)
+		}
+		aof.Append(bulkCommand("SET", "b", "2"))
+		err = rewrite.Finish(func(emit func(Snapshot) error) error {
+			return emit(Snapshot{DictData: map[string]datastructure.Item{"a": {Value: "1"}}})
+		})
+		if err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+		aof.Append(bulkCommand("SET", "c", "3"))
+		aof.Close()
+
+		suffix := ".1.base.aof"
+		if preamble {
+			suffix = ".1.base.rdb"
+		}
+		manifest, _ := os.ReadFile(filepath.Join(dir, "appendonly.aof.manifest"))
+		want := "file appendonly.aof" + suffix + " seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+		if string(manifest) != want {
+			t.Errorf("Expected manifest %q, got %q", want, manifest)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "appendonly.aof.1.incr.aof")); !os.IsNotExist(err) {
+			t.Error("Expected the rewritten incremental file removed")
+		}
 
-	commands := []string{}
-	aof.Load(tmpFile, func(cmd string, args []resp.Value) {
-		commands = append(commands, cmd)
+		restored, commands := loadAll(t, dir, path)
+		if preamble {
+			if restored == nil || restored.DictData["a"].Value != "1" {
+				t.Errorf("Expected the RDB base restored, got %v", restored)
+			}
+			if len(commands) != 2 || commands[0] != "SET b" || commands[1] != "SET c" {
+				t.Errorf("Expected only the tail replayed, got %v", commands)
+			}
+		} else if restored != nil || len(commands) != 3 || commands[0] != "SET a" {
+			t.Errorf("Expected the AOF base replayed before the tail, got %v %v", restored, commands)
+		}
+	}
+}
+
+func TestMultiPartAOFFailedRewrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+	aof, _ := OpenMultiPartAOF(dir, path, true, true)
+	aof.Append(bulkCommand("SET", "a", "1"))
+	rewrite, _ := aof.BeginRewrite()
+	aof.Append(bulkCommand("SET", "b", "2"))
+	err := rewrite.Finish(func(emit func(Snapshot) error) error {
+		return errors.New("disk full")
 	})
+	if err == nil {
+		t.Error("Expected Finish to fail")
+	}
+	aof.Close()
 
-	if len(commands) < 2 {
-		t.Errorf("Expected at least 2 commands after rewrite, got %d", len(commands))
+	restored, commands := loadAll(t, dir, path)
+	if restored != nil || len(commands) != 2 {
+		t.Errorf("Expected both incremental files kept, got %v %v", restored, commands)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Errorf("Expected no temp files, got %v", matches)
 	}
 }
 
-func TestAOFDisabled(t *testing.T) {
-	aof, _ := OpenAOF("dummy.aof", false)
+func TestMultiPartAOFAdoptsSingleFile(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "appendonly.aof")
+	os.WriteFile(path, []byte(resp.Encode(bulkCommand("SET", "old", "1"))), 0644)
 
-	cmd := resp.Value{Type: resp.Array, Items: []resp.Value{{Type: resp.BulkString, Text: "PING"}}}
-	if err := aof.Append(cmd); err != nil {
-		t.Error("Append with disabled AOF should not error")
+	dir := filepath.Join(root, "appendonlydir")
+	_, commands := loadAll(t, dir, path)
+	if len(commands) != 1 || commands[0] != "SET old" {
+		t.Errorf("Expected the old AOF loaded as the base, got %v", commands)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the old AOF moved into the directory")
 	}
+}
 
-	err := aof.Load("dummy.aof", func(cmd string, args []resp.Value) {})
-	if err != nil {
-		t.Error("Load with disabled AOF should not error")
+func TestAOFLoadTruncated(t *testing.T) {
+	good := resp.Encode(bulkCommand("SET", "a", "1")) + resp.Encode(bulkCommand("SET", "b", "2"))
+	partial := resp.Encode(bulkCommand("SET", "c", "3"))[:10]
+
+	for _, allow := range []bool{false, true} {
+		aof, dir, incr := openAOF(t)
+		aof.Close()
+		os.WriteFile(incr, []byte(good+partial), 0644)
+		aof, _ = OpenMultiPartAOF(dir, filepath.Join(dir, "appendonly.aof"), false, true)
+		aof.SetLoadTruncated(allow)
+		commands := 0
+		err := aof.LoadAll(func(*Snapshot) {}, func(cmd string, args []resp.Value) { commands++ })
+		aof.Close()
+
+		data, _ := os.ReadFile(incr)
+		if allow {
+			if err != nil || commands != 2 || string(data) != good {
+				t.Errorf("Expected the partial command cut off, got %v, %d commands, %d bytes", err, commands, len(data))
+			}
+		} else if !errors.Is(err, ErrAOFCorrupt) || len(data) != len(good+partial) {
+			t.Errorf("Expected ErrAOFCorrupt and the file untouched, got %v, %d bytes", err, len(data))
+		}
+	}
+}
+
+func TestAOFLoadCorrupt(t *testing.T) {
+	aof, dir, incr := openAOF(t)
+	aof.Close()
+	set := resp.Encode(bulkCommand("SET", "a", "1"))
+	os.WriteFile(incr, []byte(set+"$3\r\nfoo\r\n"+set), 0644)
+
+	aof, _ = OpenMultiPartAOF(dir, filepath.Join(dir, "appendonly.aof"), false, true)
+	defer aof.Close()
+	aof.SetLoadTruncated(true)
+	err := aof.LoadAll(func(*Snapshot) {}, func(cmd string, args []resp.Value) {})
+	if !errors.Is(err, ErrAOFCorrupt) {
+		t.Errorf("Expected mid-file corruption to fail the load, got %v", err)
+	}
+}
+
+func TestCheckAOF(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+	aof, _ := OpenMultiPartAOF(dir, path, true, true)
+	aof.Append(bulkCommand("SET", "a", "1"))
+	rewrite, _ := aof.BeginRewrite()
+	rewrite.Finish(func(emit func(Snapshot) error) error {
+		return emit(Snapshot{DictData: map[string]datastructure.Item{"a": {Value: "1"}}})
+	})
+	aof.Append(bulkCommand("SET", "b", "2"))
+	aof.Close()
+
+	incr := filepath.Join(dir, "appendonly.aof.2.incr.aof")
+	valid := int64(len(resp.Encode(bulkCommand("SET", "b", "2"))))
+	f, _ := os.OpenFile(incr, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("*2\r\n$3\r\nDEL")
+	f.Close()
+
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	checks, err := CheckAOF(manifest)
+	if err != nil || len(checks) != 2 {
+		t.Fatalf("Expected the base and one incremental file checked, got %v %v", checks, err)
+	}
+	if checks[0].Err != nil {
+		t.Errorf("Expected a good base, got %v", checks[0].Err)
+	}
+	c := checks[1]
+	if c.Err == nil || !c.Truncated || c.Valid != valid || c.Commands != 1 || !c.Fixable {
+		t.Errorf("Expected a fixable truncation at %d, got %+v", valid, c)
+	}
+	if err := FixAOF(c); err != nil {
+		t.Fatalf("FixAOF failed: %v", err)
+	}
+	if checks, _ := CheckAOF(manifest); checks[1].Err != nil {
+		t.Errorf("Expected the fixed file to check clean, got %v", checks[1].Err)
+	}
+	if err := FixAOF(checks[0]); err == nil {
+		t.Error("Expected the base to be refused for fixing")
 	}
 }
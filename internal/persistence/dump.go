@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc64"
+)
+
+const dumpTrailerSize = 2 + crcSize
+
+// ErrDumpPayload is returned for a payload that is damaged or was written by
+// a newer version.
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// EncodeDump returns the payload of key in snapshot.
+func EncodeDump(snapshot Snapshot, key string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot.renameKey(key, "")); err != nil {
+		return nil, err
+	}
+	payload := binary.LittleEndian.AppendUint16(buf.Bytes(), rdbVersion)
+	return binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable)), nil
+}
+
+// DecodeDump checks payload and returns its snapshot with the value under
+// key.
+func DecodeDump(payload []byte, key string) (*Snapshot, error) {
+	if len(payload) < dumpTrailerSize {
+		return nil, ErrDumpPayload
+	}
+	body := payload[:len(payload)-crcSize]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(payload[len(body):]) {
+		return nil, ErrDumpPayload
+	}
+	if binary.LittleEndian.Uint16(body[len(body)-2:]) > rdbVersion {
+		return nil, ErrDumpPayload
+	}
+	var snapshot Snapshot
+	if err := gob.NewDecoder(bytes.NewReader(body[:len(body)-2])).Decode(&snapshot); err != nil {
+		return nil, ErrDumpPayload
+	}
+	snapshot = snapshot.renameKey("", key)
+	return &snapshot, nil
+}
+
+func (s Snapshot) renameKey(from, to string) Snapshot {
+	return Snapshot{
+		DictData:   renameIn(s.DictData, from, to),
+		SetData:    renameIn(s.SetData, from, to),
+		ListData:   renameIn(s.ListData, from, to),
+		HashData:   renameIn(s.HashData, from, to),
+		ZSetData:   renameIn(s.ZSetData, from, to),
+		JSONData:   renameIn(s.JSONData, from, to),
+		TSData:     renameIn(s.TSData, from, to),
+		SketchData: renameIn(s.SketchData, from, to),
+	}
+}
+
+func renameIn[V any](m map[string]V, from, to string) map[string]V {
+	v, ok := m[from]
+	if !ok {
+		return nil
+	}
+	return map[string]V{to: v}
+}
@@ -0,0 +1,45 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+)
+
+func TestDump(t *testing.T) {
+	snapshot := Snapshot{
+		DictData: map[string]datastructure.Item{"a": {Value: "1"}, "b": {Value: "2"}},
+		HashData: map[string]map[string]string{"h": {"f": "v"}},
+	}
+	payload, err := EncodeDump(snapshot, "a")
+	if err != nil {
+		t.Fatalf("EncodeDump failed: %v", err)
+	}
+	restored, err := DecodeDump(payload, "renamed")
+	if err != nil {
+		t.Fatalf("DecodeDump failed: %v", err)
+	}
+	if len(restored.DictData) != 1 || restored.DictData["renamed"].Value != "1" || restored.HashData != nil {
+		t.Errorf("Expected only a under its new name, got %+v", restored)
+	}
+
+	flipped := bytes.Clone(payload)
+	flipped[len(flipped)/2] ^= 1
+	newer := bytes.Clone(payload[:len(payload)-dumpTrailerSize])
+	newer = binary.LittleEndian.AppendUint16(newer, rdbVersion+1)
+	newer = binary.LittleEndian.AppendUint64(newer, crc64.Checksum(newer, crcTable))
+	for name, b := range map[string][]byte{
+		"bit flipped": flipped,
+		"truncated":   payload[:len(payload)-1],
+		"short":       payload[:3],
+		"newer":       newer,
+	} {
+		if _, err := DecodeDump(b, "k"); !errors.Is(err, ErrDumpPayload) {
+			t.Errorf("%s: expected ErrDumpPayload, got %v", name, err)
+		}
+	}
+}
@@ -1,19 +1,32 @@
 package persistence
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc64"
 	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
 )
 
 type Snapshot struct {
-	DictData map[string]datastructure.Item
-	SetData  map[string]datastructure.Item
-	ListData map[string][]datastructure.Item
-	HashData map[string]map[string]string
+	DictData   map[string]datastructure.Item
+	SetData    map[string]datastructure.Item
+	ListData   map[string][]datastructure.Item
+	HashData   map[string]map[string]string
+	ZSetData   map[string]map[string]float64
+	JSONData   map[string]string
+	TSData     map[string]datastructure.TSDump
+	SketchData map[string]datastructure.SketchDump
 }
 
 type RDB struct {
@@ -40,40 +53,166 @@ func OpenRDB(path string, enabled bool) (*RDB, error) {
 	}, nil
 }
 
+// An RDB file is a header of rdbMagic and a four digit version, the parts
+// of the snapshot gob-encoded one after another, and a trailer holding the
+// CRC-64 of everything before it, little-endian.
+const (
+	rdbMagic   = "VALKEYDB"
+	rdbVersion = 1
+	headerSize = len(rdbMagic) + 4
+	crcSize    = 8
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// ErrRDBCorrupt is returned by Load for a file that is truncated, fails its
+// checksum or does not decode.
+var ErrRDBCorrupt = errors.New("RDB file is corrupt")
+
 func (r *RDB) Save(snapshot Snapshot, path string) error {
+	return r.SaveStream(path, func(emit func(Snapshot) error) error {
+		return emit(snapshot)
+	})
+}
+
+// SaveStream writes the snapshot in parts, each straight to disk, beside
+// path and renames it over path once synced.
+func (r *RDB) SaveStream(path string, write func(emit func(Snapshot) error) error) error {
 	if !r.enabled {
 		return nil
 	}
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return SaveRDB(path, write)
+}
 
-	f, err := os.Create(path)
+// SaveRDB writes an RDB file at path from the parts write passes to emit,
+// replacing it only once the new file is on disk.
+func SaveRDB(path string, write func(emit func(Snapshot) error) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
 	if err != nil {
 		return err
 	}
+	if err := writeRDB(f, write); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
 
-	defer f.Close()
+func writeRDB(f *os.File, write func(emit func(Snapshot) error) error) error {
+	crc := crc64.New(crcTable)
+	w := bufio.NewWriter(io.MultiWriter(f, crc))
+	if _, err := fmt.Fprintf(w, "%s%04d", rdbMagic, rdbVersion); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(w)
+	err := write(func(part Snapshot) error {
+		if part.empty() {
+			return nil
+		}
+		return enc.Encode(part)
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Write(binary.LittleEndian.AppendUint64(nil, crc.Sum64())); err != nil {
+		return err
+	}
+	return f.Sync()
+}
 
-	encoder := gob.NewEncoder(f)
-	return encoder.Encode(snapshot)
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
+// Load reads the snapshot at path. A missing or empty file is no snapshot;
+// a damaged one is an error wrapping ErrRDBCorrupt.
 func (r *RDB) Load(path string) (*Snapshot, error) {
 	if !r.enabled {
 		return nil, nil
 	}
+	return ReadRDB(path)
+}
 
-	f, err := os.Open(path)
+// ReadRDB reads the RDB file at path like RDB.Load.
+func ReadRDB(path string) (*Snapshot, error) {
+	f, body, err := openRDB(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	defer f.Close()
+	if body == nil {
+		return nil, nil
+	}
+	snapshot, err := DecodeSnapshot(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, ErrRDBCorrupt, err)
+	}
+	return snapshot, nil
+}
 
+// ScanRDB passes the parts of the RDB file at path to fn one at a time, so
+// only one part is ever in memory. The file is checked in full first.
+func ScanRDB(path string, fn func(Snapshot) error) error {
+	f, body, err := openRDB(path)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
+	if body == nil {
+		return nil
+	}
+	dec := gob.NewDecoder(body)
+	for {
+		var part Snapshot
+		if err := dec.Decode(&part); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w: %v", path, ErrRDBCorrupt, err)
+		}
+		if err := fn(part); err != nil {
+			return err
+		}
+	}
+}
+
+func openRDB(path string) (*os.File, io.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := checkRDB(f, path)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, body, nil
+}
 
+func checkRDB(f *os.File, path string) (io.Reader, error) {
 	stat, err := f.Stat()
 	if err != nil {
 		return nil, err
@@ -82,16 +221,91 @@ func (r *RDB) Load(path string) (*Snapshot, error) {
 		return nil, nil
 	}
 
-	var snapshot Snapshot
-	decoder := gob.NewDecoder(f)
-	if err := decoder.Decode(&snapshot); err != nil {
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			return nil, nil
+	header := make([]byte, headerSize)
+	n, _ := io.ReadFull(f, header)
+	if !bytes.HasPrefix(header[:n], []byte(rdbMagic)) {
+		// Files saved before the header was added are a bare gob stream
+		// without a checksum.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
 		}
+		return bufio.NewReader(f), nil
+	}
+	if n < headerSize || stat.Size() < int64(headerSize+crcSize) {
+		return nil, fmt.Errorf("%s: %w: truncated", path, ErrRDBCorrupt)
+	}
+	version, err := strconv.Atoi(string(header[len(rdbMagic):]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: bad version %q", path, ErrRDBCorrupt, header[len(rdbMagic):])
+	}
+	if version > rdbVersion {
+		return nil, fmt.Errorf("%s: RDB version %d is newer than the supported %d", path, version, rdbVersion)
+	}
+
+	// Check the whole file before decoding any of it.
+	bodySize := stat.Size() - int64(headerSize+crcSize)
+	crc := crc64.New(crcTable)
+	crc.Write(header)
+	if _, err := io.CopyN(crc, f, bodySize); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, ErrRDBCorrupt, err)
+	}
+	trailer := make([]byte, crcSize)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, ErrRDBCorrupt, err)
+	}
+	if want := binary.LittleEndian.Uint64(trailer); crc.Sum64() != want {
+		return nil, fmt.Errorf("%s: %w: checksum %016x, expected %016x", path, ErrRDBCorrupt, crc.Sum64(), want)
+	}
+
+	if _, err := f.Seek(int64(headerSize), io.SeekStart); err != nil {
 		return nil, err
 	}
+	return bufio.NewReader(io.LimitReader(f, bodySize)), nil
+}
 
-	return &snapshot, nil
+// EncodeSnapshot writes snapshot in the RDB format. Replication uses it to
+// stream a full sync payload.
+func EncodeSnapshot(w io.Writer, snapshot Snapshot) error {
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+// DecodeSnapshot reads a snapshot, merging the parts of a streamed save.
+func DecodeSnapshot(r io.Reader) (*Snapshot, error) {
+	dec := gob.NewDecoder(r)
+	var snapshot Snapshot
+	for {
+		var part Snapshot
+		if err := dec.Decode(&part); err == io.EOF {
+			return &snapshot, nil
+		} else if err != nil {
+			return nil, err
+		}
+		snapshot.merge(part)
+	}
+}
+
+func (s Snapshot) empty() bool {
+	return len(s.DictData) == 0 && len(s.SetData) == 0 && len(s.ListData) == 0 && len(s.HashData) == 0 &&
+		len(s.ZSetData) == 0 && len(s.JSONData) == 0 && len(s.TSData) == 0 && len(s.SketchData) == 0
+}
+
+func (s *Snapshot) merge(part Snapshot) {
+	s.DictData = mergeMap(s.DictData, part.DictData)
+	s.SetData = mergeMap(s.SetData, part.SetData)
+	s.ListData = mergeMap(s.ListData, part.ListData)
+	s.HashData = mergeMap(s.HashData, part.HashData)
+	s.ZSetData = mergeMap(s.ZSetData, part.ZSetData)
+	s.JSONData = mergeMap(s.JSONData, part.JSONData)
+	s.TSData = mergeMap(s.TSData, part.TSData)
+	s.SketchData = mergeMap(s.SketchData, part.SketchData)
+}
+
+func mergeMap[V any](dst, src map[string]V) map[string]V {
+	if dst == nil {
+		return src
+	}
+	maps.Copy(dst, src)
+	return dst
 }
 
 func (r *RDB) Close() error {
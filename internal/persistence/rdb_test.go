@@ -1,7 +1,10 @@
 package persistence
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -56,6 +59,55 @@ func TestRDBSaveLoad(t *testing.T) {
 	}
 }
 
+func TestRDBSaveStream(t *testing.T) {
+	tmpFile := "test_stream.rdb"
+	defer os.Remove(tmpFile)
+
+	rdb, err := OpenRDB(tmpFile, true)
+	if err != nil {
+		t.Fatalf("OpenRDB failed: %v", err)
+	}
+	defer rdb.Close()
+
+	parts := []Snapshot{
+		{DictData: map[string]datastructure.Item{"a": {Value: "1"}}},
+		{},
+		{DictData: map[string]datastructure.Item{"b": {Value: "2"}}},
+		{HashData: map[string]map[string]string{"h": {"f": "v"}}},
+	}
+	err = rdb.SaveStream(tmpFile, func(emit func(Snapshot) error) error {
+		for _, part := range parts {
+			if err := emit(part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SaveStream failed: %v", err)
+	}
+
+	loaded, err := rdb.Load(tmpFile)
+	if err != nil || loaded == nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded.DictData) != 2 || loaded.DictData["b"].Value != "2" {
+		t.Errorf("Expected the dict parts merged, got %v", loaded.DictData)
+	}
+	if loaded.HashData["h"]["f"] != "v" {
+		t.Errorf("Expected the hash part, got %v", loaded.HashData)
+	}
+
+	var scanned []Snapshot
+	err = ScanRDB(tmpFile, func(part Snapshot) error {
+		scanned = append(scanned, part)
+		return nil
+	})
+	if err != nil || len(scanned) != 3 || scanned[1].DictData["b"].Value != "2" {
+		t.Errorf("Expected the non-empty parts one at a time, got %v: %v", scanned, err)
+	}
+}
+
 func TestRDBLoadEmpty(t *testing.T) {
 	tmpFile := "test_empty.rdb"
 	os.WriteFile(tmpFile, []byte{}, 0644)
@@ -86,3 +138,60 @@ func TestRDBDisabled(t *testing.T) {
 		t.Error("Load with disabled RDB should return nil")
 	}
 }
+
+func TestRDBCorrupt(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "dump.rdb")
+	rdb, _ := OpenRDB(tmpFile, true)
+	defer rdb.Close()
+
+	snapshot := Snapshot{DictData: map[string]datastructure.Item{"k": {Value: "v"}}}
+	if err := rdb.Save(snapshot, tmpFile); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(tmpFile + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Save left its temp file behind")
+	}
+	data, _ := os.ReadFile(tmpFile)
+	if !bytes.HasPrefix(data, []byte("VALKEYDB0001")) {
+		t.Errorf("Expected the versioned header, got %q", data[:12])
+	}
+
+	damaged := map[string][]byte{
+		"truncated":   data[:len(data)-3],
+		"header only": data[:12],
+		"bit flipped": bytes.Clone(data),
+	}
+	damaged["bit flipped"][len(data)/2] ^= 1
+	for name, b := range damaged {
+		os.WriteFile(tmpFile, b, 0644)
+		if _, err := rdb.Load(tmpFile); !errors.Is(err, ErrRDBCorrupt) {
+			t.Errorf("%s: expected ErrRDBCorrupt, got %v", name, err)
+		}
+	}
+
+	newer := bytes.Replace(data, []byte("VALKEYDB0001"), []byte("VALKEYDB0099"), 1)
+	os.WriteFile(tmpFile, newer, 0644)
+	if _, err := rdb.Load(tmpFile); err == nil {
+		t.Error("Expected a newer version to be refused")
+	}
+}
+
+func TestRDBLoadLegacy(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "dump.rdb")
+	var buf bytes.Buffer
+	snapshot := Snapshot{DictData: map[string]datastructure.Item{"k": {Value: "v"}}}
+	if err := EncodeSnapshot(&buf, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(tmpFile, buf.Bytes(), 0644)
+
+	rdb, _ := OpenRDB(tmpFile, true)
+	defer rdb.Close()
+	loaded, err := rdb.Load(tmpFile)
+	if err != nil {
+		t.Fatalf("Load of a header-less file failed: %v", err)
+	}
+	if loaded.DictData["k"].Value != "v" {
+		t.Errorf("Expected k=v, got %v", loaded.DictData)
+	}
+}
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -93,6 +94,8 @@ func readInteger(r *bufio.Reader) (Value, error) {
 	return Value{Type: Integer, Number: n}, nil
 }
 
+const maxBulkLen = 512 << 20
+
 func readBulkString(r *bufio.Reader) (Value, error) {
 	line, err := readLine(r)
 	if err != nil {
@@ -105,11 +108,17 @@ func readBulkString(r *bufio.Reader) (Value, error) {
 	if n == -1 {
 		return Value{Type: BulkString, IsNil: true}, nil
 	}
+	if n < 0 || n > maxBulkLen {
+		return Value{}, fmt.Errorf("invalid bulk length %d", n)
+	}
 
 	buf := make([]byte, n+2)
 	if _, err := io.ReadFull(r, buf); err != nil {
 		return Value{}, err
 	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return Value{}, errors.New("bulk string not terminated by CRLF")
+	}
 	return Value{Type: BulkString, Text: string(buf[:n])}, nil
 }
 
@@ -125,8 +134,11 @@ func readArray(r *bufio.Reader) (Value, error) {
 	if count == -1 {
 		return Value{Type: Array, IsNil: true}, nil
 	}
+	if count < 0 {
+		return Value{}, fmt.Errorf("invalid array length %d", count)
+	}
 
-	values := make([]Value, 0, count)
+	values := make([]Value, 0, min(count, 1024))
 	for i := 0; i < count; i++ {
 		v, err := Decode(r)
 		if err != nil {
@@ -0,0 +1,290 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	stateFile    = "raft-state"
+	logFile      = "raft-log.aof"
+	snapshotFile = "raft-snapshot.rdb"
+)
+
+// Entry is one slot of the replicated log. Command is a RESP command array,
+// the same form the AOF stores.
+type Entry struct {
+	Term    uint64
+	Index   uint64
+	Command resp.Value
+}
+
+func (e Entry) encode() string {
+	return resp.Encode(resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.BulkString, Text: strconv.FormatUint(e.Term, 10)},
+		{Type: resp.BulkString, Text: strconv.FormatUint(e.Index, 10)},
+		e.Command,
+	}})
+}
+
+func decodeEntry(v resp.Value) (Entry, error) {
+	if v.Type != resp.Array || len(v.Items) != 3 || v.Items[2].Type != resp.Array {
+		return Entry{}, errors.New("malformed log record")
+	}
+	term, err1 := strconv.ParseUint(v.Items[0].Text, 10, 64)
+	index, err2 := strconv.ParseUint(v.Items[1].Text, 10, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return Entry{}, err
+	}
+	return Entry{Term: term, Index: index, Command: v.Items[2]}, nil
+}
+
+type snapshot struct {
+	Index   uint64
+	Term    uint64
+	Members []Member
+	Data    []byte
+}
+
+type raftLog struct {
+	dir     string
+	snap    snapshot
+	entries []Entry
+	file    *os.File
+}
+
+func openLog(dir string) (*raftLog, error) {
+	l := &raftLog{dir: dir}
+	if dir == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := l.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := l.loadEntries(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *raftLog) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(l.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&l.snap); err != nil {
+		return fmt.Errorf("raft snapshot: %w", err)
+	}
+	return nil
+}
+
+func (l *raftLog) loadEntries() error {
+	path := filepath.Join(l.dir, logFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	src := bytes.NewReader(data)
+	reader := bufio.NewReader(src)
+	for {
+		consumed := len(data) - src.Len() - reader.Buffered()
+		v, err := resp.Decode(reader)
+		if err != nil {
+			if consumed == len(data) {
+				return nil
+			}
+			return l.rewrite()
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return fmt.Errorf("raft log %s: %w", path, err)
+		}
+		if e.Index <= l.snap.Index {
+			continue
+		}
+		if e.Index != l.lastIndex()+1 {
+			return fmt.Errorf("raft log %s: entry %d follows %d", path, e.Index, l.lastIndex())
+		}
+		l.entries = append(l.entries, e)
+	}
+}
+
+func (l *raftLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func (l *raftLog) firstIndex() uint64 { return l.snap.Index + 1 }
+
+func (l *raftLog) lastIndex() uint64 { return l.snap.Index + uint64(len(l.entries)) }
+
+func (l *raftLog) lastTerm() uint64 {
+	if len(l.entries) == 0 {
+		return l.snap.Term
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	switch {
+	case index == l.snap.Index:
+		return l.snap.Term, true
+	case index < l.snap.Index || index > l.lastIndex():
+		return 0, false
+	}
+	return l.entries[index-l.firstIndex()].Term, true
+}
+
+func (l *raftLog) entry(index uint64) Entry {
+	return l.entries[index-l.firstIndex()]
+}
+
+func (l *raftLog) slice(from, to uint64) []Entry {
+	if from > to {
+		return nil
+	}
+	return append([]Entry(nil), l.entries[from-l.firstIndex():to-l.firstIndex()+1]...)
+}
+
+func (l *raftLog) append(entries ...Entry) error {
+	l.entries = append(l.entries, entries...)
+	if l.file == nil {
+		return nil
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.encode())
+	}
+	if _, err := l.file.WriteString(b.String()); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *raftLog) truncate(index uint64) error {
+	l.entries = l.entries[:index-l.firstIndex()]
+	return l.rewrite()
+}
+
+func (l *raftLog) compact(snap snapshot) error {
+	if t, ok := l.term(snap.Index); ok && t == snap.Term {
+		l.entries = append([]Entry(nil), l.entries[snap.Index-l.firstIndex()+1:]...)
+	} else {
+		l.entries = nil
+	}
+	l.snap = snap
+	if l.dir == "" {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(snap); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(l.dir, snapshotFile), b.Bytes()); err != nil {
+		return err
+	}
+	return l.rewrite()
+}
+
+func (l *raftLog) rewrite() error {
+	if l.dir == "" {
+		return nil
+	}
+	var b strings.Builder
+	for _, e := range l.entries {
+		b.WriteString(e.encode())
+	}
+	path := filepath.Join(l.dir, logFile)
+	if err := writeFile(path, []byte(b.String())); err != nil {
+		return err
+	}
+	if l.file == nil {
+		return nil
+	}
+	_ = l.file.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+func (l *raftLog) saveState(term uint64, votedFor string) error {
+	if l.dir == "" {
+		return nil
+	}
+	return writeFile(filepath.Join(l.dir, stateFile), fmt.Appendf(nil, "%d %s\n", term, votedFor))
+}
+
+func (l *raftLog) loadState() (uint64, string, error) {
+	if l.dir == "" {
+		return 0, "", nil
+	}
+	data, err := os.ReadFile(filepath.Join(l.dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, "", errors.New("raft state: empty file")
+	}
+	term, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("raft state: %w", err)
+	}
+	votedFor := ""
+	if len(fields) > 1 {
+		votedFor = fields[1]
+	}
+	return term, votedFor, nil
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
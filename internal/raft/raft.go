@@ -0,0 +1,1003 @@
+// Package raft replicates the write command log across a group of nodes so
+// a write is acknowledged only once a majority has stored it.
+package raft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	Follower  = "follower"
+	Candidate = "candidate"
+	Leader    = "leader"
+
+	// Internal log commands, never passed to the state machine.
+	cmdNoop   = "RAFT.NOOP"
+	cmdConfig = "RAFT.CONFIG"
+
+	maxBatch = 512
+)
+
+var (
+	ErrNoLeader     = errors.New("TRYAGAIN No raft leader is known yet")
+	ErrTimeout      = errors.New("TRYAGAIN Timed out waiting for the raft commit")
+	ErrLost         = errors.New("TRYAGAIN Leadership changed before the write committed; it may or may not have been applied")
+	ErrClosed       = errors.New("ERR raft node is shutting down")
+	ErrConfigChange = errors.New("ERR A membership change is already in progress")
+	ErrMemberExists = errors.New("ERR Node is already a member")
+	ErrNotMember    = errors.New("ERR Node is not a member")
+)
+
+// NotLeaderError sends a client to the leader's client address.
+type NotLeaderError struct {
+	Leader Member
+}
+
+func (e *NotLeaderError) Error() string {
+	addr := e.Leader.ClientAddr
+	if addr == "" {
+		addr = e.Leader.Addr
+	}
+	return "NOTLEADER " + addr
+}
+
+// StateMachine is the dataset the log is applied to.
+type StateMachine interface {
+	Apply(cmd string, args []resp.Value) resp.Value
+	Snapshot() persistence.Snapshot
+	Replace(snapshot *persistence.Snapshot)
+}
+
+// Member is a node of the group.
+type Member struct {
+	ID string
+	// Addr is where the node serves raft RPCs.
+	Addr string
+	// ClientAddr is the node's RESP address, reported to redirected clients.
+	ClientAddr string
+}
+
+type Config struct {
+	ID         string
+	Addr       string
+	ClientAddr string
+	// Peers is the initial membership, this node included, used only when
+	// the node has no log yet.
+	Peers []Member
+	// Dir holds the log, snapshot and vote. Empty keeps them in memory.
+	Dir               string
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotEntries is how many applied entries trigger a snapshot.
+	SnapshotEntries int
+	// Listener, when set, serves raft RPCs instead of listening on Addr.
+	Listener net.Listener
+}
+
+// Status is a summary for RAFT INFO and INFO raft.
+type Status struct {
+	ID          string
+	Role        string
+	Term        uint64
+	Leader      string
+	CommitIndex uint64
+	LastApplied uint64
+	LastIndex   uint64
+	SnapshotAt  uint64
+	Members     []Member
+}
+
+type replicator struct {
+	member  Member
+	trigger chan struct{}
+	stop    chan struct{}
+	// acked is the highest request sequence the follower answered in the
+	// current term, which ReadIndex uses to confirm leadership.
+	acked uint64
+}
+
+type waiter struct {
+	term   uint64
+	result resp.Value
+	err    error
+	done   bool
+}
+
+type Raft struct {
+	cfg Config
+	sm  StateMachine
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	log         *raftLog
+	role        string
+	term        uint64
+	votedFor    string
+	leaderID    string
+	lastContact time.Time
+	deadline    time.Time
+	members     []Member
+	configIndex uint64
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	replicators map[string]*replicator
+	seq         uint64
+	waiters     map[uint64]*waiter
+
+	// applyMu is held while the state machine changes, by the applier or by
+	// an installed snapshot. It is taken before mu.
+	applyMu sync.Mutex
+
+	connMu sync.Mutex
+	conns  map[string]*conn
+
+	listener net.Listener
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New restores the node from cfg.Dir and loads its latest snapshot into sm.
+func New(cfg Config, sm StateMachine) (*Raft, error) {
+	if cfg.ID == "" || strings.ContainsAny(cfg.ID, " \r\n") {
+		return nil, fmt.Errorf("raft: invalid node id %q", cfg.ID)
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = time.Second
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = cfg.ElectionTimeout / 10
+	}
+	if cfg.SnapshotEntries <= 0 {
+		cfg.SnapshotEntries = 10000
+	}
+
+	l, err := openLog(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Raft{
+		cfg:         cfg,
+		sm:          sm,
+		log:         l,
+		role:        Follower,
+		nextIndex:   map[string]uint64{},
+		matchIndex:  map[string]uint64{},
+		replicators: map[string]*replicator{},
+		waiters:     map[uint64]*waiter{},
+		conns:       map[string]*conn{},
+		stop:        make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	if r.term, r.votedFor, err = l.loadState(); err != nil {
+		l.close()
+		return nil, err
+	}
+	if l.snap.Index > 0 {
+		data, err := persistence.DecodeSnapshot(bytes.NewReader(l.snap.Data))
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("raft snapshot: %w", err)
+		}
+		sm.Replace(data)
+		r.commitIndex, r.lastApplied = l.snap.Index, l.snap.Index
+	}
+	r.reloadMembers()
+	if r.members == nil && l.lastIndex() == 0 && len(cfg.Peers) > 0 {
+		// A fresh group starts from the configured peers. Every founding
+		// node writes the same first entry, so their logs agree.
+		r.members = slices.Clone(cfg.Peers)
+		if err := l.append(Entry{Term: 0, Index: 1, Command: configCommand(r.members)}); err != nil {
+			l.close()
+			return nil, err
+		}
+		r.configIndex = 1
+	}
+	return r, nil
+}
+
+// Start serves raft RPCs on cfg.Addr and begins the election timer.
+func (r *Raft) Start() error {
+	ln := r.cfg.Listener
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", r.cfg.Addr); err != nil {
+			return err
+		}
+	}
+	r.listener = ln
+	r.mu.Lock()
+	r.resetDeadline()
+	r.mu.Unlock()
+	r.wg.Add(3)
+	go r.acceptLoop()
+	go r.run()
+	go r.applier()
+	return nil
+}
+
+func (r *Raft) closed() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Raft) Close() {
+	if r.closed() {
+		return
+	}
+	close(r.stop)
+	if r.listener != nil {
+		_ = r.listener.Close()
+	}
+	r.mu.Lock()
+	r.stopReplicators()
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	r.closeConns()
+	r.wg.Wait()
+	_ = r.log.close()
+}
+
+// Addr is the address raft RPCs are served on.
+func (r *Raft) Addr() string {
+	if r.listener != nil {
+		return r.listener.Addr().String()
+	}
+	return r.cfg.Addr
+}
+
+func (r *Raft) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Status{
+		ID:          r.cfg.ID,
+		Role:        r.role,
+		Term:        r.term,
+		Leader:      r.leaderID,
+		CommitIndex: r.commitIndex,
+		LastApplied: r.lastApplied,
+		LastIndex:   r.log.lastIndex(),
+		SnapshotAt:  r.log.snap.Index,
+		Members:     slices.Clone(r.members),
+	}
+}
+
+func (r *Raft) IsLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == Leader
+}
+
+// Callers hold r.mu.
+func (r *Raft) resetDeadline() {
+	t := r.cfg.ElectionTimeout
+	r.deadline = time.Now().Add(t + rand.N(t))
+}
+
+func (r *Raft) member(id string) (Member, bool) {
+	for _, m := range r.members {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+func (r *Raft) quorum() int { return len(r.members)/2 + 1 }
+
+func (r *Raft) persistState() {
+	if err := r.log.saveState(r.term, r.votedFor); err != nil {
+		log.Printf("raft: saving state: %v", err)
+	}
+}
+
+// Callers hold r.mu.
+func (r *Raft) notLeader() error {
+	if m, ok := r.member(r.leaderID); ok && r.leaderID != r.cfg.ID {
+		return &NotLeaderError{Leader: m}
+	}
+	return ErrNoLeader
+}
+
+func (r *Raft) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		if r.role == Leader {
+			r.triggerAll()
+		} else if time.Now().After(r.deadline) {
+			// Only members campaign; a node that was removed, or has not
+			// been added yet, waits to hear from a leader.
+			if _, ok := r.member(r.cfg.ID); ok {
+				r.startElection()
+			} else {
+				r.resetDeadline()
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Callers hold r.mu.
+func (r *Raft) becomeFollower(term uint64, leader string) {
+	if term > r.term {
+		r.term, r.votedFor = term, ""
+		r.persistState()
+	}
+	if r.role != Follower {
+		log.Printf("raft: %s is a follower in term %d", r.cfg.ID, r.term)
+	}
+	r.role = Follower
+	r.leaderID = leader
+	r.stopReplicators()
+	r.resetDeadline()
+	r.cond.Broadcast()
+}
+
+// Callers hold r.mu.
+func (r *Raft) startElection() {
+	r.role = Candidate
+	r.term++
+	r.votedFor = r.cfg.ID
+	r.leaderID = ""
+	r.persistState()
+	r.resetDeadline()
+	log.Printf("raft: %s starts an election for term %d", r.cfg.ID, r.term)
+
+	req := &voteRequest{Term: r.term, Candidate: r.cfg.ID, LastIndex: r.log.lastIndex(), LastTerm: r.log.lastTerm()}
+	votes := 1
+	if votes >= r.quorum() {
+		r.becomeLeader()
+		return
+	}
+	for _, m := range r.members {
+		if m.ID == r.cfg.ID {
+			continue
+		}
+		go func(m Member) {
+			reply, err := r.call(m.Addr, &request{Vote: req})
+			if err != nil || reply.Vote == nil {
+				return
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			switch {
+			case reply.Vote.Term > r.term:
+				r.becomeFollower(reply.Vote.Term, "")
+			case r.role == Candidate && r.term == req.Term && reply.Vote.Granted:
+				votes++
+				if votes >= r.quorum() {
+					r.becomeLeader()
+				}
+			}
+		}(m)
+	}
+}
+
+// Callers hold r.mu.
+func (r *Raft) becomeLeader() {
+	r.role = Leader
+	r.leaderID = r.cfg.ID
+	log.Printf("raft: %s is the leader for term %d", r.cfg.ID, r.term)
+	for _, m := range r.members {
+		r.nextIndex[m.ID] = r.log.lastIndex() + 1
+		r.matchIndex[m.ID] = 0
+	}
+	if _, err := r.appendLocal(resp.Value{Type: resp.Array, Items: []resp.Value{{Type: resp.BulkString, Text: cmdNoop}}}); err != nil {
+		log.Printf("raft: appending no-op: %v", err)
+	}
+	r.syncReplicators()
+	r.triggerAll()
+	r.cond.Broadcast()
+}
+
+// Callers hold r.mu.
+func (r *Raft) appendLocal(cmd resp.Value) (Entry, error) {
+	e := Entry{Term: r.term, Index: r.log.lastIndex() + 1, Command: cmd}
+	if err := r.log.append(e); err != nil {
+		return Entry{}, err
+	}
+	if isConfig(cmd) {
+		r.members, r.configIndex = parseConfig(cmd), e.Index
+		r.syncReplicators()
+	}
+	r.matchIndex[r.cfg.ID] = e.Index
+	r.advanceCommit()
+	return e, nil
+}
+
+// Callers hold r.mu.
+func (r *Raft) syncReplicators() {
+	if r.role != Leader {
+		return
+	}
+	for id, rep := range r.replicators {
+		if _, ok := r.member(id); !ok {
+			close(rep.stop)
+			delete(r.replicators, id)
+		}
+	}
+	for _, m := range r.members {
+		if m.ID == r.cfg.ID || r.replicators[m.ID] != nil {
+			continue
+		}
+		if _, ok := r.nextIndex[m.ID]; !ok {
+			r.nextIndex[m.ID] = r.log.lastIndex() + 1
+		}
+		rep := &replicator{member: m, trigger: make(chan struct{}, 1), stop: make(chan struct{})}
+		r.replicators[m.ID] = rep
+		r.wg.Add(1)
+		go r.replicate(rep, r.term)
+	}
+}
+
+func (r *Raft) stopReplicators() {
+	for id, rep := range r.replicators {
+		close(rep.stop)
+		delete(r.replicators, id)
+	}
+}
+
+func (r *Raft) triggerAll() {
+	for _, rep := range r.replicators {
+		select {
+		case rep.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *Raft) replicate(rep *replicator, term uint64) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-rep.stop:
+			return
+		case <-rep.trigger:
+		}
+		for {
+			r.mu.Lock()
+			if r.role != Leader || r.term != term || r.closed() {
+				r.mu.Unlock()
+				return
+			}
+			r.seq++
+			seq := r.seq
+			req := r.buildRequest(rep.member.ID)
+			r.mu.Unlock()
+
+			reply, err := r.call(rep.member.Addr, req)
+			if err != nil {
+				break
+			}
+
+			r.mu.Lock()
+			more := r.handleReply(rep, term, seq, req, reply)
+			r.mu.Unlock()
+			if !more {
+				break
+			}
+		}
+	}
+}
+
+// Callers hold r.mu.
+func (r *Raft) buildRequest(id string) *request {
+	next := r.nextIndex[id]
+	if next <= r.log.snap.Index {
+		return &request{Snapshot: &snapshotRequest{Term: r.term, Leader: r.cfg.ID, Snapshot: r.log.snap}}
+	}
+	prev := next - 1
+	prevTerm, _ := r.log.term(prev)
+	last := min(r.log.lastIndex(), prev+maxBatch)
+	return &request{Append: &appendRequest{
+		Term:      r.term,
+		Leader:    r.cfg.ID,
+		PrevIndex: prev,
+		PrevTerm:  prevTerm,
+		Entries:   r.log.slice(next, last),
+		Commit:    r.commitIndex,
+	}}
+}
+
+// Callers hold r.mu.
+func (r *Raft) handleReply(rep *replicator, term, seq uint64, req *request, reply *response) bool {
+	var replyTerm uint64
+	switch {
+	case reply.Append != nil:
+		replyTerm = reply.Append.Term
+	case reply.Snapshot != nil:
+		replyTerm = reply.Snapshot.Term
+	default:
+		return false
+	}
+	if replyTerm > r.term {
+		r.becomeFollower(replyTerm, "")
+		return false
+	}
+	if r.role != Leader || r.term != term {
+		return false
+	}
+	rep.acked = max(rep.acked, seq)
+	id := rep.member.ID
+
+	if req.Snapshot != nil {
+		r.matchIndex[id] = max(r.matchIndex[id], req.Snapshot.Snapshot.Index)
+		r.nextIndex[id] = r.matchIndex[id] + 1
+	} else if reply.Append.Success {
+		match := req.Append.PrevIndex + uint64(len(req.Append.Entries))
+		r.matchIndex[id] = max(r.matchIndex[id], match)
+		r.nextIndex[id] = r.matchIndex[id] + 1
+		r.advanceCommit()
+	} else {
+		r.nextIndex[id] = max(1, min(reply.Append.ConflictIndex, r.nextIndex[id]-1))
+	}
+	r.cond.Broadcast()
+	return r.nextIndex[id] <= r.log.lastIndex()
+}
+
+// Callers hold r.mu.
+func (r *Raft) advanceCommit() {
+	if r.role != Leader {
+		return
+	}
+	matches := make([]uint64, 0, len(r.members))
+	for _, m := range r.members {
+		if m.ID == r.cfg.ID {
+			matches = append(matches, r.log.lastIndex())
+		} else {
+			matches = append(matches, r.matchIndex[m.ID])
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	n := matches[r.quorum()-1]
+	if n <= r.commitIndex {
+		return
+	}
+	if t, ok := r.log.term(n); !ok || t != r.term {
+		return
+	}
+	r.commitIndex = n
+	r.cond.Broadcast()
+	// Followers learn the new commit index with the next request.
+	r.triggerAll()
+
+	if _, ok := r.member(r.cfg.ID); !ok && r.commitIndex >= r.configIndex {
+		log.Printf("raft: %s was removed from the group and steps down", r.cfg.ID)
+		r.becomeFollower(r.term, "")
+	}
+}
+
+func (r *Raft) handleVote(req *voteRequest) *voteResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// A node that still hears from a leader ignores candidates, so a removed
+	// member cannot disrupt the group with ever higher terms.
+	if r.leaderID != "" && time.Since(r.lastContact) < r.cfg.ElectionTimeout {
+		return &voteResponse{Term: r.term}
+	}
+	if req.Term > r.term {
+		r.becomeFollower(req.Term, "")
+	}
+	granted := false
+	upToDate := req.LastTerm > r.log.lastTerm() ||
+		(req.LastTerm == r.log.lastTerm() && req.LastIndex >= r.log.lastIndex())
+	if req.Term == r.term && (r.votedFor == "" || r.votedFor == req.Candidate) && upToDate {
+		r.votedFor = req.Candidate
+		r.persistState()
+		r.resetDeadline()
+		granted = true
+	}
+	return &voteResponse{Term: r.term, Granted: granted}
+}
+
+// Callers hold r.mu.
+func (r *Raft) heardFromLeader(term uint64, leader string) {
+	if term > r.term || r.role != Follower {
+		r.becomeFollower(term, leader)
+	}
+	r.leaderID = leader
+	r.lastContact = time.Now()
+	r.resetDeadline()
+}
+
+func (r *Raft) handleAppend(req *appendRequest) *appendResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Term < r.term {
+		return &appendResponse{Term: r.term}
+	}
+	r.heardFromLeader(req.Term, req.Leader)
+
+	if req.PrevIndex > r.log.lastIndex() {
+		return &appendResponse{Term: r.term, ConflictIndex: r.log.lastIndex() + 1}
+	}
+	if t, ok := r.log.term(req.PrevIndex); ok && t != req.PrevTerm {
+		// Skip back over the whole conflicting term at once.
+		conflict := req.PrevIndex
+		for conflict > r.log.firstIndex() {
+			if pt, _ := r.log.term(conflict - 1); pt != t {
+				break
+			}
+			conflict--
+		}
+		return &appendResponse{Term: r.term, ConflictIndex: conflict}
+	}
+
+	reload := false
+	for i, e := range req.Entries {
+		if e.Index <= r.log.snap.Index {
+			continue
+		}
+		if e.Index <= r.log.lastIndex() {
+			if t, _ := r.log.term(e.Index); t == e.Term {
+				continue
+			}
+			if err := r.log.truncate(e.Index); err != nil {
+				log.Printf("raft: truncating log: %v", err)
+				return &appendResponse{Term: r.term, ConflictIndex: e.Index}
+			}
+			reload = true
+		}
+		if err := r.log.append(req.Entries[i:]...); err != nil {
+			log.Printf("raft: appending to log: %v", err)
+			return &appendResponse{Term: r.term, ConflictIndex: e.Index}
+		}
+		for _, added := range req.Entries[i:] {
+			reload = reload || isConfig(added.Command)
+		}
+		break
+	}
+	if reload {
+		r.reloadMembers()
+	}
+
+	last := req.PrevIndex + uint64(len(req.Entries))
+	if req.Commit > r.commitIndex {
+		r.commitIndex = max(r.commitIndex, min(req.Commit, last))
+		r.cond.Broadcast()
+	}
+	return &appendResponse{Term: r.term, Success: true}
+}
+
+func (r *Raft) handleSnapshot(req *snapshotRequest) *snapshotResponse {
+	r.mu.Lock()
+	if req.Term < r.term {
+		defer r.mu.Unlock()
+		return &snapshotResponse{Term: r.term}
+	}
+	r.heardFromLeader(req.Term, req.Leader)
+	r.mu.Unlock()
+
+	data, err := persistence.DecodeSnapshot(bytes.NewReader(req.Snapshot.Data))
+	if err != nil {
+		log.Printf("raft: bad snapshot from %s: %v", req.Leader, err)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return &snapshotResponse{Term: r.term}
+	}
+
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Snapshot.Index <= r.lastApplied {
+		return &snapshotResponse{Term: r.term}
+	}
+	if err := r.log.compact(req.Snapshot); err != nil {
+		log.Printf("raft: installing snapshot: %v", err)
+		return &snapshotResponse{Term: r.term}
+	}
+	r.sm.Replace(data)
+	r.commitIndex = max(r.commitIndex, req.Snapshot.Index)
+	r.lastApplied = req.Snapshot.Index
+	r.reloadMembers()
+	r.cond.Broadcast()
+	log.Printf("raft: %s installed a snapshot at index %d", r.cfg.ID, req.Snapshot.Index)
+	return &snapshotResponse{Term: r.term}
+}
+
+// Callers hold r.mu.
+func (r *Raft) reloadMembers() {
+	for i := r.log.lastIndex(); i >= r.log.firstIndex(); i-- {
+		if e := r.log.entry(i); isConfig(e.Command) {
+			r.members, r.configIndex = parseConfig(e.Command), i
+			return
+		}
+	}
+	r.members, r.configIndex = slices.Clone(r.log.snap.Members), r.log.snap.Index
+}
+
+func (r *Raft) applier() {
+	defer r.wg.Done()
+	for {
+		r.applyMu.Lock()
+		r.mu.Lock()
+		for r.lastApplied >= r.commitIndex && !r.closed() {
+			// Let an installed snapshot in while there is nothing to do.
+			r.applyMu.Unlock()
+			r.cond.Wait()
+			r.mu.Unlock()
+			r.applyMu.Lock()
+			r.mu.Lock()
+		}
+		if r.closed() {
+			r.mu.Unlock()
+			r.applyMu.Unlock()
+			return
+		}
+		entries := r.log.slice(r.lastApplied+1, r.commitIndex)
+		r.mu.Unlock()
+
+		results := make([]resp.Value, len(entries))
+		for i, e := range entries {
+			results[i] = r.apply(e)
+		}
+
+		r.mu.Lock()
+		for i, e := range entries {
+			r.lastApplied = e.Index
+			if w := r.waiters[e.Index]; w != nil {
+				if w.term == e.Term {
+					w.result = results[i]
+				} else {
+					w.err = ErrLost
+				}
+				w.done = true
+			}
+		}
+		r.cond.Broadcast()
+		snap := r.lastApplied-r.log.snap.Index >= uint64(r.cfg.SnapshotEntries)
+		r.mu.Unlock()
+		if snap {
+			r.snapshot()
+		}
+		r.applyMu.Unlock()
+	}
+}
+
+func (r *Raft) apply(e Entry) resp.Value {
+	cmd := e.Command
+	if len(cmd.Items) == 0 {
+		return resp.Value{Type: resp.Error, Text: "ERR empty command"}
+	}
+	name := strings.ToUpper(cmd.Items[0].Text)
+	if name == cmdNoop || name == cmdConfig {
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	}
+	return r.sm.Apply(name, cmd.Items[1:])
+}
+
+// Callers hold r.applyMu.
+func (r *Raft) snapshot() {
+	r.mu.Lock()
+	index := r.lastApplied
+	term, _ := r.log.term(index)
+	members := r.membersAt(index)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := persistence.EncodeSnapshot(&buf, r.sm.Snapshot()); err != nil {
+		log.Printf("raft: encoding snapshot: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if index <= r.log.snap.Index {
+		return
+	}
+	if err := r.log.compact(snapshot{Index: index, Term: term, Members: members, Data: buf.Bytes()}); err != nil {
+		log.Printf("raft: saving snapshot: %v", err)
+		return
+	}
+	log.Printf("raft: %s saved a snapshot at index %d", r.cfg.ID, index)
+}
+
+// Callers hold r.mu.
+func (r *Raft) membersAt(index uint64) []Member {
+	for i := index; i >= r.log.firstIndex(); i-- {
+		if e := r.log.entry(i); isConfig(e.Command) {
+			return parseConfig(e.Command)
+		}
+	}
+	return slices.Clone(r.log.snap.Members)
+}
+
+// Snapshot compacts the log now instead of waiting for SnapshotEntries.
+func (r *Raft) Snapshot() {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	r.snapshot()
+}
+
+// Callers hold r.mu.
+func (r *Raft) wait(timeout time.Duration, cond func() bool) error {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		expired = true
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	})
+	defer timer.Stop()
+	for !cond() {
+		switch {
+		case r.closed():
+			return ErrClosed
+		case expired:
+			return ErrTimeout
+		}
+		r.cond.Wait()
+	}
+	return nil
+}
+
+func (r *Raft) commitTimeout() time.Duration { return 5 * r.cfg.ElectionTimeout }
+
+// Propose appends a write command and returns the state machine's reply once
+// a majority stored it and it was applied here.
+func (r *Raft) Propose(cmd resp.Value) (resp.Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != Leader {
+		return resp.Value{}, r.notLeader()
+	}
+	e, err := r.appendLocal(cmd)
+	if err != nil {
+		return resp.Value{}, err
+	}
+	return r.await(e)
+}
+
+// Callers hold r.mu.
+func (r *Raft) await(e Entry) (resp.Value, error) {
+	w := &waiter{term: e.Term}
+	r.waiters[e.Index] = w
+	defer delete(r.waiters, e.Index)
+	r.triggerAll()
+	err := r.wait(r.commitTimeout(), func() bool {
+		return w.done || r.term != e.Term
+	})
+	switch {
+	case err != nil:
+		return resp.Value{}, err
+	case !w.done:
+		return resp.Value{}, ErrLost
+	}
+	return w.result, w.err
+}
+
+// ReadIndex returns once this node reflects every write committed before
+// the call, after confirming that it still leads.
+func (r *Raft) ReadIndex() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != Leader {
+		return r.notLeader()
+	}
+	term := r.term
+	stillLeader := func() bool { return r.role == Leader && r.term == term }
+
+	// Until an entry of this term commits, the commit index may lag behind
+	// what earlier leaders committed.
+	if err := r.wait(r.commitTimeout(), func() bool {
+		t, _ := r.log.term(r.commitIndex)
+		return !stillLeader() || t == term
+	}); err != nil {
+		return err
+	}
+	readIndex := r.commitIndex
+	since := r.seq
+	r.triggerAll()
+	if err := r.wait(r.commitTimeout(), func() bool {
+		return !stillLeader() || r.confirmed(since)
+	}); err != nil {
+		return err
+	}
+	if !stillLeader() {
+		return r.notLeader()
+	}
+	return r.wait(r.commitTimeout(), func() bool { return r.lastApplied >= readIndex })
+}
+
+// Callers hold r.mu.
+func (r *Raft) confirmed(since uint64) bool {
+	acks := 0
+	for _, m := range r.members {
+		if m.ID == r.cfg.ID {
+			acks++
+		} else if rep := r.replicators[m.ID]; rep != nil && rep.acked > since {
+			acks++
+		}
+	}
+	return acks >= r.quorum()
+}
+
+// AddMember adds m to the group. Only one membership change may be pending
+// at a time, which keeps old and new majorities overlapping.
+func (r *Raft) AddMember(m Member) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.member(m.ID); ok {
+		return ErrMemberExists
+	}
+	return r.changeMembers(append(slices.Clone(r.members), m))
+}
+
+func (r *Raft) RemoveMember(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.member(id); !ok {
+		return ErrNotMember
+	}
+	members := slices.DeleteFunc(slices.Clone(r.members), func(m Member) bool { return m.ID == id })
+	return r.changeMembers(members)
+}
+
+// Callers hold r.mu.
+func (r *Raft) changeMembers(members []Member) error {
+	if r.role != Leader {
+		return r.notLeader()
+	}
+	if r.configIndex > r.commitIndex {
+		return ErrConfigChange
+	}
+	e, err := r.appendLocal(configCommand(members))
+	if err != nil {
+		return err
+	}
+	_, err = r.await(e)
+	return err
+}
+
+func isConfig(cmd resp.Value) bool {
+	return len(cmd.Items) > 0 && strings.EqualFold(cmd.Items[0].Text, cmdConfig)
+}
+
+func configCommand(members []Member) resp.Value {
+	items := []resp.Value{{Type: resp.BulkString, Text: cmdConfig}}
+	for _, m := range members {
+		items = append(items,
+			resp.Value{Type: resp.BulkString, Text: m.ID},
+			resp.Value{Type: resp.BulkString, Text: m.Addr},
+			resp.Value{Type: resp.BulkString, Text: m.ClientAddr})
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func parseConfig(cmd resp.Value) []Member {
+	var members []Member
+	for i := 1; i+2 < len(cmd.Items); i += 3 {
+		members = append(members, Member{ID: cmd.Items[i].Text, Addr: cmd.Items[i+1].Text, ClientAddr: cmd.Items[i+2].Text})
+	}
+	return members
+}
@@ -0,0 +1,383 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// kv is a state machine understanding SET, DEL and INCR.
+type kv struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newKV() *kv { return &kv{data: map[string]string{}} }
+
+func (s *kv) Apply(cmd string, args []resp.Value) resp.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch cmd {
+	case "SET":
+		s.data[args[0].Text] = args[1].Text
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	case "DEL":
+		_, ok := s.data[args[0].Text]
+		delete(s.data, args[0].Text)
+		if ok {
+			return resp.Value{Type: resp.Integer, Number: 1}
+		}
+		return resp.Value{Type: resp.Integer, Number: 0}
+	case "INCR":
+		n, _ := strconv.ParseInt(s.data[args[0].Text], 10, 64)
+		n++
+		s.data[args[0].Text] = strconv.FormatInt(n, 10)
+		return resp.Value{Type: resp.Integer, Number: n}
+	}
+	return resp.Value{Type: resp.Error, Text: "ERR unknown command"}
+}
+
+func (s *kv) Snapshot() persistence.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := map[string]datastructure.Item{}
+	for k, v := range s.data {
+		items[k] = datastructure.Item{Value: v}
+	}
+	return persistence.Snapshot{DictData: items}
+}
+
+func (s *kv) Replace(snapshot *persistence.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = map[string]string{}
+	for k, item := range snapshot.DictData {
+		s.data[k] = item.Value
+	}
+}
+
+func (s *kv) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[key]
+}
+
+func command(args ...string) resp.Value {
+	v := resp.Value{Type: resp.Array}
+	for _, a := range args {
+		v.Items = append(v.Items, resp.Value{Type: resp.BulkString, Text: a})
+	}
+	return v
+}
+
+type node struct {
+	*Raft
+	sm  *kv
+	cfg Config
+}
+
+type group struct {
+	t     *testing.T
+	nodes []*node
+}
+
+func testConfig(t *testing.T, id string, peers []Member, dir string) Config {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return Config{
+		ID:              id,
+		Addr:            ln.Addr().String(),
+		ClientAddr:      "client-" + id,
+		Peers:           peers,
+		Dir:             dir,
+		ElectionTimeout: 150 * time.Millisecond,
+		Listener:        ln,
+	}
+}
+
+func (g *group) start(cfg Config) *node {
+	g.t.Helper()
+	sm := newKV()
+	r, err := New(cfg, sm)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	if err := r.Start(); err != nil {
+		g.t.Fatal(err)
+	}
+	n := &node{Raft: r, sm: sm, cfg: cfg}
+	g.t.Cleanup(r.Close)
+	return n
+}
+
+func newGroup(t *testing.T, size int, persist bool) *group {
+	t.Helper()
+	g := &group{t: t}
+	var cfgs []Config
+	var peers []Member
+	for i := range size {
+		id := fmt.Sprintf("n%d", i+1)
+		dir := ""
+		if persist {
+			dir = t.TempDir()
+		}
+		cfg := testConfig(t, id, nil, dir)
+		cfgs = append(cfgs, cfg)
+		peers = append(peers, Member{ID: id, Addr: cfg.Addr, ClientAddr: cfg.ClientAddr})
+	}
+	for _, cfg := range cfgs {
+		cfg.Peers = peers
+		g.nodes = append(g.nodes, g.start(cfg))
+	}
+	return g
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// leader waits for exactly one running node to lead.
+func (g *group) leader() *node {
+	g.t.Helper()
+	var found *node
+	waitFor(g.t, "a leader", func() bool {
+		found = nil
+		for _, n := range g.nodes {
+			if n.closed() || !n.IsLeader() {
+				continue
+			}
+			if found != nil {
+				return false
+			}
+			found = n
+		}
+		return found != nil
+	})
+	return found
+}
+
+func (g *group) propose(args ...string) resp.Value {
+	g.t.Helper()
+	for attempt := 0; ; attempt++ {
+		result, err := g.leader().Propose(command(args...))
+		if err == nil {
+			return result
+		}
+		if attempt == 20 {
+			g.t.Fatalf("propose %v: %v", args, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (g *group) waitValue(n *node, key, want string) {
+	g.t.Helper()
+	waitFor(g.t, fmt.Sprintf("%s to see %s=%s", n.cfg.ID, key, want), func() bool { return n.sm.get(key) == want })
+}
+
+func TestElection(t *testing.T) {
+	g := newGroup(t, 3, false)
+	leader := g.leader()
+	for _, n := range g.nodes {
+		waitFor(t, "followers to learn the leader", func() bool { return n.Status().Leader == leader.cfg.ID })
+	}
+
+	var notLeader *NotLeaderError
+	for _, n := range g.nodes {
+		if n == leader {
+			continue
+		}
+		_, err := n.Propose(command("SET", "k", "v"))
+		if !errors.As(err, &notLeader) || err.Error() != "NOTLEADER client-"+leader.cfg.ID {
+			t.Fatalf("propose on follower: got %v", err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	g := newGroup(t, 3, false)
+	if result := g.propose("SET", "k", "v"); result.Text != "OK" {
+		t.Fatalf("SET: got %v", result)
+	}
+	for range 9 {
+		g.propose("INCR", "counter")
+	}
+	if result := g.propose("INCR", "counter"); result.Number != 10 {
+		t.Fatalf("INCR: got %v", result)
+	}
+	for _, n := range g.nodes {
+		g.waitValue(n, "k", "v")
+		g.waitValue(n, "counter", "10")
+	}
+}
+
+func TestLeaderFailure(t *testing.T) {
+	g := newGroup(t, 3, false)
+	for i := range 5 {
+		g.propose("SET", "k"+strconv.Itoa(i), "v")
+	}
+	old := g.leader()
+	old.Close()
+
+	// The writes the old leader acknowledged survive it.
+	leader := g.leader()
+	if leader == old {
+		t.Fatal("closed node still leads")
+	}
+	for i := range 5 {
+		g.waitValue(leader, "k"+strconv.Itoa(i), "v")
+	}
+	g.propose("SET", "after", "failover")
+	for _, n := range g.nodes {
+		if n != old {
+			g.waitValue(n, "after", "failover")
+		}
+	}
+
+	// Without a majority nothing commits.
+	for _, n := range g.nodes {
+		if n != leader && n != old {
+			n.Close()
+		}
+	}
+	if _, err := leader.Propose(command("SET", "lost", "v")); err == nil {
+		t.Fatal("write without a quorum succeeded")
+	}
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	g := &group{t: t}
+	var peers []Member
+	var cfgs []Config
+	for _, id := range []string{"n1", "n2", "n3"} {
+		cfg := testConfig(t, id, nil, t.TempDir())
+		cfg.SnapshotEntries = 10
+		cfgs = append(cfgs, cfg)
+		peers = append(peers, Member{ID: id, Addr: cfg.Addr, ClientAddr: cfg.ClientAddr})
+	}
+	for _, cfg := range cfgs[:2] {
+		cfg.Peers = peers
+		g.nodes = append(g.nodes, g.start(cfg))
+	}
+	for i := range 50 {
+		g.propose("SET", "k"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	leader := g.leader()
+	waitFor(t, "a snapshot", func() bool { return leader.Status().SnapshotAt > 0 })
+
+	// The third node starts after the log it missed was compacted.
+	cfg := cfgs[2]
+	cfg.Peers = peers
+	late := g.start(cfg)
+	g.waitValue(late, "k49", "49")
+	g.waitValue(late, "k0", "0")
+	if late.Status().SnapshotAt == 0 {
+		t.Fatal("late node should have installed a snapshot")
+	}
+}
+
+func TestMembership(t *testing.T) {
+	g := newGroup(t, 3, false)
+	g.propose("SET", "k", "v")
+
+	cfg := testConfig(t, "n4", nil, "")
+	joined := g.start(cfg)
+	g.nodes = append(g.nodes, joined)
+	leader := g.leader()
+	if err := leader.AddMember(Member{ID: "n4", Addr: cfg.Addr, ClientAddr: cfg.ClientAddr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.AddMember(Member{ID: "n4", Addr: cfg.Addr}); !errors.Is(err, ErrMemberExists) {
+		t.Fatalf("adding twice: got %v", err)
+	}
+	g.waitValue(joined, "k", "v")
+	if got := len(joined.Status().Members); got != 4 {
+		t.Fatalf("joined node sees %d members, want 4", got)
+	}
+
+	// Removing the leader hands the group to the others.
+	if err := leader.RemoveMember(leader.cfg.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the removed leader to step down", func() bool { return !leader.IsLeader() })
+	leader.Close()
+	g.propose("SET", "k", "after")
+	g.waitValue(joined, "k", "after")
+	if got := len(g.leader().Status().Members); got != 3 {
+		t.Fatalf("leader sees %d members, want 3", got)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	g := newGroup(t, 3, false)
+	g.propose("SET", "k", "v")
+	leader := g.leader()
+	if err := leader.ReadIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if got := leader.sm.get("k"); got != "v" {
+		t.Fatalf("read after ReadIndex = %q, want v", got)
+	}
+	for _, n := range g.nodes {
+		if n != leader {
+			if err := n.ReadIndex(); err == nil {
+				t.Fatal("ReadIndex on a follower succeeded")
+			}
+		}
+	}
+
+	// A leader cut off from its followers cannot confirm it still leads.
+	for _, n := range g.nodes {
+		if n != leader {
+			n.Close()
+		}
+	}
+	if err := leader.ReadIndex(); err == nil {
+		t.Fatal("ReadIndex without a quorum succeeded")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	g := newGroup(t, 3, true)
+	for range 5 {
+		g.propose("INCR", "counter")
+	}
+	for _, n := range g.nodes {
+		g.waitValue(n, "counter", "5")
+	}
+	for _, n := range g.nodes {
+		n.Close()
+	}
+
+	restarted := &group{t: t}
+	for _, n := range g.nodes {
+		cfg := n.cfg
+		cfg.Listener = nil
+		restarted.nodes = append(restarted.nodes, restarted.start(cfg))
+	}
+	if result := restarted.propose("INCR", "counter"); result.Number != 6 {
+		t.Fatalf("INCR after restart: got %v", result)
+	}
+	for _, n := range restarted.nodes {
+		restarted.waitValue(n, "counter", "6")
+	}
+}
@@ -0,0 +1,183 @@
+package raft
+
+import (
+	"encoding/gob"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type voteRequest struct {
+	Term      uint64
+	Candidate string
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+type voteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+type appendRequest struct {
+	Term      uint64
+	Leader    string
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []Entry
+	Commit    uint64
+}
+
+type appendResponse struct {
+	Term    uint64
+	Success bool
+	// ConflictIndex is where the leader should retry from after a mismatch.
+	ConflictIndex uint64
+}
+
+type snapshotRequest struct {
+	Term     uint64
+	Leader   string
+	Snapshot snapshot
+}
+
+type snapshotResponse struct {
+	Term uint64
+}
+
+type request struct {
+	Vote     *voteRequest
+	Append   *appendRequest
+	Snapshot *snapshotRequest
+}
+
+type response struct {
+	Vote     *voteResponse
+	Append   *appendResponse
+	Snapshot *snapshotResponse
+}
+
+type conn struct {
+	mu  sync.Mutex
+	c   net.Conn
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func (r *Raft) peerConn(addr string) *conn {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	pc, ok := r.conns[addr]
+	if !ok {
+		pc = &conn{}
+		r.conns[addr] = pc
+	}
+	return pc
+}
+
+func (r *Raft) call(addr string, req *request) (*response, error) {
+	pc := r.peerConn(addr)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if r.closed() {
+		return nil, net.ErrClosed
+	}
+	timeout := r.cfg.ElectionTimeout
+	if pc.c == nil {
+		c, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		r.connMu.Lock()
+		if r.closed() {
+			r.connMu.Unlock()
+			_ = c.Close()
+			return nil, net.ErrClosed
+		}
+		pc.c = c
+		r.connMu.Unlock()
+		pc.enc, pc.dec = gob.NewEncoder(c), gob.NewDecoder(c)
+	}
+	// A snapshot can be large, so it gets more time than a heartbeat.
+	if req.Snapshot != nil {
+		timeout *= 10
+	}
+	_ = pc.c.SetDeadline(time.Now().Add(timeout))
+	var resp response
+	err := pc.enc.Encode(req)
+	if err == nil {
+		err = pc.dec.Decode(&resp)
+	}
+	if err != nil {
+		_ = pc.c.Close()
+		r.connMu.Lock()
+		pc.c = nil
+		r.connMu.Unlock()
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (r *Raft) closeConns() {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	for _, pc := range r.conns {
+		// Closing the socket unblocks a call in progress, which then
+		// clears pc.c itself.
+		if pc.c != nil {
+			_ = pc.c.Close()
+		}
+	}
+}
+
+func (r *Raft) acceptLoop() {
+	defer r.wg.Done()
+	for {
+		c, err := r.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		r.wg.Add(1)
+		go r.serve(c)
+	}
+}
+
+func (r *Raft) serve(c net.Conn) {
+	defer r.wg.Done()
+	defer c.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.stop:
+			_ = c.Close()
+		case <-done:
+		}
+	}()
+
+	enc, dec := gob.NewEncoder(c), gob.NewDecoder(c)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		var resp response
+		switch {
+		case req.Vote != nil:
+			resp.Vote = r.handleVote(req.Vote)
+		case req.Append != nil:
+			resp.Append = r.handleAppend(req.Append)
+		case req.Snapshot != nil:
+			resp.Snapshot = r.handleSnapshot(req.Snapshot)
+		default:
+			return
+		}
+		if err := enc.Encode(&resp); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package replication
+
+// Backlog is a fixed-size ring of the most recent bytes of the replication
+// stream. It is not safe for concurrent use.
+type Backlog struct {
+	buf   []byte
+	start int64
+	end   int64
+}
+
+func NewBacklog(size int) *Backlog {
+	return &Backlog{buf: make([]byte, size)}
+}
+
+// Reset empties the backlog and positions it at offset.
+func (b *Backlog) Reset(offset int64) {
+	b.start = offset
+	b.end = offset
+}
+
+func (b *Backlog) Write(p []byte) {
+	size := int64(len(b.buf))
+	if int64(len(p)) > size {
+		b.end += int64(len(p)) - size
+		p = p[int64(len(p))-size:]
+	}
+	for len(p) > 0 {
+		pos := b.end % size
+		n := copy(b.buf[pos:], p)
+		p = p[n:]
+		b.end += int64(n)
+	}
+	if b.end-b.start > size {
+		b.start = b.end - size
+	}
+}
+
+// ReadFrom returns the bytes from offset to the end of the stream, or false
+// when offset is no longer (or not yet) held.
+func (b *Backlog) ReadFrom(offset int64) ([]byte, bool) {
+	if offset < b.start || offset > b.end {
+		return nil, false
+	}
+	size := int64(len(b.buf))
+	out := make([]byte, 0, b.end-offset)
+	for offset < b.end {
+		pos := offset % size
+		chunk := min(size-pos, b.end-offset)
+		out = append(out, b.buf[pos:pos+chunk]...)
+		offset += chunk
+	}
+	return out, true
+}
+
+// First returns the offset of the oldest byte held.
+func (b *Backlog) First() int64 {
+	return b.start
+}
+
+func (b *Backlog) Len() int64 {
+	return b.end - b.start
+}
+
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
@@ -0,0 +1,46 @@
+package replication
+
+import "testing"
+
+func TestBacklogReadFrom(t *testing.T) {
+	b := NewBacklog(8)
+	b.Reset(100)
+	b.Write([]byte("abcde"))
+
+	if data, ok := b.ReadFrom(102); !ok || string(data) != "cde" {
+		t.Errorf("Expected cde, got %q %v", data, ok)
+	}
+	if data, ok := b.ReadFrom(105); !ok || len(data) != 0 {
+		t.Errorf("Expected empty tail at end offset, got %q %v", data, ok)
+	}
+	if _, ok := b.ReadFrom(99); ok {
+		t.Error("Expected offset before the backlog to be rejected")
+	}
+	if _, ok := b.ReadFrom(106); ok {
+		t.Error("Expected offset past the stream to be rejected")
+	}
+}
+
+func TestBacklogWrapAround(t *testing.T) {
+	b := NewBacklog(8)
+	b.Write([]byte("abcdef"))
+	b.Write([]byte("ghij"))
+
+	if b.First() != 2 || b.Len() != 8 {
+		t.Errorf("Expected window [2,10), got first=%d len=%d", b.First(), b.Len())
+	}
+	if _, ok := b.ReadFrom(1); ok {
+		t.Error("Expected overwritten offset to be rejected")
+	}
+	if data, _ := b.ReadFrom(2); string(data) != "cdefghij" {
+		t.Errorf("Expected cdefghij, got %q", data)
+	}
+
+	b.Write([]byte("0123456789XY"))
+	if data, _ := b.ReadFrom(b.First()); string(data) != "456789XY" {
+		t.Errorf("Expected only the newest bytes to survive, got %q", data)
+	}
+	if b.First() != 14 {
+		t.Errorf("Expected first offset 14, got %d", b.First())
+	}
+}
@@ -0,0 +1,447 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	DefaultBacklogSize = 1 << 20
+	DefaultTimeout     = 60 * time.Second
+
+	pingPeriod    = 10 * time.Second
+	ackPeriod     = time.Second
+	replicaBuffer = 4096
+)
+
+// Dataset is the part of the database replication drives: taking and
+// installing snapshots and applying the master's write stream.
+type Dataset interface {
+	Snapshot() persistence.Snapshot
+	Replace(snapshot *persistence.Snapshot)
+	Replay(cmd string, args []resp.Value)
+	RunWrite(fn func())
+	PauseWrites(fn func())
+}
+
+type Options struct {
+	BacklogSize int
+	// ReadOnly rejects client writes while this node is a replica.
+	ReadOnly bool
+	// AOFEnabled makes a replica report its applied offset as fsynced, since
+	// every applied write is synced to its AOF before the offset advances.
+	AOFEnabled bool
+	MasterAuth string
+	ListenPort string
+	Timeout    time.Duration
+	// OnFullSync runs after a replica installs a snapshot from its master,
+	// so local persistence can be rebuilt.
+	OnFullSync func()
+}
+
+// Manager tracks the replication state of this node.
+type Manager struct {
+	mu       sync.Mutex
+	db       Dataset
+	opts     Options
+	replID   string
+	replID2  string
+	offset2  int64
+	offset   int64
+	backlog  *Backlog
+	replicas map[*replica]struct{}
+	link     *link
+	acked    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewManager(db Dataset, opts Options) *Manager {
+	if opts.BacklogSize <= 0 {
+		opts.BacklogSize = DefaultBacklogSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	m := &Manager{
+		db:       db,
+		opts:     opts,
+		replID:   newReplID(),
+		offset2:  -1,
+		backlog:  NewBacklog(opts.BacklogSize),
+		replicas: make(map[*replica]struct{}),
+		acked:    make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	go m.pingLoop()
+	return m
+}
+
+func newReplID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Feed propagates a write command executed on this node. Replicas ignore it
+// because they forward their master's stream verbatim instead.
+func (m *Manager) Feed(v resp.Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.link != nil {
+		return
+	}
+	m.propagate([]byte(resp.Encode(v)))
+}
+
+func (m *Manager) propagate(data []byte) {
+	m.backlog.Write(data)
+	m.offset += int64(len(data))
+	for r := range m.replicas {
+		r.send(data)
+	}
+}
+
+// Offset returns the current replication stream offset.
+func (m *Manager) Offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.offset
+}
+
+func (m *Manager) IsReplica() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.link != nil
+}
+
+// WaitReplicas blocks until n replicas have acknowledged offset, or until
+// timeout passes; a zero timeout waits forever.
+func (m *Manager) WaitReplicas(offset int64, n int, fsynced bool, timeout time.Duration) int {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	asked := false
+	for {
+		m.mu.Lock()
+		count := m.countAcked(offset, fsynced)
+		if count >= n {
+			m.mu.Unlock()
+			return count
+		}
+		if !asked {
+			m.propagate(encodeCommand("REPLCONF", "GETACK", "*"))
+			asked = true
+		}
+		ch := m.acked
+		m.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-expired:
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return m.countAcked(offset, fsynced)
+		}
+	}
+}
+
+func (m *Manager) countAcked(offset int64, fsynced bool) int {
+	count := 0
+	for r := range m.replicas {
+		acked := r.ackOffset.Load()
+		if fsynced {
+			acked = r.fackOffset.Load()
+		}
+		if acked >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// ReadOnly reports whether client writes must be rejected.
+func (m *Manager) ReadOnly() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.link != nil && m.opts.ReadOnly
+}
+
+func (m *Manager) pingLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			if m.link == nil && len(m.replicas) > 0 {
+				m.propagate(encodeCommand("PING"))
+			}
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close drops the master link and every attached replica.
+func (m *Manager) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.link != nil {
+		m.link.close()
+		m.link = nil
+	}
+	m.dropReplicas()
+}
+
+func (m *Manager) dropReplicas() {
+	for r := range m.replicas {
+		r.close()
+		delete(m.replicas, r)
+	}
+}
+
+type replica struct {
+	conn       net.Conn
+	port       string
+	ch         chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+	ackOffset  atomic.Int64
+	fackOffset atomic.Int64
+	ackAt      atomic.Int64
+}
+
+func (r *replica) send(data []byte) {
+	select {
+	case r.ch <- data:
+	default:
+		// A replica that cannot keep up is dropped; it reconnects and
+		// resyncs from the backlog.
+		r.close()
+	}
+}
+
+func (r *replica) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		_ = r.conn.Close()
+	})
+}
+
+func (r *replica) writeLoop() {
+	for {
+		select {
+		case data := <-r.ch:
+			if _, err := r.conn.Write(data); err != nil {
+				r.close()
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// ServeReplica answers PSYNC on conn and streams writes until the replica
+// disconnects.
+func (m *Manager) ServeReplica(conn net.Conn, reader *bufio.Reader, args []string, port string) {
+	_ = conn.SetDeadline(time.Time{})
+	r := &replica{
+		conn: conn,
+		port: port,
+		ch:   make(chan []byte, replicaBuffer),
+		done: make(chan struct{}),
+	}
+	r.ackAt.Store(time.Now().UnixNano())
+	r.fackOffset.Store(-1)
+
+	replID, offset := "?", int64(-1)
+	if len(args) == 2 {
+		replID = args[0]
+		if n, err := strconv.ParseInt(args[1], 10, 64); err == nil {
+			offset = n
+		}
+	}
+
+	header, ok := m.continueReplica(r, replID, offset)
+	if !ok {
+		var err error
+		if header, err = m.fullResync(r); err != nil {
+			log.Printf("replication: full resync for %s failed: %v", conn.RemoteAddr(), err)
+			r.close()
+			return
+		}
+	}
+	defer m.detach(r)
+
+	if _, err := conn.Write(header); err != nil {
+		return
+	}
+	go r.writeLoop()
+
+	for {
+		v, err := resp.Decode(reader)
+		if err != nil {
+			return
+		}
+		if v.Type == resp.Array && len(v.Items) >= 3 && strings.EqualFold(v.Items[0].Text, "REPLCONF") && strings.EqualFold(v.Items[1].Text, "ACK") {
+			m.handleAck(r, v.Items[2:])
+		}
+	}
+}
+
+func (m *Manager) handleAck(r *replica, args []resp.Value) {
+	n, err := strconv.ParseInt(args[0].Text, 10, 64)
+	if err != nil {
+		return
+	}
+	r.ackOffset.Store(n)
+	r.ackAt.Store(time.Now().UnixNano())
+	if len(args) == 3 && strings.EqualFold(args[1].Text, "FACK") {
+		if f, err := strconv.ParseInt(args[2].Text, 10, 64); err == nil {
+			r.fackOffset.Store(f)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	close(m.acked)
+	m.acked = make(chan struct{})
+}
+
+func (m *Manager) continueReplica(r *replica, replID string, offset int64) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if replID != m.replID && (replID != m.replID2 || offset > m.offset2) {
+		return nil, false
+	}
+	data, ok := m.backlog.ReadFrom(offset)
+	if !ok {
+		return nil, false
+	}
+	m.replicas[r] = struct{}{}
+	r.ackOffset.Store(offset)
+	log.Printf("replication: partial resync of %s from offset %d", r.conn.RemoteAddr(), offset)
+	return append([]byte(fmt.Sprintf("+CONTINUE %s\r\n", m.replID)), data...), true
+}
+
+func (m *Manager) fullResync(r *replica) ([]byte, error) {
+	var (
+		snapshot persistence.Snapshot
+		replID   string
+		offset   int64
+	)
+	m.db.PauseWrites(func() {
+		snapshot = m.db.Snapshot()
+		m.mu.Lock()
+		replID, offset = m.replID, m.offset
+		m.replicas[r] = struct{}{}
+		m.mu.Unlock()
+	})
+	r.ackOffset.Store(offset)
+
+	var payload bytes.Buffer
+	if err := persistence.EncodeSnapshot(&payload, snapshot); err != nil {
+		m.detach(r)
+		return nil, err
+	}
+	log.Printf("replication: full resync of %s at offset %d (%d bytes)", r.conn.RemoteAddr(), offset, payload.Len())
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n", replID, offset)
+	return []byte(header + resp.Encode(resp.Value{Type: resp.BulkString, Text: payload.String()})), nil
+}
+
+func (m *Manager) detach(r *replica) {
+	r.close()
+	m.mu.Lock()
+	delete(m.replicas, r)
+	m.mu.Unlock()
+}
+
+type ReplicaStatus struct {
+	IP     string
+	Port   string
+	Offset int64
+	Lag    time.Duration
+}
+
+type Status struct {
+	Role           string
+	ReplID         string
+	ReplID2        string
+	Offset         int64
+	SecondOffset   int64
+	MasterHost     string
+	MasterPort     string
+	LinkUp         bool
+	SyncInProgress bool
+	LastIO         time.Duration
+	ReadOnly       bool
+	BacklogSize    int
+	BacklogFirst   int64
+	BacklogLen     int64
+	Replicas       []ReplicaStatus
+}
+
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := Status{
+		Role:         "master",
+		ReplID:       m.replID,
+		ReplID2:      m.replID2,
+		Offset:       m.offset,
+		SecondOffset: m.offset2,
+		BacklogSize:  m.backlog.Size(),
+		BacklogFirst: m.backlog.First(),
+		BacklogLen:   m.backlog.Len(),
+	}
+	if st.ReplID2 == "" {
+		st.ReplID2 = strings.Repeat("0", 40)
+	}
+	if l := m.link; l != nil {
+		st.Role = "slave"
+		st.MasterHost, st.MasterPort = l.host, l.port
+		st.LinkUp = l.state == linkConnected
+		st.SyncInProgress = l.state == linkSyncing
+		if !l.lastIO.IsZero() {
+			st.LastIO = time.Since(l.lastIO)
+		}
+		st.ReadOnly = m.opts.ReadOnly
+	}
+	now := time.Now()
+	for r := range m.replicas {
+		ip, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+		st.Replicas = append(st.Replicas, ReplicaStatus{
+			IP:     ip,
+			Port:   r.port,
+			Offset: r.ackOffset.Load(),
+			Lag:    now.Sub(time.Unix(0, r.ackAt.Load())),
+		})
+	}
+	sort.Slice(st.Replicas, func(i, j int) bool {
+		return st.Replicas[i].IP+":"+st.Replicas[i].Port < st.Replicas[j].IP+":"+st.Replicas[j].Port
+	})
+	return st
+}
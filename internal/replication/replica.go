@@ -0,0 +1,268 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	linkConnecting = "connect"
+	linkSyncing    = "sync"
+	linkConnected  = "connected"
+
+	reconnectDelay = time.Second
+)
+
+// link's fields are guarded by the Manager's mutex.
+type link struct {
+	host    string
+	port    string
+	state   string
+	lastIO  time.Time
+	conn    net.Conn
+	stopped bool
+}
+
+func (l *link) close() {
+	l.stopped = true
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
+
+// ReplicaOf makes this node a replica of host:port. Attached replicas are
+// dropped so they resync against the new history.
+func (m *Manager) ReplicaOf(host, port string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.link != nil {
+		if m.link.host == host && m.link.port == port {
+			return
+		}
+		m.link.close()
+	}
+	m.dropReplicas()
+	l := &link{host: host, port: port, state: linkConnecting}
+	m.link = l
+	go m.runLink(l)
+}
+
+// PromoteToMaster stops replicating. The old replication ID is kept as the
+// secondary one so replicas of the same master can still resync partially.
+func (m *Manager) PromoteToMaster() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.link == nil {
+		return
+	}
+	m.link.close()
+	m.link = nil
+	m.replID2, m.offset2 = m.replID, m.offset
+	m.replID = newReplID()
+	m.dropReplicas()
+}
+
+func (m *Manager) runLink(l *link) {
+	for {
+		err := m.syncWithMaster(l)
+		m.mu.Lock()
+		stopped := l.stopped
+		l.state = linkConnecting
+		m.mu.Unlock()
+		if stopped {
+			return
+		}
+		log.Printf("replication: link to %s:%s lost: %v", l.host, l.port, err)
+		time.Sleep(reconnectDelay)
+	}
+}
+
+func (m *Manager) syncWithMaster(l *link) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(l.host, l.port), m.opts.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m.mu.Lock()
+	if l.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	l.conn = conn
+	replID, offset := m.replID, m.offset
+	m.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	call := func(parts ...string) (resp.Value, error) {
+		if err := writeCommand(conn, parts...); err != nil {
+			return resp.Value{}, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(m.opts.Timeout))
+		v, err := resp.Decode(reader)
+		if err == nil && v.Type == resp.Error {
+			err = errors.New(v.Text)
+		}
+		return v, err
+	}
+
+	if m.opts.MasterAuth != "" {
+		if _, err := call("AUTH", m.opts.MasterAuth); err != nil {
+			return err
+		}
+	}
+	if _, err := call("PING"); err != nil {
+		return err
+	}
+	if m.opts.ListenPort != "" {
+		if _, err := call("REPLCONF", "listening-port", m.opts.ListenPort); err != nil {
+			return err
+		}
+	}
+
+	m.setLinkState(l, linkSyncing)
+	reply, err := call("PSYNC", replID, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply.Text)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		if err := m.loadFullSync(l, reader, fields[1], fields[2]); err != nil {
+			return err
+		}
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		m.mu.Lock()
+		if fields[1] != m.replID {
+			m.replID2, m.offset2 = m.replID, m.offset
+			m.replID = fields[1]
+		}
+		m.mu.Unlock()
+		log.Printf("replication: partial resync with %s:%s from offset %d", l.host, l.port, offset)
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %q", reply.Text)
+	}
+
+	m.setLinkState(l, linkConnected)
+	done := make(chan struct{})
+	defer close(done)
+	ackNow := make(chan struct{}, 1)
+	go m.ackLoop(conn, ackNow, done)
+	return m.stream(l, conn, reader, ackNow)
+}
+
+func (m *Manager) loadFullSync(l *link, reader *bufio.Reader, replID, offsetText string) error {
+	offset, err := strconv.ParseInt(offsetText, 10, 64)
+	if err != nil {
+		return err
+	}
+	payload, err := resp.Decode(reader)
+	if err != nil {
+		return err
+	}
+	snapshot, err := persistence.DecodeSnapshot(strings.NewReader(payload.Text))
+	if err != nil {
+		return err
+	}
+
+	m.db.PauseWrites(func() {
+		m.db.Replace(snapshot)
+		m.mu.Lock()
+		m.replID, m.replID2, m.offset2 = replID, "", -1
+		m.offset = offset
+		m.backlog.Reset(offset)
+		m.dropReplicas()
+		l.lastIO = time.Now()
+		m.mu.Unlock()
+	})
+	log.Printf("replication: full resync with %s:%s at offset %d (%d bytes)", l.host, l.port, offset, len(payload.Text))
+	if m.opts.OnFullSync != nil {
+		m.opts.OnFullSync()
+	}
+	return nil
+}
+
+func (m *Manager) stream(l *link, conn net.Conn, reader *bufio.Reader, ackNow chan struct{}) error {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(m.opts.Timeout))
+		v, err := resp.Decode(reader)
+		if err != nil {
+			return err
+		}
+		raw := []byte(resp.Encode(v))
+		name := ""
+		if v.Type == resp.Array && len(v.Items) > 0 {
+			name = strings.ToUpper(v.Items[0].Text)
+		}
+		m.db.RunWrite(func() {
+			if name != "" && name != "REPLCONF" {
+				m.db.Replay(name, v.Items[1:])
+			}
+			m.mu.Lock()
+			if m.link == l {
+				m.propagate(raw)
+				l.lastIO = time.Now()
+			}
+			m.mu.Unlock()
+		})
+		if name == "REPLCONF" && len(v.Items) > 1 && strings.EqualFold(v.Items[1].Text, "GETACK") {
+			select {
+			case ackNow <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (m *Manager) ackLoop(conn net.Conn, ackNow, done chan struct{}) {
+	ticker := time.NewTicker(ackPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ackNow:
+		case <-done:
+			return
+		}
+		m.mu.Lock()
+		offset := strconv.FormatInt(m.offset, 10)
+		m.mu.Unlock()
+		parts := []string{"REPLCONF", "ACK", offset}
+		if m.opts.AOFEnabled {
+			parts = append(parts, "FACK", offset)
+		}
+		if err := writeCommand(conn, parts...); err != nil {
+			return
+		}
+	}
+}
+
+func (m *Manager) setLinkState(l *link, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l.state = state
+}
+
+func writeCommand(conn net.Conn, parts ...string) error {
+	_, err := conn.Write(encodeCommand(parts...))
+	return err
+}
+
+func encodeCommand(parts ...string) []byte {
+	items := make([]resp.Value, len(parts))
+	for i, p := range parts {
+		items[i] = resp.Value{Type: resp.BulkString, Text: p}
+	}
+	return []byte(resp.Encode(resp.Value{Type: resp.Array, Items: items}))
+}
@@ -0,0 +1,258 @@
+package replication
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/persistence"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// memDB is a minimal Dataset holding string keys, enough to drive the
+// replication protocol without the command registry.
+type memDB struct {
+	mu       sync.RWMutex
+	writeMu  sync.RWMutex
+	items    map[string]string
+	replaces int
+	repl     *Manager
+}
+
+func newMemDB() *memDB {
+	return &memDB{items: make(map[string]string)}
+}
+
+func (d *memDB) set(key, value string) {
+	d.RunWrite(func() {
+		d.Replay("SET", []resp.Value{{Type: resp.BulkString, Text: key}, {Type: resp.BulkString, Text: value}})
+		d.repl.Feed(resp.Value{Type: resp.Array, Items: []resp.Value{
+			{Type: resp.BulkString, Text: "SET"},
+			{Type: resp.BulkString, Text: key},
+			{Type: resp.BulkString, Text: value},
+		}})
+	})
+}
+
+func (d *memDB) get(key string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	v, ok := d.items[key]
+	return v, ok
+}
+
+func (d *memDB) fullSyncs() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.replaces
+}
+
+func (d *memDB) Snapshot() persistence.Snapshot {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	dict := make(map[string]datastructure.Item, len(d.items))
+	for k, v := range d.items {
+		dict[k] = datastructure.Item{Value: v}
+	}
+	return persistence.Snapshot{DictData: dict}
+}
+
+func (d *memDB) Replace(snapshot *persistence.Snapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items = make(map[string]string, len(snapshot.DictData))
+	for k, item := range snapshot.DictData {
+		d.items[k] = item.Value
+	}
+	d.replaces++
+}
+
+func (d *memDB) Replay(cmd string, args []resp.Value) {
+	if cmd != "SET" || len(args) != 2 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items[args[0].Text] = args[1].Text
+}
+
+func (d *memDB) RunWrite(fn func()) {
+	d.writeMu.RLock()
+	defer d.writeMu.RUnlock()
+	fn()
+}
+
+func (d *memDB) PauseWrites(fn func()) {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	fn()
+}
+
+// serveMaster answers the replica handshake the way the server does and
+// hands PSYNC connections to m.
+func serveMaster(t *testing.T, m *Manager) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				port := ""
+				for {
+					v, err := resp.Decode(reader)
+					if err != nil || len(v.Items) == 0 {
+						return
+					}
+					switch strings.ToUpper(v.Items[0].Text) {
+					case "PSYNC":
+						m.ServeReplica(conn, reader, []string{v.Items[1].Text, v.Items[2].Text}, port)
+						return
+					case "REPLCONF":
+						port = v.Items[2].Text
+						conn.Write([]byte("+OK\r\n"))
+					default:
+						conn.Write([]byte("+PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFullAndPartialResync(t *testing.T) {
+	masterDB := newMemDB()
+	master := NewManager(masterDB, Options{})
+	masterDB.repl = master
+	defer master.Close()
+	port := serveMaster(t, master)
+
+	masterDB.set("before", "1")
+
+	replicaDB := newMemDB()
+	replica := NewManager(replicaDB, Options{ReadOnly: true, ListenPort: "7001"})
+	replicaDB.repl = replica
+	defer replica.Close()
+	replica.ReplicaOf("127.0.0.1", port)
+
+	waitFor(t, "full sync", func() bool { _, ok := replicaDB.get("before"); return ok })
+	masterDB.set("after", "2")
+	waitFor(t, "streamed write", func() bool { _, ok := replicaDB.get("after"); return ok })
+
+	if !replica.ReadOnly() || master.ReadOnly() {
+		t.Error("Expected only the replica to be read-only")
+	}
+	waitFor(t, "replica ack", func() bool {
+		st := master.Status()
+		return len(st.Replicas) == 1 && st.Replicas[0].Offset == st.Offset
+	})
+	if st := master.Status(); st.Replicas[0].Port != "7001" {
+		t.Errorf("Expected announced port 7001, got %+v", st.Replicas[0])
+	}
+	if st := replica.Status(); st.Role != "slave" || !st.LinkUp || st.ReplID != master.Status().ReplID {
+		t.Errorf("Unexpected replica status %+v", st)
+	}
+
+	// Drop the link; writes made meanwhile must arrive from the backlog
+	// without another full sync.
+	replica.mu.Lock()
+	replica.link.conn.Close()
+	replica.mu.Unlock()
+	masterDB.set("during", "3")
+	waitFor(t, "partial resync", func() bool { _, ok := replicaDB.get("during"); return ok })
+	if replicaDB.fullSyncs() != 1 {
+		t.Errorf("Expected a single full sync, got %d", replicaDB.fullSyncs())
+	}
+	if replica.Status().Offset != master.Status().Offset {
+		t.Error("Expected offsets to match after partial resync")
+	}
+}
+
+func TestPromoteKeepsHistory(t *testing.T) {
+	masterDB := newMemDB()
+	master := NewManager(masterDB, Options{})
+	masterDB.repl = master
+	defer master.Close()
+	port := serveMaster(t, master)
+	masterDB.set("k", "v")
+
+	replicaDB := newMemDB()
+	replica := NewManager(replicaDB, Options{ReadOnly: true})
+	replicaDB.repl = replica
+	defer replica.Close()
+	replica.ReplicaOf("127.0.0.1", port)
+	waitFor(t, "sync", func() bool { _, ok := replicaDB.get("k"); return ok })
+
+	oldID := master.Status().ReplID
+	replica.PromoteToMaster()
+	st := replica.Status()
+	if st.Role != "master" || st.ReplID == oldID || st.ReplID2 != oldID || replica.ReadOnly() {
+		t.Errorf("Unexpected status after promotion %+v", st)
+	}
+
+	// The old master can follow the promoted replica with a partial resync.
+	newPort := serveMaster(t, replica)
+	replicaDB.set("new", "1")
+	master.ReplicaOf("127.0.0.1", newPort)
+	waitFor(t, "resync from promoted replica", func() bool { _, ok := masterDB.get("new"); return ok })
+	if masterDB.fullSyncs() != 0 {
+		t.Errorf("Expected partial resync, got %d full syncs", masterDB.fullSyncs())
+	}
+}
+
+func TestWaitReplicas(t *testing.T) {
+	masterDB := newMemDB()
+	master := NewManager(masterDB, Options{})
+	masterDB.repl = master
+	defer master.Close()
+	port := serveMaster(t, master)
+
+	replicaDB := newMemDB()
+	replica := NewManager(replicaDB, Options{ReadOnly: true, AOFEnabled: true})
+	replicaDB.repl = replica
+	defer replica.Close()
+	replica.ReplicaOf("127.0.0.1", port)
+	waitFor(t, "replica attached", func() bool { return len(master.Status().Replicas) == 1 })
+
+	masterDB.set("k", "v")
+	offset := master.Offset()
+	start := time.Now()
+	if n := master.WaitReplicas(offset, 1, true, 5*time.Second); n != 1 {
+		t.Fatalf("Expected 1 replica to fsync, got %d", n)
+	}
+	// GETACK makes the replica answer without waiting for its periodic ACK.
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected a prompt acknowledgement, took %v", elapsed)
+	}
+	if n := master.WaitReplicas(master.Offset()+1000, 1, false, 50*time.Millisecond); n != 0 {
+		t.Errorf("Expected timeout with no acknowledgement, got %d", n)
+	}
+	if n := master.WaitReplicas(offset, 0, false, 0); n != 1 {
+		t.Errorf("Expected an immediate count, got %d", n)
+	}
+}
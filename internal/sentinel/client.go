@@ -0,0 +1,78 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+type client struct {
+	addr string
+	auth string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newClient(addr, auth string) *client {
+	return &client{addr: addr, auth: auth}
+}
+
+func (c *client) call(timeout time.Duration, parts ...string) (resp.Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, timeout)
+		if err != nil {
+			return resp.Value{}, err
+		}
+		c.conn, c.reader = conn, bufio.NewReader(conn)
+		if c.auth != "" {
+			v, err := c.roundTrip(timeout, "AUTH", c.auth)
+			if err == nil && v.Type == resp.Error {
+				err = errors.New(v.Text)
+			}
+			if err != nil {
+				c.drop()
+				return resp.Value{}, err
+			}
+		}
+	}
+
+	v, err := c.roundTrip(timeout, parts...)
+	if err != nil {
+		c.drop()
+	}
+	return v, err
+}
+
+func (c *client) roundTrip(timeout time.Duration, parts ...string) (resp.Value, error) {
+	items := make([]resp.Value, len(parts))
+	for i, p := range parts {
+		items[i] = resp.Value{Type: resp.BulkString, Text: p}
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write([]byte(resp.Encode(resp.Value{Type: resp.Array, Items: items}))); err != nil {
+		return resp.Value{}, err
+	}
+	return resp.Decode(c.reader)
+}
+
+func (c *client) drop() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn, c.reader = nil, nil
+	}
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop()
+}
@@ -0,0 +1,270 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func errorf(format string, args ...any) resp.Value {
+	return resp.Value{Type: resp.Error, Text: fmt.Sprintf(format, args...)}
+}
+
+func bulk(text string) resp.Value {
+	return resp.Value{Type: resp.BulkString, Text: text}
+}
+
+func (s *Sentinel) dispatch(name string, args []resp.Value) resp.Value {
+	switch name {
+	case "PING":
+		return resp.Value{Type: resp.SimpleString, Text: "PONG"}
+	case "INFO":
+		return bulk(s.info())
+	case "SENTINEL":
+		if len(args) == 0 {
+			return errorf("ERR wrong number of arguments for 'sentinel' command")
+		}
+		return s.cmdSentinel(strings.ToUpper(args[0].Text), args[1:])
+	default:
+		return errorf("ERR unknown command '%s'", name)
+	}
+}
+
+func (s *Sentinel) cmdSentinel(sub string, args []resp.Value) resp.Value {
+	switch sub {
+	case "MYID":
+		return bulk(s.runID)
+	case "MASTERS":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		items := make([]resp.Value, 0, len(s.masters))
+		for _, name := range s.masterNames() {
+			items = append(items, s.masterFields(s.masters[name]))
+		}
+		return resp.Value{Type: resp.Array, Items: items}
+	case "GET-MASTER-ADDR-BY-NAME", "MASTER", "REPLICAS", "SLAVES", "FAILOVER":
+		if len(args) != 1 {
+			return errorf("ERR wrong number of arguments for 'sentinel|%s' command", strings.ToLower(sub))
+		}
+		return s.cmdMaster(sub, args[0].Text)
+	case "SENTINELS":
+		if len(args) != 1 {
+			return errorf("ERR wrong number of arguments for 'sentinel|sentinels' command")
+		}
+		return s.cmdSentinels(args[0].Text)
+	case "IS-MASTER-DOWN-BY-ADDR":
+		if len(args) != 4 {
+			return errorf("ERR wrong number of arguments for 'sentinel|is-master-down-by-addr' command")
+		}
+		epoch, err := strconv.ParseInt(args[2].Text, 10, 64)
+		if err != nil {
+			return errorf("ERR value is not an integer or out of range")
+		}
+		return s.isMasterDown(net.JoinHostPort(args[0].Text, args[1].Text), epoch, args[3].Text)
+	case "HELLO":
+		if len(args) != 7 {
+			return errorf("ERR wrong number of arguments for 'sentinel|hello' command")
+		}
+		epoch, err1 := strconv.ParseInt(args[2].Text, 10, 64)
+		configEpoch, err2 := strconv.ParseInt(args[6].Text, 10, 64)
+		if err1 != nil || err2 != nil {
+			return errorf("ERR value is not an integer or out of range")
+		}
+		s.handleHello(args[1].Text, epoch, args[3].Text, net.JoinHostPort(args[4].Text, args[5].Text), configEpoch)
+		return bulk(s.runID)
+	default:
+		return errorf("ERR unknown sentinel subcommand '%s'", strings.ToLower(sub))
+	}
+}
+
+func (s *Sentinel) cmdMaster(sub, name string) resp.Value {
+	s.mu.Lock()
+	m, ok := s.masters[name]
+	if !ok {
+		s.mu.Unlock()
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			return resp.Value{Type: resp.Array, IsNil: true}
+		}
+		return errorf("ERR No such master with that name")
+	}
+	defer s.mu.Unlock()
+
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(m.inst.addr)
+		return resp.Value{Type: resp.Array, Items: []resp.Value{bulk(host), bulk(port)}}
+	case "MASTER":
+		return s.masterFields(m)
+	case "FAILOVER":
+		if m.failingOver {
+			return errorf("INPROG Failover already in progress")
+		}
+		if m.selectReplica() == nil {
+			return errorf("NOGOODSLAVE No suitable replica to promote")
+		}
+		go s.failover(m, 0, true)
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	default:
+		addrs := make([]string, 0, len(m.replicas))
+		for addr := range m.replicas {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		items := make([]resp.Value, 0, len(addrs))
+		for _, addr := range addrs {
+			r := m.replicas[addr]
+			fields := instanceFields(r, m.sdown(r))
+			fields = append(fields,
+				"role-reported", r.role,
+				"master-addr", r.masterAddr,
+				"master-link-status", linkStatus(r.linkUp),
+				"slave-repl-offset", strconv.FormatInt(r.offset, 10))
+			items = append(items, fieldArray(fields))
+		}
+		return resp.Value{Type: resp.Array, Items: items}
+	}
+}
+
+func (s *Sentinel) cmdSentinels(name string) resp.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.masters[name]; !ok {
+		return errorf("ERR No such master with that name")
+	}
+	items := make([]resp.Value, 0, len(s.peers))
+	for _, p := range s.activePeers() {
+		seen := int64(-1)
+		if !p.lastHello.IsZero() {
+			seen = time.Since(p.lastHello).Milliseconds()
+		}
+		items = append(items, fieldArray([]string{
+			"name", p.runID,
+			"addr", p.addr,
+			"runid", p.runID,
+			"last-hello-message", strconv.FormatInt(seen, 10),
+		}))
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func (s *Sentinel) isMasterDown(addr string, epoch int64, runID string) resp.Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m *master
+	for _, candidate := range s.masters {
+		if candidate.inst.addr == addr {
+			m = candidate
+			break
+		}
+	}
+	down := int64(0)
+	leader, leaderEpoch := "*", int64(0)
+	if m != nil {
+		if m.sdown(m.inst) {
+			down = 1
+		}
+		if runID != "*" {
+			s.voteFor(m, runID, epoch)
+			if m.leaderEpoch == epoch {
+				leader, leaderEpoch = m.leader, m.leaderEpoch
+			}
+		}
+	}
+	return resp.Value{Type: resp.Array, Items: []resp.Value{
+		{Type: resp.Integer, Number: down},
+		bulk(leader),
+		{Type: resp.Integer, Number: leaderEpoch},
+	}}
+}
+
+func (s *Sentinel) masterNames() []string {
+	names := make([]string, 0, len(s.masters))
+	for name := range s.masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Callers hold s.mu.
+func (s *Sentinel) masterFields(m *master) resp.Value {
+	fields := instanceFields(m.inst, m.sdown(m.inst))
+	flags := "master"
+	if m.sdown(m.inst) {
+		flags += ",s_down"
+	}
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failingOver {
+		flags += ",failover_in_progress"
+	}
+	fields[len(fields)-1] = flags
+	fields = append(fields,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(s.activePeers())),
+		"quorum", strconv.Itoa(m.cfg.Quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"down-after-milliseconds", strconv.FormatInt(m.cfg.DownAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.cfg.FailoverTimeout.Milliseconds(), 10),
+	)
+	return resp.Value{Type: resp.Array, Items: append([]resp.Value{bulk("name"), bulk(m.cfg.Name)}, fieldArray(fields).Items...)}
+}
+
+func instanceFields(inst *instance, sdown bool) []string {
+	host, port, _ := net.SplitHostPort(inst.addr)
+	flags := "slave"
+	if sdown {
+		flags += ",s_down"
+	}
+	return []string{
+		"ip", host,
+		"port", port,
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(inst.lastOK).Milliseconds(), 10),
+		"flags", flags,
+	}
+}
+
+func fieldArray(fields []string) resp.Value {
+	items := make([]resp.Value, len(fields))
+	for i, f := range fields {
+		items[i] = bulk(f)
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+func linkStatus(up bool) string {
+	if up {
+		return "ok"
+	}
+	return "err"
+}
+
+func (s *Sentinel) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := []string{
+		"# Sentinel",
+		"sentinel_masters:" + strconv.Itoa(len(s.masters)),
+		"sentinel_current_epoch:" + strconv.FormatInt(s.currentEpoch, 10),
+		"sentinel_run_id:" + s.runID,
+	}
+	for i, name := range s.masterNames() {
+		m := s.masters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.sdown(m.inst) {
+			status = "sdown"
+		}
+		lines = append(lines, fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			i, name, status, m.inst.addr, len(m.replicas), len(s.activePeers())+1))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
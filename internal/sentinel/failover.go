@@ -0,0 +1,180 @@
+package sentinel
+
+import (
+	"log"
+	mathrand "math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func (s *Sentinel) checkDown(m *master) {
+	s.mu.Lock()
+	sdown := m.sdown(m.inst)
+	if !sdown {
+		if m.odown {
+			log.Printf("sentinel: -odown %s %s", m.cfg.Name, m.inst.addr)
+		}
+		m.odown = false
+	}
+	host, port, _ := net.SplitHostPort(m.inst.addr)
+	epoch := strconv.FormatInt(s.currentEpoch, 10)
+	s.mu.Unlock()
+	if !sdown {
+		return
+	}
+
+	agreed := 1
+	for _, v := range s.askPeers(m.pingPeriod, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, epoch, "*") {
+		if len(v.Items) == 3 && v.Items[0].Number == 1 {
+			agreed++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	odown := agreed >= m.cfg.Quorum
+	if odown && !m.odown {
+		log.Printf("sentinel: +odown %s %s #quorum %d/%d", m.cfg.Name, m.inst.addr, agreed, m.cfg.Quorum)
+		if after := time.Now().Add(m.desync()); after.After(m.failoverAfter) {
+			m.failoverAfter = after
+		}
+	}
+	m.odown = odown
+}
+
+func (s *Sentinel) maybeFailover(m *master) {
+	s.mu.Lock()
+	if !m.odown || m.failingOver || time.Now().Before(m.failoverAfter) {
+		s.mu.Unlock()
+		return
+	}
+	s.currentEpoch++
+	epoch := s.currentEpoch
+	m.leader, m.leaderEpoch = s.runID, epoch
+	m.failoverAfter = time.Now().Add(m.cfg.FailoverTimeout + m.desync())
+	host, port, _ := net.SplitHostPort(m.inst.addr)
+	needed := max(m.cfg.Quorum, (len(s.activePeers())+1)/2+1)
+	s.mu.Unlock()
+
+	log.Printf("sentinel: +try-failover %s %s epoch %d", m.cfg.Name, m.inst.addr, epoch)
+	votes := 1
+	for _, v := range s.askPeers(m.pingPeriod, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, strconv.FormatInt(epoch, 10), s.runID) {
+		if len(v.Items) == 3 && v.Items[1].Text == s.runID && v.Items[2].Number == epoch {
+			votes++
+		}
+	}
+	if votes < needed {
+		log.Printf("sentinel: -failover-abort-not-elected %s epoch %d (%d/%d votes)", m.cfg.Name, epoch, votes, needed)
+		return
+	}
+	log.Printf("sentinel: +elected-leader %s epoch %d (%d/%d votes)", m.cfg.Name, epoch, votes, needed)
+	s.failover(m, epoch, false)
+}
+
+func (s *Sentinel) failover(m *master, epoch int64, forced bool) bool {
+	s.mu.Lock()
+	if m.failingOver {
+		s.mu.Unlock()
+		return false
+	}
+	promoted := m.selectReplica()
+	if promoted == nil {
+		m.failoverAfter = time.Now().Add(m.cfg.FailoverTimeout)
+		s.mu.Unlock()
+		log.Printf("sentinel: -failover-abort-no-good-slave %s", m.cfg.Name)
+		return false
+	}
+	m.failingOver = true
+	s.mu.Unlock()
+
+	log.Printf("sentinel: +selected-slave %s %s offset %d", m.cfg.Name, promoted.addr, promoted.offset)
+	if !s.promote(m, promoted) {
+		s.mu.Lock()
+		m.failingOver = false
+		m.failoverAfter = time.Now().Add(m.cfg.FailoverTimeout)
+		s.mu.Unlock()
+		log.Printf("sentinel: -failover-abort-slave-timeout %s %s", m.cfg.Name, promoted.addr)
+		return false
+	}
+
+	s.mu.Lock()
+	if forced {
+		s.currentEpoch++
+		epoch = s.currentEpoch
+	}
+	s.switchMaster(m, promoted.addr, epoch)
+	others := make([]*instance, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		if !m.sdown(r) {
+			// Restart the grace period so reconfigure leaves it to us.
+			r.stateSince = time.Now()
+			others = append(others, r)
+		}
+	}
+	s.mu.Unlock()
+
+	s.sendHello(m)
+	host, port, _ := net.SplitHostPort(promoted.addr)
+	for _, r := range others {
+		if v, err := r.client.call(m.pingPeriod, "REPLICAOF", host, port); err == nil && v.Type != resp.Error {
+			log.Printf("sentinel: +slave-reconf-sent %s %s", m.cfg.Name, r.addr)
+		}
+	}
+	return true
+}
+
+func (m *master) desync() time.Duration {
+	return time.Duration(mathrand.Int64N(int64(maxDesync * m.pingPeriod)))
+}
+
+// Callers hold s.mu.
+func (m *master) selectReplica() *instance {
+	var best *instance
+	for _, r := range m.replicas {
+		if r.role != "slave" || m.sdown(r) || time.Since(r.lastOK) > 2*m.pingPeriod {
+			continue
+		}
+		if best == nil || r.offset > best.offset || (r.offset == best.offset && r.addr < best.addr) {
+			best = r
+		}
+	}
+	return best
+}
+
+func (s *Sentinel) promote(m *master, r *instance) bool {
+	deadline := time.Now().Add(m.cfg.FailoverTimeout)
+	for time.Now().Before(deadline) {
+		if v, err := r.client.call(m.pingPeriod, "REPLICAOF", "NO", "ONE"); err == nil && v.Type != resp.Error {
+			info, err := r.client.call(m.pingPeriod, "INFO", "replication")
+			if err == nil {
+				if fields, _ := parseReplicationInfo(info.Text); fields["role"] == "master" {
+					return true
+				}
+			}
+		}
+		select {
+		case <-time.After(m.pingPeriod):
+		case <-s.stop:
+			return false
+		}
+	}
+	return false
+}
+
+// Callers hold s.mu.
+func (s *Sentinel) voteFor(m *master, runID string, epoch int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, epoch
+		log.Printf("sentinel: +vote-for-leader %s %s epoch %d", m.cfg.Name, runID, epoch)
+		if runID != s.runID {
+			// Give the leader time to finish before competing with it.
+			m.failoverAfter = time.Now().Add(m.cfg.FailoverTimeout)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package sentinel
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+const (
+	maxPingPeriod = time.Second
+	// reconfigureGrace is how many ping periods an instance must report a
+	// wrong role before it is reconfigured.
+	reconfigureGrace = 4
+	maxDesync        = 5
+)
+
+type MasterConfig struct {
+	Name            string
+	Addr            string
+	Quorum          int
+	DownAfter       time.Duration
+	FailoverTimeout time.Duration
+	Auth            string
+}
+
+type Config struct {
+	Addr string
+	// Peers lists every sentinel of the deployment; this one's own address
+	// may be included and is recognized by its run ID.
+	Peers   []string
+	Masters []MasterConfig
+}
+
+// Sentinel monitors masters and their replicas, and together with its peers
+// promotes a replica when a master is objectively down.
+type Sentinel struct {
+	cfg   Config
+	runID string
+
+	mu           sync.Mutex
+	currentEpoch int64
+	masters      map[string]*master
+	peers        []*peer
+
+	listener net.Listener
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type peer struct {
+	addr      string
+	client    *client
+	runID     string
+	self      bool
+	lastHello time.Time
+}
+
+type instance struct {
+	addr       string
+	client     *client
+	lastOK     time.Time
+	role       string
+	masterAddr string
+	linkUp     bool
+	offset     int64
+	// stateSince is when role or masterAddr last changed.
+	stateSince time.Time
+}
+
+type master struct {
+	cfg           MasterConfig
+	pingPeriod    time.Duration
+	inst          *instance
+	replicas      map[string]*instance
+	configEpoch   int64
+	odown         bool
+	leader        string
+	leaderEpoch   int64
+	failingOver   bool
+	failoverAfter time.Time
+}
+
+func New(cfg Config) *Sentinel {
+	id := make([]byte, 20)
+	_, _ = rand.Read(id)
+	s := &Sentinel{
+		cfg:     cfg,
+		runID:   hex.EncodeToString(id),
+		masters: make(map[string]*master),
+		stop:    make(chan struct{}),
+	}
+	for _, mc := range cfg.Masters {
+		if mc.Quorum <= 0 {
+			mc.Quorum = 1
+		}
+		if mc.DownAfter <= 0 {
+			mc.DownAfter = 30 * time.Second
+		}
+		if mc.FailoverTimeout <= 0 {
+			mc.FailoverTimeout = 3 * time.Minute
+		}
+		s.masters[mc.Name] = &master{
+			cfg:        mc,
+			pingPeriod: min(maxPingPeriod, mc.DownAfter),
+			inst:       s.newInstance(mc.Addr, mc.Auth),
+			replicas:   make(map[string]*instance),
+		}
+	}
+	return s
+}
+
+func (s *Sentinel) newInstance(addr, auth string) *instance {
+	now := time.Now()
+	return &instance{addr: addr, client: newClient(addr, auth), lastOK: now, stateSince: now}
+}
+
+func (s *Sentinel) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+func (s *Sentinel) Listen() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	return nil
+}
+
+func (s *Sentinel) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Serve starts monitoring and answers clients and peers until Close.
+func (s *Sentinel) Serve() error {
+	for _, addr := range s.cfg.Peers {
+		s.peers = append(s.peers, &peer{addr: addr, client: newClient(addr, "")})
+	}
+	for _, m := range s.masters {
+		s.wg.Add(1)
+		go s.monitor(m)
+	}
+	log.Printf("sentinel %s listening on %s", s.runID, s.Addr())
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			log.Printf("sentinel accept error: %v", err)
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+		}()
+	}
+}
+
+func (s *Sentinel) Close() {
+	close(s.stop)
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	s.mu.Lock()
+	for _, p := range s.peers {
+		p.client.close()
+	}
+	for _, m := range s.masters {
+		m.inst.client.close()
+		for _, r := range m.replicas {
+			r.client.close()
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *Sentinel) handleConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := resp.Decode(reader)
+		if err != nil {
+			return
+		}
+		if req.Type != resp.Array || len(req.Items) == 0 {
+			continue
+		}
+		reply := s.dispatch(strings.ToUpper(req.Items[0].Text), req.Items[1:])
+		if _, err := conn.Write([]byte(resp.Encode(reply))); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) monitor(m *master) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(m.pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+		s.probeAll(m)
+		s.sendHello(m)
+		s.checkDown(m)
+		s.maybeFailover(m)
+		s.reconfigure(m)
+	}
+}
+
+func (s *Sentinel) probeAll(m *master) {
+	s.mu.Lock()
+	targets := []*instance{m.inst}
+	for _, r := range m.replicas {
+		targets = append(targets, r)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, inst := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.probe(m, inst)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Sentinel) probe(m *master, inst *instance) {
+	pong, err := inst.client.call(m.pingPeriod, "PING")
+	if err != nil || pong.Type == resp.Error {
+		return
+	}
+	info, err := inst.client.call(m.pingPeriod, "INFO", "replication")
+	if err != nil || info.Type == resp.Error {
+		return
+	}
+	fields, replicas := parseReplicationInfo(info.Text)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	inst.lastOK = now
+	masterAddr := ""
+	if fields["role"] == "slave" {
+		masterAddr = net.JoinHostPort(fields["master_host"], fields["master_port"])
+	}
+	if fields["role"] != inst.role || masterAddr != inst.masterAddr {
+		inst.role, inst.masterAddr, inst.stateSince = fields["role"], masterAddr, now
+	}
+	inst.linkUp = fields["master_link_status"] == "up"
+	if off, err := strconv.ParseInt(fields["slave_repl_offset"], 10, 64); err == nil {
+		inst.offset = off
+	}
+
+	if inst == m.inst && inst.role == "master" {
+		for _, addr := range replicas {
+			if _, ok := m.replicas[addr]; !ok && addr != m.inst.addr {
+				m.replicas[addr] = s.newInstance(addr, m.cfg.Auth)
+				log.Printf("sentinel: +slave %s %s", m.cfg.Name, addr)
+			}
+		}
+	}
+}
+
+func parseReplicationInfo(text string) (map[string]string, []string) {
+	fields := make(map[string]string)
+	var replicas []string
+	for _, line := range strings.Split(text, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "slave") && strings.Contains(value, "ip=") {
+			var ip, port string
+			for _, kv := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "ip":
+					ip = v
+				case "port":
+					port = v
+				}
+			}
+			if ip != "" && port != "" {
+				replicas = append(replicas, net.JoinHostPort(ip, port))
+			}
+			continue
+		}
+		fields[key] = value
+	}
+	return fields, replicas
+}
+
+func (m *master) sdown(inst *instance) bool {
+	return time.Since(inst.lastOK) > m.cfg.DownAfter
+}
+
+func (s *Sentinel) activePeers() []*peer {
+	var out []*peer
+	for _, p := range s.peers {
+		if !p.self {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (s *Sentinel) askPeers(timeout time.Duration, parts ...string) []resp.Value {
+	s.mu.Lock()
+	peers := s.activePeers()
+	s.mu.Unlock()
+
+	var (
+		mu      sync.Mutex
+		replies []resp.Value
+		wg      sync.WaitGroup
+	)
+	for _, p := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := p.client.call(timeout, parts...)
+			if err != nil || v.Type == resp.Error {
+				return
+			}
+			mu.Lock()
+			replies = append(replies, v)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return replies
+}
+
+func (s *Sentinel) sendHello(m *master) {
+	s.mu.Lock()
+	host, port, _ := net.SplitHostPort(m.inst.addr)
+	args := []string{"SENTINEL", "HELLO", s.Addr(), s.runID, strconv.FormatInt(s.currentEpoch, 10),
+		m.cfg.Name, host, port, strconv.FormatInt(m.configEpoch, 10)}
+	peers := append([]*peer(nil), s.peers...)
+	s.mu.Unlock()
+
+	for _, p := range peers {
+		go func() {
+			v, err := p.client.call(m.pingPeriod, args...)
+			if err != nil || v.Type != resp.BulkString {
+				return
+			}
+			s.mu.Lock()
+			p.runID = v.Text
+			p.self = v.Text == s.runID
+			p.lastHello = time.Now()
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Sentinel) handleHello(runID string, epoch int64, name, addr string, configEpoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+	}
+	m, ok := s.masters[name]
+	if !ok || configEpoch <= m.configEpoch || addr == m.inst.addr {
+		if ok && configEpoch > m.configEpoch {
+			m.configEpoch = configEpoch
+		}
+		return
+	}
+	log.Printf("sentinel: +config-update-from %s %s %s -> %s (epoch %d)", runID, name, m.inst.addr, addr, configEpoch)
+	s.switchMaster(m, addr, configEpoch)
+}
+
+// Callers hold s.mu.
+func (s *Sentinel) switchMaster(m *master, addr string, configEpoch int64) {
+	old := m.inst
+	next, ok := m.replicas[addr]
+	if !ok {
+		next = s.newInstance(addr, m.cfg.Auth)
+	}
+	delete(m.replicas, addr)
+	m.replicas[old.addr] = old
+	next.lastOK = time.Now()
+	m.inst = next
+	m.configEpoch = configEpoch
+	m.odown = false
+	m.failingOver = false
+	m.failoverAfter = time.Now().Add(m.cfg.FailoverTimeout)
+	log.Printf("sentinel: +switch-master %s %s %s", m.cfg.Name, old.addr, addr)
+}
+
+func (s *Sentinel) reconfigure(m *master) {
+	s.mu.Lock()
+	if m.failingOver {
+		s.mu.Unlock()
+		return
+	}
+	current := m.inst.addr
+	host, port, _ := net.SplitHostPort(current)
+	var stale []*instance
+	grace := reconfigureGrace * m.pingPeriod
+	for _, r := range m.replicas {
+		if m.sdown(r) || time.Since(r.stateSince) < grace {
+			continue
+		}
+		if r.role == "master" || (r.role == "slave" && r.masterAddr != current) {
+			stale = append(stale, r)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, r := range stale {
+		log.Printf("sentinel: +fix-slave-config %s %s -> %s", m.cfg.Name, r.addr, current)
+		_, _ = r.client.call(m.pingPeriod, "REPLICAOF", host, port)
+	}
+}
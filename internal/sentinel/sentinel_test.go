@@ -0,0 +1,307 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+// fakeNetwork holds fake instances that answer just enough of PING, INFO
+// and REPLICAOF for a sentinel to monitor them.
+type fakeNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*fakeNode
+}
+
+type fakeNode struct {
+	net        *fakeNetwork
+	addr       string
+	role       string
+	masterAddr string
+	offset     int64
+
+	ln    net.Listener
+	conns []net.Conn
+}
+
+func newFakeNetwork() *fakeNetwork {
+	return &fakeNetwork{nodes: make(map[string]*fakeNode)}
+}
+
+func (n *fakeNetwork) start(t *testing.T, masterAddr string, offset int64) *fakeNode {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{net: n, addr: ln.Addr().String(), role: "master", offset: offset}
+	if masterAddr != "" {
+		node.role, node.masterAddr = "slave", masterAddr
+	}
+	n.mu.Lock()
+	n.nodes[node.addr] = node
+	n.mu.Unlock()
+	node.serve(ln)
+	t.Cleanup(node.kill)
+	return node
+}
+
+func (f *fakeNode) serve(ln net.Listener) {
+	f.net.mu.Lock()
+	f.ln = ln
+	f.net.mu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.net.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.net.mu.Unlock()
+			go f.handle(conn)
+		}
+	}()
+}
+
+func (f *fakeNode) kill() {
+	f.net.mu.Lock()
+	defer f.net.mu.Unlock()
+	if f.ln != nil {
+		_ = f.ln.Close()
+		f.ln = nil
+	}
+	for _, c := range f.conns {
+		_ = c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeNode) restart(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.serve(ln)
+}
+
+func (f *fakeNode) state() (string, string) {
+	f.net.mu.Lock()
+	defer f.net.mu.Unlock()
+	return f.role, f.masterAddr
+}
+
+func (f *fakeNode) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := resp.Decode(reader)
+		if err != nil {
+			return
+		}
+		var reply resp.Value
+		switch strings.ToUpper(req.Items[0].Text) {
+		case "PING":
+			reply = resp.Value{Type: resp.SimpleString, Text: "PONG"}
+		case "INFO":
+			reply = bulk(f.info())
+		case "REPLICAOF":
+			f.net.mu.Lock()
+			if strings.EqualFold(req.Items[1].Text, "NO") {
+				f.role, f.masterAddr = "master", ""
+			} else {
+				f.role, f.masterAddr = "slave", net.JoinHostPort(req.Items[1].Text, req.Items[2].Text)
+			}
+			f.net.mu.Unlock()
+			reply = resp.Value{Type: resp.SimpleString, Text: "OK"}
+		default:
+			reply = errorf("ERR unknown command")
+		}
+		if _, err := conn.Write([]byte(resp.Encode(reply))); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeNode) info() string {
+	f.net.mu.Lock()
+	defer f.net.mu.Unlock()
+	lines := []string{"# Replication", "role:" + f.role}
+	if f.role == "slave" {
+		host, port, _ := net.SplitHostPort(f.masterAddr)
+		lines = append(lines, "master_host:"+host, "master_port:"+port, "master_link_status:up",
+			fmt.Sprintf("slave_repl_offset:%d", f.offset))
+		return strings.Join(lines, "\r\n")
+	}
+	i := 0
+	for _, r := range f.net.nodes {
+		if r.role == "slave" && r.masterAddr == f.addr && r.ln != nil {
+			host, port, _ := net.SplitHostPort(r.addr)
+			lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d,lag=0", i, host, port, r.offset))
+			i++
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func startSentinels(t *testing.T, n int, mc MasterConfig) []*Sentinel {
+	t.Helper()
+	sentinels := make([]*Sentinel, n)
+	var addrs []string
+	for i := range sentinels {
+		s := New(Config{Addr: "127.0.0.1:0", Masters: []MasterConfig{mc}})
+		if err := s.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		sentinels[i] = s
+		addrs = append(addrs, s.Addr())
+	}
+	for _, s := range sentinels {
+		s.cfg.Peers = addrs
+		go s.Serve()
+		t.Cleanup(s.Close)
+	}
+	return sentinels
+}
+
+func masterAddr(t *testing.T, s *Sentinel, name string) string {
+	t.Helper()
+	c := newClient(s.Addr(), "")
+	defer c.close()
+	v, err := c.call(time.Second, "SENTINEL", "get-master-addr-by-name", name)
+	if err != nil || len(v.Items) != 2 {
+		t.Fatalf("get-master-addr-by-name: %v %+v", err, v)
+	}
+	return net.JoinHostPort(v.Items[0].Text, v.Items[1].Text)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestParseReplicationInfo(t *testing.T) {
+	fields, replicas := parseReplicationInfo("# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=127.0.0.1,port=7001,state=online,offset=10,lag=0\r\n" +
+		"slave1:ip=::1,port=7002,state=online,offset=10,lag=0\r\nmaster_repl_offset:10\r\n")
+	if fields["role"] != "master" || fields["master_repl_offset"] != "10" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+	if len(replicas) != 2 || replicas[0] != "127.0.0.1:7001" || replicas[1] != "[::1]:7002" {
+		t.Errorf("Unexpected replicas %v", replicas)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	network := newFakeNetwork()
+	primary := network.start(t, "", 100)
+	behind := network.start(t, primary.addr, 80)
+	ahead := network.start(t, primary.addr, 100)
+
+	sentinels := startSentinels(t, 3, MasterConfig{
+		Name:            "mymaster",
+		Addr:            primary.addr,
+		Quorum:          2,
+		DownAfter:       200 * time.Millisecond,
+		FailoverTimeout: time.Second,
+	})
+	waitFor(t, "replica discovery", func() bool {
+		for _, s := range sentinels {
+			s.mu.Lock()
+			n := len(s.masters["mymaster"].replicas)
+			s.mu.Unlock()
+			if n != 2 {
+				return false
+			}
+		}
+		return true
+	})
+
+	primary.kill()
+	waitFor(t, "promotion", func() bool {
+		role, _ := ahead.state()
+		return role == "master"
+	})
+	waitFor(t, "sentinels to agree on the new master", func() bool {
+		for _, s := range sentinels {
+			if masterAddr(t, s, "mymaster") != ahead.addr {
+				return false
+			}
+		}
+		return true
+	})
+	waitFor(t, "the other replica to follow", func() bool {
+		_, master := behind.state()
+		return master == ahead.addr
+	})
+
+	primary.restart(t)
+	waitFor(t, "the old master to be demoted", func() bool {
+		role, master := primary.state()
+		return role == "slave" && master == ahead.addr
+	})
+
+	epochs := map[int64]bool{}
+	for _, s := range sentinels {
+		s.mu.Lock()
+		epochs[s.masters["mymaster"].configEpoch] = true
+		s.mu.Unlock()
+	}
+	if len(epochs) != 1 {
+		t.Errorf("Sentinels disagree on the config epoch: %v", epochs)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	network := newFakeNetwork()
+	primary := network.start(t, "", 0)
+	sentinels := startSentinels(t, 1, MasterConfig{Name: "mymaster", Addr: primary.addr, DownAfter: time.Second})
+	s := sentinels[0]
+
+	if got := masterAddr(t, s, "mymaster"); got != primary.addr {
+		t.Errorf("Expected %s, got %s", primary.addr, got)
+	}
+	if v := s.dispatch("SENTINEL", []resp.Value{bulk("get-master-addr-by-name"), bulk("nope")}); !v.IsNil {
+		t.Errorf("Expected nil for an unknown master, got %+v", v)
+	}
+	if v := s.dispatch("SENTINEL", []resp.Value{bulk("myid")}); v.Text != s.runID {
+		t.Errorf("Expected run ID, got %+v", v)
+	}
+	v := s.dispatch("SENTINEL", []resp.Value{bulk("master"), bulk("mymaster")})
+	if len(v.Items) < 2 || v.Items[1].Text != "mymaster" {
+		t.Errorf("Unexpected SENTINEL MASTER reply %+v", v)
+	}
+	if v := s.dispatch("SENTINEL", []resp.Value{bulk("failover"), bulk("mymaster")}); !strings.HasPrefix(v.Text, "NOGOODSLAVE") {
+		t.Errorf("Expected NOGOODSLAVE without replicas, got %+v", v)
+	}
+
+	host, port, _ := net.SplitHostPort(primary.addr)
+	vote := func(runID string, epoch string) resp.Value {
+		return s.dispatch("SENTINEL", []resp.Value{bulk("is-master-down-by-addr"), bulk(host), bulk(port), bulk(epoch), bulk(runID)})
+	}
+	if v := vote("a", "1"); v.Items[1].Text != "a" || v.Items[2].Number != 1 {
+		t.Errorf("Expected a vote for a, got %+v", v)
+	}
+	if v := vote("b", "1"); v.Items[1].Text != "a" {
+		t.Errorf("Expected the epoch 1 vote to stay with a, got %+v", v)
+	}
+	if v := vote("b", "2"); v.Items[1].Text != "b" || v.Items[2].Number != 2 {
+		t.Errorf("Expected a vote for b in epoch 2, got %+v", v)
+	}
+	if v := s.dispatch("INFO", nil); !strings.Contains(v.Text, "sentinel_current_epoch:2") {
+		t.Errorf("Unexpected INFO %q", v.Text)
+	}
+}
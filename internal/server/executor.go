@@ -0,0 +1,50 @@
+package server
+
+type executor struct {
+	tasks chan func()
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newExecutor() *executor {
+	e := &executor{
+		tasks: make(chan func()),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *executor) run() {
+	defer close(e.done)
+	for {
+		select {
+		case fn := <-e.tasks:
+			fn()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// fn must not call do itself.
+func (e *executor) do(fn func()) bool {
+	finished := make(chan struct{})
+	task := func() {
+		defer close(finished)
+		fn()
+	}
+	select {
+	case e.tasks <- task:
+	case <-e.stop:
+		return false
+	}
+	<-finished
+	return true
+}
+
+func (e *executor) close() {
+	close(e.stop)
+	<-e.done
+}
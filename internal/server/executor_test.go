@@ -0,0 +1,156 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/william1nguyen/valkeydb/internal/command"
+	"github.com/william1nguyen/valkeydb/internal/datastructure"
+	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+)
+
+func TestExecutorRunsOneAtATime(t *testing.T) {
+	e := newExecutor()
+	defer e.close()
+
+	// An unguarded read-modify-write loses updates unless the executor
+	// never runs two functions at once.
+	n := 0
+	var order []int
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				e.do(func() { n++ })
+			}
+		}()
+	}
+	wg.Wait()
+	for i := range 5 {
+		e.do(func() { order = append(order, i) })
+	}
+	if n != 8000 {
+		t.Errorf("Expected 8000, got %d", n)
+	}
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("Expected submission order, got %v", order)
+		}
+	}
+}
+
+func TestExecutorClose(t *testing.T) {
+	e := newExecutor()
+	e.close()
+	if e.do(func() { t.Error("Closed executor ran a function") }) {
+		t.Error("Expected do to report false after close")
+	}
+}
+
+// newTestServer builds the command layer over fresh stores, single-threaded
+// when single is set.
+func newTestServer(single bool) *Server {
+	s := &Server{}
+	if single {
+		s.exec = newExecutor()
+		datastructure.SetExecutor(func(fn func()) { s.exec.do(fn) })
+		defer datastructure.SetExecutor(nil)
+	}
+	s.db = &command.DB{
+		Dict:   datastructure.CreateDict(),
+		Set:    datastructure.CreateSet(),
+		List:   datastructure.CreateList(),
+		Hash:   datastructure.CreateHashMap(),
+		ZSet:   datastructure.CreateZSet(),
+		JSON:   datastructure.CreateJSONStore(),
+		TS:     datastructure.CreateTimeSeries(),
+		Sketch: datastructure.CreateSketches(),
+		Pubsub: datastructure.CreatePubsub(),
+		Access: datastructure.CreateAccessTable(),
+	}
+	command.Init(s.db)
+	return s
+}
+
+func request(args ...string) resp.Value {
+	items := make([]resp.Value, len(args))
+	for i, a := range args {
+		items[i] = resp.Value{Type: resp.BulkString, Text: a}
+	}
+	return resp.Value{Type: resp.Array, Items: items}
+}
+
+// TestSingleThreadedAtomicity runs a handler that reads and rewrites two
+// keys with separate store calls, like a scripted workflow. Concurrently
+// two of them may interleave and lose an update; single-threaded they
+// cannot.
+func TestSingleThreadedAtomicity(t *testing.T) {
+	s := newTestServer(true)
+	defer s.exec.close()
+	dict := s.db.Dict
+	command.Register("TEST.BUMP", func(args []resp.Value) resp.Value {
+		a, _ := dict.Get("a")
+		b, _ := dict.Get("b")
+		na, _ := strconv.Atoi(a)
+		nb, _ := strconv.Atoi(b)
+		dict.Set("a", strconv.Itoa(na+1), 0)
+		dict.Set("b", strconv.Itoa(nb+1), 0)
+		return resp.Value{Type: resp.SimpleString, Text: "OK"}
+	})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 250 {
+				s.execute(request("TEST.BUMP"), false)
+			}
+		}()
+	}
+	wg.Wait()
+	a := s.execute(request("GET", "a"), false)
+	b := s.execute(request("GET", "b"), false)
+	if a.Text != "2000" || b.Text != "2000" {
+		t.Errorf("Expected both keys at 2000, got %q %q", a.Text, b.Text)
+	}
+}
+
+func benchmarkExecution(b *testing.B, reqs ...resp.Value) {
+	for _, mode := range []string{"concurrent", "single"} {
+		b.Run(mode, func(b *testing.B) {
+			s := newTestServer(mode == "single")
+			if s.exec != nil {
+				defer s.exec.close()
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					s.execute(reqs[i%len(reqs)], false)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkExecutionSet(b *testing.B) {
+	var reqs []resp.Value
+	for i := range 1024 {
+		reqs = append(reqs, request("SET", "key:"+strconv.Itoa(i), "value"))
+	}
+	benchmarkExecution(b, reqs...)
+}
+
+func BenchmarkExecutionMixed(b *testing.B) {
+	var reqs []resp.Value
+	for i := range 1024 {
+		key := "key:" + strconv.Itoa(i)
+		reqs = append(reqs, request("GET", key), request("INCR", key+":n"), request("GET", key), request("SET", key, "value"))
+	}
+	benchmarkExecution(b, reqs...)
+}
@@ -3,17 +3,25 @@ package server
 import (
 	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/william1nguyen/valkeydb/internal/acl"
+	"github.com/william1nguyen/valkeydb/internal/backup"
+	"github.com/william1nguyen/valkeydb/internal/cluster"
 	"github.com/william1nguyen/valkeydb/internal/command"
 	"github.com/william1nguyen/valkeydb/internal/config"
+	"github.com/william1nguyen/valkeydb/internal/crdt"
 	"github.com/william1nguyen/valkeydb/internal/datastructure"
 	"github.com/william1nguyen/valkeydb/internal/persistence"
 	"github.com/william1nguyen/valkeydb/internal/protocol/resp"
+	"github.com/william1nguyen/valkeydb/internal/raft"
+	"github.com/william1nguyen/valkeydb/internal/replication"
 )
 
 type Server struct {
@@ -23,11 +31,25 @@ type Server struct {
 	set      *datastructure.Set
 	list     *datastructure.List
 	hash     *datastructure.HashMap
+	zset     *datastructure.ZSet
+	json     *datastructure.JSONStore
+	ts       *datastructure.TimeSeries
+	sketch   *datastructure.Sketches
 	pubsub   *datastructure.Pubsub
 	aof      *persistence.AOF
 	rdb      *persistence.RDB
-	stopCh   chan struct{}
-	wg       sync.WaitGroup
+	db       *command.DB
+	repl     *replication.Manager
+	cluster  *cluster.Cluster
+	raft     *raft.Raft
+	crdt     *crdt.Node
+	backups  *backup.Manager
+	acl      *acl.Users
+	// exec runs every command in single-threaded execution and is nil
+	// otherwise.
+	exec   *executor
+	stopCh chan struct{}
+	wg     sync.WaitGroup
 }
 
 func New(addr string) *Server {
@@ -46,103 +68,270 @@ func (s *Server) ListenAndServe() error {
 }
 
 func (s *Server) initialize() error {
+	raftMode := config.Global.Raft.Enabled
+	if raftMode && config.Global.Cluster.Enabled {
+		return errors.New("raft and cluster mode cannot be enabled together")
+	}
+	if raftMode && config.Global.Replication.ReplicaOf != "" {
+		return errors.New("raft mode replaces replicaof; remove it from the config")
+	}
+	aaMode := config.Global.ActiveActive.Enabled
+	if aaMode && (raftMode || config.Global.Cluster.Enabled) {
+		return errors.New("active-active mode cannot be combined with raft or cluster mode")
+	}
+	if aaMode && config.Global.Replication.ReplicaOf != "" {
+		return errors.New("active-active mode replaces replicaof; remove it from the config")
+	}
+	if _, err := config.ParseMemory(config.Global.Memory.MaxMemory); err != nil {
+		return err
+	}
+	if _, err := config.ParseSaveRules(config.Global.Persistence.RDB.Save); err != nil {
+		return err
+	}
+	if policy := config.Global.GetMaxMemoryPolicy(); !command.ValidEvictionPolicy(policy) {
+		return fmt.Errorf("unknown maxmemory_policy %q", policy)
+	}
+	single := false
+	switch execution := config.Global.GetExecution(); execution {
+	case "concurrent":
+	case "single":
+		single = true
+	default:
+		return fmt.Errorf("unknown execution %q", execution)
+	}
+	// Raft and active-active apply writes from their own goroutines while
+	// a command waits on them, which a single executor would deadlock on.
+	if single && (raftMode || aaMode) {
+		return errors.New("single-threaded execution cannot be combined with raft or active-active mode")
+	}
+
 	listener, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		return err
 	}
 	s.listener = listener
 	s.stopCh = make(chan struct{})
+	if single {
+		s.exec = newExecutor()
+		datastructure.SetExecutor(func(fn func()) { s.exec.do(fn) })
+	}
 
 	s.dict = datastructure.CreateDict()
 	s.set = datastructure.CreateSet()
 	s.list = datastructure.CreateList()
 	s.hash = datastructure.CreateHashMap()
+	s.zset = datastructure.CreateZSet()
+	s.json = datastructure.CreateJSONStore()
+	s.ts = datastructure.CreateTimeSeries()
+	s.sketch = datastructure.CreateSketches()
 	s.pubsub = datastructure.CreatePubsub()
 
 	aofFile := config.Global.Persistence.AOF.Filename
 	rdbFile := config.Global.Persistence.RDB.Filename
 
-	if s.aof, err = persistence.OpenAOF(aofFile, config.Global.Persistence.AOF.Enabled); err != nil {
+	// In raft mode the raft log and its snapshots are the only persistence,
+	// in active-active mode the CRDT delta log.
+	ownLog := raftMode || aaMode
+	if s.aof, err = persistence.OpenMultiPartAOF(config.Global.GetAOFDir(), aofFile, config.Global.GetAOFRDBPreamble(), config.Global.Persistence.AOF.Enabled && !ownLog); err != nil {
 		return err
 	}
-	if s.rdb, err = persistence.OpenRDB(rdbFile, config.Global.Persistence.RDB.Enabled); err != nil {
+	s.aof.SetLoadTruncated(config.Global.GetAOFLoadTruncated())
+	if s.rdb, err = persistence.OpenRDB(rdbFile, config.Global.Persistence.RDB.Enabled && !ownLog); err != nil {
 		return err
 	}
 
-	command.Init(&command.DB{
+	s.db = &command.DB{
 		Dict:   s.dict,
 		Set:    s.set,
 		List:   s.list,
 		Hash:   s.hash,
+		ZSet:   s.zset,
+		JSON:   s.json,
+		TS:     s.ts,
+		Sketch: s.sketch,
 		Pubsub: s.pubsub,
+		Access: datastructure.CreateAccessTable(),
 		AOF:    s.aof,
 		RDB:    s.rdb,
+	}
+	s.acl = acl.New(config.Global.GetAuth(), command.KnownCommand)
+	s.db.ACL = s.acl
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	s.repl = replication.NewManager(s.dataset(), replication.Options{
+		BacklogSize: config.Global.Replication.BacklogSize,
+		ReadOnly:    config.Global.GetReplicaReadOnly(),
+		AOFEnabled:  config.Global.Persistence.AOF.Enabled,
+		MasterAuth:  config.Global.Replication.MasterAuth,
+		ListenPort:  port,
+		Timeout:     config.Global.GetReplicationTimeout(),
+		OnFullSync:  s.rewriteAOF,
 	})
-
-	s.loadRDB()
-	s.loadAOF()
-
-	return nil
-}
-
-func (s *Server) loadRDB() {
-	rdbFile := config.Global.Persistence.RDB.Filename
-	snapshot, err := s.rdb.Load(rdbFile)
-	if err != nil {
-		log.Printf("RDB load error: %v", err)
-		return
+	s.db.Repl = s.repl
+	if config.Global.Cluster.Enabled {
+		s.cluster, err = cluster.New(cluster.Options{
+			Addr:        listener.Addr().String(),
+			BusPort:     config.Global.Cluster.BusPort,
+			ConfigFile:  config.Global.Cluster.ConfigFile,
+			NodeTimeout: config.Global.GetClusterNodeTimeout(),
+		})
+		if err != nil {
+			return err
+		}
+		s.db.Cluster = s.cluster
 	}
-	if snapshot == nil {
-		return
+	if raftMode {
+		if s.raft, err = s.newRaft(listener.Addr().String()); err != nil {
+			return err
+		}
+		s.db.Raft = s.raft
 	}
-
-	for key, item := range snapshot.DictData {
-		s.dict.Set(key, item.Value, 0)
-		if !item.ExpiredAt.IsZero() {
-			s.dict.ExpireAt(key, item.ExpiredAt)
+	if aaMode {
+		aa := config.Global.ActiveActive
+		s.crdt, err = crdt.New(crdt.Config{
+			ID:          aa.ID,
+			Peers:       aa.Peers,
+			Auth:        config.Global.GetActiveActiveAuth(),
+			Dir:         aa.Dir,
+			BacklogSize: aa.BacklogSize,
+			Timeout:     config.Global.GetActiveActiveTimeout(),
+		}, command.NewCRDTView(s.db))
+		if err != nil {
+			return err
+		}
+		s.db.CRDT = s.crdt
+	}
+	if config.Global.Persistence.Backup.Enabled && !ownLog {
+		b := config.Global.Persistence.Backup
+		s.backups, err = backup.New(backup.Config{
+			Dir:    config.Global.GetBackupDir(),
+			Keep:   b.Keep,
+			MaxAge: time.Duration(b.MaxAge) * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+		s.db.Backups = s.backups
+	}
+	command.Init(s.db)
+	if file := config.Global.GetACLFile(); file != "" {
+		if err := s.acl.Load(file); err != nil {
+			return fmt.Errorf("aclfile: %w", err)
 		}
 	}
 
-	for key, item := range snapshot.SetData {
-		if len(item.Members) > 0 {
-			members := make([]string, 0, len(item.Members))
-			for m := range item.Members {
-				members = append(members, m)
+	if !ownLog {
+		// As in Redis, the AOF holds the whole dataset once it exists, and
+		// the RDB file is only read without one.
+		s.serially(func() {
+			if !s.aof.Enabled() || s.aof.Created() {
+				err = s.loadRDB()
 			}
-			s.set.Sadd(key, members...)
-			if !item.ExpiredAt.IsZero() {
-				s.set.ExpireAt(key, item.ExpiredAt)
+			if err == nil {
+				err = s.loadAOF()
 			}
+		})
+		if err != nil {
+			return err
+		}
+		if s.aof.Enabled() && s.aof.Created() {
+			s.rewriteAOF()
 		}
 	}
+	if s.backups != nil {
+		s.aof.SetFeed(func(v resp.Value) {
+			s.repl.Feed(v)
+			s.backups.Append(v)
+		})
+		// Each start begins a new backup from the dataset as loaded.
+		s.runBackup()
+	} else {
+		s.aof.SetFeed(s.repl.Feed)
+	}
 
-	for key, items := range snapshot.ListData {
-		if len(items) > 0 {
-			values := make([]string, 0, len(items))
-			for _, item := range items {
-				values = append(values, item.Value)
-			}
-			s.list.Rpush(key, values...)
-		}
+	if master := strings.Fields(config.Global.Replication.ReplicaOf); len(master) == 2 {
+		s.repl.ReplicaOf(master[0], master[1])
 	}
 
-	for key, hash := range snapshot.HashData {
-		for field, value := range hash {
-			s.hash.Hset(key, field, value)
+	if s.cluster != nil {
+		if err := s.cluster.Start(); err != nil {
+			return err
 		}
+		log.Printf("cluster node %s", s.cluster.MyID())
 	}
+	if s.raft != nil {
+		if err := s.raft.Start(); err != nil {
+			return err
+		}
+		log.Printf("raft node %s serving on %s", config.Global.Raft.ID, s.raft.Addr())
+	}
+	if s.crdt != nil {
+		s.crdt.Start()
+		log.Printf("active-active node %s with %d peers", s.crdt.ID(), len(config.Global.ActiveActive.Peers))
+	}
+	return nil
+}
 
-	log.Printf("RDB loaded: %d dict keys, %d set keys, %d list keys, %d hash keys", len(snapshot.DictData), len(snapshot.SetData), len(snapshot.ListData), len(snapshot.HashData))
+func (s *Server) newRaft(clientAddr string) (*raft.Raft, error) {
+	rc := config.Global.Raft
+	if rc.ClientAddr != "" {
+		clientAddr = rc.ClientAddr
+	}
+	peers := make([]raft.Member, 0, len(rc.Peers))
+	for _, p := range rc.Peers {
+		peers = append(peers, raft.Member{ID: p.ID, Addr: p.Addr, ClientAddr: p.ClientAddr})
+	}
+	return raft.New(raft.Config{
+		ID:              rc.ID,
+		Addr:            rc.Addr,
+		ClientAddr:      clientAddr,
+		Peers:           peers,
+		Dir:             rc.Dir,
+		ElectionTimeout: config.Global.GetRaftElectionTimeout(),
+		SnapshotEntries: rc.SnapshotEntries,
+	}, s.db)
 }
 
-func (s *Server) loadAOF() {
-	aofFile := config.Global.Persistence.AOF.Filename
-	s.aof.Load(aofFile, func(cmd string, args []resp.Value) {
+func (s *Server) loadRDB() error {
+	rdbFile := config.Global.Persistence.RDB.Filename
+	snapshot, err := s.rdb.Load(rdbFile)
+	if err != nil {
+		return fmt.Errorf("RDB load: %w (move the file away to start without it)", err)
+	}
+	if snapshot == nil {
+		return nil
+	}
+
+	s.db.Restore(snapshot)
+
+	log.Printf("RDB loaded: %d dict keys, %d set keys, %d list keys, %d hash keys, %d zset keys, %d json keys, %d timeseries keys, %d sketch keys", len(snapshot.DictData), len(snapshot.SetData), len(snapshot.ListData), len(snapshot.HashData), len(snapshot.ZSetData), len(snapshot.JSONData), len(snapshot.TSData), len(snapshot.SketchData))
+	return nil
+}
+
+func (s *Server) loadAOF() error {
+	err := s.aof.LoadAll(s.db.Restore, func(cmd string, args []resp.Value) {
 		command.Replay(cmd, args)
 	})
+	if err != nil {
+		return fmt.Errorf("AOF load: %w", err)
+	}
+	return nil
 }
 
 func (s *Server) startBackgroundTasks() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(config.Global.GetExpirationCheckInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.serially(func() { command.NotePeakMemory() })
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -157,17 +346,66 @@ func (s *Server) startBackgroundTasks() {
 			}
 		}
 	}()
+	if s.backups != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ticker := time.NewTicker(config.Global.GetBackupInterval())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.runBackup()
+				case <-s.stopCh:
+					return
+				}
+			}
+		}()
+	}
+	rules := config.Global.GetSaveRules()
+	if len(rules) > 0 && config.Global.Persistence.RDB.Enabled && s.raft == nil && s.crdt == nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.serially(func() { command.SaveIfDue(rules, time.Now()) })
+				case <-s.stopCh:
+					return
+				}
+			}
+		}()
+	}
 }
 
 func (s *Server) rewriteAOF() {
-	aofFile := config.Global.Persistence.AOF.Filename
-	if err := s.aof.RewriteWithLists(s.dict.Dump(), s.set.Dump(), s.list.Dump(), s.hash.Dump(), aofFile); err != nil {
+	if !s.aof.Enabled() {
+		return
+	}
+	err := command.RewriteAOF(func(fn func()) {
+		s.serially(func() { s.db.PauseWrites(fn) })
+	})
+	if err != nil {
 		log.Printf("aof rewrite error: %v", err)
 	} else {
 		log.Printf("aof rewrite done")
 	}
 }
 
+func (s *Server) runBackup() {
+	err := command.RunBackup(func(fn func()) {
+		s.serially(func() { s.db.PauseWrites(fn) })
+	})
+	if err != nil {
+		log.Printf("backup error: %v", err)
+	} else {
+		log.Printf("backup done")
+	}
+}
+
 func (s *Server) acceptLoop() error {
 	for {
 		conn, err := s.listener.Accept()
@@ -198,6 +436,18 @@ func (s *Server) Close(ctx context.Context) error {
 	if s.stopCh != nil {
 		close(s.stopCh)
 	}
+	if s.repl != nil {
+		s.repl.Close()
+	}
+	if s.cluster != nil {
+		s.cluster.Close()
+	}
+	if s.raft != nil {
+		s.raft.Close()
+	}
+	if s.crdt != nil {
+		s.crdt.Close()
+	}
 	done := make(chan struct{})
 	go func() {
 		s.wg.Wait()
@@ -208,19 +458,21 @@ func (s *Server) Close(ctx context.Context) error {
 	case <-ctx.Done():
 	}
 
-	if s.rdb != nil && config.Global.Persistence.RDB.Enabled {
-		snapshot := persistence.Snapshot{
-			DictData: s.dict.Dump(),
-			SetData:  s.set.Dump(),
-			ListData: s.list.Dump(),
-			HashData: s.hash.Dump(),
-		}
+	if s.rdb != nil && config.Global.Persistence.RDB.Enabled && s.raft == nil && s.crdt == nil {
+		var snapshot persistence.Snapshot
+		s.serially(func() { snapshot = s.db.Snapshot() })
 		_ = s.rdb.Save(snapshot, config.Global.Persistence.RDB.Filename)
 	}
+	if s.exec != nil {
+		s.exec.close()
+	}
 
 	if s.aof != nil {
 		_ = s.aof.Close()
 	}
+	if s.backups != nil {
+		_ = s.backups.Close()
+	}
 	if s.rdb != nil {
 		_ = s.rdb.Close()
 	}
@@ -232,7 +484,17 @@ func (s *Server) handleConn(conn net.Conn) {
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
-	authed := config.Global.GetAuth() == ""
+	client := conn.RemoteAddr().String()
+	// user is who the connection is logged in as, "" before AUTH.
+	user := ""
+	if s.acl.NoPass(acl.DefaultUser) {
+		user = acl.DefaultUser
+	}
+	replicaPort := ""
+	var lastWrite command.WriteOffsets
+	// asking is set by ASKING and lets only the next command run on a slot
+	// this node is importing.
+	asking := false
 
 	for {
 		_ = conn.SetReadDeadline(time.Now().Add(config.Global.GetReadTimeout()))
@@ -246,27 +508,64 @@ func (s *Server) handleConn(conn net.Conn) {
 			cmd = strings.ToUpper(req.Items[0].Text)
 		}
 
-		if !authed {
-			switch cmd {
-			case "AUTH":
-				respVal := s.dispatchCommand(req)
-				if respVal.Type == resp.SimpleString && respVal.Text == "OK" {
-					authed = true
-				}
+		// A deleted user's connections have to log in again.
+		if user != "" && !s.acl.Exists(user) {
+			user = ""
+		}
+		if cmd == "AUTH" {
+			name, v := command.Auth(req.Items[1:], client)
+			if name != "" {
+				user = name
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(config.Global.GetWriteTimeout()))
+			if err := s.writeResponse(writer, conn, v); err != nil {
+				return
+			}
+			continue
+		}
+		if user == "" && cmd != "PING" && cmd != "QUIT" {
+			v := resp.Value{Type: resp.Error, Text: "NOAUTH Authentication required."}
+			_ = conn.SetWriteDeadline(time.Now().Add(config.Global.GetWriteTimeout()))
+			if err := s.writeResponse(writer, conn, v); err != nil {
+				return
+			}
+			continue
+		}
+		if user != "" && cmd != "" {
+			if v := command.CheckACL(user, client, cmd, req.Items[1:]); v != nil {
 				_ = conn.SetWriteDeadline(time.Now().Add(config.Global.GetWriteTimeout()))
-				if err := s.writeResponse(writer, conn, respVal); err != nil {
+				if err := s.writeResponse(writer, conn, *v); err != nil {
 					return
 				}
 				continue
-			case "PING", "QUIT":
-			default:
-				v := resp.Value{Type: resp.Error, Text: "NOAUTH Authentication required."}
-				_ = conn.SetWriteDeadline(time.Now().Add(config.Global.GetWriteTimeout()))
+			}
+		}
+
+		if cmd == "PSYNC" || cmd == "SYNC" {
+			args := make([]string, 0, len(req.Items)-1)
+			for _, a := range req.Items[1:] {
+				args = append(args, a.Text)
+			}
+			s.repl.ServeReplica(conn, reader, args, replicaPort)
+			return
+		}
+		if cmd == "CRDT.SYNC" {
+			if s.crdt == nil {
+				v := resp.Value{Type: resp.Error, Text: "ERR This instance has active-active replication disabled"}
 				if err := s.writeResponse(writer, conn, v); err != nil {
 					return
 				}
 				continue
 			}
+			args := make([]string, 0, len(req.Items)-1)
+			for _, a := range req.Items[1:] {
+				args = append(args, a.Text)
+			}
+			s.crdt.ServePeer(conn, reader, args)
+			return
+		}
+		if cmd == "REPLCONF" && len(req.Items) == 3 && strings.EqualFold(req.Items[1].Text, "listening-port") {
+			replicaPort = req.Items[2].Text
 		}
 
 		if req.Type == resp.Array && len(req.Items) > 0 {
@@ -274,7 +573,25 @@ func (s *Server) handleConn(conn net.Conn) {
 			args := req.Items[1:]
 			command.MonitorPublish(cmdUp, args)
 		}
-		respVal := s.dispatchCommand(req)
+		var respVal resp.Value
+		switch cmd {
+		case "WAIT":
+			respVal = command.Wait(lastWrite, req.Items[1:])
+		case "WAITAOF":
+			respVal = command.WaitAOF(lastWrite, req.Items[1:])
+		case "ACL":
+			if len(req.Items) == 2 && strings.EqualFold(req.Items[1].Text, "WHOAMI") {
+				respVal = resp.Value{Type: resp.BulkString, Text: user}
+			} else {
+				respVal = s.execute(req, asking)
+			}
+		default:
+			respVal = s.execute(req, asking)
+			if command.IsWrite(cmd) {
+				lastWrite = command.CurrentOffsets()
+			}
+		}
+		asking = cmd == "ASKING" && respVal.Type != resp.Error
 		command.IncCommands()
 		_ = conn.SetWriteDeadline(time.Now().Add(config.Global.GetWriteTimeout()))
 		if err := s.writeResponse(writer, conn, respVal); err != nil {
@@ -326,7 +643,23 @@ func (s *Server) readRequest(r *bufio.Reader, conn net.Conn) (resp.Value, error)
 	return req, nil
 }
 
-func (s *Server) dispatchCommand(req resp.Value) resp.Value {
+func (s *Server) serially(fn func()) bool {
+	if s.exec == nil {
+		fn()
+		return true
+	}
+	return s.exec.do(fn)
+}
+
+func (s *Server) execute(req resp.Value, asking bool) resp.Value {
+	var result resp.Value
+	if !s.serially(func() { result = s.dispatchCommand(req, asking) }) {
+		return resp.Value{Type: resp.Error, Text: "ERR server is shutting down"}
+	}
+	return result
+}
+
+func (s *Server) dispatchCommand(req resp.Value, asking bool) resp.Value {
 	if req.Type != resp.Array || len(req.Items) == 0 {
 		return resp.Value{Type: resp.Error, Text: "ERR protocol error"}
 	}
@@ -338,7 +671,83 @@ func (s *Server) dispatchCommand(req resp.Value) resp.Value {
 	}
 
 	args := req.Items[1:]
-	return handler(args)
+	keys := command.CommandKeys(cmd, args)
+	if s.cluster != nil {
+		// RESTORE-ASKING carries its own ASKING flag.
+		asking = asking || cmd == "RESTORE-ASKING"
+		if err := s.cluster.Route(keys, asking, s.db.Exists); err != nil {
+			return resp.Value{Type: resp.Error, Text: err.Error()}
+		}
+	}
+	if err := s.db.CheckMemory(cmd); err != nil {
+		return resp.Value{Type: resp.Error, Text: err.Error()}
+	}
+	if command.TouchesKeys(cmd) {
+		// Touched after the command runs, so only keys that exist by then
+		// are recorded.
+		defer s.db.Touch(keys...)
+	}
+	if s.raft != nil {
+		return s.dispatchRaft(cmd, req, handler)
+	}
+	if !command.IsWrite(cmd) {
+		return handler(args)
+	}
+	if s.crdt != nil {
+		aaHandler, ok := command.ActiveActiveHandler(cmd)
+		if !ok {
+			return resp.Value{Type: resp.Error, Text: "ERR " + cmd + " is not supported in active-active mode"}
+		}
+		handler = aaHandler
+	}
+	if command.ReadOnlyReplica() {
+		return resp.Value{Type: resp.Error, Text: "READONLY You can't write against a read only replica."}
+	}
+	var result resp.Value
+	s.db.RunWrite(func() {
+		result = handler(args)
+	})
+	return result
+}
+
+func (s *Server) dispatchRaft(cmd string, req resp.Value, handler command.Handler) resp.Value {
+	switch {
+	case cmd == "REPLICAOF" || cmd == "SLAVEOF":
+		return resp.Value{Type: resp.Error, Text: "ERR REPLICAOF not allowed in raft mode"}
+	case cmd == "MIGRATE":
+		return resp.Value{Type: resp.Error, Text: "ERR MIGRATE not allowed in raft mode"}
+	case command.IsWrite(cmd):
+		result, err := s.raft.Propose(command.RaftRequest(cmd, req))
+		if err != nil {
+			return resp.Value{Type: resp.Error, Text: err.Error()}
+		}
+		return result
+	case config.Global.Raft.ReadIndex && len(command.CommandKeys(cmd, req.Items[1:])) > 0:
+		if err := s.raft.ReadIndex(); err != nil {
+			return resp.Value{Type: resp.Error, Text: err.Error()}
+		}
+	}
+	return handler(req.Items[1:])
+}
+
+type serialDataset struct {
+	*command.DB
+	exec *executor
+}
+
+func (d serialDataset) RunWrite(fn func()) {
+	d.exec.do(func() { d.DB.RunWrite(fn) })
+}
+
+func (d serialDataset) PauseWrites(fn func()) {
+	d.exec.do(fn)
+}
+
+func (s *Server) dataset() replication.Dataset {
+	if s.exec == nil {
+		return s.db
+	}
+	return serialDataset{DB: s.db, exec: s.exec}
 }
 
 func (s *Server) writeResponse(w *bufio.Writer, conn net.Conn, v resp.Value) error {